@@ -0,0 +1,204 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/json"
+	"github.com/apex/log/handlers/logfmt"
+)
+
+// AccessLogFormat is the format of the access log.
+type AccessLogFormat string
+
+const (
+	// AccessLogFormatDefault logs requests with the global log handler.
+	AccessLogFormatDefault AccessLogFormat = ""
+	// AccessLogFormatLogfmt writes requests in logfmt to stderr.
+	AccessLogFormatLogfmt AccessLogFormat = "logfmt"
+	// AccessLogFormatJSON writes requests as JSON lines to stderr.
+	AccessLogFormatJSON AccessLogFormat = "json"
+)
+
+type AccessLogConfig struct {
+	// Format of the access log, defaults to the format of the global log.
+	Format AccessLogFormat `yaml:"format"`
+}
+
+func (c AccessLogConfig) Valid() error {
+	switch c.Format {
+	case AccessLogFormatDefault, AccessLogFormatLogfmt, AccessLogFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid format: %q", c.Format)
+	}
+}
+
+func (c AccessLogConfig) logger() log.Interface {
+	switch c.Format {
+	case AccessLogFormatLogfmt:
+		return &log.Logger{Handler: logfmt.New(os.Stderr), Level: log.InfoLevel}
+	case AccessLogFormatJSON:
+		return &log.Logger{Handler: json.New(os.Stderr), Level: log.InfoLevel}
+	default:
+		return log.Log
+	}
+}
+
+// accessLogEntry collects fields of a request that are only known to inner handlers.
+type accessLogEntry struct {
+	mu         sync.Mutex
+	authCtx    *AuthCtx
+	gitTimings map[string]time.Duration
+}
+
+type accessLogEntryCtxKey struct{}
+
+func accessLogEntryFromCtx(ctx context.Context) *accessLogEntry {
+	e, _ := ctx.Value(accessLogEntryCtxKey{}).(*accessLogEntry)
+	return e
+}
+
+// setAuthCtx sets the authenticated identity of the request.
+func (e *accessLogEntry) setAuthCtx(authCtx AuthCtx) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.authCtx = &authCtx
+}
+
+// recordGitTiming adds the duration of a Git operation (e.g. "clone" or "push").
+func (e *accessLogEntry) recordGitTiming(op string, d time.Duration) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gitTimings == nil {
+		e.gitTimings = make(map[string]time.Duration)
+	}
+	e.gitTimings[op] += d
+}
+
+func (e *accessLogEntry) fields() log.Fields {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fields := log.Fields{}
+	if e.authCtx != nil && e.authCtx.Provider != "" {
+		fields["authProvider"] = e.authCtx.Provider
+	}
+	if e.authCtx != nil && e.authCtx.GitLabClaims != nil {
+		fields["projectPath"] = e.authCtx.GitLabClaims.ProjectPath
+		fields["subject"] = e.authCtx.GitLabClaims.Subject
+	}
+	if e.authCtx != nil && e.authCtx.ClientCertificate != nil {
+		fields["subject"] = e.authCtx.ClientCertificate.Subject
+	}
+	if e.authCtx != nil && e.authCtx.BasicAuth != nil {
+		fields["subject"] = e.authCtx.BasicAuth.Username
+	}
+	for op, d := range e.gitTimings {
+		fields["git"+strings.ToUpper(op[:1])+op[1:]+"Duration"] = ms(d)
+	}
+	return fields
+}
+
+// accessLogger logs requests with the identity of the caller, request and response sizes and timings of Git operations.
+func accessLogger(config AccessLogConfig) func(http.Handler) http.Handler {
+	logger := config.logger()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/healthz") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			entry := &accessLogEntry{}
+			body := &countingReadCloser{ReadCloser: r.Body}
+			r.Body = body
+			res := &accessLogResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+			ctx := logger.WithFields(log.Fields{
+				"url":        r.RequestURI,
+				"method":     r.Method,
+				"remoteAddr": r.RemoteAddr,
+			})
+			ctx.Info("request")
+
+			next.ServeHTTP(res, r.WithContext(context.WithValue(r.Context(), accessLogEntryCtxKey{}, entry)))
+
+			ctx = ctx.
+				WithFields(entry.fields()).
+				WithFields(log.Fields{
+					"status":      res.status,
+					"size":        res.size,
+					"requestSize": body.size,
+					"duration":    ms(time.Since(start)),
+				})
+			switch {
+			case res.status >= 500:
+				ctx.Error("response")
+			case res.status >= 400:
+				ctx.Warn("response")
+			default:
+				ctx.Info("response")
+			}
+		})
+	}
+}
+
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	size int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// ms returns the duration in milliseconds.
+func ms(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
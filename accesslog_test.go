@@ -0,0 +1,62 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_AccessLog(t *testing.T) {
+	logger := log.Log.(*log.Logger)
+	prevHandler := logger.Handler
+	memHandler := memory.New()
+	logger.Handler = memHandler
+	defer func() {
+		logger.Handler = prevHandler
+	}()
+
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "project_path:my-group/my-project:ref_type:branch:ref:main"},
+			ProjectPath:      "my-group/my-project",
+		},
+	}}, newDefaultAuthorizer(t), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	payload := `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(payload))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var response *log.Entry
+	for _, entry := range memHandler.Entries {
+		if entry.Message == "response" {
+			response = entry
+		}
+	}
+	require.NotNil(t, response, "response should be logged")
+	require.Equal(t, "my-group/my-project", response.Fields.Get("projectPath"))
+	require.Equal(t, "project_path:my-group/my-project:ref_type:branch:ref:main", response.Fields.Get("subject"))
+	require.Equal(t, http.StatusOK, response.Fields.Get("status"))
+	require.Equal(t, rec.Body.Len(), response.Fields.Get("size"))
+	require.Equal(t, int64(len(payload)), response.Fields.Get("requestSize"))
+	require.Contains(t, response.Fields.Names(), "gitCloneDuration")
+	require.Contains(t, response.Fields.Names(), "gitPushDuration")
+}
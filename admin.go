@@ -0,0 +1,106 @@
+package vignet
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminConfig enables the admin endpoints of the server.
+type AdminConfig struct {
+	// Address to serve the admin endpoints on a separate listener (optional), e.g. "127.0.0.1:8081".
+	// If not set, the admin endpoints are served under /admin by the server of the API and Token is required.
+	Address string `yaml:"address"`
+	// Token that requests to the admin endpoints must send as bearer token (optional for a separate listener).
+	Token string `yaml:"token"`
+}
+
+func (c AdminConfig) Valid() error {
+	if c.Address == "" && c.Token == "" {
+		return fmt.Errorf("token must be set if the admin endpoints are served without a separate address")
+	}
+	return nil
+}
+
+// ReloadFunc reloads the server at runtime when requested by the admin endpoint, e.g. by reading the configuration
+// file and policy bundle again and passing them to Server.Reload.
+type ReloadFunc func(ctx context.Context) error
+
+// WithReloadFunc sets the function that is called for POST /admin/reload, reloading is not supported if not set.
+func WithReloadFunc(f ReloadFunc) ServerOption {
+	return func(s *Server) {
+		s.reloadFunc = f
+	}
+}
+
+type adminReloadResponse struct {
+	// Repositories are the names of the configured repositories after the reload.
+	Repositories []string `json:"repositories"`
+}
+
+// adminHandler serves the admin endpoints.
+func (s *Server) adminHandler(config AdminConfig) http.Handler {
+	r := chi.NewRouter()
+	r.Use(
+		accessLogger(s.config.HTTP.AccessLog),
+		adminAuthentication(config.Token),
+	)
+	r.Post("/admin/reload", s.adminReload)
+	return r
+}
+
+// adminAuthentication is a middleware to authenticate admin requests by the bearer token, all requests are allowed if
+// the token is empty.
+func adminAuthentication(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+					log.WithField("remoteAddr", r.RemoteAddr).Warn("Invalid token for admin request")
+					respondError(w, r, "Authentication failed", clientError{errors.New("invalid admin token"), http.StatusUnauthorized})
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminReload reloads the server with the reload function. If reloading fails, the current configuration stays active.
+func (s *Server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if s.reloadFunc == nil {
+		respondError(w, r, "Reload not supported", clientError{errors.New("no reload function set for the server"), http.StatusNotImplemented})
+		return
+	}
+
+	// Reload functions are serialized, so a slow reload is not overtaken by a later one. Server.Reload takes reloadMu
+	// itself, so it is called while holding adminReloadMu.
+	s.adminReloadMu.Lock()
+	err := s.reloadFunc(r.Context())
+	s.adminReloadMu.Unlock()
+	if err != nil {
+		log.WithError(err).Error("Reload failed, keeping current configuration")
+		// The error is exposed, since only admins can send the request
+		respondError(w, r, "Reload failed", clientError{fmt.Errorf("%w, the current configuration stays active", err), http.StatusInternalServerError})
+		return
+	}
+
+	res := adminReloadResponse{Repositories: make([]string, 0)}
+	for repoName := range s.current().config.Repositories {
+		res.Repositories = append(res.Repositories, repoName)
+	}
+	sort.Strings(res.Repositories)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,124 @@
+package vignet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestServer_AdminReload(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	authenticationProvider := staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}
+
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		"before": {URL: gitSrv.URL},
+	}
+	config.Admin = &vignet.AdminConfig{Token: "s3cr3t"}
+
+	reloaded := config
+	reloaded.Repositories = vignet.RepositoriesConfig{
+		"after": {URL: gitSrv.URL},
+	}
+
+	ctx := context.Background()
+	var (
+		srv       *vignet.Server
+		reloadErr error
+	)
+	srv, err := vignet.NewServer(ctx,
+		vignet.WithConfig(config),
+		vignet.WithAuthenticationProvider(authenticationProvider),
+		vignet.WithReloadFunc(func(ctx context.Context) error {
+			if reloadErr != nil {
+				return reloadErr
+			}
+			return srv.Reload(ctx, vignet.WithConfig(reloaded), vignet.WithAuthenticationProvider(authenticationProvider))
+		}),
+	)
+	require.NoError(t, err)
+	handler := srv.Handler()
+
+	reload := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	readFile := func(repo string) int {
+		req := httptest.NewRequest("GET", "/v1/repos/"+repo+"/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, readFile("before"))
+	require.Equal(t, http.StatusNotFound, readFile("after"))
+
+	rec := reload("")
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+	rec = reload("wrong")
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+	reloadErr = errors.New("decoding config file: invalid")
+	rec = reload("s3cr3t")
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "decoding config file: invalid, the current configuration stays active")
+	require.Equal(t, http.StatusOK, readFile("before"))
+
+	reloadErr = nil
+	rec = reload("s3cr3t")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"repositories": ["after"]}`, rec.Body.String())
+
+	require.Equal(t, http.StatusNotFound, readFile("before"))
+	require.Equal(t, http.StatusOK, readFile("after"))
+}
+
+func TestServer_AdminAddress(t *testing.T) {
+	config := vignet.DefaultConfig
+	config.Admin = &vignet.AdminConfig{Address: "127.0.0.1:0"}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx,
+		vignet.WithConfig(config),
+		vignet.WithAddress("127.0.0.1:0"),
+		vignet.WithAuthenticationProvider(staticAuthenticationProvider{}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer func() {
+		_ = srv.Stop(ctx)
+	}()
+	require.NotNil(t, srv.AdminAddr())
+
+	// Admin endpoints are not served by the server of the API
+	resp, err := http.Post(fmt.Sprintf("http://%s/admin/reload", srv.Addr()), "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Reloading is not supported without a reload function
+	resp, err = http.Post(fmt.Sprintf("http://%s/admin/reload", srv.AdminAddr()), "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
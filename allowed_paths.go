@@ -0,0 +1,115 @@
+package vignet
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/gobwas/glob"
+)
+
+// validRepositoryPath checks that a path of a request is a clean path relative to the repository root, so it cannot
+// reach files outside of the worktree (e.g. with "../" or an absolute path).
+func validRepositoryPath(p string) error {
+	if path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") || path.Clean(p) != p {
+		return fmt.Errorf("must be a clean relative path, got %q", p)
+	}
+	return nil
+}
+
+func validAllowedPaths(patterns []string) error {
+	for i, pattern := range patterns {
+		if _, err := glob.Compile(pattern, '/'); err != nil {
+			return fmt.Errorf("invalid allowedPaths[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// allowsPath checks a path against the allowed paths of the repository, all paths are allowed if none are set.
+func (c RepositoryConfig) allowsPath(p string) bool {
+	if len(c.AllowedPaths) == 0 {
+		return true
+	}
+	for _, pattern := range c.AllowedPaths {
+		// Patterns are validated with the configuration
+		g, err := glob.Compile(pattern, '/')
+		if err == nil && g.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowedPaths returns an error with status 403 if a command of the request targets or reads a path outside of
+// the allowed paths of the repository. It is checked before cloning, independent of the authorizer.
+func (c RepositoryConfig) checkAllowedPaths(repoName string, req patchRequest) error {
+	for idx, cmd := range req.Commands {
+		var paths []string
+		if cmd.Path != "" {
+			paths = append(paths, cmd.Path)
+		}
+		for _, p := range append(paths, cmd.readPaths()...) {
+			if !c.allowsPath(p) {
+				return clientError{fmt.Errorf("'commands[%d]': path %q is not allowed for repository %q", idx, p, repoName), http.StatusForbidden}
+			}
+		}
+	}
+	return nil
+}
+
+// checkDirectoryFiles returns a deniedError with status 403 if the command reads or changes a file below a directory that is
+// outside of the allowed paths of the repository. The paths of a request can match while the files below them don't
+// (e.g. "apps/*" matches the directory "apps/a"), so this is checked with the files of the worktree before applying
+// the command.
+func (c RepositoryConfig) checkDirectoryFiles(repoName string, idx int, fs billy.Filesystem, cmd patchRequestCommand) error {
+	if len(c.AllowedPaths) == 0 {
+		return nil
+	}
+	for _, p := range directoryFiles(fs, cmd) {
+		if !c.allowsPath(p) {
+			return deniedError{clientError{fmt.Errorf("'commands[%d]': path %q is not allowed for repository %q", idx, p, repoName), http.StatusForbidden}}
+		}
+	}
+	return nil
+}
+
+// directoryFiles returns the files below directories that are read or changed by the command: the source files of
+// copyFile of a directory with their targets and the YAML files of updateImageMarkers of a directory.
+func directoryFiles(fs billy.Filesystem, cmd patchRequestCommand) []string {
+	var files []string
+	switch {
+	case cmd.CopyFile != nil:
+		for _, filename := range walkFiles(fs, cmd.CopyFile.From) {
+			rel := strings.TrimPrefix(filename, path.Clean(cmd.CopyFile.From)+"/")
+			files = append(files, filename, path.Join(cmd.Path, rel))
+		}
+	case cmd.UpdateImageMarkers != nil:
+		for _, filename := range walkFiles(fs, cmd.Path) {
+			if isYAMLFile(filename) {
+				files = append(files, filename)
+			}
+		}
+	}
+	return files
+}
+
+// walkFiles returns the files below dir, or nil if dir is not a directory.
+func walkFiles(fs billy.Filesystem, dir string) []string {
+	fi, err := fs.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+	var files []string
+	_ = util.Walk(fs, dir, func(filename string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, filename)
+		}
+		return nil
+	})
+	return files
+}
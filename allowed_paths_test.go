@@ -0,0 +1,131 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_AllowedPaths(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+		"my-group/my-project/values.yml":  "image: a",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {
+				URL:          gitSrv.URL,
+				AllowedPaths: []string{"my-group/my-project/release.yml"},
+			},
+			// Requests are rejected before cloning, so the repository is never reached
+			"unreachable": {
+				URL:          "http://127.0.0.1:1/unreachable.git",
+				AllowedPaths: []string{"apps/**"},
+			},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	})
+
+	patch := func(repo, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/patch/"+repo, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("allowed path", func(t *testing.T) {
+		rec := patch("e2e-test", `{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("path not allowed", func(t *testing.T) {
+		rec := patch("unreachable", `{
+			"commands": [
+				{"path": "apps/my-app/release.yml", "setField": {"field": "foo", "value": "baz"}},
+				{"path": "infra/release.yml", "setField": {"field": "foo", "value": "baz"}}
+			]
+		}`)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `'commands[1]': path "infra/release.yml" is not allowed for repository "unreachable"`)
+	})
+
+	t.Run("read path not allowed", func(t *testing.T) {
+		rec := patch("e2e-test", `{
+			"commands": [{"path": "my-group/my-project/release.yml", "copyFile": {"from": "my-group/my-project/values.yml", "overwrite": true}}]
+		}`)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `path "my-group/my-project/values.yml" is not allowed`)
+	})
+
+	t.Run("read file not allowed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/repos/e2e-test/file?path=my-group/my-project/values.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	})
+
+	t.Run("file below directory not allowed", func(t *testing.T) {
+		_, gitSrv := startMockHttpGitServer(t, map[string]string{
+			"my-group/my-project/a/release.yml":      "foo: bar",
+			"my-group/my-project/a/deep/release.yml": "foo: bar",
+		}, gitserver.Options{})
+		handler := newTestHandler(t, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"apps": {
+					URL:          gitSrv.URL,
+					AllowedPaths: []string{"my-group/my-project/*", "my-group/my-project/*/*.yml"},
+				},
+			},
+			Commit: vignet.DefaultConfig.Commit,
+		})
+
+		// The paths of the request are allowed, but the copy also reads and writes files in deep/
+		req := httptest.NewRequest("POST", "/v1/patch/apps", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/b", "copyFile": {"from": "my-group/my-project/a"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `'commands[0]': path "my-group/my-project/a/deep/release.yml" is not allowed for repository "apps"`)
+	})
+
+	traversals := []string{
+		"../release.yml",
+		"apps/../../release.yml",
+		"/etc/passwd",
+		"./apps/release.yml",
+		".",
+	}
+	for _, p := range traversals {
+		t.Run("invalid path "+p, func(t *testing.T) {
+			rec := patch("unreachable", `{
+				"commands": [{"path": "`+p+`", "setField": {"field": "foo", "value": "baz"}}]
+			}`)
+			require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), "must be a clean relative path")
+		})
+	}
+
+	t.Run("invalid read path", func(t *testing.T) {
+		rec := patch("unreachable", `{
+			"commands": [{"path": "apps/release.yml", "copyFile": {"from": "apps/../../secrets.yml"}}]
+		}`)
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "read path must be a clean relative path")
+	})
+}
+
+func TestRepositoryConfig_AllowedPaths(t *testing.T) {
+	config := vignet.RepositoryConfig{URL: "https://gitlab.example.com/infra.git", AllowedPaths: []string{"apps/[a-"}}
+	require.ErrorContains(t, config.Valid(), "invalid allowedPaths[0]")
+}
@@ -0,0 +1,103 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIVersionHeader selects the API version of a request to an unversioned path and is set in responses with the
+// version that handled the request.
+const APIVersionHeader = "X-Vignet-API-Version"
+
+// APIVersion is a major version of the HTTP API. Breaking changes of requests or responses are only made in a new
+// version, which is served under its own path prefix (e.g. "/v2") next to the existing versions.
+type APIVersion string
+
+const (
+	APIVersionV1 APIVersion = "v1"
+)
+
+// APIVersions are the supported API versions, the last one is the latest.
+var APIVersions = []APIVersion{APIVersionV1}
+
+// LatestAPIVersion is the version of unversioned paths, if no version is requested.
+var LatestAPIVersion = APIVersions[len(APIVersions)-1]
+
+// pathPrefix returns the path prefix of the version's endpoints.
+func (v APIVersion) pathPrefix() string {
+	return "/" + string(v)
+}
+
+// parseAPIVersion parses a requested API version, the "v" prefix is optional (e.g. "1" or "v1").
+func parseAPIVersion(s string) (APIVersion, error) {
+	v := APIVersion("v" + strings.TrimPrefix(strings.TrimSpace(s), "v"))
+	for _, supported := range APIVersions {
+		if v == supported {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported API version %q, supported versions are %s", s, joinAPIVersions(APIVersions))
+}
+
+func joinAPIVersions(versions []APIVersion) string {
+	s := make([]string, len(versions))
+	for i, v := range versions {
+		s[i] = string(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+func ctxWithAPIVersion(ctx context.Context, v APIVersion) context.Context {
+	return context.WithValue(ctx, apiVersionKey, v)
+}
+
+// apiVersionFromCtx returns the API version of the request, handlers can use it to adapt requests and responses
+// to the version.
+func apiVersionFromCtx(ctx context.Context) APIVersion {
+	v, ok := ctx.Value(apiVersionKey).(APIVersion)
+	if !ok {
+		return LatestAPIVersion
+	}
+	return v
+}
+
+// versionedAPI serves requests to the path prefix of an API version. A requested version in the header must match
+// the version of the path.
+func versionedAPI(v APIVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requested := r.Header.Get(APIVersionHeader); requested != "" {
+				if rv, err := parseAPIVersion(requested); err != nil || rv != v {
+					respondError(w, r, "Invalid API version", clientError{fmt.Errorf("requested API version %q does not match version %s of the path", requested, v), http.StatusBadRequest})
+					return
+				}
+			}
+
+			w.Header().Set(APIVersionHeader, string(v))
+			next.ServeHTTP(w, r.WithContext(ctxWithAPIVersion(r.Context(), v)))
+		})
+	}
+}
+
+// unversionedAPI serves requests to the deprecated paths without a version prefix. The version is negotiated by the
+// header and defaults to the latest version. Responses point to the versioned path as successor.
+func unversionedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := LatestAPIVersion
+		if requested := r.Header.Get(APIVersionHeader); requested != "" {
+			rv, err := parseAPIVersion(requested)
+			if err != nil {
+				respondError(w, r, "Invalid API version", clientError{err, http.StatusBadRequest})
+				return
+			}
+			v = rv
+		}
+
+		w.Header().Set(APIVersionHeader, string(v))
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", v.pathPrefix(), r.URL.EscapedPath()))
+		next.ServeHTTP(w, r.WithContext(ctxWithAPIVersion(r.Context(), v)))
+	})
+}
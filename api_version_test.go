@@ -0,0 +1,128 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_APIVersions(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+
+	t.Run("versioned patch", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch/e2e-test", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "v1", rec.Header().Get(vignet.APIVersionHeader))
+		assert.Empty(t, rec.Header().Get("Deprecation"))
+		assertGitRepoHeadCommit(t, fs, "Automated patch by vignet")
+	})
+
+	t.Run("unversioned alias", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "foo: baz\n", rec.Body.String())
+		assert.Equal(t, "v1", rec.Header().Get(vignet.APIVersionHeader))
+		assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+		assert.Equal(t, `</v1/repos/e2e-test/file>; rel="successor-version"`, rec.Header().Get("Link"))
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		requested      string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "versioned path with matching version",
+			path:           "/v1/capabilities",
+			requested:      "v1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "versioned path with other version",
+			path:           "/v1/capabilities",
+			requested:      "2",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `requested API version "2" does not match version v1 of the path`,
+		},
+		{
+			name:           "unversioned path with supported version",
+			path:           "/capabilities",
+			requested:      "1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unversioned path with unsupported version",
+			path:           "/capabilities",
+			requested:      "v2",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `unsupported API version "v2", supported versions are v1`,
+		},
+		{
+			name:           "unknown version prefix",
+			path:           "/v2/capabilities",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.requested != "" {
+				req.Header.Set(vignet.APIVersionHeader, tc.requested)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+
+	t.Run("OpenAPI server", func(t *testing.T) {
+		for _, path := range []string{"/v1/openapi.json", "/openapi.json"} {
+			req := httptest.NewRequest("GET", path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			var doc struct {
+				Servers []struct {
+					URL string `json:"url"`
+				} `json:"servers"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+			require.Len(t, doc.Servers, 1)
+			assert.Equal(t, "/v1", doc.Servers[0].URL, path)
+		}
+	})
+}
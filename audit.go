@@ -0,0 +1,117 @@
+package vignet
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// PatchEvent describes a patch that was successfully pushed to a repository.
+type PatchEvent struct {
+	Time time.Time
+	Repo string
+	// RepoURL is the URL of the repository, also for repositories of templates or discovery.
+	RepoURL    string
+	AuthCtx    AuthCtx
+	CommitHash string
+	// RevertedCommit is the hash of the commit of a patch that is reverted by CommitHash (e.g. on rollback of a batch).
+	RevertedCommit string
+	Message        string
+	// Paths of all files touched by the commands of the patch request.
+	Paths []string
+}
+
+// Notifier is notified about patches that were pushed to a repository.
+type Notifier interface {
+	Notify(ctx context.Context, event PatchEvent) error
+}
+
+// AuditOutcome is the outcome of an audited request.
+type AuditOutcome string
+
+const (
+	AuditOutcomeSucceeded AuditOutcome = "succeeded"
+	AuditOutcomeDenied    AuditOutcome = "denied"
+	AuditOutcomeFailed    AuditOutcome = "failed"
+)
+
+// AuditRecord is recorded for every authenticated patch request and every revert of a patch regardless of its outcome.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Action is ActionRevert for reverts of patches, it is empty for patch requests.
+	Action  Action       `json:"action,omitempty"`
+	Repo    string       `json:"repo"`
+	AuthCtx AuthCtx      `json:"authCtx"`
+	Outcome AuditOutcome `json:"outcome"`
+	// CommitHash is set if the outcome is AuditOutcomeSucceeded and it was not a dry run.
+	CommitHash string `json:"commitHash,omitempty"`
+	// RevertedCommit is the hash of the commit of the reverted patch for ActionRevert.
+	RevertedCommit string `json:"revertedCommit,omitempty"`
+	// DryRun is set if the request was a dry run without commit and push.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Paths of all files targeted by the commands of the patch request.
+	Paths []string `json:"paths"`
+	// Error is set if the outcome is AuditOutcomeDenied or AuditOutcomeFailed.
+	Error string `json:"error,omitempty"`
+	// Request is the patch request with redacted values of SOPS encrypted fields, it is used to replay the operation.
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// AuditSink receives an audit record for every patch request.
+type AuditSink interface {
+	Audit(ctx context.Context, record AuditRecord) error
+}
+
+// RegisterNotifier adds a notifier that will be called after a patch was pushed.
+// It must be called before the handler serves requests.
+func (h *Handler) RegisterNotifier(n Notifier) {
+	h.notifiers = append(h.notifiers, n)
+}
+
+// RegisterAuditSink adds an audit sink that will receive a record for every patch request.
+// It must be called before the handler serves requests.
+func (h *Handler) RegisterAuditSink(s AuditSink) {
+	h.auditSinks = append(h.auditSinks, s)
+}
+
+// notify calls all registered notifiers. Errors are logged, since the patch was already pushed.
+//
+// Notifiers are not cancelled with the request (e.g. if the client disconnects after the push), each notifier is
+// limited by the API timeout instead.
+func (h *Handler) notify(ctx context.Context, event PatchEvent) {
+	for _, n := range h.notifiers {
+		notifyCtx, cancel := context.WithTimeout(detachedContext{ctx}, h.config.Timeouts.api())
+		err := n.Notify(notifyCtx, event)
+		cancel()
+		if err != nil {
+			log.
+				WithField("repo", event.Repo).
+				WithError(err).
+				Errorf("Notifier %T failed", n)
+		}
+	}
+}
+
+// detachedContext keeps the values of the parent context without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}       { return nil }
+func (c detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any           { return c.parent.Value(key) }
+
+// audit sends the record to all registered audit sinks. Errors are logged and do not affect the response.
+func (h *Handler) audit(ctx context.Context, record AuditRecord) {
+	for _, s := range h.auditSinks {
+		if err := s.Audit(ctx, record); err != nil {
+			log.
+				WithField("repo", record.Repo).
+				WithError(err).
+				Errorf("Audit sink %T failed", s)
+		}
+	}
+}
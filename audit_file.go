@@ -0,0 +1,78 @@
+package vignet
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+type AuditConfig struct {
+	// File appends audit records as JSON lines to the file if set, the records can be replayed with `vignet replay`.
+	File string `yaml:"file"`
+}
+
+// FileAuditSink appends audit records as JSON lines to a file.
+type FileAuditSink struct {
+	mx sync.Mutex
+	f  *os.File
+}
+
+var _ AuditSink = &FileAuditSink{}
+
+// NewFileAuditSink opens the file for appending records, it is created if it does not exist.
+func NewFileAuditSink(filename string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit file: %w", err)
+	}
+	return &FileAuditSink{f: f}, nil
+}
+
+func (s *FileAuditSink) Audit(_ context.Context, record AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, err = s.f.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing audit record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileAuditSink) Close() error {
+	return s.f.Close()
+}
+
+// ReadAuditRecords reads audit records as JSON lines (e.g. written by FileAuditSink).
+func ReadAuditRecords(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+	scanner := bufio.NewScanner(r)
+	// Records contain the request with the content of created files
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("decoding audit record in line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit records: %w", err)
+	}
+	return records, nil
+}
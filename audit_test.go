@@ -0,0 +1,81 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+type recordingSink struct {
+	records []vignet.AuditRecord
+	events  []vignet.PatchEvent
+	// notifyDeadlines are the deadlines of the contexts of notifications
+	notifyDeadlines []time.Time
+}
+
+func (s *recordingSink) Audit(ctx context.Context, record vignet.AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *recordingSink) Notify(ctx context.Context, event vignet.PatchEvent) error {
+	s.events = append(s.events, event)
+	deadline, _ := ctx.Deadline()
+	s.notifyDeadlines = append(s.notifyDeadlines, deadline)
+	return nil
+}
+
+func TestHandler_AuditSinkAndNotifier(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Bumped release",
+		},
+		Timeouts: vignet.TimeoutsConfig{
+			API: time.Minute,
+		},
+	})
+	sink := &recordingSink{}
+	handler.RegisterAuditSink(sink)
+	handler.RegisterNotifier(sink)
+
+	// Allowed request
+	req, _ := http.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commands":[{"path":"my-group/my-project/release.yml","setField":{"field":"foo","value":"baz"}}]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Denied request
+	req, _ = http.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commands":[{"path":"other/file.yml","deleteFile":{}}]}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	require.Len(t, sink.records, 2)
+	require.Equal(t, vignet.AuditOutcomeSucceeded, sink.records[0].Outcome)
+	require.NotEmpty(t, sink.records[0].CommitHash)
+	require.Equal(t, []string{"my-group/my-project/release.yml"}, sink.records[0].Paths)
+	require.Equal(t, vignet.AuditOutcomeDenied, sink.records[1].Outcome)
+	require.NotEmpty(t, sink.records[1].Error)
+
+	require.Len(t, sink.events, 1)
+	require.Equal(t, sink.records[0].CommitHash, sink.events[0].CommitHash)
+	require.Equal(t, "Bumped release", sink.events[0].Message)
+	require.Equal(t, gitSrv.URL, sink.events[0].RepoURL)
+	// Notifications are bounded by the API timeout instead of the request
+	require.WithinDuration(t, time.Now().Add(time.Minute), sink.notifyDeadlines[0], 10*time.Second)
+}
@@ -28,8 +28,16 @@ type GitLabClaims struct {
 type AuthCtx struct {
 	// Error is set if the authentication failed.
 	Error error `json:"error"`
+	// Provider is the name of the authentication provider that authenticated the request.
+	Provider string `json:"provider"`
 	// GitLabClaims is set for GitLab authentication provider if no authenticated error occurred.
 	GitLabClaims *GitLabClaims `json:"gitLabClaims"`
+	// ClientCertificate is set for the mTLS authentication provider if no authentication error occurred.
+	ClientCertificate *ClientCertificateClaims `json:"clientCertificate"`
+	// BasicAuth is set for the htpasswd authentication provider if no authentication error occurred.
+	BasicAuth *BasicAuthClaims `json:"basicAuth"`
+	// IDToken is the validated token of the caller for credential exchange, it is never serialized.
+	IDToken string `json:"-"`
 }
 
 type AuthenticationProvider interface {
@@ -57,6 +65,7 @@ func AuthenticateRequest(authenticationProvider AuthenticationProvider) func(htt
 				return
 			}
 			ctx = ctxWithAuthCtx(ctx, authCtx)
+			accessLogEntryFromCtx(ctx).setAuthCtx(authCtx)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
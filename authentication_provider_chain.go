@@ -0,0 +1,57 @@
+package vignet
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// NamedAuthenticationProvider is an authentication provider of a chain, the name is set in AuthCtx.Provider for
+// requests it authenticated.
+type NamedAuthenticationProvider struct {
+	Name string
+	AuthenticationProvider
+}
+
+// ChainAuthenticationProvider tries multiple authentication providers in order, a request is authenticated by the
+// first provider that accepts it.
+type ChainAuthenticationProvider struct {
+	providers []NamedAuthenticationProvider
+}
+
+var _ AuthenticationProvider = &ChainAuthenticationProvider{}
+
+// NewChainAuthenticationProvider creates a new ChainAuthenticationProvider for the providers in order.
+func NewChainAuthenticationProvider(providers ...NamedAuthenticationProvider) *ChainAuthenticationProvider {
+	return &ChainAuthenticationProvider{
+		providers: providers,
+	}
+}
+
+// AuthCtxFromRequest returns the AuthCtx of the first provider that authenticated the request with the name of the
+// provider set. If no provider authenticated the request, the errors of all providers are set in AuthCtx.
+// An internal error of a provider is returned immediately.
+func (p *ChainAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (AuthCtx, error) {
+	if len(p.providers) == 0 {
+		return AuthCtx{
+			Error: errors.New("no authentication provider configured"),
+		}, nil
+	}
+
+	var errs []error
+	for _, provider := range p.providers {
+		authCtx, err := provider.AuthCtxFromRequest(r)
+		if err != nil {
+			return AuthCtx{}, fmt.Errorf("authenticating with %s: %w", provider.Name, err)
+		}
+		if authCtx.Error != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name, authCtx.Error))
+			continue
+		}
+		authCtx.Provider = provider.Name
+		return authCtx, nil
+	}
+	return AuthCtx{
+		Error: errors.Join(errs...),
+	}, nil
+}
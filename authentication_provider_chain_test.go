@@ -0,0 +1,119 @@
+package vignet_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func Test_ChainAuthenticationProvider_AuthCtxFromRequest(t *testing.T) {
+	rejecting := staticAuthenticationProvider{authCtx: vignet.AuthCtx{Error: errors.New("missing Authorization header")}}
+	accepting := staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}
+
+	req, _ := http.NewRequest("POST", "/foo", nil)
+
+	t.Run("first accepting provider", func(t *testing.T) {
+		authProvider := vignet.NewChainAuthenticationProvider(
+			vignet.NamedAuthenticationProvider{Name: "tokens", AuthenticationProvider: rejecting},
+			vignet.NamedAuthenticationProvider{Name: "gitlab", AuthenticationProvider: accepting},
+			vignet.NamedAuthenticationProvider{Name: "other", AuthenticationProvider: accepting},
+		)
+
+		authCtx, err := authProvider.AuthCtxFromRequest(req)
+		require.NoError(t, err)
+		require.NoError(t, authCtx.Error)
+		assert.Equal(t, "gitlab", authCtx.Provider)
+		require.NotNil(t, authCtx.GitLabClaims)
+		assert.Equal(t, "my-group/my-project", authCtx.GitLabClaims.ProjectPath)
+	})
+
+	t.Run("no accepting provider", func(t *testing.T) {
+		authProvider := vignet.NewChainAuthenticationProvider(
+			vignet.NamedAuthenticationProvider{Name: "tokens", AuthenticationProvider: rejecting},
+			vignet.NamedAuthenticationProvider{Name: "gitlab", AuthenticationProvider: rejecting},
+		)
+
+		authCtx, err := authProvider.AuthCtxFromRequest(req)
+		require.NoError(t, err)
+		require.Error(t, authCtx.Error)
+		assert.Contains(t, authCtx.Error.Error(), "tokens: missing Authorization header")
+		assert.Contains(t, authCtx.Error.Error(), "gitlab: missing Authorization header")
+		assert.Empty(t, authCtx.Provider)
+	})
+}
+
+func TestConfig_AuthenticationProviders(t *testing.T) {
+	gitLab := vignet.AuthenticationProviderConfig{
+		Type:   vignet.AuthenticationProviderGitLab,
+		GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.com"},
+	}
+
+	tests := []struct {
+		name          string
+		configure     func(c *vignet.Config)
+		expectedError string
+	}{
+		{
+			name: "single provider",
+			configure: func(c *vignet.Config) {
+				c.AuthenticationProvider = gitLab
+			},
+		},
+		{
+			name: "multiple providers",
+			configure: func(c *vignet.Config) {
+				other := gitLab
+				other.Name = "gitlab-other"
+				other.GitLab = &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.org"}
+				c.AuthenticationProviders = []vignet.AuthenticationProviderConfig{gitLab, other}
+			},
+		},
+		{
+			name: "duplicate names",
+			configure: func(c *vignet.Config) {
+				c.AuthenticationProviders = []vignet.AuthenticationProviderConfig{gitLab, gitLab}
+			},
+			expectedError: `invalid authenticationProviders[1]: duplicate name "gitlab"`,
+		},
+		{
+			name: "combined with single provider",
+			configure: func(c *vignet.Config) {
+				c.AuthenticationProvider = gitLab
+				c.AuthenticationProviders = []vignet.AuthenticationProviderConfig{gitLab}
+			},
+			expectedError: "cannot be combined with authenticationProviders",
+		},
+		{
+			name: "missing GitLab URL",
+			configure: func(c *vignet.Config) {
+				c.AuthenticationProvider = vignet.AuthenticationProviderConfig{Type: vignet.AuthenticationProviderGitLab}
+			},
+			expectedError: "invalid authenticationProvider: gitlab.url must be set",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := vignet.Config{
+				Repositories: vignet.RepositoriesConfig{
+					"e2e-test": {URL: "http://localhost"},
+				},
+				Commit: vignet.DefaultConfig.Commit,
+			}
+			tc.configure(&config)
+
+			err := config.Validate()
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -6,22 +6,84 @@ import (
 	"net/http"
 	netUrl "net/url"
 	"strings"
+	"time"
 
 	"github.com/MicahParks/keyfunc"
+	"github.com/apex/log"
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// jwksRefreshRateLimit limits refreshes of the keys for tokens signed with an unknown key.
+const jwksRefreshRateLimit = time.Minute
+
 type GitLabAuthenticationProvider struct {
-	jwks *keyfunc.JWKS
+	jwks           *keyfunc.JWKS
+	audience       string
+	issuer         string
+	clockSkew      time.Duration
+	maxTokenAge    time.Duration
+	requiredClaims []string
+	replayStore    TokenReplayStore
 }
 
 var _ AuthenticationProvider = &GitLabAuthenticationProvider{}
 
+// GitLabAuthenticationProviderOption configures a GitLabAuthenticationProvider.
+type GitLabAuthenticationProviderOption func(p *GitLabAuthenticationProvider)
+
+// WithGitLabAudience requires the `aud` claim of tokens to contain the audience, e.g. the audience of GitLab ID tokens
+// configured with `id_tokens` in a job.
+func WithGitLabAudience(audience string) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.audience = audience
+	}
+}
+
+// WithGitLabIssuer requires the `iss` claim of tokens to be the issuer, e.g. the URL of the GitLab instance.
+func WithGitLabIssuer(issuer string) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.issuer = issuer
+	}
+}
+
+// WithGitLabClockSkew sets the tolerated difference of the clocks of GitLab and vignet for validating the `exp`, `nbf`
+// and `iat` claims of tokens, defaults to no tolerance.
+func WithGitLabClockSkew(clockSkew time.Duration) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.clockSkew = clockSkew
+	}
+}
+
+// WithGitLabMaxTokenAge rejects tokens that were issued (`iat` claim) longer ago than the maximum age, tokens must have
+// an `iat` claim then.
+func WithGitLabMaxTokenAge(maxTokenAge time.Duration) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.maxTokenAge = maxTokenAge
+	}
+}
+
+// WithGitLabRequiredClaims rejects tokens that do not have a non-empty value for each of the claims
+// (e.g. "project_path" or "ref_protected").
+func WithGitLabRequiredClaims(claims ...string) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.requiredClaims = claims
+	}
+}
+
+// WithGitLabReplayProtection rejects tokens that were already used, tokens are identified by their `jti` claim and
+// remembered in the store until they expire. Tokens without `jti` are rejected.
+func WithGitLabReplayProtection(store TokenReplayStore) GitLabAuthenticationProviderOption {
+	return func(p *GitLabAuthenticationProvider) {
+		p.replayStore = store
+	}
+}
+
 // NewGitLabAuthenticationProvider creates a new GitLabAuthenticationProvider.
 //
 // It takes the GitLab instance URL as an argument.
-// The context is used to cancel the refreshing of keys.
-func NewGitLabAuthenticationProvider(ctx context.Context, url string) (*GitLabAuthenticationProvider, error) {
+// Keys are refreshed in the background if a token is signed with an unknown key (e.g. after GitLab rotated its keys),
+// the context is used to cancel the refreshing of keys.
+func NewGitLabAuthenticationProvider(ctx context.Context, url string, opts ...GitLabAuthenticationProviderOption) (*GitLabAuthenticationProvider, error) {
 	parsedURL, err := netUrl.Parse(url)
 	if err != nil {
 		return nil, fmt.Errorf("invalid URL: %w", err)
@@ -30,17 +92,45 @@ func NewGitLabAuthenticationProvider(ctx context.Context, url string) (*GitLabAu
 	parsedURL.Path = "/-/jwks"
 
 	jwks, err := keyfunc.Get(parsedURL.String(), keyfunc.Options{
-		Ctx: ctx,
+		Ctx:               ctx,
+		RefreshUnknownKID: true,
+		RefreshRateLimit:  jwksRefreshRateLimit,
+		RefreshErrorHandler: func(err error) {
+			log.WithError(err).Warn("Failed to refresh GitLab JWKS")
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("loading JWKS: %w", err)
 	}
+	go func() {
+		<-ctx.Done()
+		jwks.EndBackground()
+	}()
+
+	return newGitLabAuthenticationProvider(jwks, opts), nil
+}
+
+// NewGitLabAuthenticationProviderWithJWKS creates a new GitLabAuthenticationProvider with static keys.
+//
+// It takes the JSON of a JWKS (e.g. downloaded from `/-/jwks` of the GitLab instance) as an argument, so no requests
+// to GitLab are needed in air-gapped environments. Keys are not refreshed.
+func NewGitLabAuthenticationProviderWithJWKS(jwksJSON []byte, opts ...GitLabAuthenticationProviderOption) (*GitLabAuthenticationProvider, error) {
+	jwks, err := keyfunc.NewJSON(jwksJSON)
+	if err != nil {
+		return nil, fmt.Errorf("parsing JWKS: %w", err)
+	}
 
+	return newGitLabAuthenticationProvider(jwks, opts), nil
+}
+
+func newGitLabAuthenticationProvider(jwks *keyfunc.JWKS, opts []GitLabAuthenticationProviderOption) *GitLabAuthenticationProvider {
 	p := &GitLabAuthenticationProvider{
 		jwks: jwks,
 	}
-
-	return p, nil
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *GitLabAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (AuthCtx, error) {
@@ -58,7 +148,8 @@ func (p *GitLabAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (Auth
 	}
 	encodedJWT := authorizationHeader[len(bearerPrefix):]
 
-	token, err := jwt.ParseWithClaims(encodedJWT, &GitLabClaims{}, p.jwks.Keyfunc, jwt.WithValidMethods([]string{"RS256"}))
+	// Claims are validated after verifying the signature to tolerate clock skew
+	token, err := jwt.ParseWithClaims(encodedJWT, &GitLabClaims{}, p.jwks.Keyfunc, jwt.WithValidMethods([]string{"RS256"}), jwt.WithoutClaimsValidation())
 	if err != nil {
 		return AuthCtx{
 			Error: fmt.Errorf("parsing JWT: %w", err),
@@ -66,7 +157,76 @@ func (p *GitLabAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (Auth
 	}
 
 	claims := token.Claims.(*GitLabClaims)
+	if err := p.validateClaims(claims, encodedJWT, time.Now()); err != nil {
+		return AuthCtx{
+			Error: err,
+		}, nil
+	}
+
+	// Only valid tokens are remembered, so invalid tokens cannot be used to block valid ones
+	if p.replayStore != nil {
+		if claims.ID == "" {
+			return AuthCtx{
+				Error: fmt.Errorf("token is missing jti claim"),
+			}, nil
+		}
+		fresh, err := p.replayStore.Remember(r.Context(), claims.ID, claims.ExpiresAt.Add(p.clockSkew))
+		if err != nil {
+			return AuthCtx{}, fmt.Errorf("checking token replay: %w", err)
+		}
+		if !fresh {
+			return AuthCtx{
+				Error: fmt.Errorf("token %q was already used", claims.ID),
+			}, nil
+		}
+	}
+
 	return AuthCtx{
 		GitLabClaims: claims,
+		IDToken:      encodedJWT,
 	}, nil
 }
+
+// validateClaims validates the time based claims with the allowed clock skew, the issuer, audience and required
+// claims. Tokens without expiry are rejected.
+func (p *GitLabAuthenticationProvider) validateClaims(claims *GitLabClaims, encodedJWT string, now time.Time) error {
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("token is missing exp claim")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-p.clockSkew), true) {
+		return fmt.Errorf("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(p.clockSkew), false) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(p.clockSkew), false) {
+		return fmt.Errorf("token used before issued")
+	}
+	if p.maxTokenAge > 0 {
+		if claims.IssuedAt == nil {
+			return fmt.Errorf("token is missing iat claim")
+		}
+		if age := now.Sub(claims.IssuedAt.Time); age > p.maxTokenAge+p.clockSkew {
+			return fmt.Errorf("token was issued %s ago, the maximum age is %s", age.Round(time.Second), p.maxTokenAge)
+		}
+	}
+	if p.issuer != "" && !claims.VerifyIssuer(p.issuer, true) {
+		return fmt.Errorf("token has invalid issuer %q", claims.Issuer)
+	}
+	if p.audience != "" && !claims.VerifyAudience(p.audience, true) {
+		return fmt.Errorf("token has invalid audience %q", []string(claims.Audience))
+	}
+	if len(p.requiredClaims) > 0 {
+		// The signature was already verified, so the claims can be read again to check arbitrary claims
+		var mapClaims jwt.MapClaims
+		if _, _, err := jwt.NewParser().ParseUnverified(encodedJWT, &mapClaims); err != nil {
+			return fmt.Errorf("parsing JWT: %w", err)
+		}
+		for _, name := range p.requiredClaims {
+			if value, ok := mapClaims[name]; !ok || value == nil || value == "" {
+				return fmt.Errorf("token is missing required claim %q", name)
+			}
+		}
+	}
+	return nil
+}
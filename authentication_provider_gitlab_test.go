@@ -7,7 +7,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwa"
@@ -42,16 +45,246 @@ func Test_GitLabAuthenticationProvider_AuthCtxFromRequest(t *testing.T) {
 
 	require.NotNil(t, authCtx.GitLabClaims)
 	require.Equal(t, "my-group/my-project", authCtx.GitLabClaims.ProjectPath)
+	require.Equal(t, string(serialized), authCtx.IDToken)
+}
+
+func Test_GitLabAuthenticationProvider_StaticJWKS(t *testing.T) {
+	ks := generateJwkSet(t)
+	pubks, err := jwk.PublicSetOf(ks)
+	require.NoError(t, err)
+	jwksJSON, err := json.Marshal(pubks)
+	require.NoError(t, err)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksFile, jwksJSON, 0600))
+
+	config := vignet.Config{
+		AuthenticationProvider: vignet.AuthenticationProviderConfig{
+			Type:   vignet.AuthenticationProviderGitLab,
+			GitLab: &vignet.GitLabAuthenticationProviderConfig{JWKSFile: jwksFile},
+		},
+	}
+	// No requests are sent to GitLab, so the provider can be built without a URL
+	authProvider, err := config.BuildAuthenticationProvider(context.Background())
+	require.NoError(t, err)
+
+	serialized := buildJWT(t, ks)
+	req, _ := http.NewRequest("POST", "/foo", nil)
+	req.Header.Set("Authorization", "Bearer "+string(serialized))
+	authCtx, err := authProvider.AuthCtxFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, authCtx.Error)
+	require.NotNil(t, authCtx.GitLabClaims)
+	require.Equal(t, "my-group/my-project", authCtx.GitLabClaims.ProjectPath)
+
+	_, err = vignet.NewGitLabAuthenticationProviderWithJWKS([]byte(`{"keys": `))
+	require.ErrorContains(t, err, "parsing JWKS")
+}
+
+func Test_GitLabAuthenticationProvider_ValidateClaims(t *testing.T) {
+	ks := generateJwkSet(t)
+	jwksSrv := httptest.NewServer(jwksHandler(t, ks))
+	defer jwksSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authProvider, err := vignet.NewGitLabAuthenticationProvider(
+		ctx,
+		jwksSrv.URL,
+		vignet.WithGitLabAudience("vignet"),
+		vignet.WithGitLabIssuer("test"),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		build         func(b *jwt.Builder) *jwt.Builder
+		expectedError string
+	}{
+		{
+			name:  "valid",
+			build: func(b *jwt.Builder) *jwt.Builder { return b },
+		},
+		{
+			name: "missing expiry",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return jwt.NewBuilder().Issuer("test").Audience([]string{"vignet"})
+			},
+			expectedError: "token is missing exp claim",
+		},
+		{
+			name: "expired",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Expiration(time.Now().Add(-time.Minute))
+			},
+			expectedError: "token is expired",
+		},
+		{
+			name: "not yet valid",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.NotBefore(time.Now().Add(time.Minute))
+			},
+			expectedError: "token is not valid yet",
+		},
+		{
+			name: "other issuer",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Issuer("https://gitlab.example.org")
+			},
+			expectedError: `token has invalid issuer "https://gitlab.example.org"`,
+		},
+		{
+			name: "other audience",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Audience([]string{"other"})
+			},
+			expectedError: `token has invalid audience ["other"]`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tok, err := tc.build(jwt.
+				NewBuilder().
+				Issuer("test").
+				Audience([]string{"vignet"}).
+				Expiration(time.Now().Add(time.Hour))).
+				Build()
+			require.NoError(t, err)
+
+			req, _ := http.NewRequest("POST", "/foo", nil)
+			req.Header.Set("Authorization", "Bearer "+string(signJWT(t, ks, tok)))
+			authCtx, err := authProvider.AuthCtxFromRequest(req)
+			require.NoError(t, err)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, authCtx.Error, tc.expectedError)
+				return
+			}
+			require.NoError(t, authCtx.Error)
+		})
+	}
+}
+
+func Test_GitLabAuthenticationProvider_ValidationOptions(t *testing.T) {
+	ks := generateJwkSet(t)
+	jwksSrv := httptest.NewServer(jwksHandler(t, ks))
+	defer jwksSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authProvider, err := vignet.NewGitLabAuthenticationProvider(
+		ctx,
+		jwksSrv.URL,
+		vignet.WithGitLabClockSkew(time.Minute),
+		vignet.WithGitLabMaxTokenAge(10*time.Minute),
+		vignet.WithGitLabRequiredClaims("ref_protected"),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		build         func(b *jwt.Builder) *jwt.Builder
+		expectedError string
+	}{
+		{
+			name:  "valid",
+			build: func(b *jwt.Builder) *jwt.Builder { return b },
+		},
+		{
+			name: "expired within clock skew",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Expiration(time.Now().Add(-30 * time.Second))
+			},
+		},
+		{
+			name: "expired beyond clock skew",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Expiration(time.Now().Add(-2 * time.Minute))
+			},
+			expectedError: "token is expired",
+		},
+		{
+			name: "too old",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.IssuedAt(time.Now().Add(-20 * time.Minute))
+			},
+			expectedError: "the maximum age is 10m0s",
+		},
+		{
+			name: "missing required claim",
+			build: func(b *jwt.Builder) *jwt.Builder {
+				return b.Claim("ref_protected", "")
+			},
+			expectedError: `token is missing required claim "ref_protected"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tok, err := tc.build(jwt.
+				NewBuilder().
+				IssuedAt(time.Now()).
+				Expiration(time.Now().Add(time.Hour)).
+				Claim("ref_protected", "true")).
+				Build()
+			require.NoError(t, err)
+
+			req, _ := http.NewRequest("POST", "/foo", nil)
+			req.Header.Set("Authorization", "Bearer "+string(signJWT(t, ks, tok)))
+			authCtx, err := authProvider.AuthCtxFromRequest(req)
+			require.NoError(t, err)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, authCtx.Error, tc.expectedError)
+				return
+			}
+			require.NoError(t, authCtx.Error)
+		})
+	}
+}
+
+func Test_GitLabAuthenticationProvider_ReplayProtection(t *testing.T) {
+	ks := generateJwkSet(t)
+	jwksSrv := httptest.NewServer(jwksHandler(t, ks))
+	defer jwksSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authProvider, err := vignet.NewGitLabAuthenticationProvider(
+		ctx,
+		jwksSrv.URL,
+		vignet.WithGitLabReplayProtection(vignet.NewMemoryTokenReplayStore()),
+	)
+	require.NoError(t, err)
+
+	authenticate := func(tok jwt.Token) vignet.AuthCtx {
+		req, _ := http.NewRequest("POST", "/foo", nil)
+		req.Header.Set("Authorization", "Bearer "+string(signJWT(t, ks, tok)))
+		authCtx, err := authProvider.AuthCtxFromRequest(req)
+		require.NoError(t, err)
+		return authCtx
+	}
+
+	tok, err := jwt.NewBuilder().JwtID("job-1").Expiration(time.Now().Add(time.Hour)).Build()
+	require.NoError(t, err)
+	require.NoError(t, authenticate(tok).Error)
+	require.EqualError(t, authenticate(tok).Error, `token "job-1" was already used`)
+
+	tok, err = jwt.NewBuilder().Expiration(time.Now().Add(time.Hour)).Build()
+	require.NoError(t, err)
+	require.EqualError(t, authenticate(tok).Error, "token is missing jti claim")
 }
 
 func buildJWT(t *testing.T, ks jwk.Set) []byte {
 	tok, err := jwt.
 		NewBuilder().
 		Issuer("test").
+		Expiration(time.Now().Add(time.Hour)).
 		Claim("project_path", "my-group/my-project").
 		Build()
 	require.NoError(t, err)
 
+	return signJWT(t, ks, tok)
+}
+
+func signJWT(t *testing.T, ks jwk.Set, tok jwt.Token) []byte {
 	key, _ := ks.Key(0)
 	serialized, err := jwt.
 		NewSerializer().
@@ -0,0 +1,87 @@
+package vignet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuthClaims identify a caller authenticated by username and password.
+type BasicAuthClaims struct {
+	Username string `json:"username"`
+}
+
+// HtpasswdAuthenticationProvider authenticates requests by HTTP basic auth with users of an htpasswd file.
+// Only bcrypt hashes are supported (e.g. created with `htpasswd -B`).
+//
+// It is mainly intended for local development and smoke tests.
+type HtpasswdAuthenticationProvider struct {
+	hashes map[string][]byte
+}
+
+var _ AuthenticationProvider = &HtpasswdAuthenticationProvider{}
+
+// dummyHash is compared for unknown users, so the response time does not reveal whether a user exists.
+var dummyHash = []byte("$2a$10$wQE416v2S4c9RtzHo4Ifg.7MEQWIbXS3E11HSFP6he4DI6upCRLAO")
+
+// NewHtpasswdAuthenticationProvider creates a new HtpasswdAuthenticationProvider.
+//
+// It takes the content of an htpasswd file with lines of `username:hash`, empty lines and lines starting with `#`
+// are ignored.
+func NewHtpasswdAuthenticationProvider(htpasswd io.Reader) (*HtpasswdAuthenticationProvider, error) {
+	p := &HtpasswdAuthenticationProvider{
+		hashes: make(map[string][]byte),
+	}
+
+	scanner := bufio.NewScanner(htpasswd)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("line %d: expected username:hash", lineNumber)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: unsupported hash for user %q, only bcrypt is supported", lineNumber, username)
+		}
+		p.hashes[username] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading htpasswd: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *HtpasswdAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (AuthCtx, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return AuthCtx{
+			Error: errors.New("missing basic auth credentials in Authorization header"),
+		}, nil
+	}
+
+	hash, exists := p.hashes[username]
+	if !exists {
+		hash = dummyHash
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if !exists || err != nil {
+		return AuthCtx{
+			Error: fmt.Errorf("invalid username or password for user %q", username),
+		}, nil
+	}
+
+	return AuthCtx{
+		BasicAuth: &BasicAuthClaims{Username: username},
+	}, nil
+}
@@ -0,0 +1,75 @@
+package vignet_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func Test_HtpasswdAuthenticationProvider_AuthCtxFromRequest(t *testing.T) {
+	// Password "secret" hashed with bcrypt
+	authProvider, err := vignet.NewHtpasswdAuthenticationProvider(strings.NewReader(`# Users for smoke tests
+deployer:$2a$04$MWCqDj4dkj6MKJorSBECd.DWsCHWAjerTFAzKKTFMypAVDDmoQPp2
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		noBasicAuth   bool
+		expectedError string
+	}{
+		{
+			name:     "valid credentials",
+			username: "deployer",
+			password: "secret",
+		},
+		{
+			name:          "wrong password",
+			username:      "deployer",
+			password:      "wrong",
+			expectedError: `invalid username or password for user "deployer"`,
+		},
+		{
+			name:          "unknown user",
+			username:      "other",
+			password:      "secret",
+			expectedError: `invalid username or password for user "other"`,
+		},
+		{
+			name:          "missing credentials",
+			noBasicAuth:   true,
+			expectedError: "missing basic auth credentials",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/foo", nil)
+			if !tc.noBasicAuth {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			authCtx, err := authProvider.AuthCtxFromRequest(req)
+			require.NoError(t, err)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, authCtx.Error, tc.expectedError)
+				assert.Nil(t, authCtx.BasicAuth)
+				return
+			}
+			require.NoError(t, authCtx.Error)
+			require.NotNil(t, authCtx.BasicAuth)
+			assert.Equal(t, tc.username, authCtx.BasicAuth.Username)
+		})
+	}
+}
+
+func Test_NewHtpasswdAuthenticationProvider_UnsupportedHash(t *testing.T) {
+	// MD5 hash created by htpasswd without -B
+	_, err := vignet.NewHtpasswdAuthenticationProvider(strings.NewReader("deployer:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n"))
+	require.EqualError(t, err, `line 1: unsupported hash for user "deployer", only bcrypt is supported`)
+}
@@ -0,0 +1,76 @@
+package vignet
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ClientCertificateClaims are attributes of the verified TLS client certificate of the caller.
+type ClientCertificateClaims struct {
+	// Subject is the distinguished name of the subject, e.g. "CN=deployer,OU=ci,O=Example".
+	Subject            string   `json:"subject"`
+	CommonName         string   `json:"commonName"`
+	Organization       []string `json:"organization"`
+	OrganizationalUnit []string `json:"organizationalUnit"`
+	// DNSNames, EmailAddresses and URIs are the subject alternative names.
+	DNSNames       []string `json:"dnsNames"`
+	EmailAddresses []string `json:"emailAddresses"`
+	URIs           []string `json:"uris"`
+	// Issuer is the distinguished name of the issuer.
+	Issuer       string `json:"issuer"`
+	SerialNumber string `json:"serialNumber"`
+}
+
+// MTLSAuthenticationProvider authenticates requests by the client certificate of the TLS connection.
+//
+// The certificate must be verified by the server (see TLSConfig.ClientCAFile), so the provider only works if vignet
+// serves TLS itself.
+type MTLSAuthenticationProvider struct{}
+
+var _ AuthenticationProvider = &MTLSAuthenticationProvider{}
+
+// NewMTLSAuthenticationProvider creates a new MTLSAuthenticationProvider.
+func NewMTLSAuthenticationProvider() *MTLSAuthenticationProvider {
+	return &MTLSAuthenticationProvider{}
+}
+
+func (p *MTLSAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (AuthCtx, error) {
+	if r.TLS == nil {
+		return AuthCtx{
+			Error: errors.New("request was not sent over TLS"),
+		}, nil
+	}
+	// Verified chains are only set if the certificate was verified against the client CAs
+	if len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return AuthCtx{
+			Error: errors.New("missing verified client certificate"),
+		}, nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	claims := &ClientCertificateClaims{
+		Subject:            cert.Subject.String(),
+		CommonName:         cert.Subject.CommonName,
+		Organization:       nonNilStrings(cert.Subject.Organization),
+		OrganizationalUnit: nonNilStrings(cert.Subject.OrganizationalUnit),
+		DNSNames:           nonNilStrings(cert.DNSNames),
+		EmailAddresses:     nonNilStrings(cert.EmailAddresses),
+		URIs:               make([]string, len(cert.URIs)),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       cert.SerialNumber.String(),
+	}
+	for i, u := range cert.URIs {
+		claims.URIs[i] = u.String()
+	}
+	return AuthCtx{
+		ClientCertificate: claims,
+	}, nil
+}
+
+// nonNilStrings returns an empty slice for nil, so the policy input has arrays instead of null.
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
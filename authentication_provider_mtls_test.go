@@ -0,0 +1,165 @@
+package vignet_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func Test_MTLSAuthenticationProvider_AuthCtxFromRequest(t *testing.T) {
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	client := newTestCertificate(t, pkix.Name{CommonName: "deployer", Organization: []string{"Example"}}, ca)
+
+	authProvider := vignet.NewMTLSAuthenticationProvider()
+
+	req, _ := http.NewRequest("POST", "/foo", nil)
+	authCtx, err := authProvider.AuthCtxFromRequest(req)
+	require.NoError(t, err)
+	require.EqualError(t, authCtx.Error, "request was not sent over TLS")
+
+	req.TLS = &tls.ConnectionState{}
+	authCtx, err = authProvider.AuthCtxFromRequest(req)
+	require.NoError(t, err)
+	require.EqualError(t, authCtx.Error, "missing verified client certificate")
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{client.cert, ca.cert}}
+	authCtx, err = authProvider.AuthCtxFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, authCtx.Error)
+	require.NotNil(t, authCtx.ClientCertificate)
+	assert.Equal(t, "CN=deployer,O=Example", authCtx.ClientCertificate.Subject)
+	assert.Equal(t, "deployer", authCtx.ClientCertificate.CommonName)
+	assert.Equal(t, []string{"Example"}, authCtx.ClientCertificate.Organization)
+	assert.Equal(t, []string{"deployer.example.com"}, authCtx.ClientCertificate.DNSNames)
+	assert.Equal(t, "CN=test-ca", authCtx.ClientCertificate.Issuer)
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	server := newTestCertificate(t, pkix.Name{CommonName: "vignet"}, ca)
+	client := newTestCertificate(t, pkix.Name{CommonName: "deployer"}, ca)
+
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		"e2e-test": {URL: "http://localhost"},
+	}
+	config.AuthenticationProvider = vignet.AuthenticationProviderConfig{Type: vignet.AuthenticationProviderMTLS}
+	config.HTTP.TLS = &vignet.TLSConfig{
+		CertFile:     server.writePEM(t, dir, "server.pem", nil),
+		KeyFile:      server.writePEM(t, dir, "server-key.pem", server.key),
+		ClientCAFile: ca.writePEM(t, dir, "ca.pem", nil),
+	}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config), vignet.WithAddress("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer func() {
+		_ = srv.Stop(ctx)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientWithCert := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+	url := fmt.Sprintf("https://%s/patch/e2e-test", srv.Addr())
+	body := `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "bar"}}]}`
+
+	// Requests without a client certificate are not authenticated
+	resp, err := clientWithCert().Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Requests with a verified client certificate are authenticated, the default policy denies them without GitLab claims
+	resp, err = clientWithCert(client.tlsCertificate()).Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate creates a CA certificate if parent is nil, otherwise a certificate signed by parent.
+func newTestCertificate(t *testing.T, subject pkix.Name, parent *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		template.DNSNames = []string{subject.CommonName + ".example.com"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+// writePEM writes the certificate or the given key PEM encoded to a file and returns its path.
+func (c *testCertificate) writePEM(t *testing.T, dir, name string, key *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}
+	if key != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+	return path
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.cert.Raw},
+		PrivateKey:  c.key,
+		Leaf:        c.cert,
+	}
+}
@@ -4,51 +4,316 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/open-policy-agent/opa/bundle"
 	"github.com/open-policy-agent/opa/rego"
 )
 
+// Action is an operation on a repository that is authorized by the policy. Each action has its own entrypoint
+// data.vignet.request.<action>.violations, so new operations get their own rules instead of relying on the patch rules.
+type Action string
+
+const (
+	// ActionPatch patches files of a repository, the input contains the patchRequest.
+	ActionPatch Action = "patch"
+	// ActionRead reads from a repository (e.g. promotions), the input contains the readRequest.
+	ActionRead Action = "read"
+	// ActionTag creates a tag by a createTag command, the input contains the tagRequest and the patchRequest.
+	ActionTag Action = "tag"
+	// ActionCreateBranch creates the source branch of a pull or merge request, the input contains the branchRequest
+	// and the patchRequest.
+	ActionCreateBranch Action = "createBranch"
+	// ActionPreview applies a patch request without committing (POST /patch/{repo}/preview), the input contains the
+	// patchRequest.
+	ActionPreview Action = "preview"
+	// ActionRevert reverts the commit of a patch (e.g. on rollback of a batch), the input contains the revertRequest
+	// and the patchRequest of the reverted patch.
+	ActionRevert Action = "revert"
+)
+
+// Actions are all actions that are authorized by the policy.
+var Actions = []Action{ActionPatch, ActionRead, ActionTag, ActionCreateBranch, ActionPreview, ActionRevert}
+
+// packagePath returns the path of the policy package of the action (e.g. "vignet.request.patch").
+func (a Action) packagePath() string {
+	return "vignet.request." + string(a)
+}
+
+// fallback returns the action whose policy is evaluated if the policy does not define the package of the action.
+// Previews disclose the content of files and reverts change them, so they are not allowed by a policy that only
+// restricts patches.
+func (a Action) fallback() (Action, bool) {
+	if a == ActionPreview || a == ActionRevert {
+		return ActionPatch, true
+	}
+	return "", false
+}
+
+// deniedByDefault returns true if the action is denied if the policy does not define the package of the action.
+// Reads disclose the content of the repository and created branches can be any branch of the repository (e.g. a
+// protected branch), so they must be allowed explicitly by the policy.
+func (a Action) deniedByDefault() bool {
+	return a == ActionRead || a == ActionCreateBranch
+}
+
 type Authorizer interface {
-	AllowPatch(ctx context.Context, authCtx AuthCtx, repo string, req patchRequest) error
+	// Authorize authorizes an action, it returns an error implementing ViolationsResolver if the action is denied.
+	// Patch actions are authorized before the repository is cloned with input.Current set to nil and again after
+	// cloning with the current state of the files targeted by the commands.
+	Authorize(ctx context.Context, input authorizationInput) error
+}
+
+// authorizationInput is the policy input of an action, only the requests of the action are set.
+type authorizationInput struct {
+	Action  Action          `json:"action"`
+	Repo    string          `json:"repo"`
+	AuthCtx AuthCtx         `json:"authCtx"`
+	Request RequestMetadata `json:"request"`
+
+	PatchRequest  *patchRequest  `json:"patchRequest,omitempty"`
+	ReadRequest   *readRequest   `json:"readRequest,omitempty"`
+	TagRequest    *tagRequest    `json:"tagRequest,omitempty"`
+	BranchRequest *branchRequest `json:"branchRequest,omitempty"`
+	RevertRequest *revertRequest `json:"revertRequest,omitempty"`
+	// Current is the state of the files targeted by the commands, it is only set for patch actions after the
+	// repository was cloned.
+	Current []currentState `json:"current,omitempty"`
+}
+
+// readRequest describes a request that reads from a repository.
+type readRequest struct {
+	// Resource that is read (e.g. "promotions" or "file").
+	Resource string `json:"resource"`
+	// Path of the file that is read, only set for the "file" resource.
+	Path string `json:"path,omitempty"`
+	// Field of the file that is read, only set for the "file" resource if a field is requested.
+	Field string `json:"field,omitempty"`
+	// Branch that is read, empty for the default branch.
+	Branch string `json:"branch,omitempty"`
+}
+
+// tagRequest describes a tag that is created by a createTag command.
+type tagRequest struct {
+	Name      string `json:"name"`
+	Annotated bool   `json:"annotated"`
+	// Target revision of the tag, empty for the commit created by the request.
+	Target string `json:"target"`
+}
+
+// branchRequest describes the source branch of a pull or merge request that is created by a patch request.
+type branchRequest struct {
+	// Name of the branch, empty if it is derived from the commit hash ("vignet/<commit hash>").
+	Name string `json:"name"`
+	// TargetBranch of the pull request, empty for the default branch of the repository.
+	TargetBranch string `json:"targetBranch"`
+}
+
+// revertRequest describes the commit of a patch that is reverted.
+type revertRequest struct {
+	// Commit is the hash of the reverted commit.
+	Commit string `json:"commit"`
+	// Branch the commit was pushed to.
+	Branch string `json:"branch"`
+}
+
+// patchActionInputs returns the inputs of all actions of a patch request: the patch itself, a tag for each createTag
+// command and the source branch of a pull or merge request. A preview creates neither, so only the preview is authorized.
+// Values of SOPS encrypted fields are redacted, so they are not passed to the policy.
+func patchActionInputs(action Action, repo string, authCtx AuthCtx, requestMetadata RequestMetadata, req patchRequest) []authorizationInput {
+	req = req.redacted()
+	newInput := func(action Action) authorizationInput {
+		return authorizationInput{
+			Action:       action,
+			Repo:         repo,
+			AuthCtx:      authCtx,
+			Request:      requestMetadata,
+			PatchRequest: &req,
+		}
+	}
+
+	inputs := []authorizationInput{newInput(action)}
+	if action == ActionPreview {
+		return inputs
+	}
+	for _, cmd := range req.Commands {
+		if cmd.CreateTag == nil {
+			continue
+		}
+		input := newInput(ActionTag)
+		input.TagRequest = &tagRequest{
+			Name:      cmd.CreateTag.Name,
+			Annotated: cmd.CreateTag.Annotated,
+			Target:    cmd.CreateTag.Target,
+		}
+		inputs = append(inputs, input)
+	}
+	if pr := req.changeRequest(); pr != nil {
+		input := newInput(ActionCreateBranch)
+		input.BranchRequest = &branchRequest{
+			Name:         pr.SourceBranch,
+			TargetBranch: pr.TargetBranch,
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
+
+// authorizeAll authorizes all inputs and combines the violations of all actions. Other errors are returned immediately.
+func authorizeAll(ctx context.Context, authorizer Authorizer, inputs []authorizationInput) error {
+	var violations authorizerViolationsError
+	for _, input := range inputs {
+		err := authorizer.Authorize(ctx, input)
+		if v, ok := err.(ViolationsResolver); ok {
+			violations = append(violations, v.Violations()...)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("authorizing %s action: %w", input.Action, err)
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
 }
 
 type RegoAuthorizer struct {
-	patchAllowQuery rego.PreparedEvalQuery
+	// policy is replaced atomically when an updated bundle is activated.
+	policy atomic.Pointer[regoPolicy]
+}
+
+// regoPolicy are the prepared queries of a bundle.
+type regoPolicy struct {
+	actionQueries    map[Action]rego.PreparedEvalQuery
+	configCheckQuery rego.PreparedEvalQuery
+
+	// packages are the paths of packages defined by the policy (without "data." prefix).
+	packages map[string]bool
 }
 
 var _ Authorizer = &RegoAuthorizer{}
 
-func NewRegoAuthorizer(ctx context.Context, bundle *bundle.Bundle) (*RegoAuthorizer, error) {
-	patchAllowQuery, err := rego.New(
-		rego.Query("data.vignet.request.patch.violations[msg]"),
+// RegoAuthorizerOption configures optional behavior of a RegoAuthorizer.
+type RegoAuthorizerOption func(o *regoAuthorizerOptions)
+
+type regoAuthorizerOptions struct {
+	data map[string]any
+}
+
+// WithRegoData adds static data documents to the data of the bundle, e.g. a mapping of teams to repositories that is
+// available as data.teams in the policy. Keys must not conflict with data of the bundle.
+func WithRegoData(data map[string]any) RegoAuthorizerOption {
+	return func(o *regoAuthorizerOptions) {
+		o.data = data
+	}
+}
+
+func NewRegoAuthorizer(ctx context.Context, bundle *bundle.Bundle, opts ...RegoAuthorizerOption) (*RegoAuthorizer, error) {
+	var o regoAuthorizerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.data) > 0 {
+		var err error
+		bundle, err = bundleWithData(bundle, o.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	packages := make(map[string]bool)
+	for _, m := range bundle.Modules {
+		if m.Parsed != nil {
+			packages[strings.TrimPrefix(m.Parsed.Package.Path.String(), "data.")] = true
+		}
+	}
+
+	actionQueries := make(map[Action]rego.PreparedEvalQuery, len(Actions))
+	for _, action := range Actions {
+		queryAction := action
+		if fallback, ok := action.fallback(); ok && !packages[action.packagePath()] {
+			queryAction = fallback
+		}
+		q, err := prepareViolationsQuery(ctx, bundle, fmt.Sprintf("data.%s.violations[msg]", queryAction.packagePath()))
+		if err != nil {
+			return nil, err
+		}
+		actionQueries[action] = q
+	}
+	configCheckQuery, err := prepareViolationsQuery(ctx, bundle, "data.vignet.config.violations[msg]")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RegoAuthorizer{}
+	r.policy.Store(&regoPolicy{
+		actionQueries:    actionQueries,
+		configCheckQuery: configCheckQuery,
+		packages:         packages,
+	})
+	return r, nil
+}
+
+// Swap replaces the policy of the authorizer with the policy of another authorizer, e.g. to activate an updated
+// bundle after it passed the SelfTest. Requests that are evaluated concurrently use either policy.
+func (r *RegoAuthorizer) Swap(other *RegoAuthorizer) {
+	r.policy.Store(other.policy.Load())
+}
+
+// hasPackage returns true if the policy defines the package (e.g. "vignet.request.patch").
+func (r *RegoAuthorizer) hasPackage(path string) bool {
+	return r.policy.Load().packages[path]
+}
+
+// bundleWithData returns a copy of the bundle with the data added to the data of the bundle.
+func bundleWithData(b *bundle.Bundle, data map[string]any) (*bundle.Bundle, error) {
+	merged := *b
+	merged.Data = make(map[string]any, len(b.Data)+len(data))
+	for key, value := range b.Data {
+		merged.Data[key] = value
+	}
+	for key, value := range data {
+		if _, exists := merged.Data[key]; exists {
+			return nil, fmt.Errorf("data %q conflicts with data of the bundle", key)
+		}
+		merged.Data[key] = value
+	}
+	return &merged, nil
+}
+
+func prepareViolationsQuery(ctx context.Context, bundle *bundle.Bundle, query string) (rego.PreparedEvalQuery, error) {
+	q, err := rego.New(
+		rego.Query(query),
 		rego.ParsedBundle("default", bundle),
 		// Set strict errors for built-in function errors (e.g. wrong operand types)
 		rego.StrictBuiltinErrors(true),
 	).PrepareForEval(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("preparing query: %w", err)
+		return rego.PreparedEvalQuery{}, fmt.Errorf("preparing query: %w", err)
 	}
-
-	return &RegoAuthorizer{
-		patchAllowQuery: patchAllowQuery,
-	}, nil
+	return q, nil
 }
 
-type patchInput struct {
-	Repo         string       `json:"repo"`
-	PatchRequest patchRequest `json:"patchRequest"`
-	AuthCtx      AuthCtx      `json:"authCtx"`
+func (r *RegoAuthorizer) Authorize(ctx context.Context, input authorizationInput) error {
+	policy := r.policy.Load()
+	query, exists := policy.actionQueries[input.Action]
+	if !exists {
+		return fmt.Errorf("unknown action %q", input.Action)
+	}
+	if input.Action.deniedByDefault() && !policy.packages[input.Action.packagePath()] {
+		return authorizerViolationsError{fmt.Sprintf("policy does not define package %s, %s requests are denied", input.Action.packagePath(), input.Action)}
+	}
+	return evalViolations(ctx, query, input)
 }
 
-func (r *RegoAuthorizer) AllowPatch(ctx context.Context, authCtx AuthCtx, repo string, req patchRequest) error {
-	input := patchInput{
-		Repo:         repo,
-		PatchRequest: req,
-		AuthCtx:      authCtx,
-	}
+// CheckConfig evaluates the configuration requirements of the policy, the input is a summary of the configuration without secrets.
+func (r *RegoAuthorizer) CheckConfig(ctx context.Context, config Config) error {
+	return evalViolations(ctx, r.policy.Load().configCheckQuery, newConfigInput(config))
+}
 
-	results, err := r.patchAllowQuery.Eval(ctx, rego.EvalInput(input))
+func evalViolations(ctx context.Context, query rego.PreparedEvalQuery, input any) error {
+	results, err := query.Eval(ctx, rego.EvalInput(input))
 	if err != nil {
 		return fmt.Errorf("evaluating query: %w", err)
 	}
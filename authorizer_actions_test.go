@@ -0,0 +1,88 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_ActionPolicies(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), loadTestBundle(t, `
+package vignet.request.patch
+
+violations := set()
+`, `
+package vignet.request.tag
+import future.keywords
+
+violations contains msg if {
+	not startswith(input.tagRequest.name, "release/")
+	msg := sprintf("tag %s (annotated: %v) of %d commands not allowed", [input.tagRequest.name, input.tagRequest.annotated, count(input.patchRequest.commands)])
+}
+`, `
+package vignet.request.createBranch
+import future.keywords
+
+violations contains msg if {
+	not startswith(input.branchRequest.name, "deploy/")
+	msg := sprintf("branch %q to %q not allowed", [input.branchRequest.name, input.branchRequest.targetBranch])
+}
+`))
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	t.Run("denied tag and branch", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+			"commands": [
+				{"path": "release.yml", "setField": {"field": "foo", "value": "baz"}},
+				{"createTag": {"name": "v1.0.0", "message": "Release", "annotated": true}}
+			],
+			"mergeRequest": {"sourceBranch": "feature", "targetBranch": "main"}
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "tag v1.0.0 (annotated: true) of 2 commands not allowed")
+		require.Contains(t, rec.Body.String(), `branch "feature" to "main" not allowed`)
+	})
+
+	t.Run("allowed tag", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+			"commands": [
+				{"path": "release.yml", "setField": {"field": "foo", "value": "baz"}},
+				{"createTag": {"name": "release/v1.0.0"}}
+			]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, gitRepoHeadCommit(t, fs).Hash, gitRepoTagRef(t, fs, "release/v1.0.0"))
+	})
+
+	t.Run("denied read without read policy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "policy does not define package vignet.request.read")
+	})
+}
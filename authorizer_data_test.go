@@ -0,0 +1,45 @@
+package vignet_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestRegoAuthorizer_Data(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "data.yaml")
+	require.NoError(t, os.WriteFile(dataFile, []byte("teams:\n  platform:\n    repositories: [infra]\n"), 0644))
+
+	data, err := vignet.AuthorizationConfig{DataFile: dataFile}.LoadData()
+	require.NoError(t, err)
+
+	b := loadTestBundle(t, `
+package vignet.config
+import future.keywords
+
+violations contains msg if {
+	some name, team in data.teams
+	not "e2e-test" in team.repositories
+	msg := sprintf("repository e2e-test is not assigned to team %s", [name])
+}
+`)
+	ctx := context.Background()
+
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, b, vignet.WithRegoData(data))
+	require.NoError(t, err)
+	require.EqualError(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation: repository e2e-test is not assigned to team platform")
+
+	// Without data the rule is undefined
+	authorizer, err = vignet.NewRegoAuthorizer(ctx, b)
+	require.NoError(t, err)
+	require.NoError(t, authorizer.CheckConfig(ctx, vignet.Config{}))
+
+	b.Data = map[string]any{"teams": map[string]any{}}
+	_, err = vignet.NewRegoAuthorizer(ctx, b, vignet.WithRegoData(data))
+	require.EqualError(t, err, `data "teams" conflicts with data of the bundle`)
+}
@@ -0,0 +1,129 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OPAServerAuthorizer authorizes requests by querying the violations from a remote OPA server (or a compatible
+// service) via the data API instead of evaluating an embedded bundle.
+//
+// The server must serve a policy with the same packages and input as the embedded bundle.
+type OPAServerAuthorizer struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+var _ Authorizer = &OPAServerAuthorizer{}
+
+// NewOPAServerAuthorizer creates a new OPAServerAuthorizer for the base URL of an OPA server (e.g. "http://opa:8181").
+// If token is not empty, it is sent as bearer token.
+func NewOPAServerAuthorizer(baseURL string, token string, client *http.Client) *OPAServerAuthorizer {
+	return &OPAServerAuthorizer{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		token:  token,
+		client: client,
+	}
+}
+
+func (a *OPAServerAuthorizer) Authorize(ctx context.Context, input authorizationInput) error {
+	// The policy of the fallback is queried if the policy of the action is undefined
+	if fallback, ok := input.Action.fallback(); ok {
+		err := a.queryViolations(ctx, actionDataPath(input.Action), input, true)
+		var undefined undefinedViolationsError
+		if !errors.As(err, &undefined) {
+			return err
+		}
+		return a.queryViolations(ctx, actionDataPath(fallback), input, true)
+	}
+	// Created branches are denied if the policy is undefined, like by the RegoAuthorizer
+	if input.Action == ActionCreateBranch {
+		err := a.queryViolations(ctx, actionDataPath(input.Action), input, true)
+		var undefined undefinedViolationsError
+		if errors.As(err, &undefined) {
+			return authorizerViolationsError{fmt.Sprintf("%s, %s requests are denied", undefined.Error(), input.Action)}
+		}
+		return err
+	}
+	// Patch requests must never be allowed by a policy that is missing on the server
+	required := input.Action == ActionPatch
+	return a.queryViolations(ctx, actionDataPath(input.Action), input, required)
+}
+
+// actionDataPath returns the data path of the violations of an action (e.g. "vignet/request/patch/violations").
+func actionDataPath(action Action) string {
+	return strings.ReplaceAll(action.packagePath(), ".", "/") + "/violations"
+}
+
+// undefinedViolationsError is returned by queryViolations if the result of a required query is undefined.
+type undefinedViolationsError struct {
+	path string
+}
+
+func (e undefinedViolationsError) Error() string {
+	return fmt.Sprintf("policy on OPA server does not define %s", strings.ReplaceAll(e.path, "/", "."))
+}
+
+// CheckConfig evaluates the configuration requirements of the policy, the input is a summary of the configuration without secrets.
+func (a *OPAServerAuthorizer) CheckConfig(ctx context.Context, config Config) error {
+	return a.queryViolations(ctx, "vignet/config/violations", newConfigInput(config), false)
+}
+
+// queryViolations queries the violations at the data path with the input.
+// If required is set, an undefined result is an error instead of no violations.
+func (a *OPAServerAuthorizer) queryViolations(ctx context.Context, path string, input any, required bool) error {
+	body, err := json.Marshal(struct {
+		Input any `json:"input"`
+	}{Input: input})
+	if err != nil {
+		return fmt.Errorf("encoding input: %w", err)
+	}
+
+	u, err := url.JoinPath(a.url, "v1/data", path)
+	if err != nil {
+		return fmt.Errorf("building URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying OPA server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("querying OPA server: unexpected status code %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result *[]string `json:"result"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("decoding OPA server response: %w", err)
+	}
+	if result.Result == nil {
+		if required {
+			return undefinedViolationsError{path: path}
+		}
+		return nil
+	}
+	if len(*result.Result) == 0 {
+		return nil
+	}
+
+	return authorizerViolationsError(*result.Result)
+}
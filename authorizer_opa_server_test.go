@@ -0,0 +1,154 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestOPAServerAuthorizer(t *testing.T) {
+	var (
+		mx     sync.Mutex
+		inputs = make(map[string]map[string]any)
+	)
+	// results by data path, a missing path is an undefined result
+	results := map[string][]string{
+		"/v1/data/vignet/request/patch/violations": {"path is not allowed"},
+		"/v1/data/vignet/config/violations":        {},
+	}
+
+	opaSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body struct {
+			Input map[string]any `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mx.Lock()
+		inputs[r.URL.Path] = body.Input
+		mx.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if result, ok := results[r.URL.Path]; ok {
+			_ = json.NewEncoder(w).Encode(map[string]any{"result": result})
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer opaSrv.Close()
+
+	config := vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: "http://localhost"},
+		},
+		Authorization: vignet.AuthorizationConfig{
+			OPAServer: &vignet.OPAServerAuthorizationConfig{URL: opaSrv.URL, Token: "s3cr3t"},
+		},
+	}
+	require.NoError(t, config.Authorization.OPAServer.Valid())
+	authorizer := config.Authorization.OPAServer.Build()
+
+	t.Run("self-test", func(t *testing.T) {
+		require.NoError(t, vignet.SelfTest(context.Background(), authorizer, config))
+
+		mx.Lock()
+		defer mx.Unlock()
+		assert.Contains(t, inputs["/v1/data/vignet/config/violations"], "repositories")
+	})
+
+	t.Run("patch request with violations", func(t *testing.T) {
+		handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		}}, authorizer, config)
+
+		req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+			"commands": [{"path": "my-group/other-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "path is not allowed")
+
+		mx.Lock()
+		defer mx.Unlock()
+		input := inputs["/v1/data/vignet/request/patch/violations"]
+		assert.Equal(t, "infra", input["repo"])
+		assert.Equal(t, "my-group/my-project", input["authCtx"].(map[string]any)["gitLabClaims"].(map[string]any)["project_path"])
+	})
+
+	t.Run("values of SOPS encrypted fields are redacted", func(t *testing.T) {
+		handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		}}, authorizer, config)
+
+		req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/secret.enc.yaml", "setField": {"field": "password", "value": "n3w-s3cr3t", "sops": true}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+		mx.Lock()
+		defer mx.Unlock()
+		b, err := json.Marshal(inputs["/v1/data/vignet/request/patch/violations"])
+		require.NoError(t, err)
+		assert.Contains(t, string(b), `"sops":true`)
+		assert.NotContains(t, string(b), "n3w-s3cr3t")
+	})
+
+	t.Run("preview falls back to patch violations", func(t *testing.T) {
+		handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		}}, authorizer, config)
+
+		req := httptest.NewRequest("POST", "/patch/infra/preview", strings.NewReader(`{
+			"commands": [{"path": "my-group/other-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "path is not allowed")
+
+		mx.Lock()
+		defer mx.Unlock()
+		assert.Contains(t, inputs, "/v1/data/vignet/request/preview/violations")
+		assert.Equal(t, "preview", inputs["/v1/data/vignet/request/patch/violations"]["action"])
+	})
+
+	t.Run("undefined createBranch violations deny merge requests", func(t *testing.T) {
+		handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		}}, authorizer, config)
+
+		req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+			"commands": [{"path": "my-group/other-project/release.yml", "setField": {"field": "foo", "value": "baz"}}],
+			"mergeRequest": {"sourceBranch": "main"}
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "createBranch requests are denied")
+	})
+
+	t.Run("undefined patch violations", func(t *testing.T) {
+		undefinedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		defer undefinedSrv.Close()
+
+		err := vignet.SelfTest(context.Background(), vignet.NewOPAServerAuthorizer(undefinedSrv.URL, "", http.DefaultClient), config)
+		require.ErrorContains(t, err, "policy on OPA server does not define vignet.request.patch.violations")
+	})
+}
@@ -0,0 +1,257 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// RepositoryRulesConfig are declarative authorization rules for patch requests on a repository, as an alternative to
+// writing a Rego policy.
+type RepositoryRulesConfig struct {
+	// Paths are glob patterns of files that may be patched or read (e.g. "apps/**/*.yaml"), all paths are allowed if empty.
+	Paths []string `yaml:"paths"`
+	// Commands are the allowed command types (e.g. "setField"), all commands are allowed if empty.
+	Commands []Feature `yaml:"commands"`
+	// RequireProtectedRef only allows requests from pipelines of protected refs (GitLab claim ref_protected).
+	RequireProtectedRef bool `yaml:"requireProtectedRef"`
+	// ProjectDirectories maps GitLab project paths to the directory the project may patch, the key "*" applies to all
+	// other projects. "{projectPath}" in a directory is replaced with the project path.
+	// If set, requests without GitLab claims and of projects without a directory are denied.
+	ProjectDirectories map[string]string `yaml:"projectDirectories"`
+}
+
+func (c RepositoryRulesConfig) Valid() error {
+	for i, pattern := range c.Paths {
+		if _, err := glob.Compile(pattern, '/'); err != nil {
+			return fmt.Errorf("invalid paths[%d]: %w", i, err)
+		}
+	}
+	for i, command := range c.Commands {
+		if !isCommandFeature(command) {
+			return fmt.Errorf("invalid commands[%d]: unknown command %q", i, command)
+		}
+	}
+	for projectPath, dir := range c.ProjectDirectories {
+		if strings.Trim(dir, "/") == "" {
+			return fmt.Errorf("invalid projectDirectories[%q]: directory must be set", projectPath)
+		}
+	}
+	return nil
+}
+
+func isCommandFeature(feature Feature) bool {
+	for _, f := range commandFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// RulesAuthorizer authorizes requests by declarative rules per repository (see RepositoryRulesConfig), so simple
+// installations don't need to write Rego. Patch requests on repositories without rules are denied.
+type RulesAuthorizer struct {
+	rules map[string]repositoryRules
+}
+
+var _ Authorizer = &RulesAuthorizer{}
+
+type repositoryRules struct {
+	RepositoryRulesConfig
+	paths []glob.Glob
+}
+
+// NewRulesAuthorizer creates a new RulesAuthorizer with rules by repository name.
+func NewRulesAuthorizer(rules map[string]RepositoryRulesConfig) (*RulesAuthorizer, error) {
+	a := &RulesAuthorizer{
+		rules: make(map[string]repositoryRules, len(rules)),
+	}
+	for repo, config := range rules {
+		if err := config.Valid(); err != nil {
+			return nil, fmt.Errorf("invalid rules for repository %q: %w", repo, err)
+		}
+		compiled := repositoryRules{RepositoryRulesConfig: config}
+		for _, pattern := range config.Paths {
+			compiled.paths = append(compiled.paths, glob.MustCompile(pattern, '/'))
+		}
+		a.rules[repo] = compiled
+	}
+	return a, nil
+}
+
+func (a *RulesAuthorizer) Authorize(_ context.Context, input authorizationInput) error {
+	switch input.Action {
+	case ActionPatch, ActionPreview, ActionRevert:
+		return a.allowPatch(input.AuthCtx, input.Repo, *input.PatchRequest, input.Current)
+	case ActionRead:
+		return a.allowRead(input.AuthCtx, input.Repo, *input.ReadRequest)
+	case ActionTag:
+		// Tags are covered by the rules of the patch request they are part of
+		return nil
+	case ActionCreateBranch:
+		return a.allowBranch(input.AuthCtx, input.Repo, *input.BranchRequest)
+	default:
+		return fmt.Errorf("unknown action %q", input.Action)
+	}
+}
+
+// repositoryRules returns the rules of a repository, rules of a repository template apply to all matching repositories.
+func (a *RulesAuthorizer) repositoryRules(repo string) (repositoryRules, bool) {
+	key, exists := lookupRepositoryKey(a.rules, repo)
+	if !exists {
+		return repositoryRules{}, false
+	}
+	return a.rules[key], true
+}
+
+func (a *RulesAuthorizer) allowPatch(authCtx AuthCtx, repo string, req patchRequest, current []currentState) error {
+	rules, exists := a.repositoryRules(repo)
+	if !exists {
+		return authorizerViolationsError{fmt.Sprintf("no rules configured for repository %q", repo)}
+	}
+
+	var violations []string
+	if rules.RequireProtectedRef && (authCtx.GitLabClaims == nil || authCtx.GitLabClaims.RefProtected != "true") {
+		violations = append(violations, "requests are only allowed from protected refs")
+	}
+
+	dir, dirViolation := rules.projectDirectory(authCtx)
+	if dirViolation != "" {
+		violations = append(violations, dirViolation)
+	}
+
+	for i, cmd := range req.Commands {
+		if !rules.allowsCommand(cmd.feature()) {
+			violations = append(violations, fmt.Sprintf("command %s is not allowed", cmd.feature()))
+		}
+
+		if cmd.CreateTag != nil {
+			if dir != "" && !strings.HasPrefix(cmd.CreateTag.Name, dir) {
+				violations = append(violations, fmt.Sprintf("tag %q is not prefixed with project directory %q", cmd.CreateTag.Name, dir))
+			}
+			continue
+		}
+
+		// Files read by the command (e.g. the source of copyFile) are restricted like the patched file, files below
+		// directories are only known after cloning
+		paths := append([]string{cmd.Path}, cmd.readPaths()...)
+		if i < len(current) {
+			paths = append(paths, current[i].Files...)
+		}
+		for _, p := range paths {
+			if !rules.allowsPath(p) {
+				violations = append(violations, fmt.Sprintf("path %q is not allowed", p))
+			}
+			if dir != "" && !strings.HasPrefix(p, dir) {
+				violations = append(violations, fmt.Sprintf("path %q is not in project directory %q", p, dir))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return authorizerViolationsError(violations)
+	}
+	return nil
+}
+
+// allowBranch allows source branches derived from the commit hash or prefixed with "vignet/", with project directories
+// a branch can also be prefixed with the directory of the project. Other branches could be existing (e.g. protected)
+// branches of the repository.
+func (a *RulesAuthorizer) allowBranch(authCtx AuthCtx, repo string, req branchRequest) error {
+	rules, exists := a.repositoryRules(repo)
+	if !exists {
+		return authorizerViolationsError{fmt.Sprintf("no rules configured for repository %q", repo)}
+	}
+	if req.Name == "" || strings.HasPrefix(req.Name, "vignet/") {
+		return nil
+	}
+	dir, dirViolation := rules.projectDirectory(authCtx)
+	if dirViolation != "" {
+		return authorizerViolationsError{dirViolation}
+	}
+	if dir != "" && strings.HasPrefix(req.Name, dir) {
+		return nil
+	}
+	if dir != "" {
+		return authorizerViolationsError{fmt.Sprintf("branch %q is not prefixed with \"vignet/\" or project directory %q", req.Name, dir)}
+	}
+	return authorizerViolationsError{fmt.Sprintf("branch %q is not prefixed with \"vignet/\"", req.Name)}
+}
+
+// projectDirectory returns the directory (with trailing slash) of the GitLab project of the caller, or an empty
+// string if ProjectDirectories is not set. A violation is returned if the caller has no directory.
+func (r repositoryRules) projectDirectory(authCtx AuthCtx) (dir string, violation string) {
+	if len(r.ProjectDirectories) == 0 {
+		return "", ""
+	}
+	if authCtx.GitLabClaims == nil || authCtx.GitLabClaims.ProjectPath == "" {
+		return "", "requests without GitLab claims are not allowed by the project directories"
+	}
+
+	projectPath := authCtx.GitLabClaims.ProjectPath
+	dir, exists := r.ProjectDirectories[projectPath]
+	if !exists {
+		dir, exists = r.ProjectDirectories["*"]
+	}
+	if !exists {
+		return "", fmt.Sprintf("no project directory configured for GitLab project path %q", projectPath)
+	}
+	dir = strings.ReplaceAll(dir, "{projectPath}", projectPath)
+	return strings.TrimSuffix(dir, "/") + "/", ""
+}
+
+func (r repositoryRules) allowsCommand(feature Feature) bool {
+	if len(r.Commands) == 0 {
+		return true
+	}
+	for _, f := range r.Commands {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (r repositoryRules) allowsPath(path string) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+	for _, g := range r.paths {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *RulesAuthorizer) allowRead(authCtx AuthCtx, repo string, req readRequest) error {
+	switch req.Resource {
+	case "promotions", "history":
+		return nil
+	case "file":
+		// Files can be read with the same paths that can be patched
+		rules, exists := a.repositoryRules(repo)
+		if !exists {
+			return authorizerViolationsError{fmt.Sprintf("no rules configured for repository %q", repo)}
+		}
+		var violations []string
+		if !rules.allowsPath(req.Path) {
+			violations = append(violations, fmt.Sprintf("path %q is not allowed", req.Path))
+		}
+		dir, dirViolation := rules.projectDirectory(authCtx)
+		if dirViolation != "" {
+			violations = append(violations, dirViolation)
+		} else if dir != "" && !strings.HasPrefix(req.Path, dir) {
+			violations = append(violations, fmt.Sprintf("path %q is not in project directory %q", req.Path, dir))
+		}
+		if len(violations) > 0 {
+			return authorizerViolationsError(violations)
+		}
+		return nil
+	default:
+		return authorizerViolationsError{fmt.Sprintf("resource %q cannot be read", req.Resource)}
+	}
+}
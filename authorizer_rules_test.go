@@ -0,0 +1,185 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestRulesAuthorizer(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"apps/my-group/my-project/release.yml": "image:\n  tag: 1.2.0\n",
+		"apps/other/release.yml":               "image:\n  tag: 1.2.0\n",
+		"README.md":                            "# Infra\n",
+	}, gitserver.Options{})
+
+	authorizer, err := vignet.NewRulesAuthorizer(map[string]vignet.RepositoryRulesConfig{
+		"e2e-test": {
+			Paths:               []string{"apps/**/*.{yml,yaml}"},
+			Commands:            []vignet.Feature{vignet.FeatureSetField, vignet.FeatureCreateTag},
+			RequireProtectedRef: true,
+			ProjectDirectories: map[string]string{
+				"*": "apps/{projectPath}",
+			},
+		},
+		"templated/*": {
+			Paths:    []string{"apps/**/*.{yml,yaml}"},
+			Commands: []vignet.Feature{vignet.FeatureSetField},
+		},
+		"directories": {
+			Paths: []string{"apps/*"},
+		},
+	})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name           string
+		claims         *vignet.GitLabClaims
+		repo           string
+		command        string
+		mergeRequest   string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "allowed",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unprotected ref",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "false"},
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "requests are only allowed from protected refs",
+		},
+		{
+			name:           "other project directory",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:        `{"path": "apps/other/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `path \"apps/other/release.yml\" is not in project directory \"apps/my-group/my-project/\"`,
+		},
+		{
+			name:           "command and path not allowed",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:        `{"path": "apps/my-group/my-project/README.md", "createFile": {"content": "foo"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `command createFile is not allowed\n- path \"apps/my-group/my-project/README.md\" is not allowed`,
+		},
+		{
+			name:           "read path not allowed",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "valueFrom": {"path": "apps/my-group/my-project/values.json", "field": "tag"}}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `path \"apps/my-group/my-project/values.json\" is not allowed`,
+		},
+		{
+			name:           "file below directory not allowed",
+			repo:           "directories",
+			command:        `{"path": "apps/copy", "copyFile": {"from": "apps/my-group"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `path \"apps/my-group/my-project/release.yml\" is not allowed\n- path \"apps/copy/my-project/release.yml\" is not allowed`,
+		},
+		{
+			name:           "missing GitLab claims",
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "requests without GitLab claims are not allowed by the project directories",
+		},
+		{
+			name:           "repository without rules",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			repo:           "other",
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `no rules configured for repository \"other\"`,
+		},
+		{
+			name:           "repository matching template",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+			repo:           "templated%2Fproject",
+			command:        `{"path": "apps/other/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:         "branch prefixed with project directory",
+			claims:       &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:      `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			mergeRequest: `{"sourceBranch": "apps/my-group/my-project/bump"}`,
+			// The branch is authorized, the request fails later without a provider
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "a provider must be configured",
+		},
+		{
+			name:           "protected branch not allowed",
+			claims:         &vignet.GitLabClaims{ProjectPath: "my-group/my-project", RefProtected: "true"},
+			command:        `{"path": "apps/my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			mergeRequest:   `{"sourceBranch": "main"}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  `branch \"main\" is not prefixed with \"vignet/\" or project directory \"apps/my-group/my-project/\"`,
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+				GitLabClaims: tc.claims,
+			}}, authorizer, vignet.Config{
+				Repositories: vignet.RepositoriesConfig{
+					"e2e-test":    {URL: gitSrv.URL},
+					"other":       {URL: gitSrv.URL},
+					"directories": {URL: gitSrv.URL},
+					"templated/*": {URL: gitSrv.URL + "/{path}"},
+				},
+			})
+
+			repo := tc.repo
+			if repo == "" {
+				repo = "e2e-test"
+			}
+			body := `{"commands": [` + tc.command + `]`
+			if tc.mergeRequest != "" {
+				body += `, "mergeRequest": ` + tc.mergeRequest
+			}
+			req := httptest.NewRequest("POST", "/patch/"+repo+"?dryRun=true", strings.NewReader(body+`}`))
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				assert.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestConfig_AuthorizationRules(t *testing.T) {
+	config := vignet.Config{
+		AuthenticationProvider: vignet.AuthenticationProviderConfig{
+			Type:   vignet.AuthenticationProviderGitLab,
+			GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.com"},
+		},
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: "http://localhost"},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	}
+
+	config.Authorization.Rules = map[string]vignet.RepositoryRulesConfig{
+		"e2e-test": {Commands: []vignet.Feature{"setFoo"}},
+	}
+	require.EqualError(t, config.Validate(), `invalid authorization.rules["e2e-test"]: invalid commands[0]: unknown command "setFoo"`)
+
+	config.Authorization.Rules = map[string]vignet.RepositoryRulesConfig{
+		"unknown": {},
+	}
+	require.EqualError(t, config.Validate(), `invalid authorization.rules["unknown"]: repository not configured`)
+}
@@ -0,0 +1,215 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/apex/log"
+)
+
+// batchPatchRequest patches multiple repositories in one request.
+type batchPatchRequest struct {
+	// Patches are applied in order, each is authorized and committed separately.
+	Patches []batchPatchRequestEntry `json:"patches"`
+	// OnFailure is the behavior if a patch fails, defaults to batchBestEffort.
+	OnFailure batchFailureMode `json:"onFailure"`
+}
+
+// batchFailureMode is the behavior of a batch if a patch fails.
+type batchFailureMode string
+
+const (
+	// batchBestEffort applies the following patches.
+	batchBestEffort batchFailureMode = "bestEffort"
+	// batchFailFast skips the following patches.
+	batchFailFast batchFailureMode = "failFast"
+	// batchRollback skips the following patches and reverts the commits of the applied patches.
+	batchRollback batchFailureMode = "rollback"
+)
+
+// batchPatchOutcome is the outcome of a patch of a batch.
+type batchPatchOutcome string
+
+const (
+	batchOutcomeApplied        batchPatchOutcome = "applied"
+	batchOutcomeFailed         batchPatchOutcome = "failed"
+	batchOutcomeSkipped        batchPatchOutcome = "skipped"
+	batchOutcomeRolledBack     batchPatchOutcome = "rolledBack"
+	batchOutcomeRollbackFailed batchPatchOutcome = "rollbackFailed"
+)
+
+// batchPatchRequestEntry is a patch request for a repository of a batch.
+type batchPatchRequestEntry struct {
+	// Repo is the name of the repository to patch.
+	Repo string `json:"repo"`
+	patchRequest
+}
+
+func (r batchPatchRequest) Validate() error {
+	if len(r.Patches) == 0 {
+		return fmt.Errorf("'patches' must not be empty")
+	}
+	for i, p := range r.Patches {
+		if p.Repo == "" {
+			return fmt.Errorf("'patches[%d].repo' must be set", i)
+		}
+	}
+	switch r.OnFailure {
+	case "", batchBestEffort, batchFailFast, batchRollback:
+	default:
+		return fmt.Errorf("'onFailure' must be one of %q, %q or %q", batchBestEffort, batchFailFast, batchRollback)
+	}
+	return nil
+}
+
+// commandCount returns the number of commands of all patches.
+func (r batchPatchRequest) commandCount() int {
+	n := 0
+	for _, p := range r.Patches {
+		n += len(p.Commands)
+	}
+	return n
+}
+
+type batchPatchResponse struct {
+	// OnFailure is the behavior that was applied if a patch failed.
+	OnFailure batchFailureMode `json:"onFailure"`
+	// Results contains a result for each patch in the order of the request.
+	Results []batchPatchResult `json:"results"`
+}
+
+// batchPatchResult is the result of a patch of a batch, either Patch or Error is set.
+type batchPatchResult struct {
+	Repo string `json:"repo"`
+	// Status is the status code the patch would have as a single request.
+	Status  int               `json:"status"`
+	Outcome batchPatchOutcome `json:"outcome"`
+	Patch   *patchResponse    `json:"patch,omitempty"`
+	Error   *errorResponse    `json:"error,omitempty"`
+	// RevertCommit is the hash of the commit that reverted the patch if it was rolled back.
+	RevertCommit string `json:"revertCommit,omitempty"`
+	// RollbackError is set if the patch could not be rolled back.
+	RollbackError *errorResponse `json:"rollbackError,omitempty"`
+}
+
+// batchPatch applies patches to multiple repositories and responds with the result of each patch (multi-status).
+// By default a failed patch does not stop the following patches, with failFast the following patches are skipped and
+// with rollback the commits of the applied patches are reverted as well.
+func (h *Handler) batchPatch(w http.ResponseWriter, r *http.Request) {
+	var req batchPatchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.Limits.maxBodySize())
+	if err := h.newBodyDecoder(r.Body).Decode(&req); err != nil {
+		log.WithError(err).Warn("Invalid JSON in request body")
+		respondError(w, r, "Invalid JSON in body", bodyError(err))
+		return
+	}
+	if dryRun := r.URL.Query().Get("dryRun"); dryRun != "" {
+		v, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("invalid 'dryRun': %w", err), http.StatusBadRequest})
+			return
+		}
+		for i := range req.Patches {
+			req.Patches[i].DryRun = req.Patches[i].DryRun || v
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		log.WithError(err).Warn("Invalid batch patch request")
+		respondError(w, r, "Validation of request failed", clientError{err, http.StatusBadRequest})
+		return
+	}
+	// The limit applies to all commands of the batch, so a batch is not more expensive than a single request
+	if maxCommands := h.config.Limits.maxCommands(); req.commandCount() > maxCommands {
+		err := fmt.Errorf("'patches' exceeds the limit of %d commands", maxCommands)
+		log.WithError(err).Warn("Batch patch request exceeds limits")
+		respondError(w, r, "Request too large", clientError{err, http.StatusRequestEntityTooLarge})
+		return
+	}
+
+	if req.OnFailure == "" {
+		req.OnFailure = batchBestEffort
+	}
+
+	res := batchPatchResponse{
+		OnFailure: req.OnFailure,
+		Results:   make([]batchPatchResult, 0, len(req.Patches)),
+	}
+	failed := -1
+	for i, p := range req.Patches {
+		result := batchPatchResult{
+			Repo:    p.Repo,
+			Status:  http.StatusOK,
+			Outcome: batchOutcomeApplied,
+		}
+		if failed >= 0 && req.OnFailure != batchBestEffort {
+			status, errRes := newErrorResponse("Skipped", clientError{fmt.Errorf("skipped because patches[%d] failed", failed), http.StatusFailedDependency})
+			result.Status = status
+			result.Outcome = batchOutcomeSkipped
+			result.Error = &errRes
+			res.Results = append(res.Results, result)
+			continue
+		}
+
+		var (
+			patchRes *patchResponse
+			cause    string
+		)
+		err := h.takeRepositoryToken(p.Repo)
+		if err != nil {
+			cause = "Rate limit exceeded"
+		} else {
+			patchRes, cause, err = h.applyPatch(r, p.Repo, p.patchRequest, ActionPatch)
+		}
+		if err != nil {
+			status, errRes := newErrorResponse(cause, err)
+			result.Status = status
+			result.Outcome = batchOutcomeFailed
+			result.Error = &errRes
+			if failed < 0 {
+				failed = i
+			}
+		} else {
+			result.Patch = patchRes
+		}
+		res.Results = append(res.Results, result)
+	}
+
+	if failed >= 0 && req.OnFailure == batchRollback {
+		h.rollbackBatch(r, req, res.Results)
+	}
+
+	respondNegotiated(w, r, http.StatusMultiStatus, &res)
+}
+
+// rollbackBatch reverts the commits of applied patches in reverse order, so patches of the same repository are
+// reverted on top of each other. The rollback is detached from the request, so it is completed even if the client
+// disconnected or the request timed out while applying the patches.
+func (h *Handler) rollbackBatch(r *http.Request, req batchPatchRequest, results []batchPatchResult) {
+	ctx, cancel := context.WithTimeout(detachedContext{r.Context()}, h.config.Timeouts.request())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	for i := len(results) - 1; i >= 0; i-- {
+		result := &results[i]
+		if result.Outcome != batchOutcomeApplied || result.Patch.DryRun || result.Patch.Commit == "" {
+			continue
+		}
+		revertCommit, err := h.revertPatch(r, result.Repo, req.Patches[i].patchRequest, result.Patch)
+		if err != nil {
+			log.
+				WithField("repo", result.Repo).
+				WithField("commitHash", result.Patch.Commit).
+				WithError(err).
+				Error("Failed to roll back patch of batch")
+			_, errRes := newErrorResponse("Rollback failed", err)
+			result.Outcome = batchOutcomeRollbackFailed
+			result.RollbackError = &errRes
+			continue
+		}
+		result.Outcome = batchOutcomeRolledBack
+		result.RevertCommit = revertCommit
+	}
+}
@@ -0,0 +1,338 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_BatchPatch(t *testing.T) {
+	infraFs, infraSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+	}, gitserver.Options{})
+	appsFs, appsSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/values.yml": "replicas: 1\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: infraSrv.URL},
+			"apps":  {URL: appsSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+
+	t.Run("multi-status", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch", strings.NewReader(`{
+			"patches": [
+				{
+					"repo": "infra",
+					"commit": {"message": "Release 1.1.0"},
+					"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]
+				},
+				{
+					"repo": "unknown",
+					"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]
+				},
+				{
+					"repo": "apps",
+					"commands": [{"path": "my-group/other-project/values.yml", "setField": {"field": "replicas", "value": 2}}]
+				},
+				{
+					"repo": "apps",
+					"commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 2}}]
+				}
+			]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []struct {
+				Repo   string `json:"repo"`
+				Status int    `json:"status"`
+				Patch  *struct {
+					Commit string `json:"commit"`
+				} `json:"patch"`
+				Error *struct {
+					Cause string `json:"cause"`
+					Error string `json:"error"`
+				} `json:"error"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Results, 4)
+
+		assert.Equal(t, "infra", res.Results[0].Repo)
+		assert.Equal(t, http.StatusOK, res.Results[0].Status)
+		require.NotNil(t, res.Results[0].Patch)
+		assert.Equal(t, gitRepoHeadCommit(t, infraFs).Hash.String(), res.Results[0].Patch.Commit)
+		assertGitRepoHeadCommit(t, infraFs, "Release 1.1.0")
+
+		assert.Equal(t, http.StatusNotFound, res.Results[1].Status)
+		require.NotNil(t, res.Results[1].Error)
+		assert.Equal(t, "Unknown repository", res.Results[1].Error.Cause)
+
+		assert.Equal(t, http.StatusForbidden, res.Results[2].Status)
+		require.NotNil(t, res.Results[2].Error)
+		assert.Contains(t, res.Results[2].Error.Error, `path "my-group/other-project/values.yml" is not a prefix of GitLab project path`)
+
+		assert.Equal(t, http.StatusOK, res.Results[3].Status)
+		assertGitRepoHeadCommit(t, appsFs, "Automated patch by vignet")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			payload        string
+			expectedStatus int
+			expectedError  string
+		}{
+			{
+				name:           "no patches",
+				payload:        `{"patches": []}`,
+				expectedStatus: http.StatusBadRequest,
+				expectedError:  "'patches' must not be empty",
+			},
+			{
+				name:           "missing repo",
+				payload:        `{"patches": [{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "bar"}}]}]}`,
+				expectedStatus: http.StatusBadRequest,
+				expectedError:  "'patches[0].repo' must be set",
+			},
+			{
+				name:           "unknown field",
+				payload:        `{"patches": [{"repo": "infra", "foo": true}]}`,
+				expectedStatus: http.StatusBadRequest,
+				expectedError:  `unknown field "foo"`,
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				req := httptest.NewRequest("POST", "/patch", strings.NewReader(tc.payload))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+			})
+		}
+	})
+}
+
+func TestHandler_BatchPatch_OnFailure(t *testing.T) {
+	type result struct {
+		Repo          string `json:"repo"`
+		Status        int    `json:"status"`
+		Outcome       string `json:"outcome"`
+		RevertCommit  string `json:"revertCommit"`
+		RollbackError *struct {
+			Error string `json:"error"`
+		} `json:"rollbackError"`
+	}
+
+	setup := func(t *testing.T) (billy.Filesystem, billy.Filesystem, *vignet.Handler) {
+		infraFs, infraSrv := startMockHttpGitServer(t, map[string]string{
+			"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+			"my-group/my-project/old.yml":     "foo: bar\n",
+			"my-group/my-project/deploy.sh":   "#!/bin/sh\n",
+		}, gitserver.Options{})
+		appsFs, appsSrv := startMockHttpGitServer(t, map[string]string{
+			"my-group/my-project/values.yml": "replicas: 1\n",
+		}, gitserver.Options{})
+
+		handler := newTestHandler(t, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"infra": {URL: infraSrv.URL},
+				"apps":  {URL: appsSrv.URL},
+			},
+			Commit: vignet.CommitConfig{
+				DefaultMessage: "Automated patch by vignet",
+			},
+		})
+		return infraFs, appsFs, handler
+	}
+	batch := func(t *testing.T, handler *vignet.Handler, onFailure string) (string, []result) {
+		req := httptest.NewRequest("POST", "/patch", strings.NewReader(`{
+			"onFailure": "`+onFailure+`",
+			"patches": [
+				{
+					"repo": "infra",
+					"commit": {"message": "Release 1.1.0"},
+					"commands": [
+						{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}},
+						{"path": "my-group/my-project/new.yml", "createFile": {"content": "foo: baz\n"}},
+						{"path": "my-group/my-project/old.yml", "deleteFile": {}}
+					]
+				},
+				{
+					"repo": "apps",
+					"commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 2}}]
+				},
+				{
+					"repo": "unknown",
+					"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]
+				},
+				{
+					"repo": "apps",
+					"commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 3}}]
+				}
+			]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			OnFailure string   `json:"onFailure"`
+			Results   []result `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Results, 4)
+		return res.OnFailure, res.Results
+	}
+	outcomes := func(results []result) []string {
+		var outcomes []string
+		for _, r := range results {
+			outcomes = append(outcomes, r.Outcome)
+		}
+		return outcomes
+	}
+
+	t.Run("bestEffort by default", func(t *testing.T) {
+		_, appsFs, handler := setup(t)
+
+		onFailure, results := batch(t, handler, "")
+		assert.Equal(t, "bestEffort", onFailure)
+		assert.Equal(t, []string{"applied", "applied", "failed", "applied"}, outcomes(results))
+		assertGitRepoContains(t, appsFs, map[string]fileExpectation{
+			"my-group/my-project/values.yml": content{"replicas: 3\n"},
+		})
+	})
+
+	t.Run("failFast", func(t *testing.T) {
+		_, appsFs, handler := setup(t)
+
+		onFailure, results := batch(t, handler, "failFast")
+		assert.Equal(t, "failFast", onFailure)
+		assert.Equal(t, []string{"applied", "applied", "failed", "skipped"}, outcomes(results))
+		assert.Equal(t, http.StatusFailedDependency, results[3].Status)
+		assertGitRepoContains(t, appsFs, map[string]fileExpectation{
+			"my-group/my-project/values.yml": content{"replicas: 2\n"},
+		})
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		infraFs, appsFs, handler := setup(t)
+
+		onFailure, results := batch(t, handler, "rollback")
+		assert.Equal(t, "rollback", onFailure)
+		assert.Equal(t, []string{"rolledBack", "rolledBack", "failed", "skipped"}, outcomes(results))
+
+		infraHead := gitRepoHeadCommit(t, infraFs)
+		assert.Equal(t, results[0].RevertCommit, infraHead.Hash.String())
+		assert.Contains(t, infraHead.Message, `Revert "Release 1.1.0"`)
+		assertGitRepoContains(t, infraFs, map[string]fileExpectation{
+			"my-group/my-project/release.yml": content{"image:\n  tag: 1.0.0\n"},
+			"my-group/my-project/old.yml":     content{"foo: bar\n"},
+			"my-group/my-project/new.yml":     deleted{},
+		})
+		assert.Equal(t, results[1].RevertCommit, gitRepoHeadCommit(t, appsFs).Hash.String())
+		assertGitRepoContains(t, appsFs, map[string]fileExpectation{
+			"my-group/my-project/values.yml": content{"replicas: 1\n"},
+		})
+	})
+
+	t.Run("rollback of mode change", func(t *testing.T) {
+		infraFs, _, handler := setup(t)
+
+		req := httptest.NewRequest("POST", "/patch", strings.NewReader(`{
+			"onFailure": "rollback",
+			"patches": [
+				{"repo": "infra", "commands": [{"path": "my-group/my-project/deploy.sh", "setMode": {"mode": "0755"}}]},
+				{"repo": "unknown", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]}
+			]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []result `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, []string{"rolledBack", "failed"}, outcomes(res.Results))
+		assertGitRepoContains(t, infraFs, map[string]fileExpectation{
+			"my-group/my-project/deploy.sh": mode{0644},
+		})
+	})
+
+	t.Run("rollback denied by policy", func(t *testing.T) {
+		_, appsSrv := startMockHttpGitServer(t, map[string]string{
+			"my-group/my-project/values.yml": "replicas: 1\n",
+		}, gitserver.Options{})
+
+		b := loadTestBundle(t, `
+package vignet.request.patch
+import future.keywords
+
+violations contains msg if {
+	false
+	msg := ""
+}
+`, `
+package vignet.request.revert
+import future.keywords
+
+violations contains "reverts are not allowed"
+`)
+		authorizer, err := vignet.NewRegoAuthorizer(context.Background(), b)
+		require.NoError(t, err)
+		handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"apps": {URL: appsSrv.URL},
+			},
+		})
+
+		req := httptest.NewRequest("POST", "/patch", strings.NewReader(`{
+			"onFailure": "rollback",
+			"patches": [
+				{"repo": "apps", "commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 2}}]},
+				{"repo": "unknown", "commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 3}}]}
+			]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []result `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, []string{"rollbackFailed", "failed"}, outcomes(res.Results))
+		require.NotNil(t, res.Results[0].RollbackError)
+		assert.Contains(t, res.Results[0].RollbackError.Error, "reverts are not allowed")
+	})
+
+	t.Run("invalid onFailure", func(t *testing.T) {
+		_, _, handler := setup(t)
+
+		req := httptest.NewRequest("POST", "/patch", strings.NewReader(`{"onFailure": "retry", "patches": [{"repo": "infra"}]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `'onFailure' must be one of`)
+	})
+}
@@ -0,0 +1,72 @@
+package vignet_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/internal/bench"
+)
+
+func BenchmarkPatch(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	for _, files := range []int{10, 100, 1000} {
+		for _, dryRun := range []bool{false, true} {
+			b.Run(fmt.Sprintf("files=%d/dryRun=%t", files, dryRun), func(b *testing.B) {
+				opts := bench.DefaultOptions
+				opts.Files = files
+				opts.DryRun = dryRun
+
+				h, err := bench.NewHarness(context.Background(), opts)
+				require.NoError(b, err)
+				defer h.Close()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := h.Patch(context.Background())
+					require.NoError(b, err)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPatchConcurrent(b *testing.B) {
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	h, err := bench.NewHarness(context.Background(), bench.DefaultOptions)
+	require.NoError(b, err)
+	defer h.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := h.Patch(context.Background())
+			if err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func TestBenchHarness(t *testing.T) {
+	opts := bench.Options{
+		Files:       5,
+		FileSize:    256,
+		Requests:    6,
+		Concurrency: 3,
+	}
+
+	result, err := bench.Run(context.Background(), opts)
+	require.NoError(t, err)
+	require.NoError(t, result.FirstError)
+	require.Equal(t, 6, result.Requests)
+	require.Equal(t, 0, result.Errors)
+	require.Len(t, result.Latencies, 6)
+}
@@ -0,0 +1,85 @@
+package vignet
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// capabilitiesResponse describes what this instance supports, so clients can adapt to a deployment.
+type capabilitiesResponse struct {
+	Version string `json:"version"`
+	// APIVersions are the supported versions of the API, the last one is the latest.
+	APIVersions []APIVersion `json:"apiVersions"`
+	// Commands are the enabled command types of patch requests.
+	Commands []Feature `json:"commands"`
+	// Features are all enabled features (command types and subsystems).
+	Features []Feature `json:"features"`
+	// FileFormats are the formats of files that can be patched.
+	FileFormats []string `json:"fileFormats"`
+	// AuthenticationProviders are the types of the configured authentication providers.
+	AuthenticationProviders []AuthenticationProviderType `json:"authenticationProviders"`
+	Limits                  capabilitiesLimits           `json:"limits"`
+}
+
+type capabilitiesLimits struct {
+	// MaxBodySize is the maximum size of a request body in bytes.
+	MaxBodySize int64 `json:"maxBodySize"`
+	// MaxCommands is the maximum number of commands of a patch request.
+	MaxCommands int `json:"maxCommands"`
+	// MaxFileSize is the maximum size of a file patched by a command in bytes.
+	MaxFileSize int64 `json:"maxFileSize"`
+}
+
+func (h *Handler) capabilities(w http.ResponseWriter, r *http.Request) {
+	res := capabilitiesResponse{
+		Version:                 Version,
+		APIVersions:             APIVersions,
+		Commands:                h.config.Features.EnabledCommands(),
+		Features:                h.config.Features.EnabledFeatures(),
+		FileFormats:             []string{"yaml"},
+		AuthenticationProviders: []AuthenticationProviderType{},
+		Limits: capabilitiesLimits{
+			MaxBodySize: h.config.Limits.maxBodySize(),
+			MaxCommands: h.config.Limits.maxCommands(),
+			MaxFileSize: h.config.Limits.maxFileSize(),
+		},
+	}
+	if res.Commands == nil {
+		res.Commands = []Feature{}
+	}
+	if res.Features == nil {
+		res.Features = []Feature{}
+	}
+	// JSON files can only be patched by commands that patch fields
+	for _, feature := range []Feature{FeatureSetField, FeatureEnsureFields, FeatureAddToArray, FeatureJSONPatch, FeatureIncrementField, FeatureDeleteField} {
+		if h.config.Features.Enabled(feature) {
+			res.FileFormats = append(res.FileFormats, "json")
+			break
+		}
+	}
+	// TOML files can only be patched with setField
+	if h.config.Features.Enabled(FeatureSetField) {
+		res.FileFormats = append(res.FileFormats, "toml")
+	}
+	if h.config.Features.Enabled(FeatureSetProperty) {
+		res.FileFormats = append(res.FileFormats, "dotenv", "properties")
+	}
+	for _, p := range h.config.AuthenticationProviderConfigs() {
+		if !containsAuthenticationProviderType(res.AuthenticationProviders, p.Type) {
+			res.AuthenticationProviders = append(res.AuthenticationProviders, p.Type)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func containsAuthenticationProviderType(types []AuthenticationProviderType, t AuthenticationProviderType) bool {
+	for _, existing := range types {
+		if existing == t {
+			return true
+		}
+	}
+	return false
+}
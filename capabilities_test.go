@@ -0,0 +1,216 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_Capabilities(t *testing.T) {
+	config := vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: "http://localhost"},
+		},
+		Limits: vignet.LimitsConfig{
+			MaxCommands: 10,
+		},
+		Features: vignet.FeaturesConfig{
+			vignet.FeatureJSONPatch:       false,
+			vignet.FeatureMultipartUpload: false,
+		},
+	}
+	config.AuthenticationProvider.Type = vignet.AuthenticationProviderGitLab
+
+	// Authentication is not required
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, newDefaultAuthorizer(t), config)
+
+	req := httptest.NewRequest("GET", "/capabilities", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"version": "dev",
+		"apiVersions": ["v1"],
+		"commands": ["setField", "ensureFields", "addToArray", "createFile", "deleteFile", "createTag", "setProperty", "setMode", "createFromTemplate", "bumpChartVersion", "incrementField", "setLabel", "setAnnotation", "updateImageMarkers", "copyFile", "deleteField", "setComment"],
+		"features": ["setField", "ensureFields", "addToArray", "createFile", "deleteFile", "createTag", "setProperty", "setMode", "createFromTemplate", "bumpChartVersion", "incrementField", "setLabel", "setAnnotation", "updateImageMarkers", "copyFile", "deleteField", "setComment", "promotions", "readFile", "history"],
+		"fileFormats": ["yaml", "json", "toml", "dotenv", "properties"],
+		"authenticationProviders": ["gitlab"],
+		"limits": {
+			"maxBodySize": 33554432,
+			"maxCommands": 10,
+			"maxFileSize": 10485760
+		}
+	}`, rec.Body.String())
+}
+
+func TestHandler_Limits(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+		"my-group/my-project/large.yml":   "foo: " + strings.Repeat("a", 128),
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Limits: vignet.LimitsConfig{
+			MaxBodySize: 1024,
+			MaxCommands: 2,
+			MaxFileSize: 64,
+		},
+	})
+
+	setFieldCommand := `{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}`
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "body too large",
+			body:           `{"commands": [` + setFieldCommand + `], "commit": {"message": "` + strings.Repeat("a", 1024) + `"}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedError:  "body exceeds the limit of 1024 bytes",
+		},
+		{
+			name:           "too many commands",
+			body:           `{"commands": [` + strings.Join([]string{setFieldCommand, setFieldCommand, setFieldCommand}, ",") + `]}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedError:  "'commands' exceeds the limit of 2 commands",
+		},
+		{
+			name:           "file too large",
+			body:           `{"commands": [{"path": "my-group/my-project/large.yml", "setField": {"field": "foo", "value": "baz"}}], "dryRun": true}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  "is too large to patch (133 bytes), the maximum size is 64 bytes",
+		},
+		{
+			name:           "valueFrom file too large",
+			body:           `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "valueFrom": {"path": "my-group/my-project/large.yml", "field": "foo"}}}], "dryRun": true}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  `file "my-group/my-project/large.yml" is too large to read (133 bytes), the maximum size is 64 bytes`,
+		},
+		{
+			name:           "template file too large",
+			body:           `{"commands": [{"path": "my-group/my-project/new.yml", "createFromTemplate": {"templatePath": "my-group/my-project/large.yml"}}], "dryRun": true}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  `template "my-group/my-project/large.yml" is too large to read (133 bytes), the maximum size is 64 bytes`,
+		},
+		{
+			name:           "created file too large",
+			body:           `{"commands": [{"path": "my-group/my-project/new.txt", "createFile": {"content": "` + strings.Repeat("a", 65) + `"}}], "dryRun": true}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedError:  "'commands[0].createFile' content exceeds the limit of 64 bytes (65 bytes)",
+		},
+		{
+			name:           "created file within limits",
+			body:           `{"commands": [{"path": "my-group/my-project/new.txt", "createFile": {"content": "` + strings.Repeat("a", 64) + `"}}], "dryRun": true}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "rendered template too large",
+			body:           `{"commands": [{"path": "my-group/my-project/new.txt", "createFromTemplate": {"template": "{{ range .items }}{{ . }}{{ end }}", "values": {"items": ["` + strings.Repeat("a", 40) + `", "` + strings.Repeat("b", 40) + `"]}}}], "dryRun": true}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedError:  "rendered template exceeds the limit of 64 bytes",
+		},
+		{
+			name:           "within limits",
+			body:           `{"commands": [` + strings.Join([]string{setFieldCommand, setFieldCommand}, ",") + `], "dryRun": true}`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestHandler_AllowUnknownFields(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	authorizer := newDefaultAuthorizer(t)
+
+	newHandler := func(allowUnknownFields bool) *vignet.Handler {
+		return vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		}}, authorizer, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"e2e-test": {URL: gitSrv.URL},
+			},
+			HTTP: vignet.HTTPConfig{
+				AllowUnknownFields: allowUnknownFields,
+			},
+		})
+	}
+
+	patch := `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}], "dryRun": true, "unknown": true}`
+	batch := `{"patches": [{"repo": "e2e-test", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}], "unknown": true}`
+
+	tests := []struct {
+		name               string
+		path               string
+		body               string
+		allowUnknownFields bool
+		expectedStatus     int
+		expectedError      string
+	}{
+		{
+			name:           "patch rejects unknown fields by default",
+			path:           "/v1/patch/e2e-test",
+			body:           patch,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `unknown field "unknown"`,
+		},
+		{
+			name:               "patch ignores allowed unknown fields",
+			path:               "/v1/patch/e2e-test",
+			body:               patch,
+			allowUnknownFields: true,
+			expectedStatus:     http.StatusOK,
+		},
+		{
+			name:           "batch rejects unknown fields by default",
+			path:           "/v1/patch?dryRun=true",
+			body:           batch,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `unknown field "unknown"`,
+		},
+		{
+			name:               "batch ignores allowed unknown fields",
+			path:               "/v1/patch?dryRun=true",
+			body:               batch,
+			allowUnknownFields: true,
+			expectedStatus:     http.StatusMultiStatus,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			newHandler(tc.allowUnknownFields).ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				assert.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+}
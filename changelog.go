@@ -0,0 +1,296 @@
+package vignet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	goyaml "gopkg.in/yaml.v3"
+
+	"github.com/networkteam/vignet/properties"
+	"github.com/networkteam/vignet/semver"
+	"github.com/networkteam/vignet/toml"
+	"github.com/networkteam/vignet/yaml"
+)
+
+// ChangelogConfig enables writing a changelog fragment describing every patch to the repository.
+type ChangelogConfig struct {
+	// Directory for changelog fragments relative to the repository root, defaults to "changelog.d".
+	Directory string `yaml:"directory"`
+}
+
+const defaultChangelogDirectory = "changelog.d"
+
+func (c ChangelogConfig) Valid() error {
+	if c.Directory != "" && (path.IsAbs(c.Directory) || strings.HasPrefix(path.Clean(c.Directory), "..")) {
+		return fmt.Errorf("directory must be relative to the repository root")
+	}
+	return nil
+}
+
+func (c ChangelogConfig) directory() string {
+	if c.Directory == "" {
+		return defaultChangelogDirectory
+	}
+	return path.Clean(c.Directory)
+}
+
+// changelogEntry is written to a changelog fragment for each patch.
+type changelogEntry struct {
+	Time     time.Time         `yaml:"time"`
+	Message  string            `yaml:"message"`
+	Identity changelogIdentity `yaml:"identity,omitempty"`
+	Changes  []changelogChange `yaml:"changes"`
+}
+
+type changelogIdentity struct {
+	ProjectPath string `yaml:"projectPath,omitempty"`
+	UserLogin   string `yaml:"userLogin,omitempty"`
+	UserEmail   string `yaml:"userEmail,omitempty"`
+	PipelineID  string `yaml:"pipelineId,omitempty"`
+	JobID       string `yaml:"jobId,omitempty"`
+}
+
+type changelogChange struct {
+	Path     string `yaml:"path,omitempty"`
+	From     string `yaml:"from,omitempty"`
+	Command  string `yaml:"command"`
+	Tag      string `yaml:"tag,omitempty"`
+	Field    string `yaml:"field,omitempty"`
+	OldValue any    `yaml:"oldValue,omitempty"`
+	NewValue any    `yaml:"newValue,omitempty"`
+}
+
+func changelogIdentityFromAuthCtx(authCtx AuthCtx) changelogIdentity {
+	claims := authCtx.GitLabClaims
+	if claims == nil {
+		return changelogIdentity{}
+	}
+	return changelogIdentity{
+		ProjectPath: claims.ProjectPath,
+		UserLogin:   claims.UserLogin,
+		UserEmail:   claims.UserEmail,
+		PipelineID:  claims.PipelineID,
+		JobID:       claims.JobID,
+	}
+}
+
+// changelogChangesForCommand describes the changes of a command for the changelog and the command results. It must be called before the command is applied
+// to read the old values of fields.
+func changelogChangesForCommand(fs billy.Filesystem, cmd patchRequestCommand) []changelogChange {
+	if cmd.SetField != nil {
+		assignments := cmd.SetField.assignments()
+		changes := make([]changelogChange, len(assignments))
+		for i, a := range assignments {
+			if cmd.SetField.SOPS {
+				// Values of encrypted files must not be disclosed
+				changes[i] = changelogChange{Path: cmd.Path, Command: "setField", Field: a.Field}
+				continue
+			}
+			newValue := a.Value
+			if v := cmd.SetField.ValueFrom; v != nil {
+				newValue, _ = v.read(fs, cmd.Path)
+			}
+			changes[i] = changelogChange{
+				Path:     cmd.Path,
+				Command:  "setField",
+				Field:    a.Field,
+				OldValue: readFieldValue(fs, cmd.Path, a.Field),
+				NewValue: newValue,
+			}
+		}
+		return changes
+	}
+
+	if cmd.BumpChartVersion != nil {
+		change := changelogChangeForCommand(cmd)
+		oldValue := readFieldValue(fs, cmd.Path, "version")
+		change.OldValue = oldValue
+		if s, ok := oldValue.(string); ok {
+			if version, err := semver.Parse(s); err == nil {
+				change.NewValue = version.Bump(cmd.BumpChartVersion.part()).String()
+			}
+		}
+		changes := []changelogChange{change}
+		if cmd.BumpChartVersion.AppVersion != "" {
+			changes = append(changes, changelogChange{
+				Path:     cmd.Path,
+				Command:  "bumpChartVersion",
+				Field:    "appVersion",
+				OldValue: readFieldValue(fs, cmd.Path, "appVersion"),
+				NewValue: cmd.BumpChartVersion.AppVersion,
+			})
+		}
+		return changes
+	}
+
+	if cmd.IncrementField != nil {
+		change := changelogChangeForCommand(cmd)
+		change.OldValue = readFieldValue(fs, cmd.Path, cmd.IncrementField.Field)
+		if next, err := cmd.IncrementField.increment(change.OldValue); err == nil {
+			change.NewValue = next
+		}
+		return []changelogChange{change}
+	}
+
+	if cmd.DeleteField != nil {
+		change := changelogChangeForCommand(cmd)
+		change.OldValue = readFieldValue(fs, cmd.Path, cmd.DeleteField.Field)
+		return []changelogChange{change}
+	}
+
+	if cmd.SetLabel != nil || cmd.SetAnnotation != nil {
+		command, setMetadata := "setLabel", cmd.SetLabel
+		if cmd.SetAnnotation != nil {
+			command, setMetadata = "setAnnotation", cmd.SetAnnotation
+		}
+		entries := setMetadata.entries()
+		changes := make([]changelogChange, len(entries))
+		for i, e := range entries {
+			changes[i] = changelogChange{Path: cmd.Path, Command: command, Field: e.Key, NewValue: e.Value}
+		}
+		return changes
+	}
+
+	if cmd.SetProperty != nil {
+		change := changelogChangeForCommand(cmd)
+		change.OldValue = readPropertyValue(fs, cmd.Path, cmd.SetProperty.Key)
+		return []changelogChange{change}
+	}
+
+	return []changelogChange{changelogChangeForCommand(cmd)}
+}
+
+func changelogChangeForCommand(cmd patchRequestCommand) changelogChange {
+	switch {
+	case cmd.CreateFile != nil:
+		return changelogChange{Path: cmd.Path, Command: "createFile"}
+	case cmd.CreateFromTemplate != nil:
+		return changelogChange{Path: cmd.Path, Command: "createFromTemplate"}
+	case cmd.CopyFile != nil:
+		return changelogChange{Path: cmd.Path, From: cmd.CopyFile.From, Command: "copyFile"}
+	case cmd.DeleteFile != nil:
+		return changelogChange{Path: cmd.Path, Command: "deleteFile"}
+	case cmd.EnsureFields != nil:
+		return changelogChange{Path: cmd.Path, Command: "ensureFields", NewValue: cmd.EnsureFields.Fields}
+	case cmd.AddToArray != nil:
+		return changelogChange{Path: cmd.Path, Command: "addToArray", Field: cmd.AddToArray.Field, NewValue: cmd.AddToArray.Value}
+	case cmd.JSONPatch != nil:
+		return changelogChange{Path: cmd.Path, Command: "jsonPatch", NewValue: cmd.JSONPatch}
+	case cmd.SetProperty != nil:
+		if cmd.SetProperty.Remove {
+			return changelogChange{Path: cmd.Path, Command: "setProperty", Field: cmd.SetProperty.Key}
+		}
+		return changelogChange{Path: cmd.Path, Command: "setProperty", Field: cmd.SetProperty.Key, NewValue: *cmd.SetProperty.Value}
+	case cmd.SetMode != nil:
+		return changelogChange{Path: cmd.Path, Command: "setMode", NewValue: string(cmd.SetMode.Mode)}
+	case cmd.BumpChartVersion != nil:
+		return changelogChange{Path: cmd.Path, Command: "bumpChartVersion", Field: "version"}
+	case cmd.IncrementField != nil:
+		return changelogChange{Path: cmd.Path, Command: "incrementField", Field: cmd.IncrementField.Field}
+	case cmd.DeleteField != nil:
+		return changelogChange{Path: cmd.Path, Command: "deleteField", Field: cmd.DeleteField.Field}
+	case cmd.SetComment != nil:
+		return changelogChange{Path: cmd.Path, Command: "setComment", Field: cmd.SetComment.Field}
+	case cmd.SetLabel != nil:
+		return changelogChange{Path: cmd.Path, Command: "setLabel"}
+	case cmd.SetAnnotation != nil:
+		return changelogChange{Path: cmd.Path, Command: "setAnnotation"}
+	case cmd.UpdateImageMarkers != nil:
+		return changelogChange{Path: cmd.Path, Command: "updateImageMarkers", Field: cmd.UpdateImageMarkers.Policy, NewValue: cmd.UpdateImageMarkers.Image + ":" + cmd.UpdateImageMarkers.Tag}
+	case cmd.CreateTag != nil:
+		return changelogChange{Command: "createTag", Tag: cmd.CreateTag.Name}
+	default:
+		return changelogChange{Path: cmd.Path}
+	}
+}
+
+// readFieldValue reads the value of a field, or nil if it cannot be read.
+// Errors are handled when the command is applied.
+func readFieldValue(fs billy.Filesystem, filename, field string) any {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	if isTOMLFile(filename) {
+		patcher, err := toml.NewPatcher(f)
+		if err != nil {
+			return nil
+		}
+		value, _ := patcher.Field(field)
+		return value
+	}
+
+	patcher, err := yaml.NewPatcher(f)
+	if err != nil {
+		return nil
+	}
+	value, _ := patcher.Field(field)
+	return value
+}
+
+// readPropertyValue reads the value of a key of a .env or .properties file, or nil if it cannot be read.
+func readPropertyValue(fs billy.Filesystem, filename, key string) any {
+	format, ok := properties.FormatOf(filename)
+	if !ok {
+		return nil
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	patcher, err := properties.NewPatcher(f, format)
+	if err != nil {
+		return nil
+	}
+	if value, ok := patcher.Get(key); ok {
+		return value
+	}
+	return nil
+}
+
+// writeChangelogFragment writes the entry to a fragment named after the time of the entry.
+// If the fragment already exists, the entry is appended. It returns the path of the fragment.
+func writeChangelogFragment(fs billy.Filesystem, config ChangelogConfig, entry changelogEntry) (string, error) {
+	filename := path.Join(config.directory(), entry.Time.UTC().Format("20060102150405")+"-vignet.yaml")
+
+	var entries []changelogEntry
+	existing, err := fs.Open(filename)
+	if err == nil {
+		err = goyaml.NewDecoder(existing).Decode(&entries)
+		existing.Close()
+		if err != nil {
+			return "", fmt.Errorf("decoding existing fragment %s: %w", filename, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("opening existing fragment %s: %w", filename, err)
+	}
+	entries = append(entries, entry)
+
+	err = fs.MkdirAll(config.directory(), 0755)
+	if err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
+	}
+	f, err := fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", fmt.Errorf("creating fragment %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	enc := goyaml.NewEncoder(f)
+	enc.SetIndent(2)
+	err = enc.Encode(entries)
+	if err != nil {
+		return "", fmt.Errorf("encoding fragment: %w", err)
+	}
+
+	return filename, nil
+}
@@ -0,0 +1,369 @@
+// Package client is a Go client for the HTTP API of vignet.
+//
+// The API is described by the OpenAPI document served at /v1/openapi.json, the types of this package implement the
+// schemas of the document.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// APIVersion is the version of the API implemented by the client.
+const APIVersion = "v1"
+
+// Headers of responses.
+const (
+	// ConsistencyTokenHeader is set in responses of patch requests with the consistency token of the pushed state,
+	// it is sent with read requests of a context built by WithConsistencyToken.
+	ConsistencyTokenHeader = "X-Vignet-Consistency-Token"
+	// CommitHeader is set in responses of read requests with the hash of the commit that was read.
+	CommitHeader = "X-Vignet-Commit"
+)
+
+// Client sends requests to a vignet instance.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	header     http.Header
+	username   string
+	password   string
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client to send requests with, http.DefaultClient is used by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBearerToken authenticates requests with a bearer token, e.g. the JWT of a GitLab CI job.
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithBasicAuth authenticates requests by HTTP basic auth.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithHeader sets a header on all requests, e.g. "X-Vignet-Job-Token" for a credential exchange.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Set(key, value)
+	}
+}
+
+// New creates a new Client for the vignet instance at baseURL (e.g. "https://vignet.example.com").
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL must be absolute, got %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Error is returned for responses with an error status code.
+type Error struct {
+	StatusCode int
+	ErrorResponse
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("vignet: %s (status %d)", e.Cause, e.StatusCode)
+	if e.ErrorResponse.Error != "" {
+		msg += ": " + e.ErrorResponse.Error
+	}
+	return msg
+}
+
+// StatusCode returns the status code of an *Error, or 0 if err is not an *Error.
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Patch applies the commands of the request to the repository and pushes a commit.
+func (c *Client) Patch(ctx context.Context, repo string, req PatchRequest) (*PatchResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	var res PatchResponse
+	_, err = c.do(ctx, http.MethodPost, "/patch/"+url.PathEscape(repo), nil, "application/json", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PatchWithFiles applies the commands of the request like Patch, but sends a multipart request with the files as
+// additional parts. Commands reference files by name in CreateFileCommand.ContentFrom.
+func (c *Client) PatchWithFiles(ctx context.Context, repo string, req PatchRequest, files map[string]io.Reader) (*PatchResponse, error) {
+	requestJSON, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeMultipartBody(mw, requestJSON, files))
+	}()
+	defer pr.Close()
+
+	var res PatchResponse
+	_, err = c.do(ctx, http.MethodPost, "/patch/"+url.PathEscape(repo), nil, mw.FormDataContentType(), pr, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func writeMultipartBody(mw *multipart.Writer, requestJSON []byte, files map[string]io.Reader) error {
+	if err := mw.WriteField("request", string(requestJSON)); err != nil {
+		return err
+	}
+	for name, r := range files {
+		w, err := mw.CreateFormFile(name, name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return fmt.Errorf("copying file %q: %w", name, err)
+		}
+	}
+	return mw.Close()
+}
+
+// Preview applies the commands of the request as a dry run and returns the diff, nothing is pushed.
+func (c *Client) Preview(ctx context.Context, repo string, req PatchRequest) (*PatchResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	var res PatchResponse
+	_, err = c.do(ctx, http.MethodPost, "/patch/"+url.PathEscape(repo)+"/preview", nil, "application/json", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// BatchPatch applies patches to multiple repositories. The result of each patch is returned, so an error is only
+// returned if the batch itself failed.
+func (c *Client) BatchPatch(ctx context.Context, req BatchPatchRequest) (*BatchPatchResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	var res BatchPatchResponse
+	_, err = c.do(ctx, http.MethodPost, "/patch", nil, "application/json", bytes.NewReader(body), &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+type consistencyTokenKey struct{}
+
+// WithConsistencyToken returns a context for reading a file or field with the consistency token of a patch, so the
+// branch of the patch is read and the state contains the pushed commit.
+func WithConsistencyToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+// ReadFile reads the content of a file at the head of the default branch, see WithConsistencyToken to read a patch.
+func (c *Client) ReadFile(ctx context.Context, repo, path string) (*File, error) {
+	query := url.Values{"path": {path}}
+	var content []byte
+	header, err := c.do(ctx, http.MethodGet, "/repos/"+url.PathEscape(repo)+"/file", query, "", nil, &content)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Commit:  header.Get(CommitHeader),
+		Content: content,
+	}, nil
+}
+
+// ReadField reads the value of a field of a file at the head of the default branch, see WithConsistencyToken to read
+// a patch.
+func (c *Client) ReadField(ctx context.Context, repo, path, field string) (*ReadFieldResponse, error) {
+	query := url.Values{"path": {path}, "field": {field}}
+	var res ReadFieldResponse
+	_, err := c.do(ctx, http.MethodGet, "/repos/"+url.PathEscape(repo)+"/file", query, "", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// HistoryOptions filter the commits of a history request.
+type HistoryOptions struct {
+	// Branch to list commits of, defaults to the default branch.
+	Branch string
+	// VignetOnly only lists commits created by vignet, if set to true.
+	VignetOnly bool
+	// Limit is the maximum number of commits, the server default is used if zero.
+	Limit int
+}
+
+// History lists the latest commits of a branch (newest first).
+func (c *Client) History(ctx context.Context, repo string, opts HistoryOptions) (*HistoryResponse, error) {
+	query := url.Values{}
+	if opts.Branch != "" {
+		query.Set("branch", opts.Branch)
+	}
+	if opts.VignetOnly {
+		query.Set("vignet", "true")
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var res HistoryResponse
+	_, err := c.do(ctx, http.MethodGet, "/repos/"+url.PathEscape(repo)+"/history", query, "", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PromotionsOptions filter the promotions of a promotions request.
+type PromotionsOptions struct {
+	// Environment only lists promotions to the environment.
+	Environment string
+	// PromotedFrom only lists promotions of source commits with the hash prefix.
+	PromotedFrom string
+	// Limit is the maximum number of promotions, the server default is used if zero.
+	Limit int
+}
+
+// Promotions lists promotions in the history of the default branch (newest first).
+func (c *Client) Promotions(ctx context.Context, repo string, opts PromotionsOptions) (*PromotionsResponse, error) {
+	query := url.Values{}
+	if opts.Environment != "" {
+		query.Set("environment", opts.Environment)
+	}
+	if opts.PromotedFrom != "" {
+		query.Set("promotedFrom", opts.PromotedFrom)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	var res PromotionsResponse
+	_, err := c.do(ctx, http.MethodGet, "/promotions/"+url.PathEscape(repo), query, "", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Capabilities describes what the instance supports.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	var res Capabilities
+	_, err := c.do(ctx, http.MethodGet, "/capabilities", nil, "", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// do sends a request and decodes a successful response into res, which is either a pointer to a []byte for the raw
+// body or a value to decode JSON into. It returns the header of the response.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, res any) (http.Header, error) {
+	u := *c.baseURL
+	u.Path += "/" + APIVersion + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token, ok := ctx.Value(consistencyTokenKey{}).(string); ok && token != "" {
+		req.Header.Set(ConsistencyTokenHeader, token)
+	}
+	// Errors are returned as JSON if accepted
+	req.Header.Set("Accept", "application/json, */*;q=0.5")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, decodeError(resp)
+	}
+
+	if raw, ok := res.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return resp.Header, nil
+}
+
+// decodeError builds an *Error from an error response, the body is used as cause if it is not JSON.
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("reading error response with status %d: %w", resp.StatusCode, err)
+	}
+	if err := json.Unmarshal(body, &apiErr.ErrorResponse); err != nil || apiErr.Cause == "" {
+		apiErr.ErrorResponse = ErrorResponse{
+			Cause: strings.TrimSpace(string(body)),
+			Code:  resp.Header.Get("X-Error-Code"),
+		}
+		if apiErr.Cause == "" {
+			apiErr.Cause = http.StatusText(resp.StatusCode)
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,252 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/client"
+	"github.com/networkteam/vignet/internal/gitserver"
+	"github.com/networkteam/vignet/policy"
+)
+
+type staticAuthenticationProvider struct {
+	authCtx vignet.AuthCtx
+}
+
+func (p staticAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (vignet.AuthCtx, error) {
+	return p.authCtx, nil
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	fs := memfs.New()
+	require.NoError(t, gitserver.InitRepo(fs, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+	}))
+	gitSrv := httptest.NewServer(gitserver.New(fs, gitserver.Options{}))
+	defer gitSrv.Close()
+
+	defaultBundle, err := policy.LoadDefaultBundle()
+	require.NoError(t, err)
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, defaultBundle)
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.WithBearerToken("not-a-token"))
+	require.NoError(t, err)
+
+	t.Run("preview", func(t *testing.T) {
+		res, err := c.Preview(ctx, "infra", client.PatchRequest{
+			Commands: []client.Command{
+				{Path: "my-group/my-project/release.yml", SetField: &client.SetFieldCommand{Field: "image.tag", Value: "1.1.0"}},
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, res.DryRun)
+		assert.Contains(t, res.Diff, "+  tag: 1.1.0")
+		assert.Empty(t, res.Commit)
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		res, err := c.Patch(ctx, "infra", client.PatchRequest{
+			Commit: client.Commit{Message: "Release 1.1.0"},
+			Commands: []client.Command{
+				{Path: "my-group/my-project/release.yml", SetField: &client.SetFieldCommand{Field: "image.tag", Value: "1.1.0"}},
+			},
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, res.Commit)
+		assert.NotEmpty(t, res.ConsistencyToken)
+		require.Len(t, res.Commands, 1)
+		assert.Equal(t, "1.0.0", res.Commands[0].OldValue)
+		assert.Equal(t, "1.1.0", res.Commands[0].NewValue)
+
+		field, err := c.ReadField(client.WithConsistencyToken(ctx, res.ConsistencyToken), "infra", "my-group/my-project/release.yml", "image.tag")
+		require.NoError(t, err)
+		assert.Equal(t, "1.1.0", field.Value)
+		assert.Equal(t, res.Commit, field.Commit)
+	})
+
+	t.Run("patch with files", func(t *testing.T) {
+		res, err := c.PatchWithFiles(ctx, "infra", client.PatchRequest{
+			Commands: []client.Command{
+				{Path: "my-group/my-project/manifest.yml", CreateFile: &client.CreateFileCommand{ContentFrom: "manifest"}},
+			},
+		}, map[string]io.Reader{
+			"manifest": strings.NewReader("kind: ConfigMap\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"my-group/my-project/manifest.yml"}, res.Commands[0].ChangedFiles)
+	})
+
+	t.Run("read file and field", func(t *testing.T) {
+		file, err := c.ReadFile(ctx, "infra", "my-group/my-project/manifest.yml")
+		require.NoError(t, err)
+		assert.Equal(t, "kind: ConfigMap\n", string(file.Content))
+		assert.NotEmpty(t, file.Commit)
+
+		field, err := c.ReadField(ctx, "infra", "my-group/my-project/release.yml", "image.tag")
+		require.NoError(t, err)
+		assert.Equal(t, "1.1.0", field.Value)
+		assert.Equal(t, file.Commit, field.Commit)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		res, err := c.History(ctx, "infra", client.HistoryOptions{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, res.Commits, 2)
+		assert.Equal(t, "Automated patch by vignet", res.Commits[0].Message)
+		assert.Equal(t, "Release 1.1.0", res.Commits[1].Message)
+	})
+
+	t.Run("batch patch", func(t *testing.T) {
+		res, err := c.BatchPatch(ctx, client.BatchPatchRequest{
+			Patches: []client.BatchPatch{
+				{Repo: "unknown", PatchRequest: client.PatchRequest{Commands: []client.Command{
+					{Path: "my-group/my-project/release.yml", DeleteFile: &client.DeleteFileCommand{}},
+				}}},
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, http.StatusNotFound, res.Results[0].Status)
+		require.NotNil(t, res.Results[0].Error)
+		assert.Equal(t, "Unknown repository", res.Results[0].Error.Cause)
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		res, err := c.Capabilities(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, vignet.Version, res.Version)
+		assert.Contains(t, res.Commands, "setField")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := c.Patch(ctx, "infra", client.PatchRequest{
+			Commands: []client.Command{
+				{Path: "other-group/release.yml", SetField: &client.SetFieldCommand{Field: "image.tag", Value: "1.2.0"}},
+			},
+		})
+		require.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, client.StatusCode(err))
+
+		var apiErr *client.Error
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "Authorization failed", apiErr.Cause)
+		assert.Contains(t, apiErr.ErrorResponse.Error, "is not a prefix of GitLab project path")
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := client.New("vignet.example.com")
+	require.ErrorContains(t, err, "base URL must be absolute")
+}
+
+// TestTypesMatchOpenAPI checks that the JSON fields of the client types are the properties of the schemas
+// in the OpenAPI document.
+func TestTypesMatchOpenAPI(t *testing.T) {
+	b, err := os.ReadFile("../openapi.json")
+	require.NoError(t, err)
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(b, &doc))
+
+	types := map[string]any{
+		"PatchRequest":              client.PatchRequest{},
+		"Commit":                    client.Commit{},
+		"Signature":                 client.Signature{},
+		"PullRequestOptions":        client.PullRequestOptions{},
+		"Promotion":                 client.Promotion{},
+		"PromotionSource":           client.PromotionSource{},
+		"Command":                   client.Command{},
+		"SetFieldCommand":           client.SetFieldCommand{},
+		"SetFieldValueFrom":         client.SetFieldValueFrom{},
+		"CreateFileCommand":         client.CreateFileCommand{},
+		"CreateTagCommand":          client.CreateTagCommand{},
+		"EnsureFieldsCommand":       client.EnsureFieldsCommand{},
+		"AddToArrayCommand":         client.AddToArrayCommand{},
+		"JSONPatchOperation":        client.JSONPatchOperation{},
+		"SetPropertyCommand":        client.SetPropertyCommand{},
+		"SetModeCommand":            client.SetModeCommand{},
+		"CreateFromTemplateCommand": client.CreateFromTemplateCommand{},
+		"BumpChartVersionCommand":   client.BumpChartVersionCommand{},
+		"IncrementFieldCommand":     client.IncrementFieldCommand{},
+		"DeleteFieldCommand":        client.DeleteFieldCommand{},
+		"SetCommentCommand":         client.SetCommentCommand{},
+		"SetMetadataCommand":        client.SetMetadataCommand{},
+		"UpdateImageMarkersCommand": client.UpdateImageMarkersCommand{},
+		"CopyFileCommand":           client.CopyFileCommand{},
+		"PatchResponse":             client.PatchResponse{},
+		"CommandResult":             client.CommandResult{},
+		"PullRequest":               client.PullRequest{},
+		"MergeRequest":              client.MergeRequest{},
+		"Error":                     client.ErrorResponse{},
+		"BatchPatchRequest":         client.BatchPatchRequest{},
+		"BatchPatchResponse":        client.BatchPatchResponse{},
+		"BatchPatchResult":          client.BatchPatchResult{},
+		"ReadFieldResponse":         client.ReadFieldResponse{},
+		"HistoryResponse":           client.HistoryResponse{},
+		"HistoryCommit":             client.HistoryCommit{},
+		"PromotionsResponse":        client.PromotionsResponse{},
+		"PromotionRecord":           client.PromotionRecord{},
+		"Capabilities":              client.Capabilities{},
+	}
+	for name, v := range types {
+		schema, ok := doc.Components.Schemas[name]
+		if !assert.True(t, ok, "missing schema %s", name) {
+			continue
+		}
+		var properties []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		assert.Equal(t, properties, jsonFields(reflect.TypeOf(v)), "fields of schema %s", name)
+	}
+}
+
+// jsonFields returns the sorted JSON field names of a struct type, fields of embedded structs are omitted.
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,487 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PatchRequest patches files of a repository with commands and pushes a commit.
+type PatchRequest struct {
+	Commit   Commit    `json:"commit"`
+	Commands []Command `json:"commands"`
+	// DryRun applies the commands and returns the resulting diff without committing and pushing.
+	DryRun bool `json:"dryRun,omitempty"`
+	// PushOptions are sent with the push in addition to the push options of the repository (e.g. "ci.skip").
+	PushOptions []string `json:"pushOptions,omitempty"`
+	// PullRequest options are given, if the commit should be pushed to a new branch and a pull request should be created.
+	PullRequest *PullRequestOptions `json:"pullRequest,omitempty"`
+	// MergeRequest is an alternative to PullRequest using GitLab terms, the response will contain a merge request.
+	MergeRequest *PullRequestOptions `json:"mergeRequest,omitempty"`
+	// Promotion records the commit as a promotion of another commit with trailers in the commit message.
+	Promotion *Promotion `json:"promotion,omitempty"`
+	// Atomic is true by default, so the request fails if a command fails. If set to false, failing commands are
+	// skipped and the changes of the other commands are committed (best-effort).
+	Atomic *bool `json:"atomic,omitempty"`
+	// ExpectedHead is the full commit hash the branch must point to, otherwise the request fails with status 412.
+	ExpectedHead string `json:"expectedHead,omitempty"`
+}
+
+type Commit struct {
+	// Message of the commit, defaults to the configured default message.
+	Message   string     `json:"message,omitempty"`
+	Committer *Signature `json:"committer,omitempty"`
+	Author    *Signature `json:"author,omitempty"`
+}
+
+type Signature struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// PullRequestOptions are the options for a pull request or GitLab merge request.
+type PullRequestOptions struct {
+	// Title of the pull request, defaults to the first line of the commit message.
+	Title string `json:"title,omitempty"`
+	// Description of the pull request.
+	Description string `json:"description,omitempty"`
+	// SourceBranch to push the commit to, defaults to "vignet/<commit hash>".
+	SourceBranch string `json:"sourceBranch,omitempty"`
+	// TargetBranch of the pull request, defaults to the default branch of the repository.
+	TargetBranch string `json:"targetBranch,omitempty"`
+	// RemoveSourceBranch removes the source branch when the merge request is merged, if set to true (GitLab only).
+	RemoveSourceBranch bool `json:"removeSourceBranch,omitempty"`
+}
+
+// Promotion marks a patch as a promotion of a commit, e.g. from a staging to a production environment.
+type Promotion struct {
+	// Environment the patch promotes to (optional).
+	Environment string `json:"environment,omitempty"`
+	// PromotedFrom references the source of the promotion.
+	PromotedFrom PromotionSource `json:"promotedFrom"`
+}
+
+type PromotionSource struct {
+	// Repo of the source commit, defaults to the repository of the patch.
+	Repo string `json:"repo,omitempty"`
+	// Commit is the full hash of the source commit.
+	Commit string `json:"commit"`
+	// Environment of the source commit (optional).
+	Environment string `json:"environment,omitempty"`
+}
+
+// Command is a command of a patch request, exactly one of the command options must be set.
+type Command struct {
+	// Path to file to patch (relative to repository root)
+	Path string `json:"path,omitempty"`
+	// SetField options are given, if the command should set the value of a (nested) field
+	SetField *SetFieldCommand `json:"setField,omitempty"`
+	// CreateFile options are given, if the command should create a file
+	CreateFile *CreateFileCommand `json:"createFile,omitempty"`
+	// DeleteFile options are given, if the command should delete a file
+	DeleteFile *DeleteFileCommand `json:"deleteFile,omitempty"`
+	// CreateTag options are given, if the command should create a tag (path must not be set)
+	CreateTag *CreateTagCommand `json:"createTag,omitempty"`
+	// EnsureFields options are given, if the command should converge fields of a file to the given values
+	EnsureFields *EnsureFieldsCommand `json:"ensureFields,omitempty"`
+	// AddToArray options are given, if the command should add a value to a sequence
+	AddToArray *AddToArrayCommand `json:"addToArray,omitempty"`
+	// JSONPatch is given, if the command should apply a JSON Patch (RFC 6902) document
+	JSONPatch []JSONPatchOperation `json:"jsonPatch,omitempty"`
+	// SetProperty options are given, if the command should set or remove a key of a .env or .properties file
+	SetProperty *SetPropertyCommand `json:"setProperty,omitempty"`
+	// SetMode options are given, if the command should change the mode of a file
+	SetMode *SetModeCommand `json:"setMode,omitempty"`
+	// CreateFromTemplate options are given, if the command should create a file by rendering a template
+	CreateFromTemplate *CreateFromTemplateCommand `json:"createFromTemplate,omitempty"`
+	// BumpChartVersion options are given, if the command should increment the version of a Helm chart
+	BumpChartVersion *BumpChartVersionCommand `json:"bumpChartVersion,omitempty"`
+	// IncrementField options are given, if the command should increment a number or semantic version
+	IncrementField *IncrementFieldCommand `json:"incrementField,omitempty"`
+	// DeleteField options are given, if the command should remove a key or an item of a sequence
+	DeleteField *DeleteFieldCommand `json:"deleteField,omitempty"`
+	// SetComment options are given, if the command should set comments of a field
+	SetComment *SetCommentCommand `json:"setComment,omitempty"`
+	// SetLabel options are given, if the command should set labels of Kubernetes manifests
+	SetLabel *SetMetadataCommand `json:"setLabel,omitempty"`
+	// SetAnnotation options are given, if the command should set annotations of Kubernetes manifests
+	SetAnnotation *SetMetadataCommand `json:"setAnnotation,omitempty"`
+	// UpdateImageMarkers options are given, if the command should update values marked with a Flux image policy
+	// in a file or all YAML files of a directory
+	UpdateImageMarkers *UpdateImageMarkersCommand `json:"updateImageMarkers,omitempty"`
+	// CopyFile options are given, if the command should copy a file or directory of the repository to the path
+	CopyFile *CopyFileCommand `json:"copyFile,omitempty"`
+
+	// Optional skips the command if the file at the path does not exist
+	Optional bool `json:"optional,omitempty"`
+	// ContinueOnError skips the command if it fails because of the request (e.g. a missing field),
+	// instead of failing the request
+	ContinueOnError bool `json:"continueOnError,omitempty"`
+}
+
+type SetFieldCommand struct {
+	// Field path to set (in YAMLPath syntax).
+	Field string `json:"field,omitempty"`
+	// Value to set.
+	Value any `json:"value,omitempty"`
+	// Fields maps field paths to values to set several fields at once, instead of Field and Value.
+	Fields map[string]any `json:"fields,omitempty"`
+	// Create missing keys for field if they don't exist, if set to true.
+	Create bool `json:"create,omitempty"`
+	// SOPS decrypts the SOPS encrypted file before setting the field and encrypts the new value, if set to true.
+	SOPS bool `json:"sops,omitempty"`
+	// ExpectedValue is the current value the field must have, otherwise the field is not set (optional).
+	ExpectedValue any `json:"expectedValue,omitempty"`
+	// ValueFrom copies the value from a field of the same or another file, instead of Value.
+	ValueFrom *SetFieldValueFrom `json:"valueFrom,omitempty"`
+	// ExpandAliases sets a field of a YAML file that is shared by an anchor and aliases by expanding them to copies.
+	ExpandAliases bool `json:"expandAliases,omitempty"`
+	// Style of the value in a YAML file (plain, single, double, literal or folded), optional.
+	Style string `json:"style,omitempty"`
+	// AllowMultiple sets every field matching the path, instead of failing if multiple fields match.
+	AllowMultiple bool `json:"allowMultiple,omitempty"`
+}
+
+type SetFieldValueFrom struct {
+	// Path of the file to read the value from (optional, defaults to the path of the command).
+	Path string `json:"path,omitempty"`
+	// Field path of the value (in YAMLPath syntax, dot path syntax for TOML files).
+	Field string `json:"field"`
+}
+
+type CreateFileCommand struct {
+	// Content of the file to set
+	Content string `json:"content,omitempty"`
+	// Encoding of the content, "base64" for binary content (optional, defaults to plain text).
+	Encoding string `json:"encoding,omitempty"`
+	// Mode of the file, e.g. "0755" for an executable file (optional, defaults to "0644").
+	Mode string `json:"mode,omitempty"`
+	// ContentFrom is the name of a file part of a multipart request to stream the content from.
+	// See Client.PatchWithFiles.
+	ContentFrom string `json:"contentFrom,omitempty"`
+}
+
+type DeleteFileCommand struct {
+}
+
+type CreateTagCommand struct {
+	// Name of the tag (e.g. "v1.2.3").
+	Name string `json:"name"`
+	// Message of the tag, it is required for an annotated tag.
+	Message string `json:"message,omitempty"`
+	// Annotated creates an annotated tag instead of a lightweight tag, if set to true.
+	Annotated bool `json:"annotated,omitempty"`
+	// Target revision of the tag (e.g. a branch, tag or commit hash), defaults to the commit created by the request.
+	Target string `json:"target,omitempty"`
+}
+
+type EnsureFieldsCommand struct {
+	// Fields maps dot separated field paths to values, missing keys are created.
+	Fields map[string]any `json:"fields"`
+	// Prune removes keys under the dot separated path that are not given in Fields, if set.
+	Prune string `json:"prune,omitempty"`
+}
+
+type AddToArrayCommand struct {
+	// Field path of the sequence (in YAMLPath syntax).
+	Field string `json:"field"`
+	// Value to add, it can be a scalar, an object or an array.
+	Value any `json:"value"`
+	// Index to insert the value at, the value is appended if not set.
+	Index *int `json:"index,omitempty"`
+}
+
+// JSONPatchOperation is an operation of a JSON Patch (RFC 6902) document.
+type JSONPatchOperation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" or "test".
+	Op string `json:"op"`
+	// Path is the JSON Pointer of the target location.
+	Path string `json:"path"`
+	// From is the JSON Pointer of the source location of "move" and "copy".
+	From string `json:"from,omitempty"`
+	// Value of "add", "replace" and "test".
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type SetPropertyCommand struct {
+	// Key to set or remove.
+	Key string `json:"key"`
+	// Value to set, the key is added if it doesn't exist.
+	Value *string `json:"value,omitempty"`
+	// Remove all lines of the key instead of setting a value, if set to true.
+	Remove bool `json:"remove,omitempty"`
+}
+
+type SetModeCommand struct {
+	// Mode of the file, e.g. "0755" for an executable file.
+	Mode string `json:"mode"`
+}
+
+type CreateFromTemplateCommand struct {
+	// Template in text/template syntax to render.
+	Template string `json:"template,omitempty"`
+	// TemplatePath is the path of a template in the repository, instead of Template.
+	TemplatePath string `json:"templatePath,omitempty"`
+	// Values are the data of the template.
+	Values map[string]any `json:"values,omitempty"`
+	// Mode of the file, e.g. "0755" for an executable file (optional, defaults to "0644").
+	Mode string `json:"mode,omitempty"`
+}
+
+type BumpChartVersionCommand struct {
+	// Bump is the part of the version to increment, one of "major", "minor" or "patch" (optional, defaults to "patch").
+	Bump string `json:"bump,omitempty"`
+	// AppVersion of the chart to set (optional).
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+type IncrementFieldCommand struct {
+	// Field path of the value (in YAMLPath syntax).
+	Field string `json:"field"`
+	// By is the amount to add to an integer (optional, defaults to 1).
+	By *int `json:"by,omitempty"`
+	// Bump is the part of a semantic version to increment, one of "major", "minor" or "patch", instead of By.
+	Bump string `json:"bump,omitempty"`
+}
+
+type DeleteFieldCommand struct {
+	// Field path of the key or sequence item to remove (in YAMLPath syntax).
+	Field string `json:"field"`
+	// ExpandAliases deletes a field of a YAML file that is shared by an anchor and aliases by expanding them to copies,
+	// so the aliases are not changed.
+	ExpandAliases bool `json:"expandAliases,omitempty"`
+}
+
+type SetCommentCommand struct {
+	// Field path of the commented field (in YAMLPath syntax).
+	Field string `json:"field"`
+	// LineComment to set at the end of the line of the field, an empty string removes it (optional).
+	LineComment *string `json:"lineComment,omitempty"`
+	// HeadComment to set on the lines above the field, an empty string removes it (optional).
+	HeadComment *string `json:"headComment,omitempty"`
+}
+
+// SetMetadataCommand sets labels or annotations in the metadata of Kubernetes manifests.
+type SetMetadataCommand struct {
+	// Key of the label or annotation.
+	Key string `json:"key,omitempty"`
+	// Value to set.
+	Value *string `json:"value,omitempty"`
+	// Values maps keys to values to set several at once, instead of Key and Value.
+	Values map[string]string `json:"values,omitempty"`
+	// Kind of the manifests to change (optional, defaults to all manifests of the file).
+	Kind string `json:"kind,omitempty"`
+	// Name of the manifests to change (optional, defaults to all manifests of the file).
+	Name string `json:"name,omitempty"`
+}
+
+type UpdateImageMarkersCommand struct {
+	// Policy is the image policy of markers to update ("namespace:name").
+	Policy string `json:"policy"`
+	// Image is the new image name without tag.
+	Image string `json:"image,omitempty"`
+	// Tag is the new tag of the image.
+	Tag string `json:"tag,omitempty"`
+}
+
+type CopyFileCommand struct {
+	// From is the path of the file or directory to copy.
+	From string `json:"from"`
+	// Overwrite existing files at the path, if set to true.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+type PatchResponse struct {
+	// Commit is the hash of the created commit, it is empty for a dry run.
+	Commit string `json:"commit,omitempty"`
+	// ConsistencyToken identifies the pushed state of the repository, it is empty for a dry run.
+	ConsistencyToken string `json:"consistencyToken,omitempty"`
+	// Branch the commit was pushed to.
+	Branch string `json:"branch"`
+	// Commands contains a result for each command in the order of the request.
+	Commands []CommandResult `json:"commands"`
+	// DryRun is set if the commands were applied without committing and pushing.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Diff is the unified diff of the changes, it is only set for a dry run.
+	Diff string `json:"diff,omitempty"`
+	// PullRequest is set if a pull request was created.
+	PullRequest *PullRequest `json:"pullRequest,omitempty"`
+	// MergeRequest is set if a GitLab merge request was created.
+	MergeRequest *MergeRequest `json:"mergeRequest,omitempty"`
+}
+
+type PullRequest struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+type MergeRequest struct {
+	IID int    `json:"iid"`
+	URL string `json:"url"`
+}
+
+type CommandResult struct {
+	// Applied is set if the command was applied, even if it did not change a file.
+	Applied bool `json:"applied"`
+	// ChangedFiles are the paths of files changed by the command.
+	ChangedFiles []string `json:"changedFiles"`
+	// Tag is the name of the tag created by the command.
+	Tag string `json:"tag,omitempty"`
+	// ChangedFields are the paths of fields changed by an ensureFields command.
+	ChangedFields []string `json:"changedFields,omitempty"`
+	// PrunedFields are the paths of keys removed by an ensureFields command.
+	PrunedFields []string `json:"prunedFields,omitempty"`
+	// Version is the new version set by a bumpChartVersion command.
+	Version string `json:"version,omitempty"`
+	// Value is the new value of the field set by an incrementField command.
+	Value any `json:"value,omitempty"`
+	// Field is the field changed by a command that changes a single field (e.g. setField or incrementField).
+	Field string `json:"field,omitempty"`
+	// OldValue is the value of the field before the command was applied.
+	OldValue any `json:"oldValue,omitempty"`
+	// NewValue is the value of the field after the command was applied.
+	NewValue any `json:"newValue,omitempty"`
+	// Matches is the number of fields set by a setField command with allowMultiple.
+	Matches int `json:"matches,omitempty"`
+	// Skipped is set if the command was not applied, because the file of an optional command does not exist
+	// or the command failed and errors should not fail the request.
+	Skipped bool `json:"skipped,omitempty"`
+	// Error is the error of a skipped command that failed.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchPatchRequest patches multiple repositories in one request.
+type BatchPatchRequest struct {
+	// Patches are applied in order, each is authorized and committed separately.
+	Patches []BatchPatch `json:"patches"`
+	// OnFailure is the behavior if a patch fails, the server defaults to BatchBestEffort.
+	OnFailure BatchFailureMode `json:"onFailure,omitempty"`
+}
+
+// BatchFailureMode is the behavior of a batch if a patch fails.
+type BatchFailureMode string
+
+const (
+	// BatchBestEffort applies the following patches.
+	BatchBestEffort BatchFailureMode = "bestEffort"
+	// BatchFailFast skips the following patches.
+	BatchFailFast BatchFailureMode = "failFast"
+	// BatchRollback skips the following patches and reverts the commits of the applied patches.
+	BatchRollback BatchFailureMode = "rollback"
+)
+
+// BatchPatchOutcome is the outcome of a patch of a batch.
+type BatchPatchOutcome string
+
+const (
+	BatchOutcomeApplied        BatchPatchOutcome = "applied"
+	BatchOutcomeFailed         BatchPatchOutcome = "failed"
+	BatchOutcomeSkipped        BatchPatchOutcome = "skipped"
+	BatchOutcomeRolledBack     BatchPatchOutcome = "rolledBack"
+	BatchOutcomeRollbackFailed BatchPatchOutcome = "rollbackFailed"
+)
+
+// BatchPatch is a patch request for a repository of a batch.
+type BatchPatch struct {
+	// Repo is the name of the repository to patch.
+	Repo string `json:"repo"`
+	PatchRequest
+}
+
+type BatchPatchResponse struct {
+	// OnFailure is the behavior that was applied if a patch failed.
+	OnFailure BatchFailureMode `json:"onFailure"`
+	// Results contains a result for each patch in the order of the request.
+	Results []BatchPatchResult `json:"results"`
+}
+
+// BatchPatchResult is the result of a patch of a batch, either Patch or Error is set.
+type BatchPatchResult struct {
+	Repo string `json:"repo"`
+	// Status is the status code the patch would have as a single request.
+	Status  int               `json:"status"`
+	Outcome BatchPatchOutcome `json:"outcome"`
+	Patch   *PatchResponse    `json:"patch,omitempty"`
+	Error   *ErrorResponse    `json:"error,omitempty"`
+	// RevertCommit is the hash of the commit that reverted the patch if it was rolled back.
+	RevertCommit string `json:"revertCommit,omitempty"`
+	// RollbackError is set if the patch could not be rolled back.
+	RollbackError *ErrorResponse `json:"rollbackError,omitempty"`
+}
+
+// ErrorResponse is the JSON body of an error response.
+type ErrorResponse struct {
+	Cause string `json:"cause"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+	// Command is the index of the failed command.
+	Command *int `json:"command,omitempty"`
+}
+
+// File is the content of a file read from the head of the default branch.
+type File struct {
+	// Commit is the hash of the commit that was read.
+	Commit  string
+	Content []byte
+}
+
+type ReadFieldResponse struct {
+	// Commit is the hash of the commit that was read.
+	Commit string `json:"commit"`
+	Path   string `json:"path"`
+	Field  string `json:"field"`
+	Value  any    `json:"value"`
+}
+
+type HistoryResponse struct {
+	Commits []HistoryCommit `json:"commits"`
+}
+
+type HistoryCommit struct {
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+	// Message is the first line of the commit message.
+	Message   string    `json:"message"`
+	Author    Signature `json:"author"`
+	Committer Signature `json:"committer"`
+	// Files are the paths of files changed by the commit (compared to the first parent).
+	Files []string `json:"files"`
+}
+
+type PromotionsResponse struct {
+	Promotions []PromotionRecord `json:"promotions"`
+}
+
+type PromotionRecord struct {
+	// Commit is the hash of the commit of the promotion.
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+	// Message is the first line of the commit message.
+	Message      string          `json:"message"`
+	Environment  string          `json:"environment,omitempty"`
+	PromotedFrom PromotionSource `json:"promotedFrom"`
+}
+
+// Capabilities describe what an instance supports.
+type Capabilities struct {
+	Version string `json:"version"`
+	// APIVersions are the supported versions of the API, the last one is the latest.
+	APIVersions []string `json:"apiVersions"`
+	// Commands are the enabled command types of patch requests.
+	Commands []string `json:"commands"`
+	// Features are all enabled features (command types and subsystems).
+	Features []string `json:"features"`
+	// FileFormats are the formats of files that can be patched.
+	FileFormats []string `json:"fileFormats"`
+	// AuthenticationProviders are the types of the configured authentication providers.
+	AuthenticationProviders []string           `json:"authenticationProviders"`
+	Limits                  CapabilitiesLimits `json:"limits"`
+}
+
+type CapabilitiesLimits struct {
+	// MaxBodySize is the maximum size of a request body in bytes.
+	MaxBodySize int64 `json:"maxBodySize"`
+	// MaxCommands is the maximum number of commands of a patch request.
+	MaxCommands int `json:"maxCommands"`
+	// MaxFileSize is the maximum size of a file patched by a command in bytes.
+	MaxFileSize int64 `json:"maxFileSize"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/networkteam/vignet"
+)
+
+func configValidateAction(c *cli.Context) error {
+	// Only log warnings and errors, the report is printed to stdout
+	if !c.Bool("verbose") {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	var passed, failed, skipped int
+	report := func(status, check string, err error) {
+		switch status {
+		case "PASS":
+			passed++
+		case "FAIL":
+			failed++
+		case "SKIP":
+			skipped++
+		}
+		fmt.Fprintf(os.Stdout, "%s: %s\n", status, check)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "  %s\n", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+		}
+	}
+
+	filenames, err := configFilenames(c)
+	if err != nil {
+		report("FAIL", "load configuration", err)
+		return fmt.Errorf("configuration is invalid")
+	}
+	sources := strings.Join(append(filenames, "environment"), ", ")
+	config, err := vignet.LoadConfigWithEnv(os.Environ(), filenames...)
+	if err != nil {
+		report("FAIL", "load configuration from "+sources, err)
+		return fmt.Errorf("configuration is invalid")
+	}
+	report("PASS", "load configuration from "+sources, nil)
+
+	if err := config.Validate(); err != nil {
+		report("FAIL", "validate configuration", err)
+		return fmt.Errorf("configuration is invalid")
+	}
+	report("PASS", "validate configuration", nil)
+
+	if c.Bool("check-policy") {
+		selfTest := func(ctx context.Context, authorizer *vignet.RegoAuthorizer) error {
+			return vignet.SelfTest(ctx, authorizer, config)
+		}
+		_, _, err := buildAuthorizer(c, config, selfTest)
+		if err != nil {
+			report("FAIL", "compile policy and run self-test", err)
+		} else {
+			report("PASS", "compile policy and run self-test", nil)
+		}
+	}
+
+	if c.Bool("check-repositories") {
+		for _, check := range vignet.CheckRepositories(c.Context, config) {
+			switch {
+			case check.Skipped != "":
+				report("SKIP", fmt.Sprintf("repository %s (%s)", check.Repo, check.Skipped), nil)
+			case check.Err != nil:
+				report("FAIL", "repository "+check.Repo, check.Err)
+			default:
+				report("PASS", "repository "+check.Repo, nil)
+			}
+		}
+	}
+
+	total := passed + failed + skipped
+	fmt.Fprintf(os.Stdout, "PASS: %d/%d\n", passed, total)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stdout, "SKIPPED: %d/%d\n", skipped, total)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stdout, "FAIL: %d/%d\n", failed, total)
+		return fmt.Errorf("%d configuration checks failed", failed)
+	}
+	return nil
+}
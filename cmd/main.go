@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/logfmt"
@@ -12,21 +15,21 @@ import (
 	"github.com/mattn/go-isatty"
 	"github.com/open-policy-agent/opa/bundle"
 	"github.com/urfave/cli/v2"
-	"gopkg.in/yaml.v3"
 
 	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/bench"
 	"github.com/networkteam/vignet/policy"
 )
 
-type ctxKey int
-
-const (
-	ctxKeyConfig ctxKey = iota
-)
-
 func main() {
 	app := cli.NewApp()
 	app.Name = "vignet"
+	app.Version = vignet.Version
+	// The default version flag has the alias "v", which is used by --verbose
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version",
+	}
 	app.Usage = "The missing GitOps piece: expose Git repositories for automation via an authenticated HTTP API"
 	app.Flags = []cli.Flag{
 		&cli.StringFlag{
@@ -36,20 +39,76 @@ func main() {
 			Usage:    "Address for HTTP server to listen on",
 			EnvVars:  []string{"VIGNET_ADDRESS"},
 		},
+		&cli.DurationFlag{
+			Name:     "shutdown-timeout",
+			Category: "http",
+			Value:    30 * time.Second,
+			Usage:    "Time to wait for in-flight requests on shutdown",
+			EnvVars:  []string{"VIGNET_SHUTDOWN_TIMEOUT"},
+		},
+		&cli.PathFlag{
+			Name:     "tls-cert",
+			Category: "http",
+			Usage:    "Path to a PEM encoded certificate (chain) to serve HTTPS, overrides http.tls.certFile of the configuration",
+			EnvVars:  []string{"VIGNET_TLS_CERT"},
+		},
+		&cli.PathFlag{
+			Name:     "tls-key",
+			Category: "http",
+			Usage:    "Path to the PEM encoded private key of the certificate, overrides http.tls.keyFile of the configuration",
+			EnvVars:  []string{"VIGNET_TLS_KEY"},
+		},
+		&cli.DurationFlag{
+			Name:     "tls-reload-interval",
+			Category: "http",
+			Usage:    "Interval for checking the certificate and key for changes to reload a rotated certificate, overrides http.tls.reloadInterval of the configuration",
+			EnvVars:  []string{"VIGNET_TLS_RELOAD_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:     "h2c",
+			Category: "http",
+			Usage:    "Serve HTTP/2 without TLS (h2c) in addition to HTTP/1.1",
+			EnvVars:  []string{"VIGNET_H2C"},
+		},
 		&cli.PathFlag{
 			Name:     "config",
 			Category: "configuration",
 			Aliases:  []string{"c"},
-			Usage:    "Path to the configuration file",
+			Usage:    "Path to the configuration file, the default is skipped if it does not exist (e.g. to configure vignet by environment variables only)",
 			Value:    "config.yaml",
 			EnvVars:  []string{"VIGNET_CONFIG"},
 		},
+		&cli.PathFlag{
+			Name:     "config-dir",
+			Category: "configuration",
+			Usage:    "Path to a directory with YAML files (*.yaml, *.yml) that are merged with the configuration file",
+			EnvVars:  []string{"VIGNET_CONFIG_DIR"},
+		},
 		&cli.PathFlag{
 			Name:     "policy",
 			Category: "authorization",
-			Usage:    "Path to an OPA policy bundle path, uses the built-in by default",
+			Usage:    "Path to an OPA policy bundle path or HTTP(S) URL of a bundle tarball, uses the built-in by default",
 			EnvVars:  []string{"VIGNET_POLICY"},
 		},
+		&cli.DurationFlag{
+			Name:     "policy-refresh-interval",
+			Category: "authorization",
+			Value:    time.Minute,
+			Usage:    "Interval for refreshing a policy bundle from an URL",
+			EnvVars:  []string{"VIGNET_POLICY_REFRESH_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:     "policy-token",
+			Category: "authorization",
+			Usage:    "Bearer token for fetching a policy bundle from an URL",
+			EnvVars:  []string{"VIGNET_POLICY_TOKEN"},
+		},
+		&cli.BoolFlag{
+			Name:     "skip-self-test",
+			Category: "authorization",
+			Usage:    "Skip the self-test of the policy against the configuration on startup",
+			EnvVars:  []string{"VIGNET_SKIP_SELF_TEST"},
+		},
 		&cli.BoolFlag{
 			Name:     "verbose",
 			Aliases:  []string{"v"},
@@ -69,48 +128,218 @@ func main() {
 			log.SetLevel(log.DebugLevel)
 		}
 		setServerLogHandler(c)
-
-		config, err := loadConfig(c.Path("config"))
-		if err != nil {
-			return err
-		}
-		c.Context = context.WithValue(c.Context, ctxKeyConfig, config)
 		return nil
 	}
 	app.Description = "The default command starts the HTTP server that handles commands."
 	app.Action = func(c *cli.Context) error {
-		config := c.Context.Value(ctxKeyConfig).(vignet.Config)
-
-		authenticationProvider, err := config.BuildAuthenticationProvider(c.Context)
+		// Used tokens are remembered across generations
+		replayStores := vignet.NewTokenReplayStores()
+		gen, err := loadServerGeneration(c, replayStores)
 		if err != nil {
-			return fmt.Errorf("building authentication provider: %w", err)
+			return err
 		}
-		switch config.AuthenticationProvider.Type {
-		case vignet.AuthenticationProviderGitLab:
-			log.
-				WithField("gitlabUrl", config.AuthenticationProvider.GitLab.URL).
-				Infof("Using GitLab authentication provider")
-		default:
-			log.Infof("Using authentication provider %s", config.AuthenticationProvider.Type)
+
+		var (
+			srv *vignet.Server
+			// genMu guards gen, which is replaced by a reload from the admin listener
+			genMu sync.Mutex
+		)
+		// Reloads are serialized by the server
+		reload := func(ctx context.Context) error {
+			next, err := loadServerGeneration(c, replayStores)
+			if err != nil {
+				return err
+			}
+			err = srv.Reload(ctx, next.serverOptions()...)
+			if err != nil {
+				next.stop()
+				return err
+			}
+			genMu.Lock()
+			defer genMu.Unlock()
+			gen.stop()
+			gen = next
+			gen.start()
+			return nil
 		}
 
-		authorizer, err := buildAuthorizer(c)
+		srv, err = vignet.NewServer(
+			c.Context,
+			append(
+				gen.serverOptions(),
+				vignet.WithAddress(c.String("address")),
+				vignet.WithReloadFunc(reload),
+			)...,
+		)
 		if err != nil {
-			return fmt.Errorf("building authorizer: %w", err)
+			return fmt.Errorf("building server: %w", err)
 		}
 
-		h := vignet.NewHandler(authenticationProvider, authorizer, config)
-
-		// TODO Add graceful shutdown
-		log.WithField("address", c.String("address")).Infof("Starting HTTP server")
-		err = http.ListenAndServe(c.String("address"), h)
+		// The generation is started before the admin listener accepts reloads
+		gen.start()
+		err = srv.Start()
 		if err != nil {
+			gen.stop()
 			return fmt.Errorf("starting server: %w", err)
 		}
 
+		ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+
+		log.Infof("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), c.Duration("shutdown-timeout"))
+		defer cancel()
+		err = srv.Stop(shutdownCtx)
+		if err != nil {
+			return err
+		}
+		genMu.Lock()
+		gen.stop()
+		genMu.Unlock()
+
 		return nil
 	}
 
+	app.Commands = []*cli.Command{
+		{
+			Name:   "bench",
+			Usage:  "Benchmark the patch pipeline against a synthetic repository",
+			Hidden: true,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "files",
+					Value: bench.DefaultOptions.Files,
+					Usage: "Number of files in the synthetic repository",
+				},
+				&cli.IntFlag{
+					Name:  "file-size",
+					Value: bench.DefaultOptions.FileSize,
+					Usage: "Approximate size of each file in bytes",
+				},
+				&cli.IntFlag{
+					Name:  "requests",
+					Value: bench.DefaultOptions.Requests,
+					Usage: "Total number of patch requests",
+				},
+				&cli.IntFlag{
+					Name:  "concurrency",
+					Value: bench.DefaultOptions.Concurrency,
+					Usage: "Number of concurrent requests",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Send dry run requests without commit and push",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				// Only log warnings and errors, the summary is printed after the run
+				if !c.Bool("verbose") {
+					log.SetLevel(log.WarnLevel)
+				}
+
+				result, err := bench.Run(c.Context, bench.Options{
+					Files:       c.Int("files"),
+					FileSize:    c.Int("file-size"),
+					Requests:    c.Int("requests"),
+					Concurrency: c.Int("concurrency"),
+					DryRun:      c.Bool("dry-run"),
+				})
+				if err != nil {
+					return err
+				}
+				result.WriteSummary(os.Stdout)
+
+				return nil
+			},
+		},
+		{
+			Name:  "replay",
+			Usage: "Replay operations from audit records against the configured repositories (e.g. onto a restored mirror)",
+			Description: "Reads audit records as JSON lines (see audit.file in the configuration) and re-executes the selected\n" +
+				"pushed patches in order. Authorization is skipped, since the operations were authorized when they were recorded.\n" +
+				"Each operation has to be confirmed interactively, unless --yes is given.",
+			Flags: []cli.Flag{
+				&cli.PathFlag{
+					Name:     "records",
+					Usage:    "Path to a file with audit records as JSON lines, use - for stdin",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "repo",
+					Usage: "Only replay operations on the repository",
+				},
+				&cli.StringSliceFlag{
+					Name:  "commit",
+					Usage: "Only replay operations that pushed the (abbreviated) commit hash, can be repeated",
+				},
+				&cli.TimestampFlag{
+					Name:   "since",
+					Layout: time.RFC3339,
+					Usage:  "Only replay operations at or after the time (RFC 3339)",
+				},
+				&cli.TimestampFlag{
+					Name:   "until",
+					Layout: time.RFC3339,
+					Usage:  "Only replay operations before the time (RFC 3339)",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Print the diff of each operation without committing and pushing",
+				},
+				&cli.BoolFlag{
+					Name:    "yes",
+					Aliases: []string{"y"},
+					Usage:   "Replay all selected operations without confirmation",
+				},
+			},
+			Action: replayAction,
+		},
+		{
+			Name:  "config",
+			Usage: "Work with the configuration",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "validate",
+					Usage: "Load and validate the configuration (set by --config and --config-dir)",
+					Description: "Validates the configuration and optionally compiles the policy (set by --policy or the built-in) with a\n" +
+						"self-test against the configuration and checks that repositories are reachable with their credentials.\n" +
+						"Prints a report and exits with a non-zero exit code if a check failed, e.g. to check changes in CI.",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "check-policy",
+							Usage: "Compile the policy and run the self-test against the configuration",
+						},
+						&cli.BoolFlag{
+							Name:  "check-repositories",
+							Usage: "Check that repositories are reachable by listing their refs",
+						},
+					},
+					Action: configValidateAction,
+				},
+			},
+		},
+		{
+			Name:  "policy",
+			Usage: "Work with policy bundles",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "test",
+					Usage: "Run the Rego tests and fixtures against the policy bundle (set by --policy or the built-in)",
+					Description: "Runs the Rego unit tests of the bundle (rules prefixed with test_, like opa test) and evaluates\n" +
+						"fixtures from JSON files with an input and the expected violations. Exits with a non-zero exit code if a test fails.",
+					Flags: []cli.Flag{
+						&cli.PathFlag{
+							Name:  "fixtures",
+							Usage: "Path to a directory with fixtures as JSON files",
+						},
+					},
+					Action: policyTestAction,
+				},
+			},
+		},
+	}
+
 	// TODO Add API to test authorization for commands
 
 	err := app.Run(os.Args)
@@ -120,49 +349,282 @@ func main() {
 	}
 }
 
-func loadConfig(configFilename string) (vignet.Config, error) {
-	configFile, err := os.Open(configFilename)
+// loadConfig loads the configuration file, the files of the configuration directory (if set) and the values of
+// environment variables and validates it.
+func loadConfig(c *cli.Context) (vignet.Config, error) {
+	filenames, err := configFilenames(c)
 	if err != nil {
-		return vignet.Config{}, fmt.Errorf("opening config file: %w", err)
+		return vignet.Config{}, err
 	}
-	defer configFile.Close()
-
-	config := vignet.DefaultConfig
-	err = yaml.NewDecoder(configFile).Decode(&config)
+	config, err := vignet.LoadConfigWithEnv(os.Environ(), filenames...)
 	if err != nil {
-		return vignet.Config{}, fmt.Errorf("decoding config file: %w", err)
+		return vignet.Config{}, err
 	}
 	err = config.Validate()
 	if err != nil {
-		return vignet.Config{}, fmt.Errorf("validating config file: %w", err)
+		return vignet.Config{}, fmt.Errorf("validating config: %w", err)
 	}
 	return config, nil
 }
 
-func buildAuthorizer(c *cli.Context) (vignet.Authorizer, error) {
+// configFilenames returns the configuration file and the files of the configuration directory (if set). The default
+// configuration file is skipped if it does not exist, so the configuration can be set by a directory or environment
+// variables only.
+func configFilenames(c *cli.Context) ([]string, error) {
+	var filenames []string
+	configFilename := c.Path("config")
+	if _, err := os.Stat(configFilename); err == nil || c.IsSet("config") {
+		filenames = append(filenames, configFilename)
+	}
+	if dir := c.Path("config-dir"); dir != "" {
+		dirFilenames, err := vignet.ConfigDirFilenames(dir)
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, dirFilenames...)
+		if len(filenames) == 0 {
+			return nil, fmt.Errorf("no configuration files in %s", dir)
+		}
+	}
+	return filenames, nil
+}
+
+// serverGeneration is the configuration with the authentication provider and authorizer built from it, it is replaced
+// when the server is reloaded.
+type serverGeneration struct {
+	config                 vignet.Config
+	authenticationProvider vignet.AuthenticationProvider
+	authorizer             vignet.Authorizer
+	watchPolicy            func(ctx context.Context)
+
+	// ctx is cancelled when the generation is replaced, to stop refreshing keys and policy bundles
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// loadServerGeneration loads the configuration file and policy bundle and builds the authentication provider and
+// authorizer. The token replay stores are taken from replayStores.
+func loadServerGeneration(c *cli.Context, replayStores *vignet.TokenReplayStores) (*serverGeneration, error) {
+	config, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	err = applyHTTPFlags(c, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	gen := &serverGeneration{
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	gen.authenticationProvider, err = config.BuildAuthenticationProviderWithReplayStores(ctx, replayStores)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("building authentication provider: %w", err)
+	}
+	for _, p := range config.AuthenticationProviderConfigs() {
+		switch p.Type {
+		case vignet.AuthenticationProviderGitLab:
+			log.
+				WithField("gitlabUrl", p.GitLab.URL).
+				WithField("staticJWKS", p.GitLab.JWKS != "" || p.GitLab.JWKSFile != "").
+				Infof("Using GitLab authentication provider")
+		default:
+			log.Infof("Using authentication provider %s", p.Type)
+		}
+	}
+
+	selfTest := func(ctx context.Context, authorizer *vignet.RegoAuthorizer) error {
+		err := vignet.SelfTest(ctx, authorizer, config)
+		if err != nil {
+			return fmt.Errorf("self-test of policy failed: %w", err)
+		}
+		log.Debug("Self-test of policy passed")
+		return nil
+	}
+	if c.Bool("skip-self-test") {
+		log.Warn("Skipping self-test of policy")
+		selfTest = nil
+	}
+
+	gen.authorizer, gen.watchPolicy, err = buildAuthorizer(c, config, selfTest)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("building authorizer: %w", err)
+	}
+
+	return gen, nil
+}
+
+func (g *serverGeneration) serverOptions() []vignet.ServerOption {
+	return []vignet.ServerOption{
+		vignet.WithConfig(g.config),
+		vignet.WithAuthenticationProvider(g.authenticationProvider),
+		vignet.WithAuthorizer(g.authorizer),
+	}
+}
+
+// start watches or refreshes the policy bundle in the background.
+func (g *serverGeneration) start() {
+	if g.watchPolicy != nil {
+		go g.watchPolicy(g.ctx)
+	}
+}
+
+func (g *serverGeneration) stop() {
+	g.cancel()
+}
+
+// applyHTTPFlags overrides the HTTP configuration with the flags for serving TLS and h2c.
+func applyHTTPFlags(c *cli.Context, config *vignet.Config) error {
+	certFile, keyFile := c.Path("tls-cert"), c.Path("tls-key")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return fmt.Errorf("--tls-cert and --tls-key must be given together")
+		}
+		tlsConfig := vignet.TLSConfig{}
+		if config.HTTP.TLS != nil {
+			tlsConfig = *config.HTTP.TLS
+		}
+		tlsConfig.CertFile = certFile
+		tlsConfig.KeyFile = keyFile
+		config.HTTP.TLS = &tlsConfig
+	}
+	if c.IsSet("tls-reload-interval") {
+		if config.HTTP.TLS == nil {
+			return fmt.Errorf("--tls-reload-interval needs a certificate, set --tls-cert and --tls-key")
+		}
+		config.HTTP.TLS.ReloadInterval = c.Duration("tls-reload-interval")
+	}
+	if c.Bool("h2c") {
+		config.HTTP.H2C = true
+	}
+
+	if err := config.HTTP.Valid(); err != nil {
+		return fmt.Errorf("invalid http flags: %w", err)
+	}
+	return nil
+}
+
+// policyWatchInterval is the interval for checking a local policy bundle for changes.
+const policyWatchInterval = 2 * time.Second
+
+// buildAuthorizer builds the authorizer for the policy bundle and runs the self-test if set.
+// For a bundle URL or a local bundle a function is returned that refreshes the bundle until the context is done.
+func buildAuthorizer(c *cli.Context, config vignet.Config, selfTest func(ctx context.Context, authorizer *vignet.RegoAuthorizer) error) (vignet.Authorizer, func(ctx context.Context), error) {
 	var (
 		b   *bundle.Bundle
 		err error
 	)
 
-	if c.IsSet("policy") {
-		policyPath := c.Path("policy")
-		b, err = policy.LoadBundle(policyPath)
+	// An OPA server or rules of the configuration are used instead of a policy bundle
+	var configured vignet.Authorizer
+	switch {
+	case config.Authorization.OPAServer != nil:
+		configured = config.Authorization.OPAServer.Build()
+		log.
+			WithField("opaServerUrl", config.Authorization.OPAServer.URL).
+			Infof("Using OPA server for authorization")
+	case len(config.Authorization.Rules) > 0:
+		configured, err = vignet.NewRulesAuthorizer(config.Authorization.Rules)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Using authorization rules of configuration")
+	}
+	if configured != nil {
+		if c.IsSet("policy") {
+			return nil, nil, fmt.Errorf("--policy cannot be combined with authorization.opaServer or authorization.rules")
+		}
+		if !c.Bool("skip-self-test") {
+			err = vignet.SelfTest(c.Context, configured, config)
+			if err != nil {
+				return nil, nil, fmt.Errorf("self-test of policy failed: %w", err)
+			}
+			log.Debug("Self-test of policy passed")
+		}
+		return configured, nil, nil
+	}
+
+	data, err := config.Authorization.LoadData()
+	if err != nil {
+		return nil, nil, err
+	}
+	authorizerOpts := []vignet.RegoAuthorizerOption{vignet.WithRegoData(data)}
+
+	if c.IsSet("policy") && policy.IsRemoteBundle(c.String("policy")) {
+		policyURL := c.String("policy")
+		opts := []vignet.PolicyRefresherOption{
+			vignet.WithPolicyCheck(selfTest),
+			vignet.WithPolicyAuthorizerOptions(authorizerOpts...),
+		}
+		if token := c.String("policy-token"); token != "" {
+			opts = append(opts, vignet.WithPolicyBearerToken(token))
+		}
+		refresher := vignet.NewPolicyRefresher(policyURL, opts...)
+		_, err = refresher.Refresh(c.Context)
 		if err != nil {
-			return nil, fmt.Errorf("loading policy bundle: %w", err)
+			return nil, nil, fmt.Errorf("loading policy bundle: %w", err)
 		}
 		log.
-			WithField("policyPath", policyPath).
+			WithField("policyUrl", policyURL).
+			WithField("refreshInterval", c.Duration("policy-refresh-interval")).
 			Infof("Loaded policy bundle")
-	} else {
+		return refresher.Authorizer(), func(ctx context.Context) {
+			refresher.Run(ctx, c.Duration("policy-refresh-interval"))
+		}, nil
+	}
+
+	if !c.IsSet("policy") {
 		b, err = policy.LoadDefaultBundle()
 		if err != nil {
-			return nil, fmt.Errorf("loading default bundle: %w", err)
+			return nil, nil, fmt.Errorf("loading default bundle: %w", err)
 		}
 		log.Infof("Loaded default policy bundle")
+
+		authorizer, err := newCheckedAuthorizer(c.Context, b, selfTest, authorizerOpts)
+		return authorizer, nil, err
+	}
+
+	policyPath := c.Path("policy")
+	b, err = policy.LoadBundle(policyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading policy bundle: %w", err)
 	}
+	log.
+		WithField("policyPath", policyPath).
+		Infof("Loaded policy bundle")
 
-	return vignet.NewRegoAuthorizer(c.Context, b)
+	authorizer, err := newCheckedAuthorizer(c.Context, b, selfTest, authorizerOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watcher, err := vignet.NewPolicyWatcher(policyPath, authorizer, selfTest, authorizerOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("watching policy bundle: %w", err)
+	}
+	return authorizer, func(ctx context.Context) {
+		watcher.Run(ctx, policyWatchInterval)
+	}, nil
+}
+
+func newCheckedAuthorizer(ctx context.Context, b *bundle.Bundle, selfTest func(ctx context.Context, authorizer *vignet.RegoAuthorizer) error, opts []vignet.RegoAuthorizerOption) (*vignet.RegoAuthorizer, error) {
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, b, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if selfTest != nil {
+		err = selfTest(ctx, authorizer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return authorizer, nil
 }
 
 func setServerLogHandler(c *cli.Context) {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/urfave/cli/v2"
+
+	"github.com/networkteam/vignet/policy"
+)
+
+func policyTestAction(c *cli.Context) error {
+	b, err := loadPolicyBundle(c)
+	if err != nil {
+		return err
+	}
+
+	var passed, failed, skipped int
+	verbose := c.Bool("verbose")
+
+	results, err := policy.RunTests(c.Context, b)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		switch {
+		case result.Skip:
+			skipped++
+		case result.Pass():
+			passed++
+		default:
+			failed++
+		}
+		if verbose || (!result.Pass() && !result.Skip) {
+			fmt.Fprintln(os.Stdout, result.String())
+			if result.Error != nil {
+				fmt.Fprintf(os.Stdout, "  %v\n", result.Error)
+			}
+		}
+	}
+
+	if c.IsSet("fixtures") {
+		fixtures, err := policy.LoadFixtures(c.Path("fixtures"))
+		if err != nil {
+			return fmt.Errorf("loading fixtures: %w", err)
+		}
+		for _, result := range policy.RunFixtures(c.Context, b, fixtures) {
+			if result.Pass() {
+				passed++
+				if verbose {
+					fmt.Fprintf(os.Stdout, "%s: PASS\n", result.Fixture.Name)
+				}
+				continue
+			}
+
+			failed++
+			if result.Error != nil {
+				fmt.Fprintf(os.Stdout, "%s: ERROR\n  %v\n", result.Fixture.Name, result.Error)
+				continue
+			}
+			fmt.Fprintf(os.Stdout, "%s: FAIL\n  expected violations: %q\n  actual violations:   %q\n", result.Fixture.Name, result.Fixture.ExpectedViolations, result.Violations)
+		}
+	}
+
+	total := passed + failed + skipped
+	fmt.Fprintf(os.Stdout, "PASS: %d/%d\n", passed, total)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stdout, "SKIPPED: %d/%d\n", skipped, total)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stdout, "FAIL: %d/%d\n", failed, total)
+		return fmt.Errorf("%d policy tests failed", failed)
+	}
+	return nil
+}
+
+// loadPolicyBundle loads the bundle set by --policy (a local path or URL) or the default bundle.
+func loadPolicyBundle(c *cli.Context) (*bundle.Bundle, error) {
+	if !c.IsSet("policy") {
+		return policy.LoadDefaultBundle()
+	}
+
+	location := c.String("policy")
+	if policy.IsRemoteBundle(location) {
+		header := make(http.Header)
+		if token := c.String("policy-token"); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+		b, _, err := policy.FetchBundle(c.Context, http.DefaultClient, location, header, "")
+		if err != nil {
+			return nil, fmt.Errorf("fetching policy bundle: %w", err)
+		}
+		return b, nil
+	}
+
+	b, err := policy.LoadBundle(location)
+	if err != nil {
+		return nil, fmt.Errorf("loading policy bundle: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/networkteam/vignet"
+)
+
+func replayAction(c *cli.Context) error {
+	config, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Path("records") == "-" && !c.Bool("yes") {
+		return fmt.Errorf("--yes is required to read records from stdin, since stdin is used for confirmation")
+	}
+
+	records, err := readAuditRecordsFile(c.Path("records"))
+	if err != nil {
+		return err
+	}
+
+	filter := vignet.ReplayFilter{
+		Repo:    c.String("repo"),
+		Commits: c.StringSlice("commit"),
+	}
+	if t := c.Timestamp("since"); t != nil {
+		filter.Since = *t
+	}
+	if t := c.Timestamp("until"); t != nil {
+		filter.Until = *t
+	}
+	// Patches that were rolled back are skipped
+	selected := filter.Select(records)
+	fmt.Fprintf(os.Stdout, "Selected %d of %d records for replay\n", len(selected), len(records))
+
+	replayer := vignet.NewReplayer(config)
+	locker, err := config.BuildLocker()
+	if err != nil {
+		return fmt.Errorf("building locker: %w", err)
+	}
+	replayer.SetLocker(locker)
+
+	dryRun := c.Bool("dry-run")
+	stdin := bufio.NewReader(os.Stdin)
+	for i, record := range selected {
+		fmt.Fprintf(os.Stdout, "\n[%d/%d] %s %s commit %s\n", i+1, len(selected), record.Time.Format(time.RFC3339), record.Repo, record.CommitHash)
+		for _, p := range record.Paths {
+			fmt.Fprintf(os.Stdout, "  %s\n", p)
+		}
+
+		if !c.Bool("yes") {
+			answer, err := confirm(stdin, "Replay operation? [y/N/q] ")
+			if err != nil {
+				return err
+			}
+			if answer == "q" {
+				return nil
+			}
+			if answer != "y" {
+				fmt.Fprintln(os.Stdout, "Skipped")
+				continue
+			}
+		}
+
+		res, err := replayer.Replay(c.Context, record, dryRun)
+		if err != nil {
+			return fmt.Errorf("replaying record %d (commit %s): %w", i+1, record.CommitHash, err)
+		}
+		if dryRun {
+			fmt.Fprint(os.Stdout, res.Diff)
+		} else {
+			fmt.Fprintf(os.Stdout, "Pushed commit %s to %s\n", res.Commit, res.Branch)
+		}
+	}
+
+	return nil
+}
+
+func readAuditRecordsFile(filename string) ([]vignet.AuditRecord, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening records file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	return vignet.ReadAuditRecords(r)
+}
+
+func confirm(r *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stdout, prompt)
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading confirmation: %w", err)
+	}
+	return strings.ToLower(strings.TrimSpace(line)), nil
+}
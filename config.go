@@ -1,25 +1,421 @@
 package vignet
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/ssh"
+	goyaml "gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	// AuthenticationProvider configures the authentication provider to use for authenticating requests.
-	AuthenticationProvider struct {
-		Type AuthenticationProviderType `yaml:"type"`
-		// GitLab must be set for type `gitlab`
-		GitLab *struct {
-			URL string `yaml:"url"`
-		} `yaml:"gitlab"`
-	} `yaml:"authenticationProvider"`
+	AuthenticationProvider AuthenticationProviderConfig `yaml:"authenticationProvider"`
+	// AuthenticationProviders configures multiple authentication providers that are tried in order, a request is
+	// authenticated by the first provider that accepts it. It cannot be combined with AuthenticationProvider.
+	AuthenticationProviders []AuthenticationProviderConfig `yaml:"authenticationProviders"`
+
+	// Authorization configures how requests are authorized, the embedded policy bundle is used by default.
+	Authorization AuthorizationConfig `yaml:"authorization"`
 
 	// Repositories indexed by an identifier.
 	Repositories RepositoriesConfig `yaml:"repositories"`
 
 	// Commit configures commit options when creating a new commit.
 	Commit CommitConfig `yaml:"commit"`
+
+	// Notifications configures built-in notifiers.
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	// HTTP configures handling of HTTP requests.
+	HTTP HTTPConfig `yaml:"http"`
+
+	// Locking configures how operations on a repository are serialized.
+	Locking LockingConfig `yaml:"locking"`
+
+	// Audit configures built-in audit sinks.
+	Audit AuditConfig `yaml:"audit"`
+
+	// Limits of patch requests.
+	Limits LimitsConfig `yaml:"limits"`
+
+	// RateLimits limits the rate of requests per identity and repository (optional).
+	RateLimits RateLimitsConfig `yaml:"rateLimits"`
+
+	// Timeouts of requests and Git operations.
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+
+	// ConsistencyTokens configures the tokens returned for pushed patches.
+	ConsistencyTokens ConsistencyTokensConfig `yaml:"consistencyTokens"`
+
+	// Discovery registers repositories automatically (optional).
+	Discovery DiscoveryConfig `yaml:"discovery"`
+
+	// Features enables or disables command types and subsystems, all features are enabled by default.
+	Features FeaturesConfig `yaml:"features"`
+
+	// Admin enables the admin endpoints, e.g. to reload the configuration at runtime (optional).
+	Admin *AdminConfig `yaml:"admin"`
+
+	// FaultInjection enables injection of faults for testing failure handling of clients (optional).
+	// Never enable this in production!
+	FaultInjection *FaultInjectionConfig `yaml:"faultInjection"`
+}
+
+type HTTPConfig struct {
+	// TrustedProxies are IPs or CIDRs of proxies that are trusted to set X-Forwarded-For for resolving the client IP.
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// PolicyHeaders are names of request headers that are exposed to the policy input.
+	// Sensitive headers (e.g. Authorization) are never exposed.
+	PolicyHeaders []string `yaml:"policyHeaders"`
+	// AccessLog configures the access log of requests.
+	AccessLog AccessLogConfig `yaml:"accessLog"`
+	// AllowUnknownFields ignores unknown fields in JSON request bodies instead of rejecting them, e.g. for clients
+	// sending fields of a newer API version. Defaults to false.
+	AllowUnknownFields bool `yaml:"allowUnknownFields"`
+	// TLS configures serving HTTPS instead of HTTP (optional). HTTP/2 is negotiated with clients that support it.
+	TLS *TLSConfig `yaml:"tls"`
+	// H2C serves HTTP/2 without TLS in addition to HTTP/1.1 (optional), e.g. for a proxy that sends HTTP/2 to
+	// backends. It cannot be combined with TLS.
+	H2C bool `yaml:"h2c"`
+}
+
+type TLSConfig struct {
+	// CertFile is the path to the PEM encoded certificate (chain) of the server.
+	CertFile string `yaml:"certFile"`
+	// KeyFile is the path to the PEM encoded private key of the server.
+	KeyFile string `yaml:"keyFile"`
+	// ClientCAFile is the path to PEM encoded CA certificates to verify client certificates (optional).
+	// Client certificates are verified if sent, but not required, so requests can be authenticated by other providers.
+	ClientCAFile string `yaml:"clientCAFile"`
+	// ReloadInterval is the interval for checking CertFile and KeyFile for changes (optional). If set, a rotated
+	// certificate is used without a restart.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+func (c TLSConfig) Valid() error {
+	if c.CertFile == "" {
+		return fmt.Errorf("certFile must be set")
+	}
+	if c.KeyFile == "" {
+		return fmt.Errorf("keyFile must be set")
+	}
+	if c.ReloadInterval < 0 {
+		return fmt.Errorf("reloadInterval must not be negative")
+	}
+	return nil
+}
+
+// Build loads the certificate of the server and the client CAs.
+func (c TLSConfig) Build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Prefer HTTP/2, the HTTP server falls back to HTTP/1.1 for other clients
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if c.ReloadInterval > 0 {
+		reloader, err := newCertificateReloader(c.CertFile, c.KeyFile, c.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = reloader.getCertificate
+	} else {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+func (c HTTPConfig) Valid() error {
+	if _, err := parseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trustedProxies: %w", err)
+	}
+	for _, name := range c.PolicyHeaders {
+		if isSensitiveHeader(name) {
+			return fmt.Errorf("invalid policyHeaders: header %q must not be exposed", name)
+		}
+	}
+	if err := c.AccessLog.Valid(); err != nil {
+		return fmt.Errorf("invalid accessLog: %w", err)
+	}
+	if c.TLS != nil {
+		if err := c.TLS.Valid(); err != nil {
+			return fmt.Errorf("invalid tls: %w", err)
+		}
+		if c.H2C {
+			return fmt.Errorf("h2c cannot be combined with tls, HTTP/2 is negotiated for TLS connections")
+		}
+	}
+	return nil
+}
+
+type AuthorizationConfig struct {
+	// OPAServer queries a remote OPA server for decisions instead of the embedded policy bundle (optional).
+	OPAServer *OPAServerAuthorizationConfig `yaml:"opaServer"`
+	// Rules are declarative authorization rules by repository name that are used instead of the policy bundle
+	// (optional). Patch requests on repositories without rules are denied.
+	Rules map[string]RepositoryRulesConfig `yaml:"rules"`
+	// CurrentFileContent passes the parsed documents of targeted YAML and JSON files to the policy in
+	// input.current[].documents, so policies can check the whole file before the change.
+	CurrentFileContent bool `yaml:"currentFileContent"`
+	// Data are static data documents that are added to the data of the policy bundle (optional), e.g. a mapping of
+	// teams to repositories that is available as data.teams.
+	Data map[string]any `yaml:"data"`
+	// DataFile is the path to a JSON or YAML file with data documents, instead of Data (optional).
+	DataFile string `yaml:"dataFile"`
+}
+
+// LoadData returns the static data documents of Data or DataFile.
+func (c AuthorizationConfig) LoadData() (map[string]any, error) {
+	if c.DataFile == "" {
+		return c.Data, nil
+	}
+
+	f, err := os.Open(c.DataFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening data file: %w", err)
+	}
+	defer f.Close()
+
+	// JSON is a subset of YAML
+	var data map[string]any
+	err = goyaml.NewDecoder(f).Decode(&data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding data file: %w", err)
+	}
+	return data, nil
+}
+
+type OPAServerAuthorizationConfig struct {
+	// URL is the base URL of the OPA server, e.g. "http://opa:8181".
+	URL string `yaml:"url"`
+	// Token is sent as bearer token (optional).
+	Token string `yaml:"token"`
+	// Timeout of a query, defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c OPAServerAuthorizationConfig) Valid() error {
+	if c.URL == "" {
+		return fmt.Errorf("url must be set")
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("url must be an HTTP(S) URL")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// Build creates the authorizer for the OPA server.
+func (c OPAServerAuthorizationConfig) Build() *OPAServerAuthorizer {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return NewOPAServerAuthorizer(c.URL, c.Token, &http.Client{Timeout: timeout})
+}
+
+type LimitsConfig struct {
+	// MaxBodySize is the maximum size of a request body in bytes, defaults to 32 MiB.
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// MaxCommands is the maximum number of commands of a patch request, defaults to 100.
+	MaxCommands int `yaml:"maxCommands"`
+	// MaxFileSize is the maximum size of a file patched or created by a command in bytes, defaults to 10 MiB.
+	MaxFileSize int64 `yaml:"maxFileSize"`
+}
+
+const (
+	defaultMaxBodySize = 32 << 20
+	defaultMaxCommands = 100
+	defaultMaxFileSize = 10 << 20
+)
+
+func (c LimitsConfig) Valid() error {
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("maxBodySize must not be negative")
+	}
+	if c.MaxCommands < 0 {
+		return fmt.Errorf("maxCommands must not be negative")
+	}
+	if c.MaxFileSize < 0 {
+		return fmt.Errorf("maxFileSize must not be negative")
+	}
+	return nil
+}
+
+func (c LimitsConfig) maxBodySize() int64 {
+	if c.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+	return c.MaxBodySize
+}
+
+func (c LimitsConfig) maxCommands() int {
+	if c.MaxCommands == 0 {
+		return defaultMaxCommands
+	}
+	return c.MaxCommands
+}
+
+func (c LimitsConfig) maxFileSize() int64 {
+	if c.MaxFileSize == 0 {
+		return defaultMaxFileSize
+	}
+	return c.MaxFileSize
+}
+
+type LockingConfig struct {
+	// Backend for locks, defaults to LockingBackendMemory.
+	Backend LockingBackend `yaml:"backend"`
+	// Redis must be set for backend `redis`.
+	Redis *RedisLockingConfig `yaml:"redis"`
+}
+
+type LockingBackend string
+
+const (
+	// LockingBackendMemory locks within a single instance.
+	LockingBackendMemory LockingBackend = "memory"
+	// LockingBackendRedis locks across multiple instances using Redis.
+	LockingBackendRedis LockingBackend = "redis"
+)
+
+// RedisConfig configures the connection to a Redis server.
+type RedisConfig struct {
+	// Address of the Redis server (host:port).
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+func (c RedisConfig) client() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     c.Address,
+		Username: c.Username,
+		Password: c.Password,
+		DB:       c.DB,
+	})
+}
+
+type RedisLockingConfig struct {
+	RedisConfig `yaml:",inline"`
+	// KeyPrefix for lock keys, defaults to "vignet:lock:".
+	KeyPrefix string `yaml:"keyPrefix"`
+	// TTL of a lock before it expires if it is not extended (e.g. after a crash), defaults to 30s.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// minLockTTL is the minimum TTL of locks in Redis.
+const minLockTTL = time.Second
+
+func (c LockingConfig) Valid() error {
+	switch c.Backend {
+	case "", LockingBackendMemory:
+	case LockingBackendRedis:
+		if c.Redis == nil || c.Redis.Address == "" {
+			return fmt.Errorf("redis.address required for backend %q", c.Backend)
+		}
+		// The lock is extended every third of the TTL, a short TTL would expire before it is extended
+		if c.Redis.TTL != 0 && c.Redis.TTL < minLockTTL {
+			return fmt.Errorf("redis.ttl must be at least %s", minLockTTL)
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %q", c.Backend)
+	}
+	return nil
+}
+
+// BuildLocker builds the locker for the configured backend.
+func (c Config) BuildLocker() (Locker, error) {
+	switch c.Locking.Backend {
+	case "", LockingBackendMemory:
+		return NewMemoryLocker(), nil
+	case LockingBackendRedis:
+		rc := c.Locking.Redis
+		if rc == nil {
+			return nil, fmt.Errorf("missing redis configuration")
+		}
+		keyPrefix := rc.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = "vignet:lock:"
+		}
+		ttl := rc.TTL
+		if ttl == 0 {
+			ttl = 30 * time.Second
+		}
+		return NewRedisLocker(rc.client(), keyPrefix, ttl), nil
+	default:
+		return nil, fmt.Errorf("unsupported locking backend: %q", c.Locking.Backend)
+	}
+}
+
+// FaultInjectionConfig configures rates (probability from 0 to 1) of injected faults.
+// Faults can also be requested deterministically via the FaultInjectionHeader.
+type FaultInjectionConfig struct {
+	// Delay to add before handling a request.
+	Delay time.Duration `yaml:"delay"`
+	// DelayRate is the rate of requests to delay.
+	DelayRate float64 `yaml:"delayRate"`
+	// PushRejectionRate is the rate of pushes to reject.
+	PushRejectionRate float64 `yaml:"pushRejectionRate"`
+	// AuthenticationOutageRate is the rate of requests where authentication fails with an internal error.
+	AuthenticationOutageRate float64 `yaml:"authenticationOutageRate"`
+}
+
+func (c FaultInjectionConfig) Valid() error {
+	for name, rate := range map[string]float64{
+		"delayRate":                c.DelayRate,
+		"pushRejectionRate":        c.PushRejectionRate,
+		"authenticationOutageRate": c.AuthenticationOutageRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	return nil
+}
+
+type NotificationsConfig struct {
+	// GitLabMergeRequest comments on the merge request of the triggering GitLab pipeline if set.
+	GitLabMergeRequest *GitLabMergeRequestNotificationConfig `yaml:"gitLabMergeRequest"`
+}
+
+type GitLabMergeRequestNotificationConfig struct {
+	// URL of the GitLab instance, defaults to authenticationProvider.gitlab.url.
+	URL string `yaml:"url"`
+	// Token is an access token with scope "api" to comment on merge requests.
+	Token string `yaml:"token"`
 }
 
 // DefaultConfig is the default configuration that will be overwritten by the configuration file.
@@ -34,15 +430,119 @@ var DefaultConfig = Config{
 }
 
 func (c Config) Validate() error {
-	if len(c.Repositories) == 0 {
+	if len(c.Repositories) == 0 && len(c.Discovery.GitLabGroups) == 0 {
 		return fmt.Errorf("invalid repositories: empty")
 	}
-	if !c.AuthenticationProvider.Type.IsValid() {
-		return fmt.Errorf("invalid authenticationProvider.type: %q", c.AuthenticationProvider.Type)
+	if len(c.AuthenticationProviders) > 0 {
+		if c.AuthenticationProvider.Type != "" {
+			return fmt.Errorf("invalid authenticationProvider: cannot be combined with authenticationProviders")
+		}
+		names := make(map[string]bool, len(c.AuthenticationProviders))
+		for i, p := range c.AuthenticationProviders {
+			if err := p.Valid(); err != nil {
+				return fmt.Errorf("invalid authenticationProviders[%d]: %w", i, err)
+			}
+			if names[p.name()] {
+				return fmt.Errorf("invalid authenticationProviders[%d]: duplicate name %q", i, p.name())
+			}
+			names[p.name()] = true
+		}
+	} else if err := c.AuthenticationProvider.Valid(); err != nil {
+		return fmt.Errorf("invalid authenticationProvider: %w", err)
+	}
+	for _, p := range c.AuthenticationProviderConfigs() {
+		if p.Type == AuthenticationProviderMTLS && (c.HTTP.TLS == nil || c.HTTP.TLS.ClientCAFile == "") {
+			return fmt.Errorf("invalid authentication provider %q: http.tls.clientCAFile must be set for type %q", p.name(), p.Type)
+		}
+	}
+	if c.Authorization.OPAServer != nil {
+		if err := c.Authorization.OPAServer.Valid(); err != nil {
+			return fmt.Errorf("invalid authorization.opaServer: %w", err)
+		}
+		if len(c.Authorization.Rules) > 0 {
+			return fmt.Errorf("invalid authorization.rules: cannot be combined with authorization.opaServer")
+		}
+	}
+	if c.Authorization.DataFile != "" && len(c.Authorization.Data) > 0 {
+		return fmt.Errorf("invalid authorization.dataFile: cannot be combined with authorization.data")
+	}
+	if (c.Authorization.DataFile != "" || len(c.Authorization.Data) > 0) && (c.Authorization.OPAServer != nil || len(c.Authorization.Rules) > 0) {
+		return fmt.Errorf("invalid authorization.data: only supported for policy bundles")
+	}
+	for repo, rules := range c.Authorization.Rules {
+		// Rules of discovered repositories cannot be checked, since they are only known at runtime
+		if _, exists := c.Repositories[repo]; !exists && len(c.Discovery.GitLabGroups) == 0 {
+			return fmt.Errorf("invalid authorization.rules[%q]: repository not configured", repo)
+		}
+		if err := rules.Valid(); err != nil {
+			return fmt.Errorf("invalid authorization.rules[%q]: %w", repo, err)
+		}
 	}
 	if err := c.Commit.DefaultAuthor.Valid(); err != nil {
 		return fmt.Errorf("invalid commit.defaultAuthor: %w", err)
 	}
+	if err := c.Commit.validateSources(); err != nil {
+		return fmt.Errorf("invalid commit: %w", err)
+	}
+	if _, err := parseCommitMessageTemplate(c.Commit.MessageTemplate); err != nil {
+		return fmt.Errorf("invalid commit.messageTemplate: %w", err)
+	}
+	if c.Commit.SigningKey != nil {
+		if err := c.Commit.SigningKey.Valid(); err != nil {
+			return fmt.Errorf("invalid commit.signingKey: %w", err)
+		}
+	}
+	if err := c.HTTP.Valid(); err != nil {
+		return fmt.Errorf("invalid http: %w", err)
+	}
+	if err := c.Locking.Valid(); err != nil {
+		return fmt.Errorf("invalid locking: %w", err)
+	}
+	if err := c.Limits.Valid(); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
+	}
+	if err := c.RateLimits.Valid(); err != nil {
+		return fmt.Errorf("invalid rateLimits: %w", err)
+	}
+	if err := c.Timeouts.Valid(); err != nil {
+		return fmt.Errorf("invalid timeouts: %w", err)
+	}
+	if err := c.Discovery.Valid(); err != nil {
+		return fmt.Errorf("invalid discovery: %w", err)
+	}
+	if err := c.Features.Valid(); err != nil {
+		return fmt.Errorf("invalid features: %w", err)
+	}
+	if c.Admin != nil {
+		if err := c.Admin.Valid(); err != nil {
+			return fmt.Errorf("invalid admin: %w", err)
+		}
+	}
+	if c.FaultInjection != nil {
+		if err := c.FaultInjection.Valid(); err != nil {
+			return fmt.Errorf("invalid faultInjection: %w", err)
+		}
+	}
+	if n := c.Notifications.GitLabMergeRequest; n != nil {
+		if n.Token == "" {
+			return fmt.Errorf("invalid notifications.gitLabMergeRequest.token: empty")
+		}
+		if n.URL == "" && c.gitLabURL() == "" {
+			return fmt.Errorf("invalid notifications.gitLabMergeRequest.url: empty")
+		}
+	}
+	for repoName, repoConfig := range c.Repositories {
+		if err := repoConfig.Valid(); err != nil {
+			return fmt.Errorf("invalid repositories.%s: %w", repoName, err)
+		}
+		if isRepositoryTemplate(repoName) {
+			if err := validRepositoryTemplate(repoName, repoConfig); err != nil {
+				return fmt.Errorf("invalid repositories.%s: %w", repoName, err)
+			}
+		} else if strings.Contains(repoConfig.URL, repositoryPathPlaceholder) {
+			return fmt.Errorf("invalid repositories.%s: url contains %s, but the name is not a pattern", repoName, repositoryPathPlaceholder)
+		}
+	}
 
 	return nil
 }
@@ -52,6 +552,145 @@ type RepositoriesConfig map[string]RepositoryConfig
 type RepositoryConfig struct {
 	URL       string           `yaml:"url"`
 	BasicAuth *BasicAuthConfig `yaml:"basicAuth"`
+	// Provider of the repository for provider specific authentication and API features (optional).
+	Provider RepositoryProvider `yaml:"provider"`
+	// Token to access the repository and the provider API (requires a provider).
+	Token string `yaml:"token"`
+	// APIURL of the provider, defaults to the API of the host of the repository URL (optional).
+	APIURL string `yaml:"apiUrl"`
+	// SigningKey overrides commit.signingKey for this repository (optional).
+	SigningKey *SigningKeyConfig `yaml:"signingKey"`
+	// PushOptions are sent with every push to the repository (e.g. "ci.skip" for GitLab), optional.
+	PushOptions []string `yaml:"pushOptions"`
+	// SOPS configures keys to set fields in SOPS encrypted files (optional).
+	SOPS *SOPSConfig `yaml:"sops"`
+	// Changelog enables writing a changelog fragment for every patch (optional).
+	Changelog *ChangelogConfig `yaml:"changelog"`
+	// Exchange trades the token of the caller for a short-lived credential instead of using static credentials (optional).
+	Exchange *ExchangeConfig `yaml:"exchange"`
+	// Commit overrides defaults of commit for this repository (optional).
+	Commit *RepositoryCommitConfig `yaml:"commit"`
+	// AllowedPaths are glob patterns of paths that commands may target or read (e.g. "apps/**"), checked before
+	// cloning in addition to the authorizer. All paths are allowed if empty.
+	AllowedPaths []string `yaml:"allowedPaths"`
+}
+
+// RepositoryCommitConfig overrides commit defaults for a repository, values that are not set are used from the
+// commit configuration.
+type RepositoryCommitConfig struct {
+	DefaultMessage  string           `yaml:"defaultMessage"`
+	DefaultAuthor   *SignatureConfig `yaml:"defaultAuthor"`
+	MessageTemplate string           `yaml:"messageTemplate"`
+}
+
+func (c RepositoryCommitConfig) Valid() error {
+	if c.DefaultAuthor != nil {
+		if err := c.DefaultAuthor.Valid(); err != nil {
+			return fmt.Errorf("invalid defaultAuthor: %w", err)
+		}
+	}
+	if _, err := parseCommitMessageTemplate(c.MessageTemplate); err != nil {
+		return fmt.Errorf("invalid messageTemplate: %w", err)
+	}
+	return nil
+}
+
+type RepositoryProvider string
+
+const (
+	// RepositoryProviderGitea is used for Gitea and Forgejo repositories.
+	RepositoryProviderGitea RepositoryProvider = "gitea"
+	// RepositoryProviderGitLab is used for GitLab repositories.
+	RepositoryProviderGitLab RepositoryProvider = "gitlab"
+	// RepositoryProviderGitHub is used for GitHub and GitHub Enterprise Server repositories.
+	RepositoryProviderGitHub RepositoryProvider = "github"
+)
+
+func (c RepositoryConfig) Valid() error {
+	if c.URL == "" {
+		return fmt.Errorf("url required")
+	}
+	switch c.Provider {
+	case "":
+		if c.Token != "" {
+			return fmt.Errorf("token requires a provider")
+		}
+	case RepositoryProviderGitea, RepositoryProviderGitLab, RepositoryProviderGitHub:
+	default:
+		return fmt.Errorf("unsupported provider: %q", c.Provider)
+	}
+	if c.Token != "" && c.BasicAuth != nil {
+		return fmt.Errorf("only one of token or basicAuth can be set")
+	}
+	if c.Exchange != nil {
+		if c.Token != "" || c.BasicAuth != nil {
+			return fmt.Errorf("exchange cannot be combined with token or basicAuth")
+		}
+		if err := c.Exchange.Valid(); err != nil {
+			return fmt.Errorf("invalid exchange: %w", err)
+		}
+	}
+	if c.SigningKey != nil {
+		if err := c.SigningKey.Valid(); err != nil {
+			return fmt.Errorf("invalid signingKey: %w", err)
+		}
+	}
+	for _, opt := range c.PushOptions {
+		if err := validatePushOption(opt); err != nil {
+			return fmt.Errorf("invalid push option %q: %w", opt, err)
+		}
+	}
+	if err := validAllowedPaths(c.AllowedPaths); err != nil {
+		return err
+	}
+	if c.SOPS != nil {
+		if err := c.SOPS.Valid(); err != nil {
+			return fmt.Errorf("invalid sops: %w", err)
+		}
+	}
+	if c.Changelog != nil {
+		if err := c.Changelog.Valid(); err != nil {
+			return fmt.Errorf("invalid changelog: %w", err)
+		}
+	}
+	if c.Commit != nil {
+		if err := c.Commit.Valid(); err != nil {
+			return fmt.Errorf("invalid commit: %w", err)
+		}
+	}
+	return nil
+}
+
+// authMethod returns the auth method for Git operations on the repository.
+func (c RepositoryConfig) authMethod() transport.AuthMethod {
+	if c.BasicAuth != nil {
+		return &gitHttp.BasicAuth{
+			Username: c.BasicAuth.Username,
+			Password: c.BasicAuth.Password,
+		}
+	}
+	if c.Token != "" {
+		switch c.Provider {
+		case RepositoryProviderGitea:
+			return &tokenHeaderAuth{scheme: "token", token: c.Token}
+		case RepositoryProviderGitLab:
+			// GitLab accepts access tokens as password with any username
+			return &gitHttp.BasicAuth{Username: "oauth2", Password: c.Token}
+		case RepositoryProviderGitHub:
+			return &gitHttp.BasicAuth{Username: "x-access-token", Password: c.Token}
+		}
+	}
+	return nil
+}
+
+// withCredential returns a copy of the configuration that uses the credential for Git operations and the provider API.
+func (c RepositoryConfig) withCredential(cred Credential) RepositoryConfig {
+	c.BasicAuth = &BasicAuthConfig{
+		Username: cred.Username,
+		Password: cred.Password,
+	}
+	c.Token = cred.Password
+	return c
 }
 
 type BasicAuthConfig struct {
@@ -77,32 +716,487 @@ func (c SignatureConfig) Valid() error {
 type CommitConfig struct {
 	DefaultMessage string          `yaml:"defaultMessage"`
 	DefaultAuthor  SignatureConfig `yaml:"defaultAuthor"`
+	// MessageTemplate renders the commit message in text/template syntax (optional), the message of the request or
+	// the default message is available as {{ .Message }}.
+	MessageTemplate string `yaml:"messageTemplate"`
+	// SigningKey configures a GPG or SSH key to sign commits with (optional).
+	SigningKey *SigningKeyConfig `yaml:"signingKey"`
+	// AuthorSources is the order of sources to resolve the commit author (optional).
+	// The request is rejected if no source provides a signature.
+	AuthorSources []SignatureSource `yaml:"authorSources"`
+	// CommitterSources is the order of sources to resolve the commit committer (optional).
+	// The request is rejected if no source provides a signature.
+	CommitterSources []SignatureSource `yaml:"committerSources"`
+}
+
+// SignatureSource is a source for the author or committer signature of a commit.
+type SignatureSource string
+
+const (
+	// SignatureSourceRequest uses the author or committer given in the request.
+	SignatureSourceRequest SignatureSource = "request"
+	// SignatureSourceClaims uses the user of the authentication claims (if user login and email are set).
+	SignatureSourceClaims SignatureSource = "claims"
+	// SignatureSourceDefault uses the configured default author.
+	SignatureSourceDefault SignatureSource = "default"
+	// SignatureSourceAuthor uses the resolved author (only valid for the committer).
+	SignatureSourceAuthor SignatureSource = "author"
+)
+
+var (
+	defaultAuthorSources    = []SignatureSource{SignatureSourceRequest, SignatureSourceDefault}
+	defaultCommitterSources = []SignatureSource{SignatureSourceRequest, SignatureSourceClaims, SignatureSourceAuthor}
+)
+
+// forRepository returns the commit configuration with the overrides of the repository.
+func (c CommitConfig) forRepository(repoConfig RepositoryConfig) CommitConfig {
+	if repoConfig.Commit == nil {
+		return c
+	}
+	if repoConfig.Commit.DefaultMessage != "" {
+		c.DefaultMessage = repoConfig.Commit.DefaultMessage
+	}
+	if repoConfig.Commit.DefaultAuthor != nil {
+		c.DefaultAuthor = *repoConfig.Commit.DefaultAuthor
+	}
+	if repoConfig.Commit.MessageTemplate != "" {
+		c.MessageTemplate = repoConfig.Commit.MessageTemplate
+	}
+	return c
+}
+
+func (c CommitConfig) authorSources() []SignatureSource {
+	if len(c.AuthorSources) == 0 {
+		return defaultAuthorSources
+	}
+	return c.AuthorSources
+}
+
+func (c CommitConfig) committerSources() []SignatureSource {
+	if len(c.CommitterSources) == 0 {
+		return defaultCommitterSources
+	}
+	return c.CommitterSources
+}
+
+func (c CommitConfig) validateSources() error {
+	for _, source := range c.AuthorSources {
+		switch source {
+		case SignatureSourceRequest, SignatureSourceClaims, SignatureSourceDefault:
+		default:
+			return fmt.Errorf("invalid authorSources: unsupported source %q", source)
+		}
+	}
+	for _, source := range c.CommitterSources {
+		switch source {
+		case SignatureSourceRequest, SignatureSourceClaims, SignatureSourceDefault, SignatureSourceAuthor:
+		default:
+			return fmt.Errorf("invalid committerSources: unsupported source %q", source)
+		}
+	}
+	return nil
+}
+
+// SigningKeyConfig configures a private key for signing commits, either read from a file or given inline.
+type SigningKeyConfig struct {
+	// Format of the key, defaults to SigningKeyFormatGPG.
+	Format SigningKeyFormat `yaml:"format"`
+	// File is the path to the private key (armored for GPG, OpenSSH / PEM format for SSH).
+	File string `yaml:"file"`
+	// Key is the inline private key.
+	Key string `yaml:"key"`
+	// Passphrase to decrypt the private key (optional).
+	Passphrase string `yaml:"passphrase"`
+}
+
+type SigningKeyFormat string
+
+const (
+	SigningKeyFormatGPG SigningKeyFormat = "gpg"
+	SigningKeyFormatSSH SigningKeyFormat = "ssh"
+)
+
+func (c SigningKeyConfig) Valid() error {
+	if c.File == "" && c.Key == "" {
+		return fmt.Errorf("file or key required")
+	}
+	if c.File != "" && c.Key != "" {
+		return fmt.Errorf("only one of file or key can be set")
+	}
+	switch c.Format {
+	case "", SigningKeyFormatGPG:
+		if _, err := c.Entity(); err != nil {
+			return err
+		}
+	case SigningKeyFormatSSH:
+		if _, err := c.SSHSigner(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid format: %q", c.Format)
+	}
+	return nil
+}
+
+func (c SigningKeyConfig) readKey() ([]byte, error) {
+	if c.File != "" {
+		b, err := os.ReadFile(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		return b, nil
+	}
+	return []byte(c.Key), nil
+}
+
+// SSHSigner reads the configured SSH key and returns a signer for commits in the SSHSIG format.
+func (c SigningKeyConfig) SSHSigner() (git.Signer, error) {
+	b, err := c.readKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var signer ssh.Signer
+	if c.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b, []byte(c.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key: %w", err)
+	}
+
+	return &sshSigner{signer: signer}, nil
+}
+
+// Entity reads the configured GPG key and returns the decrypted OpenPGP entity for signing.
+func (c SigningKeyConfig) Entity() (*openpgp.Entity, error) {
+	b, err := c.readKey()
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("reading armored key: %w", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("expected exactly one key, got %d", len(entities))
+	}
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, fmt.Errorf("key is not a private key")
+	}
+
+	if entity.PrivateKey.Encrypted {
+		if c.Passphrase == "" {
+			return nil, fmt.Errorf("key is encrypted, but no passphrase given")
+		}
+		if err := entity.DecryptPrivateKeys([]byte(c.Passphrase)); err != nil {
+			return nil, fmt.Errorf("decrypting key: %w", err)
+		}
+	}
+
+	return entity, nil
+}
+
+// commitSigner is a signing key of the configuration that was read and decrypted once.
+type commitSigner struct {
+	entity *openpgp.Entity
+	signer git.Signer
+	// err is set if the key could not be loaded, so commits fail instead of being pushed unsigned.
+	err error
+}
+
+// loadSigner reads and decrypts the configured key.
+func (c SigningKeyConfig) loadSigner() *commitSigner {
+	if c.Format == SigningKeyFormatSSH {
+		signer, err := c.SSHSigner()
+		if err != nil {
+			return &commitSigner{err: fmt.Errorf("loading SSH signing key: %w", err)}
+		}
+		return &commitSigner{signer: signer}
+	}
+	entity, err := c.Entity()
+	if err != nil {
+		return &commitSigner{err: fmt.Errorf("loading signing key: %w", err)}
+	}
+	return &commitSigner{entity: entity}
+}
+
+// apply sets the key to sign commits with in the commit options.
+func (s *commitSigner) apply(commitOptions *git.CommitOptions) error {
+	if s.err != nil {
+		return s.err
+	}
+	commitOptions.SignKey = s.entity
+	commitOptions.Signer = s.signer
+	return nil
 }
 
 type AuthenticationProviderType string
 
 const (
-	AuthenticationProviderGitLab AuthenticationProviderType = "gitlab"
+	AuthenticationProviderGitLab   AuthenticationProviderType = "gitlab"
+	AuthenticationProviderMTLS     AuthenticationProviderType = "mtls"
+	AuthenticationProviderHtpasswd AuthenticationProviderType = "htpasswd"
 )
 
 func (p AuthenticationProviderType) IsValid() bool {
 	switch p {
-	case AuthenticationProviderGitLab:
+	case AuthenticationProviderGitLab, AuthenticationProviderMTLS, AuthenticationProviderHtpasswd:
 		return true
 	default:
 		return false
 	}
 }
 
-func (c Config) BuildAuthenticationProvider(ctx context.Context) (AuthenticationProvider, error) {
-	switch c.AuthenticationProvider.Type {
+type AuthenticationProviderConfig struct {
+	// Name of the provider that is set in AuthCtx.Provider, defaults to the type.
+	Name string                     `yaml:"name"`
+	Type AuthenticationProviderType `yaml:"type"`
+	// GitLab must be set for type `gitlab`
+	GitLab *GitLabAuthenticationProviderConfig `yaml:"gitlab"`
+	// Htpasswd must be set for type `htpasswd`
+	Htpasswd *HtpasswdAuthenticationProviderConfig `yaml:"htpasswd"`
+}
+
+type HtpasswdAuthenticationProviderConfig struct {
+	// File is the path to an htpasswd file with bcrypt hashes, it is read on startup.
+	File string `yaml:"file"`
+}
+
+type GitLabAuthenticationProviderConfig struct {
+	// URL of the GitLab instance, keys are fetched from `/-/jwks` unless JWKS or JWKSFile is set.
+	URL string `yaml:"url"`
+	// JWKS is the inline JSON of the keys to verify tokens (optional), e.g. for air-gapped environments.
+	JWKS string `yaml:"jwks"`
+	// JWKSFile is the path to a JSON file with the keys to verify tokens (optional).
+	JWKSFile string `yaml:"jwksFile"`
+	// Audience that must be contained in the `aud` claim of tokens (optional), e.g. the `aud` of GitLab ID tokens.
+	Audience string `yaml:"audience"`
+	// Issuer that must match the `iss` claim of tokens (optional), e.g. the URL of the GitLab instance.
+	Issuer string `yaml:"issuer"`
+	// ClockSkew is the tolerated difference of clocks for validating `exp`, `nbf` and `iat` (optional), defaults to 0.
+	ClockSkew time.Duration `yaml:"clockSkew"`
+	// MaxTokenAge is the maximum time since a token was issued (`iat`) to accept it (optional).
+	MaxTokenAge time.Duration `yaml:"maxTokenAge"`
+	// RequiredClaims are names of claims that must be set in tokens (optional), e.g. "ref_protected".
+	RequiredClaims []string `yaml:"requiredClaims"`
+	// ReplayProtection rejects tokens that were already used, identified by their `jti` claim (optional).
+	ReplayProtection *ReplayProtectionConfig `yaml:"replayProtection"`
+}
+
+type ReplayProtectionConfig struct {
+	// Backend to remember used tokens, defaults to ReplayProtectionBackendMemory.
+	Backend ReplayProtectionBackend `yaml:"backend"`
+	// Redis must be set for backend `redis`.
+	Redis *RedisReplayProtectionConfig `yaml:"redis"`
+}
+
+type ReplayProtectionBackend string
+
+const (
+	// ReplayProtectionBackendMemory remembers tokens within a single instance.
+	ReplayProtectionBackendMemory ReplayProtectionBackend = "memory"
+	// ReplayProtectionBackendRedis remembers tokens across multiple instances using Redis.
+	ReplayProtectionBackendRedis ReplayProtectionBackend = "redis"
+)
+
+type RedisReplayProtectionConfig struct {
+	RedisConfig `yaml:",inline"`
+	// KeyPrefix for token keys, defaults to "vignet:jti:".
+	KeyPrefix string `yaml:"keyPrefix"`
+}
+
+func (c ReplayProtectionConfig) Valid() error {
+	switch c.Backend {
+	case "", ReplayProtectionBackendMemory:
+	case ReplayProtectionBackendRedis:
+		if c.Redis == nil || c.Redis.Address == "" {
+			return fmt.Errorf("redis.address required for backend %q", c.Backend)
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %q", c.Backend)
+	}
+	return nil
+}
+
+func (c ReplayProtectionConfig) build() TokenReplayStore {
+	if c.Backend == ReplayProtectionBackendRedis {
+		keyPrefix := c.Redis.KeyPrefix
+		if keyPrefix == "" {
+			keyPrefix = "vignet:jti:"
+		}
+		return NewRedisTokenReplayStore(c.Redis.client(), keyPrefix)
+	}
+	return NewMemoryTokenReplayStore()
+}
+
+func (c AuthenticationProviderConfig) Valid() error {
+	if !c.Type.IsValid() {
+		return fmt.Errorf("invalid type: %q", c.Type)
+	}
+	if c.Type == AuthenticationProviderGitLab {
+		if c.GitLab == nil || (c.GitLab.URL == "" && c.GitLab.JWKS == "" && c.GitLab.JWKSFile == "") {
+			return fmt.Errorf("gitlab.url must be set for type %q if no gitlab.jwks or gitlab.jwksFile is given", c.Type)
+		}
+		if c.GitLab.JWKS != "" && c.GitLab.JWKSFile != "" {
+			return fmt.Errorf("gitlab.jwks and gitlab.jwksFile cannot be combined")
+		}
+		if c.GitLab.ClockSkew < 0 {
+			return fmt.Errorf("gitlab.clockSkew must not be negative")
+		}
+		if c.GitLab.MaxTokenAge < 0 {
+			return fmt.Errorf("gitlab.maxTokenAge must not be negative")
+		}
+		if c.GitLab.ReplayProtection != nil {
+			if err := c.GitLab.ReplayProtection.Valid(); err != nil {
+				return fmt.Errorf("invalid gitlab.replayProtection: %w", err)
+			}
+		}
+	}
+	if c.Type == AuthenticationProviderHtpasswd && (c.Htpasswd == nil || c.Htpasswd.File == "") {
+		return fmt.Errorf("htpasswd.file must be set for type %q", c.Type)
+	}
+	return nil
+}
+
+func (c AuthenticationProviderConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return string(c.Type)
+}
+
+func (c AuthenticationProviderConfig) build(ctx context.Context, replayStores *TokenReplayStores) (AuthenticationProvider, error) {
+	switch c.Type {
 	case AuthenticationProviderGitLab:
-		p, err := NewGitLabAuthenticationProvider(ctx, c.AuthenticationProvider.GitLab.URL)
+		var opts []GitLabAuthenticationProviderOption
+		if c.GitLab.Audience != "" {
+			opts = append(opts, WithGitLabAudience(c.GitLab.Audience))
+		}
+		if c.GitLab.Issuer != "" {
+			opts = append(opts, WithGitLabIssuer(c.GitLab.Issuer))
+		}
+		if c.GitLab.ClockSkew > 0 {
+			opts = append(opts, WithGitLabClockSkew(c.GitLab.ClockSkew))
+		}
+		if c.GitLab.MaxTokenAge > 0 {
+			opts = append(opts, WithGitLabMaxTokenAge(c.GitLab.MaxTokenAge))
+		}
+		if len(c.GitLab.RequiredClaims) > 0 {
+			opts = append(opts, WithGitLabRequiredClaims(c.GitLab.RequiredClaims...))
+		}
+		if rp := c.GitLab.ReplayProtection; rp != nil {
+			opts = append(opts, WithGitLabReplayProtection(replayStores.store(c.name(), *rp)))
+		}
+		jwks := []byte(c.GitLab.JWKS)
+		if c.GitLab.JWKSFile != "" {
+			var err error
+			jwks, err = os.ReadFile(c.GitLab.JWKSFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading GitLab JWKS file: %w", err)
+			}
+		}
+		if len(jwks) > 0 {
+			p, err := NewGitLabAuthenticationProviderWithJWKS(jwks, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("initializing GitLab authentication provider: %w", err)
+			}
+			return p, nil
+		}
+		p, err := NewGitLabAuthenticationProvider(ctx, c.GitLab.URL, opts...)
 		if err != nil {
 			return nil, fmt.Errorf("initializing GitLab authentication provider: %w", err)
 		}
 		return p, nil
+	case AuthenticationProviderMTLS:
+		return NewMTLSAuthenticationProvider(), nil
+	case AuthenticationProviderHtpasswd:
+		f, err := os.Open(c.Htpasswd.File)
+		if err != nil {
+			return nil, fmt.Errorf("opening htpasswd file: %w", err)
+		}
+		defer f.Close()
+		p, err := NewHtpasswdAuthenticationProvider(f)
+		if err != nil {
+			return nil, fmt.Errorf("initializing htpasswd authentication provider: %w", err)
+		}
+		return p, nil
 	default:
+		return nil, fmt.Errorf("unsupported authentication provider: %q", c.Type)
+	}
+}
+
+// AuthenticationProviderConfigs returns the configured authentication providers in order, either the list of
+// AuthenticationProviders or the single AuthenticationProvider.
+func (c Config) AuthenticationProviderConfigs() []AuthenticationProviderConfig {
+	if len(c.AuthenticationProviders) > 0 {
+		return c.AuthenticationProviders
+	}
+	if c.AuthenticationProvider.Type == "" {
+		return nil
+	}
+	return []AuthenticationProviderConfig{c.AuthenticationProvider}
+}
+
+// gitLabURL returns the URL of the first configured GitLab authentication provider.
+func (c Config) gitLabURL() string {
+	for _, p := range c.AuthenticationProviderConfigs() {
+		if p.GitLab != nil && p.GitLab.URL != "" {
+			return p.GitLab.URL
+		}
+	}
+	return ""
+}
+
+// BuildAuthenticationProvider builds the configured authentication providers as a chain, so the name of the provider
+// that authenticated a request is set in AuthCtx.Provider.
+func (c Config) BuildAuthenticationProvider(ctx context.Context) (AuthenticationProvider, error) {
+	return c.BuildAuthenticationProviderWithReplayStores(ctx, nil)
+}
+
+// BuildAuthenticationProviderWithReplayStores builds the authentication providers like BuildAuthenticationProvider
+// and takes the token replay stores from replayStores, so used tokens are remembered across reloads.
+func (c Config) BuildAuthenticationProviderWithReplayStores(ctx context.Context, replayStores *TokenReplayStores) (AuthenticationProvider, error) {
+	configs := c.AuthenticationProviderConfigs()
+	if len(configs) == 0 {
 		return nil, fmt.Errorf("unsupported authentication provider: %q", c.AuthenticationProvider.Type)
 	}
+	providers := make([]NamedAuthenticationProvider, len(configs))
+	for i, pc := range configs {
+		p, err := pc.build(ctx, replayStores)
+		if err != nil {
+			return nil, err
+		}
+		providers[i] = NamedAuthenticationProvider{Name: pc.name(), AuthenticationProvider: p}
+	}
+	return NewChainAuthenticationProvider(providers...), nil
+}
+
+// BuildAuditSinks builds the built-in audit sinks that are enabled in the configuration.
+func (c Config) BuildAuditSinks() ([]AuditSink, error) {
+	var sinks []AuditSink
+	if c.Audit.File != "" {
+		s, err := NewFileAuditSink(c.Audit.File)
+		if err != nil {
+			return nil, fmt.Errorf("building file audit sink: %w", err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// BuildNotifiers builds the built-in notifiers that are enabled in the configuration.
+func (c Config) BuildNotifiers() []Notifier {
+	var notifiers []Notifier
+	if n := c.Notifications.GitLabMergeRequest; n != nil {
+		url := n.URL
+		if url == "" {
+			url = c.gitLabURL()
+		}
+		notifiers = append(notifiers, NewGitLabMergeRequestNotifier(url, n.Token, c.Timeouts.apiClient()))
+	}
+	return notifiers
 }
@@ -0,0 +1,212 @@
+package vignet
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix is the prefix of environment variables that set configuration values.
+//
+// The rest of the name is the path of the value in upper snake case, e.g. VIGNET_COMMIT_DEFAULT_MESSAGE for
+// commit.defaultMessage. Entries of mappings with object values (repositories, authorization.rules) are set by name,
+// e.g. VIGNET_REPOSITORIES_MY_PROJECT_URL (or the short form VIGNET_REPO_MY_PROJECT_URL) for the url of the
+// repository "my-project": names are lower cased and underscores are replaced by dashes.
+//
+// Values of strings are used as is, other values (numbers, booleans, durations, lists and mappings) are parsed as
+// YAML, e.g. VIGNET_REPO_MY_PROJECT_PUSH_OPTIONS='[ci.skip]'. So any value can be set, including a whole section
+// like VIGNET_AUTHENTICATION_PROVIDERS='[{type: gitlab, gitlab: {url: https://gitlab.example.com}}]'.
+const ConfigEnvPrefix = "VIGNET_"
+
+// configEnvAliases are short forms of paths for environment variables.
+var configEnvAliases = map[string]string{
+	"REPO": "REPOSITORIES",
+}
+
+// LoadConfigWithEnv loads the configuration like LoadConfig and merges the values of environment variables (see
+// ConfigEnvPrefix) as another file. Without files the configuration is only read from the environment.
+//
+// Variables with the prefix that do not start with a configuration value (e.g. VIGNET_ADDRESS of the command line
+// flags) are ignored.
+func LoadConfigWithEnv(environ []string, filenames ...string) (Config, error) {
+	envValues, err := configValuesFromEnv(environ)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(envValues) == 0 {
+		if len(filenames) == 0 {
+			return Config{}, fmt.Errorf("no configuration files given and no configuration set by %s environment variables", ConfigEnvPrefix)
+		}
+		return LoadConfig(filenames...)
+	}
+
+	sources := make([]configSource, 0, len(filenames)+1)
+	for _, filename := range filenames {
+		values, err := readConfigValues(filename)
+		if err != nil {
+			return Config{}, err
+		}
+		sources = append(sources, configSource{name: filename, values: values})
+	}
+	sources = append(sources, configSource{name: "the environment", values: envValues})
+	return mergeConfigSources(sources)
+}
+
+// configValuesFromEnv returns the configuration values set by environment variables as nested mappings.
+func configValuesFromEnv(environ []string) (map[string]any, error) {
+	environ = append([]string(nil), environ...)
+	sort.Strings(environ)
+
+	values := make(map[string]any)
+	origins := make(map[string]string)
+	for _, env := range environ {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, ConfigEnvPrefix) || value == "" {
+			continue
+		}
+
+		segments := strings.Split(strings.TrimPrefix(name, ConfigEnvPrefix), "_")
+		if alias, exists := configEnvAliases[segments[0]]; exists {
+			segments = append(strings.Split(alias, "_"), segments[1:]...)
+		}
+		if !matchesConfigField(reflect.TypeOf(Config{}), segments) {
+			continue
+		}
+		keys, typ, ok := resolveConfigEnvPath(reflect.TypeOf(Config{}), segments)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s does not match a configuration value", name)
+		}
+
+		var v any = value
+		if indirectType(typ).Kind() != reflect.String {
+			if err := goyaml.Unmarshal([]byte(value), &v); err != nil {
+				return nil, fmt.Errorf("parsing environment variable %s: %w", name, err)
+			}
+		}
+		if err := setConfigValue(values, keys, v, name, origins); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// matchesConfigField checks if the segments start with a field of the struct, so other variables are ignored.
+func matchesConfigField(typ reflect.Type, segments []string) bool {
+	for _, field := range configFields(typ) {
+		for i := 1; i <= len(segments); i++ {
+			if strings.Join(segments[:i], "") == field.envName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveConfigEnvPath resolves the segments of a variable name to the keys of a value with the type of the value.
+// Segments are matched against field names ignoring underscores (e.g. GIT_LAB and GITLAB both match gitLab), the
+// first complete match is used.
+func resolveConfigEnvPath(typ reflect.Type, segments []string) ([]string, reflect.Type, bool) {
+	if len(segments) == 0 {
+		return nil, typ, true
+	}
+
+	typ = indirectType(typ)
+	switch typ.Kind() {
+	case reflect.Struct:
+		for _, field := range configFields(typ) {
+			for i := 1; i <= len(segments); i++ {
+				if strings.Join(segments[:i], "") != field.envName {
+					continue
+				}
+				if keys, valueType, ok := resolveConfigEnvPath(field.typ, segments[i:]); ok {
+					return append([]string{field.key}, keys...), valueType, true
+				}
+			}
+		}
+	case reflect.Map:
+		// Entries are only set by name for object values, other mappings can be set as a whole
+		if typ.Key().Kind() != reflect.String || indirectType(typ.Elem()).Kind() != reflect.Struct {
+			return nil, nil, false
+		}
+		for i := 1; i < len(segments); i++ {
+			if keys, valueType, ok := resolveConfigEnvPath(typ.Elem(), segments[i:]); ok {
+				name := strings.ToLower(strings.Join(segments[:i], "-"))
+				return append([]string{name}, keys...), valueType, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+type configField struct {
+	key string
+	// envName is the key in upper case
+	envName string
+	typ     reflect.Type
+}
+
+// configFields returns the YAML fields of a struct, fields of inline structs are returned as fields of the struct.
+func configFields(typ reflect.Type) []configField {
+	var fields []configField
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if opts == "inline" {
+			fields = append(fields, configFields(indirectType(f.Type))...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields = append(fields, configField{
+			key:     name,
+			envName: strings.ToUpper(name),
+			typ:     f.Type,
+		})
+	}
+	return fields
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// setConfigValue sets a value by keys in nested mappings. A value cannot be set twice, also not by setting a parent
+// or child of it.
+func setConfigValue(values map[string]any, keys []string, value any, origin string, origins map[string]string) error {
+	path := strings.Join(keys, ".")
+	setPaths := make([]string, 0, len(origins))
+	for setPath := range origins {
+		setPaths = append(setPaths, setPath)
+	}
+	sort.Strings(setPaths)
+	for _, setPath := range setPaths {
+		if setPath == path || strings.HasPrefix(path, setPath+".") || strings.HasPrefix(setPath, path+".") {
+			return fmt.Errorf("%s is set by %s and %s", path, origins[setPath], origin)
+		}
+	}
+
+	for _, key := range keys[:len(keys)-1] {
+		child, exists := values[key].(map[string]any)
+		if !exists {
+			child = make(map[string]any)
+			values[key] = child
+		}
+		values = child
+	}
+	values[keys[len(keys)-1]] = value
+	origins[path] = origin
+	return nil
+}
@@ -0,0 +1,128 @@
+package vignet_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestLoadConfigWithEnv(t *testing.T) {
+	t.Run("environment only", func(t *testing.T) {
+		config, err := vignet.LoadConfigWithEnv([]string{
+			"PATH=/usr/bin",
+			"VIGNET_ADDRESS=:8080",
+			"VIGNET_AUTHENTICATION_PROVIDER_TYPE=gitlab",
+			"VIGNET_AUTHENTICATION_PROVIDER_GITLAB_URL=https://gitlab.example.com",
+			"VIGNET_REPO_MY_PROJECT_URL=https://gitlab.example.com/my-group/my-project.git",
+			"VIGNET_REPO_MY_PROJECT_PROVIDER=gitlab",
+			"VIGNET_REPO_MY_PROJECT_TOKEN=12345",
+			"VIGNET_REPO_MY_PROJECT_PUSH_OPTIONS=[ci.skip]",
+			"VIGNET_REPOSITORIES_INFRA_BASIC_AUTH_USERNAME=git",
+			"VIGNET_REPOSITORIES_INFRA_BASIC_AUTH_PASSWORD=true",
+			"VIGNET_REPOSITORIES_INFRA_URL=https://git.example.com/infra.git",
+			"VIGNET_AUTHORIZATION_RULES_MY_PROJECT_PATHS=[apps/**]",
+			"VIGNET_COMMIT_DEFAULT_MESSAGE=chore: automated update",
+			"VIGNET_TIMEOUTS_PUSH=2m",
+			"VIGNET_FEATURES={jsonPatch: false}",
+			"VIGNET_DISCOVERY_GIT_LAB_GROUPS=",
+		})
+		require.NoError(t, err)
+		require.NoError(t, config.Validate())
+
+		assert.Equal(t, vignet.AuthenticationProviderGitLab, config.AuthenticationProvider.Type)
+		assert.Equal(t, "https://gitlab.example.com", config.AuthenticationProvider.GitLab.URL)
+		assert.Equal(t, vignet.RepositoriesConfig{
+			"my-project": {
+				URL:         "https://gitlab.example.com/my-group/my-project.git",
+				Provider:    vignet.RepositoryProviderGitLab,
+				Token:       "12345",
+				PushOptions: []string{"ci.skip"},
+			},
+			"infra": {
+				URL:       "https://git.example.com/infra.git",
+				BasicAuth: &vignet.BasicAuthConfig{Username: "git", Password: "true"},
+			},
+		}, config.Repositories)
+		assert.Equal(t, []string{"apps/**"}, config.Authorization.Rules["my-project"].Paths)
+		assert.Equal(t, "chore: automated update", config.Commit.DefaultMessage)
+		assert.Equal(t, vignet.DefaultConfig.Commit.DefaultAuthor, config.Commit.DefaultAuthor)
+		assert.Equal(t, 2*time.Minute, config.Timeouts.Push)
+		assert.False(t, config.Features.Enabled(vignet.FeatureJSONPatch))
+	})
+
+	t.Run("merged with files", func(t *testing.T) {
+		dir := writeConfigFiles(t, map[string]string{
+			"config.yaml": `
+authenticationProvider:
+  type: gitlab
+  gitlab:
+    url: https://gitlab.example.com
+repositories:
+  infra:
+    url: https://git.example.com/infra.git
+`,
+		})
+
+		config, err := vignet.LoadConfigWithEnv([]string{
+			"VIGNET_REPO_APPS_URL=https://git.example.com/apps.git",
+		}, filepath.Join(dir, "config.yaml"))
+		require.NoError(t, err)
+		assert.Len(t, config.Repositories, 2)
+
+		_, err = vignet.LoadConfigWithEnv([]string{
+			"VIGNET_AUTHENTICATION_PROVIDER_GITLAB_URL=https://other.example.com",
+		}, filepath.Join(dir, "config.yaml"))
+		assert.EqualError(t, err, "authenticationProvider.gitlab.url is set in "+filepath.Join(dir, "config.yaml")+" and the environment")
+
+		_, err = vignet.LoadConfigWithEnv([]string{
+			"VIGNET_REPO_INFRA_TOKEN=a-token",
+		}, filepath.Join(dir, "config.yaml"))
+		assert.EqualError(t, err, "repositories.infra is defined in "+filepath.Join(dir, "config.yaml")+" and the environment")
+	})
+
+	errors := []struct {
+		name          string
+		environ       []string
+		expectedError string
+	}{
+		{
+			name:          "no configuration",
+			environ:       []string{"VIGNET_ADDRESS=:8080"},
+			expectedError: "no configuration files given and no configuration set by VIGNET_ environment variables",
+		},
+		{
+			name:          "unknown value",
+			environ:       []string{"VIGNET_COMMIT_DEFAULT_MESAGE=Update"},
+			expectedError: "environment variable VIGNET_COMMIT_DEFAULT_MESAGE does not match a configuration value",
+		},
+		{
+			name:          "repository without value",
+			environ:       []string{"VIGNET_REPO_INFRA=https://git.example.com/infra.git"},
+			expectedError: "environment variable VIGNET_REPO_INFRA does not match a configuration value",
+		},
+		{
+			name: "set twice",
+			environ: []string{
+				"VIGNET_COMMIT={defaultMessage: Update}",
+				"VIGNET_COMMIT_DEFAULT_MESSAGE=Update",
+			},
+			expectedError: "commit.defaultMessage is set by VIGNET_COMMIT and VIGNET_COMMIT_DEFAULT_MESSAGE",
+		},
+		{
+			name:          "invalid YAML",
+			environ:       []string{"VIGNET_TIMEOUTS_PUSH=[2m"},
+			expectedError: "parsing environment variable VIGNET_TIMEOUTS_PUSH: yaml: line 1: did not find expected ',' or ']'",
+		},
+	}
+	for _, tc := range errors {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := vignet.LoadConfigWithEnv(tc.environ)
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package vignet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/getsops/sops/v3/decrypt"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// exclusiveConfigKeys are mappings whose entries must be defined by a single file, so a file cannot change the
+// repositories or rules owned by another file.
+var exclusiveConfigKeys = []string{"repositories", "authorization.rules"}
+
+// LoadConfig decodes the configuration from YAML files on top of DefaultConfig, it is not validated.
+//
+// Multiple files are merged in order: mappings are merged recursively, any other value (scalars and sequences) may
+// only be set by one file and entries of repositories and authorization.rules must be defined by a single file.
+// This makes the result independent of the order of the files.
+//
+// SOPS encrypted files are decrypted with the keys of the environment like the sops CLI does (e.g. SOPS_AGE_KEY_FILE,
+// AWS or GCP credentials for KMS and the GnuPG keyring for PGP).
+func LoadConfig(filenames ...string) (Config, error) {
+	if len(filenames) == 1 {
+		config := DefaultConfig
+		// Decode a single file directly, so errors refer to its lines
+		b, err := readConfigFile(filenames[0])
+		if err != nil {
+			return Config{}, err
+		}
+
+		err = goyaml.NewDecoder(bytes.NewReader(b)).Decode(&config)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("decoding config file %s: %w", filenames[0], err)
+		}
+		return config, nil
+	}
+
+	sources := make([]configSource, 0, len(filenames))
+	for _, filename := range filenames {
+		values, err := readConfigValues(filename)
+		if err != nil {
+			return Config{}, err
+		}
+		sources = append(sources, configSource{name: filename, values: values})
+	}
+	return mergeConfigSources(sources)
+}
+
+// configSource are the configuration values of a file or the environment.
+type configSource struct {
+	name   string
+	values map[string]any
+}
+
+// mergeConfigSources merges the values of the sources in order and decodes them on top of DefaultConfig.
+func mergeConfigSources(sources []configSource) (Config, error) {
+	merged := make(map[string]any)
+	origins := make(map[string]string)
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		err := mergeConfigValues(merged, source.values, "", source.name, origins)
+		if err != nil {
+			return Config{}, err
+		}
+		names = append(names, source.name)
+	}
+
+	out, err := goyaml.Marshal(merged)
+	if err != nil {
+		return Config{}, fmt.Errorf("encoding merged config: %w", err)
+	}
+	config := DefaultConfig
+	err = goyaml.Unmarshal(out, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("decoding merged config of %s: %w", strings.Join(names, ", "), err)
+	}
+	return config, nil
+}
+
+// ConfigDirFilenames returns the YAML files (*.yaml, *.yml) of a directory in lexical order. Hidden files and
+// subdirectories are skipped, so the directory can be a mounted Kubernetes ConfigMap.
+func ConfigDirFilenames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading config directory: %w", err)
+	}
+
+	var filenames []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ext := filepath.Ext(name); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		// Follow symlinks of mounted ConfigMaps
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading config directory: %w", err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// readConfigFile reads a config file and decrypts it if it is SOPS encrypted.
+func readConfigFile(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening config file: %w", err)
+	}
+	if !isSOPSEncryptedConfig(b) {
+		return b, nil
+	}
+
+	b, err = decrypt.Data(b, "yaml")
+	if err != nil {
+		return nil, fmt.Errorf("decrypting SOPS encrypted config file %s: %w", filename, err)
+	}
+	return b, nil
+}
+
+// isSOPSEncryptedConfig checks for the metadata of SOPS, a config without it is read as plaintext.
+func isSOPSEncryptedConfig(b []byte) bool {
+	var document struct {
+		SOPS *struct {
+			MAC string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	// Errors are reported by decoding the config
+	_ = goyaml.Unmarshal(b, &document)
+	return document.SOPS != nil && document.SOPS.MAC != ""
+}
+
+func readConfigValues(filename string) (map[string]any, error) {
+	b, err := readConfigFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	err = goyaml.NewDecoder(bytes.NewReader(b)).Decode(&values)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decoding config file %s: %w", filename, err)
+	}
+	return values, nil
+}
+
+// mergeConfigValues merges the values of a source into dst. The origins of merged values are recorded by path to report
+// conflicts with both files.
+func mergeConfigValues(dst, src map[string]any, path, filename string, origins map[string]string) error {
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := src[key]
+		valuePath := key
+		if path != "" {
+			valuePath = path + "." + key
+		}
+
+		if value == nil {
+			continue
+		}
+		existing, exists := dst[key]
+		if !exists || existing == nil {
+			dst[key] = value
+			origins[valuePath] = filename
+			continue
+		}
+
+		existingMap, existingIsMap := existing.(map[string]any)
+		valueMap, valueIsMap := value.(map[string]any)
+		if !existingIsMap || !valueIsMap {
+			return fmt.Errorf("%s is set in %s and %s", valuePath, configValueOrigin(origins, valuePath), filename)
+		}
+		if isExclusiveConfigKey(path) {
+			return fmt.Errorf("%s is defined in %s and %s", valuePath, configValueOrigin(origins, valuePath), filename)
+		}
+		if err := mergeConfigValues(existingMap, valueMap, valuePath, filename, origins); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configValueOrigin returns the file that set the value at the path or the nearest parent.
+func configValueOrigin(origins map[string]string, path string) string {
+	for {
+		if origin, exists := origins[path]; exists {
+			return origin
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return "an earlier file"
+		}
+		path = path[:i]
+	}
+}
+
+func isExclusiveConfigKey(path string) bool {
+	for _, key := range exclusiveConfigKeys {
+		if path == key {
+			return true
+		}
+	}
+	return false
+}
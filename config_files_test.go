@@ -0,0 +1,167 @@
+package vignet_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("merges files of directory", func(t *testing.T) {
+		dir := writeConfigFiles(t, map[string]string{
+			"config.yaml": `
+authenticationProvider:
+  type: gitlab
+  gitlab:
+    url: https://gitlab.example.com
+commit:
+  defaultMessage: Update by vignet
+`,
+			"conf.d/10-team-a.yaml": `
+repositories:
+  team-a:
+    url: https://gitlab.example.com/team-a/deployment.git
+authorization:
+  rules:
+    team-a:
+      paths: ["apps/**"]
+`,
+			"conf.d/20-team-b.yml": `
+repositories:
+  team-b:
+    url: https://gitlab.example.com/team-b/deployment.git
+commit:
+  defaultAuthor:
+    name: Team B
+    email: team-b@example.com
+`,
+			"conf.d/empty.yaml":   ``,
+			"conf.d/README.md":    `Not a config file`,
+			"conf.d/.hidden.yaml": `commit: {defaultMessage: Hidden}`,
+			"conf.d/sub/a.yaml":   `commit: {defaultMessage: Sub}`,
+		})
+
+		filenames, err := vignet.ConfigDirFilenames(filepath.Join(dir, "conf.d"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "conf.d", "10-team-a.yaml"),
+			filepath.Join(dir, "conf.d", "20-team-b.yml"),
+			filepath.Join(dir, "conf.d", "empty.yaml"),
+		}, filenames)
+
+		config, err := vignet.LoadConfig(append([]string{filepath.Join(dir, "config.yaml")}, filenames...)...)
+		require.NoError(t, err)
+		require.NoError(t, config.Validate())
+
+		assert.Equal(t, "https://gitlab.example.com", config.AuthenticationProvider.GitLab.URL)
+		assert.Equal(t, vignet.RepositoriesConfig{
+			"team-a": {URL: "https://gitlab.example.com/team-a/deployment.git"},
+			"team-b": {URL: "https://gitlab.example.com/team-b/deployment.git"},
+		}, config.Repositories)
+		assert.Equal(t, []string{"apps/**"}, config.Authorization.Rules["team-a"].Paths)
+		assert.Equal(t, "Update by vignet", config.Commit.DefaultMessage)
+		assert.Equal(t, vignet.SignatureConfig{Name: "Team B", Email: "team-b@example.com"}, config.Commit.DefaultAuthor)
+	})
+
+	t.Run("single file keeps defaults", func(t *testing.T) {
+		dir := writeConfigFiles(t, map[string]string{
+			"config.yaml": `commit: {defaultMessage: Update}`,
+		})
+
+		config, err := vignet.LoadConfig(filepath.Join(dir, "config.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, "Update", config.Commit.DefaultMessage)
+		assert.Equal(t, vignet.DefaultConfig.Commit.DefaultAuthor, config.Commit.DefaultAuthor)
+	})
+
+	t.Run("decrypts SOPS encrypted file", func(t *testing.T) {
+		// The fixture is encrypted with the age key of the sops package tests
+		t.Setenv("SOPS_AGE_KEY_FILE", "sops/testdata/age-key.txt")
+
+		config, err := vignet.LoadConfig("testdata/config.enc.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "https://gitlab.example.com", config.AuthenticationProvider.GitLab.URL)
+		assert.Equal(t, &vignet.BasicAuthConfig{Username: "git", Password: "a-secret-password"}, config.Repositories["my-project"].BasicAuth)
+
+		// Encrypted files are merged like plaintext files
+		dir := writeConfigFiles(t, map[string]string{
+			"commit.yaml": `commit: {defaultMessage: Update}`,
+		})
+		config, err = vignet.LoadConfig("testdata/config.enc.yaml", filepath.Join(dir, "commit.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, "Update", config.Commit.DefaultMessage)
+		assert.Equal(t, "a-secret-password", config.Repositories["my-project"].BasicAuth.Password)
+	})
+
+	t.Run("SOPS encrypted file without key", func(t *testing.T) {
+		t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+		t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+		_, err := vignet.LoadConfig("testdata/config.enc.yaml")
+		require.ErrorContains(t, err, "decrypting SOPS encrypted config file testdata/config.enc.yaml")
+	})
+
+	conflicts := []struct {
+		name          string
+		a, b          string
+		expectedError string
+	}{
+		{
+			name:          "scalar set twice",
+			a:             `commit: {defaultMessage: A}`,
+			b:             `commit: {defaultMessage: B}`,
+			expectedError: "commit.defaultMessage is set in {dir}/a.yaml and {dir}/b.yaml",
+		},
+		{
+			name:          "sequence set twice",
+			a:             `http: {policyHeaders: [X-A]}`,
+			b:             `http: {policyHeaders: [X-B]}`,
+			expectedError: "http.policyHeaders is set in {dir}/a.yaml and {dir}/b.yaml",
+		},
+		{
+			name:          "mapping and scalar",
+			a:             `commit: {defaultAuthor: {name: A}}`,
+			b:             `commit: {defaultAuthor: B}`,
+			expectedError: "commit.defaultAuthor is set in {dir}/a.yaml and {dir}/b.yaml",
+		},
+		{
+			name:          "repository defined twice",
+			a:             `repositories: {infra: {url: https://git.example.com/infra.git}}`,
+			b:             `repositories: {infra: {pushOptions: [ci.skip]}}`,
+			expectedError: "repositories.infra is defined in {dir}/a.yaml and {dir}/b.yaml",
+		},
+		{
+			name:          "rules defined twice",
+			a:             `authorization: {rules: {infra: {paths: [a]}}}`,
+			b:             `authorization: {rules: {infra: {commands: [setField]}}}`,
+			expectedError: "authorization.rules.infra is defined in {dir}/a.yaml and {dir}/b.yaml",
+		},
+	}
+	for _, tc := range conflicts {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, map[string]string{"a.yaml": tc.a, "b.yaml": tc.b})
+
+			_, err := vignet.LoadConfig(filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml"))
+			require.Error(t, err)
+			assert.Equal(t, strings.ReplaceAll(tc.expectedError, "{dir}/", dir+string(filepath.Separator)), err.Error())
+		})
+	}
+}
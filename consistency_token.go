@@ -0,0 +1,88 @@
+package vignet
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ConsistencyTokenHeader is set in responses of patch requests with a token that identifies the pushed state
+// of the repository. Clients should treat the token as opaque. A read request sending the token in this header
+// reads the branch of the token and only responds with a state that contains the pushed commit.
+const ConsistencyTokenHeader = "X-Vignet-Consistency-Token"
+
+// ConsistencyTokensConfig configures the consistency tokens of patch responses.
+type ConsistencyTokensConfig struct {
+	// Key signs tokens with HMAC-SHA256, so clients cannot create tokens for other branches. Instances that accept the
+	// tokens of each other (e.g. replicas) need the same key. A random key is generated on startup if not set, tokens
+	// are then rejected after a restart.
+	Key string `yaml:"key"`
+}
+
+// generatedConsistencyTokenKey signs tokens if no key is configured, it is kept across reloads of the configuration.
+var generatedConsistencyTokenKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generating consistency token key: %v", err))
+	}
+	return key
+}()
+
+func (c ConsistencyTokensConfig) key() []byte {
+	if c.Key == "" {
+		return generatedConsistencyTokenKey
+	}
+	return []byte(c.Key)
+}
+
+// consistencyToken identifies a commit pushed to a branch of a repository.
+type consistencyToken struct {
+	Repo   string `json:"r"`
+	Branch string `json:"b"`
+	Commit string `json:"c"`
+}
+
+// sign encodes the token with its signature.
+func (t consistencyToken) sign(key []byte) string {
+	b, _ := json.Marshal(t)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(consistencyTokenMAC(key, payload))
+}
+
+func consistencyTokenMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// parseConsistencyToken parses a token returned by sign, the signature must match the key.
+func parseConsistencyToken(s string, key []byte) (consistencyToken, error) {
+	var t consistencyToken
+	payload, signature, found := strings.Cut(s, ".")
+	if !found {
+		return t, errors.New("token is not signed")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return t, fmt.Errorf("decoding signature: %w", err)
+	}
+	if !hmac.Equal(mac, consistencyTokenMAC(key, payload)) {
+		return t, errors.New("invalid signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return t, fmt.Errorf("decoding token: %w", err)
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("decoding token: %w", err)
+	}
+	if t.Repo == "" || t.Branch == "" || !commitHashPattern.MatchString(t.Commit) {
+		return t, errors.New("token is incomplete")
+	}
+	return t, nil
+}
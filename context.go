@@ -6,6 +6,8 @@ type ctxKey int
 
 const (
 	authCtxKey ctxKey = iota
+	requestedFaultsKey
+	apiVersionKey
 )
 
 func ctxWithAuthCtx(ctx context.Context, authCtx AuthCtx) context.Context {
@@ -15,3 +17,12 @@ func ctxWithAuthCtx(ctx context.Context, authCtx AuthCtx) context.Context {
 func authCtxFromCtx(ctx context.Context) AuthCtx {
 	return ctx.Value(authCtxKey).(AuthCtx)
 }
+
+func ctxWithRequestedFaults(ctx context.Context, faults map[Fault]bool) context.Context {
+	return context.WithValue(ctx, requestedFaultsKey, faults)
+}
+
+func requestedFaultsFromCtx(ctx context.Context) map[Fault]bool {
+	faults, _ := ctx.Value(requestedFaultsKey).(map[Fault]bool)
+	return faults
+}
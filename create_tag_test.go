@@ -0,0 +1,124 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_CreateTag(t *testing.T) {
+	fs := memfs.New()
+	initGitRepo(t, fs, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	})
+	initialCommit := gitRepoHeadCommit(t, fs)
+
+	handler, cleanup := newCreateTagTestHandler(t, fs)
+	defer cleanup()
+
+	// A request with only tag commands does not create a commit
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [{"createTag": {"name": "my-group/my-project/v1.0.0", "target": "master"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), `"tag":"my-group/my-project/v1.0.0"`)
+
+	assertGitRepoHeadCommit(t, fs, "Initial commit")
+	require.Equal(t, initialCommit.Hash, gitRepoTagRef(t, fs, "my-group/my-project/v1.0.0"))
+
+	// Creating the same tag again fails
+	req = httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [{"createTag": {"name": "my-group/my-project/v1.0.0"}}]
+	}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "already exists")
+}
+
+func TestHandler_InvalidCreateTag(t *testing.T) {
+	handler, cleanup := newCreateTagTestHandler(t, memfs.New())
+	defer cleanup()
+
+	tests := []struct {
+		name          string
+		payload       string
+		expectedError string
+	}{
+		{
+			name:          "invalid name",
+			payload:       `{"commands": [{"createTag": {"name": "my-group/my-project/v1..0"}}]}`,
+			expectedError: "not a valid tag name",
+		},
+		{
+			name:          "annotated without message",
+			payload:       `{"commands": [{"createTag": {"name": "my-group/my-project/v1.0.0", "annotated": true}}]}`,
+			expectedError: "'message' must be set",
+		},
+		{
+			name:          "path set",
+			payload:       `{"commands": [{"path": "my-group/my-project/release.yml", "createTag": {"name": "my-group/my-project/v1.0.0"}}]}`,
+			expectedError: "'path' must not be set",
+		},
+		{
+			name:          "merge request without file commands",
+			payload:       `{"mergeRequest": {}, "commands": [{"createTag": {"name": "my-group/my-project/v1.0.0"}}]}`,
+			expectedError: "at least one command that changes a file",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(tc.payload))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tc.expectedError)
+		})
+	}
+}
+
+func newCreateTagTestHandler(t *testing.T, fs billy.Filesystem) (*vignet.Handler, func()) {
+	t.Helper()
+
+	gitSrv := httptest.NewServer(gitserver.New(fs, gitserver.Options{}))
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Bumped release",
+		},
+	})
+
+	return handler, gitSrv.Close
+}
+
+func gitRepoTagRef(t *testing.T, fs billy.Filesystem, name string) plumbing.Hash {
+	t.Helper()
+
+	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	defer storer.Close()
+
+	repo, err := git.Open(storer, nil)
+	require.NoError(t, err)
+
+	ref, err := repo.Tag(name)
+	require.NoError(t, err)
+
+	return ref.Hash()
+}
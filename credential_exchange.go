@@ -0,0 +1,424 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	netUrl "net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JobTokenHeader is the header to pass a GitLab CI job token for the `gitlabJobToken` exchange.
+const JobTokenHeader = "X-Vignet-Job-Token"
+
+// Credential is a short-lived credential for Git operations and the provider API of a repository.
+type Credential struct {
+	Username string
+	Password string
+	// ExpiresAt is the time the credential expires, it is zero if unknown.
+	ExpiresAt time.Time
+}
+
+// ExchangeRequest is the input for exchanging the token of a caller for a credential.
+type ExchangeRequest struct {
+	// Repo is the name of the repository.
+	Repo string
+	// RepoConfig is the configuration of the repository.
+	RepoConfig RepositoryConfig
+	// AuthCtx of the authenticated caller, the validated token is in IDToken.
+	AuthCtx AuthCtx
+	// Header of the HTTP request, e.g. to pass through a job token.
+	Header http.Header
+}
+
+// CredentialExchanger trades the token of an authenticated caller for a credential to access a repository.
+type CredentialExchanger interface {
+	ExchangeCredential(ctx context.Context, req ExchangeRequest) (*Credential, error)
+}
+
+// ExchangeConfig configures how the token of the caller is exchanged for a credential.
+type ExchangeConfig struct {
+	Type ExchangeType `yaml:"type"`
+	// GitHubApp must be set for type `githubApp`.
+	GitHubApp *GitHubAppExchangeConfig `yaml:"githubApp"`
+	// STS must be set for type `sts`.
+	STS *STSExchangeConfig `yaml:"sts"`
+	// Timeout of a request to exchange a token (types `githubApp` and `sts`), defaults to 10 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+const defaultExchangeTimeout = 10 * time.Second
+
+type ExchangeType string
+
+const (
+	// ExchangeTypeGitLabJobToken passes through the GitLab CI job token given in the X-Vignet-Job-Token header.
+	ExchangeTypeGitLabJobToken ExchangeType = "gitlabJobToken"
+	// ExchangeTypeGitHubApp creates an installation access token of a GitHub App for the repository.
+	ExchangeTypeGitHubApp ExchangeType = "githubApp"
+	// ExchangeTypeSTS exchanges the token of the caller at an OAuth 2.0 token exchange (RFC 8693) endpoint.
+	ExchangeTypeSTS ExchangeType = "sts"
+)
+
+func (c ExchangeConfig) Valid() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	switch c.Type {
+	case ExchangeTypeGitLabJobToken:
+	case ExchangeTypeGitHubApp:
+		if c.GitHubApp == nil {
+			return fmt.Errorf("githubApp required for type %q", c.Type)
+		}
+		if err := c.GitHubApp.Valid(); err != nil {
+			return fmt.Errorf("invalid githubApp: %w", err)
+		}
+	case ExchangeTypeSTS:
+		if c.STS == nil {
+			return fmt.Errorf("sts required for type %q", c.Type)
+		}
+		if err := c.STS.Valid(); err != nil {
+			return fmt.Errorf("invalid sts: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported type: %q", c.Type)
+	}
+	return nil
+}
+
+// buildExchanger builds the exchanger for the configured type.
+func (c ExchangeConfig) buildExchanger() CredentialExchanger {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultExchangeTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch c.Type {
+	case ExchangeTypeGitLabJobToken:
+		return gitLabJobTokenExchanger{}
+	case ExchangeTypeGitHubApp:
+		// The private key is only read and parsed once, an error is returned by every exchange
+		key, keyErr := c.GitHubApp.privateKey()
+		return &gitHubAppExchanger{
+			config: *c.GitHubApp,
+			client: client,
+			key:    key,
+			keyErr: keyErr,
+			tokens: make(map[string]Credential),
+		}
+	case ExchangeTypeSTS:
+		return &stsExchanger{
+			config: *c.STS,
+			client: client,
+		}
+	default:
+		return nil
+	}
+}
+
+// --- GitLab job token
+
+// gitLabJobTokenExchanger uses the job token of the caller, access is limited to the job token permissions in GitLab.
+type gitLabJobTokenExchanger struct{}
+
+func (gitLabJobTokenExchanger) ExchangeCredential(_ context.Context, req ExchangeRequest) (*Credential, error) {
+	jobToken := req.Header.Get(JobTokenHeader)
+	if jobToken == "" {
+		return nil, clientError{fmt.Errorf("missing %s header", JobTokenHeader), http.StatusBadRequest}
+	}
+	return &Credential{
+		Username: "gitlab-ci-token",
+		Password: jobToken,
+	}, nil
+}
+
+// --- GitHub App
+
+// GitHubAppExchangeConfig configures a GitHub App to create installation access tokens.
+type GitHubAppExchangeConfig struct {
+	// AppID of the GitHub App (or the client ID).
+	AppID string `yaml:"appId"`
+	// InstallationID of the GitHub App for the owner of the repository.
+	InstallationID int64 `yaml:"installationId"`
+	// PrivateKeyFile is the path to the PEM encoded private key of the GitHub App.
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	// PrivateKey is the inline PEM encoded private key of the GitHub App.
+	PrivateKey string `yaml:"privateKey"`
+}
+
+func (c GitHubAppExchangeConfig) Valid() error {
+	if c.AppID == "" {
+		return fmt.Errorf("appId required")
+	}
+	if c.InstallationID == 0 {
+		return fmt.Errorf("installationId required")
+	}
+	if c.PrivateKeyFile == "" && c.PrivateKey == "" {
+		return fmt.Errorf("privateKeyFile or privateKey required")
+	}
+	if c.PrivateKeyFile != "" && c.PrivateKey != "" {
+		return fmt.Errorf("only one of privateKeyFile or privateKey can be set")
+	}
+	if _, err := c.privateKey(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c GitHubAppExchangeConfig) privateKey() (*rsa.PrivateKey, error) {
+	pemKey := []byte(c.PrivateKey)
+	if c.PrivateKeyFile != "" {
+		b, err := os.ReadFile(c.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading private key file: %w", err)
+		}
+		pemKey = b
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	return key, nil
+}
+
+// gitHubAppExchanger creates installation access tokens restricted to the repository.
+// Tokens are cached until shortly before they expire.
+type gitHubAppExchanger struct {
+	config GitHubAppExchangeConfig
+	client *http.Client
+	key    *rsa.PrivateKey
+	keyErr error
+
+	// mx guards tokens, it is not held while a token is created
+	mx     sync.Mutex
+	tokens map[string]Credential
+}
+
+// gitHubAppTokenRenewBefore is the time before the expiry of a cached token when a new token is created.
+const gitHubAppTokenRenewBefore = 5 * time.Minute
+
+type gitHubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e *gitHubAppExchanger) ExchangeCredential(ctx context.Context, req ExchangeRequest) (*Credential, error) {
+	if cred, ok := e.cachedToken(req.Repo); ok {
+		return &cred, nil
+	}
+
+	apiURL, err := gitHubAPIURL(req.RepoConfig)
+	if err != nil {
+		return nil, err
+	}
+	fullName, err := repositoryFullName(req.RepoConfig.URL)
+	if err != nil {
+		return nil, err
+	}
+	appJWT, err := e.appJWT()
+	if err != nil {
+		return nil, fmt.Errorf("building app JWT: %w", err)
+	}
+
+	b, err := json.Marshal(map[string][]string{
+		"repositories": {path.Base(fullName)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding body: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/app/installations/%d/access_tokens", apiURL, e.config.InstallationID), bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+appJWT)
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("creating installation token: unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token gitHubInstallationToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	cred := Credential{
+		Username:  "x-access-token",
+		Password:  token.Token,
+		ExpiresAt: token.ExpiresAt,
+	}
+	e.mx.Lock()
+	e.tokens[req.Repo] = cred
+	e.mx.Unlock()
+
+	return &cred, nil
+}
+
+// cachedToken returns the cached token of the repository, if it does not expire soon.
+func (e *gitHubAppExchanger) cachedToken(repo string) (Credential, bool) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	cred, ok := e.tokens[repo]
+	if !ok || !time.Now().Add(gitHubAppTokenRenewBefore).Before(cred.ExpiresAt) {
+		return Credential{}, false
+	}
+	return cred, true
+}
+
+// appJWT builds a JWT to authenticate as the GitHub App.
+func (e *gitHubAppExchanger) appJWT() (string, error) {
+	if e.keyErr != nil {
+		return "", e.keyErr
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		// Allow for clock drift as recommended by GitHub
+		IssuedAt:  jwt.NewNumericDate(now.Add(-60 * time.Second)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+		Issuer:    e.config.AppID,
+	})
+	return token.SignedString(e.key)
+}
+
+// --- STS
+
+// STSExchangeConfig configures an OAuth 2.0 token exchange (RFC 8693) endpoint.
+type STSExchangeConfig struct {
+	// URL of the token endpoint.
+	URL string `yaml:"url"`
+	// Audience to request for the exchanged token (optional).
+	Audience string `yaml:"audience"`
+	// Scope to request for the exchanged token (optional).
+	Scope string `yaml:"scope"`
+	// SubjectTokenType of the token of the caller, defaults to "urn:ietf:params:oauth:token-type:jwt".
+	SubjectTokenType string `yaml:"subjectTokenType"`
+	// ClientID to authenticate at the token endpoint (optional).
+	ClientID string `yaml:"clientId"`
+	// ClientSecret to authenticate at the token endpoint (optional).
+	ClientSecret string `yaml:"clientSecret"`
+	// Username for Git operations with the exchanged token, defaults to "oauth2".
+	Username string `yaml:"username"`
+}
+
+const (
+	stsGrantType               = "urn:ietf:params:oauth:grant-type:token-exchange"
+	stsDefaultSubjectTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	stsDefaultUsername         = "oauth2"
+)
+
+func (c STSExchangeConfig) Valid() error {
+	if c.URL == "" {
+		return fmt.Errorf("url required")
+	}
+	if c.ClientSecret != "" && c.ClientID == "" {
+		return fmt.Errorf("clientSecret requires clientId")
+	}
+	return nil
+}
+
+// stsExchanger exchanges the token of the caller for every request.
+type stsExchanger struct {
+	config STSExchangeConfig
+	client *http.Client
+}
+
+type stsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type stsErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (e *stsExchanger) ExchangeCredential(ctx context.Context, req ExchangeRequest) (*Credential, error) {
+	if req.AuthCtx.IDToken == "" {
+		return nil, errors.New("no token of caller to exchange")
+	}
+
+	subjectTokenType := e.config.SubjectTokenType
+	if subjectTokenType == "" {
+		subjectTokenType = stsDefaultSubjectTokenType
+	}
+	form := netUrl.Values{
+		"grant_type":         {stsGrantType},
+		"subject_token":      {req.AuthCtx.IDToken},
+		"subject_token_type": {subjectTokenType},
+	}
+	if e.config.Audience != "" {
+		form.Set("audience", e.config.Audience)
+	}
+	if e.config.Scope != "" {
+		form.Set("scope", e.config.Scope)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if e.config.ClientID != "" {
+		httpReq.SetBasicAuth(e.config.ClientID, e.config.ClientSecret)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		// The token of the caller was rejected, only the OAuth error code is safe to expose
+		var errRes stsErrorResponse
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&errRes)
+		return nil, clientError{fmt.Errorf("token exchange denied: %s", errRes.Error), http.StatusForbidden}
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("exchanging token: unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tokenRes stsTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenRes); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if tokenRes.AccessToken == "" {
+		return nil, errors.New("exchanging token: empty access token in response")
+	}
+
+	username := e.config.Username
+	if username == "" {
+		username = stsDefaultUsername
+	}
+	cred := &Credential{
+		Username: username,
+		Password: tokenRes.AccessToken,
+	}
+	if tokenRes.ExpiresIn > 0 {
+		cred.ExpiresAt = time.Now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+	}
+	return cred, nil
+}
@@ -0,0 +1,278 @@
+package vignet_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_ExchangeGitLabJobToken(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{Username: "gitlab-ci-token", Password: "a-job-token"}})
+
+	handler := newExchangeTestHandler(t, vignet.RepositoryConfig{
+		URL:      gitSrv.URL,
+		Exchange: &vignet.ExchangeConfig{Type: vignet.ExchangeTypeGitLabJobToken},
+	})
+
+	// Missing job token
+	rec := performExchangeTestPatch(t, handler, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "missing X-Vignet-Job-Token header")
+
+	rec = performExchangeTestPatch(t, handler, http.Header{vignet.JobTokenHeader: {"a-job-token"}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertGitRepoHeadCommit(t, fs, "Bumped release")
+}
+
+func TestHandler_ExchangeGitHubApp(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{Username: "x-access-token", Password: "ghs_installation-token"}})
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	var tokenRequests int
+	gitHubSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.Equal(t, "/app/installations/42/access_tokens", r.URL.Path)
+
+		appJWT := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var claims jwt.RegisteredClaims
+		_, err := jwt.ParseWithClaims(appJWT, &claims, func(token *jwt.Token) (any, error) {
+			return &privateKey.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"RS256"}))
+		require.NoError(t, err)
+		require.Equal(t, "1234", claims.Issuer)
+
+		var body struct {
+			Repositories []string `json:"repositories"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, []string{"infra"}, body.Repositories)
+
+		tokenRequests++
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token": "ghs_installation-token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer gitHubSrv.Close()
+
+	handler := newExchangeTestHandler(t, vignet.RepositoryConfig{
+		URL:    gitSrv.URL + "/my-org/infra.git",
+		APIURL: gitHubSrv.URL,
+		Exchange: &vignet.ExchangeConfig{
+			Type: vignet.ExchangeTypeGitHubApp,
+			GitHubApp: &vignet.GitHubAppExchangeConfig{
+				AppID:          "1234",
+				InstallationID: 42,
+				PrivateKey:     string(privateKeyPEM),
+			},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		rec := performExchangeTestPatch(t, handler, nil)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	assertGitRepoHeadCommit(t, fs, "Bumped release")
+	// The installation token is cached until it expires
+	require.Equal(t, 1, tokenRequests)
+}
+
+func TestHandler_ExchangeGitHubAppConcurrently(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	keyFile := filepath.Join(t.TempDir(), "github-app.pem")
+	require.NoError(t, os.WriteFile(keyFile, privateKeyPEM, 0o600))
+
+	// Token requests are only answered when both repositories requested a token
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	gitHubSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"token": "ghs_installation-token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer gitHubSrv.Close()
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}, newDefaultAuthorizer(t), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"my-org/*": {
+				URL:    gitSrv.URL + "/{path}",
+				APIURL: gitHubSrv.URL,
+				Exchange: &vignet.ExchangeConfig{
+					Type: vignet.ExchangeTypeGitHubApp,
+					GitHubApp: &vignet.GitHubAppExchangeConfig{
+						AppID:          "1234",
+						InstallationID: 42,
+						PrivateKeyFile: keyFile,
+					},
+				},
+			},
+		},
+	})
+	// The private key was read when the handler was built
+	require.NoError(t, os.Remove(keyFile))
+
+	var wg sync.WaitGroup
+	for _, repo := range []string{"infra", "apps"} {
+		wg.Add(1)
+		go func(repo string) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/patch/my-org%2F"+repo, strings.NewReader(`{
+				"dryRun": true,
+				"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+			}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}(repo)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("token of a repository was not created while the token of another repository was created")
+	}
+}
+
+func TestHandler_ExchangeSTS(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{Username: "oauth2", Password: "an-exchanged-token"}})
+
+	stsSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.PostForm.Get("grant_type"))
+		require.Equal(t, "git", r.PostForm.Get("audience"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("subject_token") != "an-id-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "subject token is not trusted"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "an-exchanged-token", "token_type": "Bearer", "expires_in": 600}`))
+	}))
+	defer stsSrv.Close()
+
+	repoConfig := vignet.RepositoryConfig{
+		URL: gitSrv.URL,
+		Exchange: &vignet.ExchangeConfig{
+			Type: vignet.ExchangeTypeSTS,
+			STS: &vignet.STSExchangeConfig{
+				URL:      stsSrv.URL,
+				Audience: "git",
+			},
+		},
+	}
+
+	handler := newExchangeTestHandlerWithIDToken(t, repoConfig, "an-id-token")
+	rec := performExchangeTestPatch(t, handler, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assertGitRepoHeadCommit(t, fs, "Bumped release")
+
+	handler = newExchangeTestHandlerWithIDToken(t, repoConfig, "an-untrusted-token")
+	rec = performExchangeTestPatch(t, handler, nil)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "token exchange denied: invalid_grant")
+	require.NotContains(t, rec.Body.String(), "subject token is not trusted")
+}
+
+func newExchangeTestHandler(t *testing.T, repoConfig vignet.RepositoryConfig) *vignet.Handler {
+	return newExchangeTestHandlerWithIDToken(t, repoConfig, "")
+}
+
+func newExchangeTestHandlerWithIDToken(t *testing.T, repoConfig vignet.RepositoryConfig, idToken string) *vignet.Handler {
+	t.Helper()
+
+	require.NoError(t, repoConfig.Valid())
+
+	return vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+		IDToken:      idToken,
+	}}, newDefaultAuthorizer(t), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": repoConfig,
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Bumped release",
+		},
+	})
+}
+
+func performExchangeTestPatch(t *testing.T, handler *vignet.Handler, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler_ExchangeTimeout(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	hang := make(chan struct{})
+	stsSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer stsSrv.Close()
+	defer close(hang)
+
+	handler := newExchangeTestHandlerWithIDToken(t, vignet.RepositoryConfig{
+		URL: gitSrv.URL,
+		Exchange: &vignet.ExchangeConfig{
+			Type:    vignet.ExchangeTypeSTS,
+			STS:     &vignet.STSExchangeConfig{URL: stsSrv.URL},
+			Timeout: 50 * time.Millisecond,
+		},
+	}, "an-id-token")
+
+	start := time.Now()
+	rec := performExchangeTestPatch(t, handler, nil)
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	require.Less(t, time.Since(start), 5*time.Second, "exchange should time out")
+}
@@ -0,0 +1,131 @@
+package vignet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// currentState is the state of the file targeted by a command before the change. It is passed to the policy in
+// input.current (by index of the command) after the repository was cloned.
+type currentState struct {
+	Path string `json:"path"`
+	// Exists is true if the file exists.
+	Exists bool `json:"exists"`
+	// Fields are the current values of the fields targeted by the command (e.g. the field of setField) by field path,
+	// missing fields are null.
+	Fields map[string]any `json:"fields"`
+	// Documents are the parsed documents of YAML and JSON files, only set if authorization.currentFileContent is enabled.
+	Documents []any `json:"documents,omitempty"`
+	// Files are the files below directories that are read or changed by the command (e.g. copyFile of a directory).
+	Files []string `json:"files,omitempty"`
+}
+
+// currentStateForCommands reads the state of the files targeted by the commands.
+// Files that are too large to patch are not read.
+func currentStateForCommands(fs billy.Filesystem, cmds []patchRequestCommand, withDocuments bool, maxFileSize int64) []currentState {
+	states := make([]currentState, len(cmds))
+	for i, cmd := range cmds {
+		state := currentState{
+			Path:   cmd.Path,
+			Fields: map[string]any{},
+			Files:  directoryFiles(fs, cmd),
+		}
+		states[i] = state
+		if cmd.Path == "" {
+			continue
+		}
+		info, err := fs.Stat(cmd.Path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		state.Exists = true
+		if info.Size() > maxFileSize {
+			states[i] = state
+			continue
+		}
+
+		for _, field := range targetedFields(cmd) {
+			if cmd.SetProperty != nil {
+				state.Fields[field] = readPropertyValue(fs, cmd.Path, field)
+			} else {
+				state.Fields[field] = readFieldValue(fs, cmd.Path, field)
+			}
+		}
+		if withDocuments && (isYAMLFile(cmd.Path) || isJSONFile(cmd.Path)) {
+			state.Documents = readDocuments(fs, cmd.Path)
+		}
+		states[i] = state
+	}
+	return states
+}
+
+// targetedFields returns the fields of the file that are read or changed by the command.
+func targetedFields(cmd patchRequestCommand) []string {
+	switch {
+	case cmd.SetField != nil:
+		// Values of encrypted files must not be disclosed
+		if cmd.SetField.SOPS {
+			return nil
+		}
+		assignments := cmd.SetField.assignments()
+		fields := make([]string, len(assignments))
+		for i, a := range assignments {
+			fields[i] = a.Field
+		}
+		return fields
+	case cmd.EnsureFields != nil:
+		fields := make([]string, 0, len(cmd.EnsureFields.Fields))
+		for field := range cmd.EnsureFields.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		return fields
+	case cmd.AddToArray != nil:
+		return []string{cmd.AddToArray.Field}
+	case cmd.IncrementField != nil:
+		return []string{cmd.IncrementField.Field}
+	case cmd.DeleteField != nil:
+		return []string{cmd.DeleteField.Field}
+	case cmd.SetComment != nil:
+		return []string{cmd.SetComment.Field}
+	case cmd.BumpChartVersion != nil:
+		return []string{"version", "appVersion"}
+	case cmd.SetProperty != nil:
+		return []string{cmd.SetProperty.Key}
+	default:
+		return nil
+	}
+}
+
+// readDocuments parses all documents of a YAML or JSON file, or returns nil if it cannot be read.
+func readDocuments(fs billy.Filesystem, filename string) []any {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var documents []any
+	dec := goyaml.NewDecoder(f)
+	for {
+		var doc any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		documents = append(documents, doc)
+	}
+	// Documents with non-string keys cannot be passed to the policy
+	if _, err := json.Marshal(documents); err != nil {
+		return nil
+	}
+	return documents
+}
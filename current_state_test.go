@@ -0,0 +1,98 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_CurrentStateInPolicyInput(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"release.yml": "image:\n  tag: 1.2.0\n",
+		"secret.yml":  "kind: Secret\ndata: {}\n",
+	}, gitserver.Options{})
+
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), loadTestBundle(t, `
+package vignet.request.patch
+import future.keywords
+
+violations contains msg if {
+	some i, cmd in input.patchRequest.commands
+	current := input.current[i].fields["image.tag"]
+	semver.compare(cmd.setField.value, current) < 0
+	msg := sprintf("image tag may only increase from %s", [current])
+}
+
+violations contains msg if {
+	some state in input.current
+	not state.exists
+	msg := sprintf("file %s must already exist", [state.path])
+}
+
+violations contains msg if {
+	some state in input.current
+	state.documents[_].kind == "Secret"
+	msg := sprintf("file %s contains a secret", [state.path])
+}
+`))
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Authorization: vignet.AuthorizationConfig{
+			CurrentFileContent: true,
+		},
+	})
+
+	tt := []struct {
+		name           string
+		command        string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "increased tag",
+			command:        `{"path": "release.yml", "setField": {"field": "image.tag", "value": "1.3.0"}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "decreased tag",
+			command:        `{"path": "release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "image tag may only increase from 1.2.0",
+		},
+		{
+			name:           "missing file",
+			command:        `{"path": "other.yml", "createFile": {"content": "foo: bar"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "file other.yml must already exist",
+		},
+		{
+			name:           "document content",
+			command:        `{"path": "secret.yml", "setField": {"field": "data.foo", "value": "bar"}}`,
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "file secret.yml contains a secret",
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test?dryRun=true", strings.NewReader(`{"commands": [`+tc.command+`]}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				assert.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	netUrl "net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gobwas/glob"
+)
+
+// DiscoveryConfig registers repositories automatically, so new projects can be patched without changing the
+// configuration. Configured repositories take precedence over discovered repositories with the same name.
+type DiscoveryConfig struct {
+	// Interval between discoveries, defaults to 5m.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of listing the projects of a group, defaults to 1m.
+	Timeout time.Duration `yaml:"timeout"`
+	// GitLabGroups are GitLab groups whose projects are registered as repositories.
+	GitLabGroups []GitLabGroupDiscoveryConfig `yaml:"gitLabGroups"`
+}
+
+const (
+	defaultDiscoveryInterval = 5 * time.Minute
+	defaultDiscoveryTimeout  = time.Minute
+)
+
+func (c DiscoveryConfig) Valid() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	for i, g := range c.GitLabGroups {
+		if err := g.Valid(); err != nil {
+			return fmt.Errorf("invalid gitLabGroups[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (c DiscoveryConfig) interval() time.Duration {
+	if c.Interval == 0 {
+		return defaultDiscoveryInterval
+	}
+	return c.Interval
+}
+
+func (c DiscoveryConfig) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultDiscoveryTimeout
+	}
+	return c.Timeout
+}
+
+// GitLabGroupDiscoveryConfig registers the projects of a GitLab group as repositories with provider "gitlab".
+type GitLabGroupDiscoveryConfig struct {
+	// URL of the GitLab instance, e.g. "https://gitlab.example.com".
+	URL string `yaml:"url"`
+	// Group is the full path of the group, e.g. "my-group/deployments".
+	Group string `yaml:"group"`
+	// Token is an access token to list projects of the group, it is used for discovered repositories as well.
+	Token string `yaml:"token"`
+	// IncludeSubgroups registers projects of subgroups as well.
+	IncludeSubgroups bool `yaml:"includeSubgroups"`
+	// Name of discovered repositories, "{path}" is replaced by the full path of the project and "{name}" by the path
+	// relative to the group. Defaults to "{path}".
+	Name string `yaml:"name"`
+	// Exclude are glob patterns of full project paths that are not registered (e.g. "my-group/legacy-*").
+	Exclude []string `yaml:"exclude"`
+	// Repository configures discovered repositories (optional), e.g. pushOptions or commit. The url, provider and
+	// token are set by the discovery.
+	Repository RepositoryConfig `yaml:"repository"`
+}
+
+func (c GitLabGroupDiscoveryConfig) Valid() error {
+	if c.URL == "" {
+		return fmt.Errorf("url required")
+	}
+	if c.Group == "" {
+		return fmt.Errorf("group required")
+	}
+	if c.Token == "" {
+		return fmt.Errorf("token required")
+	}
+	if c.Name != "" && !strings.Contains(c.Name, "{path}") && !strings.Contains(c.Name, "{name}") {
+		return fmt.Errorf("name must contain {path} or {name}")
+	}
+	for _, pattern := range c.Exclude {
+		if _, err := glob.Compile(pattern, '/'); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	if c.Repository.URL != "" || c.Repository.Provider != "" || c.Repository.Token != "" || c.Repository.BasicAuth != nil {
+		return fmt.Errorf("invalid repository: url, provider, token and basicAuth are set by the discovery")
+	}
+	if c.Repository.Exchange != nil {
+		return fmt.Errorf("invalid repository: exchange is not supported for discovered repositories")
+	}
+	repoConfig := c.repositoryConfig("https://gitlab.example.com/group/project.git")
+	if err := repoConfig.Valid(); err != nil {
+		return fmt.Errorf("invalid repository: %w", err)
+	}
+	return nil
+}
+
+func (c GitLabGroupDiscoveryConfig) repositoryConfig(url string) RepositoryConfig {
+	repoConfig := c.Repository
+	repoConfig.URL = url
+	repoConfig.Provider = RepositoryProviderGitLab
+	repoConfig.Token = c.Token
+	return repoConfig
+}
+
+// repositoryName returns the name of a discovered repository for the full path of a project.
+func (c GitLabGroupDiscoveryConfig) repositoryName(projectPath string) string {
+	name := c.Name
+	if name == "" {
+		name = "{path}"
+	}
+	relativePath := strings.TrimPrefix(projectPath, strings.TrimSuffix(c.Group, "/")+"/")
+	return strings.NewReplacer("{path}", projectPath, "{name}", relativePath).Replace(name)
+}
+
+type gitLabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	HTTPURLToRepo     string `json:"http_url_to_repo"`
+}
+
+// gitLabProjectsPerPage is the page size for listing projects, the maximum of the GitLab API.
+const gitLabProjectsPerPage = 100
+
+// discover lists the projects of the group with the given HTTP client and returns them as repositories by name.
+func (c GitLabGroupDiscoveryConfig) discover(ctx context.Context, httpClient *http.Client) (RepositoriesConfig, error) {
+	client, err := newGitLabClient(RepositoryConfig{
+		URL:   c.URL,
+		Token: c.Token,
+	}, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes := make([]glob.Glob, 0, len(c.Exclude))
+	for _, pattern := range c.Exclude {
+		excludes = append(excludes, glob.MustCompile(pattern, '/'))
+	}
+
+	repos := make(RepositoriesConfig)
+	for page := 1; ; page++ {
+		query := netUrl.Values{
+			"archived":          {"false"},
+			"include_subgroups": {fmt.Sprint(c.IncludeSubgroups)},
+			"order_by":          {"id"},
+			"sort":              {"asc"},
+			"per_page":          {fmt.Sprint(gitLabProjectsPerPage)},
+			"page":              {fmt.Sprint(page)},
+		}
+		var projects []gitLabProject
+		err := client.request(ctx, http.MethodGet, fmt.Sprintf("/groups/%s/projects?%s", netUrl.PathEscape(c.Group), query.Encode()), nil, &projects)
+		if err != nil {
+			return nil, fmt.Errorf("listing projects of group %s: %w", c.Group, err)
+		}
+
+	projects:
+		for _, p := range projects {
+			for _, exclude := range excludes {
+				if exclude.Match(p.PathWithNamespace) {
+					continue projects
+				}
+			}
+			repos[c.repositoryName(p.PathWithNamespace)] = c.repositoryConfig(p.HTTPURLToRepo)
+		}
+
+		if len(projects) < gitLabProjectsPerPage {
+			return repos, nil
+		}
+	}
+}
+
+// Discover registers the repositories of the discovery configuration once, the previously discovered repositories
+// are replaced. If a source fails, its previously discovered repositories are kept and an error is returned.
+func (s *Server) Discover(ctx context.Context) error {
+	s.discovered.mu.Lock()
+	defer s.discovered.mu.Unlock()
+
+	config := s.current().config.Discovery
+	httpClient := s.current().config.Timeouts.apiClient()
+	previous := s.discovered.repositories()
+	bySource := make(map[string]RepositoriesConfig, len(config.GitLabGroups))
+
+	discovered := make(RepositoriesConfig)
+	var errs []string
+	for _, g := range config.GitLabGroups {
+		source := g.URL + " " + g.Group
+		// The context of the server is not cancelled before shutdown, so a hanging API must not block discoveries
+		groupCtx, cancel := context.WithTimeout(ctx, config.timeout())
+		repos, err := g.discover(groupCtx, httpClient)
+		cancel()
+		if err != nil {
+			errs = append(errs, err.Error())
+			// Keep the repositories of the group until it can be listed again
+			repos = s.discovered.bySource[source]
+		}
+		bySource[source] = repos
+		for name, repoConfig := range repos {
+			if _, exists := discovered[name]; exists {
+				log.WithField("repo", name).Warn("Repository discovered in multiple groups, using the first")
+				continue
+			}
+			discovered[name] = repoConfig
+		}
+	}
+	s.discovered.bySource = bySource
+	s.discovered.all.Store(&discovered)
+
+	if added, removed := diffRepositoryNames(previous, discovered); len(added) > 0 || len(removed) > 0 {
+		log.
+			WithField("added", added).
+			WithField("removed", removed).
+			WithField("repositories", len(discovered)).
+			Info("Discovered repositories changed")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("discovering repositories: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// runDiscovery discovers repositories periodically until the context is done.
+func (s *Server) runDiscovery(ctx context.Context) {
+	for {
+		if err := s.Discover(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Error("Discovery of repositories failed, keeping previously discovered repositories")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.current().config.Discovery.interval()):
+		}
+	}
+}
+
+// discoveredRepositories holds the discovered repositories, it is shared by the handlers of a server across reloads.
+type discoveredRepositories struct {
+	all atomic.Pointer[RepositoriesConfig]
+
+	// mu serializes discoveries
+	mu sync.Mutex
+	// bySource are the repositories discovered by each source (URL and group)
+	bySource map[string]RepositoriesConfig
+}
+
+func (d *discoveredRepositories) repositories() RepositoriesConfig {
+	if d == nil {
+		return nil
+	}
+	if repos := d.all.Load(); repos != nil {
+		return *repos
+	}
+	return nil
+}
+
+// lookupRepository returns the configuration of a repository by name and the name it is configured with, configured
+// repositories take precedence over discovered repositories.
+func (h *Handler) lookupRepository(name string) (string, RepositoryConfig, bool) {
+	if key, repoConfig, exists := h.config.Repositories.lookup(name); exists {
+		return key, repoConfig, true
+	}
+	if repoConfig, exists := h.discovered.repositories()[name]; exists {
+		return name, repoConfig, true
+	}
+	return "", RepositoryConfig{}, false
+}
+
+func diffRepositoryNames(previous, next RepositoriesConfig) (added, removed []string) {
+	for name := range next {
+		if _, exists := previous[name]; !exists {
+			added = append(added, name)
+		}
+	}
+	for name := range previous {
+		if _, exists := next[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
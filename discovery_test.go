@@ -0,0 +1,191 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestServer_Discover(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	// Projects of the group, the first page is full to test pagination
+	var projects []map[string]string
+	for i := 0; i < 99; i++ {
+		projects = append(projects, map[string]string{
+			"path_with_namespace": fmt.Sprintf("my-group/deploy/app-%d", i),
+			"http_url_to_repo":    fmt.Sprintf("%s/my-group/deploy/app-%d.git", gitSrv.URL, i),
+		})
+	}
+	projects = append(projects,
+		map[string]string{"path_with_namespace": "my-group/deploy/legacy-app", "http_url_to_repo": gitSrv.URL + "/my-group/deploy/legacy-app.git"},
+		map[string]string{"path_with_namespace": "my-group/deploy/team/app", "http_url_to_repo": gitSrv.URL + "/my-group/deploy/team/app.git"},
+	)
+	var failing, hanging atomic.Bool
+	hang := make(chan struct{})
+	gitLabSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if hanging.Load() {
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		if r.URL.Path != "/api/v4/groups/my-group/deploy/projects" || r.Header.Get("PRIVATE-TOKEN") != "a-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "true", r.URL.Query().Get("include_subgroups"))
+		assert.Equal(t, "false", r.URL.Query().Get("archived"))
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		start, end := (page-1)*perPage, page*perPage
+		if start > len(projects) {
+			start = len(projects)
+		}
+		if end > len(projects) {
+			end = len(projects)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(projects[start:end])
+	}))
+	t.Cleanup(gitLabSrv.Close)
+	t.Cleanup(func() { close(hang) })
+
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		// A configured repository takes precedence over a discovered repository
+		"app-1": {URL: "http://127.0.0.1:1/configured.git"},
+	}
+	config.Discovery = vignet.DiscoveryConfig{
+		Timeout: 200 * time.Millisecond,
+		GitLabGroups: []vignet.GitLabGroupDiscoveryConfig{
+			{
+				URL:              gitLabSrv.URL,
+				Group:            "my-group/deploy",
+				Token:            "a-token",
+				IncludeSubgroups: true,
+				Name:             "{name}",
+				Exclude:          []string{"my-group/deploy/legacy-*"},
+				Repository: vignet.RepositoryConfig{
+					Commit: &vignet.RepositoryCommitConfig{DefaultMessage: "Discovered patch"},
+				},
+			},
+		},
+	}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config), vignet.WithAuthenticationProvider(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}))
+	require.NoError(t, err)
+	handler := srv.Handler()
+
+	patch := func(repo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/patch/"+repo+"?dryRun=true", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := patch("app-0")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+	require.NoError(t, srv.Discover(ctx))
+
+	for _, repo := range []string{"app-0", "app-98", "team%2Fapp"} {
+		rec = patch(repo)
+		require.Equal(t, http.StatusOK, rec.Code, repo+": "+rec.Body.String())
+	}
+	rec = patch("legacy-app")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+	// The configured repository is not reachable
+	rec = patch("app-1")
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+
+	t.Run("repository configuration", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch/app-2", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assertGitRepoHeadCommit(t, fs, "Discovered patch")
+	})
+
+	t.Run("failed discovery keeps repositories", func(t *testing.T) {
+		failing.Store(true)
+		defer failing.Store(false)
+
+		require.ErrorContains(t, srv.Discover(ctx), "listing projects of group my-group/deploy: unexpected status code 503")
+		rec := patch("app-0")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("hanging discovery times out", func(t *testing.T) {
+		hanging.Store(true)
+		defer hanging.Store(false)
+
+		require.ErrorContains(t, srv.Discover(ctx), "context deadline exceeded")
+		rec := patch("app-0")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("removed projects", func(t *testing.T) {
+		projects = projects[1:]
+		require.NoError(t, srv.Discover(ctx))
+
+		rec := patch("app-0")
+		require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	})
+}
+
+func TestConfig_Discovery(t *testing.T) {
+	config := vignet.Config{
+		AuthenticationProvider: vignet.AuthenticationProviderConfig{
+			Type:   vignet.AuthenticationProviderGitLab,
+			GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.com"},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+		Discovery: vignet.DiscoveryConfig{
+			GitLabGroups: []vignet.GitLabGroupDiscoveryConfig{
+				{URL: "https://gitlab.example.com", Group: "my-group", Token: "a-token", Name: "deploy"},
+			},
+		},
+	}
+	require.EqualError(t, config.Validate(), "invalid discovery: invalid gitLabGroups[0]: name must contain {path} or {name}")
+
+	config.Discovery.GitLabGroups[0].Name = ""
+	config.Discovery.GitLabGroups[0].Repository.URL = "https://gitlab.example.com/other.git"
+	require.EqualError(t, config.Validate(), "invalid discovery: invalid gitLabGroups[0]: invalid repository: url, provider, token and basicAuth are set by the discovery")
+
+	config.Discovery.GitLabGroups[0].Repository.URL = ""
+	require.NoError(t, config.Validate())
+
+	// Rules can be defined for repositories that are discovered
+	config.Authorization.Rules = map[string]vignet.RepositoryRulesConfig{"my-group/*": {}}
+	require.NoError(t, config.Validate())
+}
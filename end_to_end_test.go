@@ -1,15 +1,20 @@
 package vignet_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
-	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
@@ -20,10 +25,13 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
 	"github.com/networkteam/vignet/policy"
 )
 
 func TestEndToEnd(t *testing.T) {
+	sshPrivateKey, sshPublicKey := generateSSHKey(t)
+
 	tt := []struct {
 		name               string
 		patchPayload       string
@@ -31,155 +39,1881 @@ func TestEndToEnd(t *testing.T) {
 		expectedStatus     int
 		expectedGitContent map[string]fileExpectation
 		multipartFiles     map[string]string
+		// expectedDiff is set for dry runs and expects the given diff in the response and no new commit
+		expectedDiff string
+		// configure can be set to adjust the handler config for the test case
+		configure func(t *testing.T, config *vignet.Config)
+		// assertRepo can be set to perform additional assertions on the Git repository
+		assertRepo func(t *testing.T, fs billy.Filesystem)
 	}{
 		{
-			name: "valid setField with new key and create",
+			name: "valid setField with new key and create",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "spec.values.image.tag",
+						"value": "1.2.3",
+						"create": true
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{`foo: bar
+spec:
+  values:
+    image:
+      tag: 1.2.3
+`},
+			},
+		},
+		{
+			name: "valid multiple setField with JSONPath",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {
+						"field": "spec.template.spec.containers[0].image",
+						"value": "test.example.com:0.2.0"
+					  }
+					},
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {
+						"field": "spec.template.spec.containers[0].env[?(@.name == 'BUILD_ID')].value",
+						"value": "42"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.2.0
+          env:
+            - name: BUILD_ID
+              value: '42'
+`},
+			},
+		},
+		{
+			name: "invalid setField with new key and no create",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "spec.values.image.tag",
+						"value": "1.2.3"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `no nodes matched path`,
+		},
+		{
+			name: "invalid setField with non-existing file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/unknown.yml",
+					  "setField": {
+						"field": "spec.values.image.tag",
+						"value": "1.2.3"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `file does not exist`,
+		},
+		{
+			name: "valid create",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/new.yml",
+					  "createFile": {
+						"content": "---\nfoo: bar #Test\n"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{`foo: bar`},
+				"my-group/my-project/new.yml":     content{"---\nfoo: bar #Test\n"},
+			},
+		},
+		{
+			name: "invalid create with existing file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "createFile": {
+						"content": "---\nfoo: bar #Test\n"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "file already exists",
+		},
+		{
+			name: "valid delete",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "deleteFile": {}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": deleted{},
+			},
+		},
+		{
+			name: "valid setField with signed commit",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.Commit.SigningKey = &vignet.SigningKeyConfig{
+					Key:        generateArmoredGPGKey(t, "secret"),
+					Passphrase: "secret",
+				}
+			},
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+			assertRepo: func(t *testing.T, fs billy.Filesystem) {
+				commit := gitRepoHeadCommit(t, fs)
+				require.Contains(t, commit.PGPSignature, "-----BEGIN PGP SIGNATURE-----")
+			},
+		},
+		{
+			name: "valid setField with SSH signed commit",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.Commit.SigningKey = &vignet.SigningKeyConfig{
+					Format: vignet.SigningKeyFormatSSH,
+					Key:    sshPrivateKey,
+				}
+			},
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+			assertRepo: func(t *testing.T, fs billy.Filesystem) {
+				assertSSHSignedCommit(t, gitRepoHeadCommit(t, fs), sshPublicKey)
+			},
+		},
+		{
+			name: "invalid annotated createTag with SSH signing key",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "createTag": {
+						"name": "my-group/my-project/v1.2.3",
+						"message": "Release 1.2.3",
+						"annotated": true
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.Commit.SigningKey = &vignet.SigningKeyConfig{
+					Format: vignet.SigningKeyFormatSSH,
+					Key:    sshPrivateKey,
+				}
+			},
+			expectedStatus: 422,
+			expectedError:  "cannot be signed with an SSH signing key",
+		},
+		{
+			name: "valid setField with author from request",
+			patchPayload: `
+				{
+				  "commit": {
+					"author": {"name": "J. Doe", "email": "j.doe@example.com"}
+				  },
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.Commit.AuthorSources = []vignet.SignatureSource{vignet.SignatureSourceRequest}
+			},
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+			assertRepo: func(t *testing.T, fs billy.Filesystem) {
+				commit := gitRepoHeadCommit(t, fs)
+				require.Equal(t, "J. Doe", commit.Author.Name)
+				// Committer falls back to author, since the claims have no user
+				require.Equal(t, "j.doe@example.com", commit.Committer.Email)
+			},
+		},
+		{
+			name: "invalid setField with committer from claims only and no user in claims",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.Commit.CommitterSources = []vignet.SignatureSource{vignet.SignatureSourceClaims}
+			},
+			expectedStatus: 422,
+			expectedError:  "resolving commit committer",
+		},
+		{
+			name: "valid setField with changelog fragment",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				repo := config.Repositories["e2e-test"]
+				repo.Changelog = &vignet.ChangelogConfig{}
+				config.Repositories["e2e-test"] = repo
+			},
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+			assertRepo: func(t *testing.T, fs billy.Filesystem) {
+				var fragment string
+				files, err := gitRepoHeadCommit(t, fs).Files()
+				require.NoError(t, err)
+				err = files.ForEach(func(f *object.File) error {
+					if strings.HasPrefix(f.Name, "changelog.d/") {
+						require.Regexp(t, `^changelog.d/\d{14}-vignet.yaml$`, f.Name)
+						fragment, _ = f.Contents()
+					}
+					return nil
+				})
+				require.NoError(t, err)
+				require.Contains(t, fragment, `  message: Bumped release
+  identity:
+    projectPath: my-group/my-project
+  changes:
+    - path: my-group/my-project/release.yml
+      command: setField
+      field: foo
+      oldValue: bar
+      newValue: baz
+`)
+			},
+		},
+		{
+			name: "valid setField with dry run",
+			patchPayload: `
+				{
+				  "dryRun": true,
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedDiff: `diff --git a/my-group/my-project/release.yml b/my-group/my-project/release.yml
+index 7daacd5db8d36bc6df962d1d01cb98d8713fe5c4..c444f32c501072f3448d9855b939a7043ebd4817 100644
+--- a/my-group/my-project/release.yml
++++ b/my-group/my-project/release.yml
+@@ -1 +1 @@
+-foo: bar
+\ No newline at end of file
++foo: baz
+`,
+		},
+		{
+			name: "invalid setField with injected push rejection",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					}
+				  ]
+				}
+			`,
+			configure: func(t *testing.T, config *vignet.Config) {
+				config.FaultInjection = &vignet.FaultInjectionConfig{
+					PushRejectionRate: 1,
+				}
+			},
+			expectedStatus: 500,
+			expectedError:  "Patch failed",
+		},
+		{
+			name: "valid setField with annotated createTag",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {
+						"field": "foo",
+						"value": "baz"
+					  }
+					},
+					{
+					  "createTag": {
+						"name": "my-group/my-project/v1.2.3",
+						"message": "Release 1.2.3",
+						"annotated": true
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+			assertRepo: func(t *testing.T, fs billy.Filesystem) {
+				tag := gitRepoTag(t, fs, "my-group/my-project/v1.2.3")
+				require.Equal(t, "Release 1.2.3\n", tag.Message)
+				require.Equal(t, gitRepoHeadCommit(t, fs).Hash, tag.Target)
+			},
+		},
+		{
+			name: "invalid createTag with name not prefixed with project path",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "createTag": {
+						"name": "v1.2.3"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 403,
+			expectedError:  `tag "v1.2.3" is not prefixed with GitLab project path`,
+		},
+		{
+			name: "valid ensureFields with prune",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "ensureFields": {
+						"fields": {
+						  "spec.template.spec.replicas": 2,
+						  "spec.template.metadata.labels.app": "test"
+						},
+						"prune": "spec.template.metadata"
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.1.0
+          env:
+            - name: BUILD_ID
+              value: '1'
+      replicas: 2
+    metadata:
+      labels:
+        app: test
+`},
+			},
+		},
+		{
+			name: "valid addToArray with object",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "addToArray": {
+						"field": "$.spec.template.spec.containers[?(@.name=='test')].env",
+						"value": {"name": "LOG_LEVEL", "value": "debug"},
+						"index": 0
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.1.0
+          env:
+            - name: LOG_LEVEL
+              value: debug
+            - name: BUILD_ID
+              value: '1'
+`},
+			},
+		},
+		{
+			name: "invalid addToArray with negative index",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "addToArray": {
+						"field": "spec.template.spec.containers",
+						"value": {"name": "sidecar"},
+						"index": -1
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'index' must not be negative",
+		},
+		{
+			name: "valid jsonPatch",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "jsonPatch": [
+						{"op": "test", "path": "/spec/template/spec/containers/0/name", "value": "test"},
+						{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "test.example.com:0.2.0"},
+						{"op": "add", "path": "/spec/template/spec/containers/0/env/-", "value": {"name": "LOG_LEVEL", "value": "debug"}},
+						{"op": "add", "path": "/metadata", "value": {}},
+						{"op": "copy", "from": "/spec/template/spec/containers/0/name", "path": "/metadata/name"}
+					  ]
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.2.0
+          env:
+            - name: BUILD_ID
+              value: '1'
+            - name: LOG_LEVEL
+              value: debug
+metadata:
+  name: test
+`},
+			},
+		},
+		{
+			name: "invalid jsonPatch with failed test",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "jsonPatch": [
+						{"op": "test", "path": "/spec/template/spec/containers/0/name", "value": "other"},
+						{"op": "remove", "path": "/spec/template/spec/containers/0"}
+					  ]
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "test failed",
+		},
+		{
+			name: "invalid jsonPatch without value",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "jsonPatch": [
+						{"op": "replace", "path": "/spec/template"}
+					  ]
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'value' must be set for operation 0 (replace)",
+		},
+		{
+			name: "valid setField with multiple fields",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {
+						"fields": {
+						  "spec.template.spec.containers[0].image": "test.example.com:0.2.0",
+						  "spec.template.spec.containers[0].env[?(@.name == 'BUILD_ID')].value": "42"
+						}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.2.0
+          env:
+            - name: BUILD_ID
+              value: '42'
+`},
+			},
+		},
+		{
+			name: "invalid setField with multiple fields and missing field",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {
+						"fields": {
+						  "spec.template.spec.containers[0].image": "test.example.com:0.2.0",
+						  "spec.template.spec.replicas": 2
+						}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `setting field "spec.template.spec.replicas": no nodes matched path`,
+		},
+		{
+			name: "invalid setField with fields and field",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {
+						"field": "spec.template.spec.containers[0].image",
+						"fields": {
+						  "spec.template.spec.replicas": 2
+						}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'fields' cannot be combined with 'field' and 'value'",
+		},
+		{
+			name: "valid setField in JSON file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/appsettings.json",
+					  "setField": {
+						"fields": {
+						  "Api.Url": "https://api-v2.example.com",
+						  "Api.Timeout": 60,
+						  "Logging.LogLevel": "Debug"
+						}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/appsettings.json": content{`{
+    "Logging": {
+        "LogLevel": "Debug"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api-v2.example.com",
+        "Timeout": 60
+    }
+}
+`},
+			},
+		},
+		{
+			name: "valid setField in JSON file with create",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/appsettings.json",
+					  "setField": {
+						"field": "FeatureFlags.NewCheckout",
+						"value": true,
+						"create": true
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/appsettings.json": content{`{
+    "Logging": {
+        "LogLevel": "Information"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api.example.com",
+        "Timeout": 30
+    },
+    "FeatureFlags": {
+        "NewCheckout": true
+    }
+}
+`},
+			},
+		},
+		{
+			name: "valid setField in TOML file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "setField": {
+						"fields": {
+						  "project.version": "0.2.0",
+						  "tool.my-project.image": "test.example.com:0.2.0",
+						  "tool.my-project.replicas": 2
+						},
+						"create": true
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/pyproject.toml": content{`[project]
+name = "my-project"
+version = "0.2.0" # set by CI
+
+[tool.my-project]
+image = "test.example.com:0.2.0"
+replicas = 2
+`},
+			},
+		},
+		{
+			name: "invalid addToArray with TOML file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "addToArray": {"field": "project.authors", "value": "Jane"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "unsupported file type",
+		},
+		{
+			name: "valid setProperty in .env file",
+			patchPayload: `
+				{
+				  "commands": [
+					{"path": "my-group/my-project/.env.production", "setProperty": {"key": "LOG_LEVEL", "value": "debug"}},
+					{"path": "my-group/my-project/.env.production", "setProperty": {"key": "GREETING", "value": "Hello vignet"}},
+					{"path": "my-group/my-project/.env.production", "setProperty": {"key": "LEGACY_FLAG", "remove": true}},
+					{"path": "my-group/my-project/.env.production", "setProperty": {"key": "NEW_KEY", "value": "value with spaces"}}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/.env.production": content{`# Managed by CI
+export LOG_LEVEL=debug # verbose in staging
+GREETING="Hello vignet"
+NEW_KEY="value with spaces"
+`},
+			},
+		},
+		{
+			name: "invalid setProperty without value",
+			patchPayload: `
+				{
+				  "commands": [
+					{"path": "my-group/my-project/.env.production", "setProperty": {"key": "LOG_LEVEL"}}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "invalid 'setProperty' command: 'value' must be set",
+		},
+		{
+			name: "invalid setProperty with YAML file",
+			patchPayload: `
+				{
+				  "commands": [
+					{"path": "my-group/my-project/release.yml", "setProperty": {"key": "foo", "value": "baz"}}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "setProperty only supports .env and .properties files",
+		},
+		{
+			name: "invalid setField with .env file",
+			patchPayload: `
+				{
+				  "commands": [
+					{"path": "my-group/my-project/.env.production", "setField": {"field": "LOG_LEVEL", "value": "debug"}}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "only setProperty, createFile and deleteFile are supported",
+		},
+		{
+			name: "valid createFile with JSON file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/other.json",
+					  "createFile": {"content": "{}"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/other.json": content{"{}"},
+			},
+		},
+		{
+			name: "valid createFile with base64 encoded binary file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/favicon.ico",
+					  "createFile": {"content": "AAABAAEAEBD/AP8=", "encoding": "base64"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/favicon.ico": content{"\x00\x00\x01\x00\x01\x00\x10\x10\xff\x00\xff"},
+			},
+		},
+		{
+			name: "valid createFile with mode",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/migrate.sh",
+					  "createFile": {"content": "#!/bin/sh\n", "mode": "0755"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/migrate.sh": mode{0755},
+				"my-group/my-project/deploy.sh":  mode{0644},
+			},
+		},
+		{
+			name: "valid createFile with content from multipart file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/manifest.yml",
+					  "createFile": {"contentFrom": "manifest"}
+					},
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"}
+					}
+				  ]
+				}
+			`,
+			multipartFiles: map[string]string{
+				"manifest": "kind: ConfigMap\nmetadata:\n  name: my-app\n",
+			},
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/manifest.yml": content{"kind: ConfigMap\nmetadata:\n  name: my-app\n"},
+				"my-group/my-project/release.yml":  content{"foo: baz\n"},
+			},
+		},
+		{
+			name: "invalid createFile with missing multipart file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/manifest.yml",
+					  "createFile": {"contentFrom": "missing"}
+					}
+				  ]
+				}
+			`,
+			multipartFiles: map[string]string{
+				"manifest": "kind: ConfigMap\n",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `part "missing" not found`,
+		},
+		{
+			name: "valid createFromTemplate with inline template",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/VERSION",
+					  "createFromTemplate": {"template": "{{ .version }}\n", "values": {"version": "1.2.3"}}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/VERSION": content{"1.2.3\n"},
+			},
+		},
+		{
+			name: "valid createFromTemplate with template path",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/review-42.yml",
+					  "createFromTemplate": {
+						"templatePath": "my-group/my-project/templates/review.yml.tmpl",
+						"values": {"name": "review-42", "image": "test.example.com:0.2.0", "hosts": ["a.example.com"]}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/review-42.yml": content{"name: review-42\nimage: \"test.example.com:0.2.0\"\nhost: a.example.com\n"},
+			},
+		},
+		{
+			name: "invalid createFromTemplate with missing value",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/review-42.yml",
+					  "createFromTemplate": {
+						"templatePath": "my-group/my-project/templates/review.yml.tmpl",
+						"values": {"name": "review-42"}
+					  }
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `map has no entry for key "image"`,
+		},
+		{
+			name: "invalid createFromTemplate with syntax error",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/VERSION",
+					  "createFromTemplate": {"template": "{{ .version "}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "invalid 'template'",
+		},
+		{
+			name: "invalid createFromTemplate with non-existing template path",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/VERSION",
+					  "createFromTemplate": {"templatePath": "my-group/my-project/templates/unknown.tmpl"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `template "my-group/my-project/templates/unknown.tmpl" does not exist`,
+		},
+		{
+			name: "valid setField with expectedValue",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz", "expectedValue": "bar"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+		},
+		{
+			name: "valid setField with valueFrom",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "valueFrom": {"path": "my-group/my-project/pyproject.toml", "field": "project.version"}}
+					},
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "setField": {"field": "tool.my-project.image", "valueFrom": {"field": "project.name"}}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: 0.1.0\n"},
+				"my-group/my-project/pyproject.toml": content{`[project]
+name = "my-project"
+version = "0.1.0" # set by CI
+
+[tool.my-project]
+image = "my-project"
+`},
+			},
+		},
+		{
+			name: "invalid setField with valueFrom missing field",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "valueFrom": {"path": "my-group/my-project/appsettings.json", "field": "Api.Version"}}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `field "Api.Version" of "my-group/my-project/appsettings.json" does not exist`,
+		},
+		{
+			name: "valid setField with create and keys containing dots",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "metadata.annotations.app\\.kubernetes\\.io/version", "value": "1.0.0", "create": true}
+					},
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "metadata.labels['app.kubernetes.io/name']", "value": "my-project", "create": true}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{`foo: bar
+metadata:
+  annotations:
+    app.kubernetes.io/version: 1.0.0
+  labels:
+    app.kubernetes.io/name: my-project
+`},
+			},
+		},
+		{
+			name: "valid setField with create and new sequence item",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "spec.template.spec.containers[0].env[1].name", "value": "REVISION", "create": true}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.1.0
+          env:
+            - name: BUILD_ID
+              value: '1'
+            - name: REVISION
+`},
+			},
+		},
+		{
+			name: "valid setField with allowMultiple",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "$..name", "value": "app", "allowMultiple": true}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: test.example.com:0.1.0
+          env:
+            - name: app
+              value: '1'
+`},
+			},
+		},
+		{
+			name: "invalid setField with allowMultiple and create",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "spec.template.spec.containers[*].image", "value": "test.example.com:0.2.0", "allowMultiple": true, "create": true}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'allowMultiple' cannot be combined with 'create'",
+		},
+		{
+			name: "invalid setField with allowMultiple and different expectedValue",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "$..name", "value": "app", "allowMultiple": true, "expectedValue": "test"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 409,
+			expectedError:  `field "$..name" has value "BUILD_ID", expected "test"`,
+		},
+		{
+			name: "invalid setField with allowMultiple without matches",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "spec.template.spec.initContainers[*].image", "value": "test.example.com:0.2.0", "allowMultiple": true}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "no nodes matched path",
+		},
+		{
+			name: "valid deleteField",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "deleteField": {"field": "spec.template.spec.containers[0].env[0]"}
+					},
+					{
+					  "path": "my-group/my-project/appsettings.json",
+					  "deleteField": {"field": "Api.Timeout"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.1.0
+          env: []
+`},
+				"my-group/my-project/appsettings.json": content{`{
+    "Logging": {
+        "LogLevel": "Information"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api.example.com"
+    }
+}
+`},
+			},
+		},
+		{
+			name: "invalid deleteField with missing field",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "deleteField": {"field": "missing"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `deleting field "missing": no nodes matched path`,
+		},
+		{
+			name: "valid setField with JSON Pointer",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setField": {"field": "/spec/template/spec/containers/0/image", "value": "test.example.com:0.2.0"}
+					},
+					{
+					  "path": "my-group/my-project/appsettings.json",
+					  "setField": {"field": "/Api/Retries", "value": 3, "create": true}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          image: test.example.com:0.2.0
+          env:
+            - name: BUILD_ID
+              value: '1'
+`},
+				"my-group/my-project/appsettings.json": content{`{
+    "Logging": {
+        "LogLevel": "Information"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api.example.com",
+        "Timeout": 30,
+        "Retries": 3
+    }
+}
+`},
+			},
+		},
+		{
+			name: "invalid setField with JSON Pointer for TOML file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "setField": {"field": "/project/version", "value": "0.2.0"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `field "/project/version": JSON Pointers are only supported for YAML and JSON files`,
+		},
+		{
+			name: "valid setComment",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deployment.yml",
+					  "setComment": {"field": "spec.template.spec.containers[0].image", "lineComment": "{\"$imagepolicy\": \"flux-system:test\"}", "headComment": "Managed by vignet"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/deployment.yml": content{`spec:
+  template:
+    spec:
+      containers:
+        - name: test
+          # Managed by vignet
+          image: test.example.com:0.1.0 # {"$imagepolicy": "flux-system:test"}
+          env:
+            - name: BUILD_ID
+              value: '1'
+`},
+			},
+		},
+		{
+			name: "invalid setComment without comments",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setComment": {"field": "foo"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "one of 'lineComment' or 'headComment' must be given",
+		},
+		{
+			name: "valid setField with style",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "1.20", "style": "single"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: '1.20'\n"},
+			},
+		},
+		{
+			name: "invalid setField with style for number",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": 1.2, "style": "double"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'style' double is only supported for string values",
+		},
+		{
+			name: "invalid setField with style for TOML file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "setField": {"field": "project.version", "value": "0.2.0", "style": "single"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "'style' is only supported for YAML files",
+		},
+		{
+			name: "valid setField with expandAliases",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/anchors.yml",
+					  "setField": {"field": "production.image.tag", "value": "1.1.0", "expandAliases": true}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/anchors.yml": content{`defaults: &defaults
+  image:
+    tag: 1.0.0
+production:
+  <<: *defaults
+  image:
+    tag: 1.1.0
+staging:
+  <<: *defaults
+`},
+			},
+		},
+		{
+			name: "invalid setField of field shared by anchor",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/anchors.yml",
+					  "setField": {"field": "production.image.tag", "value": "1.1.0"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `field "production.image.tag" is shared by anchor "defaults" with aliases`,
+		},
+		{
+			name: "valid optional setField with missing file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"}
+					},
+					{
+					  "path": "my-group/my-project/missing.yml",
+					  "setField": {"field": "foo", "value": "baz"},
+					  "optional": true
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+				"my-group/my-project/missing.yml": deleted{},
+			},
+		},
+		{
+			name: "valid setField with continueOnError",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "missing", "value": "baz"},
+					  "continueOnError": true
+					},
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+		},
+		{
+			name: "valid non-atomic request with failing command",
+			patchPayload: `
+				{
+				  "atomic": false,
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"}
+					},
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "incrementField": {"field": "foo"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{"foo: baz\n"},
+			},
+		},
+		{
+			name: "invalid atomic request with failing command",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"}
+					},
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "missing", "value": "baz"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "no nodes matched path",
+		},
+		{
+			name: "invalid continueOnError in atomic request",
+			patchPayload: `
+				{
+				  "atomic": true,
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"field": "foo", "value": "baz"},
+					  "continueOnError": true
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'commands[0]' is invalid: 'continueOnError' cannot be used for an atomic request",
+		},
+		{
+			name: "invalid optional createFile",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/new.yml",
+					  "createFile": {"content": "foo: bar"},
+					  "optional": true
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'commands[0]' is invalid: 'optional' cannot be used for 'createFile' command",
+		},
+		{
+			name: "invalid setField with different expectedValue",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/pyproject.toml",
+					  "setField": {"field": "project.version", "value": "0.1.1", "expectedValue": "0.0.9"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 409,
+			expectedError:  `field "project.version" has value "0.1.0", expected "0.0.9"`,
+		},
+		{
+			name: "invalid setField with expectedValue and fields",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/release.yml",
+					  "setField": {"fields": {"foo": "baz"}, "expectedValue": "bar"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'expectedValue' cannot be combined with 'fields'",
+		},
+		{
+			name: "valid setLabel with kind",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/manifests.yml",
+					  "setLabel": {"key": "app.kubernetes.io/version", "value": "1.0", "kind": "Deployment"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/manifests.yml": content{`apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    app.kubernetes.io/name: my-app
+    app.kubernetes.io/version: "1.0"
+`},
+			},
+		},
+		{
+			name: "valid setAnnotation for all manifests",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/manifests.yml",
+					  "setAnnotation": {"values": {"example.com/revision": "abc123", "example.com/owner": "team-a"}, "name": "my-app"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/manifests.yml": content{`apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+  annotations:
+    example.com/owner: team-a
+    example.com/revision: abc123
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    app.kubernetes.io/name: my-app
+  annotations:
+    example.com/owner: team-a
+    example.com/revision: abc123
+`},
+			},
+		},
+		{
+			name: "invalid setLabel without matching manifest",
 			patchPayload: `
 				{
 				  "commands": [
 					{
-					  "path": "my-group/my-project/release.yml",
-					  "setField": {
-						"field": "spec.values.image.tag",
-						"value": "1.2.3",
-						"create": true
-					  }
+					  "path": "my-group/my-project/manifests.yml",
+					  "setLabel": {"key": "team", "value": "a", "kind": "Ingress"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "no manifest matched kind and name",
+		},
+		{
+			name: "valid updateImageMarkers with directory",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/flux",
+					  "updateImageMarkers": {"policy": "flux-system:app", "image": "test.example.com/app", "tag": "0.2.0"}
 					}
 				  ]
 				}
 			`,
 			expectedGitContent: map[string]fileExpectation{
-				"my-group/my-project/release.yml": content{`foo: bar
-spec:
-  values:
-    image:
-      tag: 1.2.3
+				"my-group/my-project/flux/app.yaml": content{`image: test.example.com/app:0.2.0 # {"$imagepolicy": "flux-system:app"}
+`},
+				"my-group/my-project/flux/nested/values.yaml": content{`image:
+  repository: test.example.com/app # {"$imagepolicy": "flux-system:app:name"}
+  tag: 0.2.0 # {"$imagepolicy": "flux-system:app:tag"}
 `},
 			},
 		},
 		{
-			name: "valid multiple setField with JSONPath",
+			name: "invalid updateImageMarkers with unknown policy",
 			patchPayload: `
 				{
 				  "commands": [
 					{
-					  "path": "my-group/my-project/deployment.yml",
-					  "setField": {
-						"field": "spec.template.spec.containers[0].image",
-						"value": "test.example.com:0.2.0"
-					  }
-					},
+					  "path": "my-group/my-project/flux/app.yaml",
+					  "updateImageMarkers": {"policy": "flux-system:other", "image": "test.example.com/other", "tag": "0.2.0"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  `no image policy markers found for policy "flux-system:other"`,
+		},
+		{
+			name: "valid copyFile with directory",
+			patchPayload: `
+				{
+				  "commands": [
 					{
-					  "path": "my-group/my-project/deployment.yml",
-					  "setField": {
-						"field": "spec.template.spec.containers[0].env[?(@.name == 'BUILD_ID')].value",
-						"value": "42"
-					  }
+					  "path": "my-group/my-project/production",
+					  "copyFile": {"from": "my-group/my-project/flux"}
 					}
 				  ]
 				}
 			`,
 			expectedGitContent: map[string]fileExpectation{
-				"my-group/my-project/deployment.yml": content{`spec:
-  template:
-    spec:
-      containers:
-        - name: test
-          image: test.example.com:0.2.0
-          env:
-            - name: BUILD_ID
-              value: '42'
+				"my-group/my-project/production/app.yaml": content{`image: test.example.com/app:0.1.0 # {"$imagepolicy": "flux-system:app"}
+`},
+				"my-group/my-project/production/chart/templates/deployment.yaml": content{`image: {{ .Values.image.repository }}
+`},
+				"my-group/my-project/flux/app.yaml": content{`image: test.example.com/app:0.1.0 # {"$imagepolicy": "flux-system:app"}
 `},
 			},
 		},
 		{
-			name: "invalid setField with new key and no create",
+			name: "valid copyFile with overwrite",
 			patchPayload: `
 				{
 				  "commands": [
 					{
 					  "path": "my-group/my-project/release.yml",
-					  "setField": {
-						"field": "spec.values.image.tag",
-						"value": "1.2.3"
-					  }
+					  "copyFile": {"from": "my-group/my-project/flux/app.yaml", "overwrite": true}
 					}
 				  ]
 				}
 			`,
-			expectedStatus: 422,
-			expectedError:  `no nodes matched path`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/release.yml": content{`image: test.example.com/app:0.1.0 # {"$imagepolicy": "flux-system:app"}
+`},
+			},
 		},
 		{
-			name: "invalid setField with non-existing file",
+			name: "invalid copyFile to existing file",
 			patchPayload: `
 				{
 				  "commands": [
 					{
-					  "path": "my-group/my-project/unknown.yml",
-					  "setField": {
-						"field": "spec.values.image.tag",
-						"value": "1.2.3"
-					  }
+					  "path": "my-group/my-project/release.yml",
+					  "copyFile": {"from": "my-group/my-project/deploy.sh"}
 					}
 				  ]
 				}
 			`,
 			expectedStatus: 422,
-			expectedError:  `file does not exist`,
+			expectedError:  `file "my-group/my-project/release.yml" already exists`,
 		},
 		{
-			name: "valid create",
+			name: "valid incrementField with JSON file",
 			patchPayload: `
 				{
 				  "commands": [
 					{
-					  "path": "my-group/my-project/new.yml",
-					  "createFile": {
-						"content": "---\nfoo: bar #Test\n"
-					  }
+					  "path": "my-group/my-project/appsettings.json",
+					  "incrementField": {"field": "Api.Timeout", "by": 15}
 					}
 				  ]
 				}
 			`,
 			expectedGitContent: map[string]fileExpectation{
-				"my-group/my-project/release.yml": content{`foo: bar`},
-				"my-group/my-project/new.yml":     content{"---\nfoo: bar #Test\n"},
+				"my-group/my-project/appsettings.json": content{`{
+    "Logging": {
+        "LogLevel": "Information"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api.example.com",
+        "Timeout": 45
+    }
+}
+`},
 			},
 		},
 		{
-			name: "invalid create with existing file",
+			name: "valid bumpChartVersion",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/chart/Chart.yaml",
+					  "bumpChartVersion": {"bump": "minor", "appVersion": "1.1.0"}
+					}
+				  ]
+				}
+			`,
+			expectedGitContent: map[string]fileExpectation{
+				"my-group/my-project/chart/Chart.yaml": content{`apiVersion: v2
+name: my-project
+# Bumped by CI
+version: 0.4.0
+appVersion: "1.1.0"
+`},
+			},
+		},
+		{
+			name: "invalid bumpChartVersion with unknown part",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/chart/Chart.yaml",
+					  "bumpChartVersion": {"bump": "build"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  `unknown part "build"`,
+		},
+		{
+			name: "invalid bumpChartVersion with other file",
 			patchPayload: `
 				{
 				  "commands": [
 					{
 					  "path": "my-group/my-project/release.yml",
-					  "createFile": {
-						"content": "---\nfoo: bar #Test\n"
-					  }
+					  "bumpChartVersion": {}
 					}
 				  ]
 				}
 			`,
 			expectedStatus: 422,
-			expectedError:  "file already exists",
+			expectedError:  "bumpChartVersion only supports Chart.yaml files",
 		},
 		{
-			name: "valid delete",
+			name: "valid setMode",
 			patchPayload: `
 				{
 				  "commands": [
 					{
-					  "path": "my-group/my-project/release.yml",
-					  "deleteFile": {}
+					  "path": "my-group/my-project/deploy.sh",
+					  "setMode": {"mode": "0755"}
 					}
 				  ]
 				}
 			`,
 			expectedGitContent: map[string]fileExpectation{
-				"my-group/my-project/release.yml": deleted{},
+				"my-group/my-project/deploy.sh": mode{0755},
 			},
 		},
+		{
+			name: "invalid setMode with unsupported mode",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/deploy.sh",
+					  "setMode": {"mode": "0777"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "unsupported mode \"0777\", only 0644 and 0755 are supported",
+		},
+		{
+			name: "invalid setMode with non-existing file",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/unknown.sh",
+					  "setMode": {"mode": "0755"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 422,
+			expectedError:  "file does not exist",
+		},
+		{
+			name: "invalid createFile with invalid base64 content",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/favicon.ico",
+					  "createFile": {"content": "not base64!", "encoding": "base64"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "'content' is not valid base64",
+		},
+		{
+			name: "invalid createFile with unsupported encoding",
+			patchPayload: `
+				{
+				  "commands": [
+					{
+					  "path": "my-group/my-project/favicon.ico",
+					  "createFile": {"content": "AAAB", "encoding": "hex"}
+					}
+				  ]
+				}
+			`,
+			expectedStatus: 400,
+			expectedError:  "unsupported 'encoding' \"hex\"",
+		},
 		{
 			name: "invalid delete with non-existing file",
 			patchPayload: `
@@ -213,6 +1947,70 @@ spec:
 			initGitRepo(t, fs, map[string]string{
 				"my-group/my-project/release.yml": "foo: bar",
 				"other/file.yml":                  "version: 123",
+				"my-group/my-project/deploy.sh":   "#!/bin/sh\necho deploy\n",
+				"my-group/my-project/flux/app.yaml": `image: test.example.com/app:0.1.0 # {"$imagepolicy": "flux-system:app"}
+`,
+				"my-group/my-project/flux/nested/values.yaml": `image:
+  repository: test.example.com/app # {"$imagepolicy": "flux-system:app:name"}
+  tag: 0.1.0 # {"$imagepolicy": "flux-system:app:tag"}
+`,
+				"my-group/my-project/flux/chart/templates/deployment.yaml": `image: {{ .Values.image.repository }}
+`,
+				"my-group/my-project/manifests.yml": `apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    app.kubernetes.io/name: my-app
+`,
+				"my-group/my-project/anchors.yml": `defaults: &defaults
+  image:
+    tag: 1.0.0
+production:
+  <<: *defaults
+staging:
+  <<: *defaults
+`,
+				"my-group/my-project/chart/Chart.yaml": `apiVersion: v2
+name: my-project
+# Bumped by CI
+version: 0.3.9
+appVersion: "1.0.0"
+`,
+				"my-group/my-project/templates/review.yml.tmpl": `name: {{ .name }}
+image: {{ .image | printf "%q" }}
+{{- range .hosts }}
+host: {{ . }}
+{{- end }}
+`,
+				"my-group/my-project/.env.production": `# Managed by CI
+export LOG_LEVEL=info # verbose in staging
+GREETING="Hello World"
+LEGACY_FLAG=1
+`,
+				"my-group/my-project/pyproject.toml": `[project]
+name = "my-project"
+version = "0.1.0" # set by CI
+
+[tool.my-project]
+image = "test.example.com:0.1.0"
+`,
+				"my-group/my-project/appsettings.json": `{
+    "Logging": {
+        "LogLevel": "Information"
+    },
+    "AllowedHosts": "*",
+    "Api": {
+        "Url": "https://api.example.com",
+        "Timeout": 30
+    }
+}
+`,
 				"my-group/my-project/deployment.yml": `spec:
   template:
     spec:
@@ -225,7 +2023,7 @@ spec:
 `,
 			})
 			// - Start mock HTTP Git server with basic auth
-			gitSrv := httptest.NewServer(newMockHttpGitServer(fs, mockHttpGitServerOpts{basicAuth: &gitHttp.BasicAuth{
+			gitSrv := httptest.NewServer(gitserver.New(fs, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{
 				Username: "j.doe",
 				Password: "not-a-secret",
 			}}))
@@ -245,7 +2043,7 @@ spec:
 			require.NoError(t, err)
 
 			// - Create handler
-			handler := vignet.NewHandler(authProvider, authorizer, vignet.Config{
+			config := vignet.Config{
 				Repositories: vignet.RepositoriesConfig{
 					"e2e-test": {
 						URL: gitSrv.URL,
@@ -258,12 +2056,33 @@ spec:
 				Commit: vignet.CommitConfig{
 					DefaultMessage: "Bumped release",
 				},
-			})
+			}
+			if tc.configure != nil {
+				tc.configure(t, &config)
+			}
+			handler := vignet.NewHandler(authProvider, authorizer, config)
 
 			// --- Build patch request
 			// - Build a simulated JWT coming from GitLab Job (CI_JOB_JWT)
 			serializedJWT := buildJWT(t, ks)
-			req, _ := http.NewRequest("POST", "/patch/e2e-test", strings.NewReader(tc.patchPayload))
+			var req *http.Request
+			if tc.multipartFiles != nil {
+				// - Send the patch payload as "request" part and the files as additional parts
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				require.NoError(t, mw.WriteField("request", tc.patchPayload))
+				for name, fileContent := range tc.multipartFiles {
+					pw, err := mw.CreateFormFile(name, name)
+					require.NoError(t, err)
+					_, err = pw.Write([]byte(fileContent))
+					require.NoError(t, err)
+				}
+				require.NoError(t, mw.Close())
+				req, _ = http.NewRequest("POST", "/patch/e2e-test", &body)
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+			} else {
+				req, _ = http.NewRequest("POST", "/patch/e2e-test", strings.NewReader(tc.patchPayload))
+			}
 			req.Header.Set("Authorization", "Bearer "+string(serializedJWT))
 
 			// --- Perform request
@@ -282,9 +2101,41 @@ spec:
 				return
 			}
 
+			// --- Assert response contains pushed commit
+			var res struct {
+				Commit   string `json:"commit"`
+				Branch   string `json:"branch"`
+				Commands []struct {
+					ChangedFiles []string `json:"changedFiles"`
+				} `json:"commands"`
+				DryRun           bool   `json:"dryRun"`
+				Diff             string `json:"diff"`
+				ConsistencyToken string `json:"consistencyToken"`
+			}
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			err = json.Unmarshal(rec.Body.Bytes(), &res)
+			require.NoError(t, err)
+
+			if tc.expectedDiff != "" {
+				require.True(t, res.DryRun)
+				require.Equal(t, tc.expectedDiff, res.Diff)
+				require.Empty(t, res.Commit)
+				require.Empty(t, res.ConsistencyToken)
+				assertGitRepoHeadCommit(t, fs, "Initial commit")
+				return
+			}
+			require.Equal(t, gitRepoHeadCommit(t, fs).Hash.String(), res.Commit)
+			require.Equal(t, "master", res.Branch)
+			require.NotEmpty(t, res.Commands)
+			require.NotEmpty(t, res.ConsistencyToken)
+			require.Equal(t, res.ConsistencyToken, rec.Header().Get(vignet.ConsistencyTokenHeader))
+
 			// --- Assert Git repository contains change
 			assertGitRepoHeadCommit(t, fs, "Bumped release")
 			assertGitRepoContains(t, fs, tc.expectedGitContent)
+			if tc.assertRepo != nil {
+				tc.assertRepo(t, fs)
+			}
 		})
 	}
 }
@@ -301,6 +2152,11 @@ type deleted struct{}
 func (deleted) content() string { return "" }
 func (deleted) isDeleted() bool { return true }
 
+type mode struct{ os.FileMode }
+
+func (mode) content() string { return "" }
+func (mode) isDeleted() bool { return false }
+
 type fileExpectation interface {
 	content() string
 	isDeleted() bool
@@ -309,6 +2165,13 @@ type fileExpectation interface {
 func assertGitRepoHeadCommit(t *testing.T, fs billy.Filesystem, expectedMessage string) {
 	t.Helper()
 
+	commit := gitRepoHeadCommit(t, fs)
+	require.Equal(t, expectedMessage, commit.Message)
+}
+
+func gitRepoHeadCommit(t *testing.T, fs billy.Filesystem) *object.Commit {
+	t.Helper()
+
 	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
 	defer storer.Close()
 
@@ -321,7 +2184,77 @@ func assertGitRepoHeadCommit(t *testing.T, fs billy.Filesystem, expectedMessage
 	commit, err := repo.CommitObject(head.Hash())
 	require.NoError(t, err)
 
-	require.Equal(t, expectedMessage, commit.Message)
+	return commit
+}
+
+func gitRepoTag(t *testing.T, fs billy.Filesystem, name string) *object.Tag {
+	t.Helper()
+
+	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	defer storer.Close()
+
+	repo, err := git.Open(storer, nil)
+	require.NoError(t, err)
+
+	ref, err := repo.Tag(name)
+	require.NoError(t, err)
+
+	tag, err := repo.TagObject(ref.Hash())
+	require.NoError(t, err)
+
+	return tag
+}
+
+func TestHandler_SigningKeyIsLoadedOnce(t *testing.T) {
+	gitFS, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	keyFile := filepath.Join(t.TempDir(), "signing-key.asc")
+	require.NoError(t, os.WriteFile(keyFile, []byte(generateArmoredGPGKey(t, "secret")), 0o600))
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			SigningKey: &vignet.SigningKeyConfig{File: keyFile, Passphrase: "secret"},
+		},
+	})
+
+	// The key was read when the handler was built, it is not read again for a commit
+	require.NoError(t, os.Remove(keyFile))
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Contains(t, gitRepoHeadCommit(t, gitFS).PGPSignature, "-----BEGIN PGP SIGNATURE-----")
+}
+
+func generateArmoredGPGKey(t *testing.T, passphrase string) string {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("vignet", "", "bot@vignet", nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+
+	err = entity.PrivateKey.Encrypt([]byte(passphrase))
+	require.NoError(t, err)
+	for _, subkey := range entity.Subkeys {
+		err = subkey.PrivateKey.Encrypt([]byte(passphrase))
+		require.NoError(t, err)
+	}
+	err = entity.SerializePrivateWithoutSigning(w, nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.String()
 }
 
 func assertGitRepoContains(t *testing.T, fs billy.Filesystem, expectedFiles map[string]fileExpectation) {
@@ -358,48 +2291,13 @@ func assertGitRepoContains(t *testing.T, fs billy.Filesystem, expectedFiles map[
 		case deleted:
 			_, err := workdirFS.Stat(path)
 			require.ErrorIs(t, err, os.ErrNotExist)
-		}
-
-	}
-}
-
-func initGitRepo(t *testing.T, fs billy.Filesystem, initialFiles map[string]string) {
-	t.Helper()
-
-	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
-	defer storer.Close()
-
-	workdirFS := memfs.New()
-	repo, err := git.Init(storer, workdirFS)
-	require.NoError(t, err)
-
-	// Create initial files
-	for path, content := range initialFiles {
-		(func() {
-			f, err := workdirFS.Create(path)
-			require.NoError(t, err)
-			defer f.Close()
-
-			_, err = f.Write([]byte(content))
+		case mode:
+			fi, err := workdirFS.Stat(path)
 			require.NoError(t, err)
-		})()
-	}
 
-	// Add files
-	w, err := repo.Worktree()
-	require.NoError(t, err)
+			// Assert mode (the checkout sets the permissions of the mode in the tree)
+			require.Equal(t, v.FileMode, fi.Mode().Perm())
+		}
 
-	for path := range initialFiles {
-		_, err := w.Add(path)
-		require.NoError(t, err)
 	}
-
-	_, err = w.Commit("Initial commit", &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "vignet",
-			Email: "test@vignet",
-			When:  time.Now(),
-		},
-	})
-	require.NoError(t, err)
 }
@@ -0,0 +1,107 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_EnsureFields(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 0.1.0\nenv:\n  A: \"1\"\n  B: \"2\"\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Converged release",
+		},
+	})
+
+	payload := `{
+		"commands": [{
+			"path": "my-group/my-project/release.yml",
+			"ensureFields": {
+				"fields": {"image.tag": "0.2.0", "env.A": "1"},
+				"prune": "env"
+			}
+		}]
+	}`
+
+	type response struct {
+		Commit   string `json:"commit"`
+		Commands []struct {
+			ChangedFiles  []string `json:"changedFiles"`
+			ChangedFields []string `json:"changedFields"`
+			PrunedFields  []string `json:"prunedFields"`
+		} `json:"commands"`
+	}
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(payload))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.NotEmpty(t, res.Commit)
+	require.Equal(t, []string{"my-group/my-project/release.yml"}, res.Commands[0].ChangedFiles)
+	require.Equal(t, []string{"image.tag"}, res.Commands[0].ChangedFields)
+	require.Equal(t, []string{"env.B"}, res.Commands[0].PrunedFields)
+	assertGitRepoHeadCommit(t, fs, "Converged release")
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"image:\n  tag: 0.2.0\nenv:\n  A: \"1\"\n"},
+	})
+
+	// The file is already in the desired state, so no commit is created
+	req = httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(payload))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	res = response{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Empty(t, res.Commit)
+	require.Empty(t, res.Commands[0].ChangedFiles)
+	require.Empty(t, res.Commands[0].ChangedFields)
+	assertGitRepoHeadCommit(t, fs, "Converged release")
+}
+
+func TestHandler_InvalidEnsureFields(t *testing.T) {
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, newDefaultAuthorizer(t), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: "http://localhost"},
+		},
+	})
+
+	tests := []struct {
+		name          string
+		ensureFields  string
+		expectedError string
+	}{
+		{name: "empty fields", ensureFields: `{"fields": {}}`, expectedError: "'fields' must not be empty"},
+		{name: "JSONPath field", ensureFields: `{"fields": {"$.foo": "bar"}}`, expectedError: "must be a valid path"},
+		{name: "non-scalar value", ensureFields: `{"fields": {"foo": {"bar": "baz"}}}`, expectedError: "must be a scalar"},
+		{name: "conflicting fields", ensureFields: `{"fields": {"foo": "bar", "foo.bar": "baz"}}`, expectedError: "conflicts with field"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+				"commands": [{"path": "my-group/my-project/release.yml", "ensureFields": `+tc.ensureFields+`}]
+			}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tc.expectedError)
+		})
+	}
+}
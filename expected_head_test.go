@@ -0,0 +1,158 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_ExpectedHead(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+
+	const otherHash = "0123456789abcdef0123456789abcdef01234567"
+	patch := func(ifMatch, expectedHead, value string) *httptest.ResponseRecorder {
+		body := `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "` + value + `"}}]`
+		if expectedHead != "" {
+			body += `, "expectedHead": "` + expectedHead + `"`
+		}
+		body += `}`
+		req := httptest.NewRequest("POST", "/v1/patch/e2e-test", strings.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("expectedHead matches", func(t *testing.T) {
+		head := gitRepoHeadCommit(t, fs).Hash.String()
+		rec := patch("", head, "baz")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.NotEqual(t, head, gitRepoHeadCommit(t, fs).Hash.String())
+	})
+
+	t.Run("expectedHead does not match", func(t *testing.T) {
+		head := gitRepoHeadCommit(t, fs).Hash.String()
+		rec := patch("", otherHash, "qux")
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+
+		var res struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, "head_mismatch", res.Code)
+		assert.Equal(t, `HEAD of branch "master" is `+head+`, expected `+otherHash, res.Error)
+		assert.Equal(t, head, gitRepoHeadCommit(t, fs).Hash.String(), "nothing is pushed")
+	})
+
+	t.Run("If-Match matches", func(t *testing.T) {
+		head := gitRepoHeadCommit(t, fs).Hash.String()
+		rec := patch(`"`+head+`"`, "", "quux")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("If-Match with ETag of previous patch", func(t *testing.T) {
+		rec := patch("*", "", "garply")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		etag := rec.Header().Get("ETag")
+		assert.Equal(t, `"`+gitRepoHeadCommit(t, fs).Hash.String()+`"`, etag)
+
+		rec = patch(etag, "", "waldo")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = patch(etag, "", "fred")
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+	})
+
+	t.Run("If-Match with ETag of patch without changes", func(t *testing.T) {
+		head := gitRepoHeadCommit(t, fs).Hash.String()
+		rec := patch(`"`+head+`"`, "", "waldo")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		etag := rec.Header().Get("ETag")
+		assert.Equal(t, `"`+head+`"`, etag)
+
+		rec = patch(etag, "", "plugh")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.NotEqual(t, head, gitRepoHeadCommit(t, fs).Hash.String())
+	})
+
+	t.Run("If-Match does not match", func(t *testing.T) {
+		rec := patch(otherHash, "", "corge")
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+	})
+
+	t.Run("If-Match wildcard", func(t *testing.T) {
+		rec := patch("*", "", "grault")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	tests := []struct {
+		name          string
+		ifMatch       string
+		expectedHead  string
+		expectedError string
+	}{
+		{
+			name:          "If-Match differs from expectedHead",
+			ifMatch:       otherHash,
+			expectedHead:  strings.Repeat("a", 40),
+			expectedError: `If-Match \"` + otherHash + `\" does not match 'expectedHead'`,
+		},
+		{
+			name:          "multiple entity tags",
+			ifMatch:       `"` + otherHash + `", "` + strings.Repeat("a", 40) + `"`,
+			expectedError: "If-Match must contain a single commit hash",
+		},
+		{
+			name:          "abbreviated hash",
+			expectedHead:  otherHash[:7],
+			expectedError: "'expectedHead' must be a full commit hash",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := patch(tc.ifMatch, tc.expectedHead, "garply")
+			require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tc.expectedError)
+		})
+	}
+
+	t.Run("batch entry", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch", strings.NewReader(`{"patches": [
+			{"repo": "e2e-test", "expectedHead": "`+otherHash+`", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "waldo"}}]}
+		]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []struct {
+				Status int `json:"status"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, http.StatusPreconditionFailed, res.Results[0].Status)
+	})
+}
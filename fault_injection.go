@@ -0,0 +1,100 @@
+package vignet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Fault is a failure mode that can be injected for testing.
+type Fault string
+
+const (
+	// FaultDelay delays the handling of a request.
+	FaultDelay Fault = "delay"
+	// FaultPushRejection rejects the push to the repository.
+	FaultPushRejection Fault = "push-rejection"
+	// FaultAuthenticationOutage fails authentication with an internal error (e.g. JWKS not available).
+	FaultAuthenticationOutage Fault = "authentication-outage"
+)
+
+// FaultInjectionHeader can be set on a request to inject faults deterministically (comma separated list of faults).
+// It is only honored if fault injection is configured.
+const FaultInjectionHeader = "X-Vignet-Inject-Fault"
+
+// errInjectedFault is returned for injected faults.
+var errInjectedFault = errors.New("injected fault")
+
+// faultInjector injects faults according to the configured rates or faults requested via header.
+type faultInjector struct {
+	config FaultInjectionConfig
+	rand   func() float64
+}
+
+func newFaultInjector(config FaultInjectionConfig) *faultInjector {
+	return &faultInjector{
+		config: config,
+		rand:   rand.Float64,
+	}
+}
+
+// middleware parses requested faults from the header and applies the delay fault.
+func (f *faultInjector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if header := r.Header.Get(FaultInjectionHeader); header != "" {
+			requested := make(map[Fault]bool)
+			for _, fault := range strings.Split(header, ",") {
+				requested[Fault(strings.TrimSpace(fault))] = true
+			}
+			ctx = ctxWithRequestedFaults(ctx, requested)
+		}
+
+		if f.config.Delay > 0 && f.shouldInject(ctx, FaultDelay, f.config.DelayRate) {
+			log.WithField("delay", f.config.Delay).Warn("Injecting delay fault")
+			select {
+			case <-time.After(f.config.Delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (f *faultInjector) shouldInject(ctx context.Context, fault Fault, rate float64) bool {
+	if requestedFaultsFromCtx(ctx)[fault] {
+		return true
+	}
+	return rate > 0 && f.rand() < rate
+}
+
+// injectPushRejection returns an error if a push rejection should be injected.
+func (f *faultInjector) injectPushRejection(ctx context.Context) error {
+	if f == nil || !f.shouldInject(ctx, FaultPushRejection, f.config.PushRejectionRate) {
+		return nil
+	}
+	log.Warn("Injecting push rejection fault")
+	return fmt.Errorf("%w: push rejected", errInjectedFault)
+}
+
+// faultInjectingAuthenticationProvider wraps an authentication provider to inject outages.
+type faultInjectingAuthenticationProvider struct {
+	AuthenticationProvider
+	faults *faultInjector
+}
+
+func (p faultInjectingAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (AuthCtx, error) {
+	if p.faults.shouldInject(r.Context(), FaultAuthenticationOutage, p.faults.config.AuthenticationOutageRate) {
+		log.Warn("Injecting authentication outage fault")
+		return AuthCtx{}, fmt.Errorf("%w: authentication provider not available", errInjectedFault)
+	}
+	return p.AuthenticationProvider.AuthCtxFromRequest(r)
+}
@@ -0,0 +1,34 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestHandler_FaultInjectionHealthCheck(t *testing.T) {
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, nil, vignet.Config{
+		FaultInjection: &vignet.FaultInjectionConfig{
+			Delay:     time.Minute,
+			DelayRate: 1,
+		},
+	})
+
+	// Health checks are not delayed
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Requests to the API are delayed
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/capabilities", nil).WithContext(ctx))
+	require.Empty(t, rec.Body.String())
+}
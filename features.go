@@ -0,0 +1,190 @@
+package vignet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature is a command type or subsystem that can be enabled or disabled per deployment.
+type Feature string
+
+const (
+	FeatureSetField     Feature = "setField"
+	FeatureEnsureFields Feature = "ensureFields"
+	FeatureAddToArray   Feature = "addToArray"
+	FeatureJSONPatch    Feature = "jsonPatch"
+	FeatureCreateFile   Feature = "createFile"
+	FeatureDeleteFile   Feature = "deleteFile"
+	FeatureCopyFile     Feature = "copyFile"
+	FeatureCreateTag    Feature = "createTag"
+	FeatureSetProperty  Feature = "setProperty"
+	FeatureSetMode      Feature = "setMode"
+	// FeatureCreateFromTemplate enables the createFromTemplate command.
+	FeatureCreateFromTemplate Feature = "createFromTemplate"
+	// FeatureBumpChartVersion enables the bumpChartVersion command.
+	FeatureBumpChartVersion Feature = "bumpChartVersion"
+	// FeatureIncrementField enables the incrementField command.
+	FeatureIncrementField Feature = "incrementField"
+	// FeatureDeleteField enables the deleteField command.
+	FeatureDeleteField Feature = "deleteField"
+	// FeatureSetComment enables the setComment command.
+	FeatureSetComment    Feature = "setComment"
+	FeatureSetLabel      Feature = "setLabel"
+	FeatureSetAnnotation Feature = "setAnnotation"
+	// FeatureUpdateImageMarkers enables the updateImageMarkers command.
+	FeatureUpdateImageMarkers Feature = "updateImageMarkers"
+	// FeaturePromotions enables promotion metadata of patches and GET /promotions.
+	FeaturePromotions Feature = "promotions"
+	// FeatureMultipartUpload enables multipart request bodies to stream content of files.
+	FeatureMultipartUpload Feature = "multipartUpload"
+	// FeatureReadFile enables GET /repos/{repo}/file.
+	FeatureReadFile Feature = "readFile"
+	// FeatureHistory enables GET /repos/{repo}/history.
+	FeatureHistory Feature = "history"
+)
+
+// commandFeatures are the features of command types.
+var commandFeatures = []Feature{
+	FeatureSetField,
+	FeatureEnsureFields,
+	FeatureAddToArray,
+	FeatureJSONPatch,
+	FeatureCreateFile,
+	FeatureDeleteFile,
+	FeatureCreateTag,
+	FeatureSetProperty,
+	FeatureSetMode,
+	FeatureCreateFromTemplate,
+	FeatureBumpChartVersion,
+	FeatureIncrementField,
+	FeatureSetLabel,
+	FeatureSetAnnotation,
+	FeatureUpdateImageMarkers,
+	FeatureCopyFile,
+	FeatureDeleteField,
+	FeatureSetComment,
+}
+
+// knownFeatures are all features in the order they are advertised.
+var knownFeatures = append(append([]Feature{}, commandFeatures...),
+	FeaturePromotions,
+	FeatureMultipartUpload,
+	FeatureReadFile,
+	FeatureHistory,
+)
+
+// FeaturesConfig enables or disables features, features that are not set are enabled.
+type FeaturesConfig map[Feature]bool
+
+func (c FeaturesConfig) Valid() error {
+	var unknown []string
+	for feature := range c {
+		if !isKnownFeature(feature) {
+			unknown = append(unknown, string(feature))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		known := make([]string, len(knownFeatures))
+		for i, feature := range knownFeatures {
+			known[i] = string(feature)
+		}
+		return fmt.Errorf("unknown features %s, supported features are %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+	}
+	return nil
+}
+
+// Enabled returns true if the feature is not disabled.
+func (c FeaturesConfig) Enabled(feature Feature) bool {
+	enabled, isSet := c[feature]
+	return !isSet || enabled
+}
+
+// EnabledFeatures returns the enabled features in a stable order.
+func (c FeaturesConfig) EnabledFeatures() []Feature {
+	var features []Feature
+	for _, feature := range knownFeatures {
+		if c.Enabled(feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// EnabledCommands returns the enabled command types in a stable order.
+func (c FeaturesConfig) EnabledCommands() []Feature {
+	var features []Feature
+	for _, feature := range commandFeatures {
+		if c.Enabled(feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+func isKnownFeature(feature Feature) bool {
+	for _, f := range knownFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// feature returns the feature of the command type.
+func (c patchRequestCommand) feature() Feature {
+	switch {
+	case c.SetField != nil:
+		return FeatureSetField
+	case c.EnsureFields != nil:
+		return FeatureEnsureFields
+	case c.AddToArray != nil:
+		return FeatureAddToArray
+	case c.JSONPatch != nil:
+		return FeatureJSONPatch
+	case c.CreateFile != nil:
+		return FeatureCreateFile
+	case c.DeleteFile != nil:
+		return FeatureDeleteFile
+	case c.CreateTag != nil:
+		return FeatureCreateTag
+	case c.SetProperty != nil:
+		return FeatureSetProperty
+	case c.SetMode != nil:
+		return FeatureSetMode
+	case c.CreateFromTemplate != nil:
+		return FeatureCreateFromTemplate
+	case c.BumpChartVersion != nil:
+		return FeatureBumpChartVersion
+	case c.IncrementField != nil:
+		return FeatureIncrementField
+	case c.DeleteField != nil:
+		return FeatureDeleteField
+	case c.SetComment != nil:
+		return FeatureSetComment
+	case c.SetLabel != nil:
+		return FeatureSetLabel
+	case c.SetAnnotation != nil:
+		return FeatureSetAnnotation
+	case c.UpdateImageMarkers != nil:
+		return FeatureUpdateImageMarkers
+	case c.CopyFile != nil:
+		return FeatureCopyFile
+	default:
+		return ""
+	}
+}
+
+// checkFeatures returns an error if the request uses a disabled feature.
+func (r patchRequest) checkFeatures(features FeaturesConfig) error {
+	for idx, cmd := range r.Commands {
+		if feature := cmd.feature(); !features.Enabled(feature) {
+			return fmt.Errorf("'commands[%d]' is not supported: command %s is disabled", idx, feature)
+		}
+	}
+	if r.Promotion != nil && !features.Enabled(FeaturePromotions) {
+		return fmt.Errorf("'promotion' is not supported: feature %s is disabled", FeaturePromotions)
+	}
+	return nil
+}
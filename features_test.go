@@ -0,0 +1,109 @@
+package vignet_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_Features(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Features: vignet.FeaturesConfig{
+			vignet.FeatureJSONPatch:       false,
+			vignet.FeaturePromotions:      false,
+			vignet.FeatureMultipartUpload: false,
+			vignet.FeatureReadFile:        false,
+			vignet.FeatureHistory:         false,
+			vignet.FeatureSetField:        true,
+		},
+	})
+
+	t.Run("disabled command", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commands": [
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}},
+			{"path": "my-group/my-project/release.yml", "jsonPatch": [{"op": "remove", "path": "/foo"}]}
+		]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "'commands[1]' is not supported: command jsonPatch is disabled")
+	})
+
+	t.Run("disabled promotion", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}],
+			"promotion": {"environment": "production", "promotedFrom": {"repo": "e2e-test", "commit": "0123456789abcdef0123456789abcdef01234567", "environment": "staging"}}
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "feature promotions is disabled")
+	})
+
+	t.Run("disabled promotions endpoint", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/promotions/e2e-test", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	})
+
+	t.Run("disabled read file endpoint", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	})
+
+	t.Run("disabled history endpoint", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/history", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	})
+
+	t.Run("disabled multipart upload", func(t *testing.T) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		require.NoError(t, mw.WriteField("request", `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`))
+		require.NoError(t, mw.Close())
+
+		req := httptest.NewRequest("POST", "/patch/e2e-test", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnsupportedMediaType, rec.Code, rec.Body.String())
+	})
+
+	t.Run("enabled command", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commit": {"message": "Set foo"}, "commands": [
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}
+		]}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	assertGitRepoHeadCommit(t, fs, "Set foo")
+}
+
+func TestFeaturesConfig_Valid(t *testing.T) {
+	require.NoError(t, vignet.FeaturesConfig{vignet.FeatureJSONPatch: false}.Valid())
+
+	err := vignet.FeaturesConfig{"asyncJobs": true}.Valid()
+	require.ErrorContains(t, err, "unknown features asyncJobs")
+}
@@ -0,0 +1,31 @@
+package vignet_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+// startMockHttpGitServer initializes a Git repository with the given files and serves it via HTTP until the test ends.
+func startMockHttpGitServer(t *testing.T, initialFiles map[string]string, opts gitserver.Options) (billy.Filesystem, *httptest.Server) {
+	t.Helper()
+
+	fs := memfs.New()
+	initGitRepo(t, fs, initialFiles)
+	gitSrv := httptest.NewServer(gitserver.New(fs, opts))
+	t.Cleanup(gitSrv.Close)
+
+	return fs, gitSrv
+}
+
+func initGitRepo(t *testing.T, fs billy.Filesystem, initialFiles map[string]string) {
+	t.Helper()
+
+	err := gitserver.InitRepo(fs, initialFiles)
+	require.NoError(t, err)
+}
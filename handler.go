@@ -1,29 +1,42 @@
 package vignet
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport"
-	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/memory"
-	"github.com/networkteam/apexlogutils/httplog"
 
 	"github.com/networkteam/vignet/httputil"
+	"github.com/networkteam/vignet/properties"
+	"github.com/networkteam/vignet/semver"
+	"github.com/networkteam/vignet/sops"
+	"github.com/networkteam/vignet/toml"
 	"github.com/networkteam/vignet/yaml"
 )
 
@@ -32,6 +45,22 @@ type Handler struct {
 
 	authorizer Authorizer
 	config     Config
+
+	notifiers  []Notifier
+	auditSinks []AuditSink
+	faults     *faultInjector
+	locker     Locker
+	exchangers map[string]CredentialExchanger
+	// defaultSigner signs commits with commit.signingKey, signers with the signing keys of repositories by key
+	defaultSigner *commitSigner
+	signers       map[string]*commitSigner
+	// discovered are the repositories discovered by the server (optional)
+	discovered *discoveredRepositories
+
+	trustedProxies []*net.IPNet
+
+	identityLimiter   *rateLimiter
+	repositoryLimiter *rateLimiter
 }
 
 var _ http.Handler = &Handler{}
@@ -44,18 +73,65 @@ func NewHandler(
 	h := &Handler{
 		authorizer: authorizer,
 		config:     config,
+		locker:     NewMemoryLocker(),
+		exchangers: make(map[string]CredentialExchanger),
+		signers:    make(map[string]*commitSigner),
+	}
+
+	for repoName, repoConfig := range config.Repositories {
+		if repoConfig.Exchange != nil {
+			h.exchangers[repoName] = repoConfig.Exchange.buildExchanger()
+		}
+		// Signing keys are only read and decrypted once, not for every commit
+		if repoConfig.SigningKey != nil {
+			h.signers[repoName] = repoConfig.SigningKey.loadSigner()
+		}
+	}
+	if config.Commit.SigningKey != nil {
+		h.defaultSigner = config.Commit.SigningKey.loadSigner()
+	}
+
+	if config.RateLimits.PerIdentity != nil {
+		h.identityLimiter = newRateLimiter(*config.RateLimits.PerIdentity)
+	}
+	if config.RateLimits.PerRepository != nil {
+		h.repositoryLimiter = newRateLimiter(*config.RateLimits.PerRepository)
+	}
+
+	trustedProxies, err := parseCIDRs(config.HTTP.TrustedProxies)
+	if err != nil {
+		// The config should have been validated before, so we do not fail here
+		log.WithError(err).Error("Invalid trusted proxies, ignoring")
+	} else {
+		h.trustedProxies = trustedProxies
 	}
 
 	r := chi.NewRouter()
 
 	r.Use(
-		httpLogger,
+		accessLogger(config.HTTP.AccessLog),
+		h.requestTimeout,
 	)
 
-	r.Group(func(r chi.Router) {
-		r.Use(AuthenticateRequest(authenticationProvider))
+	if config.FaultInjection != nil {
+		log.Warn("Fault injection is enabled, do not use this in production")
+		h.faults = newFaultInjector(*config.FaultInjection)
+		authenticationProvider = faultInjectingAuthenticationProvider{
+			AuthenticationProvider: authenticationProvider,
+			faults:                 h.faults,
+		}
+	}
 
-		r.Post("/patch/{repo}", h.patch)
+	for _, v := range APIVersions {
+		r.Route(v.pathPrefix(), func(r chi.Router) {
+			r.Use(versionedAPI(v))
+			h.apiRoutes(r, authenticationProvider)
+		})
+	}
+	// Deprecated: paths without a version prefix are kept as aliases of the versioned paths
+	r.Group(func(r chi.Router) {
+		r.Use(unversionedAPI)
+		h.apiRoutes(r, authenticationProvider)
 	})
 
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +143,62 @@ func NewHandler(
 	return h
 }
 
+// apiRoutes registers the endpoints of the API.
+// Faults are only injected into the API, so health checks are not affected.
+func (h *Handler) apiRoutes(r chi.Router, authenticationProvider AuthenticationProvider) {
+	if h.faults != nil {
+		r.Use(h.faults.middleware)
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(AuthenticateRequest(authenticationProvider))
+		r.Use(h.rateLimitIdentity)
+
+		r.Post("/patch", h.batchPatch)
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.rateLimitRepository)
+
+			r.Post("/patch/{repo}", h.patch)
+			r.Post("/patch/{repo}/preview", h.preview)
+			if h.config.Features.Enabled(FeatureReadFile) {
+				r.Get("/repos/{repo}/file", h.readFile)
+			}
+			if h.config.Features.Enabled(FeatureHistory) {
+				r.Get("/repos/{repo}/history", h.history)
+			}
+			if h.config.Features.Enabled(FeaturePromotions) {
+				r.Get("/promotions/{repo}", h.promotions)
+			}
+		})
+	})
+
+	r.Get("/capabilities", h.capabilities)
+	r.Get("/openapi.json", h.openAPI)
+}
+
+// repoParam returns the repository name of the "repo" URL parameter. Names with slashes (e.g. of repositories matching a
+// template) are sent with escaped slashes, so they are unescaped.
+func repoParam(r *http.Request) string {
+	repoName := chi.URLParam(r, "repo")
+	if unescaped, err := url.PathUnescape(repoName); err == nil {
+		return unescaped
+	}
+	return repoName
+}
+
+// SetLocker sets the locker to serialize operations on a repository, a MemoryLocker is used by default.
+// It must be called before the handler serves requests.
+func (h *Handler) SetLocker(l Locker) {
+	h.locker = l
+}
+
+// SetCredentialExchanger sets the exchanger for credentials of a repository instead of the configured exchange.
+// It must be called before the handler serves requests.
+func (h *Handler) SetCredentialExchanger(repoName string, e CredentialExchanger) {
+	h.exchangers[repoName] = e
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
@@ -74,6 +206,41 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type patchRequest struct {
 	Commit   patchRequestCommit    `json:"commit"`
 	Commands []patchRequestCommand `json:"commands"`
+	// DryRun applies the commands and returns the resulting diff without committing and pushing.
+	DryRun bool `json:"dryRun"`
+	// PushOptions are sent with the push in addition to the push options of the repository (e.g. "ci.skip").
+	PushOptions []string `json:"pushOptions"`
+	// PullRequest options are given, if the commit should be pushed to a new branch and a pull request should be created.
+	PullRequest *patchRequestPullRequest `json:"pullRequest"`
+	// MergeRequest is an alternative to PullRequest using GitLab terms, the response will contain a merge request.
+	MergeRequest *patchRequestPullRequest `json:"mergeRequest"`
+	// Promotion records the commit as a promotion of another commit with trailers in the commit message.
+	Promotion *patchRequestPromotion `json:"promotion"`
+	// Atomic is true by default, so the request fails if a command fails. If set to false, failing commands are
+	// skipped and the changes of the other commands are committed (best-effort).
+	Atomic *bool `json:"atomic"`
+	// ExpectedHead is the commit hash the branch must point to, otherwise the request fails with status 412 (optional).
+	// It can also be given by an If-Match header.
+	ExpectedHead string `json:"expectedHead"`
+}
+
+// atomic returns true if a failing command should fail the request.
+func (r patchRequest) atomic() bool {
+	return r.Atomic == nil || *r.Atomic
+}
+
+// patchRequestPullRequest are the options for a pull request or GitLab merge request.
+type patchRequestPullRequest struct {
+	// Title of the pull request, defaults to the first line of the commit message.
+	Title string `json:"title"`
+	// Description of the pull request.
+	Description string `json:"description"`
+	// SourceBranch to push the commit to, defaults to "vignet/<commit hash>".
+	SourceBranch string `json:"sourceBranch"`
+	// TargetBranch of the pull request, defaults to the default branch of the repository.
+	TargetBranch string `json:"targetBranch"`
+	// RemoveSourceBranch removes the source branch when the merge request is merged, if set to true (GitLab only).
+	RemoveSourceBranch bool `json:"removeSourceBranch"`
 }
 
 type patchRequestCommit struct {
@@ -96,10 +263,123 @@ func (c patchRequestCommit) Validate() error {
 	return nil
 }
 
+// paths returns the paths of all commands that change a file.
+func (r patchRequest) paths() []string {
+	paths := make([]string, 0, len(r.Commands))
+	for _, cmd := range r.Commands {
+		if cmd.Path != "" {
+			paths = append(paths, cmd.Path)
+		}
+	}
+	return paths
+}
+
+// hasFileCommands returns true if any command changes a file.
+func (r patchRequest) hasFileCommands() bool {
+	for _, cmd := range r.Commands {
+		if cmd.CreateTag == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// redacted returns a copy of the request without the plaintext values of SOPS encrypted fields. It must be used
+// wherever the request leaves the handler: in logs, audit records and the authorizer input.
+func (r patchRequest) redacted() patchRequest {
+	commands := make([]patchRequestCommand, len(r.Commands))
+	for i, cmd := range r.Commands {
+		if cmd.SetField != nil && cmd.SetField.SOPS {
+			setField := *cmd.SetField
+			setField.Value = nil
+			setField.ExpectedValue = nil
+			if setField.Fields != nil {
+				setField.Fields = make(map[string]any, len(cmd.SetField.Fields))
+				for field := range cmd.SetField.Fields {
+					setField.Fields[field] = nil
+				}
+			}
+			cmd.SetField = &setField
+		}
+		commands[i] = cmd
+	}
+	r.Commands = commands
+	return r
+}
+
+// auditJSON returns the request for an audit record, values of SOPS encrypted fields are redacted.
+func (r patchRequest) auditJSON() json.RawMessage {
+	b, err := json.Marshal(r.redacted())
+	if err != nil {
+		// The request was decoded from JSON, so this should not happen
+		log.WithError(err).Error("Failed to encode patch request for audit record")
+		return nil
+	}
+	return b
+}
+
+// changeRequest returns the options for a pull or merge request, or nil if the commit should be pushed to the current branch.
+func (r patchRequest) changeRequest() *patchRequestPullRequest {
+	if r.PullRequest != nil {
+		return r.PullRequest
+	}
+	return r.MergeRequest
+}
+
+// commitETag returns the entity tag of a commit for the ETag header, it can be sent back as If-Match header.
+func commitETag(commit string) string {
+	return `"` + commit + `"`
+}
+
+// setExpectedHeadFromIfMatch sets the expected HEAD from an If-Match header with a commit hash as entity tag.
+// The wildcard "*" matches any HEAD.
+func (r *patchRequest) setExpectedHeadFromIfMatch(ifMatch string) error {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+	if strings.Contains(ifMatch, ",") {
+		return fmt.Errorf("If-Match must contain a single commit hash")
+	}
+	if strings.HasPrefix(ifMatch, "W/") {
+		return fmt.Errorf("If-Match must not contain a weak entity tag")
+	}
+	expectedHead := strings.Trim(ifMatch, `"`)
+	if r.ExpectedHead != "" && !strings.EqualFold(r.ExpectedHead, expectedHead) {
+		return fmt.Errorf("If-Match %q does not match 'expectedHead' %q", expectedHead, r.ExpectedHead)
+	}
+	r.ExpectedHead = expectedHead
+	return nil
+}
+
+// checkContentSize returns an error if the content of a createFile command is larger than the maximum file size.
+func (r patchRequest) checkContentSize(maxSize int64) error {
+	for idx, cmd := range r.Commands {
+		if cmd.CreateFile == nil {
+			continue
+		}
+		if size := cmd.CreateFile.contentSize(); size > maxSize {
+			return fmt.Errorf("'commands[%d].createFile' content exceeds the limit of %d bytes (%d bytes)", idx, maxSize, size)
+		}
+	}
+	return nil
+}
+
 func (r patchRequest) Validate() error {
 	if err := r.Commit.Validate(); err != nil {
 		return fmt.Errorf("invalid 'commit': %w", err)
 	}
+	if r.PullRequest != nil && r.MergeRequest != nil {
+		return fmt.Errorf("only one of 'pullRequest' or 'mergeRequest' can be given")
+	}
+	if r.ExpectedHead != "" && !plumbing.IsHash(r.ExpectedHead) {
+		return fmt.Errorf("'expectedHead' must be a full commit hash")
+	}
+	for idx, opt := range r.PushOptions {
+		if err := validatePushOption(opt); err != nil {
+			return fmt.Errorf("'pushOptions[%d]' is invalid: %w", idx, err)
+		}
+	}
 	if len(r.Commands) == 0 {
 		return fmt.Errorf("no 'commands' given")
 	}
@@ -107,6 +387,20 @@ func (r patchRequest) Validate() error {
 		if err := cmd.Validate(); err != nil {
 			return fmt.Errorf("'commands[%d]' is invalid: %w", idx, err)
 		}
+		if r.Atomic != nil && *r.Atomic && cmd.ContinueOnError {
+			return fmt.Errorf("'commands[%d]' is invalid: 'continueOnError' cannot be used for an atomic request", idx)
+		}
+	}
+	if r.changeRequest() != nil && !r.hasFileCommands() {
+		return fmt.Errorf("a pull or merge request needs at least one command that changes a file")
+	}
+	if r.Promotion != nil {
+		if err := r.Promotion.Validate(); err != nil {
+			return fmt.Errorf("invalid 'promotion': %w", err)
+		}
+		if !r.hasFileCommands() {
+			return fmt.Errorf("a promotion needs at least one command that changes a file")
+		}
 	}
 	return nil
 }
@@ -135,12 +429,59 @@ type patchRequestCommand struct {
 	CreateFile *createFilePatchRequestCommand `json:"createFile"`
 	// DeleteFile options are given, if the command should delete a file
 	DeleteFile *deleteFilePatchRequestCommand `json:"deleteFile"`
+	// CreateTag options are given, if the command should create a tag (path must not be set)
+	CreateTag *createTagPatchRequestCommand `json:"createTag"`
+	// EnsureFields options are given, if the command should converge fields of a file to the given values
+	EnsureFields *ensureFieldsPatchRequestCommand `json:"ensureFields"`
+	// AddToArray options are given, if the command should add a value to a sequence
+	AddToArray *addToArrayPatchRequestCommand `json:"addToArray"`
+	// JSONPatch is given, if the command should apply a JSON Patch (RFC 6902) document
+	JSONPatch jsonPatchPatchRequestCommand `json:"jsonPatch"`
+	// SetProperty options are given, if the command should set or remove a key of a .env or .properties file
+	SetProperty *setPropertyPatchRequestCommand `json:"setProperty"`
+	// SetMode options are given, if the command should change the mode of a file
+	SetMode *setModePatchRequestCommand `json:"setMode"`
+	// CreateFromTemplate options are given, if the command should create a file by rendering a template
+	CreateFromTemplate *createFromTemplatePatchRequestCommand `json:"createFromTemplate"`
+	// BumpChartVersion options are given, if the command should increment the version of a Helm chart
+	BumpChartVersion *bumpChartVersionPatchRequestCommand `json:"bumpChartVersion"`
+	// IncrementField options are given, if the command should increment a number or semantic version
+	IncrementField *incrementFieldPatchRequestCommand `json:"incrementField"`
+	// DeleteField options are given, if the command should remove a key or an item of a sequence
+	DeleteField *deleteFieldPatchRequestCommand `json:"deleteField"`
+	// SetComment options are given, if the command should set comments of a field
+	SetComment *setCommentPatchRequestCommand `json:"setComment"`
+	// SetLabel options are given, if the command should set labels of Kubernetes manifests
+	SetLabel *setMetadataPatchRequestCommand `json:"setLabel"`
+	// SetAnnotation options are given, if the command should set annotations of Kubernetes manifests
+	SetAnnotation *setMetadataPatchRequestCommand `json:"setAnnotation"`
+	// UpdateImageMarkers options are given, if the command should update values marked with a Flux image policy
+	// in a file or all YAML files of a directory
+	UpdateImageMarkers *updateImageMarkersPatchRequestCommand `json:"updateImageMarkers"`
+	// CopyFile options are given, if the command should copy a file or directory of the repository to the path
+	CopyFile *copyFilePatchRequestCommand `json:"copyFile"`
+
+	// Optional skips the command if the file at the path does not exist
+	Optional bool `json:"optional"`
+	// ContinueOnError skips the command if it fails because of the request (e.g. a missing field),
+	// instead of failing the request
+	ContinueOnError bool `json:"continueOnError"`
 }
 
 func (c patchRequestCommand) Validate() error {
-	if c.Path == "" {
+	if c.CreateTag == nil && c.Path == "" {
 		return fmt.Errorf("'path' must be set")
 	}
+	if c.Path != "" {
+		if err := validRepositoryPath(c.Path); err != nil {
+			return fmt.Errorf("'path' %w", err)
+		}
+	}
+	for _, p := range c.readPaths() {
+		if err := validRepositoryPath(p); err != nil {
+			return fmt.Errorf("read path %w", err)
+		}
+	}
 
 	var commandsSet []string
 	if c.SetField != nil {
@@ -152,6 +493,51 @@ func (c patchRequestCommand) Validate() error {
 	if c.DeleteFile != nil {
 		commandsSet = append(commandsSet, "'deleteFile'")
 	}
+	if c.CreateTag != nil {
+		commandsSet = append(commandsSet, "'createTag'")
+	}
+	if c.EnsureFields != nil {
+		commandsSet = append(commandsSet, "'ensureFields'")
+	}
+	if c.AddToArray != nil {
+		commandsSet = append(commandsSet, "'addToArray'")
+	}
+	if c.JSONPatch != nil {
+		commandsSet = append(commandsSet, "'jsonPatch'")
+	}
+	if c.SetProperty != nil {
+		commandsSet = append(commandsSet, "'setProperty'")
+	}
+	if c.SetMode != nil {
+		commandsSet = append(commandsSet, "'setMode'")
+	}
+	if c.CreateFromTemplate != nil {
+		commandsSet = append(commandsSet, "'createFromTemplate'")
+	}
+	if c.BumpChartVersion != nil {
+		commandsSet = append(commandsSet, "'bumpChartVersion'")
+	}
+	if c.IncrementField != nil {
+		commandsSet = append(commandsSet, "'incrementField'")
+	}
+	if c.DeleteField != nil {
+		commandsSet = append(commandsSet, "'deleteField'")
+	}
+	if c.SetComment != nil {
+		commandsSet = append(commandsSet, "'setComment'")
+	}
+	if c.SetLabel != nil {
+		commandsSet = append(commandsSet, "'setLabel'")
+	}
+	if c.SetAnnotation != nil {
+		commandsSet = append(commandsSet, "'setAnnotation'")
+	}
+	if c.UpdateImageMarkers != nil {
+		commandsSet = append(commandsSet, "'updateImageMarkers'")
+	}
+	if c.CopyFile != nil {
+		commandsSet = append(commandsSet, "'copyFile'")
+	}
 	if len(commandsSet) == 0 {
 		return errors.New("no command is set")
 	}
@@ -169,6 +555,87 @@ func (c patchRequestCommand) Validate() error {
 			return fmt.Errorf("invalid 'createFile' command: %w", err)
 		}
 	}
+	if c.EnsureFields != nil {
+		if err := c.EnsureFields.Validate(); err != nil {
+			return fmt.Errorf("invalid 'ensureFields' command: %w", err)
+		}
+	}
+	if c.AddToArray != nil {
+		if err := c.AddToArray.Validate(); err != nil {
+			return fmt.Errorf("invalid 'addToArray' command: %w", err)
+		}
+	}
+	if c.JSONPatch != nil {
+		if err := c.JSONPatch.Validate(); err != nil {
+			return fmt.Errorf("invalid 'jsonPatch' command: %w", err)
+		}
+	}
+	if c.SetProperty != nil {
+		if err := c.SetProperty.Validate(); err != nil {
+			return fmt.Errorf("invalid 'setProperty' command: %w", err)
+		}
+	}
+	if c.SetMode != nil {
+		if err := c.SetMode.Validate(); err != nil {
+			return fmt.Errorf("invalid 'setMode' command: %w", err)
+		}
+	}
+	if c.CreateFromTemplate != nil {
+		if err := c.CreateFromTemplate.Validate(); err != nil {
+			return fmt.Errorf("invalid 'createFromTemplate' command: %w", err)
+		}
+	}
+	if c.BumpChartVersion != nil {
+		if err := c.BumpChartVersion.Validate(); err != nil {
+			return fmt.Errorf("invalid 'bumpChartVersion' command: %w", err)
+		}
+	}
+	if c.IncrementField != nil {
+		if err := c.IncrementField.Validate(); err != nil {
+			return fmt.Errorf("invalid 'incrementField' command: %w", err)
+		}
+	}
+	if c.DeleteField != nil {
+		if err := c.DeleteField.Validate(); err != nil {
+			return fmt.Errorf("invalid 'deleteField' command: %w", err)
+		}
+	}
+	if c.SetComment != nil {
+		if err := c.SetComment.Validate(); err != nil {
+			return fmt.Errorf("invalid 'setComment' command: %w", err)
+		}
+	}
+	if c.SetLabel != nil {
+		if err := c.SetLabel.Validate(); err != nil {
+			return fmt.Errorf("invalid 'setLabel' command: %w", err)
+		}
+	}
+	if c.SetAnnotation != nil {
+		if err := c.SetAnnotation.Validate(); err != nil {
+			return fmt.Errorf("invalid 'setAnnotation' command: %w", err)
+		}
+	}
+	if c.UpdateImageMarkers != nil {
+		if err := c.UpdateImageMarkers.Validate(); err != nil {
+			return fmt.Errorf("invalid 'updateImageMarkers' command: %w", err)
+		}
+	}
+	if c.CopyFile != nil {
+		if err := c.CopyFile.Validate(); err != nil {
+			return fmt.Errorf("invalid 'copyFile' command: %w", err)
+		}
+	}
+	if c.CreateTag != nil {
+		if c.Path != "" {
+			return fmt.Errorf("'path' must not be set for 'createTag' command")
+		}
+		if err := c.CreateTag.Validate(); err != nil {
+			return fmt.Errorf("invalid 'createTag' command: %w", err)
+		}
+	}
+	if c.Optional && (c.CreateTag != nil || c.CreateFile != nil || c.CreateFromTemplate != nil || c.CopyFile != nil) {
+		return fmt.Errorf("'optional' cannot be used for %s command", commandsSet[0])
+	}
 
 	return nil
 }
@@ -178,389 +645,2763 @@ type setFieldPatchRequestCommand struct {
 	Field string `json:"field"`
 	// Value to set.
 	Value any `json:"value"`
+	// Fields maps field paths to values to set several fields at once, instead of Field and Value.
+	Fields map[string]any `json:"fields"`
 	// Create missing keys for field if they don't exist, if set to true.
 	// Note that Field must be a simple dot separated path in this case - JSONPath is not supported.
 	Create bool `json:"create"`
+	// SOPS decrypts the SOPS encrypted file before setting the field and encrypts the new value, if set to true.
+	// The repository must be configured with a SOPS key.
+	SOPS bool `json:"sops"`
+	// ExpectedValue is the current value the field must have, otherwise the field is not set (optional).
+	// It protects against concurrent requests overwriting a newer value. It cannot be combined with SOPS.
+	ExpectedValue any `json:"expectedValue"`
+	// ValueFrom copies the value from a field of the same or another file, instead of Value.
+	ValueFrom *setFieldValueFrom `json:"valueFrom"`
+	// ExpandAliases sets a field of a YAML file that is shared by an anchor and aliases by expanding them to copies,
+	// so only the field is changed. Setting a shared field fails otherwise.
+	ExpandAliases bool `json:"expandAliases"`
+	// Style of the value in a YAML file (plain, single, double, literal or folded) instead of the style chosen
+	// by the encoder (optional). Only strings can be quoted or written as block scalars.
+	Style yaml.ScalarStyle `json:"style"`
+	// AllowMultiple sets every field matching the path (e.g. "spec.containers[*].image") in a YAML or JSON file,
+	// instead of failing if multiple fields match. Keys are not created, at least one field must match.
+	AllowMultiple bool `json:"allowMultiple"`
+}
+
+type setFieldValueFrom struct {
+	// Path of the file to read the value from (optional, defaults to the path of the command).
+	Path string `json:"path"`
+	// Field path of the value (in YAMLPath syntax, dot path syntax for TOML files).
+	Field string `json:"field"`
+}
+
+// read reads the scalar value of the field, the file defaults to the file of the command.
+func (v setFieldValueFrom) read(fs billy.Filesystem, defaultPath string) (any, error) {
+	filename := v.Path
+	if filename == "" {
+		filename = defaultPath
+	}
+	if !isYAMLFile(filename) && !isJSONFile(filename) && !isTOMLFile(filename) {
+		return nil, clientError{fmt.Errorf("unsupported file type: %q, values can only be read from YAML, JSON or TOML files", filename), http.StatusUnprocessableEntity}
+	}
+
+	f, err := fs.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, clientError{fmt.Errorf("file %q does not exist", filename), http.StatusUnprocessableEntity}
+		}
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	var value any
+	if isTOMLFile(filename) {
+		patcher, err := toml.NewPatcher(f)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("invalid TOML in %q: %w", filename, err), http.StatusUnprocessableEntity}
+		}
+		value, err = patcher.Field(v.Field)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("reading field %q of %q: %w", v.Field, filename, err), http.StatusUnprocessableEntity}
+		}
+	} else {
+		patcher, err := yaml.NewPatcher(f)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("invalid YAML in %q: %w", filename, err), http.StatusUnprocessableEntity}
+		}
+		value, err = patcher.Field(v.Field)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("reading field %q of %q: %w", v.Field, filename, err), http.StatusUnprocessableEntity}
+		}
+	}
+
+	switch value.(type) {
+	case nil:
+		return nil, clientError{fmt.Errorf("field %q of %q does not exist", v.Field, filename), http.StatusUnprocessableEntity}
+	case map[string]any, []any:
+		return nil, clientError{fmt.Errorf("field %q of %q is not a scalar value", v.Field, filename), http.StatusUnprocessableEntity}
+	}
+	return value, nil
 }
 
-var yamlPathPattern = regexp.MustCompile(`^([\w-]+\.)*[\w-]+$`)
+// yamlPathPattern matches dot separated keys, keys containing dots can be escaped ("app\.kubernetes\.io/name")
+// or given in brackets ("['app.kubernetes.io/name']"), items of sequences are given by index ("containers[0]").
+var yamlPathPattern = regexp.MustCompile(`^(?:(?:[\w\-/]|\\\.)+|\['[^']+'\]|\["[^"]+"\])(?:\.(?:[\w\-/]|\\\.)+|\.?\['[^']+'\]|\.?\["[^"]+"\]|\[\d+\])*$`)
+
+// isFieldPath returns true if the field is a path of dot separated keys or a JSON Pointer, so missing keys can be created.
+func isFieldPath(field string) bool {
+	if yaml.IsJSONPointer(field) {
+		return yaml.ValidateJSONPointer(field) == nil
+	}
+	return yamlPathPattern.MatchString(field)
+}
 
 func (c setFieldPatchRequestCommand) Validate() error {
+	if c.AllowMultiple {
+		if c.Create {
+			return fmt.Errorf("'allowMultiple' cannot be combined with 'create'")
+		}
+		if c.SOPS {
+			return fmt.Errorf("'allowMultiple' cannot be combined with 'sops'")
+		}
+		if c.Style != "" {
+			return fmt.Errorf("'allowMultiple' cannot be combined with 'style'")
+		}
+	}
+	if c.SOPS && c.ExpectedValue != nil {
+		// The current value would have to be decrypted and must not be disclosed in a conflict
+		return fmt.Errorf("'expectedValue' cannot be combined with 'sops'")
+	}
+	if c.Style != "" {
+		if err := c.Style.Valid(); err != nil {
+			return fmt.Errorf("invalid 'style': %w", err)
+		}
+		if c.SOPS {
+			return fmt.Errorf("'style' cannot be combined with 'sops'")
+		}
+		for _, a := range c.assignments() {
+			if _, ok := a.Value.(string); c.Style != yaml.PlainStyle && a.Value != nil && !ok {
+				return fmt.Errorf("'style' %s is only supported for string values", c.Style)
+			}
+		}
+	}
+	if c.Fields != nil {
+		if c.Field != "" || c.Value != nil {
+			return fmt.Errorf("'fields' cannot be combined with 'field' and 'value'")
+		}
+		if c.ExpectedValue != nil {
+			return fmt.Errorf("'expectedValue' cannot be combined with 'fields'")
+		}
+		if c.ValueFrom != nil {
+			return fmt.Errorf("'valueFrom' cannot be combined with 'fields'")
+		}
+		if len(c.Fields) == 0 {
+			return fmt.Errorf("'fields' must not be empty")
+		}
+		for field := range c.Fields {
+			if field == "" {
+				return fmt.Errorf("field must not be empty")
+			}
+			if c.Create && !isFieldPath(field) {
+				return fmt.Errorf("field %q must be a valid path of dot separated YAML keys", field)
+			}
+		}
+		return nil
+	}
+
 	if c.Field == "" {
 		return fmt.Errorf("field must not be empty")
 	}
 	// Validate Field is a dot separated path if create is set
-	if c.Create && !yamlPathPattern.MatchString(c.Field) {
+	if c.Create && !isFieldPath(c.Field) {
 		return fmt.Errorf("field must be a valid path of dot separated YAML keys")
 	}
+	if c.ValueFrom != nil {
+		if c.Value != nil {
+			return fmt.Errorf("only one of 'value' or 'valueFrom' can be given")
+		}
+		if c.ValueFrom.Field == "" {
+			return fmt.Errorf("'valueFrom.field' must not be empty")
+		}
+	}
 
 	return nil
 }
 
-type createFilePatchRequestCommand struct {
-	// Content of the file to set
-	Content string `json:"content"`
-}
-
-func (c createFilePatchRequestCommand) Validate() error {
-	return nil
-}
-
-type deleteFilePatchRequestCommand struct {
+type fieldAssignment struct {
+	Field string
+	Value any
 }
 
-func (c deleteFilePatchRequestCommand) Validate() error {
-	return nil
+// unchanged returns true if every field already has the value to set (every match with allowMultiple), so the file
+// does not need to be written. The number of matched fields is returned as well.
+func (c setFieldPatchRequestCommand) unchanged(patcher *yaml.Patcher) (bool, int) {
+	matches := 0
+	for _, a := range c.assignments() {
+		values, err := patcher.GetField(a.Field)
+		if err != nil || len(values) == 0 || (len(values) > 1 && !c.AllowMultiple) {
+			return false, 0
+		}
+		valueJSON, err := json.Marshal(a.Value)
+		if err != nil {
+			return false, 0
+		}
+		for _, current := range values {
+			currentJSON, err := json.Marshal(current)
+			if err != nil || !bytes.Equal(currentJSON, valueJSON) {
+				return false, 0
+			}
+		}
+		matches += len(values)
+	}
+	return true, matches
 }
 
-func (h *Handler) patch(w http.ResponseWriter, r *http.Request) {
-	// Decode patch request from body
-	var req patchRequest
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&req); err != nil {
-		log.WithError(err).Warn("Invalid JSON in request body")
-		respondError(w, r, "Invalid JSON in body", clientError{err, http.StatusBadRequest})
-		return
+// checkExpectedValue returns a conflict error if the current value of the field is not the expected value.
+// Values are compared by their JSON encoding, so numbers of different types are equal.
+func (c setFieldPatchRequestCommand) checkExpectedValue(current any) error {
+	if c.ExpectedValue == nil {
+		return nil
 	}
-
-	err := req.Validate()
+	currentJSON, err := json.Marshal(current)
 	if err != nil {
-		log.WithField("patchRequest", req).WithError(err).Warn("Invalid patch request")
-		respondError(w, r, "Validation of request failed", clientError{err, http.StatusBadRequest})
-		return
+		return fmt.Errorf("encoding current value: %w", err)
+	}
+	expectedJSON, err := json.Marshal(c.ExpectedValue)
+	if err != nil {
+		return fmt.Errorf("encoding expected value: %w", err)
+	}
+	if bytes.Equal(currentJSON, expectedJSON) {
+		return nil
 	}
+	return clientError{fmt.Errorf("field %q has value %s, expected %s", c.Field, currentJSON, expectedJSON), http.StatusConflict}
+}
 
-	ctx := r.Context()
-	authCtx := authCtxFromCtx(ctx)
+// assignments returns the fields to set, multiple fields are sorted by path for a deterministic result.
+func (c setFieldPatchRequestCommand) assignments() []fieldAssignment {
+	if c.Fields == nil {
+		return []fieldAssignment{{Field: c.Field, Value: c.Value}}
+	}
+	assignments := make([]fieldAssignment, 0, len(c.Fields))
+	for field, value := range c.Fields {
+		assignments = append(assignments, fieldAssignment{Field: field, Value: value})
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].Field < assignments[j].Field
+	})
+	return assignments
+}
 
-	log.
-		WithField("gitLabClaims", authCtx.GitLabClaims).
-		Debug("Authorizing request")
+type ensureFieldsPatchRequestCommand struct {
+	// Fields maps dot separated field paths to values, missing keys are created.
+	Fields map[string]any `json:"fields"`
+	// Prune removes keys under the dot separated path that are not given in Fields, if set.
+	Prune string `json:"prune"`
+}
 
-	repoName := chi.URLParam(r, "repo")
-	var repoConfig RepositoryConfig
-	if c, exists := h.config.Repositories[repoName]; !exists {
-		log.WithField("repo", repoName).Warn("Unknown repository")
-		respondError(w, r, "Unknown repository", clientError{fmt.Errorf("repository %q not configured", repoName), http.StatusNotFound})
-		return
-	} else {
-		repoConfig = c
+func (c ensureFieldsPatchRequestCommand) Validate() error {
+	if len(c.Fields) == 0 {
+		return fmt.Errorf("'fields' must not be empty")
 	}
-
-	if err := h.authorizer.AllowPatch(ctx, authCtx, repoName, req); err != nil {
-		if v, ok := err.(ViolationsResolver); ok {
-			var msg strings.Builder
-			for _, violation := range v.Violations() {
-				msg.WriteString("- ")
-				msg.WriteString(violation)
-				msg.WriteString("\n")
+	for field, value := range c.Fields {
+		if !yamlPathPattern.MatchString(field) {
+			return fmt.Errorf("field %q must be a valid path of dot separated YAML keys", field)
+		}
+		switch value.(type) {
+		case nil, bool, float64, string:
+		default:
+			return fmt.Errorf("value of field %q must be a scalar", field)
+		}
+		for other := range c.Fields {
+			if strings.HasPrefix(other, field+".") {
+				return fmt.Errorf("field %q conflicts with field %q", field, other)
 			}
-
-			log.
-				WithField("repo", repoName).
-				WithError(err).
-				Warn("Failed to authorize patch request")
-			respondError(w, r, "Authorization failed", clientError{errors.New(msg.String()), http.StatusForbidden})
-			return
 		}
-
-		log.
-			WithField("repo", repoName).
-			WithError(err).
-			Error("Unexpected error authorizing patch request")
-		respondError(w, r, "Authorization error", nil)
-		return
 	}
-
-	log.
-		WithField("authCtx", authCtx.GitLabClaims).
-		Debugf("Will patch %s with %+v", repoName, req)
-
-	// TODO Extract handling of command to separate type
-	err = h.gitClonePatchCommitPush(ctx, repoName, repoConfig, req)
-	if err != nil {
-		var clientErr clientError
-		if errors.As(err, &clientErr) {
-			log.
-				WithField("repo", repoName).
-				WithError(err).
-				Warn("Failed to apply patch command to repository")
-		} else {
-			log.
-				WithField("repo", repoName).
-				WithError(err).
-				Error("Failed to apply patch command to repository")
-		}
-		respondError(w, r, "Patch failed", err)
-		return
+	if c.Prune != "" && !yamlPathPattern.MatchString(c.Prune) {
+		return fmt.Errorf("'prune' must be a valid path of dot separated YAML keys")
 	}
-
-	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
-type errorResponse struct {
-	Cause string `json:"cause"`
-	Error string `json:"error,omitempty"`
-	Code  string `json:"code,omitempty"`
+type addToArrayPatchRequestCommand struct {
+	// Field path of the sequence (in YAMLPath syntax).
+	Field string `json:"field"`
+	// Value to add, it can be a scalar, an object or an array.
+	Value any `json:"value"`
+	// Index to insert the value at, the value is appended if not set.
+	Index *int `json:"index"`
 }
 
-func respondError(w http.ResponseWriter, r *http.Request, cause string, err error) {
-	var clientErr clientError
-	statusCode := http.StatusInternalServerError
-	errorMsg := "" // Only output detailed error message if we have a client error (which should be safe to expose)
-	if errors.As(err, &clientErr) {
-		statusCode = clientErr.status
-		if clientErr.error != nil {
-			errorMsg = clientErr.error.Error()
-		}
+func (c addToArrayPatchRequestCommand) Validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
 	}
-
-	var code string
-	var codedError codedError
-	if errors.As(err, &codedError) {
-		code = codedError.code
+	if c.Value == nil {
+		return fmt.Errorf("'value' must be set")
 	}
-
-	// Negotiate response format
-	contentType := httputil.NegotiateContentType(r, []string{"text/plain", "application/json"}, "text/plain")
-	switch contentType {
-	case "application/json":
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(statusCode)
-		_ = json.NewEncoder(w).Encode(errorResponse{
-			Cause: cause,
-			Error: errorMsg,
-			Code:  code,
-		})
-	default:
-		if code != "" {
-			w.Header().Set("X-Error-Code", code)
-		}
-		if errorMsg != "" {
-			http.Error(w, fmt.Sprintf("%s:\n\n%v", cause, errorMsg), statusCode)
-		} else {
-			http.Error(w, cause, statusCode)
-		}
+	if c.Index != nil && *c.Index < 0 {
+		return fmt.Errorf("'index' must not be negative")
 	}
+	return nil
 }
 
-func (h *Handler) gitClonePatchCommitPush(ctx context.Context, repoName string, repoConfig RepositoryConfig, req patchRequest) error {
-	storer := memory.NewStorage()
-	fs := memfs.New()
+type incrementFieldPatchRequestCommand struct {
+	// Field path of the value (in YAMLPath syntax).
+	Field string `json:"field"`
+	// By is the amount to add to an integer (optional, defaults to 1).
+	By *int `json:"by"`
+	// Bump is the part of a semantic version to increment, one of "major", "minor" or "patch", instead of By.
+	Bump semver.Part `json:"bump"`
+}
 
-	var authMethod transport.AuthMethod
-	if repoConfig.BasicAuth != nil {
-		authMethod = &gitHttp.BasicAuth{
-			Username: repoConfig.BasicAuth.Username,
-			Password: repoConfig.BasicAuth.Password,
-		}
+func (c incrementFieldPatchRequestCommand) Validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
 	}
-	r, err := git.Clone(storer, fs, &git.CloneOptions{
-		URL:  repoConfig.URL,
-		Auth: authMethod,
-	})
-	if err != nil {
-		return fmt.Errorf("cloning repository: %w", err)
+	if c.By != nil && c.Bump != "" {
+		return fmt.Errorf("only one of 'by' or 'bump' can be given")
 	}
-	log.
-		WithField("repoName", repoName).
-		WithField("repoUrl", repoConfig.URL).
-		Info("Cloned repository")
-
-	w, err := r.Worktree()
-	if err != nil {
-		return fmt.Errorf("getting worktree for repository: %w", err)
+	if c.Bump != "" {
+		if err := c.Bump.Valid(); err != nil {
+			return fmt.Errorf("invalid 'bump': %w", err)
+		}
 	}
+	return nil
+}
 
-	for _, cmd := range req.Commands {
-		err := h.applyPatchCommand(ctx, fs, cmd)
-		if err != nil {
-			return fmt.Errorf("applying patch command to %q: %w", cmd.Path, err)
+// increment returns the incremented value, the value must be an integer or a semantic version if Bump is set.
+func (c incrementFieldPatchRequestCommand) increment(value any) (any, error) {
+	if c.Bump != "" {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected semantic version, got %v", value)
 		}
-
-		err = w.AddWithOptions(&git.AddOptions{Path: cmd.Path})
+		version, err := semver.Parse(s)
 		if err != nil {
-			return fmt.Errorf("adding file to worktree: %w", err)
+			return nil, err
 		}
+		return version.Bump(c.Bump).String(), nil
 	}
 
-	commitMessage, commitOptions := h.buildCommitMsgAndOptions(ctx, req)
-	commitHash, err := w.Commit(commitMessage, commitOptions)
-	if err != nil {
-		return fmt.Errorf("creating commit: %w", err)
+	by := 1
+	if c.By != nil {
+		by = *c.By
 	}
-
-	err = r.Push(&git.PushOptions{
-		RemoteName: "origin",
-		Auth:       authMethod,
-	})
-	if err != nil {
-		return fmt.Errorf("pushing to repository: %w", err)
+	switch v := value.(type) {
+	case int:
+		return v + by, nil
+	case nil:
+		return nil, errors.New("no nodes matched path")
+	default:
+		return nil, fmt.Errorf("expected integer, got %v (use 'bump' for semantic versions)", value)
 	}
+}
 
-	log.
-		WithField("repoName", repoName).
-		WithField("repoUrl", repoConfig.URL).
-		WithField("commitHash", commitHash).
-		Info("Pushed commit to repository")
+type deleteFieldPatchRequestCommand struct {
+	// Field path of the key or sequence item to remove (in YAMLPath syntax).
+	Field string `json:"field"`
+	// ExpandAliases deletes a field of a YAML file that is shared by an anchor and aliases by expanding them to copies,
+	// so the aliases are not changed. Deleting a shared field fails otherwise.
+	ExpandAliases bool `json:"expandAliases"`
+}
 
+func (c deleteFieldPatchRequestCommand) Validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
+	}
 	return nil
 }
 
-func (h *Handler) buildCommitMsgAndOptions(ctx context.Context, req patchRequest) (string, *git.CommitOptions) {
-	commitMessage := h.config.Commit.DefaultMessage
-	if req.Commit.Message != "" {
-		commitMessage = req.Commit.Message
+type setCommentPatchRequestCommand struct {
+	// Field path of the commented field (in YAMLPath syntax).
+	Field string `json:"field"`
+	// LineComment to set at the end of the line of the field, an empty string removes it (optional).
+	LineComment *string `json:"lineComment"`
+	// HeadComment to set on the lines above the field, an empty string removes it (optional).
+	HeadComment *string `json:"headComment"`
+}
+
+func (c setCommentPatchRequestCommand) Validate() error {
+	if c.Field == "" {
+		return fmt.Errorf("field must not be empty")
 	}
-	var (
-		commitAuthor    *object.Signature
-		commitCommitter *object.Signature
-	)
-	if req.Commit.Author != nil {
-		commitAuthor = &object.Signature{
-			Name:  req.Commit.Author.Name,
-			Email: req.Commit.Author.Email,
-			When:  time.Now(),
-		}
-	} else {
-		commitAuthor = &object.Signature{
-			Name:  h.config.Commit.DefaultAuthor.Name,
-			Email: h.config.Commit.DefaultAuthor.Email,
-			When:  time.Now(),
-		}
+	if c.LineComment == nil && c.HeadComment == nil {
+		return fmt.Errorf("one of 'lineComment' or 'headComment' must be given")
 	}
-	if req.Commit.Committer != nil {
-		commitCommitter = &object.Signature{
-			Name:  req.Commit.Committer.Name,
-			Email: req.Commit.Committer.Email,
-			When:  time.Now(),
+	if c.LineComment != nil && strings.Contains(*c.LineComment, "\n") {
+		return fmt.Errorf("'lineComment' must be a single line")
+	}
+	return nil
+}
+
+// setMetadataPatchRequestCommand sets labels or annotations in the metadata of Kubernetes manifests.
+type setMetadataPatchRequestCommand struct {
+	// Key of the label or annotation.
+	Key string `json:"key"`
+	// Value to set.
+	Value *string `json:"value"`
+	// Values maps keys to values to set several at once, instead of Key and Value.
+	Values map[string]string `json:"values"`
+	// Kind of the manifests to change (optional, defaults to all manifests of the file).
+	Kind string `json:"kind"`
+	// Name of the manifests to change (optional, defaults to all manifests of the file).
+	Name string `json:"name"`
+}
+
+func (c setMetadataPatchRequestCommand) Validate() error {
+	if c.Values != nil {
+		if c.Key != "" || c.Value != nil {
+			return fmt.Errorf("'values' cannot be combined with 'key' and 'value'")
 		}
-	} else {
-		authCtx := authCtxFromCtx(ctx)
-		if authCtx.GitLabClaims != nil {
-			commitCommitter = &object.Signature{
-				Name:  authCtx.GitLabClaims.UserLogin,
-				Email: authCtx.GitLabClaims.UserEmail,
-				When:  time.Now(),
+		if len(c.Values) == 0 {
+			return fmt.Errorf("'values' must not be empty")
+		}
+		for key := range c.Values {
+			if key == "" {
+				return fmt.Errorf("key must not be empty")
 			}
 		}
+		return nil
 	}
-
-	commitOptions := &git.CommitOptions{
-		Author:    commitAuthor,
-		Committer: commitCommitter,
+	if c.Key == "" {
+		return fmt.Errorf("'key' must not be empty")
 	}
-	return commitMessage, commitOptions
+	if c.Value == nil {
+		return fmt.Errorf("'value' must be set")
+	}
+	return nil
 }
 
-type clientError struct {
-	error  error
-	status int
+type metadataEntry struct {
+	Key   string
+	Value string
 }
 
-func (e clientError) Error() string {
-	if e.error == nil {
-		return ""
+// entries returns the keys and values to set, multiple values are sorted by key for a deterministic result.
+func (c setMetadataPatchRequestCommand) entries() []metadataEntry {
+	if c.Values == nil {
+		return []metadataEntry{{Key: c.Key, Value: *c.Value}}
 	}
-	return e.error.Error()
+	entries := make([]metadataEntry, 0, len(c.Values))
+	for key, value := range c.Values {
+		entries = append(entries, metadataEntry{Key: key, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
 }
 
-func (e clientError) Unwrap() error {
-	return e.error
+// matches returns true if the document is a manifest (it has a kind) matching the kind and name of the command.
+func (c setMetadataPatchRequestCommand) matches(patcher *yaml.Patcher) bool {
+	kind, _ := patcher.Field("kind")
+	if kind == nil || c.Kind != "" && kind != c.Kind {
+		return false
+	}
+	if c.Name != "" {
+		name, _ := patcher.Field("metadata.name")
+		return name == c.Name
+	}
+	return true
 }
 
-type codedError struct {
-	error error
-	code  string
+type updateImageMarkersPatchRequestCommand struct {
+	// Policy is the image policy of markers to update ("namespace:name").
+	Policy string `json:"policy"`
+	// Image is the new image name without tag.
+	Image string `json:"image"`
+	// Tag is the new tag of the image.
+	Tag string `json:"tag"`
 }
 
-func (e codedError) Error() string {
-	if e.error == nil {
-		return e.code
+var imagePolicyPattern = regexp.MustCompile(`^[^:\s]+:[^:\s]+$`)
+
+func (c updateImageMarkersPatchRequestCommand) Validate() error {
+	if !imagePolicyPattern.MatchString(c.Policy) {
+		return fmt.Errorf("'policy' must be an image policy reference in the form namespace:name")
 	}
-	return fmt.Sprintf("%s (%s)", e.error.Error(), e.code)
+	if c.Image == "" {
+		return fmt.Errorf("'image' must not be empty")
+	}
+	if c.Tag == "" {
+		return fmt.Errorf("'tag' must not be empty")
+	}
+	return nil
 }
 
-func (e codedError) Unwrap() error {
-	return e.error
+type setPropertyPatchRequestCommand struct {
+	// Key to set or remove.
+	Key string `json:"key"`
+	// Value to set, the key is added if it doesn't exist.
+	Value *string `json:"value"`
+	// Remove all lines of the key instead of setting a value, if set to true.
+	Remove bool `json:"remove"`
 }
 
-func (h *Handler) applyPatchCommand(ctx context.Context, fs billy.Filesystem, cmd patchRequestCommand) error {
-	// If file is not a YAML file, we return an error (for now)
-	if !strings.HasSuffix(cmd.Path, ".yaml") && !strings.HasSuffix(cmd.Path, ".yml") {
-		return clientError{fmt.Errorf("unsupported file type: %q, only YAML is supported for now", cmd.Path), http.StatusUnprocessableEntity}
+func (c setPropertyPatchRequestCommand) Validate() error {
+	if c.Key == "" {
+		return fmt.Errorf("'key' must not be empty")
+	}
+	if c.Remove && c.Value != nil {
+		return fmt.Errorf("'value' cannot be combined with 'remove'")
+	}
+	if !c.Remove && c.Value == nil {
+		return fmt.Errorf("'value' must be set")
 	}
+	return nil
+}
 
-	switch {
-	case cmd.CreateFile != nil:
-		f, err := fs.OpenFile(cmd.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-		if err != nil {
-			// Check "file already exists" error
-			if os.IsExist(err) {
-				return clientError{errors.New("file already exists"), http.StatusUnprocessableEntity}
+// jsonPatchPatchRequestCommand is a JSON Patch (RFC 6902) document.
+type jsonPatchPatchRequestCommand []jsonPatchOperation
+
+type jsonPatchOperation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" or "test".
+	Op string `json:"op"`
+	// Path is a JSON Pointer to the target location.
+	Path string `json:"path"`
+	// From is a JSON Pointer to the source location for "move" and "copy".
+	From string `json:"from,omitempty"`
+	// Value for "add", "replace" and "test".
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (c jsonPatchPatchRequestCommand) Validate() error {
+	if len(c) == 0 {
+		return fmt.Errorf("operations must not be empty")
+	}
+	for idx, op := range c {
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return fmt.Errorf("'value' must be set for operation %d (%s)", idx, op.Op)
 			}
-			return fmt.Errorf("creating file: %w", err)
+		case "move", "copy":
+			if err := yaml.ValidateJSONPointer(op.From); err != nil {
+				return fmt.Errorf("invalid 'from' of operation %d (%s): %w", idx, op.Op, err)
+			}
+		case "remove":
+		default:
+			return fmt.Errorf("unknown 'op' %q of operation %d", op.Op, idx)
 		}
-		defer f.Close()
+		if err := yaml.ValidateJSONPointer(op.Path); err != nil {
+			return fmt.Errorf("invalid 'path' of operation %d (%s): %w", idx, op.Op, err)
+		}
+	}
+	return nil
+}
 
-		_, err = f.Write([]byte(cmd.CreateFile.Content))
-		if err != nil {
-			return fmt.Errorf("writing content: %w", err)
+func (c jsonPatchPatchRequestCommand) operations() ([]yaml.JSONPatchOperation, error) {
+	ops := make([]yaml.JSONPatchOperation, len(c))
+	for i, op := range c {
+		ops[i] = yaml.JSONPatchOperation{
+			Op:   op.Op,
+			Path: op.Path,
+			From: op.From,
 		}
-	case cmd.SetField != nil:
-		f, err := fs.OpenFile(cmd.Path, os.O_RDWR, 0644)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &ops[i].Value); err != nil {
+				return nil, fmt.Errorf("decoding value of operation %d: %w", i, err)
 			}
-			return fmt.Errorf("opening file read-write: %w", err)
 		}
-		defer f.Close()
+	}
+	return ops, nil
+}
 
-		patcher, err := yaml.NewPatcher(f)
-		if err != nil {
-			return fmt.Errorf("reading YAML: %w", err)
-		}
+type createFilePatchRequestCommand struct {
+	// Content of the file to set
+	Content string `json:"content"`
+	// Encoding of the content, "base64" for binary content (optional, defaults to plain text).
+	Encoding contentEncoding `json:"encoding"`
+	// Mode of the file, e.g. "0755" for an executable file (optional, defaults to "0644").
+	Mode fileMode `json:"mode"`
+	// ContentFrom is the name of a file part of a multipart request to stream the content from.
+	ContentFrom string `json:"contentFrom"`
+
+	contentFile *multipart.FileHeader
+}
 
-		err = patcher.SetField(cmd.SetField.Field, cmd.SetField.Value, cmd.SetField.Create)
-		if err != nil {
-			return clientError{fmt.Errorf("setting field %q: %w", cmd.SetField.Field, err), http.StatusUnprocessableEntity}
-		}
+// contentSize returns the size of the file content in bytes.
+func (c createFilePatchRequestCommand) contentSize() int64 {
+	switch {
+	case c.contentFile != nil:
+		return c.contentFile.Size
+	case c.Encoding == contentEncodingBase64:
+		return int64(base64.StdEncoding.DecodedLen(len(c.Content)))
+	default:
+		return int64(len(c.Content))
+	}
+}
 
-		err = f.Truncate(0)
-		if err != nil {
-			return fmt.Errorf("truncating file: %w", err)
-		}
+type contentEncoding string
 
-		_, err = f.Seek(0, io.SeekStart)
-		if err != nil {
-			return fmt.Errorf("seeking to start of file: %w", err)
+const (
+	contentEncodingBase64 contentEncoding = "base64"
+)
+
+func (c createFilePatchRequestCommand) Validate() error {
+	if c.ContentFrom != "" {
+		if c.Content != "" {
+			return fmt.Errorf("only one of 'content' or 'contentFrom' can be given")
+		}
+		if c.Encoding != "" {
+			return fmt.Errorf("'encoding' cannot be combined with 'contentFrom'")
+		}
+		if c.contentFile == nil {
+			return fmt.Errorf("'contentFrom' must reference a file part of a multipart request, part %q not found", c.ContentFrom)
+		}
+	}
+	switch c.Encoding {
+	case "":
+	case contentEncodingBase64:
+		if _, err := base64.StdEncoding.DecodeString(c.Content); err != nil {
+			return fmt.Errorf("'content' is not valid base64: %w", err)
 		}
+	default:
+		return fmt.Errorf("unsupported 'encoding' %q", c.Encoding)
+	}
+	if c.Mode != "" {
+		if _, err := c.Mode.perm(); err != nil {
+			return fmt.Errorf("invalid 'mode': %w", err)
+		}
+	}
+	return nil
+}
 
-		err = patcher.Encode(f)
-		if err != nil {
-			return fmt.Errorf("writing YAML: %w", err)
+// fileMode is an octal file mode, Git only distinguishes regular and executable files.
+type fileMode string
+
+func (m fileMode) perm() (os.FileMode, error) {
+	switch strings.TrimPrefix(string(m), "0") {
+	case "644":
+		return 0644, nil
+	case "755":
+		return 0755, nil
+	}
+	return 0, fmt.Errorf("unsupported mode %q, only 0644 and 0755 are supported", m)
+}
+
+type setModePatchRequestCommand struct {
+	// Mode of the file, "0755" for an executable file or "0644" for a regular file.
+	Mode fileMode `json:"mode"`
+}
+
+func (c setModePatchRequestCommand) Validate() error {
+	if c.Mode == "" {
+		return fmt.Errorf("'mode' must be set")
+	}
+	if _, err := c.Mode.perm(); err != nil {
+		return fmt.Errorf("invalid 'mode': %w", err)
+	}
+	return nil
+}
+
+type createFromTemplatePatchRequestCommand struct {
+	// Template in text/template syntax to render.
+	Template string `json:"template"`
+	// TemplatePath is the path of a template in the repository, instead of Template.
+	TemplatePath string `json:"templatePath"`
+	// Values are the data of the template.
+	Values map[string]any `json:"values"`
+	// Mode of the file, e.g. "0755" for an executable file (optional, defaults to "0644").
+	Mode fileMode `json:"mode"`
+}
+
+func (c createFromTemplatePatchRequestCommand) Validate() error {
+	if c.Template == "" && c.TemplatePath == "" {
+		return fmt.Errorf("one of 'template' or 'templatePath' must be set")
+	}
+	if c.Template != "" && c.TemplatePath != "" {
+		return fmt.Errorf("only one of 'template' or 'templatePath' can be given")
+	}
+	if c.Template != "" {
+		if _, err := parseTemplate("template", c.Template); err != nil {
+			return fmt.Errorf("invalid 'template': %w", err)
 		}
-	case cmd.DeleteFile != nil:
-		err := fs.Remove(cmd.Path)
+	}
+	if c.Mode != "" {
+		if _, err := c.Mode.perm(); err != nil {
+			return fmt.Errorf("invalid 'mode': %w", err)
+		}
+	}
+	return nil
+}
+
+// render renders the template with the values, a template path is read from fs.
+// The output is limited to maxSize bytes, since it is rendered into memory.
+func (c createFromTemplatePatchRequestCommand) render(fs billy.Filesystem, maxSize int64) ([]byte, error) {
+	name, text := "template", c.Template
+	if c.TemplatePath != "" {
+		data, err := util.ReadFile(fs, c.TemplatePath)
 		if err != nil {
 			if os.IsNotExist(err) {
-				return clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+				return nil, clientError{fmt.Errorf("template %q does not exist", c.TemplatePath), http.StatusUnprocessableEntity}
 			}
-			return err
+			return nil, fmt.Errorf("reading template: %w", err)
 		}
-	default:
-		return clientError{fmt.Errorf("unknown command type"), http.StatusBadRequest}
+		name, text = c.TemplatePath, string(data)
 	}
 
-	log.
-		WithField("path", cmd.Path).
-		Info("Patched YAML")
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return nil, clientError{fmt.Errorf("parsing template: %w", err), http.StatusUnprocessableEntity}
+	}
+	buf := &limitedBuffer{maxSize: maxSize}
+	err = tmpl.Execute(buf, c.Values)
+	if errors.Is(err, errLimitExceeded) {
+		return nil, clientError{fmt.Errorf("rendered template exceeds the limit of %d bytes", maxSize), http.StatusRequestEntityTooLarge}
+	}
+	if err != nil {
+		return nil, clientError{fmt.Errorf("rendering template: %w", err), http.StatusUnprocessableEntity}
+	}
+	return buf.Bytes(), nil
+}
+
+var errLimitExceeded = errors.New("limit exceeded")
+
+// limitedBuffer is a buffer that fails with errLimitExceeded instead of growing beyond maxSize bytes.
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	maxSize int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.maxSize {
+		return 0, errLimitExceeded
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// parseTemplate parses a template, missing values are an error instead of rendering "<no value>".
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+type bumpChartVersionPatchRequestCommand struct {
+	// Bump is the part of the version to increment, one of "major", "minor" or "patch" (optional, defaults to "patch").
+	Bump semver.Part `json:"bump"`
+	// AppVersion of the chart to set (optional).
+	AppVersion string `json:"appVersion"`
+}
 
+func (c bumpChartVersionPatchRequestCommand) Validate() error {
+	if c.Bump != "" {
+		if err := c.Bump.Valid(); err != nil {
+			return fmt.Errorf("invalid 'bump': %w", err)
+		}
+	}
 	return nil
 }
 
-func httpLogger(h http.Handler) http.Handler {
-	return httplog.New(h, httplog.ExcludePathPrefix("/healthz"))
+func (c bumpChartVersionPatchRequestCommand) part() semver.Part {
+	if c.Bump == "" {
+		return semver.Patch
+	}
+	return c.Bump
+}
+
+// isChartFile returns true if the file is the Chart.yaml of a Helm chart.
+func isChartFile(filename string) bool {
+	return path.Base(filename) == "Chart.yaml"
+}
+
+// chartVersion reads the semantic version of a chart.
+func chartVersion(patcher *yaml.Patcher) (semver.Version, error) {
+	value, err := patcher.Field("version")
+	if err != nil {
+		return semver.Version{}, err
+	}
+	if value == nil {
+		return semver.Version{}, errors.New("chart has no version")
+	}
+	s, ok := value.(string)
+	if !ok {
+		return semver.Version{}, fmt.Errorf("expected version of chart to be a string, got %v", value)
+	}
+	return semver.Parse(s)
+}
+
+type copyFilePatchRequestCommand struct {
+	// From is the path of the file or directory to copy.
+	From string `json:"from"`
+	// Overwrite existing files at the path, if set to true.
+	Overwrite bool `json:"overwrite"`
+}
+
+func (c copyFilePatchRequestCommand) Validate() error {
+	if c.From == "" {
+		return fmt.Errorf("'from' must not be empty")
+	}
+	return nil
+}
+
+type deleteFilePatchRequestCommand struct {
+}
+
+func (c deleteFilePatchRequestCommand) Validate() error {
+	return nil
+}
+
+type createTagPatchRequestCommand struct {
+	// Name of the tag (e.g. "v1.2.3").
+	Name string `json:"name"`
+	// Message of the tag, it is required for an annotated tag.
+	Message string `json:"message"`
+	// Annotated creates an annotated tag instead of a lightweight tag, if set to true.
+	Annotated bool `json:"annotated"`
+	// Target revision of the tag (e.g. a branch, tag or commit hash), defaults to the commit created by the request.
+	Target string `json:"target"`
+}
+
+var tagNamePattern = regexp.MustCompile(`^[\w.-]+(/[\w.-]+)*$`)
+
+func (c createTagPatchRequestCommand) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("'name' must not be empty")
+	}
+	if !tagNamePattern.MatchString(c.Name) ||
+		strings.Contains(c.Name, "..") ||
+		strings.HasPrefix(c.Name, ".") ||
+		strings.Contains(c.Name, "/.") ||
+		strings.HasSuffix(c.Name, ".lock") {
+		return fmt.Errorf("'name' is not a valid tag name")
+	}
+	if c.Annotated && c.Message == "" {
+		return fmt.Errorf("'message' must be set for an annotated tag")
+	}
+	if !c.Annotated && c.Message != "" {
+		return fmt.Errorf("'message' is only supported for an annotated tag")
+	}
+	return nil
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request) {
+	h.servePatch(w, r, ActionPatch)
+}
+
+// preview applies a patch request like a dry run and responds with the diff and the results of the commands.
+// It is authorized by its own action, so a policy can allow previews of changes that cannot be pushed.
+func (h *Handler) preview(w http.ResponseWriter, r *http.Request) {
+	h.servePatch(w, r, ActionPreview)
+}
+
+// servePatch decodes and applies a patch request, action is the action that authorizes the request.
+func (h *Handler) servePatch(w http.ResponseWriter, r *http.Request, action Action) {
+	// Decode patch request from body
+	var req patchRequest
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.Limits.maxBodySize())
+	if isMultipartRequest(r) {
+		if !h.config.Features.Enabled(FeatureMultipartUpload) {
+			respondError(w, r, "Unsupported media type", clientError{fmt.Errorf("multipart bodies are not supported: feature %s is disabled", FeatureMultipartUpload), http.StatusUnsupportedMediaType})
+			return
+		}
+		form, err := h.decodeMultipartPatchRequest(r, &req)
+		if err != nil {
+			log.WithError(err).Warn("Invalid multipart request body")
+			respondError(w, r, "Invalid multipart body", bodyError(err))
+			return
+		}
+		defer func() {
+			_ = form.RemoveAll()
+		}()
+	} else {
+		if err := h.newBodyDecoder(r.Body).Decode(&req); err != nil {
+			log.WithError(err).Warn("Invalid JSON in request body")
+			respondError(w, r, "Invalid JSON in body", bodyError(err))
+			return
+		}
+	}
+	if dryRun := r.URL.Query().Get("dryRun"); dryRun != "" {
+		v, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("invalid 'dryRun': %w", err), http.StatusBadRequest})
+			return
+		}
+		req.DryRun = req.DryRun || v
+	}
+	// A preview never commits
+	if action == ActionPreview {
+		req.DryRun = true
+	}
+	if err := req.setExpectedHeadFromIfMatch(r.Header.Get("If-Match")); err != nil {
+		respondError(w, r, "Invalid header", clientError{err, http.StatusBadRequest})
+		return
+	}
+
+	res, cause, err := h.applyPatch(r, repoParam(r), req, action)
+	if err != nil {
+		respondError(w, r, cause, err)
+		return
+	}
+
+	if res.ConsistencyToken != "" {
+		w.Header().Set(ConsistencyTokenHeader, res.ConsistencyToken)
+	}
+	// Also a patch without changes returns the HEAD of the branch, so conditional patches can be chained
+	if res.head != "" {
+		w.Header().Set("ETag", commitETag(res.head))
+	}
+	respondNegotiated(w, r, http.StatusOK, res)
+}
+
+// applyPatch validates, authorizes and applies a patch request to a repository and records the outcome in the audit log.
+// The request is authorized by the action (ActionPatch or ActionPreview).
+// If it fails, the cause for the response is returned with the error.
+func (h *Handler) applyPatch(r *http.Request, repoName string, req patchRequest, action Action) (*patchResponse, string, error) {
+	err := req.Validate()
+	if err != nil {
+		log.WithField("patchRequest", req.redacted()).WithError(err).Warn("Invalid patch request")
+		return nil, "Validation of request failed", clientError{err, http.StatusBadRequest}
+	}
+	if err := req.checkFeatures(h.config.Features); err != nil {
+		log.WithError(err).Warn("Unsupported patch request")
+		return nil, "Unsupported request", clientError{err, http.StatusUnprocessableEntity}
+	}
+	if maxCommands := h.config.Limits.maxCommands(); len(req.Commands) > maxCommands {
+		err := fmt.Errorf("'commands' exceeds the limit of %d commands", maxCommands)
+		log.WithError(err).Warn("Patch request exceeds limits")
+		return nil, "Request too large", clientError{err, http.StatusRequestEntityTooLarge}
+	}
+	if err := req.checkContentSize(h.config.Limits.maxFileSize()); err != nil {
+		log.WithError(err).Warn("Patch request exceeds limits")
+		return nil, "Request too large", clientError{err, http.StatusRequestEntityTooLarge}
+	}
+
+	ctx := r.Context()
+	authCtx := authCtxFromCtx(ctx)
+
+	log.
+		WithField("gitLabClaims", authCtx.GitLabClaims).
+		Debug("Authorizing request")
+
+	auditRecord := AuditRecord{
+		Time:    time.Now(),
+		Repo:    repoName,
+		AuthCtx: authCtx,
+		Paths:   req.paths(),
+		DryRun:  req.DryRun,
+		Request: req.auditJSON(),
+	}
+	var repoConfig RepositoryConfig
+	if _, c, exists := h.lookupRepository(repoName); !exists {
+		h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, errors.New("unknown repository"))
+		log.WithField("repo", repoName).Warn("Unknown repository")
+		return nil, "Unknown repository", clientError{fmt.Errorf("repository %q not configured", repoName), http.StatusNotFound}
+	} else {
+		repoConfig = c
+	}
+	if err := repoConfig.checkAllowedPaths(repoName, req); err != nil {
+		h.auditFailed(ctx, auditRecord, AuditOutcomeDenied, err)
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Patch request targets paths that are not allowed")
+		return nil, "Path not allowed", err
+	}
+
+	requestMetadata := h.requestMetadataFromRequest(r)
+	// The patch, created tags and the branch of a pull request are authorized by the policies of their actions
+	if err := authorizeAll(ctx, h.authorizer, patchActionInputs(action, repoName, authCtx, requestMetadata, req)); err != nil {
+		if v, ok := err.(ViolationsResolver); ok {
+			h.auditFailed(ctx, auditRecord, AuditOutcomeDenied, err)
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Warn("Failed to authorize patch request")
+			return nil, "Authorization failed", violationsClientError(v)
+		}
+
+		h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Error("Unexpected error authorizing patch request")
+		return nil, "Authorization error", err
+	}
+
+	repoConfig, err = h.exchangeCredential(ctx, r, repoName, repoConfig)
+	if err != nil {
+		h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Failed to exchange credential")
+		return nil, "Credential exchange failed", err
+	}
+
+	log.
+		WithField("authCtx", authCtx.GitLabClaims).
+		Debugf("Will patch %s with %+v", repoName, req.redacted())
+
+	// Serialize operations that push to the repository, a dry run does not need a lock
+	lockCtx := ctx
+	if !req.DryRun {
+		var unlock func()
+		lockCtx, unlock, err = h.locker.Lock(ctx, repoName)
+		if err != nil {
+			h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Error("Failed to acquire repository lock")
+			return nil, "Patch failed", err
+		}
+		defer unlock()
+	}
+
+	// TODO Extract handling of command to separate type
+	// The request is authorized again with the current state of the files after cloning
+	redactedReq := req.redacted()
+	authorizeCurrent := func(current []currentState) error {
+		err := h.authorizer.Authorize(ctx, authorizationInput{
+			Action:       action,
+			Repo:         repoName,
+			AuthCtx:      authCtx,
+			Request:      requestMetadata,
+			PatchRequest: &redactedReq,
+			Current:      current,
+		})
+		if v, ok := err.(ViolationsResolver); ok {
+			return deniedError{violationsClientError(v)}
+		}
+		return err
+	}
+	res, err := h.gitClonePatchCommitPush(lockCtx, repoName, repoConfig, req, authorizeCurrent)
+	err = lockLostError(lockCtx, err)
+	if err != nil {
+		var denied deniedError
+		if errors.As(err, &denied) {
+			h.auditFailed(ctx, auditRecord, AuditOutcomeDenied, err)
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Warn("Failed to authorize patch request with current state")
+			return nil, "Authorization failed", err
+		}
+
+		h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+		var clientErr clientError
+		if errors.As(err, &clientErr) {
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Warn("Failed to apply patch command to repository")
+		} else {
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Error("Failed to apply patch command to repository")
+		}
+		return nil, "Patch failed", err
+	}
+
+	auditRecord.Outcome = AuditOutcomeSucceeded
+	auditRecord.CommitHash = res.Commit
+	h.audit(ctx, auditRecord)
+	if !req.DryRun {
+		h.notify(ctx, PatchEvent{
+			Time:       time.Now(),
+			Repo:       repoName,
+			RepoURL:    repoConfig.URL,
+			AuthCtx:    authCtx,
+			CommitHash: res.Commit,
+			Message:    h.commitMessage(repoConfig, req),
+			Paths:      auditRecord.Paths,
+		})
+	}
+
+	return res, "", nil
+}
+
+// exchangeCredential returns the repository configuration with an exchanged credential, if an exchanger is set for the repository.
+func (h *Handler) exchangeCredential(ctx context.Context, r *http.Request, repoName string, repoConfig RepositoryConfig) (RepositoryConfig, error) {
+	key, _, _ := h.lookupRepository(repoName)
+	exchanger := h.exchangers[key]
+	if exchanger == nil {
+		return repoConfig, nil
+	}
+	cred, err := exchanger.ExchangeCredential(ctx, ExchangeRequest{
+		Repo:       repoName,
+		RepoConfig: repoConfig,
+		AuthCtx:    authCtxFromCtx(ctx),
+		Header:     r.Header,
+	})
+	if err != nil {
+		return repoConfig, fmt.Errorf("exchanging credential: %w", err)
+	}
+	return repoConfig.withCredential(*cred), nil
+}
+
+// authorizeRead authorizes a read request on a repository. If it is not authorized, an error is responded and false
+// is returned.
+func (h *Handler) authorizeRead(w http.ResponseWriter, r *http.Request, repoName string, req readRequest) bool {
+	ctx := r.Context()
+	err := h.authorizer.Authorize(ctx, authorizationInput{
+		Action:      ActionRead,
+		Repo:        repoName,
+		AuthCtx:     authCtxFromCtx(ctx),
+		Request:     h.requestMetadataFromRequest(r),
+		ReadRequest: &req,
+	})
+	if err == nil {
+		return true
+	}
+
+	if v, ok := err.(ViolationsResolver); ok {
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Failed to authorize read request")
+		respondError(w, r, "Authorization failed", violationsClientError(v))
+		return false
+	}
+
+	log.
+		WithField("repo", repoName).
+		WithError(err).
+		Error("Unexpected error authorizing read request")
+	respondError(w, r, "Authorization error", nil)
+	return false
+}
+
+func (h *Handler) auditFailed(ctx context.Context, record AuditRecord, outcome AuditOutcome, err error) {
+	record.Outcome = outcome
+	record.Error = err.Error()
+	h.audit(ctx, record)
+}
+
+type patchResponse struct {
+	// Commit is the hash of the created commit, it is empty for a dry run.
+	Commit string `json:"commit,omitempty"`
+	// ConsistencyToken identifies the pushed state of the repository, it is empty for a dry run.
+	ConsistencyToken string `json:"consistencyToken,omitempty"`
+	// Branch the commit was pushed to.
+	Branch string `json:"branch"`
+	// Commands contains a result for each command in the order of the request.
+	Commands []patchCommandResponse `json:"commands"`
+	// DryRun is set if the commands were applied without committing and pushing.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Diff is the unified diff of the changes, it is only set for a dry run.
+	Diff string `json:"diff,omitempty"`
+	// PullRequest is set if a pull request was created.
+	PullRequest *pullRequestResponse `json:"pullRequest,omitempty"`
+	// MergeRequest is set if a GitLab merge request was created.
+	MergeRequest *mergeRequestResponse `json:"mergeRequest,omitempty"`
+
+	// head is the commit the branch points to after the patch, it is empty for a dry run or a pull or merge request.
+	head string
+}
+
+type pullRequestResponse struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+type mergeRequestResponse struct {
+	IID int    `json:"iid"`
+	URL string `json:"url"`
+}
+
+type patchCommandResponse struct {
+	// Applied is set if the command was applied, even if it did not change a file.
+	Applied bool `json:"applied"`
+	// ChangedFiles are the paths of files changed by the command.
+	ChangedFiles []string `json:"changedFiles"`
+	// Tag is the name of the tag created by the command.
+	Tag string `json:"tag,omitempty"`
+	// ChangedFields are the paths of fields changed by an ensureFields command.
+	ChangedFields []string `json:"changedFields,omitempty"`
+	// PrunedFields are the paths of keys removed by an ensureFields command.
+	PrunedFields []string `json:"prunedFields,omitempty"`
+	// Version is the new version set by a bumpChartVersion command.
+	Version string `json:"version,omitempty"`
+	// Value is the new value of the field set by an incrementField command.
+	Value any `json:"value,omitempty"`
+	// Field is the field changed by a command that changes a single field (e.g. setField or incrementField).
+	Field string `json:"field,omitempty"`
+	// OldValue is the value of the field before the command was applied.
+	OldValue any `json:"oldValue,omitempty"`
+	// NewValue is the value of the field after the command was applied.
+	NewValue any `json:"newValue,omitempty"`
+	// Matches is the number of fields set by a setField command with allowMultiple.
+	Matches int `json:"matches,omitempty"`
+	// Skipped is set if the command was not applied, because the file of an optional command does not exist
+	// or the command failed and errors should not fail the request.
+	Skipped bool `json:"skipped,omitempty"`
+	// Error is the error of a skipped command that failed.
+	Error string `json:"error,omitempty"`
+}
+
+// setFieldChange sets the field with the old and new value for commands that change a single field.
+func (r *patchCommandResponse) setFieldChange(cmd patchRequestCommand, changes []changelogChange) {
+	// The field of addToArray and updateImageMarkers changes is not the field that is set
+	if len(changes) != 1 || changes[0].Field == "" || cmd.AddToArray != nil || cmd.UpdateImageMarkers != nil {
+		return
+	}
+	r.Field = changes[0].Field
+	r.OldValue = changes[0].OldValue
+	r.NewValue = changes[0].NewValue
+}
+
+type errorResponse struct {
+	Cause string `json:"cause"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+	// Command is the index of the failed command.
+	Command *int `json:"command,omitempty"`
+}
+
+// bodyError returns a client error for an error reading the request body.
+// A body exceeding the maximum size is reported with the limit, so clients know how to split their requests.
+func bodyError(err error) clientError {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return clientError{fmt.Errorf("body exceeds the limit of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge}
+	}
+	return clientError{err, http.StatusBadRequest}
+}
+
+// newBodyDecoder returns a JSON decoder for a request body, unknown fields are rejected unless allowed by the config.
+func (h *Handler) newBodyDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if !h.config.HTTP.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+func respondError(w http.ResponseWriter, r *http.Request, cause string, err error) {
+	statusCode, res := newErrorResponse(cause, err)
+
+	// Negotiate response format
+	contentType := httputil.NegotiateContentType(r, []string{"text/plain", "application/json"}, "text/plain")
+	var rateLimitErr rateLimitError
+	if errors.As(err, &rateLimitErr) {
+		w.Header().Set("Retry-After", rateLimitErr.retryAfterSeconds())
+	}
+
+	switch contentType {
+	case "application/json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(res)
+	default:
+		if res.Code != "" {
+			w.Header().Set("X-Error-Code", res.Code)
+		}
+		if res.Error != "" {
+			http.Error(w, fmt.Sprintf("%s:\n\n%v", cause, res.Error), statusCode)
+		} else {
+			http.Error(w, cause, statusCode)
+		}
+	}
+}
+
+// newErrorResponse returns the status code and response for an error, details are only exposed for client errors.
+func newErrorResponse(cause string, err error) (int, errorResponse) {
+	var clientErr clientError
+	statusCode := http.StatusInternalServerError
+	errorMsg := "" // Only output detailed error message if we have a client error (which should be safe to expose)
+	if errors.As(err, &clientErr) {
+		statusCode = clientErr.status
+		if clientErr.error != nil {
+			errorMsg = clientErr.error.Error()
+		}
+	}
+
+	var code string
+	var codedError codedError
+	if errors.As(err, &codedError) {
+		code = codedError.code
+	}
+
+	var command *int
+	var cmdErr commandError
+	if errors.As(err, &cmdErr) {
+		command = &cmdErr.index
+	}
+
+	return statusCode, errorResponse{
+		Cause:   cause,
+		Error:   errorMsg,
+		Code:    code,
+		Command: command,
+	}
+}
+
+// gitClonePatchCommitPush applies the patch request to the repository. If authorizeCurrent is set, it is called with the
+// current state of the files targeted by the commands after cloning.
+func (h *Handler) gitClonePatchCommitPush(ctx context.Context, repoName string, repoConfig RepositoryConfig, req patchRequest, authorizeCurrent func(current []currentState) error) (*patchResponse, error) {
+	// Build commit options first to reject requests with unresolvable signatures before cloning
+	commitMessage, commitOptions, err := h.buildCommitMsgAndOptions(ctx, repoName, repoConfig, req)
+	if err != nil {
+		return nil, fmt.Errorf("building commit options: %w", err)
+	}
+	if req.Promotion != nil {
+		commitMessage = req.Promotion.appendTrailers(commitMessage, repoName)
+	}
+	// go-git can only sign tags with an OpenPGP key, so an annotated tag would be pushed unsigned
+	if commitOptions.Signer != nil {
+		for i, cmd := range req.Commands {
+			if cmd.CreateTag != nil && cmd.CreateTag.Annotated {
+				return nil, commandError{clientError{fmt.Errorf("annotated tag %q cannot be signed with an SSH signing key, create a lightweight tag instead", cmd.CreateTag.Name), http.StatusUnprocessableEntity}, i}
+			}
+		}
+	}
+
+	changeRequestOpts := req.changeRequest()
+	var (
+		provider                   changeRequestProvider
+		sourceBranch, targetBranch string
+	)
+	if changeRequestOpts != nil {
+		provider, err = newChangeRequestProvider(repoConfig, h.config.Timeouts.apiClient())
+		if err != nil {
+			return nil, fmt.Errorf("building provider: %w", err)
+		}
+		if provider == nil {
+			return nil, clientError{errors.New("pull or merge requests are not supported for repository, a provider must be configured"), http.StatusUnprocessableEntity}
+		}
+		sourceBranch, targetBranch = changeRequestOpts.SourceBranch, changeRequestOpts.TargetBranch
+	}
+
+	storer := memory.NewStorage()
+	fs := memfs.New()
+
+	authMethod := repoConfig.authMethod()
+	cloneOptions := &git.CloneOptions{
+		URL:  repoConfig.URL,
+		Auth: authMethod,
+	}
+	if targetBranch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(targetBranch)
+	}
+	var r *git.Repository
+	cloneStart := time.Now()
+	err = withTimeout(ctx, "clone", h.config.Timeouts.clone(), func(ctx context.Context) (err error) {
+		r, err = git.CloneContext(ctx, storer, fs, cloneOptions)
+		return err
+	})
+	accessLogEntryFromCtx(ctx).recordGitTiming("clone", time.Since(cloneStart))
+	if err != nil {
+		return nil, fmt.Errorf("cloning repository: %w", err)
+	}
+	log.
+		WithField("repoName", repoName).
+		WithField("repoUrl", repoConfig.URL).
+		Info("Cloned repository")
+
+	if err := checkExpectedHead(r, req.ExpectedHead); err != nil {
+		return nil, err
+	}
+
+	if err := verifyPromotedCommit(r, repoName, req.Promotion); err != nil {
+		return nil, err
+	}
+
+	if authorizeCurrent != nil {
+		current := currentStateForCommands(fs, req.Commands, h.config.Authorization.CurrentFileContent, h.config.Limits.maxFileSize())
+		if err := authorizeCurrent(current); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("getting worktree for repository: %w", err)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return nil, fmt.Errorf("getting HEAD of repository: %w", err)
+	}
+
+	res := &patchResponse{
+		Branch:   head.Name().Short(),
+		Commands: make([]patchCommandResponse, len(req.Commands)),
+	}
+
+	var (
+		changelogChanges []changelogChange
+		tagCommands      []*createTagPatchRequestCommand
+		filesChanged     bool
+	)
+	for i, cmd := range req.Commands {
+		if cmd.Optional {
+			_, err := fs.Stat(cmd.Path)
+			if os.IsNotExist(err) {
+				res.Commands[i] = patchCommandResponse{
+					ChangedFiles: []string{},
+					Skipped:      true,
+				}
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("checking file %q: %w", cmd.Path, err)
+			}
+		}
+
+		// Changes are read before applying the command to get the old values, files that are too large to patch are
+		// not read (the command fails)
+		var cmdChanges []changelogChange
+		if checkFileSize(fs, cmd, h.config.Limits.maxFileSize()) == nil {
+			cmdChanges = changelogChangesForCommand(fs, cmd)
+		}
+
+		if cmd.CreateTag != nil {
+			changelogChanges = append(changelogChanges, cmdChanges...)
+			// Tags are created after the commit, so they can point to it
+			tagCommands = append(tagCommands, cmd.CreateTag)
+			res.Commands[i] = patchCommandResponse{
+				Applied:      true,
+				ChangedFiles: []string{},
+				Tag:          cmd.CreateTag.Name,
+			}
+			continue
+		}
+
+		// Files below directories are only known after cloning and applying the previous commands
+		if err := repoConfig.checkDirectoryFiles(repoName, i, fs, cmd); err != nil {
+			return nil, err
+		}
+
+		cmdRes, err := h.applyPatchCommand(ctx, fs, repoConfig, cmd)
+		if err != nil {
+			// Only errors caused by the request are skipped, changes of the failed command are not added to the worktree
+			var clientErr clientError
+			if (cmd.ContinueOnError || !req.atomic()) && errors.As(err, &clientErr) {
+				log.
+					WithField("repoName", repoName).
+					WithField("path", cmd.Path).
+					WithError(err).
+					Info("Skipped failed patch command")
+				res.Commands[i] = patchCommandResponse{
+					ChangedFiles: []string{},
+					Skipped:      true,
+					Error:        clientErr.Error(),
+				}
+				continue
+			}
+			return nil, commandError{fmt.Errorf("applying patch command to %q: %w", cmd.Path, err), i}
+		}
+		changelogChanges = append(changelogChanges, cmdChanges...)
+		cmdRes.Applied = true
+		cmdRes.setFieldChange(cmd, cmdChanges)
+		res.Commands[i] = cmdRes
+		if len(cmdRes.ChangedFiles) == 0 {
+			continue
+		}
+		filesChanged = true
+
+		for _, changedFile := range cmdRes.ChangedFiles {
+			err = w.AddWithOptions(&git.AddOptions{Path: changedFile})
+			if err != nil {
+				return nil, fmt.Errorf("adding file to worktree: %w", err)
+			}
+		}
+	}
+
+	// Signatures are resolved before cloning, so the time needs to be updated
+	commitOptions.Author.When = time.Now()
+	commitOptions.Committer.When = commitOptions.Author.When
+	if req.DryRun {
+		// The commit and tags are only created locally, signing is not needed
+		commitOptions.SignKey = nil
+		commitOptions.Signer = nil
+	}
+
+	// A request that only creates tags or sets fields already in the desired state does not create a commit
+	createCommit := filesChanged
+	commitHash := head.Hash()
+
+	if createCommit && repoConfig.Changelog != nil {
+		fragmentPath, err := writeChangelogFragment(fs, *repoConfig.Changelog, changelogEntry{
+			Time:     commitOptions.Author.When,
+			Message:  commitMessage,
+			Identity: changelogIdentityFromAuthCtx(authCtxFromCtx(ctx)),
+			Changes:  changelogChanges,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("writing changelog fragment: %w", err)
+		}
+		err = w.AddWithOptions(&git.AddOptions{Path: fragmentPath})
+		if err != nil {
+			return nil, fmt.Errorf("adding changelog fragment to worktree: %w", err)
+		}
+	}
+
+	if createCommit {
+		commitHash, err = w.Commit(commitMessage, commitOptions)
+		if err != nil {
+			return nil, fmt.Errorf("creating commit: %w", err)
+		}
+	}
+
+	for _, tagCmd := range tagCommands {
+		err = createTag(r, tagCmd, commitHash, commitOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.DryRun {
+		if createCommit {
+			diff, err := diffCommits(r, head.Hash(), commitHash)
+			if err != nil {
+				return nil, fmt.Errorf("computing diff: %w", err)
+			}
+			res.Diff = diff
+		}
+		res.DryRun = true
+
+		log.
+			WithField("repoName", repoName).
+			WithField("repoUrl", repoConfig.URL).
+			Info("Skipped commit and push for dry run")
+
+		return res, nil
+	}
+
+	if !createCommit && len(tagCommands) == 0 {
+		log.
+			WithField("repoName", repoName).
+			WithField("repoUrl", repoConfig.URL).
+			Info("Skipped commit and push, repository is already in the desired state")
+
+		res.head = commitHash.String()
+		res.ConsistencyToken = consistencyToken{
+			Repo:   repoName,
+			Branch: res.Branch,
+			Commit: commitHash.String(),
+		}.sign(h.config.ConsistencyTokens.key())
+		return res, nil
+	}
+
+	err = h.faults.injectPushRejection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pushing to repository: %w", err)
+	}
+	pushOptions := &git.PushOptions{
+		RemoteName: "origin",
+		Auth:       authMethod,
+		Options:    buildPushOptions(repoConfig.PushOptions, req.PushOptions),
+	}
+	// A pull or merge request is only created for a new commit
+	openChangeRequest := changeRequestOpts != nil && createCommit
+	if openChangeRequest {
+		// Push the commit to a new branch instead of the current branch
+		if sourceBranch == "" {
+			sourceBranch = "vignet/" + commitHash.String()[:12]
+		}
+		ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(sourceBranch), commitHash)
+		err = storer.SetReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("creating branch %s: %w", sourceBranch, err)
+		}
+		pushOptions.RefSpecs = append(pushOptions.RefSpecs, refSpecFor(ref.Name()))
+		res.Branch = sourceBranch
+	} else if createCommit && len(tagCommands) > 0 {
+		// Explicit ref specs for tags replace the default ref specs, so the current branch must be added
+		pushOptions.RefSpecs = append(pushOptions.RefSpecs, refSpecFor(head.Name()))
+	}
+	for _, tagCmd := range tagCommands {
+		pushOptions.RefSpecs = append(pushOptions.RefSpecs, refSpecFor(plumbing.NewTagReferenceName(tagCmd.Name)))
+	}
+	pushStart := time.Now()
+	err = withTimeout(ctx, "push", h.config.Timeouts.push(), func(ctx context.Context) error {
+		return r.PushContext(ctx, pushOptions)
+	})
+	accessLogEntryFromCtx(ctx).recordGitTiming("push", time.Since(pushStart))
+	if err != nil {
+		return nil, fmt.Errorf("pushing to repository: %w", err)
+	}
+
+	log.
+		WithField("repoName", repoName).
+		WithField("repoUrl", repoConfig.URL).
+		WithField("branch", res.Branch).
+		WithField("commitHash", commitHash).
+		Info("Pushed commit to repository")
+
+	if createCommit {
+		res.Commit = commitHash.String()
+	}
+	if !openChangeRequest {
+		res.head = commitHash.String()
+	}
+	res.ConsistencyToken = consistencyToken{
+		Repo:   repoName,
+		Branch: res.Branch,
+		Commit: commitHash.String(),
+	}.sign(h.config.ConsistencyTokens.key())
+
+	if openChangeRequest {
+		cr, err := h.createChangeRequest(ctx, provider, repoConfig, changeRequestOpts, res.Branch, head.Name().Short(), commitMessage)
+		if err != nil {
+			return nil, fmt.Errorf("creating pull request: %w", err)
+		}
+		if req.MergeRequest != nil {
+			res.MergeRequest = &mergeRequestResponse{IID: cr.Number, URL: cr.URL}
+		} else {
+			res.PullRequest = &pullRequestResponse{Number: cr.Number, URL: cr.URL}
+		}
+
+		log.
+			WithField("repoName", repoName).
+			WithField("pullRequestUrl", cr.URL).
+			Info("Created pull request")
+	}
+
+	return res, nil
+}
+
+func (h *Handler) createChangeRequest(ctx context.Context, provider changeRequestProvider, repoConfig RepositoryConfig, opts *patchRequestPullRequest, sourceBranch, targetBranch, commitMessage string) (*changeRequest, error) {
+	title := opts.Title
+	if title == "" {
+		title = strings.SplitN(commitMessage, "\n", 2)[0]
+	}
+
+	fullName, err := repositoryFullName(repoConfig.URL)
+	if err != nil {
+		return nil, err
+	}
+	return provider.createChangeRequest(ctx, fullName, changeRequestOptions{
+		SourceBranch:       sourceBranch,
+		TargetBranch:       targetBranch,
+		Title:              title,
+		Description:        opts.Description,
+		RemoveSourceBranch: opts.RemoveSourceBranch,
+	})
+}
+
+// createTag creates the tag of a command pointing to its target or the given commit.
+func createTag(r *git.Repository, cmd *createTagPatchRequestCommand, commitHash plumbing.Hash, commitOptions *git.CommitOptions) error {
+	target := commitHash
+	if cmd.Target != "" {
+		hash, err := r.ResolveRevision(plumbing.Revision(cmd.Target))
+		if err != nil {
+			return clientError{fmt.Errorf("resolving target %q of tag %q: %w", cmd.Target, cmd.Name, err), http.StatusUnprocessableEntity}
+		}
+		target = *hash
+	}
+
+	var opts *git.CreateTagOptions
+	if cmd.Annotated {
+		opts = &git.CreateTagOptions{
+			Tagger:  commitOptions.Committer,
+			Message: cmd.Message,
+			SignKey: commitOptions.SignKey,
+		}
+	}
+	_, err := r.CreateTag(cmd.Name, target, opts)
+	if errors.Is(err, git.ErrTagExists) {
+		return clientError{fmt.Errorf("tag %q already exists", cmd.Name), http.StatusUnprocessableEntity}
+	}
+	if err != nil {
+		return fmt.Errorf("creating tag %q: %w", cmd.Name, err)
+	}
+	return nil
+}
+
+func refSpecFor(name plumbing.ReferenceName) gitConfig.RefSpec {
+	return gitConfig.RefSpec(fmt.Sprintf("%s:%s", name, name))
+}
+
+// buildPushOptions merges push options in the form "key" or "key=value" to options for go-git.
+// Note that go-git always sends options as "key=value", so "ci.skip" is sent as "ci.skip=".
+func buildPushOptions(optionLists ...[]string) map[string]string {
+	var options map[string]string
+	for _, list := range optionLists {
+		for _, opt := range list {
+			if options == nil {
+				options = make(map[string]string)
+			}
+			key, value, _ := strings.Cut(opt, "=")
+			options[key] = value
+		}
+	}
+	return options
+}
+
+func validatePushOption(opt string) error {
+	if opt == "" || strings.HasPrefix(opt, "=") {
+		return fmt.Errorf("key must not be empty")
+	}
+	if strings.ContainsAny(opt, "\n\x00") {
+		return fmt.Errorf("must not contain newlines or NUL characters")
+	}
+	return nil
+}
+
+// diffCommits returns the unified diff between two commits.
+func diffCommits(r *git.Repository, from, to plumbing.Hash) (string, error) {
+	fromCommit, err := r.CommitObject(from)
+	if err != nil {
+		return "", fmt.Errorf("getting commit %s: %w", from, err)
+	}
+	toCommit, err := r.CommitObject(to)
+	if err != nil {
+		return "", fmt.Errorf("getting commit %s: %w", to, err)
+	}
+	patch, err := fromCommit.Patch(toCommit)
+	if err != nil {
+		return "", fmt.Errorf("creating patch: %w", err)
+	}
+	return patch.String(), nil
+}
+
+// commitMessage returns the message of the request or the default message for the repository.
+func (h *Handler) commitMessage(repoConfig RepositoryConfig, req patchRequest) string {
+	if req.Commit.Message != "" {
+		return req.Commit.Message
+	}
+	return h.config.Commit.forRepository(repoConfig).DefaultMessage
+}
+
+// commitMessageData is the data of commit message templates.
+type commitMessageData struct {
+	// Message of the request or the default message.
+	Message string
+	// Repo is the name of the repository.
+	Repo string
+	// Paths of the files targeted by the commands.
+	Paths []string
+	// AuthCtx of the caller, e.g. {{ .AuthCtx.GitLabClaims.ProjectPath }}.
+	AuthCtx AuthCtx
+}
+
+func parseCommitMessageTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("commitMessage").Option("missingkey=error").Parse(text)
+}
+
+func (h *Handler) buildCommitMsgAndOptions(ctx context.Context, repoName string, repoConfig RepositoryConfig, req patchRequest) (string, *git.CommitOptions, error) {
+	commitConfig := h.config.Commit.forRepository(repoConfig)
+	commitMessage := h.commitMessage(repoConfig, req)
+	tmpl, err := parseCommitMessageTemplate(commitConfig.MessageTemplate)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing commit message template: %w", err)
+	}
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, commitMessageData{
+			Message: commitMessage,
+			Repo:    repoName,
+			Paths:   req.paths(),
+			AuthCtx: authCtxFromCtx(ctx),
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("rendering commit message template: %w", err)
+		}
+		commitMessage = buf.String()
+	}
+
+	commitAuthor, err := h.resolveSignature(ctx, req, commitConfig, nil)
+	if err != nil {
+		return "", nil, clientError{fmt.Errorf("resolving commit author: %w", err), http.StatusUnprocessableEntity}
+	}
+	commitCommitter, err := h.resolveSignature(ctx, req, commitConfig, commitAuthor)
+	if err != nil {
+		return "", nil, clientError{fmt.Errorf("resolving commit committer: %w", err), http.StatusUnprocessableEntity}
+	}
+
+	commitOptions := &git.CommitOptions{
+		Author:    commitAuthor,
+		Committer: commitCommitter,
+	}
+
+	signer := h.defaultSigner
+	if key, _, _ := h.lookupRepository(repoName); h.signers[key] != nil {
+		signer = h.signers[key]
+	}
+	if signer != nil {
+		if err := signer.apply(commitOptions); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return commitMessage, commitOptions, nil
+}
+
+// resolveSignature returns the signature of the first source of the commit configuration that provides one. The author
+// sources are used if author is nil, otherwise the committer sources and author for SignatureSourceAuthor.
+func (h *Handler) resolveSignature(ctx context.Context, req patchRequest, commitConfig CommitConfig, author *object.Signature) (*object.Signature, error) {
+	var requestSignature *objSignature
+	var sources []SignatureSource
+	if author == nil {
+		requestSignature = req.Commit.Author
+		sources = commitConfig.authorSources()
+	} else {
+		requestSignature = req.Commit.Committer
+		sources = commitConfig.committerSources()
+	}
+
+	for _, source := range sources {
+		switch source {
+		case SignatureSourceRequest:
+			if requestSignature != nil {
+				return &object.Signature{
+					Name:  requestSignature.Name,
+					Email: requestSignature.Email,
+					When:  time.Now(),
+				}, nil
+			}
+		case SignatureSourceClaims:
+			authCtx := authCtxFromCtx(ctx)
+			if authCtx.GitLabClaims != nil && authCtx.GitLabClaims.UserLogin != "" && authCtx.GitLabClaims.UserEmail != "" {
+				return &object.Signature{
+					Name:  authCtx.GitLabClaims.UserLogin,
+					Email: authCtx.GitLabClaims.UserEmail,
+					When:  time.Now(),
+				}, nil
+			}
+		case SignatureSourceDefault:
+			return &object.Signature{
+				Name:  commitConfig.DefaultAuthor.Name,
+				Email: commitConfig.DefaultAuthor.Email,
+				When:  time.Now(),
+			}, nil
+		case SignatureSourceAuthor:
+			if author != nil {
+				sig := *author
+				return &sig, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no signature from sources %v", sources)
+}
+
+type clientError struct {
+	error  error
+	status int
+}
+
+func (e clientError) Error() string {
+	if e.error == nil {
+		return ""
+	}
+	return e.error.Error()
+}
+
+func (e clientError) Unwrap() error {
+	return e.error
+}
+
+// violationsClientError returns a client error listing the violations of the policy.
+func violationsClientError(v ViolationsResolver) clientError {
+	var msg strings.Builder
+	for _, violation := range v.Violations() {
+		msg.WriteString("- ")
+		msg.WriteString(violation)
+		msg.WriteString("\n")
+	}
+	return clientError{errors.New(msg.String()), http.StatusForbidden}
+}
+
+// deniedError is returned if the policy denied a request after the repository was cloned.
+type deniedError struct {
+	clientError
+}
+
+func (e deniedError) Unwrap() error {
+	return e.clientError
+}
+
+type codedError struct {
+	error error
+	code  string
+}
+
+func (e codedError) Error() string {
+	if e.error == nil {
+		return e.code
+	}
+	return fmt.Sprintf("%s (%s)", e.error.Error(), e.code)
+}
+
+func (e codedError) Unwrap() error {
+	return e.error
+}
+
+// commandError is returned if a command of a patch request failed.
+type commandError struct {
+	error error
+	index int
+}
+
+func (e commandError) Error() string {
+	return e.error.Error()
+}
+
+func (e commandError) Unwrap() error {
+	return e.error
+}
+
+// checkExpectedHead returns an error if the HEAD of the cloned branch is not the expected commit, so callers can
+// compare and set. Pushes are not forced, so a change of the branch after cloning is rejected by the remote.
+func checkExpectedHead(r *git.Repository, expectedHead string) error {
+	if expectedHead == "" {
+		return nil
+	}
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("getting HEAD of repository: %w", err)
+	}
+	if !strings.EqualFold(head.Hash().String(), expectedHead) {
+		err := fmt.Errorf("HEAD of branch %q is %s, expected %s", head.Name().Short(), head.Hash(), expectedHead)
+		return codedError{clientError{err, http.StatusPreconditionFailed}, "head_mismatch"}
+	}
+	return nil
+}
+
+// checkFileSize returns an error if a file read by the command is larger than the maximum size, since files
+// are read into memory to be patched. This applies to the patched file and to source files (template path and
+// setField valueFrom path). Commands that do not read a file are not limited.
+func checkFileSize(fs billy.Filesystem, cmd patchRequestCommand, maxSize int64) error {
+	if !(cmd.CreateFile != nil || cmd.CreateFromTemplate != nil || cmd.CopyFile != nil || cmd.DeleteFile != nil || cmd.SetMode != nil || cmd.CreateTag != nil) {
+		if size, ok := fileSizeExceeds(fs, cmd.Path, maxSize); ok {
+			return clientError{fmt.Errorf("file %q is too large to patch (%d bytes), the maximum size is %d bytes", cmd.Path, size, maxSize), http.StatusUnprocessableEntity}
+		}
+	}
+	if cmd.CreateFromTemplate != nil && cmd.CreateFromTemplate.TemplatePath != "" {
+		if size, ok := fileSizeExceeds(fs, cmd.CreateFromTemplate.TemplatePath, maxSize); ok {
+			return clientError{fmt.Errorf("template %q is too large to read (%d bytes), the maximum size is %d bytes", cmd.CreateFromTemplate.TemplatePath, size, maxSize), http.StatusUnprocessableEntity}
+		}
+	}
+	if cmd.SetField != nil && cmd.SetField.ValueFrom != nil && cmd.SetField.ValueFrom.Path != "" {
+		if size, ok := fileSizeExceeds(fs, cmd.SetField.ValueFrom.Path, maxSize); ok {
+			return clientError{fmt.Errorf("file %q is too large to read (%d bytes), the maximum size is %d bytes", cmd.SetField.ValueFrom.Path, size, maxSize), http.StatusUnprocessableEntity}
+		}
+	}
+	return nil
+}
+
+// fileSizeExceeds returns the size of the file and whether it is larger than the maximum size.
+func fileSizeExceeds(fs billy.Filesystem, filename string, maxSize int64) (int64, bool) {
+	info, err := fs.Stat(filename)
+	if err != nil || info.IsDir() {
+		// Missing files are reported by the command
+		return 0, false
+	}
+	return info.Size(), info.Size() > maxSize
+}
+
+func (h *Handler) applyPatchCommand(ctx context.Context, fs billy.Filesystem, repoConfig RepositoryConfig, cmd patchRequestCommand) (patchCommandResponse, error) {
+	if err := checkFileSize(fs, cmd, h.config.Limits.maxFileSize()); err != nil {
+		return patchCommandResponse{}, err
+	}
+	if cmd.SetProperty != nil {
+		return applySetPropertyCommand(fs, cmd)
+	}
+	// Files of any type can be created, deleted and have their mode changed, image markers can be updated in directories
+	if cmd.CreateFile == nil && cmd.CreateFromTemplate == nil && cmd.CopyFile == nil && cmd.DeleteFile == nil && cmd.SetMode == nil && cmd.UpdateImageMarkers == nil {
+		if _, ok := properties.FormatOf(cmd.Path); ok {
+			return patchCommandResponse{}, clientError{fmt.Errorf("unsupported file type: %q, only setProperty, createFile and deleteFile are supported for .env and .properties files", cmd.Path), http.StatusUnprocessableEntity}
+		}
+
+		// If file is not a YAML file, we return an error (for now), JSON files are supported by commands that patch fields
+		// and TOML files by setField
+		if !isYAMLFile(cmd.Path) && !(cmd.patchesFields() && isJSONFile(cmd.Path)) && !(cmd.SetField != nil && isTOMLFile(cmd.Path)) {
+			return patchCommandResponse{}, clientError{fmt.Errorf("unsupported file type: %q, only YAML (JSON for commands patching fields, TOML for setField) is supported for now", cmd.Path), http.StatusUnprocessableEntity}
+		}
+	}
+	if cmd.SetField != nil && cmd.SetField.SOPS && !isYAMLFile(cmd.Path) {
+		return patchCommandResponse{}, clientError{fmt.Errorf("'sops' is only supported for YAML files"), http.StatusUnprocessableEntity}
+	}
+	if cmd.SetField != nil && cmd.SetField.Style != "" && !isYAMLFile(cmd.Path) {
+		return patchCommandResponse{}, clientError{fmt.Errorf("'style' is only supported for YAML files"), http.StatusUnprocessableEntity}
+	}
+	if cmd.SetField != nil && isTOMLFile(cmd.Path) {
+		for _, a := range cmd.SetField.assignments() {
+			if yaml.IsJSONPointer(a.Field) {
+				return patchCommandResponse{}, clientError{fmt.Errorf("field %q: JSON Pointers are only supported for YAML and JSON files", a.Field), http.StatusUnprocessableEntity}
+			}
+		}
+	}
+	if cmd.SetField != nil && cmd.SetField.AllowMultiple && isTOMLFile(cmd.Path) {
+		return patchCommandResponse{}, clientError{fmt.Errorf("'allowMultiple' is not supported for TOML files"), http.StatusUnprocessableEntity}
+	}
+	if cmd.SetField != nil && cmd.SetField.ValueFrom != nil {
+		// The value is read before the file is patched, so it can also be read from the same file
+		value, err := cmd.SetField.ValueFrom.read(fs, cmd.Path)
+		if err != nil {
+			return patchCommandResponse{}, err
+		}
+		setField := *cmd.SetField
+		setField.Value = value
+		cmd.SetField = &setField
+	}
+
+	res := patchCommandResponse{
+		ChangedFiles: []string{cmd.Path},
+	}
+
+	switch {
+	case cmd.CreateFile != nil:
+		perm := os.FileMode(0644)
+		if cmd.CreateFile.Mode != "" {
+			// The mode was validated with the request
+			perm, _ = cmd.CreateFile.Mode.perm()
+		}
+		f, err := fs.OpenFile(cmd.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			// Check "file already exists" error
+			if os.IsExist(err) {
+				return res, clientError{errors.New("file already exists"), http.StatusUnprocessableEntity}
+			}
+			return res, fmt.Errorf("creating file: %w", err)
+		}
+		defer f.Close()
+
+		err = cmd.CreateFile.writeContent(f)
+		if err != nil {
+			return res, fmt.Errorf("writing content: %w", err)
+		}
+	case cmd.CreateFromTemplate != nil:
+		// The file is only created if the template can be rendered
+		data, err := cmd.CreateFromTemplate.render(fs, h.config.Limits.maxFileSize())
+		if err != nil {
+			return res, err
+		}
+
+		perm := os.FileMode(0644)
+		if cmd.CreateFromTemplate.Mode != "" {
+			// The mode was validated with the request
+			perm, _ = cmd.CreateFromTemplate.Mode.perm()
+		}
+		f, err := fs.OpenFile(cmd.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			if os.IsExist(err) {
+				return res, clientError{errors.New("file already exists"), http.StatusUnprocessableEntity}
+			}
+			return res, fmt.Errorf("creating file: %w", err)
+		}
+		defer f.Close()
+
+		_, err = f.Write(data)
+		if err != nil {
+			return res, fmt.Errorf("writing content: %w", err)
+		}
+	case cmd.BumpChartVersion != nil:
+		if !isChartFile(cmd.Path) {
+			return res, clientError{fmt.Errorf("unsupported file: %q, bumpChartVersion only supports Chart.yaml files", cmd.Path), http.StatusUnprocessableEntity}
+		}
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			version, err := chartVersion(patcher)
+			if err != nil {
+				return false, clientError{fmt.Errorf("reading version of chart: %w", err), http.StatusUnprocessableEntity}
+			}
+			next := version.Bump(cmd.BumpChartVersion.part()).String()
+			err = patcher.SetField("version", next, false)
+			if err != nil {
+				return false, fmt.Errorf("setting version: %w", err)
+			}
+			if cmd.BumpChartVersion.AppVersion != "" {
+				err = patcher.SetField("appVersion", cmd.BumpChartVersion.AppVersion, true)
+				if err != nil {
+					return false, clientError{fmt.Errorf("setting appVersion: %w", err), http.StatusUnprocessableEntity}
+				}
+			}
+			res.Version = next
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+	case cmd.SetField != nil && isTOMLFile(cmd.Path):
+		changed, err := updateTOMLFile(fs, cmd.Path, func(patcher *toml.Patcher) error {
+			if cmd.SetField.ExpectedValue != nil {
+				current, err := patcher.Field(cmd.SetField.Field)
+				if err != nil {
+					return clientError{fmt.Errorf("reading field %q: %w", cmd.SetField.Field, err), http.StatusUnprocessableEntity}
+				}
+				if err := cmd.SetField.checkExpectedValue(current); err != nil {
+					return err
+				}
+			}
+			for _, a := range cmd.SetField.assignments() {
+				err := patcher.SetField(a.Field, a.Value, cmd.SetField.Create)
+				if err != nil {
+					return clientError{fmt.Errorf("setting field %q: %w", a.Field, err), http.StatusUnprocessableEntity}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+		if !changed {
+			// The file is already in the desired state
+			res.ChangedFiles = []string{}
+		}
+	case cmd.SetField != nil:
+		unchanged := false
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			var sopsDoc *sops.Document
+			if cmd.SetField.SOPS {
+				var err error
+				sopsDoc, err = openSOPSDocument(repoConfig, patcher)
+				if err != nil {
+					return false, err
+				}
+			}
+
+			if cmd.SetField.ExpectedValue != nil {
+				// Every field matched with allowMultiple must have the expected value
+				values, err := patcher.GetField(cmd.SetField.Field)
+				if err != nil {
+					return false, clientError{fmt.Errorf("reading field %q: %w", cmd.SetField.Field, err), http.StatusUnprocessableEntity}
+				}
+				if len(values) == 0 {
+					values = []any{nil}
+				} else if len(values) > 1 && !cmd.SetField.AllowMultiple {
+					return false, clientError{fmt.Errorf("reading field %q: multiple nodes matched path", cmd.SetField.Field), http.StatusUnprocessableEntity}
+				}
+				for _, current := range values {
+					if err := cmd.SetField.checkExpectedValue(current); err != nil {
+						return false, err
+					}
+				}
+			}
+
+			// Files are not re-encoded if all fields already have the value, a style or encryption always changes the file
+			if !cmd.SetField.SOPS && cmd.SetField.Style == "" {
+				var matches int
+				if unchanged, matches = cmd.SetField.unchanged(patcher); unchanged {
+					if cmd.SetField.AllowMultiple {
+						res.Matches = matches
+					}
+					return false, nil
+				}
+			}
+
+			patcher.SetExpandAliases(cmd.SetField.ExpandAliases)
+			// All fields are set before the file is written, so either all or none of them are changed
+			for _, a := range cmd.SetField.assignments() {
+				if cmd.SetField.AllowMultiple {
+					matches, err := patcher.SetFieldAll(a.Field, a.Value)
+					if err != nil {
+						return false, clientError{fmt.Errorf("setting field %q: %w", a.Field, err), http.StatusUnprocessableEntity}
+					}
+					res.Matches += matches
+					continue
+				}
+				err := patcher.SetField(a.Field, a.Value, cmd.SetField.Create)
+				if err != nil {
+					return false, clientError{fmt.Errorf("setting field %q: %w", a.Field, err), http.StatusUnprocessableEntity}
+				}
+				if cmd.SetField.Style != "" {
+					err := patcher.SetFieldStyle(a.Field, cmd.SetField.Style)
+					if err != nil {
+						return false, clientError{fmt.Errorf("setting style of field %q: %w", a.Field, err), http.StatusUnprocessableEntity}
+					}
+				}
+			}
+
+			if sopsDoc != nil {
+				err := sopsDoc.Seal(time.Now())
+				if err != nil {
+					return false, fmt.Errorf("encrypting SOPS document: %w", err)
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+		if unchanged {
+			// The file is already in the desired state
+			res.ChangedFiles = []string{}
+		}
+	case cmd.EnsureFields != nil:
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			changed, pruned, err := patcher.EnsureFields(cmd.EnsureFields.Fields, cmd.EnsureFields.Prune)
+			if err != nil {
+				return false, clientError{fmt.Errorf("ensuring fields: %w", err), http.StatusUnprocessableEntity}
+			}
+			res.ChangedFields = changed
+			res.PrunedFields = pruned
+			return len(changed) > 0 || len(pruned) > 0, nil
+		})
+		if err != nil {
+			return res, err
+		}
+		if len(res.ChangedFields) == 0 && len(res.PrunedFields) == 0 {
+			// The file is already in the desired state
+			res.ChangedFiles = []string{}
+		}
+	case cmd.IncrementField != nil:
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			value, err := patcher.Field(cmd.IncrementField.Field)
+			if err != nil {
+				return false, clientError{fmt.Errorf("reading field %q: %w", cmd.IncrementField.Field, err), http.StatusUnprocessableEntity}
+			}
+			next, err := cmd.IncrementField.increment(value)
+			if err != nil {
+				return false, clientError{fmt.Errorf("incrementing field %q: %w", cmd.IncrementField.Field, err), http.StatusUnprocessableEntity}
+			}
+			err = patcher.SetField(cmd.IncrementField.Field, next, false)
+			if err != nil {
+				return false, clientError{fmt.Errorf("setting field %q: %w", cmd.IncrementField.Field, err), http.StatusUnprocessableEntity}
+			}
+			res.Value = next
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+	case cmd.DeleteField != nil:
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			patcher.SetExpandAliases(cmd.DeleteField.ExpandAliases)
+			err := patcher.DeleteField(cmd.DeleteField.Field)
+			if err != nil {
+				return false, clientError{fmt.Errorf("deleting field %q: %w", cmd.DeleteField.Field, err), http.StatusUnprocessableEntity}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+	case cmd.SetComment != nil:
+		changed := false
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			var err error
+			changed, err = patcher.SetComment(cmd.SetComment.Field, cmd.SetComment.LineComment, cmd.SetComment.HeadComment)
+			if err != nil {
+				return false, clientError{fmt.Errorf("setting comment of field %q: %w", cmd.SetComment.Field, err), http.StatusUnprocessableEntity}
+			}
+			return changed, nil
+		})
+		if err != nil {
+			return res, err
+		}
+		if !changed {
+			// The field already has the comments
+			res.ChangedFiles = []string{}
+		}
+	case cmd.SetLabel != nil:
+		changed, err := applySetMetadataCommand(fs, cmd.Path, "labels", cmd.SetLabel)
+		if err != nil {
+			return res, err
+		}
+		if !changed {
+			// The manifests already have the labels
+			res.ChangedFiles = []string{}
+		}
+	case cmd.SetAnnotation != nil:
+		changed, err := applySetMetadataCommand(fs, cmd.Path, "annotations", cmd.SetAnnotation)
+		if err != nil {
+			return res, err
+		}
+		if !changed {
+			// The manifests already have the annotations
+			res.ChangedFiles = []string{}
+		}
+	case cmd.CopyFile != nil:
+		changedFiles, err := applyCopyFileCommand(fs, cmd.Path, cmd.CopyFile)
+		if err != nil {
+			return res, err
+		}
+		res.ChangedFiles = changedFiles
+	case cmd.UpdateImageMarkers != nil:
+		changedFiles, err := applyUpdateImageMarkersCommand(fs, cmd.Path, cmd.UpdateImageMarkers)
+		if err != nil {
+			return res, err
+		}
+		res.ChangedFiles = changedFiles
+	case cmd.AddToArray != nil:
+		err := updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			err := patcher.AddToArray(cmd.AddToArray.Field, cmd.AddToArray.Value, cmd.AddToArray.Index)
+			if err != nil {
+				return false, clientError{fmt.Errorf("adding to array %q: %w", cmd.AddToArray.Field, err), http.StatusUnprocessableEntity}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+	case cmd.JSONPatch != nil:
+		ops, err := cmd.JSONPatch.operations()
+		if err != nil {
+			return res, clientError{err, http.StatusBadRequest}
+		}
+		err = updateYAMLFile(fs, cmd.Path, func(patcher *yaml.Patcher) (bool, error) {
+			err := patcher.ApplyJSONPatch(ops)
+			if err != nil {
+				return false, clientError{fmt.Errorf("applying JSON patch: %w", err), http.StatusUnprocessableEntity}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return res, err
+		}
+	case cmd.DeleteFile != nil:
+		err := fs.Remove(cmd.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return res, clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+			}
+			return res, err
+		}
+	case cmd.SetMode != nil:
+		// The mode was validated with the request
+		perm, _ := cmd.SetMode.Mode.perm()
+		changed, err := setFileMode(fs, cmd.Path, perm)
+		if err != nil {
+			return res, err
+		}
+		if !changed {
+			// The file already has the mode
+			res.ChangedFiles = []string{}
+		}
+	default:
+		return res, clientError{fmt.Errorf("unknown command type"), http.StatusBadRequest}
+	}
+
+	log.
+		WithField("path", cmd.Path).
+		Info("Patched YAML")
+
+	return res, nil
+}
+
+// patchesFields returns true if the command patches fields of an existing file, which is supported for YAML and JSON files.
+func (c patchRequestCommand) patchesFields() bool {
+	return c.SetField != nil || c.EnsureFields != nil || c.AddToArray != nil || c.JSONPatch != nil || c.IncrementField != nil || c.DeleteField != nil
+}
+
+// readPaths returns the paths of other files that are read by the command (e.g. the source of copyFile).
+func (c patchRequestCommand) readPaths() []string {
+	var paths []string
+	if c.CopyFile != nil {
+		paths = append(paths, c.CopyFile.From)
+	}
+	if c.CreateFromTemplate != nil && c.CreateFromTemplate.TemplatePath != "" {
+		paths = append(paths, c.CreateFromTemplate.TemplatePath)
+	}
+	if c.SetField != nil && c.SetField.ValueFrom != nil && c.SetField.ValueFrom.Path != "" {
+		paths = append(paths, c.SetField.ValueFrom.Path)
+	}
+	return paths
+}
+
+func isYAMLFile(filename string) bool {
+	return strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml")
+}
+
+func isJSONFile(filename string) bool {
+	return strings.HasSuffix(filename, ".json")
+}
+
+func isTOMLFile(filename string) bool {
+	return strings.HasSuffix(filename, ".toml")
+}
+
+// updateYAMLFile applies the update to the YAML file, it is only written if the update returns true.
+// JSON files are parsed as YAML (JSON is a subset of YAML) and written as JSON with the order of keys and the indentation kept.
+func updateYAMLFile(fs billy.Filesystem, filename string, update func(patcher *yaml.Patcher) (bool, error)) error {
+	f, err := fs.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		}
+		return fmt.Errorf("opening file read-write: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+
+	patcher, err := yaml.NewPatcher(bytes.NewReader(data))
+	if err != nil {
+		if isJSONFile(filename) {
+			return clientError{fmt.Errorf("invalid JSON: %w", err), http.StatusUnprocessableEntity}
+		}
+		return fmt.Errorf("reading YAML: %w", err)
+	}
+
+	changed, err := update(patcher)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("truncating file: %w", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("seeking to start of file: %w", err)
+	}
+
+	if isJSONFile(filename) {
+		// Keep the indentation of the file to minimize formatting changes
+		err = patcher.EncodeJSONIndent(f, yaml.DetectJSONIndent(data))
+		if err != nil {
+			return fmt.Errorf("writing JSON: %w", err)
+		}
+		return nil
+	}
+	err = patcher.Encode(f)
+	if err != nil {
+		return fmt.Errorf("writing YAML: %w", err)
+	}
+	return nil
+}
+
+// applySetMetadataCommand sets the entries of the metadata mapping (labels or annotations) of all matching manifests
+// of a (multi-document) YAML file. It returns true if the file changed.
+func applySetMetadataCommand(fs billy.Filesystem, filename string, mapKey string, c *setMetadataPatchRequestCommand) (bool, error) {
+	var changed bool
+	err := updateYAMLDocuments(fs, filename, func(docs *yaml.Documents) (bool, error) {
+		var matched bool
+		for _, patcher := range docs.Patchers() {
+			if !c.matches(patcher) {
+				continue
+			}
+			matched = true
+			for _, e := range c.entries() {
+				entryChanged, err := patcher.SetMapEntry([]string{"metadata", mapKey}, e.Key, e.Value)
+				if err != nil {
+					return false, clientError{fmt.Errorf("setting %s %q: %w", mapKey, e.Key, err), http.StatusUnprocessableEntity}
+				}
+				changed = changed || entryChanged
+			}
+		}
+		if !matched {
+			return false, clientError{errors.New("no manifest matched kind and name"), http.StatusUnprocessableEntity}
+		}
+		return changed, nil
+	})
+	return changed, err
+}
+
+// applyCopyFileCommand copies the file or all files of the directory (keeping their mode) to the path.
+// It returns the changed files.
+func applyCopyFileCommand(fs billy.Filesystem, to string, c *copyFilePatchRequestCommand) ([]string, error) {
+	fi, err := fs.Stat(c.From)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, clientError{fmt.Errorf("%q does not exist", c.From), http.StatusUnprocessableEntity}
+		}
+		return nil, fmt.Errorf("getting file info: %w", err)
+	}
+
+	if !fi.IsDir() {
+		changed, err := copyFile(fs, c.From, to, fi.Mode().Perm(), c.Overwrite)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return []string{}, nil
+		}
+		return []string{to}, nil
+	}
+
+	// Files are collected first, so copying to a subdirectory of the source doesn't copy the copies
+	type sourceFile struct {
+		filename string
+		perm     os.FileMode
+	}
+	var files []sourceFile
+	err = util.Walk(fs, c.From, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, sourceFile{filename: filename, perm: info.Mode().Perm()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking directory: %w", err)
+	}
+
+	changedFiles := []string{}
+	for _, f := range files {
+		rel := strings.TrimPrefix(f.filename, path.Clean(c.From)+"/")
+		target := path.Join(to, rel)
+		changed, err := copyFile(fs, f.filename, target, f.perm, c.Overwrite)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			changedFiles = append(changedFiles, target)
+		}
+	}
+	return changedFiles, nil
+}
+
+// copyFile copies the file, an existing file is only replaced if overwrite is set. It returns true if the target changed.
+func copyFile(fs billy.Filesystem, from, to string, perm os.FileMode, overwrite bool) (bool, error) {
+	data, err := util.ReadFile(fs, from)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", from, err)
+	}
+
+	existing, err := fs.Stat(to)
+	if err == nil {
+		if existing.IsDir() {
+			return false, clientError{fmt.Errorf("%q is a directory", to), http.StatusUnprocessableEntity}
+		}
+		if !overwrite {
+			return false, clientError{fmt.Errorf("file %q already exists", to), http.StatusUnprocessableEntity}
+		}
+		current, err := util.ReadFile(fs, to)
+		if err != nil {
+			return false, fmt.Errorf("reading %s: %w", to, err)
+		}
+		if bytes.Equal(current, data) && existing.Mode().Perm() == perm {
+			return false, nil
+		}
+		// The file is re-created, so it gets the mode of the source
+		err = fs.Remove(to)
+		if err != nil {
+			return false, fmt.Errorf("removing %s: %w", to, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return false, fmt.Errorf("getting file info: %w", err)
+	}
+
+	err = util.WriteFile(fs, to, data, perm)
+	if err != nil {
+		return false, fmt.Errorf("writing %s: %w", to, err)
+	}
+	return true, nil
+}
+
+// applyUpdateImageMarkersCommand updates the image policy markers of the file or all YAML files of the directory.
+// It returns the changed files.
+func applyUpdateImageMarkersCommand(fs billy.Filesystem, root string, c *updateImageMarkersPatchRequestCommand) ([]string, error) {
+	fi, err := fs.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, clientError{errors.New("file or directory does not exist"), http.StatusUnprocessableEntity}
+		}
+		return nil, fmt.Errorf("getting file info: %w", err)
+	}
+
+	filenames := []string{root}
+	if fi.IsDir() {
+		filenames = nil
+		err = util.Walk(fs, root, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && isYAMLFile(filename) {
+				filenames = append(filenames, filename)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking directory: %w", err)
+		}
+	}
+
+	var found int
+	changedFiles := []string{}
+	for _, filename := range filenames {
+		data, err := util.ReadFile(fs, filename)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filename, err)
+		}
+		// Files without markers are skipped, so other files (e.g. Helm templates) don't need to be valid YAML
+		if !bytes.Contains(data, []byte("$imagepolicy")) {
+			continue
+		}
+
+		var fileChanged bool
+		err = updateYAMLDocuments(fs, filename, func(docs *yaml.Documents) (bool, error) {
+			for _, patcher := range docs.Patchers() {
+				n, changed := patcher.SetImagePolicyMarkers(c.Policy, c.Image, c.Tag)
+				found += n
+				fileChanged = fileChanged || changed
+			}
+			return fileChanged, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("updating %s: %w", filename, err)
+		}
+		if fileChanged {
+			changedFiles = append(changedFiles, filename)
+		}
+	}
+	if found == 0 {
+		return nil, clientError{fmt.Errorf("no image policy markers found for policy %q", c.Policy), http.StatusUnprocessableEntity}
+	}
+
+	return changedFiles, nil
+}
+
+// updateYAMLDocuments applies the update to all documents of the YAML file, the file is only written if update returns true.
+func updateYAMLDocuments(fs billy.Filesystem, filename string, update func(docs *yaml.Documents) (bool, error)) error {
+	f, err := fs.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		}
+		return fmt.Errorf("opening file read-write: %w", err)
+	}
+	defer f.Close()
+
+	docs, err := yaml.NewDocuments(f)
+	if err != nil {
+		return clientError{fmt.Errorf("invalid YAML: %w", err), http.StatusUnprocessableEntity}
+	}
+
+	changed, err := update(docs)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("truncating file: %w", err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("seeking to start of file: %w", err)
+	}
+	err = docs.Encode(f)
+	if err != nil {
+		return fmt.Errorf("writing YAML: %w", err)
+	}
+	return nil
+}
+
+// updateTOMLFile applies the update to the TOML file, values are edited in place, so comments and layout are kept.
+// The file is only written if its content changed, changed is false otherwise.
+func updateTOMLFile(fs billy.Filesystem, filename string, update func(patcher *toml.Patcher) error) (changed bool, err error) {
+	f, err := fs.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		}
+		return false, fmt.Errorf("opening file read-write: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return false, fmt.Errorf("reading file: %w", err)
+	}
+
+	patcher, err := toml.NewPatcher(bytes.NewReader(data))
+	if err != nil {
+		return false, clientError{fmt.Errorf("invalid TOML: %w", err), http.StatusUnprocessableEntity}
+	}
+
+	err = update(patcher)
+	if err != nil {
+		return false, err
+	}
+
+	var buf bytes.Buffer
+	err = patcher.Encode(&buf)
+	if err != nil {
+		return false, fmt.Errorf("encoding TOML: %w", err)
+	}
+	if bytes.Equal(buf.Bytes(), data) {
+		return false, nil
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return false, fmt.Errorf("truncating file: %w", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, fmt.Errorf("seeking to start of file: %w", err)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		return false, fmt.Errorf("writing TOML: %w", err)
+	}
+	return true, nil
+}
+
+// applySetPropertyCommand sets or removes a key of a .env or .properties file, the file is only written if it changed.
+func applySetPropertyCommand(fs billy.Filesystem, cmd patchRequestCommand) (patchCommandResponse, error) {
+	res := patchCommandResponse{
+		ChangedFiles: []string{cmd.Path},
+	}
+
+	format, ok := properties.FormatOf(cmd.Path)
+	if !ok {
+		return res, clientError{fmt.Errorf("unsupported file type: %q, setProperty only supports .env and .properties files", cmd.Path), http.StatusUnprocessableEntity}
+	}
+
+	f, err := fs.OpenFile(cmd.Path, os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		}
+		return res, fmt.Errorf("opening file read-write: %w", err)
+	}
+	defer f.Close()
+
+	patcher, err := properties.NewPatcher(f, format)
+	if err != nil {
+		return res, clientError{fmt.Errorf("reading file: %w", err), http.StatusUnprocessableEntity}
+	}
+
+	var changed bool
+	if cmd.SetProperty.Remove {
+		changed, err = patcher.Remove(cmd.SetProperty.Key)
+	} else {
+		changed, err = patcher.Set(cmd.SetProperty.Key, *cmd.SetProperty.Value)
+	}
+	if err != nil {
+		return res, clientError{fmt.Errorf("setting property %q: %w", cmd.SetProperty.Key, err), http.StatusUnprocessableEntity}
+	}
+	if !changed {
+		// The file is already in the desired state
+		res.ChangedFiles = []string{}
+		return res, nil
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		return res, fmt.Errorf("truncating file: %w", err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return res, fmt.Errorf("seeking to start of file: %w", err)
+	}
+	err = patcher.Encode(f)
+	if err != nil {
+		return res, fmt.Errorf("writing file: %w", err)
+	}
+	return res, nil
+}
+
+// setFileMode sets the permissions of a file and returns true if they changed.
+// The in-memory filesystem cannot change permissions, so the file is re-created with the content.
+func setFileMode(fs billy.Filesystem, filename string, perm os.FileMode) (bool, error) {
+	fi, err := fs.Stat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, clientError{errors.New("file does not exist"), http.StatusUnprocessableEntity}
+		}
+		return false, fmt.Errorf("getting file info: %w", err)
+	}
+	if fi.IsDir() {
+		return false, clientError{errors.New("path is a directory"), http.StatusUnprocessableEntity}
+	}
+	if fi.Mode().Perm() == perm {
+		return false, nil
+	}
+
+	data, err := util.ReadFile(fs, filename)
+	if err != nil {
+		return false, fmt.Errorf("reading file: %w", err)
+	}
+	err = fs.Remove(filename)
+	if err != nil {
+		return false, fmt.Errorf("removing file: %w", err)
+	}
+	err = util.WriteFile(fs, filename, data, perm)
+	if err != nil {
+		return false, fmt.Errorf("writing file: %w", err)
+	}
+	return true, nil
 }
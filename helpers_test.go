@@ -0,0 +1,41 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/policy"
+)
+
+type staticAuthenticationProvider struct {
+	authCtx vignet.AuthCtx
+}
+
+func (p staticAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (vignet.AuthCtx, error) {
+	return p.authCtx, nil
+}
+
+// newDefaultAuthorizer returns a RegoAuthorizer with the default policy bundle.
+func newDefaultAuthorizer(t *testing.T) vignet.Authorizer {
+	t.Helper()
+
+	defaultBundle, err := policy.LoadDefaultBundle()
+	require.NoError(t, err)
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), defaultBundle)
+	require.NoError(t, err)
+	return authorizer
+}
+
+// newTestHandler creates a handler with the default policy bundle for a GitLab job of the project
+// "my-group/my-project", which may patch files below my-group/my-project.
+func newTestHandler(t *testing.T, config vignet.Config) *vignet.Handler {
+	t.Helper()
+
+	return vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}, newDefaultAuthorizer(t), config)
+}
@@ -0,0 +1,165 @@
+package vignet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type historyResponse struct {
+	Commits []historyCommitResponse `json:"commits"`
+}
+
+type historyCommitResponse struct {
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+	// Message is the first line of the commit message.
+	Message   string           `json:"message"`
+	Author    historySignature `json:"author"`
+	Committer historySignature `json:"committer"`
+	// Files are the paths of files changed by the commit (compared to the first parent).
+	Files []string `json:"files"`
+}
+
+type historySignature struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// history lists the latest commits of a branch (newest first), optionally only commits created by vignet.
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	repoName := repoParam(r)
+
+	query := r.URL.Query()
+	limit := defaultHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxHistoryLimit {
+			respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("'limit' must be a number between 1 and %d", maxHistoryLimit), http.StatusBadRequest})
+			return
+		}
+		limit = l
+	}
+	var vignetOnly bool
+	if v := query.Get("vignet"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("invalid 'vignet': %w", err), http.StatusBadRequest})
+			return
+		}
+		vignetOnly = b
+	}
+	branch := query.Get("branch")
+
+	_, repoConfig, exists := h.lookupRepository(repoName)
+	if !exists {
+		log.WithField("repo", repoName).Warn("Unknown repository")
+		respondError(w, r, "Unknown repository", clientError{fmt.Errorf("repository %q not configured", repoName), http.StatusNotFound})
+		return
+	}
+
+	if !h.authorizeRead(w, r, repoName, readRequest{Resource: "history", Branch: branch}) {
+		return
+	}
+
+	repoConfig, err := h.exchangeCredential(ctx, r, repoName, repoConfig)
+	if err != nil {
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Failed to exchange credential")
+		respondError(w, r, "Credential exchange failed", err)
+		return
+	}
+
+	res := historyResponse{
+		Commits: []historyCommitResponse{},
+	}
+	var statsErr error
+	err = h.walkHistory(ctx, repoConfig, branch, func(c *object.Commit) bool {
+		if vignetOnly && !h.isVignetCommit(repoConfig, c) {
+			return true
+		}
+		files, err := changedFiles(c)
+		if err != nil {
+			statsErr = fmt.Errorf("getting changed files of commit %s: %w", c.Hash, err)
+			return false
+		}
+		// Files outside of the allowed paths are not disclosed, commits that only changed such files are skipped
+		if len(repoConfig.AllowedPaths) > 0 {
+			allowedFiles := files[:0]
+			for _, f := range files {
+				if repoConfig.allowsPath(f) {
+					allowedFiles = append(allowedFiles, f)
+				}
+			}
+			if len(allowedFiles) == 0 {
+				return true
+			}
+			files = allowedFiles
+		}
+		res.Commits = append(res.Commits, historyCommitResponse{
+			Commit:    c.Hash.String(),
+			Time:      c.Committer.When,
+			Message:   strings.SplitN(c.Message, "\n", 2)[0],
+			Author:    historySignature{Name: c.Author.Name, Email: c.Author.Email},
+			Committer: historySignature{Name: c.Committer.Name, Email: c.Committer.Email},
+			Files:     files,
+		})
+		return len(res.Commits) < limit
+	})
+	if err == nil {
+		err = statsErr
+	}
+	if err != nil {
+		var clientErr clientError
+		if !errors.As(err, &clientErr) {
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Error("Failed to read history")
+		}
+		respondError(w, r, "Reading history failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// isVignetCommit returns true if the commit was authored or committed with the default author of the repository.
+// Commits with a signature of the request or the claims cannot be distinguished from other commits.
+func (h *Handler) isVignetCommit(repoConfig RepositoryConfig, c *object.Commit) bool {
+	email := h.config.Commit.forRepository(repoConfig).DefaultAuthor.Email
+	if email == "" {
+		return false
+	}
+	return strings.EqualFold(c.Author.Email, email) || strings.EqualFold(c.Committer.Email, email)
+}
+
+// changedFiles returns the paths of files changed by the commit compared to its first parent.
+func changedFiles(c *object.Commit) ([]string, error) {
+	stats, err := c.Stats()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(stats))
+	for i, s := range stats {
+		files[i] = s.Name
+	}
+	return files, nil
+}
@@ -0,0 +1,177 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_History(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+		"my-group/my-project/values.yml":  "replicas: 1\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	})
+
+	for _, body := range []string{
+		`{"commit": {"message": "Release 1.1.0"}, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]}`,
+		`{"commit": {"message": "Scale up\n\nMore replicas"}, "commands": [{"path": "my-group/my-project/values.yml", "setField": {"field": "replicas", "value": 2}}]}`,
+	} {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	type historyCommit struct {
+		Commit  string `json:"commit"`
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+		Files []string `json:"files"`
+	}
+	listHistory := func(t *testing.T, query string) []historyCommit {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/repos/e2e-test/history"+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res struct {
+			Commits []historyCommit `json:"commits"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res.Commits
+	}
+
+	t.Run("all commits", func(t *testing.T) {
+		commits := listHistory(t, "")
+		require.Len(t, commits, 3)
+		assert.Equal(t, "Scale up", commits[0].Message)
+		assert.Equal(t, []string{"my-group/my-project/values.yml"}, commits[0].Files)
+		assert.Equal(t, "bot@vignet", commits[0].Author.Email)
+		assert.Equal(t, "Release 1.1.0", commits[1].Message)
+		assert.Equal(t, []string{"my-group/my-project/release.yml"}, commits[1].Files)
+		assert.Equal(t, "test@vignet", commits[2].Author.Email)
+		assert.Len(t, commits[2].Files, 2)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		commits := listHistory(t, "?limit=1")
+		require.Len(t, commits, 1)
+		assert.Equal(t, "Scale up", commits[0].Message)
+	})
+
+	t.Run("vignet commits", func(t *testing.T) {
+		commits := listHistory(t, "?vignet=true")
+		require.Len(t, commits, 2)
+		assert.Equal(t, "Scale up", commits[0].Message)
+		assert.Equal(t, "Release 1.1.0", commits[1].Message)
+	})
+
+	t.Run("unknown branch", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/history?branch=missing", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), `branch "missing" not found`)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/history?limit=1000", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	})
+}
+
+func TestHandler_History_AllowedPaths(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+		"my-group/my-project/values.yml":  "replicas: 1\n",
+		"other/secret.yml":                "password: secret\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL, AllowedPaths: []string{"my-group/my-project/release.yml"}},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commit": {"message": "Release 1.1.0"}, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	req = httptest.NewRequest("GET", "/repos/e2e-test/history", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Commits []struct {
+			Message string   `json:"message"`
+			Files   []string `json:"files"`
+		} `json:"commits"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Commits, 2)
+	assert.Equal(t, "Release 1.1.0", res.Commits[0].Message)
+	assert.Equal(t, []string{"my-group/my-project/release.yml"}, res.Commits[0].Files)
+	// Files outside of the allowed paths are not listed
+	assert.Equal(t, []string{"my-group/my-project/release.yml"}, res.Commits[1].Files)
+}
+
+func TestHandler_History_BranchIsAuthorized(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+	}, gitserver.Options{})
+
+	b := loadTestBundle(t, `
+package vignet.request.read
+import future.keywords
+
+violations contains msg if {
+	input.readRequest.branch != ""
+	msg := sprintf("branch %q cannot be read", [input.readRequest.branch])
+}
+`)
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), b)
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	req := httptest.NewRequest("GET", "/repos/e2e-test/history", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	req = httptest.NewRequest("GET", "/repos/e2e-test/history?branch=release", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `branch "release" cannot be read`)
+}
@@ -0,0 +1,77 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_IncrementField(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "build: 41\nimage:\n  tag: \"v1.2.3\" # released\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Incremented release",
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [
+			{"path": "my-group/my-project/release.yml", "incrementField": {"field": "build"}},
+			{"path": "my-group/my-project/release.yml", "incrementField": {"field": "image.tag", "bump": "minor"}}
+		]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Commit   string `json:"commit"`
+		Commands []struct {
+			Value any `json:"value"`
+		} `json:"commands"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.NotEmpty(t, res.Commit)
+	require.Equal(t, float64(42), res.Commands[0].Value)
+	require.Equal(t, "v1.3.0", res.Commands[1].Value)
+	assertGitRepoHeadCommit(t, fs, "Incremented release")
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"build: 42\nimage:\n  tag: \"v1.3.0\" # released\n"},
+	})
+
+	tests := []struct {
+		name           string
+		incrementField string
+		expectedStatus int
+		expectedError  string
+	}{
+		{name: "string without bump", incrementField: `{"field": "image.tag"}`, expectedStatus: http.StatusUnprocessableEntity, expectedError: "expected integer"},
+		{name: "integer with bump", incrementField: `{"field": "build", "bump": "patch"}`, expectedStatus: http.StatusUnprocessableEntity, expectedError: "expected semantic version"},
+		{name: "missing field", incrementField: `{"field": "replicas"}`, expectedStatus: http.StatusUnprocessableEntity, expectedError: "no nodes matched path"},
+		{name: "by and bump", incrementField: `{"field": "build", "by": 2, "bump": "patch"}`, expectedStatus: http.StatusBadRequest, expectedError: "only one of 'by' or 'bump'"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+				"commands": [{"path": "my-group/my-project/release.yml", "incrementField": `+tc.incrementField+`}]
+			}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Package bench provides a harness to benchmark the clone, patch and push pipeline of vignet
+// against a synthetic repository served by an in-process Git server.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+	"github.com/networkteam/vignet/policy"
+)
+
+// ProjectPath is the GitLab project path of the benchmark identity, all synthetic files are placed below it.
+const ProjectPath = "bench/project"
+
+// Options for a benchmark run.
+type Options struct {
+	// Files is the number of YAML files in the synthetic repository.
+	Files int
+	// FileSize is the approximate size of each file in bytes.
+	FileSize int
+	// Requests is the total number of patch requests to send.
+	Requests int
+	// Concurrency is the number of requests sent in parallel.
+	Concurrency int
+	// DryRun sends the patch requests as dry runs (no commit and push).
+	DryRun bool
+}
+
+// DefaultOptions are sensible defaults for a quick run.
+var DefaultOptions = Options{
+	Files:       100,
+	FileSize:    1024,
+	Requests:    50,
+	Concurrency: 4,
+}
+
+func (o Options) Valid() error {
+	if o.Files < 1 {
+		return errors.New("files must be at least 1")
+	}
+	if o.FileSize < 0 {
+		return errors.New("file size must not be negative")
+	}
+	if o.Requests < 1 {
+		return errors.New("requests must be at least 1")
+	}
+	if o.Concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	return nil
+}
+
+// Result of a benchmark run.
+type Result struct {
+	Requests int
+	Errors   int
+	Duration time.Duration
+	// Latencies of all requests sorted ascending.
+	Latencies []time.Duration
+	// FirstError is the first error encountered, if any.
+	FirstError error
+}
+
+// Percentile returns the latency at the given percentile (0-100).
+func (r Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	idx := int(float64(len(r.Latencies)-1) * p / 100)
+	return r.Latencies[idx]
+}
+
+// Throughput returns the number of requests per second.
+func (r Result) Throughput() float64 {
+	if r.Duration == 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Duration.Seconds()
+}
+
+// WriteSummary writes a human-readable summary of the result.
+func (r Result) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "Requests:    %d (%d errors)\n", r.Requests, r.Errors)
+	fmt.Fprintf(w, "Duration:    %s\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput:  %.2f req/s\n", r.Throughput())
+	fmt.Fprintf(w, "Latency p50: %s\n", r.Percentile(50).Round(time.Microsecond))
+	fmt.Fprintf(w, "Latency p95: %s\n", r.Percentile(95).Round(time.Microsecond))
+	fmt.Fprintf(w, "Latency p99: %s\n", r.Percentile(99).Round(time.Microsecond))
+	if r.FirstError != nil {
+		fmt.Fprintf(w, "First error: %v\n", r.FirstError)
+	}
+}
+
+// Harness serves a synthetic repository and a vignet handler for it.
+type Harness struct {
+	opts      Options
+	gitServer *httptest.Server
+	handler   http.Handler
+	seq       atomic.Int64
+}
+
+// NewHarness generates a synthetic repository and starts an in-process Git server for it.
+// Close must be called to stop the server.
+func NewHarness(ctx context.Context, opts Options) (*Harness, error) {
+	if err := opts.Valid(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	fs := memfs.New()
+	err := gitserver.InitRepo(fs, GenerateFiles(opts.Files, opts.FileSize))
+	if err != nil {
+		return nil, fmt.Errorf("initializing repository: %w", err)
+	}
+	gitServer := httptest.NewServer(gitserver.New(fs, gitserver.Options{}))
+
+	b, err := policy.LoadDefaultBundle()
+	if err != nil {
+		gitServer.Close()
+		return nil, fmt.Errorf("loading default bundle: %w", err)
+	}
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, b)
+	if err != nil {
+		gitServer.Close()
+		return nil, fmt.Errorf("building authorizer: %w", err)
+	}
+
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		"bench": {URL: gitServer.URL},
+	}
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, config)
+
+	return &Harness{
+		opts:      opts,
+		gitServer: gitServer,
+		handler:   handler,
+	}, nil
+}
+
+// Close stops the Git server.
+func (h *Harness) Close() {
+	h.gitServer.Close()
+}
+
+// Patch sends a single patch request that sets a field in one of the synthetic files.
+func (h *Harness) Patch(ctx context.Context) error {
+	n := h.seq.Add(1)
+	body, err := json.Marshal(map[string]any{
+		"commands": []map[string]any{
+			{
+				"path": filePath(int(n) % h.opts.Files),
+				"setField": map[string]any{
+					"field": "spec.version",
+					"value": fmt.Sprintf("1.0.%d", n),
+				},
+			},
+		},
+		"dryRun": h.opts.DryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/patch/bench", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	return nil
+}
+
+// Run sends the configured number of requests with the configured concurrency and collects latencies.
+func (h *Harness) Run(ctx context.Context) Result {
+	var (
+		mx     sync.Mutex
+		result = Result{Requests: h.opts.Requests}
+		wg     sync.WaitGroup
+		jobs   = make(chan struct{})
+	)
+
+	start := time.Now()
+	for i := 0; i < h.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				reqStart := time.Now()
+				err := h.Patch(ctx)
+				latency := time.Since(reqStart)
+
+				mx.Lock()
+				result.Latencies = append(result.Latencies, latency)
+				if err != nil {
+					result.Errors++
+					if result.FirstError == nil {
+						result.FirstError = err
+					}
+				}
+				mx.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < h.opts.Requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	result.Duration = time.Since(start)
+
+	sort.Slice(result.Latencies, func(i, j int) bool {
+		return result.Latencies[i] < result.Latencies[j]
+	})
+
+	return result
+}
+
+// Run generates a synthetic repository and runs the benchmark with the given options.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	h, err := NewHarness(ctx, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer h.Close()
+
+	return h.Run(ctx), nil
+}
+
+// GenerateFiles generates the given number of YAML files below ProjectPath, each padded to approximately size bytes.
+func GenerateFiles(count, size int) map[string]string {
+	files := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "apiVersion: v1\nkind: Release\nmetadata:\n  name: release-%d\nspec:\n  version: 1.0.0\n  values:\n", i)
+		for j := 0; sb.Len() < size; j++ {
+			fmt.Fprintf(&sb, "    key%d: value-%d-%d\n", j, i, j)
+		}
+		files[filePath(i)] = sb.String()
+	}
+	return files
+}
+
+func filePath(i int) string {
+	return fmt.Sprintf("%s/releases/release-%d.yaml", ProjectPath, i)
+}
+
+type staticAuthenticationProvider struct{}
+
+func (staticAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (vignet.AuthCtx, error) {
+	return vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: ProjectPath},
+	}, nil
+}
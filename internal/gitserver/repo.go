@@ -0,0 +1,76 @@
+package gitserver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// InitRepo initializes a Git repository storage in fs with an initial commit of the given files.
+func InitRepo(fs billy.Filesystem, initialFiles map[string]string) error {
+	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	defer storer.Close()
+
+	workdirFS := memfs.New()
+	repo, err := git.Init(storer, workdirFS)
+	if err != nil {
+		return fmt.Errorf("initializing repository: %w", err)
+	}
+
+	// Add files in a stable order
+	paths := make([]string, 0, len(initialFiles))
+	for path := range initialFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("getting worktree: %w", err)
+	}
+
+	for _, path := range paths {
+		err := writeFile(workdirFS, path, initialFiles[path])
+		if err != nil {
+			return err
+		}
+		_, err = w.Add(path)
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", path, err)
+		}
+	}
+
+	_, err = w.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "vignet",
+			Email: "test@vignet",
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("committing: %w", err)
+	}
+
+	return nil
+}
+
+func writeFile(fs billy.Filesystem, path string, content string) error {
+	f, err := fs.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(content))
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
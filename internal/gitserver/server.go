@@ -0,0 +1,349 @@
+// Package gitserver provides a smart HTTP Git server backed by a billy filesystem for tests and benchmarks.
+package gitserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// Server serves a Git repository via the smart HTTP protocol.
+type Server struct {
+	ld   server.Loader
+	srv  transport.Transport
+	opts Options
+
+	mx               sync.Mutex
+	pushOptions      []string
+	uploadPackDepths []int
+}
+
+var _ http.Handler = &Server{}
+
+// Options for the server.
+type Options struct {
+	// BasicAuth is required for requests if set.
+	BasicAuth *gitHttp.BasicAuth
+	// Authorization is the required value of the Authorization header if set.
+	Authorization string
+}
+
+// New creates a server for the repository storage in fs.
+func New(fs billy.Filesystem, opts Options) *Server {
+	ld := server.NewFilesystemLoader(fs)
+	srv := server.NewServer(ld)
+
+	return &Server{
+		ld:   ld,
+		srv:  srv,
+		opts: opts,
+	}
+}
+
+func (m *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.opts.BasicAuth != nil {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != m.opts.BasicAuth.Username || password != m.opts.BasicAuth.Password {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if m.opts.Authorization != "" && r.Header.Get("Authorization") != m.opts.Authorization {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Route by suffix, so the repository can be served under any path
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/info/refs"):
+		m.httpInfoRefs(w, r)
+	case strings.HasSuffix(r.URL.Path, "/git-upload-pack"):
+		m.httpGitUploadPack(w, r)
+	case strings.HasSuffix(r.URL.Path, "/git-receive-pack"):
+		m.httpGitReceivePack(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *Server) httpInfoRefs(rw http.ResponseWriter, r *http.Request) {
+	log.Debugf("Request httpInfoRefs %s %s", r.Method, r.URL)
+
+	service := r.URL.Query().Get("service")
+	if service != "git-upload-pack" && service != "git-receive-pack" {
+		http.Error(rw, "Smart Git is required", http.StatusForbidden)
+		return
+	}
+
+	rw.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+
+	ep, err := transport.NewEndpoint("/")
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to create endpoint")
+		return
+	}
+
+	var sess transport.Session
+
+	if service == "git-upload-pack" {
+		sess, err = m.srv.NewUploadPackSession(ep, nil)
+		if err != nil {
+			http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			log.WithError(err).Error("Failed to create upload pack session")
+			return
+		}
+	} else {
+		sess, err = m.srv.NewReceivePackSession(ep, nil)
+		if err != nil {
+			http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			log.WithError(err).Error("Failed to create receive pack session")
+			return
+		}
+	}
+	defer sess.Close()
+
+	ar, err := sess.AdvertisedReferencesContext(r.Context())
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to get advertised references")
+		return
+	}
+	if service == "git-receive-pack" {
+		// Advertise push options, they are handled before passing the request to the session
+		_ = ar.Capabilities.Set(capability.PushOptions)
+	} else {
+		// Advertise shallow clones, they are handled before passing the request to the session
+		_ = ar.Capabilities.Set(capability.Shallow)
+	}
+	ar.Prefix = [][]byte{
+		[]byte(fmt.Sprintf("# service=%s", service)),
+		pktline.Flush,
+	}
+	err = ar.Encode(rw)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to encode advertised references")
+		return
+	}
+}
+
+func (m *Server) httpGitUploadPack(rw http.ResponseWriter, r *http.Request) {
+	log.Debugf("Request httpGitUploadPack %s %s", r.Method, r.URL)
+
+	rw.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+
+	upr := packp.NewUploadPackRequest()
+	err := upr.Decode(r.Body)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to decode upload pack request")
+		return
+	}
+
+	depth, _ := upr.Depth.(packp.DepthCommits)
+	m.mx.Lock()
+	m.uploadPackDepths = append(m.uploadPackDepths, int(depth))
+	m.mx.Unlock()
+
+	ep, err := transport.NewEndpoint("/")
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to create endpoint")
+		return
+	}
+
+	if depth != 0 {
+		err = m.uploadShallowPack(rw, ep, upr)
+		if err != nil {
+			http.Error(rw, "Internal server error", http.StatusInternalServerError)
+			log.WithError(err).Error("Failed to upload shallow pack")
+		}
+		return
+	}
+
+	sess, err := m.srv.NewUploadPackSession(ep, nil)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to create upload pack session")
+		return
+	}
+	defer sess.Close()
+
+	res, err := sess.UploadPack(r.Context(), upr)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to upload pack")
+		return
+	}
+	defer res.Close()
+
+	err = res.Encode(rw)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to encode upload pack response")
+		return
+	}
+
+}
+
+// uploadShallowPack responds with the commits that are wanted and their trees, since the server session does not
+// support shallow clones. Only a depth of 1 is supported.
+func (m *Server) uploadShallowPack(w io.Writer, ep *transport.Endpoint, upr *packp.UploadPackRequest) error {
+	if upr.Depth != packp.DepthCommits(1) || len(upr.Shallows) > 0 {
+		return fmt.Errorf("only shallow clones with a depth of 1 are supported")
+	}
+
+	s, err := m.ld.Load(ep)
+	if err != nil {
+		return fmt.Errorf("loading storage: %w", err)
+	}
+
+	var objs []plumbing.Hash
+	for _, want := range upr.Wants {
+		commit, err := object.GetCommit(s, want)
+		if err != nil {
+			return fmt.Errorf("getting commit %s: %w", want, err)
+		}
+		objs = append(objs, commit.Hash, commit.TreeHash)
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return fmt.Errorf("getting tree of commit %s: %w", want, err)
+		}
+		walker := object.NewTreeWalker(tree, true, nil)
+		for {
+			_, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				walker.Close()
+				return fmt.Errorf("walking tree of commit %s: %w", want, err)
+			}
+			if entry.Mode != filemode.Submodule {
+				objs = append(objs, entry.Hash)
+			}
+		}
+		walker.Close()
+	}
+
+	var pack bytes.Buffer
+	_, err = packfile.NewEncoder(&pack, s, false).Encode(objs, 10)
+	if err != nil {
+		return fmt.Errorf("encoding packfile: %w", err)
+	}
+
+	res := packp.NewUploadPackResponseWithPackfile(upr, io.NopCloser(&pack))
+	res.ShallowUpdate.Shallows = upr.Wants
+	return res.Encode(w)
+}
+
+func (m *Server) httpGitReceivePack(rw http.ResponseWriter, r *http.Request) {
+	log.Debugf("Request httpGitReceivePack %s %s", r.Method, r.URL)
+
+	rw.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+
+	upr := packp.NewReferenceUpdateRequest()
+	err := upr.Decode(r.Body)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to decode reference update request")
+		return
+	}
+	err = m.decodePushOptions(upr)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to decode push options")
+		return
+	}
+
+	ep, err := transport.NewEndpoint("/")
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to create endpoint")
+		return
+	}
+
+	sess, err := m.srv.NewReceivePackSession(ep, nil)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to create receive pack session")
+		return
+	}
+	defer sess.Close()
+
+	res, err := sess.ReceivePack(r.Context(), upr)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to receive pack")
+		return
+	}
+
+	err = res.Encode(rw)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusInternalServerError)
+		log.WithError(err).Error("Failed to encode receive pack response")
+		return
+	}
+}
+
+// decodePushOptions reads the push options that precede the packfile, since the go-git decoder does not handle them.
+func (m *Server) decodePushOptions(upr *packp.ReferenceUpdateRequest) error {
+	if !upr.Capabilities.Supports(capability.PushOptions) {
+		return nil
+	}
+
+	var options []string
+	s := pktline.NewScanner(upr.Packfile)
+	for {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return err
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if len(s.Bytes()) == 0 {
+			break
+		}
+		options = append(options, strings.TrimSuffix(string(s.Bytes()), "\n"))
+	}
+	// The server session does not support push options
+	upr.Capabilities.Delete(capability.PushOptions)
+
+	m.mx.Lock()
+	m.pushOptions = options
+	m.mx.Unlock()
+
+	return nil
+}
+
+// PushOptions returns the push options of the last push.
+func (m *Server) PushOptions() []string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.pushOptions
+}
+
+// UploadPackDepths returns the depth of every upload pack request (0 for a full clone or fetch).
+func (m *Server) UploadPackDepths() []int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.uploadPackDepths
+}
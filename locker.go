@@ -0,0 +1,187 @@
+package vignet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLockLost is the cause of the cancelled context of a lock that was lost before it was released, e.g. because it
+// expired in Redis.
+var ErrLockLost = errors.New("repository lock was lost")
+
+// Locker serializes operations on a repository, so concurrent patches do not conflict on push.
+type Locker interface {
+	// Lock blocks until the lock for the repository is acquired or the context is done.
+	// The returned context is cancelled with the cause ErrLockLost if the lock is lost, operations under the lock must
+	// use it. The returned function must be called to release the lock.
+	Lock(ctx context.Context, repo string) (lockCtx context.Context, unlock func(), err error)
+}
+
+// MemoryLocker is an in-process Locker. It only serializes operations within a single vignet instance.
+type MemoryLocker struct {
+	mx    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+var _ Locker = &MemoryLocker{}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+func (l *MemoryLocker) Lock(ctx context.Context, repo string) (context.Context, func(), error) {
+	l.mx.Lock()
+	lock, exists := l.locks[repo]
+	if !exists {
+		lock = make(chan struct{}, 1)
+		l.locks[repo] = lock
+	}
+	l.mx.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+		// A lock in memory cannot be lost
+		return ctx, func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("waiting for lock: %w", ctx.Err())
+	}
+}
+
+// RedisLocker is a Locker backed by Redis that serializes operations across multiple vignet instances.
+//
+// Locks expire after a TTL to recover from crashed instances, the TTL is extended while the lock is held. If the lock
+// could not be extended before it expired or it is owned by another instance, the lock is lost and its context is
+// cancelled, so the operation is stopped before it pushes.
+type RedisLocker struct {
+	client    redis.UniversalClient
+	keyPrefix string
+	ttl       time.Duration
+	// retryInterval is the interval to retry acquiring a lock that is held by another instance.
+	retryInterval time.Duration
+}
+
+var _ Locker = &RedisLocker{}
+
+// NewRedisLocker creates a new RedisLocker using the given client.
+func NewRedisLocker(client redis.UniversalClient, keyPrefix string, ttl time.Duration) *RedisLocker {
+	return &RedisLocker{
+		client:        client,
+		keyPrefix:     keyPrefix,
+		ttl:           ttl,
+		retryInterval: 100 * time.Millisecond,
+	}
+}
+
+// Only delete or extend the lock if it is still owned by us
+var (
+	redisUnlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+	redisExtendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+func (l *RedisLocker) Lock(ctx context.Context, repo string) (context.Context, func(), error) {
+	key := l.keyPrefix + repo
+	token, err := randomToken()
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating lock token: %w", err)
+	}
+
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, l.ttl).Result()
+		if err != nil {
+			return nil, nil, fmt.Errorf("acquiring lock: %w", err)
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-time.After(l.retryInterval):
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for lock: %w", ctx.Err())
+		}
+	}
+	acquired := time.Now()
+
+	// Extend the lock until it is released, the operation is stopped if the lock is lost
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	stopExtend := make(chan struct{})
+	extendDone := make(chan struct{})
+	go func() {
+		defer close(extendDone)
+		extended := acquired
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				start := time.Now()
+				res, err := redisExtendScript.Run(context.Background(), l.client, []string{key}, token, l.ttl.Milliseconds()).Int()
+				switch {
+				case err == nil && res == 0:
+					log.WithField("repo", repo).Error("Repository lock was lost, it expired or is owned by another instance")
+					cancel(ErrLockLost)
+					return
+				case err == nil:
+					extended = start
+				case time.Since(extended) >= l.ttl:
+					log.WithField("repo", repo).WithError(err).Error("Failed to extend repository lock before it expired")
+					cancel(ErrLockLost)
+					return
+				default:
+					log.WithField("repo", repo).WithError(err).Error("Failed to extend repository lock")
+				}
+			case <-stopExtend:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return lockCtx, func() {
+		once.Do(func() {
+			close(stopExtend)
+			<-extendDone
+			cancel(nil)
+			err := redisUnlockScript.Run(context.Background(), l.client, []string{key}, token).Err()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				log.WithField("repo", repo).WithError(err).Error("Failed to release repository lock")
+			}
+		})
+	}, nil
+}
+
+// lockLostError returns err with ErrLockLost if the operation failed, because the lock of ctx was lost.
+func lockLostError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrLockLost) {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return err
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,115 @@
+package vignet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestLockers(t *testing.T) {
+	tt := []struct {
+		name      string
+		newLocker func(t *testing.T) vignet.Locker
+	}{
+		{
+			name: "memory",
+			newLocker: func(t *testing.T) vignet.Locker {
+				return vignet.NewMemoryLocker()
+			},
+		},
+		{
+			name: "redis",
+			newLocker: func(t *testing.T) vignet.Locker {
+				mr := miniredis.RunT(t)
+				client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				t.Cleanup(func() { _ = client.Close() })
+				return vignet.NewRedisLocker(client, "vignet:lock:", 3*time.Second)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			locker := tc.newLocker(t)
+			ctx := context.Background()
+
+			_, unlock, err := locker.Lock(ctx, "repo-a")
+			require.NoError(t, err)
+
+			// Another repository can be locked independently
+			_, unlockB, err := locker.Lock(ctx, "repo-b")
+			require.NoError(t, err)
+			unlockB()
+
+			// Locking the same repository blocks until the context is done
+			timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			_, _, err = locker.Lock(timeoutCtx, "repo-a")
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+
+			// After unlocking, the lock can be acquired again
+			unlock()
+			_, unlock, err = locker.Lock(ctx, "repo-a")
+			require.NoError(t, err)
+			unlock()
+		})
+	}
+}
+
+func TestRedisLocker_LostLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	locker := vignet.NewRedisLocker(client, "vignet:lock:", 300*time.Millisecond)
+
+	lockCtx, unlock, err := locker.Lock(context.Background(), "repo-a")
+	require.NoError(t, err)
+	defer unlock()
+
+	// The lock expired and was acquired by another instance
+	mr.Set("vignet:lock:repo-a", "other-instance")
+
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context of lost lock was not cancelled")
+	}
+	require.ErrorIs(t, context.Cause(lockCtx), vignet.ErrLockLost)
+
+	// The lock of the other instance is not released
+	unlock()
+	v, err := mr.Get("vignet:lock:repo-a")
+	require.NoError(t, err)
+	require.Equal(t, "other-instance", v)
+}
+
+func TestLockingConfig_Valid(t *testing.T) {
+	for _, tc := range []struct {
+		ttl           time.Duration
+		expectedError string
+	}{
+		{ttl: 0},
+		{ttl: 5 * time.Second},
+		{ttl: 10 * time.Millisecond, expectedError: "redis.ttl must be at least 1s"},
+		{ttl: -time.Second, expectedError: "redis.ttl must be at least 1s"},
+	} {
+		err := vignet.LockingConfig{
+			Backend: vignet.LockingBackendRedis,
+			Redis: &vignet.RedisLockingConfig{
+				RedisConfig: vignet.RedisConfig{Address: "localhost:6379"},
+				TTL:         tc.ttl,
+			},
+		}.Valid()
+		if tc.expectedError == "" {
+			require.NoError(t, err, tc.ttl)
+		} else {
+			require.EqualError(t, err, tc.expectedError, tc.ttl)
+		}
+	}
+}
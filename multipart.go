@@ -0,0 +1,93 @@
+package vignet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// multipartMaxMemory is the size of a multipart request that is kept in memory, larger parts are stored in temporary files.
+const multipartMaxMemory = 1 << 20
+
+// multipartRequestField is the name of the multipart field that holds the JSON patch request.
+const multipartRequestField = "request"
+
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// decodeMultipartPatchRequest decodes a patch request from the "request" field of a multipart body.
+// The content of createFile commands with contentFrom is read from the referenced file parts when the command is applied.
+// The returned form must be removed after the request is handled.
+func (h *Handler) decodeMultipartPatchRequest(r *http.Request, req *patchRequest) (*multipart.Form, error) {
+	if err := r.ParseMultipartForm(multipartMaxMemory); err != nil {
+		return nil, fmt.Errorf("parsing multipart body: %w", err)
+	}
+	form := r.MultipartForm
+
+	var requestBody io.Reader
+	if values := form.Value[multipartRequestField]; len(values) > 0 {
+		requestBody = strings.NewReader(values[0])
+	} else if files := form.File[multipartRequestField]; len(files) > 0 {
+		f, err := files[0].Open()
+		if err != nil {
+			_ = form.RemoveAll()
+			return nil, fmt.Errorf("opening %q part: %w", multipartRequestField, err)
+		}
+		defer f.Close()
+		requestBody = f
+	} else {
+		_ = form.RemoveAll()
+		return nil, fmt.Errorf("missing %q part", multipartRequestField)
+	}
+
+	if err := h.newBodyDecoder(requestBody).Decode(req); err != nil {
+		_ = form.RemoveAll()
+		return nil, fmt.Errorf("invalid JSON in %q part: %w", multipartRequestField, err)
+	}
+
+	for _, cmd := range req.Commands {
+		if cmd.CreateFile == nil || cmd.CreateFile.ContentFrom == "" {
+			continue
+		}
+		// A missing part is reported by the validation of the command
+		if files := form.File[cmd.CreateFile.ContentFrom]; len(files) > 0 {
+			cmd.CreateFile.contentFile = files[0]
+		}
+	}
+
+	return form, nil
+}
+
+// writeContent writes the content of the command to w, streaming it from the file part if contentFrom is set.
+func (c createFilePatchRequestCommand) writeContent(w io.Writer) error {
+	if c.contentFile == nil {
+		if c.Encoding == contentEncodingBase64 {
+			data, err := base64.StdEncoding.DecodeString(c.Content)
+			if err != nil {
+				return fmt.Errorf("decoding base64 content: %w", err)
+			}
+			_, err = w.Write(data)
+			return err
+		}
+		_, err := io.WriteString(w, c.Content)
+		return err
+	}
+
+	f, err := c.contentFile.Open()
+	if err != nil {
+		return fmt.Errorf("opening part %q: %w", c.ContentFrom, err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		return fmt.Errorf("copying part %q: %w", c.ContentFrom, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package vignet_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_MultipartCreateFile(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	// Large enough to be stored in a temporary file instead of memory
+	manifest := strings.Repeat("- kind: ConfigMap\n  data: {}\n", 100_000)
+
+	tests := []struct {
+		name           string
+		request        string
+		parts          map[string]string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name: "missing request",
+			parts: map[string]string{
+				"manifest": manifest,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `missing "request" part`,
+		},
+		{
+			name:           "missing part",
+			request:        `{"commands": [{"path": "my-group/my-project/generated.yml", "createFile": {"contentFrom": "manifest"}}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `part "manifest" not found`,
+		},
+		{
+			name:    "content and contentFrom",
+			request: `{"commands": [{"path": "my-group/my-project/generated.yml", "createFile": {"content": "foo: bar", "contentFrom": "manifest"}}]}`,
+			parts: map[string]string{
+				"manifest": manifest,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "only one of 'content' or 'contentFrom' can be given",
+		},
+		{
+			name: "create file from part",
+			request: `{"commit": {"message": "Add generated manifest"}, "commands": [
+				{"path": "my-group/my-project/generated.yml", "createFile": {"contentFrom": "manifest"}},
+				{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}
+			]}`,
+			parts: map[string]string{
+				"manifest": manifest,
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			if tc.request != "" {
+				require.NoError(t, mw.WriteField("request", tc.request))
+			}
+			for name, content := range tc.parts {
+				pw, err := mw.CreateFormFile(name, name+".yml")
+				require.NoError(t, err)
+				_, err = pw.Write([]byte(content))
+				require.NoError(t, err)
+			}
+			require.NoError(t, mw.Close())
+
+			req := httptest.NewRequest("POST", "/patch/e2e-test", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+
+	assertGitRepoHeadCommit(t, fs, "Add generated manifest")
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/generated.yml": content{manifest},
+		"my-group/my-project/release.yml":   content{"foo: baz\n"},
+	})
+}
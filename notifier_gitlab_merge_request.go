@@ -0,0 +1,110 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	netUrl "net/url"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// GitLabMergeRequestNotifier posts a comment with the pushed commit to the merge request
+// of the GitLab pipeline that triggered a patch.
+//
+// The merge request is looked up by the project and ref claims of the job token,
+// so only pipelines with source "merge_request_event" are considered.
+type GitLabMergeRequestNotifier struct {
+	client *gitLabClient
+}
+
+var _ Notifier = &GitLabMergeRequestNotifier{}
+
+// NewGitLabMergeRequestNotifier creates a new GitLabMergeRequestNotifier.
+//
+// It takes the GitLab instance URL, an access token with scope "api" and the client to send requests with (it should
+// have a timeout). Commit links are built from the repository URL of the event.
+func NewGitLabMergeRequestNotifier(url, token string, client *http.Client) *GitLabMergeRequestNotifier {
+	return &GitLabMergeRequestNotifier{
+		client: &gitLabClient{
+			apiURL: strings.TrimSuffix(url, "/") + "/api/v4",
+			token:  token,
+			client: client,
+		},
+	}
+}
+
+func (n *GitLabMergeRequestNotifier) Notify(ctx context.Context, event PatchEvent) error {
+	claims := event.AuthCtx.GitLabClaims
+	if claims == nil || claims.PipelineSource != "merge_request_event" || claims.ProjectID == "" || claims.Ref == "" {
+		return nil
+	}
+
+	var mergeRequests []struct {
+		IID int `json:"iid"`
+	}
+	err := n.client.request(ctx, http.MethodGet, fmt.Sprintf("/projects/%s/merge_requests?state=opened&source_branch=%s", netUrl.PathEscape(claims.ProjectID), netUrl.QueryEscape(claims.Ref)), nil, &mergeRequests)
+	if err != nil {
+		return fmt.Errorf("listing merge requests: %w", err)
+	}
+	if len(mergeRequests) == 0 {
+		log.
+			WithField("projectId", claims.ProjectID).
+			WithField("ref", claims.Ref).
+			Debug("No open merge request found for ref, skipping comment")
+		return nil
+	}
+
+	iid := mergeRequests[0].IID
+	err = n.client.request(ctx, http.MethodPost, fmt.Sprintf("/projects/%s/merge_requests/%d/notes", netUrl.PathEscape(claims.ProjectID), iid), map[string]string{
+		"body": n.commentBody(event),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("creating merge request note: %w", err)
+	}
+
+	log.
+		WithField("projectId", claims.ProjectID).
+		WithField("mergeRequestIid", iid).
+		Info("Commented on merge request")
+
+	return nil
+}
+
+func (n *GitLabMergeRequestNotifier) commentBody(event PatchEvent) string {
+	var sb strings.Builder
+
+	commitRef := fmt.Sprintf("`%s`", shortHash(event.CommitHash))
+	if webURL := repositoryWebURL(event.RepoURL); webURL != "" {
+		commitRef = fmt.Sprintf("[%s](%s/-/commit/%s)", commitRef, webURL, event.CommitHash)
+	}
+	fmt.Fprintf(&sb, "vignet pushed commit %s to repository `%s`:\n\n", commitRef, event.Repo)
+	for _, line := range strings.Split(strings.TrimSpace(event.Message), "\n") {
+		fmt.Fprintf(&sb, "> %s\n", line)
+	}
+	sb.WriteString("\nChanged files:\n\n")
+	for _, path := range event.Paths {
+		fmt.Fprintf(&sb, "- `%s`\n", path)
+	}
+
+	return sb.String()
+}
+
+// repositoryWebURL returns the web URL for a HTTP(S) repository URL without credentials and .git suffix.
+func repositoryWebURL(repoURL string) string {
+	u, err := netUrl.Parse(repoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, ".git")
+	return u.String()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
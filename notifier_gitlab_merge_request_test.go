@@ -0,0 +1,66 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestGitLabMergeRequestNotifier_Notify(t *testing.T) {
+	var notes []string
+	gitLabSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "a-token", r.Header.Get("PRIVATE-TOKEN"))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/42/merge_requests":
+			require.Equal(t, "feature/foo", r.URL.Query().Get("source_branch"))
+			_ = json.NewEncoder(w).Encode([]map[string]any{{"iid": 7}})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/42/merge_requests/7/notes":
+			var body struct {
+				Body string `json:"body"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			notes = append(notes, body.Body)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gitLabSrv.Close()
+
+	notifier := vignet.NewGitLabMergeRequestNotifier(gitLabSrv.URL, "a-token", gitLabSrv.Client())
+
+	event := vignet.PatchEvent{
+		Repo:       "infra",
+		RepoURL:    "https://gitlab.example.com/my-group/infra.git",
+		CommitHash: "0123456789abcdef0123456789abcdef01234567",
+		Message:    "Bump image",
+		Paths:      []string{"my-group/my-project/release.yml"},
+		AuthCtx: vignet.AuthCtx{
+			GitLabClaims: &vignet.GitLabClaims{
+				ProjectID:      "42",
+				Ref:            "feature/foo",
+				PipelineSource: "merge_request_event",
+			},
+		},
+	}
+	err := notifier.Notify(context.Background(), event)
+	require.NoError(t, err)
+
+	require.Len(t, notes, 1)
+	require.Equal(t, "vignet pushed commit [`01234567`](https://gitlab.example.com/my-group/infra/-/commit/0123456789abcdef0123456789abcdef01234567) to repository `infra`:\n\n> Bump image\n\nChanged files:\n\n- `my-group/my-project/release.yml`\n", notes[0])
+
+	// Pipelines not triggered by a merge request are ignored
+	event.AuthCtx.GitLabClaims.PipelineSource = "push"
+	err = notifier.Notify(context.Background(), event)
+	require.NoError(t, err)
+	require.Len(t, notes, 1)
+}
@@ -0,0 +1,50 @@
+package vignet
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/apex/log"
+)
+
+// openAPISpec is the OpenAPI 3 document of the HTTP API, the client package implements the same types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPI responds with the OpenAPI document, adjusted to the API version of the request and the version and enabled
+// features of this instance.
+func (h *Handler) openAPI(w http.ResponseWriter, r *http.Request) {
+	var doc map[string]any
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		// The document is embedded and tested, so this should not happen
+		log.WithError(err).Error("Failed to decode OpenAPI document")
+		respondError(w, r, "Reading OpenAPI document failed", err)
+		return
+	}
+
+	if info, ok := doc["info"].(map[string]any); ok {
+		info["version"] = Version
+	}
+	if servers, ok := doc["servers"].([]any); ok && len(servers) > 0 {
+		if server, ok := servers[0].(map[string]any); ok {
+			server["url"] = apiVersionFromCtx(r.Context()).pathPrefix()
+		}
+	}
+	if paths, ok := doc["paths"].(map[string]any); ok {
+		if !h.config.Features.Enabled(FeaturePromotions) {
+			delete(paths, "/promotions/{repo}")
+		}
+		if !h.config.Features.Enabled(FeatureReadFile) {
+			delete(paths, "/repos/{repo}/file")
+		}
+		if !h.config.Features.Enabled(FeatureHistory) {
+			delete(paths, "/repos/{repo}/history")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,122 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestHandler_OpenAPI(t *testing.T) {
+	getDocument := func(t *testing.T, config vignet.Config) map[string]any {
+		t.Helper()
+
+		// Authentication is not required
+		handler := vignet.NewHandler(staticAuthenticationProvider{authCtx: vignet.AuthCtx{Error: assert.AnError}}, nil, config)
+
+		req := httptest.NewRequest("GET", "/openapi.json", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		return doc
+	}
+
+	t.Run("document", func(t *testing.T) {
+		doc := getDocument(t, vignet.Config{})
+
+		assert.Equal(t, "3.0.3", doc["openapi"])
+		assert.Equal(t, "dev", doc["info"].(map[string]any)["version"])
+
+		paths := doc["paths"].(map[string]any)
+		for _, path := range []string{
+			"/patch",
+			"/patch/{repo}",
+			"/patch/{repo}/preview",
+			"/repos/{repo}/file",
+			"/repos/{repo}/history",
+			"/promotions/{repo}",
+			"/capabilities",
+			"/openapi.json",
+			"/healthz",
+		} {
+			assert.Contains(t, paths, path)
+		}
+
+		// All references must resolve to a component
+		var refs []string
+		collectRefs(doc, &refs)
+		require.NotEmpty(t, refs)
+		components := doc["components"].(map[string]any)
+		for _, ref := range refs {
+			parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+			require.Len(t, parts, 2, ref)
+			section, ok := components[parts[0]].(map[string]any)
+			require.True(t, ok, "missing components section of %s", ref)
+			assert.Contains(t, section, parts[1], "unresolved reference %s", ref)
+		}
+	})
+
+	t.Run("disabled promotions", func(t *testing.T) {
+		doc := getDocument(t, vignet.Config{
+			Features: vignet.FeaturesConfig{
+				vignet.FeaturePromotions: false,
+			},
+		})
+
+		paths := doc["paths"].(map[string]any)
+		assert.NotContains(t, paths, "/promotions/{repo}")
+		assert.Contains(t, paths, "/patch/{repo}")
+	})
+
+	t.Run("disabled read file", func(t *testing.T) {
+		doc := getDocument(t, vignet.Config{
+			Features: vignet.FeaturesConfig{
+				vignet.FeatureReadFile: false,
+			},
+		})
+
+		paths := doc["paths"].(map[string]any)
+		assert.NotContains(t, paths, "/repos/{repo}/file")
+		assert.Contains(t, paths, "/repos/{repo}/history")
+	})
+
+	t.Run("disabled history", func(t *testing.T) {
+		doc := getDocument(t, vignet.Config{
+			Features: vignet.FeaturesConfig{
+				vignet.FeatureHistory: false,
+			},
+		})
+
+		paths := doc["paths"].(map[string]any)
+		assert.NotContains(t, paths, "/repos/{repo}/history")
+		assert.Contains(t, paths, "/repos/{repo}/file")
+	})
+}
+
+// collectRefs appends the values of all $ref keys of a decoded JSON document.
+func collectRefs(v any, refs *[]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				*refs = append(*refs, ref)
+				continue
+			}
+			collectRefs(value, refs)
+		}
+	case []any:
+		for _, value := range v {
+			collectRefs(value, refs)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_SkippedCommands(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: \"1.0.0\"\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Best-effort release",
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"atomic": false,
+		"commands": [
+			{"path": "my-group/my-project/staging.yml", "setField": {"field": "image.tag", "value": "1.1.0"}, "optional": true},
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "image.digest", "value": "sha256:abc"}},
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}
+		]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Commit   string `json:"commit"`
+		Commands []struct {
+			Applied      bool     `json:"applied"`
+			ChangedFiles []string `json:"changedFiles"`
+			Field        string   `json:"field"`
+			OldValue     any      `json:"oldValue"`
+			NewValue     any      `json:"newValue"`
+			Skipped      bool     `json:"skipped"`
+			Error        string   `json:"error"`
+		} `json:"commands"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.NotEmpty(t, res.Commit)
+	require.Len(t, res.Commands, 3)
+
+	require.False(t, res.Commands[0].Applied)
+	require.True(t, res.Commands[0].Skipped)
+	require.Empty(t, res.Commands[0].Error)
+
+	require.False(t, res.Commands[1].Applied)
+	require.True(t, res.Commands[1].Skipped)
+	require.Contains(t, res.Commands[1].Error, "no nodes matched path")
+
+	require.True(t, res.Commands[2].Applied)
+	require.False(t, res.Commands[2].Skipped)
+	require.Equal(t, []string{"my-group/my-project/release.yml"}, res.Commands[2].ChangedFiles)
+	require.Equal(t, "image.tag", res.Commands[2].Field)
+	require.Equal(t, "1.0.0", res.Commands[2].OldValue)
+	require.Equal(t, "1.1.0", res.Commands[2].NewValue)
+
+	assertGitRepoHeadCommit(t, fs, "Best-effort release")
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"image:\n  tag: \"1.1.0\"\n"},
+	})
+}
+
+func TestHandler_FailedCommandIndex(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: \"1.0.0\"\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}},
+			{"path": "my-group/my-project/release.yml", "setField": {"field": "image.digest", "value": "sha256:abc"}}
+		]
+	}`))
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+
+	var res struct {
+		Error   string `json:"error"`
+		Command *int   `json:"command"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Contains(t, res.Error, "no nodes matched path")
+	require.NotNil(t, res.Command)
+	require.Equal(t, 1, *res.Command)
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+// ErrBundleNotModified is returned by FetchBundle if the server responded that the bundle did not change since the
+// given ETag.
+var ErrBundleNotModified = errors.New("bundle not modified")
+
+// IsRemoteBundle returns true if the policy location is an HTTP(S) URL instead of a local path.
+func IsRemoteBundle(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// FetchBundle downloads a bundle as a gzipped tarball from a URL (like bundles served for the OPA bundle API).
+//
+// If etag is not empty, it is sent as If-None-Match and ErrBundleNotModified is returned if the server responds with
+// 304 Not Modified. The ETag of the response is returned to be used for the next fetch.
+// Request headers (e.g. for authorization) can be set in the given header.
+func FetchBundle(ctx context.Context, client *http.Client, url string, header http.Header, etag string) (*bundle.Bundle, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("requesting bundle: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, ErrBundleNotModified
+	default:
+		return nil, "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	b, err := bundle.NewReader(resp.Body).Read()
+	if err != nil {
+		return nil, "", fmt.Errorf("reading bundle: %w", err)
+	}
+
+	return &b, resp.Header.Get("ETag"), nil
+}
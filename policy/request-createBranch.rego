@@ -0,0 +1,30 @@
+package vignet.request.createBranch
+import future.keywords
+
+gitLabProjectPath := input.authCtx.gitLabClaims.project_path
+
+# Branches derived from the commit hash (empty name) and branches prefixed with "vignet/" cannot be existing branches
+# of the repository (e.g. a protected branch)
+isAllowedBranch if {
+    input.branchRequest.name == ""
+}
+
+isAllowedBranch if {
+    startswith(input.branchRequest.name, "vignet/")
+}
+
+isAllowedBranch if {
+    startswith(input.branchRequest.name, sprintf("%s/", [gitLabProjectPath]))
+}
+
+violations contains msg if {
+    not isAllowedBranch
+    gitLabProjectPath
+    msg := sprintf("branch %q is not prefixed with \"vignet/\" or GitLab project path (%q)", [input.branchRequest.name, gitLabProjectPath])
+}
+
+violations contains msg if {
+    not isAllowedBranch
+    not gitLabProjectPath
+    msg := sprintf("branch %q is not prefixed with \"vignet/\"", [input.branchRequest.name])
+}
@@ -0,0 +1,50 @@
+package vignet.request.createBranch
+import future.keywords
+
+test_branch_derived_from_commit_hash if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "branchRequest": {"name": "", "targetBranch": ""},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_branch_prefixed_with_vignet if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "branchRequest": {"name": "vignet/release-1.2.3", "targetBranch": "main"},
+        "authCtx": {}
+    }
+}
+
+test_branch_prefixed_with_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "branchRequest": {"name": "my-group/my-project/release", "targetBranch": "main"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_existing_branch if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "branchRequest": {"name": "main", "targetBranch": "develop"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "branch \"main\" is not prefixed with \"vignet/\" or GitLab project path (\"my-group/my-project\")"
+}
+
+test_existing_branch_without_claims if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "branchRequest": {"name": "main", "targetBranch": "develop"},
+        "authCtx": {}
+    }
+    v[_] == "branch \"main\" is not prefixed with \"vignet/\""
+}
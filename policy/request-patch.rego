@@ -4,14 +4,80 @@ import future.keywords
 commands := input.patchRequest.commands
 gitLabProjectPath := input.authCtx.gitLabClaims.project_path
 
-commandPathNotPrefixOfGitLabProjectPath contains cmd if {
+isTagCommand(cmd) if {
+    cmd.createTag != null
+}
+
+fileCommands contains cmd if {
     some cmd in commands
+    not isTagCommand(cmd)
+}
+
+commandPathNotPrefixOfGitLabProjectPath contains cmd if {
+    some cmd in fileCommands
     not startswith(cmd.path, sprintf("%s/", [gitLabProjectPath]))
 }
 
-commandPathIsNotYaml contains cmd if {
-    some cmd in commands
-    not glob.match("**/*.{yml,yaml}", ["/"], cmd.path)
+templatePathNotPrefixOfGitLabProjectPath contains cmd if {
+    some cmd in fileCommands
+    cmd.createFromTemplate.templatePath != ""
+    not startswith(cmd.createFromTemplate.templatePath, sprintf("%s/", [gitLabProjectPath]))
+}
+
+copyFromPathNotPrefixOfGitLabProjectPath contains cmd if {
+    some cmd in fileCommands
+    cmd.copyFile != null
+    not startswith(cmd.copyFile.from, sprintf("%s/", [gitLabProjectPath]))
+}
+
+valueFromPathNotPrefixOfGitLabProjectPath contains cmd if {
+    some cmd in fileCommands
+    cmd.setField.valueFrom.path != ""
+    not startswith(cmd.setField.valueFrom.path, sprintf("%s/", [gitLabProjectPath]))
+}
+
+isSupportedPath(path) if {
+    glob.match("**/*.{yml,yaml,json,toml,properties,env}", ["/"], path)
+}
+
+isSupportedPath(path) if {
+    glob.match("**/.env{,.*}", ["/"], path)
+}
+
+# Files of any type can be created, copied, deleted and have their mode changed, image markers are updated in directories
+isAnyFileTypeCommand(cmd) if {
+    cmd.createFile != null
+}
+
+isAnyFileTypeCommand(cmd) if {
+    cmd.createFromTemplate != null
+}
+
+isAnyFileTypeCommand(cmd) if {
+    cmd.copyFile != null
+}
+
+isAnyFileTypeCommand(cmd) if {
+    cmd.deleteFile != null
+}
+
+isAnyFileTypeCommand(cmd) if {
+    cmd.setMode != null
+}
+
+isAnyFileTypeCommand(cmd) if {
+    cmd.updateImageMarkers != null
+}
+
+commandPathIsNotSupported contains cmd if {
+    some cmd in fileCommands
+    not isAnyFileTypeCommand(cmd)
+    not isSupportedPath(cmd.path)
+}
+
+# Paths are only restricted by GitLab claims, requests authenticated by other providers need a custom policy
+violations contains "requests without GitLab claims are not allowed by the default policy" if {
+    not gitLabProjectPath
 }
 
 violations contains msg if {
@@ -20,6 +86,21 @@ violations contains msg if {
 }
 
 violations contains msg if {
-	some cmd in commandPathIsNotYaml
-    msg := sprintf("path %q is not a YAML file", [cmd.path])
+	some cmd in templatePathNotPrefixOfGitLabProjectPath
+    msg := sprintf("template path %q is not a prefix of GitLab project path (%q)", [cmd.createFromTemplate.templatePath, gitLabProjectPath])
+}
+
+violations contains msg if {
+	some cmd in copyFromPathNotPrefixOfGitLabProjectPath
+    msg := sprintf("copy from path %q is not a prefix of GitLab project path (%q)", [cmd.copyFile.from, gitLabProjectPath])
+}
+
+violations contains msg if {
+	some cmd in valueFromPathNotPrefixOfGitLabProjectPath
+    msg := sprintf("value from path %q is not a prefix of GitLab project path (%q)", [cmd.setField.valueFrom.path, gitLabProjectPath])
+}
+
+violations contains msg if {
+	some cmd in commandPathIsNotSupported
+    msg := sprintf("path %q is not a supported file type", [cmd.path])
 }
@@ -29,3 +29,174 @@ test_commands_path_doesnt_match_claim_project_path if {
     }
     v[_] == "path \"my-group/other-project/release.yaml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
 }
+
+test_commands_path_json_file if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/appsettings.json"
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_commands_path_env_files if {
+    every path in ["my-group/my-project/.env", "my-group/my-project/.env.production", "my-group/my-project/app.properties"] {
+        count(violations) == 0 with input as {
+            "repo": "infra-test",
+            "patchRequest": {
+                "commands": [{
+                    "path": path
+                }]
+            },
+            "authCtx": {
+                "gitLabClaims": {"project_path": "my-group/my-project"}
+            }
+        }
+    }
+}
+
+test_commands_path_unsupported_file if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/app.ini"
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "path \"my-group/my-project/app.ini\" is not a supported file type"
+}
+
+test_commands_path_any_file_type if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/favicon.ico",
+                "createFile": {"content": "AAABAA==", "encoding": "base64"}
+            }, {
+                "path": "my-group/my-project/app.ini",
+                "deleteFile": {}
+            }, {
+                "path": "my-group/my-project/deploy.sh",
+                "setMode": {"mode": "0755"}
+            }, {
+                "path": "my-group/my-project/deploy/",
+                "updateImageMarkers": {"policy": "flux-system:my-project", "image": "registry.example.com/my-project", "tag": "1.2.3"}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_create_from_template_path_prefixed_with_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/Dockerfile",
+                "createFromTemplate": {"templatePath": "my-group/my-project/templates/Dockerfile.tmpl", "values": {}}
+            }, {
+                "path": "my-group/my-project/README.md",
+                "createFromTemplate": {"template": "# {{ .name }}", "templatePath": "", "values": {"name": "test"}}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_create_from_template_path_not_prefixed_with_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/secrets.yml",
+                "createFromTemplate": {"templatePath": "other-group/other-project/secrets.yml", "values": {}}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "template path \"other-group/other-project/secrets.yml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
+}
+
+test_copy_file_from_path_prefixed_with_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/production/",
+                "copyFile": {"from": "my-group/my-project/staging/", "overwrite": true}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_copy_file_from_path_not_prefixed_with_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/secrets.yml",
+                "copyFile": {"from": "other-group/other-project/secrets.yml", "overwrite": false}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "copy from path \"other-group/other-project/secrets.yml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
+}
+
+test_set_field_value_from_path_not_prefixed_with_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/production.yml",
+                "setField": {"field": "image.tag", "valueFrom": {"path": "other-group/other-project/staging.yml", "field": "image.tag"}}
+            }, {
+                "path": "my-group/my-project/production.yml",
+                "setField": {"field": "image.repository", "valueFrom": {"path": "", "field": "staging.image.repository"}}
+            }]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    count(v) == 1
+    v[_] == "value from path \"other-group/other-project/staging.yml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
+}
+
+test_request_without_gitlab_claims if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "patchRequest": {
+            "commands": [{
+                "path": "my-group/my-project/release.yaml"
+            }]
+        },
+        "authCtx": {
+            "provider": "mtls",
+            "gitLabClaims": null,
+            "clientCertificate": {"commonName": "deployer"}
+        }
+    }
+    v[_] == "requests without GitLab claims are not allowed by the default policy"
+}
@@ -0,0 +1,23 @@
+package vignet.request.read
+import future.keywords
+
+readableResources := {"promotions", "history", "file"}
+
+gitLabProjectPath := input.authCtx.gitLabClaims.project_path
+
+violations contains msg if {
+    not input.readRequest.resource in readableResources
+    msg := sprintf("resource %q cannot be read", [input.readRequest.resource])
+}
+
+# Files are only restricted by GitLab claims, requests authenticated by other providers need a custom policy
+violations contains "files cannot be read without GitLab claims by the default policy" if {
+    input.readRequest.resource == "file"
+    not gitLabProjectPath
+}
+
+violations contains msg if {
+    input.readRequest.resource == "file"
+    not startswith(input.readRequest.path, sprintf("%s/", [gitLabProjectPath]))
+    msg := sprintf("path %q is not a prefix of GitLab project path (%q)", [input.readRequest.path, gitLabProjectPath])
+}
@@ -0,0 +1,66 @@
+package vignet.request.read
+import future.keywords
+
+test_read_promotions if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "promotions"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_read_history if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "history"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_read_unknown_resource if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "secrets"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "resource \"secrets\" cannot be read"
+}
+
+test_read_file_prefixed_with_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "file", "path": "my-group/my-project/release.yml", "field": "image.tag"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_read_file_not_prefixed_with_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "file", "path": "my-group/other-project/release.yml"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "path \"my-group/other-project/release.yml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
+}
+
+test_read_file_without_gitlab_claims if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "readRequest": {"resource": "file", "path": "my-group/my-project/release.yml"},
+        "authCtx": {
+            "provider": "mtls",
+            "gitLabClaims": null
+        }
+    }
+    v[_] == "files cannot be read without GitLab claims by the default policy"
+}
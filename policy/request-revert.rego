@@ -0,0 +1,7 @@
+package vignet.request.revert
+import future.keywords
+
+# Reverts restore the files changed by a patch, so they need the same permissions as the reverted patch
+violations contains msg if {
+    some msg in data.vignet.request.patch.violations
+}
@@ -0,0 +1,33 @@
+package vignet.request.revert
+import future.keywords
+
+test_revert_of_patch_in_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "revertRequest": {"commit": "0123456789abcdef0123456789abcdef01234567", "branch": "main"},
+        "patchRequest": {
+            "commands": [
+                {"path": "my-group/my-project/release.yml"}
+            ]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_revert_of_patch_outside_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "revertRequest": {"commit": "0123456789abcdef0123456789abcdef01234567", "branch": "main"},
+        "patchRequest": {
+            "commands": [
+                {"path": "other-group/other-project/release.yml"}
+            ]
+        },
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "path \"other-group/other-project/release.yml\" is not a prefix of GitLab project path (\"my-group/my-project\")"
+}
@@ -0,0 +1,9 @@
+package vignet.request.tag
+import future.keywords
+
+gitLabProjectPath := input.authCtx.gitLabClaims.project_path
+
+violations contains msg if {
+    not startswith(input.tagRequest.name, sprintf("%s/", [gitLabProjectPath]))
+    msg := sprintf("tag %q is not prefixed with GitLab project path (%q)", [input.tagRequest.name, gitLabProjectPath])
+}
@@ -0,0 +1,23 @@
+package vignet.request.tag
+import future.keywords
+
+test_tag_prefixed_with_claim_project_path if {
+    count(violations) == 0 with input as {
+        "repo": "infra-test",
+        "tagRequest": {"name": "my-group/my-project/v1.2.3"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+}
+
+test_tag_not_prefixed_with_claim_project_path if {
+    v := violations with input as {
+        "repo": "infra-test",
+        "tagRequest": {"name": "v1.2.3"},
+        "authCtx": {
+            "gitLabClaims": {"project_path": "my-group/my-project"}
+        }
+    }
+    v[_] == "tag \"v1.2.3\" is not prefixed with GitLab project path (\"my-group/my-project\")"
+}
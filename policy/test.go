@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/tester"
+)
+
+// RunTests runs the Rego unit tests (rules prefixed with `test_`) of a bundle like `opa test`.
+func RunTests(ctx context.Context, b *bundle.Bundle) ([]*tester.Result, error) {
+	store := inmem.New()
+	txn, err := store.NewTransaction(ctx, storage.WriteParams)
+	if err != nil {
+		return nil, fmt.Errorf("creating transaction: %w", err)
+	}
+	defer store.Abort(ctx, txn)
+
+	ch, err := tester.NewRunner().
+		SetStore(store).
+		SetBundles(map[string]*bundle.Bundle{"policy": b}).
+		RunTests(ctx, txn)
+	if err != nil {
+		return nil, fmt.Errorf("running tests: %w", err)
+	}
+
+	var results []*tester.Result
+	for result := range ch {
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Fixture is a policy input with the expected violations. Fixtures are loaded from JSON files, e.g.:
+//
+//	{
+//	  "package": "vignet.request.patch",
+//	  "input": {"repo": "my-repo", "authCtx": {...}, "patchRequest": {...}},
+//	  "expectedViolations": ["..."]
+//	}
+type Fixture struct {
+	// Name of the fixture, defaults to the filename.
+	Name string `json:"name"`
+	// Package that defines the violations, defaults to "vignet.request.patch".
+	Package string `json:"package"`
+	// Input is the policy input as passed by vignet.
+	Input map[string]any `json:"input"`
+	// ExpectedViolations are the expected violation messages in any order, empty if the input is allowed.
+	ExpectedViolations []string `json:"expectedViolations"`
+}
+
+// FixtureResult is the result of evaluating a fixture.
+type FixtureResult struct {
+	Fixture    Fixture
+	Violations []string
+	Error      error
+}
+
+// Pass returns true if the fixture was evaluated without error and the violations match the expected violations.
+func (r FixtureResult) Pass() bool {
+	if r.Error != nil || len(r.Violations) != len(r.Fixture.ExpectedViolations) {
+		return false
+	}
+	expected := append([]string(nil), r.Fixture.ExpectedViolations...)
+	sort.Strings(expected)
+	for i := range expected {
+		if expected[i] != r.Violations[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadFixtures loads fixtures from all JSON files in a directory (non-recursive).
+func LoadFixtures(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	fixtures := make([]Fixture, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading fixture: %w", err)
+		}
+		var fixture Fixture
+		err = json.Unmarshal(data, &fixture)
+		if err != nil {
+			return nil, fmt.Errorf("decoding fixture %s: %w", path, err)
+		}
+		if fixture.Name == "" {
+			fixture.Name = filepath.Base(path)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// RunFixtures evaluates the violations of the fixtures with a bundle.
+func RunFixtures(ctx context.Context, b *bundle.Bundle, fixtures []Fixture) []FixtureResult {
+	results := make([]FixtureResult, len(fixtures))
+	for i, fixture := range fixtures {
+		pkg := fixture.Package
+		if pkg == "" {
+			pkg = "vignet.request.patch"
+		}
+		violations, err := evalFixture(ctx, b, pkg, fixture.Input)
+		results[i] = FixtureResult{
+			Fixture:    fixture,
+			Violations: violations,
+			Error:      err,
+		}
+	}
+	return results
+}
+
+func evalFixture(ctx context.Context, b *bundle.Bundle, pkg string, input map[string]any) ([]string, error) {
+	rs, err := rego.New(
+		rego.Query(fmt.Sprintf("data.%s.violations[msg]", pkg)),
+		rego.ParsedBundle("policy", b),
+		rego.Input(input),
+	).Eval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating query: %w", err)
+	}
+
+	violations := []string{}
+	for _, result := range rs {
+		msg, ok := result.Bindings["msg"].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string binding \"msg\" for query result")
+		}
+		violations = append(violations, msg)
+	}
+	sort.Strings(violations)
+	return violations, nil
+}
@@ -0,0 +1,65 @@
+package policy_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/policy"
+)
+
+func TestRunTests(t *testing.T) {
+	b, err := policy.LoadDefaultBundle()
+	require.NoError(t, err)
+
+	results, err := policy.RunTests(context.Background(), b)
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	for _, result := range results {
+		assert.True(t, result.Pass(), result.String())
+	}
+}
+
+func TestRunFixtures(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "allowed.json"), []byte(`{
+		"input": {
+			"repo": "infra",
+			"patchRequest": {"commands": [{"path": "my-group/my-project/release.yml"}]},
+			"authCtx": {"gitLabClaims": {"project_path": "my-group/my-project"}}
+		}
+	}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "denied.json"), []byte(`{
+		"name": "other project",
+		"input": {
+			"repo": "infra",
+			"patchRequest": {"commands": [{"path": "my-group/other-project/release.yml"}]},
+			"authCtx": {"gitLabClaims": {"project_path": "my-group/my-project"}}
+		},
+		"expectedViolations": ["some other violation"]
+	}`), 0644))
+
+	fixtures, err := policy.LoadFixtures(dir)
+	require.NoError(t, err)
+	require.Len(t, fixtures, 2)
+	assert.Equal(t, "allowed.json", fixtures[0].Name)
+	assert.Equal(t, "other project", fixtures[1].Name)
+
+	b, err := policy.LoadDefaultBundle()
+	require.NoError(t, err)
+
+	results := policy.RunFixtures(context.Background(), b, fixtures)
+	require.Len(t, results, 2)
+
+	require.NoError(t, results[0].Error)
+	assert.True(t, results[0].Pass())
+	assert.Empty(t, results[0].Violations)
+
+	require.NoError(t, results[1].Error)
+	assert.False(t, results[1].Pass())
+	assert.Equal(t, []string{`path "my-group/other-project/release.yml" is not a prefix of GitLab project path ("my-group/my-project")`}, results[1].Violations)
+}
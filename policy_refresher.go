@@ -0,0 +1,139 @@
+package vignet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/networkteam/vignet/policy"
+)
+
+// PolicyRefresher loads a policy bundle from a URL and periodically refreshes it, so policy updates roll out without
+// restarting vignet.
+//
+// A refreshed bundle is only activated if it can be prepared and passes the check (e.g. SelfTest), otherwise the
+// current policy stays active.
+type PolicyRefresher struct {
+	url    string
+	client *http.Client
+	header http.Header
+	check  func(ctx context.Context, authorizer *RegoAuthorizer) error
+	opts   []RegoAuthorizerOption
+
+	mx         sync.Mutex
+	etag       string
+	authorizer *RegoAuthorizer
+}
+
+// PolicyRefresherOption configures optional behavior of a PolicyRefresher.
+type PolicyRefresherOption func(p *PolicyRefresher)
+
+// WithPolicyBearerToken sends the token in the Authorization header when fetching the bundle.
+func WithPolicyBearerToken(token string) PolicyRefresherOption {
+	return func(p *PolicyRefresher) {
+		p.header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithPolicyCheck sets a check that a new bundle must pass before it is activated.
+func WithPolicyCheck(check func(ctx context.Context, authorizer *RegoAuthorizer) error) PolicyRefresherOption {
+	return func(p *PolicyRefresher) {
+		p.check = check
+	}
+}
+
+// WithPolicyAuthorizerOptions sets options for building the authorizer of a bundle (e.g. WithRegoData).
+func WithPolicyAuthorizerOptions(opts ...RegoAuthorizerOption) PolicyRefresherOption {
+	return func(p *PolicyRefresher) {
+		p.opts = opts
+	}
+}
+
+// WithPolicyHTTPClient sets the HTTP client for fetching the bundle.
+func WithPolicyHTTPClient(client *http.Client) PolicyRefresherOption {
+	return func(p *PolicyRefresher) {
+		p.client = client
+	}
+}
+
+// NewPolicyRefresher creates a new PolicyRefresher for a bundle URL. Refresh must be called once to load the initial
+// bundle before the authorizer can be used.
+func NewPolicyRefresher(url string, opts ...PolicyRefresherOption) *PolicyRefresher {
+	p := &PolicyRefresher{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+		header: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Authorizer returns the authorizer with the active policy, it is nil until a bundle was loaded.
+func (p *PolicyRefresher) Authorizer() *RegoAuthorizer {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	return p.authorizer
+}
+
+// Refresh fetches the bundle and activates it if it changed. It returns true if a new bundle was activated.
+func (p *PolicyRefresher) Refresh(ctx context.Context) (bool, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	b, etag, err := policy.FetchBundle(ctx, p.client, p.url, p.header, p.etag)
+	if errors.Is(err, policy.ErrBundleNotModified) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("fetching bundle: %w", err)
+	}
+
+	candidate, err := NewRegoAuthorizer(ctx, b, p.opts...)
+	if err != nil {
+		return false, fmt.Errorf("building authorizer: %w", err)
+	}
+	if p.check != nil {
+		if err := p.check(ctx, candidate); err != nil {
+			return false, fmt.Errorf("checking bundle: %w", err)
+		}
+	}
+
+	if p.authorizer == nil {
+		p.authorizer = candidate
+	} else {
+		p.authorizer.Swap(candidate)
+	}
+	p.etag = etag
+
+	return true, nil
+}
+
+// Run refreshes the bundle in the given interval until the context is done. Errors are logged and the current policy
+// stays active.
+func (p *PolicyRefresher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			updated, err := p.Refresh(ctx)
+			if err != nil {
+				log.WithField("policyUrl", p.url).WithError(err).Error("Failed to refresh policy bundle, keeping current policy")
+				continue
+			}
+			if updated {
+				log.WithField("policyUrl", p.url).Info("Activated updated policy bundle")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
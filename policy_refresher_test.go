@@ -0,0 +1,95 @@
+package vignet_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestPolicyRefresher_Refresh(t *testing.T) {
+	var (
+		mx       sync.Mutex
+		revision string
+		module   string
+		requests int
+	)
+	setBundle := func(rev, violation string) {
+		mx.Lock()
+		defer mx.Unlock()
+		revision = rev
+		module = "package vignet.config\nimport future.keywords\n\nviolations contains \"" + violation + "\" if { true }\n"
+	}
+	setBundle("v1", "violation of v1")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		requests++
+
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		etag := `"` + revision + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var buf bytes.Buffer
+		err := bundle.NewWriter(&buf).Write(*loadTestBundle(t, module))
+		require.NoError(t, err)
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	var checkErr error
+	refresher := vignet.NewPolicyRefresher(
+		srv.URL+"/bundle.tar.gz",
+		vignet.WithPolicyBearerToken("s3cr3t"),
+		vignet.WithPolicyCheck(func(ctx context.Context, authorizer *vignet.RegoAuthorizer) error {
+			return checkErr
+		}),
+	)
+	ctx := context.Background()
+
+	updated, err := refresher.Refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	authorizer := refresher.Authorizer()
+	require.NotNil(t, authorizer)
+	assert.ErrorContains(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation of v1")
+
+	// Unchanged bundle is not activated again
+	updated, err = refresher.Refresh(ctx)
+	require.NoError(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, 2, requests)
+
+	// Bundle failing the check keeps the current policy
+	setBundle("v2", "violation of v2")
+	checkErr = errors.New("self-test failed")
+	updated, err = refresher.Refresh(ctx)
+	require.EqualError(t, err, "checking bundle: self-test failed")
+	assert.False(t, updated)
+	assert.ErrorContains(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation of v1")
+
+	// Bundle passing the check is activated in the same authorizer
+	checkErr = nil
+	updated, err = refresher.Refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Same(t, authorizer, refresher.Authorizer())
+	assert.ErrorContains(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation of v2")
+}
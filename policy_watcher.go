@@ -0,0 +1,126 @@
+package vignet
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/networkteam/vignet/policy"
+)
+
+// PolicyWatcher watches a local policy bundle directory and activates the bundle in the authorizer when files change,
+// so policy iteration doesn't require a restart.
+//
+// The directory is polled for changes of file names, sizes and modification times. A changed bundle is only activated
+// if it compiles and passes the check (e.g. SelfTest), otherwise the current policy stays active.
+type PolicyWatcher struct {
+	path       string
+	authorizer *RegoAuthorizer
+	check      func(ctx context.Context, authorizer *RegoAuthorizer) error
+	opts       []RegoAuthorizerOption
+
+	fingerprint string
+}
+
+// NewPolicyWatcher creates a new PolicyWatcher for the bundle at path that was loaded into authorizer.
+// The check is optional, the options are used for building the authorizer of a changed bundle.
+func NewPolicyWatcher(path string, authorizer *RegoAuthorizer, check func(ctx context.Context, authorizer *RegoAuthorizer) error, opts ...RegoAuthorizerOption) (*PolicyWatcher, error) {
+	fingerprint, err := bundleFingerprint(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PolicyWatcher{
+		path:        path,
+		authorizer:  authorizer,
+		check:       check,
+		opts:        opts,
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// Reload loads the bundle and activates it if files changed since the last reload. It returns true if the bundle was
+// activated.
+//
+// A failed reload is not retried until files change again.
+func (w *PolicyWatcher) Reload(ctx context.Context) (bool, error) {
+	fingerprint, err := bundleFingerprint(w.path)
+	if err != nil {
+		return false, err
+	}
+	if fingerprint == w.fingerprint {
+		return false, nil
+	}
+	w.fingerprint = fingerprint
+
+	b, err := policy.LoadBundle(w.path)
+	if err != nil {
+		return false, fmt.Errorf("loading bundle: %w", err)
+	}
+	candidate, err := NewRegoAuthorizer(ctx, b, w.opts...)
+	if err != nil {
+		return false, fmt.Errorf("building authorizer: %w", err)
+	}
+	if w.check != nil {
+		if err := w.check(ctx, candidate); err != nil {
+			return false, fmt.Errorf("checking bundle: %w", err)
+		}
+	}
+
+	w.authorizer.Swap(candidate)
+
+	return true, nil
+}
+
+// Run checks for changes in the given interval until the context is done. Errors (e.g. compile errors of the policy)
+// are logged and the current policy stays active.
+func (w *PolicyWatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			updated, err := w.Reload(ctx)
+			if err != nil {
+				log.WithField("policyPath", w.path).WithError(err).Error("Failed to reload policy bundle, keeping current policy")
+				continue
+			}
+			if updated {
+				log.WithField("policyPath", w.path).Info("Activated changed policy bundle")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// bundleFingerprint hashes the names, sizes and modification times of all files in the path.
+func bundleFingerprint(path string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Follow symlinks, e.g. for files of a mounted Kubernetes ConfigMap
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%d\x00%d\n", p, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading policy files: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
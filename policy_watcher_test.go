@@ -0,0 +1,58 @@
+package vignet_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/policy"
+)
+
+func TestPolicyWatcher_Reload(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Now()
+	writePolicy := func(module string) {
+		path := filepath.Join(dir, "config.rego")
+		require.NoError(t, os.WriteFile(path, []byte(module), 0644))
+		// Do not depend on the resolution of modification times
+		modified = modified.Add(time.Second)
+		require.NoError(t, os.Chtimes(path, modified, modified))
+	}
+	writePolicy("package vignet.config\nimport future.keywords\n\nviolations contains \"violation of v1\" if { true }\n")
+
+	ctx := context.Background()
+	b, err := policy.LoadBundle(dir)
+	require.NoError(t, err)
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, b)
+	require.NoError(t, err)
+
+	watcher, err := vignet.NewPolicyWatcher(dir, authorizer, nil)
+	require.NoError(t, err)
+
+	updated, err := watcher.Reload(ctx)
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	// Compile errors keep the current policy and are not reported again until files change
+	writePolicy("package vignet.config\n\nviolations contains")
+	updated, err = watcher.Reload(ctx)
+	require.Error(t, err)
+	assert.False(t, updated)
+	assert.ErrorContains(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation of v1")
+
+	updated, err = watcher.Reload(ctx)
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	writePolicy("package vignet.config\nimport future.keywords\n\nviolations contains \"violation of v2\" if { true }\n")
+	updated, err = watcher.Reload(ctx)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.ErrorContains(t, authorizer.CheckConfig(ctx, vignet.Config{}), "violation of v2")
+}
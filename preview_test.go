@@ -0,0 +1,98 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_Preview(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.0.0\n",
+	}, gitserver.Options{})
+	initialCommit := gitRepoHeadCommit(t, fs)
+
+	// Patches are denied, previews are allowed
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), loadTestBundle(t, `
+package vignet.request.patch
+
+violations := {"patches are disabled"}
+`, `
+package vignet.request.preview
+
+violations := set()
+`))
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+	body := `{
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}],
+		"dryRun": false
+	}`
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "patches are disabled")
+
+	req = httptest.NewRequest("POST", "/patch/e2e-test/preview", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Commit   string `json:"commit"`
+		DryRun   bool   `json:"dryRun"`
+		Diff     string `json:"diff"`
+		Commands []struct {
+			Field    string `json:"field"`
+			OldValue any    `json:"oldValue"`
+			NewValue any    `json:"newValue"`
+		} `json:"commands"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Empty(t, res.Commit)
+	assert.True(t, res.DryRun)
+	assert.Contains(t, res.Diff, "-  tag: 1.0.0\n+  tag: 1.1.0\n")
+	require.Len(t, res.Commands, 1)
+	assert.Equal(t, "image.tag", res.Commands[0].Field)
+	assert.Equal(t, "1.0.0", res.Commands[0].OldValue)
+	assert.Equal(t, "1.1.0", res.Commands[0].NewValue)
+
+	// The preview was not committed
+	assert.Equal(t, initialCommit.Hash, gitRepoHeadCommit(t, fs).Hash)
+}
+
+func TestHandler_PreviewFallsBackToPatchPolicy(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/other-project/release.yml": "image:\n  tag: 1.0.0\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test/preview", strings.NewReader(`{
+		"commands": [{"path": "my-group/other-project/release.yml", "setField": {"field": "image.tag", "value": "1.1.0"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "is not a prefix of GitLab project path")
+}
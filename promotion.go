@@ -0,0 +1,274 @@
+package vignet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Trailers added to the commit message of a promotion.
+const (
+	trailerEnvironment             = "Vignet-Environment"
+	trailerPromotedFrom            = "Vignet-Promoted-From"
+	trailerPromotedFromEnvironment = "Vignet-Promoted-From-Environment"
+)
+
+// patchRequestPromotion marks a patch as a promotion of a commit, e.g. from a staging to a production environment.
+type patchRequestPromotion struct {
+	// Environment the patch promotes to (optional).
+	Environment string `json:"environment"`
+	// PromotedFrom references the source of the promotion.
+	PromotedFrom promotionSource `json:"promotedFrom"`
+}
+
+type promotionSource struct {
+	// Repo of the source commit, defaults to the repository of the patch.
+	Repo string `json:"repo,omitempty"`
+	// Commit is the full hash of the source commit.
+	Commit string `json:"commit"`
+	// Environment of the source commit (optional).
+	Environment string `json:"environment,omitempty"`
+}
+
+var (
+	commitHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	// promotionNamePattern restricts repositories and environments to values that are safe for trailers.
+	promotionNamePattern = regexp.MustCompile(`^[\w.-]+$`)
+)
+
+func (p patchRequestPromotion) Validate() error {
+	if p.Environment != "" && !promotionNamePattern.MatchString(p.Environment) {
+		return fmt.Errorf("'environment' must only contain letters, digits, '_', '.' and '-'")
+	}
+	if !commitHashPattern.MatchString(p.PromotedFrom.Commit) {
+		return fmt.Errorf("'promotedFrom.commit' must be a full commit hash")
+	}
+	if p.PromotedFrom.Repo != "" && !promotionNamePattern.MatchString(p.PromotedFrom.Repo) {
+		return fmt.Errorf("'promotedFrom.repo' must only contain letters, digits, '_', '.' and '-'")
+	}
+	if p.PromotedFrom.Environment != "" && !promotionNamePattern.MatchString(p.PromotedFrom.Environment) {
+		return fmt.Errorf("'promotedFrom.environment' must only contain letters, digits, '_', '.' and '-'")
+	}
+	return nil
+}
+
+// appendTrailers appends the trailers of the promotion to the commit message.
+func (p patchRequestPromotion) appendTrailers(message string, repoName string) string {
+	source := p.PromotedFrom
+	if source.Repo == "" {
+		source.Repo = repoName
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimRight(message, "\n"))
+	sb.WriteString("\n\n")
+	if p.Environment != "" {
+		fmt.Fprintf(&sb, "%s: %s\n", trailerEnvironment, p.Environment)
+	}
+	fmt.Fprintf(&sb, "%s: %s@%s\n", trailerPromotedFrom, source.Repo, source.Commit)
+	if source.Environment != "" {
+		fmt.Fprintf(&sb, "%s: %s\n", trailerPromotedFromEnvironment, source.Environment)
+	}
+	return sb.String()
+}
+
+// verifyPromotedCommit checks that the source commit of a promotion within the same repository exists.
+func verifyPromotedCommit(r *git.Repository, repoName string, promotion *patchRequestPromotion) error {
+	if promotion == nil || (promotion.PromotedFrom.Repo != "" && promotion.PromotedFrom.Repo != repoName) {
+		return nil
+	}
+	_, err := r.CommitObject(plumbing.NewHash(promotion.PromotedFrom.Commit))
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return clientError{fmt.Errorf("promoted commit %s not found in repository", promotion.PromotedFrom.Commit), http.StatusUnprocessableEntity}
+	}
+	if err != nil {
+		return fmt.Errorf("getting promoted commit: %w", err)
+	}
+	return nil
+}
+
+type promotionResponse struct {
+	// Commit is the hash of the commit of the promotion.
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+	// Message is the first line of the commit message.
+	Message      string          `json:"message"`
+	Environment  string          `json:"environment,omitempty"`
+	PromotedFrom promotionSource `json:"promotedFrom"`
+}
+
+type promotionsResponse struct {
+	Promotions []promotionResponse `json:"promotions"`
+}
+
+// promotionFromCommit parses the trailers of a commit, it returns nil if the commit is not a promotion.
+func promotionFromCommit(c *object.Commit) *promotionResponse {
+	var (
+		p     promotionResponse
+		found bool
+	)
+	for _, line := range strings.Split(c.Message, "\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case trailerEnvironment:
+			p.Environment = value
+		case trailerPromotedFrom:
+			repo, commit, ok := strings.Cut(value, "@")
+			if !ok {
+				continue
+			}
+			p.PromotedFrom.Repo = repo
+			p.PromotedFrom.Commit = commit
+			found = true
+		case trailerPromotedFromEnvironment:
+			p.PromotedFrom.Environment = value
+		}
+	}
+	if !found {
+		return nil
+	}
+	p.Commit = c.Hash.String()
+	p.Time = c.Committer.When
+	p.Message = strings.SplitN(c.Message, "\n", 2)[0]
+	return &p
+}
+
+const (
+	defaultPromotionsLimit = 50
+	maxPromotionsLimit     = 1000
+)
+
+// promotions lists promotions in the history of the default branch of a repository (newest first).
+func (h *Handler) promotions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	repoName := repoParam(r)
+
+	query := r.URL.Query()
+	limit := defaultPromotionsLimit
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxPromotionsLimit {
+			respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("'limit' must be a number between 1 and %d", maxPromotionsLimit), http.StatusBadRequest})
+			return
+		}
+		limit = l
+	}
+	environment := query.Get("environment")
+	promotedFrom := query.Get("promotedFrom")
+
+	_, repoConfig, exists := h.lookupRepository(repoName)
+	if !exists {
+		log.WithField("repo", repoName).Warn("Unknown repository")
+		respondError(w, r, "Unknown repository", clientError{fmt.Errorf("repository %q not configured", repoName), http.StatusNotFound})
+		return
+	}
+
+	if !h.authorizeRead(w, r, repoName, readRequest{Resource: "promotions"}) {
+		return
+	}
+
+	repoConfig, err := h.exchangeCredential(ctx, r, repoName, repoConfig)
+	if err != nil {
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Failed to exchange credential")
+		respondError(w, r, "Credential exchange failed", err)
+		return
+	}
+
+	res := promotionsResponse{
+		Promotions: []promotionResponse{},
+	}
+	err = h.walkHistory(ctx, repoConfig, "", func(c *object.Commit) bool {
+		p := promotionFromCommit(c)
+		if p == nil {
+			return true
+		}
+		if environment != "" && p.Environment != environment {
+			return true
+		}
+		if promotedFrom != "" && !strings.HasPrefix(p.PromotedFrom.Commit, promotedFrom) {
+			return true
+		}
+		res.Promotions = append(res.Promotions, *p)
+		return len(res.Promotions) < limit
+	})
+	if err != nil {
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Error("Failed to read promotions")
+		respondError(w, r, "Reading promotions failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// walkHistory clones the repository without a worktree and calls fn for each commit of the branch (newest first)
+// until it returns false. The default branch is used if branch is empty.
+func (h *Handler) walkHistory(ctx context.Context, repoConfig RepositoryConfig, branch string, fn func(c *object.Commit) bool) error {
+	cloneOptions := &git.CloneOptions{
+		URL:          repoConfig.URL,
+		Auth:         repoConfig.authMethod(),
+		SingleBranch: true,
+		NoCheckout:   true,
+	}
+	if branch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+	var r *git.Repository
+	cloneStart := time.Now()
+	err := withTimeout(ctx, "clone", h.config.Timeouts.clone(), func(ctx context.Context) (err error) {
+		r, err = git.CloneContext(ctx, memory.NewStorage(), nil, cloneOptions)
+		return err
+	})
+	accessLogEntryFromCtx(ctx).recordGitTiming("clone", time.Since(cloneStart))
+	if errors.Is(err, git.NoMatchingRefSpecError{}) {
+		return clientError{fmt.Errorf("branch %q not found", branch), http.StatusNotFound}
+	}
+	if err != nil {
+		return fmt.Errorf("cloning repository: %w", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("getting HEAD of repository: %w", err)
+	}
+	commits, err := r.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return fmt.Errorf("getting log: %w", err)
+	}
+	defer commits.Close()
+
+	for {
+		c, err := commits.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("iterating log: %w", err)
+		}
+		if !fn(c) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_Promotions(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/staging.yml":    "image: v1",
+		"my-group/my-project/production.yml": "image: v1",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: gitSrv.URL},
+		},
+	})
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := patch(`{"commit": {"message": "Deploy v2 to staging"}, "commands": [{"path": "my-group/my-project/staging.yml", "setField": {"field": "image", "value": "v2"}}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	stagingCommit := gitRepoHeadCommit(t, fs).Hash.String()
+
+	// The promoted commit must exist in the repository
+	rec = patch(fmt.Sprintf(`{
+		"commit": {"message": "Promote v2 to production"},
+		"promotion": {"environment": "production", "promotedFrom": {"commit": %q, "environment": "staging"}},
+		"commands": [{"path": "my-group/my-project/production.yml", "setField": {"field": "image", "value": "v2"}}]
+	}`, strings.Repeat("a", 40)))
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+
+	rec = patch(fmt.Sprintf(`{
+		"commit": {"message": "Promote v2 to production"},
+		"promotion": {"environment": "production", "promotedFrom": {"commit": %q, "environment": "staging"}},
+		"commands": [{"path": "my-group/my-project/production.yml", "setField": {"field": "image", "value": "v2"}}]
+	}`, stagingCommit))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	headCommit := gitRepoHeadCommit(t, fs)
+	require.Equal(t, "Promote v2 to production\n\n"+
+		"Vignet-Environment: production\n"+
+		"Vignet-Promoted-From: infra@"+stagingCommit+"\n"+
+		"Vignet-Promoted-From-Environment: staging\n", headCommit.Message)
+
+	rec = patch(`{
+		"commit": {"message": "Promote v7 to edge"},
+		"promotion": {"environment": "edge", "promotedFrom": {"repo": "other", "commit": "0123456789abcdef0123456789abcdef01234567"}},
+		"commands": [{"path": "my-group/my-project/staging.yml", "setField": {"field": "image", "value": "v7"}}]
+	}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	listPromotions := func(query string) []map[string]any {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/promotions/infra"+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res struct {
+			Promotions []map[string]any `json:"promotions"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return res.Promotions
+	}
+
+	promotions := listPromotions("")
+	require.Len(t, promotions, 2)
+	require.Equal(t, "edge", promotions[0]["environment"])
+	require.Equal(t, map[string]any{"repo": "other", "commit": "0123456789abcdef0123456789abcdef01234567"}, promotions[0]["promotedFrom"])
+	require.Equal(t, headCommit.Hash.String(), promotions[1]["commit"])
+	require.Equal(t, "Promote v2 to production", promotions[1]["message"])
+	require.Equal(t, map[string]any{"repo": "infra", "commit": stagingCommit, "environment": "staging"}, promotions[1]["promotedFrom"])
+
+	promotions = listPromotions("?environment=production")
+	require.Len(t, promotions, 1)
+	require.Equal(t, headCommit.Hash.String(), promotions[0]["commit"])
+
+	promotions = listPromotions("?promotedFrom=" + stagingCommit[:7])
+	require.Len(t, promotions, 1)
+	require.Equal(t, headCommit.Hash.String(), promotions[0]["commit"])
+
+	promotions = listPromotions("?limit=1")
+	require.Len(t, promotions, 1)
+	require.Equal(t, "edge", promotions[0]["environment"])
+
+	req := httptest.NewRequest("GET", "/promotions/unknown", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+}
+
+func TestHandler_PromotionValidation(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/production.yml": "image: v1",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: gitSrv.URL},
+		},
+	})
+
+	tests := []struct {
+		name          string
+		promotion     string
+		expectedError string
+	}{
+		{
+			name:          "abbreviated commit",
+			promotion:     `{"promotedFrom": {"commit": "0123456"}}`,
+			expectedError: "'promotedFrom.commit' must be a full commit hash",
+		},
+		{
+			name:          "invalid environment",
+			promotion:     `{"environment": "prod\nVignet-Environment: staging", "promotedFrom": {"commit": "0123456789abcdef0123456789abcdef01234567"}}`,
+			expectedError: "'environment' must only contain",
+		},
+		{
+			name:          "invalid repo",
+			promotion:     `{"promotedFrom": {"repo": "a b", "commit": "0123456789abcdef0123456789abcdef01234567"}}`,
+			expectedError: "'promotedFrom.repo' must only contain",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]any{
+				"promotion": json.RawMessage(tc.promotion),
+				"commands": []any{
+					map[string]any{"path": "my-group/my-project/production.yml", "setField": map[string]any{"field": "image", "value": "v2"}},
+				},
+			})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(string(body)))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tc.expectedError)
+		})
+	}
+}
@@ -0,0 +1,459 @@
+// Package properties patches key/value files (.env and Java .properties files) by editing lines in place,
+// so comments, the order of keys and quoting are kept.
+package properties
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Format of a key/value file.
+type Format int
+
+const (
+	// FormatDotenv is a .env file with lines of KEY=value, values can be quoted and lines can start with "export".
+	FormatDotenv Format = iota
+	// FormatJava is a Java .properties file.
+	FormatJava
+)
+
+// FormatOf returns the format of a file by its name, false is returned if the file is not a key/value file.
+func FormatOf(filename string) (Format, bool) {
+	base := path.Base(filename)
+	switch {
+	case strings.HasSuffix(base, ".properties"):
+		return FormatJava, true
+	case base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env"):
+		return FormatDotenv, true
+	default:
+		return 0, false
+	}
+}
+
+type Patcher struct {
+	format  Format
+	data    []byte
+	entries []entry
+}
+
+// entry is a key/value of a (logical) line.
+type entry struct {
+	key string
+	// lineStart and lineEnd are the range of the line including the line break.
+	lineStart, lineEnd int
+	// valueStart and valueEnd are the range of the raw value.
+	valueStart, valueEnd int
+	// separator is the raw text between key and value (e.g. "=" or " = ").
+	separator string
+	// quote of a value in a .env file (0 if not quoted).
+	quote byte
+}
+
+func NewPatcher(r io.Reader, format Format) (*Patcher, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Patcher{
+		format: format,
+		data:   data,
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Get returns the value of the key, the last value is returned if the key is defined multiple times.
+func (p *Patcher) Get(key string) (string, bool) {
+	e := p.lastEntry(key)
+	if e == nil {
+		return "", false
+	}
+	raw := string(p.data[e.valueStart:e.valueEnd])
+	if p.format == FormatJava {
+		return unescapeJava(raw), true
+	}
+	return unquoteDotenv(raw, e.quote), true
+}
+
+// Set sets the value of the key, the quoting of an existing value is kept if possible. A missing key is appended.
+// It returns true if the file changed.
+func (p *Patcher) Set(key, value string) (bool, error) {
+	if err := p.validKey(key); err != nil {
+		return false, err
+	}
+	if current, ok := p.Get(key); ok && current == value {
+		return false, nil
+	}
+
+	if e := p.lastEntry(key); e != nil {
+		var encoded string
+		if p.format == FormatJava {
+			encoded = escapeJava(value, false)
+		} else {
+			encoded = quoteDotenv(value, e.quote)
+		}
+		p.data = splice(p.data, e.valueStart, e.valueEnd, encoded)
+		return true, p.parse()
+	}
+
+	newline := "\n"
+	if bytes.Contains(p.data, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	var line string
+	if p.format == FormatJava {
+		separator := "="
+		if len(p.entries) > 0 && strings.TrimSpace(p.entries[len(p.entries)-1].separator) != "" {
+			// Use the separator style of the file
+			separator = p.entries[len(p.entries)-1].separator
+		}
+		line = escapeJava(key, true) + separator + escapeJava(value, false) + newline
+	} else {
+		line = key + "=" + quoteDotenv(value, 0) + newline
+	}
+	if len(p.data) > 0 && p.data[len(p.data)-1] != '\n' {
+		line = newline + line
+	}
+	p.data = append(p.data, line...)
+	return true, p.parse()
+}
+
+// Remove removes all lines defining the key. It returns true if the file changed.
+func (p *Patcher) Remove(key string) (bool, error) {
+	changed := false
+	for i := len(p.entries) - 1; i >= 0; i-- {
+		e := p.entries[i]
+		if e.key == key {
+			p.data = splice(p.data, e.lineStart, e.lineEnd, "")
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, p.parse()
+}
+
+// Encode writes the file.
+func (p *Patcher) Encode(w io.Writer) error {
+	_, err := w.Write(p.data)
+	return err
+}
+
+func (p *Patcher) lastEntry(key string) *entry {
+	for i := len(p.entries) - 1; i >= 0; i-- {
+		if p.entries[i].key == key {
+			return &p.entries[i]
+		}
+	}
+	return nil
+}
+
+var dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func (p *Patcher) validKey(key string) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if p.format == FormatDotenv && !dotenvKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	return nil
+}
+
+func (p *Patcher) parse() error {
+	var err error
+	if p.format == FormatJava {
+		p.entries, err = parseJava(p.data)
+	} else {
+		p.entries, err = parseDotenv(p.data)
+	}
+	return err
+}
+
+func splice(data []byte, start, end int, replacement string) []byte {
+	result := make([]byte, 0, len(data)-(end-start)+len(replacement))
+	result = append(result, data[:start]...)
+	result = append(result, replacement...)
+	return append(result, data[end:]...)
+}
+
+// lineEnd returns the offset after the line break of the line at pos.
+func lineEnd(data []byte, pos int) int {
+	i := bytes.IndexByte(data[pos:], '\n')
+	if i < 0 {
+		return len(data)
+	}
+	return pos + i + 1
+}
+
+func lineOf(data []byte, offset int) int {
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\f'
+}
+
+func parseDotenv(data []byte) ([]entry, error) {
+	var entries []entry
+	pos := 0
+	for pos < len(data) {
+		lineStart := pos
+		for pos < len(data) && isSpace(data[pos]) {
+			pos++
+		}
+		if pos >= len(data) || data[pos] == '\n' || data[pos] == '\r' || data[pos] == '#' {
+			pos = lineEnd(data, pos)
+			continue
+		}
+		if bytes.HasPrefix(data[pos:], []byte("export ")) {
+			pos += len("export ")
+			for pos < len(data) && isSpace(data[pos]) {
+				pos++
+			}
+		}
+
+		keyStart := pos
+		for pos < len(data) && data[pos] != '=' && data[pos] != '\n' && !isSpace(data[pos]) {
+			pos++
+		}
+		key := string(data[keyStart:pos])
+		sepStart := pos
+		for pos < len(data) && isSpace(data[pos]) {
+			pos++
+		}
+		if pos >= len(data) || data[pos] != '=' || key == "" {
+			return nil, fmt.Errorf("expected KEY=value (at line %d)", lineOf(data, lineStart))
+		}
+		pos++
+		for pos < len(data) && isSpace(data[pos]) {
+			pos++
+		}
+
+		e := entry{key: key, lineStart: lineStart, valueStart: pos, separator: string(data[sepStart:pos])}
+		if pos < len(data) && (data[pos] == '"' || data[pos] == '\'') {
+			// Quoted values can span multiple lines
+			e.quote = data[pos]
+			end := pos + 1
+			for ; end < len(data) && data[end] != e.quote; end++ {
+				if e.quote == '"' && data[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(data) {
+				return nil, fmt.Errorf("unterminated quoted value (at line %d)", lineOf(data, lineStart))
+			}
+			e.valueEnd = end + 1
+		} else {
+			end := pos
+			for end < len(data) && data[end] != '\n' && data[end] != '\r' && !(data[end] == '#' && end > pos && isSpace(data[end-1])) {
+				end++
+			}
+			// Trailing whitespace (before a comment) is not part of the value
+			for end > pos && isSpace(data[end-1]) {
+				end--
+			}
+			e.valueEnd = end
+		}
+		e.lineEnd = lineEnd(data, e.valueEnd)
+		entries = append(entries, e)
+		pos = e.lineEnd
+	}
+	return entries, nil
+}
+
+// unsafeDotenvChars require a value to be quoted.
+const unsafeDotenvChars = " \t\"'#\\$`\n\r"
+
+func quoteDotenv(value string, quote byte) string {
+	switch {
+	case quote == '\'' && !strings.ContainsAny(value, "'"):
+		return "'" + value + "'"
+	case quote == 0 && !strings.ContainsAny(value, unsafeDotenvChars):
+		return value
+	default:
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+		return `"` + r.Replace(value) + `"`
+	}
+}
+
+func unquoteDotenv(raw string, quote byte) string {
+	switch quote {
+	case '\'':
+		return raw[1 : len(raw)-1]
+	case '"':
+		inner := raw[1 : len(raw)-1]
+		var sb strings.Builder
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) {
+				i++
+				switch inner[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 'r':
+					sb.WriteByte('\r')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(inner[i])
+				}
+				continue
+			}
+			sb.WriteByte(inner[i])
+		}
+		return sb.String()
+	default:
+		return raw
+	}
+}
+
+func parseJava(data []byte) ([]entry, error) {
+	var entries []entry
+	pos := 0
+	for pos < len(data) {
+		lineStart := pos
+		for pos < len(data) && isSpace(data[pos]) {
+			pos++
+		}
+		if pos >= len(data) || data[pos] == '\n' || data[pos] == '\r' || data[pos] == '#' || data[pos] == '!' {
+			pos = lineEnd(data, pos)
+			continue
+		}
+
+		// The key ends at the first unescaped separator or whitespace
+		keyStart := pos
+		for pos < len(data) && data[pos] != '=' && data[pos] != ':' && data[pos] != '\n' && data[pos] != '\r' && !isSpace(data[pos]) {
+			if data[pos] == '\\' {
+				pos++
+			}
+			pos++
+		}
+		if pos > len(data) {
+			pos = len(data)
+		}
+		keyEnd := pos
+		for pos < len(data) && isSpace(data[pos]) {
+			pos++
+		}
+		if pos < len(data) && (data[pos] == '=' || data[pos] == ':') {
+			pos++
+			for pos < len(data) && isSpace(data[pos]) {
+				pos++
+			}
+		}
+
+		e := entry{
+			key:        unescapeJava(string(data[keyStart:keyEnd])),
+			lineStart:  lineStart,
+			valueStart: pos,
+			separator:  string(data[keyEnd:pos]),
+		}
+		// The value ends at a line break that is not escaped by an odd number of backslashes
+		end := pos
+		for end < len(data) && data[end] != '\n' && data[end] != '\r' {
+			if data[end] == '\\' {
+				end++
+				if end < len(data) && data[end] == '\r' && end+1 < len(data) && data[end+1] == '\n' {
+					end++
+				}
+			}
+			end++
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		e.valueEnd = end
+		e.lineEnd = lineEnd(data, end)
+		entries = append(entries, e)
+		pos = e.lineEnd
+	}
+	return entries, nil
+}
+
+// escapeJava escapes a key or value, whitespace and separators are escaped in keys and leading whitespace in values.
+func escapeJava(s string, key bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case ' ':
+			if key || i == 0 {
+				sb.WriteString(`\ `)
+			} else {
+				sb.WriteRune(r)
+			}
+		case '=', ':', '#', '!':
+			if key {
+				sb.WriteByte('\\')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// unescapeJava unescapes a raw key or value including continuation lines.
+func unescapeJava(raw string) string {
+	var sb strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' || i+1 >= len(raw) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch raw[i] {
+		case '\r', '\n':
+			// Continuation line, leading whitespace of the next line is skipped
+			if raw[i] == '\r' && i+1 < len(raw) && raw[i+1] == '\n' {
+				i++
+			}
+			for i+1 < len(raw) && isSpace(raw[i+1]) {
+				i++
+			}
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+4 < len(raw) {
+				if r, err := strconv.ParseUint(raw[i+1:i+5], 16, 32); err == nil {
+					sb.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			sb.WriteByte('u')
+		default:
+			sb.WriteByte(raw[i])
+		}
+	}
+	return sb.String()
+}
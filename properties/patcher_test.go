@@ -0,0 +1,255 @@
+package properties_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/properties"
+)
+
+const dotenv = `# Application settings
+APP_NAME=my-app
+export LOG_LEVEL=info # verbose in staging
+GREETING="Hello World"
+PATTERN='^[a-z]+$'
+MULTILINE="first
+second"
+EMPTY=
+`
+
+const javaProperties = `# Database
+db.url = jdbc:postgresql://localhost/app
+db.user = app
+! legacy comment
+message = Hello \
+          World
+key\ with\ spaces = 1
+`
+
+func TestPatcher_Dotenv(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		value        string
+		remove       bool
+		expected     string
+		expectedErr  string
+		expectChange bool
+	}{
+		{
+			name:         "unquoted value",
+			key:          "APP_NAME",
+			value:        "other-app",
+			expected:     strings.Replace(dotenv, "APP_NAME=my-app", "APP_NAME=other-app", 1),
+			expectChange: true,
+		},
+		{
+			name:         "value with export and comment",
+			key:          "LOG_LEVEL",
+			value:        "debug",
+			expected:     strings.Replace(dotenv, "export LOG_LEVEL=info # verbose", "export LOG_LEVEL=debug # verbose", 1),
+			expectChange: true,
+		},
+		{
+			name:         "unquoted value needing quotes",
+			key:          "APP_NAME",
+			value:        "my app",
+			expected:     strings.Replace(dotenv, "APP_NAME=my-app", `APP_NAME="my app"`, 1),
+			expectChange: true,
+		},
+		{
+			name:         "double quoted value",
+			key:          "GREETING",
+			value:        `Hello "vignet"`,
+			expected:     strings.Replace(dotenv, `GREETING="Hello World"`, `GREETING="Hello \"vignet\""`, 1),
+			expectChange: true,
+		},
+		{
+			name:         "single quoted value",
+			key:          "PATTERN",
+			value:        "^[0-9]+$",
+			expected:     strings.Replace(dotenv, `PATTERN='^[a-z]+$'`, `PATTERN='^[0-9]+$'`, 1),
+			expectChange: true,
+		},
+		{
+			name:         "multi-line value",
+			key:          "MULTILINE",
+			value:        "single",
+			expected:     strings.Replace(dotenv, "MULTILINE=\"first\nsecond\"", `MULTILINE="single"`, 1),
+			expectChange: true,
+		},
+		{
+			name:         "empty value",
+			key:          "EMPTY",
+			value:        "set",
+			expected:     strings.Replace(dotenv, "EMPTY=\n", "EMPTY=set\n", 1),
+			expectChange: true,
+		},
+		{
+			name:         "add key",
+			key:          "NEW_KEY",
+			value:        "a value",
+			expected:     dotenv + "NEW_KEY=\"a value\"\n",
+			expectChange: true,
+		},
+		{
+			name:     "unchanged value",
+			key:      "GREETING",
+			value:    "Hello World",
+			expected: dotenv,
+		},
+		{
+			name:         "remove key",
+			key:          "MULTILINE",
+			remove:       true,
+			expected:     strings.Replace(dotenv, "MULTILINE=\"first\nsecond\"\n", "", 1),
+			expectChange: true,
+		},
+		{
+			name:     "remove missing key",
+			key:      "MISSING",
+			remove:   true,
+			expected: dotenv,
+		},
+		{
+			name:        "invalid key",
+			key:         "INVALID KEY",
+			value:       "1",
+			expectedErr: `invalid key "INVALID KEY"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := properties.NewPatcher(strings.NewReader(dotenv), properties.FormatDotenv)
+			require.NoError(t, err)
+
+			var changed bool
+			if tt.remove {
+				changed, err = patcher.Remove(tt.key)
+			} else {
+				changed, err = patcher.Set(tt.key, tt.value)
+			}
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectChange, changed)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expected, sb.String())
+		})
+	}
+}
+
+func TestPatcher_DotenvGet(t *testing.T) {
+	patcher, err := properties.NewPatcher(strings.NewReader(dotenv), properties.FormatDotenv)
+	require.NoError(t, err)
+
+	for key, expected := range map[string]string{
+		"APP_NAME":  "my-app",
+		"LOG_LEVEL": "info",
+		"GREETING":  "Hello World",
+		"PATTERN":   "^[a-z]+$",
+		"MULTILINE": "first\nsecond",
+		"EMPTY":     "",
+	} {
+		value, ok := patcher.Get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, expected, value, key)
+	}
+	_, ok := patcher.Get("MISSING")
+	assert.False(t, ok)
+}
+
+func TestPatcher_Java(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		remove   bool
+		expected string
+	}{
+		{
+			name:     "value with separator style",
+			key:      "db.user",
+			value:    "admin",
+			expected: strings.Replace(javaProperties, "db.user = app", "db.user = admin", 1),
+		},
+		{
+			name:     "value with continuation line",
+			key:      "message",
+			value:    "Hi\nthere",
+			expected: strings.Replace(javaProperties, "message = Hello \\\n          World", `message = Hi\nthere`, 1),
+		},
+		{
+			name:     "escaped key",
+			key:      "key with spaces",
+			value:    "2",
+			expected: strings.Replace(javaProperties, `key\ with\ spaces = 1`, `key\ with\ spaces = 2`, 1),
+		},
+		{
+			name:     "add key",
+			key:      "db.pool:size",
+			value:    " 10",
+			expected: javaProperties + "db.pool\\:size = \\ 10\n",
+		},
+		{
+			name:     "remove key with continuation line",
+			key:      "message",
+			remove:   true,
+			expected: strings.Replace(javaProperties, "message = Hello \\\n          World\n", "", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := properties.NewPatcher(strings.NewReader(javaProperties), properties.FormatJava)
+			require.NoError(t, err)
+
+			var changed bool
+			if tt.remove {
+				changed, err = patcher.Remove(tt.key)
+			} else {
+				changed, err = patcher.Set(tt.key, tt.value)
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expected, sb.String())
+		})
+	}
+}
+
+func TestPatcher_JavaGet(t *testing.T) {
+	patcher, err := properties.NewPatcher(strings.NewReader(javaProperties), properties.FormatJava)
+	require.NoError(t, err)
+
+	value, ok := patcher.Get("message")
+	assert.True(t, ok)
+	assert.Equal(t, "Hello World", value)
+
+	value, ok = patcher.Get("key with spaces")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+}
+
+func TestFormatOf(t *testing.T) {
+	for filename, expected := range map[string]properties.Format{
+		"app/.env":                   properties.FormatDotenv,
+		"app/.env.production":        properties.FormatDotenv,
+		"app/production.env":         properties.FormatDotenv,
+		"app/application.properties": properties.FormatJava,
+	} {
+		format, ok := properties.FormatOf(filename)
+		assert.True(t, ok, filename)
+		assert.Equal(t, expected, format, filename)
+	}
+	_, ok := properties.FormatOf("app/environment.yaml")
+	assert.False(t, ok)
+}
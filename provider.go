@@ -0,0 +1,44 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// changeRequestProvider opens a change request (pull request or merge request) for a pushed branch.
+type changeRequestProvider interface {
+	createChangeRequest(ctx context.Context, repoFullName string, opts changeRequestOptions) (*changeRequest, error)
+}
+
+type changeRequestOptions struct {
+	SourceBranch string
+	TargetBranch string
+	Title        string
+	Description  string
+	// RemoveSourceBranch is only supported by GitLab.
+	RemoveSourceBranch bool
+}
+
+type changeRequest struct {
+	// Number of the pull request or the project-level ID (iid) of a GitLab merge request.
+	Number int
+	URL    string
+}
+
+// newChangeRequestProvider returns the provider of the repository, or nil if no provider is configured.
+// Requests to the API of the provider are sent with the given client.
+func newChangeRequestProvider(repoConfig RepositoryConfig, client *http.Client) (changeRequestProvider, error) {
+	switch repoConfig.Provider {
+	case RepositoryProviderGitea:
+		return newGiteaClient(repoConfig, client)
+	case RepositoryProviderGitLab:
+		return newGitLabClient(repoConfig, client)
+	case RepositoryProviderGitHub:
+		return newGitHubClient(repoConfig, client)
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported provider: %q", repoConfig.Provider)
+	}
+}
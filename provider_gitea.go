@@ -0,0 +1,123 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	netUrl "net/url"
+	"strings"
+)
+
+// tokenHeaderAuth authenticates Git HTTP requests with a token in the Authorization header
+// (e.g. "Authorization: token <token>" for Gitea / Forgejo).
+type tokenHeaderAuth struct {
+	scheme string
+	token  string
+}
+
+func (a *tokenHeaderAuth) Name() string {
+	return "http-token-header-auth"
+}
+
+func (a *tokenHeaderAuth) String() string {
+	return fmt.Sprintf("%s - %s *******", a.Name(), a.scheme)
+}
+
+func (a *tokenHeaderAuth) SetAuth(r *http.Request) {
+	r.Header.Set("Authorization", a.scheme+" "+a.token)
+}
+
+// giteaClient is a minimal client for the Gitea / Forgejo API.
+type giteaClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+var _ changeRequestProvider = &giteaClient{}
+
+func newGiteaClient(repoConfig RepositoryConfig, client *http.Client) (*giteaClient, error) {
+	apiURL := repoConfig.APIURL
+	if apiURL == "" {
+		u, err := netUrl.Parse(repoConfig.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing repository URL: %w", err)
+		}
+		apiURL = fmt.Sprintf("%s://%s/api/v1", u.Scheme, u.Host)
+	}
+
+	return &giteaClient{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  repoConfig.Token,
+		client: client,
+	}, nil
+}
+
+type giteaCreatePullRequestOptions struct {
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// createChangeRequest creates a pull request in the repository with the given full name (owner/name).
+func (c *giteaClient) createChangeRequest(ctx context.Context, repoFullName string, opts changeRequestOptions) (*changeRequest, error) {
+	b, err := json.Marshal(giteaCreatePullRequestOptions{
+		Head:  opts.SourceBranch,
+		Base:  opts.TargetBranch,
+		Title: opts.Title,
+		Body:  opts.Description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/pulls", c.apiURL, repoFullName), bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var pr giteaPullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &changeRequest{
+		Number: pr.Number,
+		URL:    pr.HTMLURL,
+	}, nil
+}
+
+// repositoryFullName returns the path of the repository URL without .git suffix (e.g. "owner/name").
+func repositoryFullName(repoURL string) (string, error) {
+	u, err := netUrl.Parse(repoURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing repository URL: %w", err)
+	}
+	fullName := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if strings.Count(fullName, "/") < 1 {
+		return "", fmt.Errorf("repository URL %q has no owner and name in path", repoURL)
+	}
+	return fullName, nil
+}
@@ -0,0 +1,87 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_GiteaPullRequest(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{Authorization: "token a-gitea-token"})
+
+	var createdPullRequest map[string]string
+	giteaSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.Equal(t, "/api/v1/repos/my-org/infra/pulls", r.URL.Path)
+		require.Equal(t, "token a-gitea-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&createdPullRequest))
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number": 3, "html_url": "https://gitea.example.com/my-org/infra/pulls/3"}`))
+	}))
+	defer giteaSrv.Close()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {
+				URL:      gitSrv.URL + "/my-org/infra.git",
+				Provider: vignet.RepositoryProviderGitea,
+				Token:    "a-gitea-token",
+				APIURL:   giteaSrv.URL + "/api/v1",
+			},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"commit": {"message": "Bump foo\n\nDetails"},
+		"pullRequest": {"sourceBranch": "vignet/bump-foo", "description": "Automated bump"},
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Branch      string `json:"branch"`
+		PullRequest struct {
+			Number int    `json:"number"`
+			URL    string `json:"url"`
+		} `json:"pullRequest"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, "vignet/bump-foo", res.Branch)
+	require.Equal(t, 3, res.PullRequest.Number)
+	require.Equal(t, "https://gitea.example.com/my-org/infra/pulls/3", res.PullRequest.URL)
+
+	require.Equal(t, map[string]string{
+		"head":  "vignet/bump-foo",
+		"base":  "master",
+		"title": "Bump foo",
+		"body":  "Automated bump",
+	}, createdPullRequest)
+
+	// The target branch is unchanged and the source branch contains the commit
+	assertGitRepoHeadCommit(t, fs, "Initial commit")
+	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	defer storer.Close()
+	repo, err := git.Open(storer, nil)
+	require.NoError(t, err)
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("vignet/bump-foo"), true)
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "Bump foo\n\nDetails", commit.Message)
+}
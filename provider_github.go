@@ -0,0 +1,106 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	netUrl "net/url"
+	"strings"
+)
+
+// gitHubClient is a minimal client for the GitHub REST API.
+type gitHubClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+var _ changeRequestProvider = &gitHubClient{}
+
+func newGitHubClient(repoConfig RepositoryConfig, client *http.Client) (*gitHubClient, error) {
+	apiURL, err := gitHubAPIURL(repoConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitHubClient{
+		apiURL: apiURL,
+		token:  repoConfig.Token,
+		client: client,
+	}, nil
+}
+
+// gitHubAPIURL returns the configured API URL or the API URL for the host of the repository.
+func gitHubAPIURL(repoConfig RepositoryConfig) (string, error) {
+	apiURL := repoConfig.APIURL
+	if apiURL == "" {
+		u, err := netUrl.Parse(repoConfig.URL)
+		if err != nil {
+			return "", fmt.Errorf("parsing repository URL: %w", err)
+		}
+		if u.Host == "github.com" {
+			apiURL = "https://api.github.com"
+		} else {
+			// GitHub Enterprise Server
+			apiURL = fmt.Sprintf("%s://%s/api/v3", u.Scheme, u.Host)
+		}
+	}
+	return strings.TrimSuffix(apiURL, "/"), nil
+}
+
+type gitHubCreatePullRequestOptions struct {
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+}
+
+type gitHubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// createChangeRequest creates a pull request in the repository with the given full name (owner/name).
+func (c *gitHubClient) createChangeRequest(ctx context.Context, repoFullName string, opts changeRequestOptions) (*changeRequest, error) {
+	b, err := json.Marshal(gitHubCreatePullRequestOptions{
+		Head:  opts.SourceBranch,
+		Base:  opts.TargetBranch,
+		Title: opts.Title,
+		Body:  opts.Description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/pulls", c.apiURL, repoFullName), bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var pr gitHubPullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &changeRequest{
+		Number: pr.Number,
+		URL:    pr.HTMLURL,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_GitHubPullRequest(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{Username: "x-access-token", Password: "a-github-token"}})
+
+	var createdPullRequest map[string]string
+	gitHubSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.Equal(t, "/repos/my-org/infra/pulls", r.URL.Path)
+		require.Equal(t, "Bearer a-github-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&createdPullRequest))
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number": 12, "html_url": "https://github.com/my-org/infra/pull/12"}`))
+	}))
+	defer gitHubSrv.Close()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {
+				URL:      gitSrv.URL + "/my-org/infra.git",
+				Provider: vignet.RepositoryProviderGitHub,
+				Token:    "a-github-token",
+				APIURL:   gitHubSrv.URL,
+			},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"commit": {"message": "Bump foo"},
+		"pullRequest": {"sourceBranch": "vignet/bump-foo"},
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Branch      string `json:"branch"`
+		PullRequest struct {
+			Number int    `json:"number"`
+			URL    string `json:"url"`
+		} `json:"pullRequest"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, "vignet/bump-foo", res.Branch)
+	require.Equal(t, 12, res.PullRequest.Number)
+	require.Equal(t, "https://github.com/my-org/infra/pull/12", res.PullRequest.URL)
+
+	require.Equal(t, map[string]string{
+		"head":  "vignet/bump-foo",
+		"base":  "master",
+		"title": "Bump foo",
+	}, createdPullRequest)
+
+	assertGitRepoHeadCommit(t, fs, "Initial commit")
+}
+
+func TestHandler_GitHubPullRequestTimeout(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	hang := make(chan struct{})
+	gitHubSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer gitHubSrv.Close()
+	defer close(hang)
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {
+				URL:      gitSrv.URL + "/my-org/infra.git",
+				Provider: vignet.RepositoryProviderGitHub,
+				APIURL:   gitHubSrv.URL,
+			},
+		},
+		Timeouts: vignet.TimeoutsConfig{API: 200 * time.Millisecond},
+	})
+
+	start := time.Now()
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"pullRequest": {"sourceBranch": "vignet/bump-foo"},
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	require.Less(t, time.Since(start), 5*time.Second)
+}
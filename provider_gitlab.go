@@ -0,0 +1,109 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	netUrl "net/url"
+	"strings"
+)
+
+// gitLabClient is a minimal client for the GitLab API (v4).
+type gitLabClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+var _ changeRequestProvider = &gitLabClient{}
+
+func newGitLabClient(repoConfig RepositoryConfig, client *http.Client) (*gitLabClient, error) {
+	apiURL := repoConfig.APIURL
+	if apiURL == "" {
+		u, err := netUrl.Parse(repoConfig.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing repository URL: %w", err)
+		}
+		apiURL = fmt.Sprintf("%s://%s/api/v4", u.Scheme, u.Host)
+	}
+
+	return &gitLabClient{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  repoConfig.Token,
+		client: client,
+	}, nil
+}
+
+type gitLabCreateMergeRequestOptions struct {
+	SourceBranch       string `json:"source_branch"`
+	TargetBranch       string `json:"target_branch"`
+	Title              string `json:"title"`
+	Description        string `json:"description,omitempty"`
+	RemoveSourceBranch bool   `json:"remove_source_branch,omitempty"`
+}
+
+type gitLabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+// createChangeRequest creates a merge request in the project with the given full path (group/name).
+func (c *gitLabClient) createChangeRequest(ctx context.Context, projectPath string, opts changeRequestOptions) (*changeRequest, error) {
+	var mr gitLabMergeRequest
+	err := c.request(ctx, http.MethodPost, fmt.Sprintf("/projects/%s/merge_requests", netUrl.PathEscape(projectPath)), gitLabCreateMergeRequestOptions{
+		SourceBranch:       opts.SourceBranch,
+		TargetBranch:       opts.TargetBranch,
+		Title:              opts.Title,
+		Description:        opts.Description,
+		RemoveSourceBranch: opts.RemoveSourceBranch,
+	}, &mr)
+	if err != nil {
+		return nil, err
+	}
+	return &changeRequest{
+		Number: mr.IID,
+		URL:    mr.WebURL,
+	}, nil
+}
+
+func (c *gitLabClient) request(ctx context.Context, method, path string, body any, result any) error {
+	var bodyReader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding body: %w", err)
+		}
+		bodyReader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, bodyReader)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	gitHttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_GitLabMergeRequest(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{BasicAuth: &gitHttp.BasicAuth{Username: "oauth2", Password: "a-gitlab-token"}})
+
+	var createdMergeRequest map[string]any
+	gitLabSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.Equal(t, "/api/v4/projects/my-group%2Finfra/merge_requests", r.URL.EscapedPath())
+		require.Equal(t, "a-gitlab-token", r.Header.Get("PRIVATE-TOKEN"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&createdMergeRequest))
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"iid": 7, "web_url": "https://gitlab.example.com/my-group/infra/-/merge_requests/7"}`))
+	}))
+	defer gitLabSrv.Close()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {
+				URL:      gitSrv.URL + "/my-group/infra.git",
+				Provider: vignet.RepositoryProviderGitLab,
+				Token:    "a-gitlab-token",
+				APIURL:   gitLabSrv.URL + "/api/v4",
+			},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"commit": {"message": "Bump foo"},
+		"mergeRequest": {"description": "Automated bump", "removeSourceBranch": true},
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var res struct {
+		Commit       string `json:"commit"`
+		Branch       string `json:"branch"`
+		MergeRequest struct {
+			IID int    `json:"iid"`
+			URL string `json:"url"`
+		} `json:"mergeRequest"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Equal(t, "vignet/"+res.Commit[:12], res.Branch)
+	require.Equal(t, 7, res.MergeRequest.IID)
+	require.Equal(t, "https://gitlab.example.com/my-group/infra/-/merge_requests/7", res.MergeRequest.URL)
+
+	require.Equal(t, map[string]any{
+		"source_branch":        res.Branch,
+		"target_branch":        "master",
+		"title":                "Bump foo",
+		"description":          "Automated bump",
+		"remove_source_branch": true,
+	}, createdMergeRequest)
+
+	// The target branch is unchanged and the source branch contains the commit
+	assertGitRepoHeadCommit(t, fs, "Initial commit")
+	storer := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	defer storer.Close()
+	repo, err := git.Open(storer, nil)
+	require.NoError(t, err)
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(res.Branch), true)
+	require.NoError(t, err)
+	require.Equal(t, res.Commit, ref.Hash().String())
+}
+
+func TestHandler_GitLabMergeRequestWithoutProvider(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: gitSrv.URL},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/infra", strings.NewReader(`{
+		"mergeRequest": {},
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "merge requests are not supported")
+}
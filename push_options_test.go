@@ -0,0 +1,64 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_PushOptions(t *testing.T) {
+	fs := memfs.New()
+	initGitRepo(t, fs, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	})
+	gitServer := gitserver.New(fs, gitserver.Options{})
+	gitSrv := httptest.NewServer(gitServer)
+	defer gitSrv.Close()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {
+				URL:         gitSrv.URL,
+				PushOptions: []string{"ci.skip"},
+			},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Bumped release",
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"pushOptions": ["ci.variable=DEPLOY=true"],
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.ElementsMatch(t, []string{"ci.skip=", "ci.variable=DEPLOY=true"}, gitServer.PushOptions())
+	assertGitRepoHeadCommit(t, fs, "Bumped release")
+}
+
+func TestHandler_InvalidPushOptions(t *testing.T) {
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, newDefaultAuthorizer(t), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: "http://localhost"},
+		},
+	})
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"pushOptions": ["ci.skip\nfoo"],
+		"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	require.Contains(t, rec.Body.String(), "pushOptions[0]")
+}
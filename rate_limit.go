@@ -0,0 +1,228 @@
+package vignet
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// RateLimitsConfig limits the rate of requests, so a misbehaving client cannot overload the Git remotes.
+// Requests exceeding a limit are rejected with status 429 and a Retry-After header.
+type RateLimitsConfig struct {
+	// PerIdentity limits authenticated requests of a caller (a GitLab project, client certificate or user).
+	PerIdentity *RateLimitConfig `yaml:"perIdentity"`
+	// PerRepository limits requests to a repository, each patch of a batch counts as a request to its repository.
+	PerRepository *RateLimitConfig `yaml:"perRepository"`
+}
+
+func (c RateLimitsConfig) Valid() error {
+	if c.PerIdentity != nil {
+		if err := c.PerIdentity.Valid(); err != nil {
+			return fmt.Errorf("invalid perIdentity: %w", err)
+		}
+	}
+	if c.PerRepository != nil {
+		if err := c.PerRepository.Valid(); err != nil {
+			return fmt.Errorf("invalid perRepository: %w", err)
+		}
+	}
+	return nil
+}
+
+// RateLimitConfig configures a token bucket: a bucket holds up to Burst tokens and is refilled with Requests tokens
+// per Interval, each request takes a token.
+type RateLimitConfig struct {
+	// Requests is the number of requests allowed per interval.
+	Requests int `yaml:"requests"`
+	// Interval of the rate, defaults to 1m.
+	Interval time.Duration `yaml:"interval"`
+	// Burst is the number of requests that can be made at once, defaults to Requests.
+	Burst int `yaml:"burst"`
+}
+
+func (c RateLimitConfig) Valid() error {
+	if c.Requests <= 0 {
+		return fmt.Errorf("requests must be positive")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) interval() time.Duration {
+	if c.Interval == 0 {
+		return time.Minute
+	}
+	return c.Interval
+}
+
+func (c RateLimitConfig) burst() int {
+	if c.Burst == 0 {
+		return c.Requests
+	}
+	return c.Burst
+}
+
+// rateLimiter limits the rate of requests by key with a token bucket per key.
+type rateLimiter struct {
+	// rate of refilled tokens per second
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(config.Requests) / config.interval().Seconds(),
+		burst:   float64(config.burst()),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// take takes a token from the bucket of the key. If the bucket is empty, it returns false and the duration until
+// a token is available.
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets that are full again, so keys of past requests do not accumulate.
+// Buckets are checked at most once per time to refill a bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// keepRateLimiters takes over the rate limiters of the previous handler if their configuration is unchanged, so a
+// reload does not reset the limits of callers and repositories.
+func (h *Handler) keepRateLimiters(previous *Handler) {
+	if reflect.DeepEqual(previous.config.RateLimits.PerIdentity, h.config.RateLimits.PerIdentity) {
+		h.identityLimiter = previous.identityLimiter
+	}
+	if reflect.DeepEqual(previous.config.RateLimits.PerRepository, h.config.RateLimits.PerRepository) {
+		h.repositoryLimiter = previous.repositoryLimiter
+	}
+}
+
+// rateLimitError is returned if a request exceeds a rate limit.
+type rateLimitError struct {
+	clientError
+	retryAfter time.Duration
+}
+
+func newRateLimitError(limited string, retryAfter time.Duration) rateLimitError {
+	return rateLimitError{
+		clientError: clientError{fmt.Errorf("rate limit of %s exceeded, retry after %s", limited, retryAfter.Round(time.Second)), http.StatusTooManyRequests},
+		retryAfter:  retryAfter,
+	}
+}
+
+func (e rateLimitError) Unwrap() error {
+	return e.clientError
+}
+
+// retryAfterSeconds returns the value of the Retry-After header in whole seconds (at least 1).
+func (e rateLimitError) retryAfterSeconds() string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// rateLimitIdentity is a middleware to limit requests per authenticated identity, it must be used after authentication.
+func (h *Handler) rateLimitIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.identityLimiter != nil {
+			identity := rateLimitIdentityKey(authCtxFromCtx(r.Context()))
+			if ok, retryAfter := h.identityLimiter.take(identity, time.Now()); !ok {
+				log.WithField("identity", identity).Warn("Rate limit of identity exceeded")
+				respondError(w, r, "Rate limit exceeded", newRateLimitError("identity", retryAfter))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitRepository is a middleware to limit requests per repository of the "repo" URL parameter.
+func (h *Handler) rateLimitRepository(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := h.takeRepositoryToken(repoParam(r)); err != nil {
+			respondError(w, r, "Rate limit exceeded", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeRepositoryToken returns a rateLimitError if the rate limit of the repository is exceeded.
+// Unknown repositories are not limited, since requests to them fail anyway. The limit is shared by all names of the
+// same configured repository or template, so it cannot be bypassed by requesting another name of the repository.
+func (h *Handler) takeRepositoryToken(repoName string) error {
+	if h.repositoryLimiter == nil {
+		return nil
+	}
+	key, _, exists := h.lookupRepository(repoName)
+	if !exists {
+		return nil
+	}
+	if ok, retryAfter := h.repositoryLimiter.take(key, time.Now()); !ok {
+		log.WithField("repo", repoName).WithField("repoKey", key).Warn("Rate limit of repository exceeded")
+		return newRateLimitError(fmt.Sprintf("repository %q", key), retryAfter)
+	}
+	return nil
+}
+
+// rateLimitIdentityKey identifies the caller of a request: GitLab requests by project, so all jobs of a project
+// share a limit.
+func rateLimitIdentityKey(authCtx AuthCtx) string {
+	switch {
+	case authCtx.GitLabClaims != nil:
+		return "gitlab:" + authCtx.GitLabClaims.ProjectPath
+	case authCtx.ClientCertificate != nil:
+		return "mtls:" + authCtx.ClientCertificate.Subject
+	case authCtx.BasicAuth != nil:
+		return "basicAuth:" + authCtx.BasicAuth.Username
+	default:
+		return "anonymous"
+	}
+}
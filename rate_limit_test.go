@@ -0,0 +1,177 @@
+package vignet_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+// projectHeaderAuthenticationProvider authenticates requests as the GitLab project of the X-Test-Project header.
+type projectHeaderAuthenticationProvider struct{}
+
+func (projectHeaderAuthenticationProvider) AuthCtxFromRequest(r *http.Request) (vignet.AuthCtx, error) {
+	return vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: r.Header.Get("X-Test-Project")},
+	}, nil
+}
+
+func TestHandler_RateLimits(t *testing.T) {
+	files := map[string]string{
+		"project-a/release.yml": "foo: bar",
+		"project-b/release.yml": "foo: bar",
+	}
+	_, infraSrv := startMockHttpGitServer(t, files, gitserver.Options{})
+	_, appsSrv := startMockHttpGitServer(t, files, gitserver.Options{})
+
+	authorizer := newDefaultAuthorizer(t)
+
+	newHandler := func(rateLimits vignet.RateLimitsConfig) *vignet.Handler {
+		return vignet.NewHandler(projectHeaderAuthenticationProvider{}, authorizer, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"infra": {URL: infraSrv.URL},
+				"apps":  {URL: appsSrv.URL},
+				// All names of the template are the same repository
+				"mirror/*": {URL: infraSrv.URL},
+			},
+			RateLimits: rateLimits,
+		})
+	}
+	readFile := func(handler http.Handler, project, repo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/repos/"+repo+"/file?path="+project+"/release.yml", nil)
+		req.Header.Set("X-Test-Project", project)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("per identity", func(t *testing.T) {
+		handler := newHandler(vignet.RateLimitsConfig{
+			PerIdentity: &vignet.RateLimitConfig{Requests: 1, Interval: time.Hour, Burst: 2},
+		})
+
+		for i := 0; i < 2; i++ {
+			rec := readFile(handler, "project-a", "infra")
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		rec := readFile(handler, "project-a", "apps")
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+		retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.InDelta(t, 3600, retryAfter, 60)
+		assert.Contains(t, rec.Body.String(), "rate limit of identity exceeded")
+
+		// Other identities have their own limit
+		rec = readFile(handler, "project-b", "infra")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("per repository", func(t *testing.T) {
+		handler := newHandler(vignet.RateLimitsConfig{
+			PerRepository: &vignet.RateLimitConfig{Requests: 1, Interval: time.Minute},
+		})
+
+		rec := readFile(handler, "project-a", "infra")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = readFile(handler, "project-b", "infra")
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+		assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), `rate limit of repository \"infra\" exceeded`)
+
+		// Names of the same template share the limit
+		rec = readFile(handler, "project-a", "mirror%2Finfra")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = readFile(handler, "project-a", "mirror%2FINFRA")
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `rate limit of repository \"mirror/*\" exceeded`)
+
+		// Unknown repositories are not limited
+		for i := 0; i < 2; i++ {
+			rec = readFile(handler, "project-a", "unknown")
+			require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+		}
+
+		// Each patch of a batch counts for its repository
+		req := httptest.NewRequest("POST", "/v1/patch", strings.NewReader(`{
+			"patches": [
+				{"repo": "infra", "commands": [{"path": "project-a/release.yml", "setField": {"field": "foo", "value": "baz"}}]},
+				{"repo": "apps", "commands": [{"path": "project-a/release.yml", "setField": {"field": "foo", "value": "baz"}}]}
+			]
+		}`))
+		req.Header.Set("X-Test-Project", "project-a")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []struct {
+				Status int `json:"status"`
+				Error  *struct {
+					Cause string `json:"cause"`
+				} `json:"error"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Results, 2)
+		assert.Equal(t, http.StatusTooManyRequests, res.Results[0].Status)
+		require.NotNil(t, res.Results[0].Error)
+		assert.Equal(t, "Rate limit exceeded", res.Results[0].Error.Cause)
+		assert.Equal(t, http.StatusOK, res.Results[1].Status)
+	})
+}
+
+func TestServer_ReloadRateLimits(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	authenticationProvider := staticAuthenticationProvider{authCtx: vignet.AuthCtx{
+		GitLabClaims: &vignet.GitLabClaims{ProjectPath: "my-group/my-project"},
+	}}
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		"infra": {URL: gitSrv.URL},
+	}
+	config.RateLimits = vignet.RateLimitsConfig{
+		PerRepository: &vignet.RateLimitConfig{Requests: 1, Interval: time.Hour},
+	}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx,
+		vignet.WithConfig(config),
+		vignet.WithAuthenticationProvider(authenticationProvider),
+	)
+	require.NoError(t, err)
+
+	readFile := func() int {
+		req := httptest.NewRequest("GET", "/v1/repos/infra/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, readFile())
+	require.Equal(t, http.StatusTooManyRequests, readFile())
+
+	// A reload with unchanged limits keeps the state of the limits
+	require.NoError(t, srv.Reload(ctx, vignet.WithConfig(config), vignet.WithAuthenticationProvider(authenticationProvider)))
+	require.Equal(t, http.StatusTooManyRequests, readFile())
+
+	// Changed limits start with full buckets
+	config.RateLimits.PerRepository = &vignet.RateLimitConfig{Requests: 2, Interval: time.Hour}
+	require.NoError(t, srv.Reload(ctx, vignet.WithConfig(config), vignet.WithAuthenticationProvider(authenticationProvider)))
+	require.Equal(t, http.StatusOK, readFile())
+}
@@ -0,0 +1,303 @@
+package vignet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/networkteam/vignet/properties"
+	"github.com/networkteam/vignet/toml"
+	"github.com/networkteam/vignet/yaml"
+)
+
+// CommitHeader is set in responses of read requests with the hash of the commit that was read.
+const CommitHeader = "X-Vignet-Commit"
+
+type readFieldResponse struct {
+	// Commit is the hash of the commit that was read.
+	Commit string `json:"commit"`
+	Path   string `json:"path"`
+	Field  string `json:"field"`
+	Value  any    `json:"value"`
+}
+
+// readFile responds with the content of a file or the value of a field of the file at HEAD of the default branch,
+// or of the branch of a consistency token.
+func (h *Handler) readFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	repoName := repoParam(r)
+
+	query := r.URL.Query()
+	filePath := query.Get("path")
+	field := query.Get("field")
+	if filePath == "" {
+		respondError(w, r, "Invalid query parameter", clientError{errors.New("'path' must be set"), http.StatusBadRequest})
+		return
+	}
+	if err := validRepositoryPath(filePath); err != nil {
+		respondError(w, r, "Invalid query parameter", clientError{fmt.Errorf("'path' %w", err), http.StatusBadRequest})
+		return
+	}
+
+	var token *consistencyToken
+	if header := r.Header.Get(ConsistencyTokenHeader); header != "" {
+		t, err := parseConsistencyToken(header, h.config.ConsistencyTokens.key())
+		if err != nil {
+			respondError(w, r, "Invalid consistency token", clientError{fmt.Errorf("%s header: %w", ConsistencyTokenHeader, err), http.StatusBadRequest})
+			return
+		}
+		if t.Repo != repoName {
+			respondError(w, r, "Invalid consistency token", clientError{fmt.Errorf("%s header: token is for repository %q", ConsistencyTokenHeader, t.Repo), http.StatusBadRequest})
+			return
+		}
+		token = &t
+	}
+
+	_, repoConfig, exists := h.lookupRepository(repoName)
+	if !exists {
+		log.WithField("repo", repoName).Warn("Unknown repository")
+		respondError(w, r, "Unknown repository", clientError{fmt.Errorf("repository %q not configured", repoName), http.StatusNotFound})
+		return
+	}
+	if !repoConfig.allowsPath(filePath) {
+		log.WithField("repo", repoName).WithField("path", filePath).Warn("Read request targets a path that is not allowed")
+		respondError(w, r, "Path not allowed", clientError{fmt.Errorf("path %q is not allowed for repository %q", filePath, repoName), http.StatusForbidden})
+		return
+	}
+
+	readReq := readRequest{Resource: "file", Path: filePath, Field: field}
+	if token != nil {
+		readReq.Branch = token.Branch
+	}
+	if !h.authorizeRead(w, r, repoName, readReq) {
+		return
+	}
+
+	repoConfig, err := h.exchangeCredential(ctx, r, repoName, repoConfig)
+	if err != nil {
+		log.
+			WithField("repo", repoName).
+			WithError(err).
+			Warn("Failed to exchange credential")
+		respondError(w, r, "Credential exchange failed", err)
+		return
+	}
+
+	content, commit, err := h.readHeadFile(ctx, repoConfig, filePath, token)
+	if err != nil {
+		var clientErr clientError
+		if !errors.As(err, &clientErr) {
+			log.
+				WithField("repo", repoName).
+				WithError(err).
+				Error("Failed to read file")
+		}
+		respondError(w, r, "Reading file failed", err)
+		return
+	}
+
+	w.Header().Set(CommitHeader, commit)
+	w.Header().Set("ETag", commitETag(commit))
+
+	if field == "" {
+		contentType := "application/octet-stream"
+		if utf8.Valid(content) {
+			contentType = "text/plain; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+		return
+	}
+
+	value, err := readField(filePath, content, field)
+	if err != nil {
+		respondError(w, r, "Reading field failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(readFieldResponse{
+		Commit: commit,
+		Path:   filePath,
+		Field:  field,
+		Value:  value,
+	})
+}
+
+// readHeadFile shallow clones the repository without a worktree and returns the content of the file at HEAD of the
+// default branch and the hash of the commit. With a consistency token the branch of the token is read and its commit
+// must be reachable from HEAD. The history is only cloned if the commit of the token is not HEAD, the repository is
+// cloned once more if a replica of the remote lags behind.
+func (h *Handler) readHeadFile(ctx context.Context, repoConfig RepositoryConfig, filePath string, token *consistencyToken) ([]byte, string, error) {
+	cloneOptions := &git.CloneOptions{
+		URL:          repoConfig.URL,
+		Auth:         repoConfig.authMethod(),
+		SingleBranch: true,
+		NoCheckout:   true,
+		Depth:        1,
+	}
+	if token != nil {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(token.Branch)
+	}
+
+	var (
+		head   *plumbing.Reference
+		commit *object.Commit
+	)
+	for clones := 1; ; clones++ {
+		var r *git.Repository
+		cloneStart := time.Now()
+		err := withTimeout(ctx, "clone", h.config.Timeouts.clone(), func(ctx context.Context) (err error) {
+			r, err = git.CloneContext(ctx, memory.NewStorage(), nil, cloneOptions)
+			return err
+		})
+		accessLogEntryFromCtx(ctx).recordGitTiming("clone", time.Since(cloneStart))
+		if token != nil && errors.Is(err, git.NoMatchingRefSpecError{}) {
+			return nil, "", clientError{fmt.Errorf("branch %q of consistency token does not exist", token.Branch), http.StatusPreconditionFailed}
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("cloning repository: %w", err)
+		}
+		head, err = r.Head()
+		if err != nil {
+			return nil, "", fmt.Errorf("getting HEAD of repository: %w", err)
+		}
+		commit, err = r.CommitObject(head.Hash())
+		if err != nil {
+			return nil, "", fmt.Errorf("getting HEAD commit: %w", err)
+		}
+		if token == nil || head.Hash() == plumbing.NewHash(token.Commit) {
+			break
+		}
+		if cloneOptions.Depth != 0 {
+			// The shallow clone has no history to check if the commit of the token is an ancestor of HEAD
+			log.
+				WithField("branch", token.Branch).
+				WithField("commit", token.Commit).
+				Debug("Commit of consistency token is not HEAD, cloning with history")
+			cloneOptions.Depth = 0
+			continue
+		}
+		reached, err := reachesCommit(r, commit, plumbing.NewHash(token.Commit))
+		if err != nil {
+			return nil, "", fmt.Errorf("checking consistency token: %w", err)
+		}
+		if reached {
+			break
+		}
+		// The shallow clone is followed by at most two clones with history
+		if clones == 3 {
+			err := fmt.Errorf("HEAD of branch %q is %s, it does not contain commit %s of the consistency token", token.Branch, head.Hash(), token.Commit)
+			return nil, "", codedError{clientError{err, http.StatusPreconditionFailed}, "stale_read"}
+		}
+		log.
+			WithField("branch", token.Branch).
+			WithField("commit", token.Commit).
+			Debug("Commit of consistency token not reached, cloning again")
+	}
+
+	f, err := commit.File(filePath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, "", clientError{fmt.Errorf("file %q does not exist", filePath), http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("getting file: %w", err)
+	}
+	if maxFileSize := h.config.Limits.maxFileSize(); f.Size > maxFileSize {
+		return nil, "", clientError{fmt.Errorf("file %q exceeds the limit of %d bytes", filePath, maxFileSize), http.StatusRequestEntityTooLarge}
+	}
+
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, "", fmt.Errorf("opening file: %w", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading file: %w", err)
+	}
+
+	return content, head.Hash().String(), nil
+}
+
+// reachesCommit returns whether the commit is head or one of its ancestors.
+func reachesCommit(r *git.Repository, head *object.Commit, hash plumbing.Hash) (bool, error) {
+	if head.Hash == hash {
+		return true, nil
+	}
+	c, err := r.CommitObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return c.IsAncestor(head)
+}
+
+// readField returns the value of a field of a YAML, JSON or TOML file (a path or JSONPath) or of a key of a .env
+// or .properties file.
+func readField(filePath string, content []byte, field string) (any, error) {
+	notFound := clientError{fmt.Errorf("field %q does not exist in %q", field, filePath), http.StatusNotFound}
+
+	if format, ok := properties.FormatOf(filePath); ok {
+		patcher, err := properties.NewPatcher(bytes.NewReader(content), format)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("parsing file: %w", err), http.StatusUnprocessableEntity}
+		}
+		value, ok := patcher.Get(field)
+		if !ok {
+			return nil, notFound
+		}
+		return value, nil
+	}
+
+	if isTOMLFile(filePath) {
+		patcher, err := toml.NewPatcher(bytes.NewReader(content))
+		if err != nil {
+			return nil, clientError{fmt.Errorf("parsing file: %w", err), http.StatusUnprocessableEntity}
+		}
+		value, err := patcher.Field(field)
+		if err != nil {
+			return nil, clientError{fmt.Errorf("reading field %q: %w", field, err), http.StatusUnprocessableEntity}
+		}
+		if value == nil {
+			return nil, notFound
+		}
+		return value, nil
+	}
+
+	if !isYAMLFile(filePath) && !isJSONFile(filePath) {
+		return nil, clientError{fmt.Errorf("unsupported file type: %q, fields can only be read from YAML, JSON, TOML, .env and .properties files", filePath), http.StatusUnprocessableEntity}
+	}
+	patcher, err := yaml.NewPatcher(bytes.NewReader(content))
+	if err != nil {
+		return nil, clientError{fmt.Errorf("parsing file: %w", err), http.StatusUnprocessableEntity}
+	}
+	values, err := patcher.GetField(field)
+	if err != nil {
+		return nil, clientError{fmt.Errorf("reading field %q: %w", field, err), http.StatusUnprocessableEntity}
+	}
+	switch len(values) {
+	case 0:
+		return nil, notFound
+	case 1:
+		return values[0], nil
+	default:
+		return nil, clientError{fmt.Errorf("reading field %q: multiple nodes matched path", field), http.StatusUnprocessableEntity}
+	}
+}
@@ -0,0 +1,323 @@
+package vignet_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_ReadFile(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml":    "image:\n  repository: registry.example.com/app\n  tag: 1.2.3\n",
+		"my-group/my-project/pyproject.toml": "[project]\nversion = \"0.1.0\"\n",
+		"my-group/my-project/.env":           "APP_VERSION=1.2.3\n",
+		"my-group/other-project/release.yml": "image:\n  tag: 2.0.0\n",
+	}, gitserver.Options{})
+	headCommit := gitRepoHeadCommit(t, fs).Hash.String()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+
+	t.Run("raw file", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "image:\n  repository: registry.example.com/app\n  tag: 1.2.3\n", rec.Body.String())
+		assert.Equal(t, headCommit, rec.Header().Get(vignet.CommitHeader))
+		assert.Equal(t, `"`+headCommit+`"`, rec.Header().Get("ETag"))
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedValue  any
+		expectedError  string
+	}{
+		{
+			name:           "YAML field",
+			query:          "path=my-group/my-project/release.yml&field=image.tag",
+			expectedStatus: http.StatusOK,
+			expectedValue:  "1.2.3",
+		},
+		{
+			name:           "JSONPath",
+			query:          "path=my-group/my-project/release.yml&field=$.image.repository",
+			expectedStatus: http.StatusOK,
+			expectedValue:  "registry.example.com/app",
+		},
+		{
+			name:           "TOML field",
+			query:          "path=my-group/my-project/pyproject.toml&field=project.version",
+			expectedStatus: http.StatusOK,
+			expectedValue:  "0.1.0",
+		},
+		{
+			name:           "dotenv key",
+			query:          "path=my-group/my-project/.env&field=APP_VERSION",
+			expectedStatus: http.StatusOK,
+			expectedValue:  "1.2.3",
+		},
+		{
+			name:           "missing field",
+			query:          "path=my-group/my-project/release.yml&field=image.digest",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  `field \"image.digest\" does not exist`,
+		},
+		{
+			name:           "missing file",
+			query:          "path=my-group/my-project/missing.yml",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  `file \"my-group/my-project/missing.yml\" does not exist`,
+		},
+		{
+			name:           "path of other project",
+			query:          "path=my-group/other-project/release.yml",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "is not a prefix of GitLab project path",
+		},
+		{
+			name:           "path with traversal",
+			query:          "path=my-group/my-project/../other-project/release.yml",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "'path' must be a clean relative path",
+		},
+		{
+			name:           "missing path",
+			query:          "field=image.tag",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "'path' must be set",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/repos/e2e-test/file?"+tc.query, nil)
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+				return
+			}
+
+			var res map[string]any
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, tc.expectedValue, res["value"])
+			assert.Equal(t, headCommit, res["commit"])
+		})
+	}
+}
+
+func TestHandler_ReadFile_ConsistencyToken(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.2.3\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+		ConsistencyTokens: vignet.ConsistencyTokensConfig{Key: "test-key"},
+	})
+
+	read := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml&field=image.tag", nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(vignet.ConsistencyTokenHeader, token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// sign builds a token in the format of the handler, clients treat it as opaque
+	sign := func(key, repo, branch, commit string) string {
+		b, err := json.Marshal(map[string]string{"r": repo, "b": branch, "c": commit})
+		require.NoError(t, err)
+		payload := base64.RawURLEncoding.EncodeToString(b)
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(payload))
+		return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	token := func(repo, branch, commit string) string {
+		return sign("test-key", repo, branch, commit)
+	}
+	const otherHash = "0123456789abcdef0123456789abcdef01234567"
+
+	t.Run("write then read", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch/e2e-test", strings.NewReader(`{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "1.2.4"}}]}`))
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		consistencyToken := rec.Header().Get(vignet.ConsistencyTokenHeader)
+		require.NotEmpty(t, consistencyToken)
+
+		rec = read(consistencyToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, "1.2.4", res["value"])
+	})
+
+	t.Run("commit not reached", func(t *testing.T) {
+		rec := read(token("e2e-test", "master", otherHash))
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "stale_read")
+	})
+
+	t.Run("missing branch", func(t *testing.T) {
+		rec := read(token("e2e-test", "missing", otherHash))
+		require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `branch \"missing\" of consistency token does not exist`)
+	})
+
+	t.Run("token of other repository", func(t *testing.T) {
+		rec := read(token("other", "master", otherHash))
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), `token is for repository \"other\"`)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		rec := read("not a token")
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	})
+
+	t.Run("unsigned token", func(t *testing.T) {
+		b, err := json.Marshal(map[string]string{"r": "e2e-test", "b": "master", "c": otherHash})
+		require.NoError(t, err)
+		rec := read(base64.RawURLEncoding.EncodeToString(b))
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "token is not signed")
+	})
+
+	t.Run("token signed with other key", func(t *testing.T) {
+		rec := read(sign("other-key", "e2e-test", "master", otherHash))
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "invalid signature")
+	})
+}
+
+func TestHandler_ReadFile_ConsistencyTokenBranchIsAuthorized(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.2.3\n",
+	}, gitserver.Options{})
+
+	b := loadTestBundle(t, `
+package vignet.request.read
+import future.keywords
+
+violations contains msg if {
+	input.readRequest.branch != ""
+	msg := sprintf("branch %q cannot be read", [input.readRequest.branch])
+}
+`)
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), b)
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		ConsistencyTokens: vignet.ConsistencyTokensConfig{Key: "test-key"},
+	})
+
+	tokenPayload, err := json.Marshal(map[string]string{"r": "e2e-test", "b": "release", "c": "0123456789abcdef0123456789abcdef01234567"})
+	require.NoError(t, err)
+	payload := base64.RawURLEncoding.EncodeToString(tokenPayload)
+	mac := hmac.New(sha256.New, []byte("test-key"))
+	mac.Write([]byte(payload))
+
+	req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml", nil)
+	req.Header.Set(vignet.ConsistencyTokenHeader, payload+"."+base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `branch "release" cannot be read`)
+}
+
+func TestHandler_ReadFile_ShallowClone(t *testing.T) {
+	fs := memfs.New()
+	initGitRepo(t, fs, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 1.2.3\n",
+	})
+	gitServer := gitserver.New(fs, gitserver.Options{})
+	gitSrv := httptest.NewServer(gitServer)
+	defer gitSrv.Close()
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+		ConsistencyTokens: vignet.ConsistencyTokensConfig{Key: "test-key"},
+	})
+
+	patch := func(tag string) string {
+		req := httptest.NewRequest("POST", "/v1/patch/e2e-test", strings.NewReader(`{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "`+tag+`"}}]}`))
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		return rec.Header().Get(vignet.ConsistencyTokenHeader)
+	}
+	// read returns the value of the field and the depths of the clones of the read
+	read := func(token string) (any, []int) {
+		before := len(gitServer.UploadPackDepths())
+		req := httptest.NewRequest("GET", "/repos/e2e-test/file?path=my-group/my-project/release.yml&field=image.tag", nil)
+		req.Header.Set("Accept", "application/json")
+		if token != "" {
+			req.Header.Set(vignet.ConsistencyTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res["value"], gitServer.UploadPackDepths()[before:]
+	}
+
+	olderToken := patch("1.2.4")
+	headToken := patch("1.2.5")
+
+	t.Run("without token", func(t *testing.T) {
+		value, depths := read("")
+		assert.Equal(t, "1.2.5", value)
+		assert.Equal(t, []int{1}, depths)
+	})
+
+	t.Run("token of HEAD", func(t *testing.T) {
+		value, depths := read(headToken)
+		assert.Equal(t, "1.2.5", value)
+		assert.Equal(t, []int{1}, depths)
+	})
+
+	t.Run("token of an ancestor of HEAD", func(t *testing.T) {
+		value, depths := read(olderToken)
+		assert.Equal(t, "1.2.5", value)
+		assert.Equal(t, []int{1, 0}, depths, "history is only cloned if the commit is not HEAD")
+	})
+}
@@ -0,0 +1,156 @@
+package vignet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Replayer re-executes the patch requests of audit records, e.g. onto a restored mirror after an incident.
+// Authentication and authorization are skipped, since the operations were authorized when they were recorded.
+type Replayer struct {
+	handler *Handler
+}
+
+// NewReplayer creates a replayer for the repositories of the configuration.
+func NewReplayer(config Config) *Replayer {
+	return &Replayer{
+		handler: NewHandler(nil, nil, config),
+	}
+}
+
+// SetLocker sets the locker to serialize operations on a repository, a MemoryLocker is used by default.
+func (rp *Replayer) SetLocker(l Locker) {
+	rp.handler.SetLocker(l)
+}
+
+// ReplayResult is the result of a replayed operation.
+type ReplayResult struct {
+	// Commit is the hash of the created commit, it is empty for a dry run or if only tags were created.
+	Commit string
+	// Branch the commit was pushed to.
+	Branch string
+	// Diff is the unified diff of the changes, it is only set for a dry run.
+	Diff string
+}
+
+// Replay re-executes the patch request of the record. A dry run returns the diff without committing and pushing.
+func (rp *Replayer) Replay(ctx context.Context, record AuditRecord, dryRun bool) (*ReplayResult, error) {
+	h := rp.handler
+
+	if len(record.Request) == 0 {
+		return nil, errors.New("record contains no request")
+	}
+	var req patchRequest
+	if err := json.Unmarshal(record.Request, &req); err != nil {
+		return nil, fmt.Errorf("decoding request of record: %w", err)
+	}
+	for idx, cmd := range req.Commands {
+		if cmd.SetField != nil && cmd.SetField.SOPS {
+			return nil, fmt.Errorf("'commands[%d]' cannot be replayed: values of SOPS encrypted fields are not recorded", idx)
+		}
+		if cmd.CreateFile != nil && cmd.CreateFile.ContentFrom != "" {
+			return nil, fmt.Errorf("'commands[%d]' cannot be replayed: content of part %q is not recorded", idx, cmd.CreateFile.ContentFrom)
+		}
+	}
+	req.DryRun = dryRun
+	if err := req.Validate(); err != nil {
+		return nil, fmt.Errorf("validating request of record: %w", err)
+	}
+	if err := req.checkFeatures(h.config.Features); err != nil {
+		return nil, err
+	}
+
+	key, repoConfig, exists := h.lookupRepository(record.Repo)
+	if !exists {
+		return nil, fmt.Errorf("repository %q not configured", record.Repo)
+	}
+	if err := repoConfig.checkAllowedPaths(record.Repo, req); err != nil {
+		return nil, err
+	}
+	if h.exchangers[key] != nil {
+		return nil, fmt.Errorf("repository %q uses a credential exchange, configure static credentials to replay", record.Repo)
+	}
+
+	ctx = ctxWithAuthCtx(ctx, record.AuthCtx)
+
+	if !dryRun {
+		lockCtx, unlock, err := h.locker.Lock(ctx, record.Repo)
+		if err != nil {
+			return nil, fmt.Errorf("acquiring repository lock: %w", err)
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
+
+	res, err := h.gitClonePatchCommitPush(ctx, record.Repo, repoConfig, req, nil)
+	if err != nil {
+		return nil, lockLostError(ctx, err)
+	}
+
+	return &ReplayResult{
+		Commit: res.Commit,
+		Branch: res.Branch,
+		Diff:   res.Diff,
+	}, nil
+}
+
+// ReplayFilter selects audit records to replay. Only records of pushed patches are replayable.
+type ReplayFilter struct {
+	// Repo selects records of the repository if set.
+	Repo string
+	// Commits selects records by (abbreviated) commit hashes if set.
+	Commits []string
+	// Since selects records at or after the time if set.
+	Since time.Time
+	// Until selects records before the time if set.
+	Until time.Time
+}
+
+// Select returns the records that are matched by the filter, except records of patches that were reverted by a
+// succeeded revert in records (e.g. on rollback of a batch).
+func (f ReplayFilter) Select(records []AuditRecord) []AuditRecord {
+	reverted := make(map[string]bool)
+	for _, record := range records {
+		if record.Action == ActionRevert && record.Outcome == AuditOutcomeSucceeded {
+			reverted[record.RevertedCommit] = true
+		}
+	}
+
+	var selected []AuditRecord
+	for _, record := range records {
+		if f.Matches(record) && !reverted[record.CommitHash] {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// Matches returns true if the record is replayable and selected by the filter. Reverts are not replayable, use Select
+// to skip the records of reverted patches as well.
+func (f ReplayFilter) Matches(record AuditRecord) bool {
+	if record.Action == ActionRevert || record.Outcome != AuditOutcomeSucceeded || record.DryRun || len(record.Request) == 0 {
+		return false
+	}
+	if f.Repo != "" && record.Repo != f.Repo {
+		return false
+	}
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !record.Time.Before(f.Until) {
+		return false
+	}
+	if len(f.Commits) > 0 {
+		for _, commit := range f.Commits {
+			if record.CommitHash != "" && strings.HasPrefix(record.CommitHash, commit) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
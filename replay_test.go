@@ -0,0 +1,241 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestReplayer_Replay(t *testing.T) {
+	initialFiles := map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}
+	_, gitSrv := startMockHttpGitServer(t, initialFiles, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := vignet.NewFileAuditSink(auditFile)
+	require.NoError(t, err)
+	defer sink.Close()
+	handler.RegisterAuditSink(sink)
+
+	for _, payload := range []string{
+		`{"commit": {"message": "Set foo"}, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`,
+		`{"commit": {"message": "Set foo again"}, "dryRun": true, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "qux"}}]}`,
+		`{"commit": {"message": "Create file"}, "commands": [{"path": "my-group/my-project/new.yml", "createFile": {"content": "version: 1\n"}}]}`,
+	} {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(payload))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	f, err := os.Open(auditFile)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := vignet.ReadAuditRecords(f)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	var selected []vignet.AuditRecord
+	for _, record := range records {
+		if (vignet.ReplayFilter{Repo: "e2e-test"}).Matches(record) {
+			selected = append(selected, record)
+		}
+	}
+	// The dry run is not replayable
+	require.Len(t, selected, 2)
+
+	// Replay onto a restored mirror of the repository
+	mirrorFS, mirrorSrv := startMockHttpGitServer(t, initialFiles, gitserver.Options{})
+	replayer := vignet.NewReplayer(vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: mirrorSrv.URL},
+		},
+	})
+
+	res, err := replayer.Replay(context.Background(), selected[0], true)
+	require.NoError(t, err)
+	require.Contains(t, res.Diff, "+foo: baz")
+	assertGitRepoHeadCommit(t, mirrorFS, "Initial commit")
+
+	for _, record := range selected {
+		res, err := replayer.Replay(context.Background(), record, false)
+		require.NoError(t, err)
+		require.NotEmpty(t, res.Commit)
+	}
+	assertGitRepoHeadCommit(t, mirrorFS, "Create file")
+	assertGitRepoContains(t, mirrorFS, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"foo: baz\n"},
+		"my-group/my-project/new.yml":     content{"version: 1\n"},
+	})
+}
+
+func TestReplayFilter_SelectSkipsRolledBackPatches(t *testing.T) {
+	gitFS, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	})
+	sink := &recordingSink{}
+	handler.RegisterAuditSink(sink)
+	handler.RegisterNotifier(sink)
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commit": {"message": "Create file"},
+		"commands": [{"path": "my-group/my-project/new.yml", "createFile": {"content": "version: 1\n"}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// The patch of the first repository is rolled back, since the second repository is unknown
+	req = httptest.NewRequest("POST", "/patch", strings.NewReader(`{
+		"onFailure": "rollback",
+		"patches": [
+			{"repo": "e2e-test", "commit": {"message": "Set foo"}, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]},
+			{"repo": "unknown", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}
+		]
+	}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+	require.Len(t, sink.records, 4)
+	patchRecord, revertRecord := sink.records[1], sink.records[3]
+	require.Equal(t, vignet.AuditOutcomeSucceeded, patchRecord.Outcome)
+	require.Equal(t, vignet.AuditOutcomeFailed, sink.records[2].Outcome)
+	require.Equal(t, vignet.ActionRevert, revertRecord.Action)
+	require.Equal(t, vignet.AuditOutcomeSucceeded, revertRecord.Outcome)
+	require.Equal(t, patchRecord.CommitHash, revertRecord.RevertedCommit)
+	require.Equal(t, gitRepoHeadCommit(t, gitFS).Hash.String(), revertRecord.CommitHash)
+
+	require.Len(t, sink.events, 3)
+	require.Equal(t, revertRecord.CommitHash, sink.events[2].CommitHash)
+	require.Equal(t, patchRecord.CommitHash, sink.events[2].RevertedCommit)
+
+	// Only the patch that was not rolled back is replayed
+	selected := vignet.ReplayFilter{Repo: "e2e-test"}.Select(sink.records)
+	require.Len(t, selected, 1)
+	require.Equal(t, sink.records[0].CommitHash, selected[0].CommitHash)
+
+	mirrorFS, mirrorSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+	replayer := vignet.NewReplayer(vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: mirrorSrv.URL},
+		},
+	})
+	for _, record := range selected {
+		_, err := replayer.Replay(context.Background(), record, false)
+		require.NoError(t, err)
+	}
+	assertGitRepoContains(t, mirrorFS, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"foo: bar"},
+		"my-group/my-project/new.yml":     content{"version: 1\n"},
+	})
+}
+
+func TestReplayer_ReplaySOPSNotSupported(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/secrets.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	config := vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+	}
+	handler := newTestHandler(t, config)
+	sink := &recordingSink{}
+	handler.RegisterAuditSink(sink)
+
+	req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+		"commands": [{"path": "my-group/my-project/secrets.yml", "setField": {"field": "password", "value": "a-secret-value", "sops": true}}]
+	}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	require.NotContains(t, string(record.Request), "a-secret-value")
+
+	// Replay is refused even for a record that looks like a pushed patch
+	record.Outcome = vignet.AuditOutcomeSucceeded
+	_, err := vignet.NewReplayer(config).Replay(context.Background(), record, true)
+	require.ErrorContains(t, err, "not recorded")
+}
+
+func TestReplayFilter_Matches(t *testing.T) {
+	now := time.Now()
+	record := vignet.AuditRecord{
+		Time:       now,
+		Repo:       "infra",
+		Outcome:    vignet.AuditOutcomeSucceeded,
+		CommitHash: "2c5a7e3b0f1d4e6a9b8c7d6e5f4a3b2c1d0e9f8a",
+		Request:    []byte(`{}`),
+	}
+
+	tests := []struct {
+		name     string
+		filter   vignet.ReplayFilter
+		modify   func(r *vignet.AuditRecord)
+		expected bool
+	}{
+		{name: "empty filter", expected: true},
+		{name: "matching repo", filter: vignet.ReplayFilter{Repo: "infra"}, expected: true},
+		{name: "other repo", filter: vignet.ReplayFilter{Repo: "other"}, expected: false},
+		{name: "abbreviated commit", filter: vignet.ReplayFilter{Commits: []string{"other", "2c5a7e3"}}, expected: true},
+		{name: "other commit", filter: vignet.ReplayFilter{Commits: []string{"abcdef0"}}, expected: false},
+		{name: "since", filter: vignet.ReplayFilter{Since: now}, expected: true},
+		{name: "until", filter: vignet.ReplayFilter{Until: now}, expected: false},
+		{
+			name:     "failed outcome",
+			modify:   func(r *vignet.AuditRecord) { r.Outcome = vignet.AuditOutcomeFailed },
+			expected: false,
+		},
+		{
+			name:     "dry run",
+			modify:   func(r *vignet.AuditRecord) { r.DryRun = true },
+			expected: false,
+		},
+		{
+			name:     "revert",
+			modify:   func(r *vignet.AuditRecord) { r.Action = vignet.ActionRevert },
+			expected: false,
+		},
+		{
+			name:     "no request",
+			modify:   func(r *vignet.AuditRecord) { r.Request = nil },
+			expected: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := record
+			if tc.modify != nil {
+				tc.modify(&r)
+			}
+			require.Equal(t, tc.expected, tc.filter.Matches(r))
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// maxConcurrentRepositoryChecks limits the number of repositories that are checked at once.
+const maxConcurrentRepositoryChecks = 8
+
+// RepositoryCheck is the result of checking a repository.
+type RepositoryCheck struct {
+	Repo string
+	// Skipped is the reason why the repository was not checked.
+	Skipped string
+	// Err is set if the repository is not reachable with the configured credentials.
+	Err error
+}
+
+// CheckRepositories checks that the configured repositories are reachable with their credentials by listing their
+// refs. Repository templates and repositories with a credential exchange are skipped, since their URL or credential is
+// only known for a request. The results are sorted by repository name.
+func CheckRepositories(ctx context.Context, config Config) []RepositoryCheck {
+	checks := make([]RepositoryCheck, 0, len(config.Repositories))
+	for repoName := range config.Repositories {
+		checks = append(checks, RepositoryCheck{Repo: repoName})
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Repo < checks[j].Repo
+	})
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentRepositoryChecks)
+	for i := range checks {
+		check := &checks[i]
+		repoConfig := config.Repositories[check.Repo]
+		switch {
+		case isRepositoryTemplate(check.Repo):
+			check.Skipped = "repository template"
+			continue
+		case repoConfig.Exchange != nil:
+			check.Skipped = "credential exchange"
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			check.Err = withTimeout(ctx, "listing refs", config.Timeouts.clone(), func(ctx context.Context) error {
+				return listRefs(ctx, repoConfig)
+			})
+		}()
+	}
+	wg.Wait()
+
+	return checks
+}
+
+func listRefs(ctx context.Context, repoConfig RepositoryConfig) error {
+	remote := git.NewRemote(memory.NewStorage(), &gitConfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoConfig.URL},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth: repoConfig.authMethod(),
+	})
+	if err != nil {
+		return fmt.Errorf("listing refs: %w", err)
+	}
+	if len(refs) == 0 {
+		return fmt.Errorf("repository has no refs")
+	}
+	return nil
+}
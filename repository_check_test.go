@@ -0,0 +1,43 @@
+package vignet_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestCheckRepositories(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"README.md": "# Infra\n",
+	}, gitserver.Options{})
+	unauthorizedSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(unauthorizedSrv.Close)
+
+	checks := vignet.CheckRepositories(context.Background(), vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"reachable":    {URL: gitSrv.URL},
+			"unauthorized": {URL: unauthorizedSrv.URL},
+			"my-group/*":   {URL: gitSrv.URL + "/{path}"},
+			"exchanged": {
+				URL:      gitSrv.URL,
+				Exchange: &vignet.ExchangeConfig{Type: vignet.ExchangeTypeGitLabJobToken},
+			},
+		},
+	})
+
+	require.Len(t, checks, 4)
+	assert.Equal(t, vignet.RepositoryCheck{Repo: "exchanged", Skipped: "credential exchange"}, checks[0])
+	assert.Equal(t, vignet.RepositoryCheck{Repo: "my-group/*", Skipped: "repository template"}, checks[1])
+	assert.Equal(t, vignet.RepositoryCheck{Repo: "reachable"}, checks[2])
+	assert.Equal(t, "unauthorized", checks[3].Repo)
+	assert.ErrorContains(t, checks[3].Err, "listing refs: authentication required")
+}
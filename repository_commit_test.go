@@ -0,0 +1,101 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_RepositoryCommitDefaults(t *testing.T) {
+	files := map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}
+	teamFs, teamSrv := startMockHttpGitServer(t, files, gitserver.Options{})
+	defaultFs, defaultSrv := startMockHttpGitServer(t, files, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"team": {
+				URL: teamSrv.URL,
+				Commit: &vignet.RepositoryCommitConfig{
+					DefaultMessage:  "chore: automated update",
+					DefaultAuthor:   &vignet.SignatureConfig{Name: "Team Bot", Email: "team-bot@example.com"},
+					MessageTemplate: "{{ .Message }} in {{ .Repo }}\n\nProject: {{ .AuthCtx.GitLabClaims.ProjectPath }}\n{{ range .Paths }}Path: {{ . }}\n{{ end }}",
+				},
+			},
+			"default": {URL: defaultSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+			DefaultAuthor:  vignet.SignatureConfig{Name: "vignet", Email: "bot@vignet"},
+		},
+	})
+
+	patch := func(t *testing.T, repo, body string) {
+		t.Helper()
+
+		req := httptest.NewRequest("POST", "/v1/patch/"+repo, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	t.Run("repository defaults", func(t *testing.T) {
+		patch(t, "team", `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`)
+
+		commit := gitRepoHeadCommit(t, teamFs)
+		assert.Equal(t, "chore: automated update in team\n\nProject: my-group/my-project\nPath: my-group/my-project/release.yml\n", commit.Message)
+		assert.Equal(t, "Team Bot", commit.Author.Name)
+		assert.Equal(t, "team-bot@example.com", commit.Author.Email)
+	})
+
+	t.Run("template with message of request", func(t *testing.T) {
+		patch(t, "team", `{"commit": {"message": "Release 1.2.3"}, "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "qux"}}]}`)
+
+		commit := gitRepoHeadCommit(t, teamFs)
+		assert.Equal(t, "Release 1.2.3 in team\n\nProject: my-group/my-project\nPath: my-group/my-project/release.yml\n", commit.Message)
+	})
+
+	t.Run("global defaults", func(t *testing.T) {
+		patch(t, "default", `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`)
+
+		commit := gitRepoHeadCommit(t, defaultFs)
+		assert.Equal(t, "Automated patch by vignet", commit.Message)
+		assert.Equal(t, "vignet", commit.Author.Name)
+		assert.Equal(t, "bot@vignet", commit.Author.Email)
+	})
+}
+
+func TestConfig_RepositoryCommitDefaults(t *testing.T) {
+	config := vignet.Config{
+		AuthenticationProvider: vignet.AuthenticationProviderConfig{
+			Type:   vignet.AuthenticationProviderGitLab,
+			GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.com"},
+		},
+		Repositories: vignet.RepositoriesConfig{
+			"team": {
+				URL: "https://gitlab.example.com/team/deployment.git",
+				Commit: &vignet.RepositoryCommitConfig{
+					DefaultAuthor: &vignet.SignatureConfig{Name: "Team Bot"},
+				},
+			},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	}
+	require.EqualError(t, config.Validate(), "invalid repositories.team: invalid commit: invalid defaultAuthor: email required")
+
+	config.Repositories["team"].Commit.DefaultAuthor = nil
+	config.Repositories["team"].Commit.MessageTemplate = "{{ .Message"
+	require.ErrorContains(t, config.Validate(), "invalid repositories.team: invalid commit: invalid messageTemplate: template: commitMessage:1: unclosed action")
+
+	config.Repositories["team"].Commit.MessageTemplate = ""
+	config.Commit.MessageTemplate = "{{ end }}"
+	require.ErrorContains(t, config.Validate(), "invalid commit.messageTemplate:")
+}
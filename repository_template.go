@@ -0,0 +1,92 @@
+package vignet
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// repositoryPathPlaceholder is replaced by the name of the repository in the URL of a repository template.
+const repositoryPathPlaceholder = "{path}"
+
+// repositoryPathSegmentRegexp matches a segment of a repository name that can be expanded into the URL of a template.
+var repositoryPathSegmentRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// isRepositoryTemplate returns true if the configured name of a repository is a pattern (e.g. "my-group/*"), the
+// configuration is then a template for all repositories with a matching name.
+func isRepositoryTemplate(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// validRepositoryTemplate checks the pattern and URL of a repository template.
+func validRepositoryTemplate(pattern string, c RepositoryConfig) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	if !strings.Contains(c.URL, repositoryPathPlaceholder) {
+		return fmt.Errorf("url of a template must contain %s", repositoryPathPlaceholder)
+	}
+	return nil
+}
+
+// lookup returns the configuration of a repository by name and the name it is configured with. If the name is not
+// configured, the most specific template matching the name is used and the placeholder in its URL is replaced by the
+// name.
+func (c RepositoriesConfig) lookup(name string) (string, RepositoryConfig, bool) {
+	key, exists := lookupRepositoryKey(c, name)
+	if !exists {
+		return "", RepositoryConfig{}, false
+	}
+	repoConfig := c[key]
+	if key != name {
+		repoConfig.URL = strings.ReplaceAll(repoConfig.URL, repositoryPathPlaceholder, name)
+	}
+	return key, repoConfig, true
+}
+
+// lookupRepositoryKey returns the key of a map by repository name, which is either the name itself or the longest
+// template matching the name.
+func lookupRepositoryKey[V any](m map[string]V, name string) (string, bool) {
+	if _, exists := m[name]; exists {
+		return name, true
+	}
+	if !validTemplatedRepositoryName(name) {
+		return "", false
+	}
+
+	var matches []string
+	for key := range m {
+		if !isRepositoryTemplate(key) {
+			continue
+		}
+		if matched, _ := path.Match(key, name); matched {
+			matches = append(matches, key)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i]) != len(matches[j]) {
+			return len(matches[i]) > len(matches[j])
+		}
+		return matches[i] < matches[j]
+	})
+	return matches[0], true
+}
+
+// validTemplatedRepositoryName returns true if the name can be expanded into the URL of a template, so a request
+// cannot reach other paths of the Git host (e.g. with "..").
+func validTemplatedRepositoryName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if !repositoryPathSegmentRegexp.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_RepositoryTemplates(t *testing.T) {
+	// A Git host serving repositories of a group by path
+	fileSystems := make(map[string]billy.Filesystem)
+	mux := http.NewServeMux()
+	for _, project := range []string{"project-a", "project-b", "sub/project-c"} {
+		fs := memfs.New()
+		initGitRepo(t, fs, map[string]string{
+			"my-group/my-project/release.yml": "project: " + project,
+		})
+		fileSystems[project] = fs
+		mux.Handle("/my-group/"+project+".git/", gitserver.New(fs, gitserver.Options{}))
+	}
+	gitSrv := httptest.NewServer(mux)
+	t.Cleanup(gitSrv.Close)
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"my-group/*": {URL: gitSrv.URL + "/{path}.git"},
+			// An explicitly configured repository takes precedence over a template
+			"my-group/project-b": {URL: gitSrv.URL + "/my-group/sub/project-c.git"},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+	sink := &recordingSink{}
+	handler.RegisterNotifier(sink)
+
+	readFile := func(repo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/repos/"+repo+"/file?path=my-group/my-project/release.yml", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("patch repository matching template", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch/my-group%2Fproject-a", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "project", "value": "patched"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assertGitRepoHeadCommit(t, fileSystems["project-a"], "Automated patch by vignet")
+
+		// Notifiers get the URL of the repository expanded from the template
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, gitSrv.URL+"/my-group/project-a.git", sink.events[0].RepoURL)
+	})
+
+	t.Run("batch patch repository matching template", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/patch", strings.NewReader(`{
+			"patches": [{"repo": "my-group/project-a", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "project", "value": "patched-again"}}]}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+
+		var res struct {
+			Results []struct {
+				Status int `json:"status"`
+			} `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Len(t, res.Results, 1)
+		assert.Equal(t, http.StatusOK, res.Results[0].Status)
+
+		rec = readFile("my-group%2Fproject-a")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "project: patched-again\n", rec.Body.String())
+	})
+
+	t.Run("configured repository takes precedence", func(t *testing.T) {
+		rec := readFile("my-group%2Fproject-b")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "project: sub/project-c", rec.Body.String())
+	})
+
+	for _, repo := range []string{
+		"other-group%2Fproject-a",
+		"my-group%2Fsub%2Fproject-c",
+		"my-group%2F..",
+		"my-group%2F",
+	} {
+		t.Run("not matching "+repo, func(t *testing.T) {
+			rec := readFile(repo)
+			require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestConfig_RepositoryTemplates(t *testing.T) {
+	config := vignet.Config{
+		AuthenticationProvider: vignet.AuthenticationProviderConfig{
+			Type:   vignet.AuthenticationProviderGitLab,
+			GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: "https://gitlab.example.com"},
+		},
+		Commit: vignet.DefaultConfig.Commit,
+	}
+
+	config.Repositories = vignet.RepositoriesConfig{
+		"my-group/*": {URL: "https://gitlab.example.com/{path}.git"},
+	}
+	require.NoError(t, config.Validate())
+
+	config.Repositories = vignet.RepositoriesConfig{
+		"my-group/*": {URL: "https://gitlab.example.com/my-group/project.git"},
+	}
+	require.EqualError(t, config.Validate(), "invalid repositories.my-group/*: url of a template must contain {path}")
+
+	config.Repositories = vignet.RepositoriesConfig{
+		"my-group/[": {URL: "https://gitlab.example.com/{path}.git"},
+	}
+	require.EqualError(t, config.Validate(), "invalid repositories.my-group/[: invalid pattern: syntax error in pattern")
+
+	config.Repositories = vignet.RepositoriesConfig{
+		"my-group": {URL: "https://gitlab.example.com/{path}.git"},
+	}
+	require.EqualError(t, config.Validate(), "invalid repositories.my-group: url contains {path}, but the name is not a pattern")
+}
@@ -0,0 +1,114 @@
+package vignet
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequestMetadata describes the HTTP request for policy decisions.
+type RequestMetadata struct {
+	// RemoteIP is the IP of the client, resolved from X-Forwarded-For if the request came through a trusted proxy.
+	RemoteIP string `json:"remoteIp"`
+	// UserAgent of the client.
+	UserAgent string `json:"userAgent"`
+	// Headers contains the configured subset of request headers (with canonical header names as keys).
+	Headers map[string]string `json:"headers"`
+}
+
+// sensitiveHeaders must never be exposed to the policy input.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", JobTokenHeader}
+
+func isSensitiveHeader(name string) bool {
+	for _, h := range sensitiveHeaders {
+		if http.CanonicalHeaderKey(name) == h {
+			return true
+		}
+	}
+	return false
+}
+
+// requestMetadataFromRequest builds the request metadata for the policy input.
+func (h *Handler) requestMetadataFromRequest(r *http.Request) RequestMetadata {
+	headers := make(map[string]string)
+	for _, name := range h.config.HTTP.PolicyHeaders {
+		if isSensitiveHeader(name) {
+			continue
+		}
+		if v := r.Header.Get(name); v != "" {
+			headers[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+
+	return RequestMetadata{
+		RemoteIP:  h.clientIP(r),
+		UserAgent: r.UserAgent(),
+		Headers:   headers,
+	}
+}
+
+// clientIP resolves the IP of the client. If the direct peer is a trusted proxy,
+// X-Forwarded-For is walked from right to left and the first untrusted address is returned.
+func (h *Handler) clientIP(r *http.Request) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+
+	if !h.isTrustedProxy(remoteIP) {
+		return remoteIP
+	}
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwardedFor[i])
+		if ip == "" {
+			continue
+		}
+		if !h.isTrustedProxy(ip) {
+			return ip
+		}
+		remoteIP = ip
+	}
+
+	return remoteIP
+}
+
+func (h *Handler) isTrustedProxy(ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, ipNet := range h.trustedProxies {
+		if ipNet.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses CIDRs or single IPs.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package vignet_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func loadTestBundle(t *testing.T, modules ...string) *bundle.Bundle {
+	t.Helper()
+
+	files := make(fstest.MapFS, len(modules))
+	for i, module := range modules {
+		files[fmt.Sprintf("test%d.rego", i)] = &fstest.MapFile{Data: []byte(module)}
+	}
+	fsLoader, err := bundle.NewFSLoader(files)
+	require.NoError(t, err)
+
+	b, err := bundle.NewCustomReader(fsLoader).Read()
+	require.NoError(t, err)
+
+	return &b
+}
+
+func TestHandler_RequestMetadataInPolicyInput(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	authorizer, err := vignet.NewRegoAuthorizer(context.Background(), loadTestBundle(t, `
+package vignet.request.patch
+import future.keywords
+
+violations contains msg if {
+	not net.cidr_contains("10.0.0.0/8", input.request.remoteIp)
+	msg := sprintf("remote IP %s not allowed", [input.request.remoteIp])
+}
+
+violations contains msg if {
+	input.request.headers["X-Runner"] != "trusted"
+	msg := "untrusted runner"
+}
+
+violations contains msg if {
+	input.request.headers.Authorization
+	msg := "authorization header exposed"
+}
+
+violations contains msg if {
+	input.request.headers["X-Vignet-Job-Token"]
+	msg := "job token header exposed"
+}
+`))
+	require.NoError(t, err)
+
+	handler := vignet.NewHandler(staticAuthenticationProvider{}, authorizer, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		HTTP: vignet.HTTPConfig{
+			TrustedProxies: []string{"192.0.2.0/24"},
+			PolicyHeaders:  []string{"X-Runner", "Authorization", "x-vignet-job-token"},
+		},
+	})
+
+	tt := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "client IP from trusted proxy",
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "10.1.2.3, 192.0.2.2",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "forwarded for from untrusted proxy is ignored",
+			remoteAddr:     "198.51.100.1:1234",
+			forwardedFor:   "10.1.2.3",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "remote IP 198.51.100.1 not allowed",
+		},
+		{
+			name:           "spoofed forwarded for is ignored",
+			remoteAddr:     "192.0.2.1:1234",
+			forwardedFor:   "10.1.2.3, 198.51.100.1",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "remote IP 198.51.100.1 not allowed",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{"commands":[{"path":"release.yml","setField":{"field":"foo","value":"baz"}}]}`))
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			req.Header.Set("X-Runner", "trusted")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set(vignet.JobTokenHeader, "job-token")
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.expectedStatus, rec.Code, rec.Body.String())
+			if tc.expectedError != "" {
+				require.Contains(t, rec.Body.String(), tc.expectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package vignet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/networkteam/vignet/httputil"
+)
+
+// textResponse is a response that can also be written as plain text for humans, e.g. in the log of a CI job.
+type textResponse interface {
+	writeText(w io.Writer)
+}
+
+// respondNegotiated responds with res as JSON or as plain text if the Accept header of the request prefers text/plain.
+// JSON is the default, so clients that do not send an Accept header get the structured response.
+func respondNegotiated(w http.ResponseWriter, r *http.Request, statusCode int, res textResponse) {
+	w.Header().Add("Vary", "Accept")
+
+	contentType := httputil.NegotiateContentType(r, []string{"application/json", "text/plain"}, "application/json")
+	switch contentType {
+	case "text/plain":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(statusCode)
+		res.writeText(w)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func (res *patchResponse) writeText(w io.Writer) {
+	switch {
+	case res.DryRun:
+		fmt.Fprintf(w, "Dry run on branch %s, nothing was pushed\n", res.Branch)
+	case res.Commit != "":
+		fmt.Fprintf(w, "Pushed commit %s to branch %s\n", res.Commit, res.Branch)
+	default:
+		fmt.Fprintf(w, "No changes on branch %s, nothing was pushed\n", res.Branch)
+	}
+	if res.PullRequest != nil {
+		fmt.Fprintf(w, "Created pull request #%d: %s\n", res.PullRequest.Number, res.PullRequest.URL)
+	}
+	if res.MergeRequest != nil {
+		fmt.Fprintf(w, "Created merge request !%d: %s\n", res.MergeRequest.IID, res.MergeRequest.URL)
+	}
+	for i, cmd := range res.Commands {
+		fmt.Fprintf(w, "commands[%d]: %s\n", i, cmd.text())
+	}
+	if res.Diff != "" {
+		fmt.Fprintf(w, "\n%s", res.Diff)
+	}
+}
+
+// text summarizes the result of the command in a line.
+func (res patchCommandResponse) text() string {
+	if res.Skipped {
+		if res.Error != "" {
+			return "skipped: " + res.Error
+		}
+		return "skipped"
+	}
+
+	parts := []string{"applied"}
+	if len(res.ChangedFiles) > 0 {
+		parts = append(parts, "changed "+strings.Join(res.ChangedFiles, ", "))
+	} else if res.Tag == "" {
+		parts = append(parts, "no changes")
+	}
+	if res.Field != "" {
+		parts = append(parts, fmt.Sprintf("%s: %s -> %s", res.Field, textValue(res.OldValue), textValue(res.NewValue)))
+	}
+	if res.Tag != "" {
+		parts = append(parts, "tag "+res.Tag)
+	}
+	if res.Version != "" {
+		parts = append(parts, "version "+res.Version)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// textValue formats a field value as JSON, so strings and missing values can be distinguished.
+func textValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func (res *batchPatchResponse) writeText(w io.Writer) {
+	for i, result := range res.Results {
+		fmt.Fprintf(w, "patches[%d] (%s): %d %s\n", i, result.Repo, result.Status, http.StatusText(result.Status))
+		if result.Error != nil {
+			fmt.Fprintf(w, "%s", indent(result.Error.text()))
+			continue
+		}
+		if result.Patch != nil {
+			var b strings.Builder
+			result.Patch.writeText(&b)
+			fmt.Fprintf(w, "%s", indent(b.String()))
+		}
+		if result.RevertCommit != "" {
+			fmt.Fprintf(w, "  Rolled back by %s\n", result.RevertCommit)
+		}
+		if result.RollbackError != nil {
+			fmt.Fprintf(w, "%s", indent(result.RollbackError.text()))
+		}
+	}
+}
+
+// text formats the error like a plain text error response.
+func (res errorResponse) text() string {
+	if res.Error != "" {
+		return fmt.Sprintf("%s: %s\n", res.Cause, res.Error)
+	}
+	return res.Cause + "\n"
+}
+
+// indent indents all non-empty lines of s.
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = "  " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
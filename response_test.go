@@ -0,0 +1,89 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_PatchResponseNegotiation(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Automated patch by vignet",
+		},
+	})
+
+	patch := func(path, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	setField := func(value string) string {
+		return `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "` + value + `"}}]}`
+	}
+
+	for i, accept := range []string{"", "*/*", "application/json", "text/plain;q=0.5, application/json"} {
+		t.Run("JSON for Accept "+accept, func(t *testing.T) {
+			rec := patch("/v1/patch/e2e-test", accept, setField(fmt.Sprintf("json-%d", i)))
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+
+			var res struct {
+				Commit string `json:"commit"`
+				Branch string `json:"branch"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+			assert.Equal(t, gitRepoHeadCommit(t, fs).Hash.String(), res.Commit)
+			assert.Equal(t, "master", res.Branch)
+		})
+	}
+
+	t.Run("text", func(t *testing.T) {
+		rec := patch("/v1/patch/e2e-test", "text/plain", setField("text"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Pushed commit "+gitRepoHeadCommit(t, fs).Hash.String()+" to branch master\n"+
+			`commands[0]: applied, changed my-group/my-project/release.yml, foo: "json-3" -> "text"`+"\n", rec.Body.String())
+	})
+
+	t.Run("text for preview", func(t *testing.T) {
+		rec := patch("/v1/patch/e2e-test/preview", "text/plain", setField("preview"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.True(t, strings.HasPrefix(rec.Body.String(), "Dry run on branch master, nothing was pushed\n"), rec.Body.String())
+		assert.Contains(t, rec.Body.String(), "\n-foo: text\n+foo: preview\n")
+	})
+
+	t.Run("text for batch", func(t *testing.T) {
+		rec := patch("/v1/patch", "text/plain", `{"patches": [
+			{"repo": "e2e-test", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "batch"}}]},
+			{"repo": "unknown", "commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "batch"}}]}
+		]}`)
+		require.Equal(t, http.StatusMultiStatus, rec.Code, rec.Body.String())
+		assert.Equal(t, "patches[0] (e2e-test): 200 OK\n"+
+			"  Pushed commit "+gitRepoHeadCommit(t, fs).Hash.String()+" to branch master\n"+
+			`  commands[0]: applied, changed my-group/my-project/release.yml, foo: "text" -> "batch"`+"\n"+
+			"patches[1] (unknown): 404 Not Found\n"+
+			"  Unknown repository: repository \"unknown\" not configured\n", rec.Body.String())
+	})
+}
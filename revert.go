@@ -0,0 +1,300 @@
+package vignet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// revertPatch pushes a commit to the branch of a pushed patch that restores the files changed by the commit of the
+// patch. Tags created by the patch are kept. The revert is authorized by the revert action and fails with a conflict if
+// a later commit changed the same files, the commit is signed and authored like the commit of the patch.
+//
+// Each revert is audited with ActionRevert and the reverted commit, so the reverted patch is not replayed. Notifiers
+// are notified about the pushed revert commit.
+func (h *Handler) revertPatch(r *http.Request, repoName string, req patchRequest, patchRes *patchResponse) (string, error) {
+	ctx := r.Context()
+	authCtx := authCtxFromCtx(ctx)
+
+	auditRecord := AuditRecord{
+		Time:           time.Now(),
+		Action:         ActionRevert,
+		Repo:           repoName,
+		AuthCtx:        authCtx,
+		RevertedCommit: patchRes.Commit,
+		Paths:          req.paths(),
+	}
+	_, repoConfig, exists := h.lookupRepository(repoName)
+	if !exists {
+		err := fmt.Errorf("repository %q not configured", repoName)
+		h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+		return "", err
+	}
+
+	revertHash, message, err := h.pushRevert(r, repoName, repoConfig, req, patchRes)
+	if err != nil {
+		var denied deniedError
+		if errors.As(err, &denied) {
+			h.auditFailed(ctx, auditRecord, AuditOutcomeDenied, err)
+		} else {
+			h.auditFailed(ctx, auditRecord, AuditOutcomeFailed, err)
+		}
+		return "", err
+	}
+
+	auditRecord.Outcome = AuditOutcomeSucceeded
+	auditRecord.CommitHash = revertHash
+	h.audit(ctx, auditRecord)
+	h.notify(ctx, PatchEvent{
+		Time:           time.Now(),
+		Repo:           repoName,
+		RepoURL:        repoConfig.URL,
+		AuthCtx:        authCtx,
+		CommitHash:     revertHash,
+		RevertedCommit: patchRes.Commit,
+		Message:        message,
+		Paths:          auditRecord.Paths,
+	})
+
+	return revertHash, nil
+}
+
+// pushRevert authorizes, commits and pushes the revert of a patch and returns the hash and message of the revert commit.
+func (h *Handler) pushRevert(r *http.Request, repoName string, repoConfig RepositoryConfig, req patchRequest, patchRes *patchResponse) (string, string, error) {
+	ctx := r.Context()
+
+	redactedReq := req.redacted()
+	err := h.authorizer.Authorize(ctx, authorizationInput{
+		Action:       ActionRevert,
+		Repo:         repoName,
+		AuthCtx:      authCtxFromCtx(ctx),
+		Request:      h.requestMetadataFromRequest(r),
+		PatchRequest: &redactedReq,
+		RevertRequest: &revertRequest{
+			Commit: patchRes.Commit,
+			Branch: patchRes.Branch,
+		},
+	})
+	if err != nil {
+		if v, ok := err.(ViolationsResolver); ok {
+			return "", "", deniedError{violationsClientError(v)}
+		}
+		return "", "", fmt.Errorf("authorizing revert: %w", err)
+	}
+
+	repoConfig, err = h.exchangeCredential(ctx, r, repoName, repoConfig)
+	if err != nil {
+		return "", "", err
+	}
+	_, commitOptions, err := h.buildCommitMsgAndOptions(ctx, repoName, repoConfig, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	lockCtx, unlock, err := h.locker.Lock(ctx, repoName)
+	if err != nil {
+		return "", "", err
+	}
+	defer unlock()
+	ctx = lockCtx
+
+	fs := memfs.New()
+	authMethod := repoConfig.authMethod()
+	var repo *git.Repository
+	cloneStart := time.Now()
+	err = withTimeout(ctx, "clone", h.config.Timeouts.clone(), func(ctx context.Context) (err error) {
+		repo, err = git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{
+			URL:           repoConfig.URL,
+			Auth:          authMethod,
+			ReferenceName: plumbing.NewBranchReferenceName(patchRes.Branch),
+			SingleBranch:  true,
+		})
+		return err
+	})
+	accessLogEntryFromCtx(ctx).recordGitTiming("clone", time.Since(cloneStart))
+	if err != nil {
+		return "", "", lockLostError(ctx, fmt.Errorf("cloning repository: %w", err))
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(patchRes.Commit))
+	if err != nil {
+		return "", "", fmt.Errorf("getting commit %s: %w", patchRes.Commit, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", "", fmt.Errorf("getting HEAD of repository: %w", err)
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", "", fmt.Errorf("getting HEAD commit: %w", err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return "", "", fmt.Errorf("getting worktree for repository: %w", err)
+	}
+	err = revertChanges(fs, w, commit, headCommit)
+	if err != nil {
+		return "", "", err
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	message := fmt.Sprintf("Revert %q\n\nThis reverts commit %s.\n", subject, commit.Hash)
+	revertHash, err := w.Commit(message, commitOptions)
+	if err != nil {
+		return "", "", fmt.Errorf("committing revert: %w", err)
+	}
+
+	pushStart := time.Now()
+	err = withTimeout(ctx, "push", h.config.Timeouts.push(), func(ctx context.Context) error {
+		return repo.PushContext(ctx, &git.PushOptions{
+			RemoteName: "origin",
+			Auth:       authMethod,
+			Options:    buildPushOptions(repoConfig.PushOptions, req.PushOptions),
+		})
+	})
+	accessLogEntryFromCtx(ctx).recordGitTiming("push", time.Since(pushStart))
+	if err != nil {
+		return "", "", lockLostError(ctx, fmt.Errorf("pushing to repository: %w", err))
+	}
+
+	log.
+		WithField("repoName", repoName).
+		WithField("repoUrl", repoConfig.URL).
+		WithField("branch", patchRes.Branch).
+		WithField("commitHash", revertHash).
+		WithField("revertedCommitHash", commit.Hash).
+		Info("Pushed revert commit to repository")
+
+	return revertHash.String(), message, nil
+}
+
+// revertChanges restores the files changed by commit to the state of its parent in the worktree. All files must be
+// unchanged since commit.
+func revertChanges(fs billy.Filesystem, w *git.Worktree, commit, head *object.Commit) error {
+	if commit.NumParents() != 1 {
+		return fmt.Errorf("commit %s has %d parents, only commits with one parent can be reverted", commit.Hash, commit.NumParents())
+	}
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return fmt.Errorf("getting parent of commit: %w", err)
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return fmt.Errorf("getting tree of parent commit: %w", err)
+	}
+	commitTree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("getting tree of commit: %w", err)
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return fmt.Errorf("getting tree of HEAD commit: %w", err)
+	}
+	changes, err := object.DiffTree(parentTree, commitTree)
+	if err != nil {
+		return fmt.Errorf("comparing commit with parent: %w", err)
+	}
+
+	for _, change := range changes {
+		for _, p := range changedPaths(change) {
+			if treeEntry(commitTree, p) != treeEntry(headTree, p) {
+				err := fmt.Errorf("file %q was changed since commit %s", p, commit.Hash)
+				return clientError{err, http.StatusConflict}
+			}
+		}
+	}
+
+	for _, change := range changes {
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			if _, err := w.Remove(change.To.Name); err != nil {
+				return fmt.Errorf("removing %q: %w", change.To.Name, err)
+			}
+		}
+		if change.From.Name != "" {
+			if err := restoreFile(fs, parentTree, change.From.Name); err != nil {
+				return err
+			}
+			if _, err := w.Add(change.From.Name); err != nil {
+				return fmt.Errorf("adding %q: %w", change.From.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// changedPaths returns the paths before and after a change.
+func changedPaths(change *object.Change) []string {
+	var paths []string
+	if change.From.Name != "" {
+		paths = append(paths, change.From.Name)
+	}
+	if change.To.Name != "" && change.To.Name != change.From.Name {
+		paths = append(paths, change.To.Name)
+	}
+	return paths
+}
+
+// entryState is the content and mode of a file in a tree.
+type entryState struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// treeEntry returns the state of the file at path in tree, or the zero state if the file does not exist.
+// The mode is compared as well, so a later setMode command is a change of the file.
+func treeEntry(tree *object.Tree, path string) entryState {
+	entry, err := tree.FindEntry(path)
+	if err != nil {
+		return entryState{}
+	}
+	return entryState{hash: entry.Hash, mode: entry.Mode}
+}
+
+// restoreFile writes the content and mode of the file at path in tree to fs. An existing file is removed first, so
+// the mode is restored as well (like for setMode).
+func restoreFile(fs billy.Filesystem, tree *object.Tree, path string) error {
+	file, err := tree.File(path)
+	if err != nil {
+		return fmt.Errorf("getting %q of parent commit: %w", path, err)
+	}
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return fmt.Errorf("getting mode of %q: %w", path, err)
+	}
+	reader, err := file.Reader()
+	if err != nil {
+		return fmt.Errorf("opening %q of parent commit: %w", path, err)
+	}
+	defer reader.Close()
+
+	if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %q: %w", path, err)
+	}
+	f, err := fs.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", path, err)
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package vignet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// selfTestProjectPath is the GitLab project path of the authentication context of self-test requests.
+const selfTestProjectPath = "vignet-self-test/project"
+
+type selfTestCase struct {
+	name    string
+	patch   *patchRequest
+	read    *readRequest
+	authCtx AuthCtx
+}
+
+// selfTestCases are representative requests, the policy must evaluate them without errors (violations are expected).
+func selfTestCases() []selfTestCase {
+	authCtx := AuthCtx{
+		GitLabClaims: &GitLabClaims{
+			ProjectPath:    selfTestProjectPath,
+			NamespacePath:  "vignet-self-test",
+			UserLogin:      "vignet-self-test",
+			Ref:            "main",
+			RefType:        "branch",
+			RefProtected:   "true",
+			PipelineSource: "push",
+		},
+	}
+	path := selfTestProjectPath + "/release.yml"
+
+	return []selfTestCase{
+		{
+			name: "setField",
+			patch: &patchRequest{
+				Commit:   patchRequestCommit{Message: "Self-test"},
+				Commands: []patchRequestCommand{{Path: path, SetField: &setFieldPatchRequestCommand{Field: "image.tag", Value: "1.0.0"}}},
+			},
+			authCtx: authCtx,
+		},
+		{
+			name: "createFile and deleteFile",
+			patch: &patchRequest{
+				Commands: []patchRequestCommand{
+					{Path: path, CreateFile: &createFilePatchRequestCommand{Content: "image:\n  tag: 1.0.0\n"}},
+					{Path: path, DeleteFile: &deleteFilePatchRequestCommand{}},
+				},
+			},
+			authCtx: authCtx,
+		},
+		{
+			name: "createTag with mergeRequest",
+			patch: &patchRequest{
+				Commands: []patchRequestCommand{
+					{Path: path, EnsureFields: &ensureFieldsPatchRequestCommand{Fields: map[string]any{"version": "1.0.0"}}},
+					{CreateTag: &createTagPatchRequestCommand{Name: selfTestProjectPath + "/v1.0.0"}},
+				},
+				MergeRequest: &patchRequestPullRequest{Title: "Self-test"},
+			},
+			authCtx: authCtx,
+		},
+		{
+			name:    "read promotions",
+			read:    &readRequest{Resource: "promotions"},
+			authCtx: authCtx,
+		},
+		{
+			name:    "read file",
+			read:    &readRequest{Resource: "file", Path: path, Field: "image.tag"},
+			authCtx: authCtx,
+		},
+	}
+}
+
+// configChecker is implemented by authorizers that can check the configuration requirements of the policy.
+type configChecker interface {
+	CheckConfig(ctx context.Context, config Config) error
+}
+
+// SelfTest checks that the policy of the authorizer is compatible with this version and the configuration.
+// It evaluates representative requests for each repository and the configuration requirements of the policy
+// (violations of "data.vignet.config.violations"), so incompatibilities are reported on startup instead of on requests.
+func SelfTest(ctx context.Context, authorizer Authorizer, config Config) error {
+	if ra, ok := authorizer.(*RegoAuthorizer); ok {
+		if !ra.hasPackage(ActionPatch.packagePath()) {
+			return errors.New("policy does not define package vignet.request.patch, patch requests would not be authorized by the policy")
+		}
+		if !ra.hasPackage(ActionRead.packagePath()) {
+			log.Warn("Policy does not define package vignet.request.read, all read requests (e.g. promotions) are denied")
+		}
+		if !ra.hasPackage(ActionTag.packagePath()) {
+			log.Info("Policy does not define package vignet.request.tag, tags are only authorized by the patch policy")
+		}
+		if !ra.hasPackage(ActionCreateBranch.packagePath()) {
+			log.Warn("Policy does not define package vignet.request.createBranch, all pull and merge requests are denied")
+		}
+		if !ra.hasPackage(ActionPreview.packagePath()) {
+			log.Info("Policy does not define package vignet.request.preview, previews are authorized by the patch policy")
+		}
+		if !ra.hasPackage(ActionRevert.packagePath()) {
+			log.Info("Policy does not define package vignet.request.revert, reverts are authorized by the patch policy")
+		}
+	}
+	if cc, ok := authorizer.(configChecker); ok {
+		if err := cc.CheckConfig(ctx, config); err != nil {
+			var v ViolationsResolver
+			if errors.As(err, &v) {
+				return fmt.Errorf("configuration does not meet requirements of policy:\n- %s", strings.Join(v.Violations(), "\n- "))
+			}
+			return fmt.Errorf("checking configuration requirements of policy: %w", err)
+		}
+	}
+
+	repoNames := make([]string, 0, len(config.Repositories))
+	for repoName := range config.Repositories {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+
+	requestMetadata := RequestMetadata{
+		RemoteIP:  "127.0.0.1",
+		UserAgent: "vignet-self-test",
+		Headers:   make(map[string]string),
+	}
+	for _, name := range config.HTTP.PolicyHeaders {
+		requestMetadata.Headers[http.CanonicalHeaderKey(name)] = "self-test"
+	}
+
+	for _, repoName := range repoNames {
+		for _, tc := range selfTestCases() {
+			var inputs []authorizationInput
+			if tc.patch != nil {
+				inputs = patchActionInputs(ActionPatch, repoName, tc.authCtx, requestMetadata, *tc.patch)
+			} else {
+				inputs = []authorizationInput{{
+					Action:      ActionRead,
+					Repo:        repoName,
+					AuthCtx:     tc.authCtx,
+					Request:     requestMetadata,
+					ReadRequest: tc.read,
+				}}
+			}
+			for _, input := range inputs {
+				err := authorizer.Authorize(ctx, input)
+				var v ViolationsResolver
+				if err != nil && !errors.As(err, &v) {
+					return fmt.Errorf("evaluating policy for %s request on repository %q (%s action): %w", tc.name, repoName, input.Action, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// configInput is the policy input for checking configuration requirements, it contains no secrets.
+type configInput struct {
+	// AuthenticationProvider is the type of the first authentication provider.
+	AuthenticationProvider AuthenticationProviderType `json:"authenticationProvider"`
+	// AuthenticationProviders are the names of the authentication providers in order.
+	AuthenticationProviders []string                         `json:"authenticationProviders"`
+	Repositories            map[string]repositoryConfigInput `json:"repositories"`
+	HTTP                    httpConfigInput                  `json:"http"`
+}
+
+type repositoryConfigInput struct {
+	Provider     RepositoryProvider `json:"provider"`
+	PushOptions  []string           `json:"pushOptions"`
+	AllowedPaths []string           `json:"allowedPaths"`
+	Signing      bool               `json:"signing"`
+	SOPS         bool               `json:"sops"`
+	Changelog    bool               `json:"changelog"`
+	Exchange     ExchangeType       `json:"exchange"`
+}
+
+type httpConfigInput struct {
+	PolicyHeaders  []string `json:"policyHeaders"`
+	TrustedProxies bool     `json:"trustedProxies"`
+}
+
+func newConfigInput(config Config) configInput {
+	input := configInput{
+		AuthenticationProviders: []string{},
+		Repositories:            make(map[string]repositoryConfigInput, len(config.Repositories)),
+		HTTP: httpConfigInput{
+			PolicyHeaders:  make([]string, 0, len(config.HTTP.PolicyHeaders)),
+			TrustedProxies: len(config.HTTP.TrustedProxies) > 0,
+		},
+	}
+	for i, p := range config.AuthenticationProviderConfigs() {
+		if i == 0 {
+			input.AuthenticationProvider = p.Type
+		}
+		input.AuthenticationProviders = append(input.AuthenticationProviders, p.name())
+	}
+	for _, name := range config.HTTP.PolicyHeaders {
+		input.HTTP.PolicyHeaders = append(input.HTTP.PolicyHeaders, http.CanonicalHeaderKey(name))
+	}
+	for repoName, repoConfig := range config.Repositories {
+		repoInput := repositoryConfigInput{
+			Provider:     repoConfig.Provider,
+			PushOptions:  repoConfig.PushOptions,
+			AllowedPaths: repoConfig.AllowedPaths,
+			Signing:      repoConfig.SigningKey != nil || config.Commit.SigningKey != nil,
+			SOPS:         repoConfig.SOPS != nil,
+			Changelog:    repoConfig.Changelog != nil,
+		}
+		if repoInput.PushOptions == nil {
+			repoInput.PushOptions = []string{}
+		}
+		if repoInput.AllowedPaths == nil {
+			repoInput.AllowedPaths = []string{}
+		}
+		if repoConfig.Exchange != nil {
+			repoInput.Exchange = repoConfig.Exchange.Type
+		}
+		input.Repositories[repoName] = repoInput
+	}
+	return input
+}
@@ -0,0 +1,122 @@
+package vignet_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/policy"
+)
+
+func TestSelfTest(t *testing.T) {
+	config := vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"infra": {URL: "https://git.example.com/infra.git"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		modules       map[string]string
+		config        func(c *vignet.Config)
+		expectedError string
+	}{
+		{
+			name: "default policy",
+		},
+		{
+			name: "missing patch package",
+			modules: map[string]string{
+				"request.rego": `package vignet.request
+violations := set()
+`,
+			},
+			expectedError: "policy does not define package vignet.request.patch",
+		},
+		{
+			name: "evaluation error",
+			modules: map[string]string{
+				"request-patch.rego": `package vignet.request.patch
+import future.keywords
+
+violations contains msg if {
+	some cmd in input.patchRequest.commands
+	to_number(cmd.path) > 1
+	msg := "numeric path"
+}
+`,
+			},
+			expectedError: `evaluating policy for setField request on repository "infra"`,
+		},
+		{
+			name: "unmet config requirement",
+			modules: map[string]string{
+				"request-patch.rego": `package vignet.request.patch
+violations := set()
+`,
+				"config.rego": `package vignet.config
+import future.keywords
+
+violations contains msg if {
+	not "X-Runner" in input.http.policyHeaders
+	msg := "http.policyHeaders must contain X-Runner"
+}
+`,
+			},
+			expectedError: "configuration does not meet requirements of policy:\n- http.policyHeaders must contain X-Runner",
+		},
+		{
+			name: "met config requirement",
+			modules: map[string]string{
+				"request-patch.rego": `package vignet.request.patch
+violations := set()
+`,
+				"config.rego": `package vignet.config
+import future.keywords
+
+violations contains msg if {
+	not "X-Runner" in input.http.policyHeaders
+	msg := "http.policyHeaders must contain X-Runner"
+}
+`,
+			},
+			config: func(c *vignet.Config) {
+				c.HTTP.PolicyHeaders = []string{"x-runner"}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			b, err := policy.LoadDefaultBundle()
+			require.NoError(t, err)
+			if tc.modules != nil {
+				dir := t.TempDir()
+				for name, content := range tc.modules {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+				}
+				b, err = policy.LoadBundle(dir)
+				require.NoError(t, err)
+			}
+			authorizer, err := vignet.NewRegoAuthorizer(ctx, b)
+			require.NoError(t, err)
+
+			c := config
+			if tc.config != nil {
+				tc.config(&c)
+			}
+
+			err = vignet.SelfTest(ctx, authorizer, c)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
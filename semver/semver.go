@@ -0,0 +1,146 @@
+// Package semver parses and bumps semantic versions (see https://semver.org).
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, e.g. "1.2.3-rc.1+build.5".
+type Version struct {
+	// Prefix is an optional "v" in front of the version, it is kept when the version is bumped.
+	Prefix     string
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+	Build      string
+}
+
+// Part is the part of a version to bump.
+type Part string
+
+const (
+	Major Part = "major"
+	Minor Part = "minor"
+	Patch Part = "patch"
+)
+
+// Valid returns an error if the part is unknown.
+func (p Part) Valid() error {
+	switch p {
+	case Major, Minor, Patch:
+		return nil
+	}
+	return fmt.Errorf("unknown part %q, expected one of major, minor or patch", string(p))
+}
+
+// Parse parses a semantic version with an optional "v" prefix.
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := s
+	if strings.HasPrefix(rest, "v") {
+		v.Prefix = "v"
+		rest = rest[1:]
+	}
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build, false) {
+			return Version{}, fmt.Errorf("invalid build metadata in version %q", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Prerelease, true) {
+			return Version{}, fmt.Errorf("invalid pre-release in version %q", s)
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q, expected MAJOR.MINOR.PATCH", s)
+	}
+	numbers := make([]uint64, 3)
+	for i, part := range parts {
+		if !isNumeric(part) || len(part) > 1 && part[0] == '0' {
+			return Version{}, fmt.Errorf("invalid version %q, %q is not a number without leading zeros", s, part)
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version %q: %w", s, err)
+		}
+		numbers[i] = n
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+
+	return v, nil
+}
+
+// Bump returns the next version for the part, build metadata is dropped.
+// A pre-release is released without incrementing if the part is already the
+// next version (e.g. a patch bump of "1.2.3-rc.1" is "1.2.3").
+func (v Version) Bump(part Part) Version {
+	next := Version{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	switch part {
+	case Major:
+		if v.Prerelease == "" || v.Minor != 0 || v.Patch != 0 {
+			next.Major++
+		}
+		next.Minor = 0
+		next.Patch = 0
+	case Minor:
+		if v.Prerelease == "" || v.Patch != 0 {
+			next.Minor++
+		}
+		next.Patch = 0
+	case Patch:
+		if v.Prerelease == "" {
+			next.Patch++
+		}
+	}
+	return next
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// validIdentifiers checks dot separated identifiers, numeric identifiers of a pre-release must not have leading zeros.
+func validIdentifiers(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '-') {
+				return false
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package semver_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/semver"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		version     string
+		expected    semver.Version
+		expectedErr string
+	}{
+		{
+			version:  "1.2.3",
+			expected: semver.Version{Major: 1, Minor: 2, Patch: 3},
+		},
+		{
+			version:  "v0.10.0-rc.1+build-5",
+			expected: semver.Version{Prefix: "v", Minor: 10, Prerelease: "rc.1", Build: "build-5"},
+		},
+		{
+			version:     "1.2",
+			expectedErr: "expected MAJOR.MINOR.PATCH",
+		},
+		{
+			version:     "1.02.3",
+			expectedErr: "not a number without leading zeros",
+		},
+		{
+			version:     "1.2.3-rc.01",
+			expectedErr: "invalid pre-release",
+		},
+		{
+			version:     "1.2.3+",
+			expectedErr: "invalid build metadata",
+		},
+		{
+			version:     "latest",
+			expectedErr: "expected MAJOR.MINOR.PATCH",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			v, err := semver.Parse(tt.version)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v)
+			assert.Equal(t, tt.version, v.String())
+		})
+	}
+}
+
+func TestVersion_Bump(t *testing.T) {
+	tests := []struct {
+		version  string
+		part     semver.Part
+		expected string
+	}{
+		{version: "1.2.3", part: semver.Patch, expected: "1.2.4"},
+		{version: "1.2.3", part: semver.Minor, expected: "1.3.0"},
+		{version: "1.2.3", part: semver.Major, expected: "2.0.0"},
+		{version: "v1.2.3+build.1", part: semver.Patch, expected: "v1.2.4"},
+		{version: "1.2.3-rc.1", part: semver.Patch, expected: "1.2.3"},
+		{version: "1.2.3-rc.1", part: semver.Minor, expected: "1.3.0"},
+		{version: "1.3.0-rc.1", part: semver.Minor, expected: "1.3.0"},
+		{version: "2.0.0-rc.1", part: semver.Major, expected: "2.0.0"},
+		{version: "2.1.0-rc.1", part: semver.Major, expected: "3.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+string(tt.part), func(t *testing.T) {
+			v, err := semver.Parse(tt.version)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, v.Bump(tt.part).String())
+		})
+	}
+}
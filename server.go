@@ -0,0 +1,487 @@
+package vignet
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/apex/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/networkteam/vignet/policy"
+)
+
+// Server wires configuration, authentication, authorization and the HTTP handler
+// and manages the lifecycle of the HTTP server. It can be used to embed vignet in another program.
+type Server struct {
+	config                 Config
+	address                string
+	authenticationProvider AuthenticationProvider
+	authorizer             Authorizer
+	notifiers              []Notifier
+	auditSinks             []AuditSink
+	locker                 Locker
+	exchangers             map[string]CredentialExchanger
+	reloadFunc             ReloadFunc
+	// discovered repositories are kept across reloads
+	discovered *discoveredRepositories
+	// replayStores of authentication providers built from the configuration are kept across reloads
+	replayStores *TokenReplayStores
+	// injectedAuthenticationProvider and injectedAuthorizer are the instances given by options, they are kept on
+	// reload unless other instances are given. They are nil if built from the configuration.
+	injectedAuthenticationProvider AuthenticationProvider
+	injectedAuthorizer             Authorizer
+	// ctx is the context of NewServer. Authentication providers and authorizers built from the configuration get a
+	// context derived from it, which is cancelled by cancelGeneration when they are replaced by a reload or on Stop.
+	ctx              context.Context
+	cancelGeneration context.CancelFunc
+
+	// configAuditSinks are built from the configuration once, so files are not opened again on reload.
+	configAuditSinks []AuditSink
+	// handler is replaced atomically on reload.
+	handler atomic.Pointer[Handler]
+	admin   http.Handler
+	// reloadMu serializes Reload, adminReloadMu serializes the reload function of the admin endpoint that calls Reload.
+	reloadMu      sync.Mutex
+	adminReloadMu sync.Mutex
+
+	httpServer      *http.Server
+	listener        net.Listener
+	done            chan struct{}
+	discoveryCancel context.CancelFunc
+
+	adminServer   *http.Server
+	adminListener net.Listener
+	adminDone     chan struct{}
+}
+
+// ServerOption configures a Server.
+type ServerOption func(s *Server)
+
+// WithConfig sets the configuration, DefaultConfig is used if not given.
+func WithConfig(config Config) ServerOption {
+	return func(s *Server) {
+		s.config = config
+	}
+}
+
+// WithAddress sets the address for the HTTP server to listen on, defaults to ":8080".
+func WithAddress(address string) ServerOption {
+	return func(s *Server) {
+		s.address = address
+	}
+}
+
+// WithAuthenticationProvider sets the authentication provider instead of building it from the configuration.
+func WithAuthenticationProvider(p AuthenticationProvider) ServerOption {
+	return func(s *Server) {
+		s.authenticationProvider = p
+	}
+}
+
+// WithAuthorizer sets the authorizer. If not given, an OPAServerAuthorizer or RulesAuthorizer is used if configured,
+// otherwise a RegoAuthorizer with the default policy bundle.
+func WithAuthorizer(a Authorizer) ServerOption {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+// WithNotifier registers a notifier on the handler.
+func WithNotifier(n Notifier) ServerOption {
+	return func(s *Server) {
+		s.notifiers = append(s.notifiers, n)
+	}
+}
+
+// WithAuditSink registers an audit sink on the handler.
+func WithAuditSink(a AuditSink) ServerOption {
+	return func(s *Server) {
+		s.auditSinks = append(s.auditSinks, a)
+	}
+}
+
+// WithLocker sets the locker to serialize operations on a repository instead of building it from the configuration.
+func WithLocker(l Locker) ServerOption {
+	return func(s *Server) {
+		s.locker = l
+	}
+}
+
+// WithCredentialExchanger sets the exchanger for credentials of a repository instead of the configured exchange.
+func WithCredentialExchanger(repoName string, e CredentialExchanger) ServerOption {
+	return func(s *Server) {
+		if s.exchangers == nil {
+			s.exchangers = make(map[string]CredentialExchanger)
+		}
+		s.exchangers[repoName] = e
+	}
+}
+
+// NewServer creates a new server with the given options.
+//
+// The context is passed to the authentication provider and authorizer if they are built by the server
+// (e.g. to cancel the refreshing of keys).
+func NewServer(ctx context.Context, opts ...ServerOption) (*Server, error) {
+	s := &Server{
+		ctx:          ctx,
+		config:       DefaultConfig,
+		address:      ":8080",
+		discovered:   &discoveredRepositories{},
+		replayStores: NewTokenReplayStores(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.injectedAuthenticationProvider = s.authenticationProvider
+	s.injectedAuthorizer = s.authorizer
+
+	if s.locker == nil {
+		l, err := s.config.BuildLocker()
+		if err != nil {
+			return nil, fmt.Errorf("building locker: %w", err)
+		}
+		s.locker = l
+	}
+
+	auditSinks, err := s.config.BuildAuditSinks()
+	if err != nil {
+		return nil, fmt.Errorf("building audit sinks: %w", err)
+	}
+	s.configAuditSinks = auditSinks
+
+	generationCtx, cancel := context.WithCancel(ctx)
+	h, err := s.buildHandler(generationCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.handler.Store(h)
+	s.cancelGeneration = cancel
+
+	if s.config.Admin != nil {
+		s.admin = s.adminHandler(*s.config.Admin)
+	}
+
+	return s, nil
+}
+
+// buildHandler builds the handler for the configuration. The authentication provider and authorizer are built from
+// the configuration if they are not set.
+func (s *Server) buildHandler(ctx context.Context) (*Handler, error) {
+	if s.authenticationProvider == nil {
+		p, err := s.config.BuildAuthenticationProviderWithReplayStores(ctx, s.replayStores)
+		if err != nil {
+			return nil, fmt.Errorf("building authentication provider: %w", err)
+		}
+		s.authenticationProvider = p
+	}
+
+	if s.authorizer == nil && s.config.Authorization.OPAServer != nil {
+		s.authorizer = s.config.Authorization.OPAServer.Build()
+	}
+	if s.authorizer == nil && len(s.config.Authorization.Rules) > 0 {
+		a, err := NewRulesAuthorizer(s.config.Authorization.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("building authorizer: %w", err)
+		}
+		s.authorizer = a
+	}
+	if s.authorizer == nil {
+		b, err := policy.LoadDefaultBundle()
+		if err != nil {
+			return nil, fmt.Errorf("loading default bundle: %w", err)
+		}
+		data, err := s.config.Authorization.LoadData()
+		if err != nil {
+			return nil, fmt.Errorf("loading authorization data: %w", err)
+		}
+		a, err := NewRegoAuthorizer(ctx, b, WithRegoData(data))
+		if err != nil {
+			return nil, fmt.Errorf("building authorizer: %w", err)
+		}
+		s.authorizer = a
+	}
+
+	h := NewHandler(s.authenticationProvider, s.authorizer, s.config)
+	h.SetLocker(s.locker)
+	h.discovered = s.discovered
+	for repoName, e := range s.exchangers {
+		h.SetCredentialExchanger(repoName, e)
+	}
+	for _, n := range s.config.BuildNotifiers() {
+		h.RegisterNotifier(n)
+	}
+	for _, n := range s.notifiers {
+		h.RegisterNotifier(n)
+	}
+	for _, a := range s.configAuditSinks {
+		h.RegisterAuditSink(a)
+	}
+	for _, a := range s.auditSinks {
+		h.RegisterAuditSink(a)
+	}
+
+	return h, nil
+}
+
+// Reload replaces the configuration, authentication provider and authorizer of the server at runtime, e.g. after
+// the configuration file changed. Only the options WithConfig, WithAuthenticationProvider and WithAuthorizer are
+// applied. If not given, the authentication provider and authorizer of NewServer or a previous Reload are kept, or
+// built from the configuration if they were not given before.
+//
+// Reloads are serialized. Requests in flight are finished with the previous configuration. Rate limits and token
+// replay stores keep their state if their configuration is unchanged. Changes of the locking, audit, TLS and admin
+// configuration are only applied by a restart.
+//
+// The context only aborts the reload. Instances built from the configuration use a context derived from the context
+// of NewServer, the context of the previously built instances is cancelled after they were replaced.
+func (s *Server) Reload(ctx context.Context, opts ...ServerOption) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	previous := s.current()
+	var o Server
+	o.config = previous.config
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.authenticationProvider == nil {
+		o.authenticationProvider = s.injectedAuthenticationProvider
+	}
+	if o.authorizer == nil {
+		o.authorizer = s.injectedAuthorizer
+	}
+
+	for _, name := range restartRequiredChanges(previous.config, o.config) {
+		log.WithField("config", name).Warn("Changed configuration is only applied by a restart")
+	}
+
+	// Build the handler on a copy, so the server is unchanged if building fails
+	r := &Server{
+		config:                 o.config,
+		authenticationProvider: o.authenticationProvider,
+		authorizer:             o.authorizer,
+		notifiers:              s.notifiers,
+		auditSinks:             s.auditSinks,
+		locker:                 s.locker,
+		exchangers:             s.exchangers,
+		configAuditSinks:       s.configAuditSinks,
+		discovered:             s.discovered,
+		replayStores:           s.replayStores,
+	}
+	generationCtx, cancel := context.WithCancel(s.ctx)
+	h, err := r.buildHandler(generationCtx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+	h.keepRateLimiters(previous)
+	s.handler.Store(h)
+	s.config = o.config
+	s.injectedAuthenticationProvider = o.authenticationProvider
+	s.injectedAuthorizer = o.authorizer
+	s.cancelGeneration()
+	s.cancelGeneration = cancel
+
+	log.
+		WithField("repositories", len(o.config.Repositories)).
+		Info("Reloaded configuration")
+
+	return nil
+}
+
+// stopGeneration cancels the context of the instances built from the configuration.
+func (s *Server) stopGeneration() {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.cancelGeneration()
+}
+
+// current returns the handler for new requests.
+func (s *Server) current() *Handler {
+	return s.handler.Load()
+}
+
+// restartRequiredChanges returns the names of changed configuration sections that are not applied by a reload.
+func restartRequiredChanges(previous, next Config) []string {
+	var names []string
+	if !reflect.DeepEqual(previous.HTTP.TLS, next.HTTP.TLS) || previous.HTTP.H2C != next.HTTP.H2C {
+		names = append(names, "http.tls")
+	}
+	if !reflect.DeepEqual(previous.Locking, next.Locking) {
+		names = append(names, "locking")
+	}
+	if !reflect.DeepEqual(previous.Audit, next.Audit) {
+		names = append(names, "audit")
+	}
+	if !reflect.DeepEqual(previous.Admin, next.Admin) {
+		names = append(names, "admin")
+	}
+	return names
+}
+
+// Handler returns the HTTP handler of the server, e.g. to mount it in an existing HTTP server.
+// It serves the admin endpoints under /admin if they are enabled without a separate address.
+func (s *Server) Handler() http.Handler {
+	// The admin configuration is only applied by a restart
+	serveAdmin := s.admin != nil && s.config.Admin.Address == ""
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serveAdmin && strings.HasPrefix(r.URL.Path, "/admin/") {
+			s.admin.ServeHTTP(w, r)
+			return
+		}
+		s.current().ServeHTTP(w, r)
+	})
+}
+
+// Start listens on the configured address and serves requests in the background.
+func (s *Server) Start() error {
+	if s.httpServer != nil {
+		return errors.New("server already started")
+	}
+
+	var tlsConfig *tls.Config
+	if s.config.HTTP.TLS != nil {
+		var err error
+		tlsConfig, err = s.config.HTTP.TLS.Build()
+		if err != nil {
+			return fmt.Errorf("building TLS config: %w", err)
+		}
+	}
+
+	var adminListener net.Listener
+	if s.admin != nil && s.config.Admin.Address != "" {
+		l, err := net.Listen("tcp", s.config.Admin.Address)
+		if err != nil {
+			return fmt.Errorf("listening on admin address %s: %w", s.config.Admin.Address, err)
+		}
+		adminListener = l
+	}
+
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		if adminListener != nil {
+			_ = adminListener.Close()
+		}
+		return fmt.Errorf("listening on %s: %w", s.address, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	handler := s.Handler()
+	if s.config.HTTP.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	s.listener = listener
+	s.httpServer = &http.Server{
+		Handler: handler,
+	}
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		err := s.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("HTTP server failed")
+		}
+	}()
+
+	log.
+		WithField("address", listener.Addr().String()).
+		WithField("tls", tlsConfig != nil).
+		WithField("h2c", s.config.HTTP.H2C).
+		Infof("Started HTTP server")
+
+	if adminListener != nil {
+		s.startAdmin(adminListener)
+	}
+
+	// Discovery is always run, so it can be enabled by a reload
+	discoveryCtx, cancel := context.WithCancel(context.Background())
+	s.discoveryCancel = cancel
+	go s.runDiscovery(discoveryCtx)
+
+	return nil
+}
+
+// startAdmin serves the admin endpoints on the separate admin listener in the background.
+func (s *Server) startAdmin(listener net.Listener) {
+	s.adminListener = listener
+	s.adminServer = &http.Server{
+		Handler: s.admin,
+	}
+	s.adminDone = make(chan struct{})
+
+	go func() {
+		defer close(s.adminDone)
+		err := s.adminServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("Admin HTTP server failed")
+		}
+	}()
+
+	log.
+		WithField("address", listener.Addr().String()).
+		Infof("Started admin HTTP server")
+}
+
+// Addr returns the address the server is listening on, or nil if it is not started.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// AdminAddr returns the address the admin endpoints are served on, or nil if there is no separate admin listener.
+func (s *Server) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
+
+// Stop gracefully shuts down the server and waits for in-flight requests until the context is done. The refreshing of
+// keys of instances built from the configuration is stopped as well.
+func (s *Server) Stop(ctx context.Context) error {
+	defer s.stopGeneration()
+
+	if s.httpServer == nil {
+		return nil
+	}
+
+	s.discoveryCancel()
+
+	if s.adminServer != nil {
+		err := s.adminServer.Shutdown(ctx)
+		if err != nil {
+			return fmt.Errorf("shutting down admin HTTP server: %w", err)
+		}
+		<-s.adminDone
+	}
+
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("shutting down HTTP server: %w", err)
+	}
+	<-s.done
+
+	log.Infof("Stopped HTTP server")
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package vignet_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestServer_StartStop(t *testing.T) {
+	ctx := context.Background()
+
+	srv, err := vignet.NewServer(
+		ctx,
+		vignet.WithAddress("127.0.0.1:0"),
+		vignet.WithAuthenticationProvider(staticAuthenticationProvider{}),
+	)
+	require.NoError(t, err)
+
+	err = srv.Start()
+	require.NoError(t, err)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/healthz", srv.Addr()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = srv.Stop(ctx)
+	require.NoError(t, err)
+
+	_, err = http.Get(fmt.Sprintf("http://%s/healthz", srv.Addr()))
+	require.Error(t, err)
+}
+
+func TestServer_TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test-ca"}, nil)
+	server := newTestCertificate(t, pkix.Name{CommonName: "vignet"}, ca)
+
+	config := vignet.DefaultConfig
+	config.HTTP.TLS = &vignet.TLSConfig{
+		CertFile:       server.writePEM(t, dir, "server.pem", nil),
+		KeyFile:        server.writePEM(t, dir, "server-key.pem", server.key),
+		ReloadInterval: time.Nanosecond,
+	}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config), vignet.WithAddress("127.0.0.1:0"), vignet.WithAuthenticationProvider(staticAuthenticationProvider{}))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer func() {
+		_ = srv.Stop(ctx)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func() *http.Response {
+		// A new transport for each request, so the certificate is checked on a new connection
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get(fmt.Sprintf("https://%s/healthz", srv.Addr()))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp
+	}
+
+	resp := get()
+	assert.Equal(t, 2, resp.ProtoMajor, "HTTP/2 is negotiated")
+	assert.Equal(t, "vignet", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// A rotated certificate is served without a restart
+	rotated := newTestCertificate(t, pkix.Name{CommonName: "vignet-rotated"}, ca)
+	rotated.writePEM(t, dir, "server.pem", nil)
+	rotated.writePEM(t, dir, "server-key.pem", rotated.key)
+
+	resp = get()
+	assert.Equal(t, "vignet-rotated", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestServer_H2C(t *testing.T) {
+	config := vignet.DefaultConfig
+	config.HTTP.H2C = true
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config), vignet.WithAddress("127.0.0.1:0"), vignet.WithAuthenticationProvider(staticAuthenticationProvider{}))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	defer func() {
+		_ = srv.Stop(ctx)
+	}()
+
+	url := fmt.Sprintf("http://%s/healthz", srv.Addr())
+
+	// HTTP/2 with prior knowledge
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	// HTTP/1.1 is still served
+	resp, err = http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, resp.ProtoMajor)
+}
+
+func TestServer_ReloadKeepsInjectedInstances(t *testing.T) {
+	_, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "foo: bar",
+	}, gitserver.Options{})
+
+	b := loadTestBundle(t, `
+package vignet.request.read
+import future.keywords
+
+violations contains "history cannot be read" if {
+	input.readRequest.resource == "history"
+}
+`)
+	ctx := context.Background()
+	authorizer, err := vignet.NewRegoAuthorizer(ctx, b)
+	require.NoError(t, err)
+
+	config := vignet.DefaultConfig
+	config.Repositories = vignet.RepositoriesConfig{
+		"infra": {URL: gitSrv.URL},
+	}
+	srv, err := vignet.NewServer(ctx,
+		vignet.WithConfig(config),
+		vignet.WithAuthenticationProvider(staticAuthenticationProvider{}),
+		vignet.WithAuthorizer(authorizer),
+	)
+	require.NoError(t, err)
+
+	// Without an authentication provider in the configuration, building one would fail
+	require.NoError(t, srv.Reload(ctx, vignet.WithConfig(config)))
+
+	req := httptest.NewRequest("GET", "/v1/repos/infra/history", nil)
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "history cannot be read")
+}
+
+func TestServer_ReloadKeepsReplayStore(t *testing.T) {
+	ks := generateJwkSet(t)
+	pubks, err := jwk.PublicSetOf(ks)
+	require.NoError(t, err)
+	jwksJSON, err := json.Marshal(pubks)
+	require.NoError(t, err)
+
+	config := vignet.DefaultConfig
+	config.AuthenticationProvider = vignet.AuthenticationProviderConfig{
+		Type: vignet.AuthenticationProviderGitLab,
+		GitLab: &vignet.GitLabAuthenticationProviderConfig{
+			JWKS:             string(jwksJSON),
+			ReplayProtection: &vignet.ReplayProtectionConfig{},
+		},
+	}
+
+	ctx := context.Background()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config))
+	require.NoError(t, err)
+
+	tok, err := jwt.NewBuilder().JwtID("job-1").Expiration(time.Now().Add(time.Hour)).Claim("project_path", "my-group/my-project").Build()
+	require.NoError(t, err)
+	serialized := signJWT(t, ks, tok)
+	listHistory := func() int {
+		req := httptest.NewRequest("GET", "/v1/repos/unknown/history", nil)
+		req.Header.Set("Authorization", "Bearer "+string(serialized))
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The token is authenticated, the repository does not exist
+	require.Equal(t, http.StatusNotFound, listHistory())
+
+	require.NoError(t, srv.Reload(ctx, vignet.WithConfig(config)))
+	require.Equal(t, http.StatusUnauthorized, listHistory())
+}
+
+func TestServer_ReloadStopsRefreshOfReplacedProviders(t *testing.T) {
+	var mx sync.Mutex
+	ks := generateJwkSet(t)
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		pubks, err := jwk.PublicSetOf(ks)
+		mx.Unlock()
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pubks)
+	}))
+	defer jwksSrv.Close()
+
+	config := vignet.DefaultConfig
+	config.AuthenticationProvider = vignet.AuthenticationProviderConfig{
+		Type:   vignet.AuthenticationProviderGitLab,
+		GitLab: &vignet.GitLabAuthenticationProviderConfig{URL: jwksSrv.URL},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv, err := vignet.NewServer(ctx, vignet.WithConfig(config))
+	require.NoError(t, err)
+
+	http.DefaultClient.CloseIdleConnections()
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 2; i++ {
+		// Like a reload by the admin endpoint, the context of the reload ends with the request
+		reloadCtx, reloadCancel := context.WithCancel(ctx)
+		require.NoError(t, srv.Reload(reloadCtx, vignet.WithConfig(config)))
+		reloadCancel()
+	}
+
+	// The refreshing of keys of the replaced providers is stopped
+	require.Eventually(t, func() bool {
+		http.DefaultClient.CloseIdleConnections()
+		return runtime.NumGoroutine() <= goroutines
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Keys of the current provider are still refreshed, e.g. after GitLab rotated its keys
+	mx.Lock()
+	ks = generateJwkSet(t)
+	mx.Unlock()
+	tok, err := jwt.NewBuilder().Expiration(time.Now().Add(time.Hour)).Claim("project_path", "my-group/my-project").Build()
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/v1/repos/unknown/history", nil)
+	req.Header.Set("Authorization", "Bearer "+string(signJWT(t, ks, tok)))
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	// The token is authenticated, the repository does not exist
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+	require.NoError(t, srv.Stop(ctx))
+}
@@ -0,0 +1,109 @@
+package vignet_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+)
+
+func TestHandler_SetFieldUnchanged(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/release.yml": "image:\n  tag: 0.1.0 # current tag\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Updated release",
+		},
+	})
+
+	type response struct {
+		Commit   string `json:"commit"`
+		Commands []struct {
+			ChangedFiles []string `json:"changedFiles"`
+		} `json:"commands"`
+	}
+
+	patch := func(tag string) response {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "image.tag", "value": "`+tag+`"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	res := patch("0.2.0")
+	require.NotEmpty(t, res.Commit)
+	require.Equal(t, []string{"my-group/my-project/release.yml"}, res.Commands[0].ChangedFiles)
+	assertGitRepoHeadCommit(t, fs, "Updated release")
+
+	// The field already has the value, so the file is not written and no commit is created
+	res = patch("0.2.0")
+	require.Empty(t, res.Commit)
+	require.Empty(t, res.Commands[0].ChangedFiles)
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/release.yml": content{"image:\n  tag: 0.2.0 # current tag\n"},
+	})
+}
+
+func TestHandler_SetFieldUnchangedTOML(t *testing.T) {
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/config.toml": "[image]\ntag = \"0.1.0\" # current tag\n",
+	}, gitserver.Options{})
+
+	handler := newTestHandler(t, vignet.Config{
+		Repositories: vignet.RepositoriesConfig{
+			"e2e-test": {URL: gitSrv.URL},
+		},
+		Commit: vignet.CommitConfig{
+			DefaultMessage: "Updated config",
+		},
+	})
+
+	type response struct {
+		Commit   string `json:"commit"`
+		Commands []struct {
+			ChangedFiles []string `json:"changedFiles"`
+		} `json:"commands"`
+	}
+
+	patch := func(tag string) response {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(`{
+			"commands": [{"path": "my-group/my-project/config.toml", "setField": {"field": "image.tag", "value": "`+tag+`"}}]
+		}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var res response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	res := patch("0.2.0")
+	require.NotEmpty(t, res.Commit)
+	require.Equal(t, []string{"my-group/my-project/config.toml"}, res.Commands[0].ChangedFiles)
+
+	// The field already has the value, so the file is not written and no commit is created
+	res = patch("0.2.0")
+	require.Empty(t, res.Commit)
+	require.Empty(t, res.Commands[0].ChangedFiles)
+	assertGitRepoContains(t, fs, map[string]fileExpectation{
+		"my-group/my-project/config.toml": content{"[image]\ntag = \"0.2.0\" # current tag\n"},
+	})
+}
@@ -0,0 +1,296 @@
+// Package sops edits values of SOPS encrypted YAML documents in place.
+//
+// Keys and ciphers of the upstream SOPS library are used, so all key types of SOPS can decrypt the data key. Values
+// that are not changed keep their ciphertext, so a patch results in a minimal diff (the changed values, the MAC and
+// the last modified time).
+package sops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	upstream "github.com/getsops/sops/v3"
+	sopsaes "github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/stores"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+const metadataKey = "sops"
+
+var encryptedValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+// ErrNotEncrypted is returned if a document has no SOPS metadata.
+var ErrNotEncrypted = errors.New("document is not SOPS encrypted")
+
+// Document is a SOPS encrypted YAML document with a decrypted data key.
+type Document struct {
+	root         *goyaml.Node
+	metadataNode *goyaml.Node
+	metadata     upstream.Metadata
+	lastModified string
+	dataKey      []byte
+	cipher       upstream.Cipher
+}
+
+// Open decrypts the data key of the SOPS encrypted document node and verifies the MAC.
+//
+// Age keys are decrypted with the given identities, without identities (and for all other key types) the keys are
+// looked up like the sops command does (e.g. SOPS_AGE_KEY_FILE).
+// Values can then be set in the node as plaintext, Seal must be called to encrypt them before encoding the node.
+func Open(node *goyaml.Node, identities ...age.Identity) (*Document, error) {
+	root := node
+	if root.Kind == goyaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	if root.Kind != goyaml.MappingNode {
+		return nil, ErrNotEncrypted
+	}
+
+	var metadataNode *goyaml.Node
+	for i := 0; i < len(root.Content)-1; i += 2 {
+		if root.Content[i].Value == metadataKey {
+			metadataNode = root.Content[i+1]
+			break
+		}
+	}
+	if metadataNode == nil {
+		return nil, ErrNotEncrypted
+	}
+
+	var storedMetadata stores.Metadata
+	err := metadataNode.Decode(&storedMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	metadata, err := storedMetadata.ToInternal()
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+
+	d := &Document{
+		root:         root,
+		metadataNode: metadataNode,
+		metadata:     metadata,
+		lastModified: storedMetadata.LastModified,
+		cipher:       sopsaes.NewCipher(),
+	}
+
+	d.dataKey, err = metadata.GetDataKeyWithKeyServices([]keyservice.KeyServiceClient{
+		keyservice.NewCustomLocalClient(identityKeyService{identities: identities}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data key: %w", err)
+	}
+
+	mac, err := d.walk(false)
+	if err != nil {
+		return nil, err
+	}
+	storedMAC, err := d.cipher.Decrypt(metadata.MessageAuthenticationCode, d.dataKey, d.lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting MAC: %w", err)
+	}
+	if storedMAC != mac {
+		return nil, errors.New("MAC mismatch, the document was modified without updating the MAC")
+	}
+
+	return d, nil
+}
+
+// identityKeyService is the local key service of SOPS that decrypts age keys with the given identities instead of
+// the identities of the environment.
+type identityKeyService struct {
+	keyservice.Server
+	identities sopsage.ParsedIdentities
+}
+
+func (ks identityKeyService) Decrypt(ctx context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	ageKey := req.Key.GetAgeKey()
+	if ageKey == nil || len(ks.identities) == 0 {
+		return ks.Server.Decrypt(ctx, req)
+	}
+
+	masterKey := &sopsage.MasterKey{
+		Recipient:    ageKey.Recipient,
+		EncryptedKey: string(req.Ciphertext),
+	}
+	ks.identities.ApplyToMasterKey(masterKey)
+	plaintext, err := masterKey.Decrypt()
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+// Seal encrypts all plaintext values that should be encrypted and updates the MAC and last modified time.
+func (d *Document) Seal(now time.Time) error {
+	mac, err := d.walk(true)
+	if err != nil {
+		return err
+	}
+
+	lastModified := now.UTC().Format(time.RFC3339)
+	encryptedMAC, err := d.cipher.Encrypt(mac, d.dataKey, lastModified)
+	if err != nil {
+		return fmt.Errorf("encrypting MAC: %w", err)
+	}
+	d.lastModified = lastModified
+	d.metadata.MessageAuthenticationCode = encryptedMAC
+
+	setMappingValue(d.metadataNode, "lastmodified", lastModified)
+	setMappingValue(d.metadataNode, "mac", encryptedMAC)
+
+	return nil
+}
+
+// walk computes the MAC over all values in the order of the document.
+// If seal is true, plaintext values that should be encrypted are encrypted in place.
+func (d *Document) walk(seal bool) (string, error) {
+	hash := sha512.New()
+
+	var walkNode func(node *goyaml.Node, path []string) error
+	walkNode = func(node *goyaml.Node, path []string) error {
+		switch node.Kind {
+		case goyaml.AliasNode:
+			return walkNode(node.Alias, path)
+		case goyaml.MappingNode:
+			for i := 0; i < len(node.Content)-1; i += 2 {
+				key := node.Content[i].Value
+				if node == d.root && key == metadataKey {
+					continue
+				}
+				if err := walkNode(node.Content[i+1], append(path, key)); err != nil {
+					return err
+				}
+			}
+		case goyaml.SequenceNode:
+			// Items of sequences do not extend the path
+			for _, item := range node.Content {
+				if err := walkNode(item, path); err != nil {
+					return err
+				}
+			}
+		case goyaml.ScalarNode:
+			return d.walkScalar(node, path, hash, seal)
+		}
+		return nil
+	}
+
+	err := walkNode(d.root, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil)), nil
+}
+
+func (d *Document) walkScalar(node *goyaml.Node, path []string, hash io.Writer, seal bool) error {
+	additionalData := strings.Join(path, ":") + ":"
+	shouldEncrypt := d.shouldEncrypt(path)
+
+	var value any
+	if shouldEncrypt && encryptedValuePattern.MatchString(node.Value) {
+		var err error
+		value, err = d.cipher.Decrypt(node.Value, d.dataKey, additionalData)
+		if err != nil {
+			return fmt.Errorf("decrypting value at %s: %w", strings.Join(path, "."), err)
+		}
+	} else {
+		if err := node.Decode(&value); err != nil {
+			return fmt.Errorf("decoding value at %s: %w", strings.Join(path, "."), err)
+		}
+		// Null values are neither encrypted nor part of the MAC
+		if value == nil {
+			return nil
+		}
+
+		if seal && shouldEncrypt {
+			encrypted, err := d.cipher.Encrypt(value, d.dataKey, additionalData)
+			if err != nil {
+				return fmt.Errorf("encrypting value at %s: %w", strings.Join(path, "."), err)
+			}
+			// Empty values are not encrypted
+			if encrypted != "" {
+				node.Value = encrypted
+				node.Tag = "!!str"
+				node.Style = 0
+			}
+		}
+	}
+
+	b, err := upstream.ToBytes(value)
+	if err != nil {
+		return fmt.Errorf("value at %s: %w", strings.Join(path, "."), err)
+	}
+	hash.Write(b)
+	return nil
+}
+
+// shouldEncrypt applies the rules of the metadata to decide if the value at path is encrypted.
+func (d *Document) shouldEncrypt(path []string) bool {
+	m := d.metadata
+	encrypted := true
+	if m.UnencryptedSuffix != "" {
+		for _, p := range path {
+			if strings.HasSuffix(p, m.UnencryptedSuffix) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if m.EncryptedSuffix != "" {
+		encrypted = false
+		for _, p := range path {
+			if strings.HasSuffix(p, m.EncryptedSuffix) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	if m.UnencryptedRegex != "" {
+		for _, p := range path {
+			if matched, _ := regexp.MatchString(m.UnencryptedRegex, p); matched {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if m.EncryptedRegex != "" {
+		encrypted = false
+		for _, p := range path {
+			if matched, _ := regexp.MatchString(m.EncryptedRegex, p); matched {
+				encrypted = true
+				break
+			}
+		}
+	}
+	return encrypted
+}
+
+func setMappingValue(node *goyaml.Node, key, value string) {
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1].Value = value
+			node.Content[i+1].Tag = "!!str"
+			return
+		}
+	}
+	node.Content = append(node.Content,
+		&goyaml.Node{Kind: goyaml.ScalarNode, Tag: "!!str", Value: key},
+		&goyaml.Node{Kind: goyaml.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
+
+// ParseIdentities parses age identities (e.g. the content of an age key file).
+func ParseIdentities(keys string) ([]age.Identity, error) {
+	return age.ParseIdentities(bytes.NewReader([]byte(keys)))
+}
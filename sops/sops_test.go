@@ -0,0 +1,105 @@
+package sops_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/getsops/sops/v3/decrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	goyaml "gopkg.in/yaml.v3"
+
+	"github.com/networkteam/vignet/sops"
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestSetFieldAndSeal(t *testing.T) {
+	// The fixture was encrypted with sops 3.8.1 and the age key in testdata
+	identities := loadIdentities(t)
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	require.NoError(t, err)
+
+	patcher, err := yaml.NewPatcher(bytes.NewReader(encrypted))
+	require.NoError(t, err)
+
+	doc, err := sops.Open(patcher.Node(), identities...)
+	require.NoError(t, err)
+
+	err = patcher.SetField("database.password", "n3w-s3cr3t", false)
+	require.NoError(t, err)
+	err = patcher.SetField("database.host_unencrypted", "db2.example.com", false)
+	require.NoError(t, err)
+
+	err = doc.Seal(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = patcher.Encode(&buf)
+	require.NoError(t, err)
+	result := buf.String()
+
+	assert.NotContains(t, result, "n3w-s3cr3t")
+	assert.Contains(t, result, "host_unencrypted: db2.example.com")
+	assert.Contains(t, result, `lastmodified: "2024-01-02T15:04:05Z"`)
+	// Unchanged values keep their ciphertext
+	assert.Contains(t, result, "user: ENC[AES256_GCM,data:WMin,")
+
+	// The sealed document can be opened again (verifies the MAC) and contains the new value
+	patcher, err = yaml.NewPatcher(strings.NewReader(result))
+	require.NoError(t, err)
+	_, err = sops.Open(patcher.Node(), identities...)
+	require.NoError(t, err)
+
+	// The sealed document can be decrypted by SOPS
+	t.Setenv("SOPS_AGE_KEY_FILE", "testdata/age-key.txt")
+	decrypted, err := decrypt.Data([]byte(result), "yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(decrypted), "password: n3w-s3cr3t")
+}
+
+func TestOpen_MACMismatch(t *testing.T) {
+	identities := loadIdentities(t)
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	require.NoError(t, err)
+
+	// Modify an unencrypted value without updating the MAC
+	modified := strings.Replace(string(encrypted), "db.example.com", "evil.example.com", 1)
+
+	var node goyaml.Node
+	require.NoError(t, goyaml.Unmarshal([]byte(modified), &node))
+	_, err = sops.Open(&node, identities...)
+	require.ErrorContains(t, err, "MAC mismatch")
+}
+
+func TestOpen_NotEncrypted(t *testing.T) {
+	var node goyaml.Node
+	require.NoError(t, goyaml.Unmarshal([]byte("foo: bar\n"), &node))
+	_, err := sops.Open(&node)
+	require.ErrorIs(t, err, sops.ErrNotEncrypted)
+}
+
+func TestOpen_WrongIdentity(t *testing.T) {
+	identities, err := sops.ParseIdentities("AGE-SECRET-KEY-128RYHXRF8FRUPCUXU6J5ZXUDW2LRQ0GR2ZTV0KQ5TAUXH4F9D0SS52Y5JZ")
+	require.NoError(t, err)
+	encrypted, err := os.ReadFile("testdata/secret.enc.yaml")
+	require.NoError(t, err)
+
+	var node goyaml.Node
+	require.NoError(t, goyaml.Unmarshal(encrypted, &node))
+	_, err = sops.Open(&node, identities...)
+	require.ErrorContains(t, err, "decrypting data key")
+}
+
+func loadIdentities(t *testing.T) []age.Identity {
+	t.Helper()
+
+	key, err := os.ReadFile("testdata/age-key.txt")
+	require.NoError(t, err)
+	identities, err := sops.ParseIdentities(string(key))
+	require.NoError(t, err)
+	return identities
+}
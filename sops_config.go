@@ -0,0 +1,66 @@
+package vignet
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"filippo.io/age"
+
+	"github.com/networkteam/vignet/sops"
+	"github.com/networkteam/vignet/yaml"
+)
+
+// SOPSConfig configures the keys to edit SOPS encrypted files.
+type SOPSConfig struct {
+	// AgeKeyFile is the path to an age key file with one or more identities.
+	AgeKeyFile string `yaml:"ageKeyFile"`
+	// AgeKey are inline age identities (e.g. "AGE-SECRET-KEY-1...").
+	AgeKey string `yaml:"ageKey"`
+}
+
+func (c SOPSConfig) Valid() error {
+	if c.AgeKeyFile == "" && c.AgeKey == "" {
+		return fmt.Errorf("ageKeyFile or ageKey required")
+	}
+	if c.AgeKeyFile != "" && c.AgeKey != "" {
+		return fmt.Errorf("only one of ageKeyFile or ageKey can be set")
+	}
+	if _, err := c.ageIdentities(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c SOPSConfig) ageIdentities() ([]age.Identity, error) {
+	keys := c.AgeKey
+	if c.AgeKeyFile != "" {
+		b, err := os.ReadFile(c.AgeKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading age key file: %w", err)
+		}
+		keys = string(b)
+	}
+	identities, err := sops.ParseIdentities(keys)
+	if err != nil {
+		return nil, fmt.Errorf("parsing age identities: %w", err)
+	}
+	return identities, nil
+}
+
+// openSOPSDocument decrypts the data key of the SOPS encrypted document of the patcher with the keys of the repository.
+func openSOPSDocument(repoConfig RepositoryConfig, patcher *yaml.Patcher) (*sops.Document, error) {
+	if repoConfig.SOPS == nil {
+		return nil, clientError{errors.New("SOPS is not configured for repository"), http.StatusUnprocessableEntity}
+	}
+	identities, err := repoConfig.SOPS.ageIdentities()
+	if err != nil {
+		return nil, fmt.Errorf("loading SOPS keys: %w", err)
+	}
+	doc, err := sops.Open(patcher.Node(), identities...)
+	if err != nil {
+		return nil, clientError{fmt.Errorf("opening SOPS encrypted file: %w", err), http.StatusUnprocessableEntity}
+	}
+	return doc, nil
+}
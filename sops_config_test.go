@@ -0,0 +1,83 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	goyaml "gopkg.in/yaml.v3"
+
+	"github.com/networkteam/vignet"
+	"github.com/networkteam/vignet/internal/gitserver"
+	"github.com/networkteam/vignet/sops"
+)
+
+func TestHandler_SetFieldSOPS(t *testing.T) {
+	encrypted, err := os.ReadFile("sops/testdata/secret.enc.yaml")
+	require.NoError(t, err)
+	ageKey, err := os.ReadFile("sops/testdata/age-key.txt")
+	require.NoError(t, err)
+
+	fs, gitSrv := startMockHttpGitServer(t, map[string]string{
+		"my-group/my-project/secret.enc.yaml": string(encrypted),
+	}, gitserver.Options{})
+
+	newHandler := func(sopsConfig *vignet.SOPSConfig) *vignet.Handler {
+		return newTestHandler(t, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"e2e-test": {URL: gitSrv.URL, SOPS: sopsConfig},
+			},
+			Commit: vignet.CommitConfig{
+				DefaultMessage: "Rotated secret",
+			},
+		})
+	}
+	patchPayload := `{
+		"commands": [{"path": "my-group/my-project/secret.enc.yaml", "setField": {"field": "database.password", "value": "n3w-s3cr3t", "sops": true}}]
+	}`
+
+	t.Run("without SOPS config", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(patchPayload))
+		rec := httptest.NewRecorder()
+		newHandler(nil).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "SOPS is not configured")
+	})
+
+	t.Run("with expected value", func(t *testing.T) {
+		payload := `{
+			"commands": [{"path": "my-group/my-project/secret.enc.yaml", "setField": {"field": "database.password", "value": "n3w-s3cr3t", "expectedValue": "s3cr3t", "sops": true}}]
+		}`
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(payload))
+		rec := httptest.NewRecorder()
+		newHandler(&vignet.SOPSConfig{AgeKey: string(ageKey)}).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), "'expectedValue' cannot be combined with 'sops'")
+	})
+
+	t.Run("with SOPS config", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/patch/e2e-test", strings.NewReader(patchPayload))
+		rec := httptest.NewRecorder()
+		newHandler(&vignet.SOPSConfig{AgeKey: string(ageKey)}).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		assertGitRepoHeadCommit(t, fs, "Rotated secret")
+		f, err := gitRepoHeadCommit(t, fs).File("my-group/my-project/secret.enc.yaml")
+		require.NoError(t, err)
+		content, err := f.Contents()
+		require.NoError(t, err)
+		require.NotContains(t, content, "n3w-s3cr3t")
+		require.NotContains(t, content, "password: ENC[AES256_GCM,data:c8kDUvKi,")
+
+		// The MAC of the patched file is valid
+		identities, err := sops.ParseIdentities(string(ageKey))
+		require.NoError(t, err)
+		var node goyaml.Node
+		require.NoError(t, goyaml.Unmarshal([]byte(content), &node))
+		_, err = sops.Open(&node, identities...)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,96 @@
+package vignet
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigner signs Git objects with an SSH key in the SSHSIG format (like `git config gpg.format ssh`).
+//
+// See https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig for the format.
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+const (
+	sshSigMagic         = "SSHSIG"
+	sshSigVersion       = 1
+	sshSigNamespace     = "git"
+	sshSigHashAlgorithm = "sha512"
+	sshSigPEMType       = "SSH SIGNATURE"
+)
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, fmt.Errorf("hashing message: %w", err)
+	}
+
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlgorithm,
+		Hash:          string(h.Sum(nil)),
+	})...)
+
+	var (
+		sig *ssh.Signature
+		err error
+	)
+	// RSA keys must not use SHA-1 signatures
+	if algSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(nil, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(nil, signedData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing: %w", err)
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSigBlob{
+		Version:       sshSigVersion,
+		PublicKey:     string(s.signer.PublicKey().Marshal()),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlgorithm,
+		Signature:     string(ssh.Marshal(sig)),
+	})...)
+
+	return armorSSHSignature(blob), nil
+}
+
+// armorSSHSignature encodes the blob like ssh-keygen does (base64 wrapped at 70 characters).
+func armorSSHSignature(blob []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN " + sshSigPEMType + "-----\n")
+	for len(encoded) > 70 {
+		buf.WriteString(encoded[:70])
+		buf.WriteByte('\n')
+		encoded = encoded[70:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\n-----END " + sshSigPEMType + "-----\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,82 @@
+package vignet_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateSSHKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+
+	publicKey, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return string(pem.EncodeToMemory(block)), publicKey
+}
+
+// assertSSHSignedCommit verifies the SSHSIG signature of a commit like `git verify-commit` would do.
+func assertSSHSignedCommit(t *testing.T, commit *object.Commit, publicKey ssh.PublicKey) {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(commit.PGPSignature))
+	require.NotNil(t, block, "commit must have an armored signature")
+	require.Equal(t, "SSH SIGNATURE", block.Type)
+	require.True(t, bytes.HasPrefix(block.Bytes, []byte("SSHSIG")))
+
+	var blob struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}
+	err := ssh.Unmarshal(block.Bytes[6:], &blob)
+	require.NoError(t, err)
+	require.Equal(t, "git", blob.Namespace)
+	require.Equal(t, publicKey.Marshal(), []byte(blob.PublicKey))
+
+	var sig ssh.Signature
+	err = ssh.Unmarshal([]byte(blob.Signature), &sig)
+	require.NoError(t, err)
+
+	// Encode the commit without signature to get the signed message
+	encoded := &plumbing.MemoryObject{}
+	err = commit.EncodeWithoutSignature(encoded)
+	require.NoError(t, err)
+	r, err := encoded.Reader()
+	require.NoError(t, err)
+	var message bytes.Buffer
+	_, err = message.ReadFrom(r)
+	require.NoError(t, err)
+
+	h := sha512.Sum512(message.Bytes())
+	signedData := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{
+		Namespace:     blob.Namespace,
+		HashAlgorithm: blob.HashAlgorithm,
+		Hash:          string(h[:]),
+	})...)
+
+	err = publicKey.Verify(signedData, &sig)
+	require.NoError(t, err)
+}
@@ -0,0 +1,104 @@
+package vignet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TimeoutsConfig limits the duration of requests and Git operations, so a hanging Git remote does not block requests
+// forever. Requests exceeding a timeout fail with status 504.
+type TimeoutsConfig struct {
+	// Request is the maximum duration of handling a request, defaults to 10m.
+	Request time.Duration `yaml:"request"`
+	// Clone is the maximum duration of cloning a repository, defaults to 5m.
+	Clone time.Duration `yaml:"clone"`
+	// Push is the maximum duration of pushing to a repository, defaults to 5m.
+	Push time.Duration `yaml:"push"`
+	// API is the maximum duration of a request to the API of a Git provider (e.g. to create a pull request or to
+	// comment on a merge request), defaults to 30s.
+	API time.Duration `yaml:"api"`
+}
+
+const (
+	defaultRequestTimeout = 10 * time.Minute
+	defaultCloneTimeout   = 5 * time.Minute
+	defaultPushTimeout    = 5 * time.Minute
+	defaultAPITimeout     = 30 * time.Second
+)
+
+func (c TimeoutsConfig) Valid() error {
+	if c.Request < 0 {
+		return fmt.Errorf("request must not be negative")
+	}
+	if c.Clone < 0 {
+		return fmt.Errorf("clone must not be negative")
+	}
+	if c.Push < 0 {
+		return fmt.Errorf("push must not be negative")
+	}
+	if c.API < 0 {
+		return fmt.Errorf("api must not be negative")
+	}
+	return nil
+}
+
+func (c TimeoutsConfig) request() time.Duration {
+	if c.Request == 0 {
+		return defaultRequestTimeout
+	}
+	return c.Request
+}
+
+func (c TimeoutsConfig) clone() time.Duration {
+	if c.Clone == 0 {
+		return defaultCloneTimeout
+	}
+	return c.Clone
+}
+
+func (c TimeoutsConfig) push() time.Duration {
+	if c.Push == 0 {
+		return defaultPushTimeout
+	}
+	return c.Push
+}
+
+func (c TimeoutsConfig) api() time.Duration {
+	if c.API == 0 {
+		return defaultAPITimeout
+	}
+	return c.API
+}
+
+// apiClient returns a client for requests to the API of a Git provider.
+func (c TimeoutsConfig) apiClient() *http.Client {
+	return &http.Client{Timeout: c.api()}
+}
+
+// requestTimeout is a middleware to cancel the context of a request after the request timeout.
+func (h *Handler) requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeouts.request())
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withTimeout runs a Git operation (e.g. "clone") with a context that is cancelled after the timeout.
+// If the operation failed because the timeout or the request timeout was exceeded, the error has status 504.
+func withTimeout(ctx context.Context, operation string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := fn(opCtx)
+	if err == nil || !errors.Is(opCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return clientError{fmt.Errorf("%s of repository was cancelled, the request timed out: %w", operation, err), http.StatusGatewayTimeout}
+	}
+	return clientError{fmt.Errorf("%s of repository timed out after %s: %w", operation, timeout, err), http.StatusGatewayTimeout}
+}
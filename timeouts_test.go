@@ -0,0 +1,84 @@
+package vignet_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestHandler_Timeouts(t *testing.T) {
+	// A Git remote that never responds
+	hangingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hangingSrv.Close)
+
+	newHandler := func(timeouts vignet.TimeoutsConfig) *vignet.Handler {
+		return newTestHandler(t, vignet.Config{
+			Repositories: vignet.RepositoriesConfig{
+				"hanging": {URL: hangingSrv.URL},
+			},
+			Timeouts: timeouts,
+		})
+	}
+
+	tests := []struct {
+		name          string
+		timeouts      vignet.TimeoutsConfig
+		method        string
+		path          string
+		body          string
+		expectedError string
+	}{
+		{
+			name:          "clone timeout of patch",
+			timeouts:      vignet.TimeoutsConfig{Clone: 100 * time.Millisecond},
+			method:        "POST",
+			path:          "/v1/patch/hanging",
+			body:          `{"commands": [{"path": "my-group/my-project/release.yml", "setField": {"field": "foo", "value": "baz"}}]}`,
+			expectedError: "clone of repository timed out after 100ms",
+		},
+		{
+			name:          "clone timeout of read",
+			timeouts:      vignet.TimeoutsConfig{Clone: 100 * time.Millisecond},
+			method:        "GET",
+			path:          "/v1/repos/hanging/file?path=my-group/my-project/release.yml",
+			expectedError: "clone of repository timed out after 100ms",
+		},
+		{
+			name:          "request timeout",
+			timeouts:      vignet.TimeoutsConfig{Request: 100 * time.Millisecond},
+			method:        "GET",
+			path:          "/v1/repos/hanging/history",
+			expectedError: "clone of repository was cancelled, the request timed out",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newHandler(tc.timeouts)
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusGatewayTimeout, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), tc.expectedError)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
+
+func TestTimeoutsConfig_Valid(t *testing.T) {
+	assert.NoError(t, vignet.TimeoutsConfig{}.Valid())
+	assert.NoError(t, vignet.TimeoutsConfig{Request: time.Minute, Clone: time.Second, Push: time.Second}.Valid())
+	assert.EqualError(t, vignet.TimeoutsConfig{Clone: -time.Second}.Valid(), "clone must not be negative")
+	assert.EqualError(t, vignet.TimeoutsConfig{API: -time.Second}.Valid(), "api must not be negative")
+}
@@ -0,0 +1,91 @@
+package vignet
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// certificateReloader serves the certificate of the server and reloads it if the files changed, so a rotated
+// certificate (e.g. renewed by cert-manager) is used without a restart.
+//
+// The files are checked for changes of sizes and modification times on a handshake, at most once per interval.
+// If loading fails (e.g. only one of the files was written yet), the current certificate is kept and loading is
+// retried on the next check.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	fingerprint string
+	lastCheck   time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string, interval time.Duration) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+	if err := r.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastCheck) >= r.interval {
+		if err := r.reload(now); err != nil {
+			log.WithError(err).Warn("Failed to reload TLS certificate, keeping current certificate")
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files changed since the last reload, the lock must be held.
+func (r *certificateReloader) reload(now time.Time) error {
+	r.lastCheck = now
+
+	fingerprint, err := certificateFingerprint(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if fingerprint == r.fingerprint {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	if r.cert != nil {
+		log.WithField("certFile", r.certFile).Info("Reloaded TLS certificate")
+	}
+	r.cert = &cert
+	r.fingerprint = fingerprint
+
+	return nil
+}
+
+// certificateFingerprint identifies the state of the files by their sizes and modification times.
+func certificateFingerprint(files ...string) (string, error) {
+	var fingerprint string
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", fmt.Errorf("reading certificate file: %w", err)
+		}
+		fingerprint += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return fingerprint, nil
+}
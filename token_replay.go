@@ -0,0 +1,124 @@
+package vignet
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenReplayStore remembers the IDs of used tokens (`jti` claim) until they expire to detect replayed tokens.
+type TokenReplayStore interface {
+	// Remember stores the ID of a token until it expires. It returns false if the ID was already stored.
+	Remember(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryTokenReplayStore is an in-process TokenReplayStore. It only detects replays within a single vignet instance.
+type MemoryTokenReplayStore struct {
+	mx   sync.Mutex
+	seen map[string]time.Time
+	// lastSweep is the time expired IDs were last removed
+	lastSweep time.Time
+}
+
+// memoryTokenReplaySweepInterval is the minimum interval between removing expired IDs, so remembering a token does
+// not scan all IDs on every request.
+const memoryTokenReplaySweepInterval = time.Minute
+
+var _ TokenReplayStore = &MemoryTokenReplayStore{}
+
+func NewMemoryTokenReplayStore() *MemoryTokenReplayStore {
+	return &MemoryTokenReplayStore{
+		seen: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryTokenReplayStore) Remember(_ context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	// Expired tokens are rejected anyway, so their IDs can be forgotten
+	if now.Sub(s.lastSweep) >= memoryTokenReplaySweepInterval {
+		for id, exp := range s.seen {
+			if !exp.After(now) {
+				delete(s.seen, id)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	// IDs that expired since the last sweep are not considered
+	if exp, exists := s.seen[tokenID]; exists && exp.After(now) {
+		return false, nil
+	}
+	s.seen[tokenID] = expiresAt
+	return true, nil
+}
+
+// RedisTokenReplayStore is a TokenReplayStore backed by Redis that detects replays across multiple vignet instances.
+type RedisTokenReplayStore struct {
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+var _ TokenReplayStore = &RedisTokenReplayStore{}
+
+// NewRedisTokenReplayStore creates a new RedisTokenReplayStore using the given client.
+func NewRedisTokenReplayStore(client redis.UniversalClient, keyPrefix string) *RedisTokenReplayStore {
+	return &RedisTokenReplayStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (s *RedisTokenReplayStore) Remember(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	stored, err := s.client.SetNX(ctx, s.keyPrefix+tokenID, "1", ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("storing token ID: %w", err)
+	}
+	return stored, nil
+}
+
+// TokenReplayStores keeps the replay stores built from the configuration by name of the authentication provider, so
+// tokens used before a reload are still rejected after it. A store is built again if its configuration changed.
+type TokenReplayStores struct {
+	mx     sync.Mutex
+	stores map[string]keptTokenReplayStore
+}
+
+type keptTokenReplayStore struct {
+	config ReplayProtectionConfig
+	store  TokenReplayStore
+}
+
+func NewTokenReplayStores() *TokenReplayStores {
+	return &TokenReplayStores{
+		stores: make(map[string]keptTokenReplayStore),
+	}
+}
+
+// store returns the kept store of the provider if its configuration is unchanged, otherwise a new store is built.
+// Without stores (nil) a new store is built for each call.
+func (s *TokenReplayStores) store(providerName string, config ReplayProtectionConfig) TokenReplayStore {
+	if s == nil {
+		return config.build()
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if kept, exists := s.stores[providerName]; exists && reflect.DeepEqual(kept.config, config) {
+		return kept.store
+	}
+	store := config.build()
+	s.stores[providerName] = keptTokenReplayStore{config: config, store: store}
+	return store
+}
@@ -0,0 +1,77 @@
+package vignet_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet"
+)
+
+func TestTokenReplayStores(t *testing.T) {
+	tt := []struct {
+		name     string
+		newStore func(t *testing.T) vignet.TokenReplayStore
+	}{
+		{
+			name: "memory",
+			newStore: func(t *testing.T) vignet.TokenReplayStore {
+				return vignet.NewMemoryTokenReplayStore()
+			},
+		},
+		{
+			name: "redis",
+			newStore: func(t *testing.T) vignet.TokenReplayStore {
+				mr := miniredis.RunT(t)
+				client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+				t.Cleanup(func() { _ = client.Close() })
+				return vignet.NewRedisTokenReplayStore(client, "vignet:jti:")
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.newStore(t)
+			ctx := context.Background()
+			expiresAt := time.Now().Add(time.Hour)
+
+			fresh, err := store.Remember(ctx, "token-a", expiresAt)
+			require.NoError(t, err)
+			require.True(t, fresh)
+
+			// Another token is remembered independently
+			fresh, err = store.Remember(ctx, "token-b", expiresAt)
+			require.NoError(t, err)
+			require.True(t, fresh)
+
+			// The same token is detected as replayed
+			fresh, err = store.Remember(ctx, "token-a", expiresAt)
+			require.NoError(t, err)
+			require.False(t, fresh)
+		})
+	}
+}
+
+func TestMemoryTokenReplayStore_Expired(t *testing.T) {
+	store := vignet.NewMemoryTokenReplayStore()
+	ctx := context.Background()
+
+	fresh, err := store.Remember(ctx, "token-a", time.Now().Add(50*time.Millisecond))
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	// The ID of an expired token is forgotten, even before expired IDs are removed
+	time.Sleep(100 * time.Millisecond)
+	fresh, err = store.Remember(ctx, "token-a", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, fresh)
+
+	fresh, err = store.Remember(ctx, "token-a", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.False(t, fresh)
+}
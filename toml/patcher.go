@@ -0,0 +1,246 @@
+// Package toml patches TOML documents by editing the text of values in place, so comments and the layout of tables are kept.
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+)
+
+type Patcher struct {
+	data []byte
+}
+
+func NewPatcher(r io.Reader) (*Patcher, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+
+	return &Patcher{
+		data: data,
+	}, nil
+}
+
+// SetField sets the scalar value at the path (dot path syntax, e.g. "package.version" or "bin[0].name").
+// If createKeys is true, a missing key is created in the table of the path, the table is appended if it doesn't exist.
+func (p *Patcher) SetField(path string, value any, createKeys bool) error {
+	key, err := parsePath(path)
+	if err != nil {
+		return fmt.Errorf("parsing path: %w", err)
+	}
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("encoding value: %w", err)
+	}
+
+	doc, err := scan(p.data)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if e := doc.entry(key); e != nil {
+		if !e.scalar {
+			return fmt.Errorf("expected scalar value, got %s (at line %d)", e.kind(p.data), lineOf(p.data, e.start))
+		}
+		data = splice(p.data, e.start, e.end, encoded)
+	} else if doc.isTable(key) {
+		return errors.New("expected scalar value, got table")
+	} else if !createKeys {
+		return errors.New("no value matched path")
+	} else {
+		data, err = doc.insert(p.data, key, encoded)
+		if err != nil {
+			return fmt.Errorf("creating path: %w", err)
+		}
+	}
+
+	// Check the result, so an edit that is not supported by the scanner never produces an invalid document
+	var v map[string]any
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return fmt.Errorf("patched document is invalid: %w", err)
+	}
+	p.data = data
+
+	return nil
+}
+
+// Field returns the decoded value at the path, or nil if no value matched.
+func (p *Patcher) Field(path string) (any, error) {
+	key, err := parsePath(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing path: %w", err)
+	}
+	doc, err := scan(p.data)
+	if err != nil {
+		return nil, err
+	}
+	e := doc.entry(key)
+	if e == nil {
+		return nil, nil
+	}
+
+	var v map[string]any
+	if _, err := toml.Decode("v = "+string(p.data[e.start:e.end]), &v); err != nil {
+		return nil, fmt.Errorf("decoding value: %w", err)
+	}
+	return v["v"], nil
+}
+
+// Encode writes the document.
+func (p *Patcher) Encode(w io.Writer) error {
+	_, err := w.Write(p.data)
+	return err
+}
+
+func splice(data []byte, start, end int, replacement string) []byte {
+	result := make([]byte, 0, len(data)-(end-start)+len(replacement))
+	result = append(result, data[:start]...)
+	result = append(result, replacement...)
+	return append(result, data[end:]...)
+}
+
+func lineOf(data []byte, offset int) int {
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// parsePath parses a dot path into keys, array indexes (e.g. "[0]") are separate keys.
+// Keys can be quoted with double quotes to contain dots (e.g. `tool."my.tool".version`).
+func parsePath(path string) ([]string, error) {
+	var key []string
+	s := path
+	for {
+		if s == "" {
+			return nil, fmt.Errorf("unexpected end of path %q", path)
+		}
+		if s[0] == '"' {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted key in %q", path)
+			}
+			key = append(key, s[1:end+1])
+			s = s[end+2:]
+		} else {
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if !isBareKey(s[:end]) {
+				return nil, fmt.Errorf("invalid key %q in %q", s[:end], path)
+			}
+			key = append(key, s[:end])
+			s = s[end:]
+		}
+		for strings.HasPrefix(s, "[") {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in %q", path)
+			}
+			index, err := strconv.Atoi(s[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q in %q", s[1:end], path)
+			}
+			key = append(key, indexKey(index))
+			s = s[end+1:]
+		}
+		if s == "" {
+			return key, nil
+		}
+		if s[0] != '.' {
+			return nil, fmt.Errorf("expected '.' in %q", path)
+		}
+		s = s[1:]
+	}
+}
+
+// indexKey is the key of an element of an array of tables, it cannot be confused with a bare key.
+func indexKey(index int) string {
+	return "[" + strconv.Itoa(index) + "]"
+}
+
+func isIndexKey(k string) bool {
+	return strings.HasPrefix(k, "[")
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeValue encodes a scalar value as TOML.
+func encodeValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return quoteString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("unsupported number %v", v)
+		}
+		// Numbers decoded from JSON are floats, integral numbers are written as TOML integers
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case nil:
+		return "", errors.New("null is not supported by TOML")
+	default:
+		return "", fmt.Errorf("expected scalar value, got %T", value)
+	}
+}
+
+// quoteString quotes s as a basic string.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,220 @@
+package toml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/toml"
+)
+
+const cargoTOML = `# Package metadata
+[package]
+name = "my-crate"
+version = "0.1.0" # bumped by CI
+edition = "2021"
+authors = [
+  "Jane Doe <jane@example.com>", # maintainer
+]
+
+[dependencies]
+serde = { version = "1.0", features = ["derive"] }
+tokio.version = "1.28"
+regex = '1.8'
+
+[[bin]]
+name = "server"
+path = "src/server.rs"
+
+[[bin]]
+name = "cli"
+path = "src/cli.rs"
+
+[profile.release]
+lto = true
+published = 1979-05-27 07:32:00Z
+`
+
+func TestPatcher_SetField(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		fieldPath    string
+		value        any
+		createKeys   bool
+		expectedDiff [2]string
+		expectedErr  string
+	}{
+		{
+			name:         "string in table with comment",
+			fieldPath:    "package.version",
+			value:        "0.2.0",
+			expectedDiff: [2]string{`version = "0.1.0" # bumped by CI`, `version = "0.2.0" # bumped by CI`},
+		},
+		{
+			name:         "key in inline table",
+			fieldPath:    "dependencies.serde.version",
+			value:        "1.1",
+			expectedDiff: [2]string{`serde = { version = "1.0", features`, `serde = { version = "1.1", features`},
+		},
+		{
+			name:         "dotted key",
+			fieldPath:    "dependencies.tokio.version",
+			value:        "1.29",
+			expectedDiff: [2]string{`tokio.version = "1.28"`, `tokio.version = "1.29"`},
+		},
+		{
+			name:         "literal string",
+			fieldPath:    "dependencies.regex",
+			value:        "1.9",
+			expectedDiff: [2]string{`regex = '1.8'`, `regex = "1.9"`},
+		},
+		{
+			name:         "array of tables",
+			fieldPath:    "bin[1].path",
+			value:        "src/bin/cli.rs",
+			expectedDiff: [2]string{`path = "src/cli.rs"`, `path = "src/bin/cli.rs"`},
+		},
+		{
+			name:         "boolean",
+			fieldPath:    "profile.release.lto",
+			value:        false,
+			expectedDiff: [2]string{`lto = true`, `lto = false`},
+		},
+		{
+			name:         "date time with space",
+			fieldPath:    "profile.release.published",
+			value:        "today",
+			expectedDiff: [2]string{`published = 1979-05-27 07:32:00Z`, `published = "today"`},
+		},
+		{
+			name:         "create key in existing table",
+			fieldPath:    "profile.release.opt-level",
+			value:        float64(3),
+			createKeys:   true,
+			expectedDiff: [2]string{"published = 1979-05-27 07:32:00Z\n", "published = 1979-05-27 07:32:00Z\nopt-level = 3\n"},
+		},
+		{
+			name:         "create key in table defined by dotted keys",
+			fieldPath:    "dependencies.tokio.features",
+			value:        "full",
+			createKeys:   true,
+			expectedDiff: [2]string{"regex = '1.8'\n", "regex = '1.8'\ntokio.features = \"full\"\n"},
+		},
+		{
+			name:         "create table",
+			fieldPath:    "workspace.metadata.\"my.tool\"",
+			value:        "enabled",
+			createKeys:   true,
+			expectedDiff: [2]string{"07:32:00Z\n", "07:32:00Z\n\n[workspace.metadata]\n\"my.tool\" = \"enabled\"\n"},
+		},
+		{
+			name:        "missing key",
+			fieldPath:   "package.description",
+			value:       "A crate",
+			expectedErr: "no value matched path",
+		},
+		{
+			name:        "array value",
+			fieldPath:   "package.authors",
+			value:       "Jane Doe",
+			expectedErr: "expected scalar value, got array (at line 6)",
+		},
+		{
+			name:        "table",
+			fieldPath:   "dependencies.serde",
+			value:       "1.0",
+			expectedErr: "expected scalar value, got inline table",
+		},
+		{
+			name:        "header table",
+			fieldPath:   "profile",
+			value:       "1.0",
+			expectedErr: "expected scalar value, got table",
+		},
+		{
+			name:        "create key in inline table",
+			fieldPath:   "dependencies.serde.optional",
+			value:       true,
+			createKeys:  true,
+			expectedErr: "cannot create keys in inline table",
+		},
+		{
+			name:        "non-scalar value",
+			fieldPath:   "package.version",
+			value:       map[string]any{"a": "b"},
+			expectedErr: "expected scalar value, got map[string]interface {}",
+		},
+		{
+			name:         "crlf line endings",
+			input:        "[package]\r\nname = \"a\"\r\n",
+			fieldPath:    "package.version",
+			value:        "1.0.0",
+			createKeys:   true,
+			expectedDiff: [2]string{"[package]\r\nname = \"a\"\r\n", "[package]\r\nname = \"a\"\r\nversion = \"1.0.0\"\r\n"},
+		},
+		{
+			name:         "multi-line strings",
+			input:        "a = \"\"\"\n[not-a-table]\nb = 1\"\"\"\"\"\nb = '''x'''\n",
+			fieldPath:    "b",
+			value:        "line\n\"quoted\"",
+			expectedDiff: [2]string{"b = '''x'''", `b = "line\n\"quoted\""`},
+		},
+		{
+			name:         "key in root without trailing newline",
+			input:        "title = \"a\"",
+			fieldPath:    "owner",
+			value:        "b",
+			createKeys:   true,
+			expectedDiff: [2]string{`title = "a"`, "title = \"a\"\nowner = \"b\"\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			if input == "" {
+				input = cargoTOML
+			}
+			patcher, err := toml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+
+			err = patcher.SetField(tt.fieldPath, tt.value, tt.createKeys)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			err = patcher.Encode(&sb)
+			require.NoError(t, err)
+
+			require.Contains(t, input, tt.expectedDiff[0])
+			assert.Equal(t, strings.Replace(input, tt.expectedDiff[0], tt.expectedDiff[1], 1), sb.String())
+		})
+	}
+}
+
+func TestPatcher_Field(t *testing.T) {
+	patcher, err := toml.NewPatcher(strings.NewReader(cargoTOML))
+	require.NoError(t, err)
+
+	value, err := patcher.Field("dependencies.serde.version")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", value)
+
+	value, err = patcher.Field("bin[0].name")
+	require.NoError(t, err)
+	assert.Equal(t, "server", value)
+
+	value, err = patcher.Field("package.description")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestNewPatcher_Invalid(t *testing.T) {
+	_, err := toml.NewPatcher(strings.NewReader("[package\nname = 1"))
+	require.Error(t, err)
+}
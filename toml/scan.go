@@ -0,0 +1,487 @@
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// document is the structure of a TOML document with the byte ranges of values, it is scanned from the text
+// instead of being decoded, so values can be replaced without touching comments and layout.
+type document struct {
+	entries []*entry
+	// tables defined by headers, the first table is the root table.
+	tables []*table
+}
+
+// entry is a key/value pair.
+type entry struct {
+	// key is the full key including the keys of the table, elements of arrays of tables have an index key.
+	key []string
+	// start and end are the range of the raw value.
+	start, end int
+	scalar     bool
+	// inline is true if the entry is defined in an inline table.
+	inline bool
+	// table is the table the entry is defined in.
+	table *table
+}
+
+func (e *entry) kind(data []byte) string {
+	switch data[e.start] {
+	case '[':
+		return "array"
+	case '{':
+		return "inline table"
+	default:
+		return "scalar"
+	}
+}
+
+// table is defined by a header (or is the root table).
+type table struct {
+	key []string
+	// insertAt is the offset after the last key/value of the table (or the header), where new keys are inserted.
+	insertAt int
+}
+
+func (d *document) entry(key []string) *entry {
+	for _, e := range d.entries {
+		if keyEqual(e.key, key) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *document) table(key []string) *table {
+	for _, t := range d.tables {
+		if keyEqual(t.key, key) {
+			return t
+		}
+	}
+	return nil
+}
+
+// isTable returns true if the key is a table defined by a header, an inline table or dotted keys.
+func (d *document) isTable(key []string) bool {
+	if d.table(key) != nil {
+		return true
+	}
+	for _, e := range d.entries {
+		if len(e.key) > len(key) && keyEqual(e.key[:len(key)], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// definesDottedTable returns true if the table defines the key as a table with dotted keys.
+func (d *document) definesDottedTable(t *table, key []string) bool {
+	for _, e := range d.entries {
+		if e.table == t && !e.inline && len(e.key) > len(key) && keyEqual(e.key[:len(key)], key) {
+			return true
+		}
+	}
+	return false
+}
+
+// insert inserts a new key/value for the key, which must not exist.
+func (d *document) insert(data []byte, key []string, encoded string) ([]byte, error) {
+	parent := key[:len(key)-1]
+	if e := d.entry(parent); e != nil && len(parent) > 0 {
+		return nil, fmt.Errorf("cannot create keys in %s (at line %d)", e.kind(data), lineOf(data, e.start))
+	}
+
+	newline := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+
+	// Find the table of the key, keys of tables defined by dotted keys are inserted as dotted keys into the defining table
+	var t *table
+	for _, candidate := range d.tables {
+		if len(candidate.key) <= len(parent) && keyEqual(candidate.key, parent[:len(candidate.key)]) &&
+			(t == nil || len(candidate.key) > len(t.key)) {
+			t = candidate
+		}
+	}
+	if len(t.key) < len(parent) && !d.definesDottedTable(t, parent) {
+		t = nil
+	}
+
+	if t != nil {
+		line := formatKey(key[len(t.key):]) + " = " + encoded + newline
+		if t.insertAt == len(data) && len(data) > 0 && data[len(data)-1] != '\n' {
+			line = newline + line
+		}
+		return splice(data, t.insertAt, t.insertAt, line), nil
+	}
+
+	for _, k := range parent {
+		if isIndexKey(k) {
+			return nil, errors.New("cannot create elements of arrays of tables")
+		}
+	}
+	var sb strings.Builder
+	sb.Write(data)
+	if len(data) > 0 {
+		if data[len(data)-1] != '\n' {
+			sb.WriteString(newline)
+		}
+		sb.WriteString(newline)
+	}
+	sb.WriteString("[" + formatKey(parent) + "]" + newline)
+	sb.WriteString(formatKey(key[len(parent):]) + " = " + encoded + newline)
+	return []byte(sb.String()), nil
+}
+
+func formatKey(key []string) string {
+	parts := make([]string, len(key))
+	for i, k := range key {
+		if isBareKey(k) {
+			parts[i] = k
+		} else {
+			parts[i] = quoteString(k)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func keyEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type scanner struct {
+	data []byte
+	pos  int
+	doc  *document
+	// current is the table of the scanned key/values.
+	current *table
+	// arrayLengths are the number of elements of arrays of tables by joined key.
+	arrayLengths map[string]int
+}
+
+func scan(data []byte) (*document, error) {
+	s := &scanner{
+		data:         data,
+		doc:          &document{},
+		arrayLengths: make(map[string]int),
+	}
+	s.current = &table{}
+	s.doc.tables = append(s.doc.tables, s.current)
+
+	for {
+		s.skipSpaceAndComments(true)
+		if s.pos >= len(s.data) {
+			return s.doc, nil
+		}
+
+		var err error
+		if s.data[s.pos] == '[' {
+			var t *table
+			t, err = s.scanHeader()
+			if err == nil {
+				s.current = t
+				s.doc.tables = append(s.doc.tables, t)
+			}
+		} else {
+			err = s.scanKeyValue(s.current.key, true, false)
+		}
+		if err == nil {
+			err = s.scanEndOfLine()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("scanning TOML (at line %d): %w", lineOf(s.data, min(s.pos, len(s.data))), err)
+		}
+		s.current.insertAt = s.pos
+	}
+}
+
+func (s *scanner) scanHeader() (*table, error) {
+	array := s.hasPrefix("[[")
+	if array {
+		s.pos += 2
+	} else {
+		s.pos++
+	}
+	parts, err := s.scanKey()
+	if err != nil {
+		return nil, err
+	}
+	s.skipSpaceAndComments(false)
+	if array {
+		if !s.hasPrefix("]]") {
+			return nil, errors.New("expected ']]'")
+		}
+		s.pos += 2
+	} else {
+		if !s.hasPrefix("]") {
+			return nil, errors.New("expected ']'")
+		}
+		s.pos++
+	}
+
+	// Keys of arrays of tables in the header refer to the last element
+	var key []string
+	for i, part := range parts {
+		key = append(key, part)
+		n := s.arrayLengths[strings.Join(key, "\x00")]
+		if i < len(parts)-1 && n > 0 {
+			key = append(key, indexKey(n-1))
+		}
+	}
+	if array {
+		joined := strings.Join(key, "\x00")
+		key = append(key, indexKey(s.arrayLengths[joined]))
+		s.arrayLengths[joined]++
+	}
+
+	return &table{key: key}, nil
+}
+
+func (s *scanner) scanKeyValue(prefix []string, record, inline bool) error {
+	parts, err := s.scanKey()
+	if err != nil {
+		return err
+	}
+	s.skipSpaceAndComments(false)
+	if !s.hasPrefix("=") {
+		return errors.New("expected '='")
+	}
+	s.pos++
+	s.skipSpaceAndComments(false)
+
+	key := append(append([]string{}, prefix...), parts...)
+	start := s.pos
+	scalar, err := s.scanValue(key, record)
+	if err != nil {
+		return err
+	}
+	if record {
+		s.doc.entries = append(s.doc.entries, &entry{key: key, start: start, end: s.pos, scalar: scalar, inline: inline, table: s.current})
+	}
+	return nil
+}
+
+func (s *scanner) scanKey() ([]string, error) {
+	var parts []string
+	for {
+		s.skipSpaceAndComments(false)
+		if s.pos >= len(s.data) {
+			return nil, errors.New("expected key")
+		}
+		switch s.data[s.pos] {
+		case '"':
+			start := s.pos
+			if err := s.scanBasicString(); err != nil {
+				return nil, err
+			}
+			part, err := strconv.Unquote(string(s.data[start:s.pos]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted key: %w", err)
+			}
+			parts = append(parts, part)
+		case '\'':
+			start := s.pos
+			if err := s.scanLiteralString(); err != nil {
+				return nil, err
+			}
+			parts = append(parts, string(s.data[start+1:s.pos-1]))
+		default:
+			start := s.pos
+			for s.pos < len(s.data) && isBareKeyChar(s.data[s.pos]) {
+				s.pos++
+			}
+			if start == s.pos {
+				return nil, fmt.Errorf("unexpected character %q in key", s.data[s.pos])
+			}
+			parts = append(parts, string(s.data[start:s.pos]))
+		}
+		s.skipSpaceAndComments(false)
+		if !s.hasPrefix(".") {
+			return parts, nil
+		}
+		s.pos++
+	}
+}
+
+// dateRegexp matches a date that can be followed by a space and a time.
+var dateRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// scanValue scans the value at the current position, keys of inline tables are recorded if record is true.
+func (s *scanner) scanValue(key []string, record bool) (scalar bool, err error) {
+	if s.pos >= len(s.data) {
+		return false, errors.New("expected value")
+	}
+	switch {
+	case s.hasPrefix(`"""`):
+		return true, s.scanMultilineString(`"""`, true)
+	case s.hasPrefix(`'''`):
+		return true, s.scanMultilineString(`'''`, false)
+	case s.hasPrefix(`"`):
+		return true, s.scanBasicString()
+	case s.hasPrefix(`'`):
+		return true, s.scanLiteralString()
+	case s.hasPrefix("["):
+		s.pos++
+		for {
+			s.skipSpaceAndComments(true)
+			if s.hasPrefix("]") {
+				s.pos++
+				return false, nil
+			}
+			// Elements of arrays cannot be addressed
+			if _, err := s.scanValue(nil, false); err != nil {
+				return false, err
+			}
+			s.skipSpaceAndComments(true)
+			if s.hasPrefix(",") {
+				s.pos++
+			} else if !s.hasPrefix("]") {
+				return false, errors.New("expected ',' or ']' in array")
+			}
+		}
+	case s.hasPrefix("{"):
+		s.pos++
+		for {
+			s.skipSpaceAndComments(true)
+			if s.hasPrefix("}") {
+				s.pos++
+				return false, nil
+			}
+			if err := s.scanKeyValue(key, record, true); err != nil {
+				return false, err
+			}
+			s.skipSpaceAndComments(true)
+			if s.hasPrefix(",") {
+				s.pos++
+			} else if !s.hasPrefix("}") {
+				return false, errors.New("expected ',' or '}' in inline table")
+			}
+		}
+	default:
+		start := s.pos
+		s.scanBareValue()
+		// A local date can be followed by a time separated by a space
+		if dateRegexp.Match(s.data[start:s.pos]) && s.hasPrefix(" ") && s.pos+1 < len(s.data) && s.data[s.pos+1] >= '0' && s.data[s.pos+1] <= '9' {
+			s.pos++
+			s.scanBareValue()
+		}
+		if start == s.pos {
+			return false, errors.New("expected value")
+		}
+		return true, nil
+	}
+}
+
+func (s *scanner) scanBareValue() {
+	for s.pos < len(s.data) && !strings.ContainsRune(" \t\r\n,]}#", rune(s.data[s.pos])) {
+		s.pos++
+	}
+}
+
+func (s *scanner) scanBasicString() error {
+	s.pos++
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos += 2
+		case '"':
+			s.pos++
+			return nil
+		case '\n':
+			return errors.New("unterminated string")
+		default:
+			s.pos++
+		}
+	}
+	return errors.New("unterminated string")
+}
+
+func (s *scanner) scanLiteralString() error {
+	end := bytes.IndexAny(s.data[s.pos+1:], "'\n")
+	if end < 0 || s.data[s.pos+1+end] != '\'' {
+		return errors.New("unterminated string")
+	}
+	s.pos += end + 2
+	return nil
+}
+
+func (s *scanner) scanMultilineString(delim string, escapes bool) error {
+	s.pos += len(delim)
+	for s.pos < len(s.data) {
+		if escapes && s.data[s.pos] == '\\' {
+			s.pos += 2
+			continue
+		}
+		if s.hasPrefix(delim) {
+			s.pos += len(delim)
+			// Up to two quotes are allowed before the closing delimiter
+			for i := 0; i < 2 && s.pos < len(s.data) && s.data[s.pos] == delim[0]; i++ {
+				s.pos++
+			}
+			return nil
+		}
+		s.pos++
+	}
+	return errors.New("unterminated multi-line string")
+}
+
+func (s *scanner) scanEndOfLine() error {
+	s.skipSpaceAndComments(false)
+	switch {
+	case s.pos >= len(s.data):
+	case s.hasPrefix("\n"):
+		s.pos++
+	case s.hasPrefix("\r\n"):
+		s.pos += 2
+	default:
+		return fmt.Errorf("expected end of line, got %q", s.data[s.pos])
+	}
+	return nil
+}
+
+// skipSpaceAndComments skips whitespace and comments, newlines are only skipped if newlines is true.
+func (s *scanner) skipSpaceAndComments(newlines bool) {
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case c == ' ' || c == '\t':
+			s.pos++
+		case newlines && (c == '\n' || c == '\r'):
+			s.pos++
+		case c == '#':
+			for s.pos < len(s.data) && s.data[s.pos] != '\n' && s.data[s.pos] != '\r' {
+				s.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(s.data[s.pos:], []byte(prefix))
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
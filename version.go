@@ -0,0 +1,4 @@
+package vignet
+
+// Version of vignet, it is set on release builds with -ldflags "-X github.com/networkteam/vignet.Version=...".
+var Version = "dev"
@@ -0,0 +1,253 @@
+package yaml
+
+import (
+	"fmt"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+const mergeTag = "!!merge"
+
+// SetExpandAliases sets if a field that is shared by an anchor and aliases is expanded when it is set: aliases and
+// merge keys on the path are replaced by a copy that is changed instead of the anchor, and aliases of a changed anchor
+// are replaced by a copy of the unchanged values. Setting a shared field returns an error otherwise.
+func (p *Patcher) SetExpandAliases(expand bool) {
+	p.expandAliases = expand
+}
+
+// resolvePath finds the value node at the path of mapping keys following aliases and merge keys, which are not
+// followed by JSONPath. It returns the anchored node of the first alias that was followed, so the value node is shared.
+func resolvePath(node *goyaml.Node, path []string) (valueNode *goyaml.Node, anchored *goyaml.Node) {
+	node = documentContent(node)
+	for _, key := range path {
+		if node.Kind == goyaml.AliasNode {
+			if anchored == nil {
+				anchored = node.Alias
+			}
+			node = node.Alias
+		}
+		value, merged := mappingValue(node, key)
+		if value == nil {
+			return nil, nil
+		}
+		if anchored == nil {
+			anchored = merged
+		}
+		node = value
+	}
+	if node.Kind == goyaml.AliasNode {
+		if anchored == nil {
+			anchored = node.Alias
+		}
+		node = node.Alias
+	}
+	return node, anchored
+}
+
+// mappingValue returns the value for the key of a mapping, including keys of merged mappings. For a merged key the
+// anchored node of the merged alias is returned.
+func mappingValue(node *goyaml.Node, key string) (value *goyaml.Node, merged *goyaml.Node) {
+	if node.Kind != goyaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) && node.Content[i].Value == key {
+			return node.Content[i+1], nil
+		}
+	}
+	// Explicit keys take precedence over merged keys, earlier merged mappings over later ones
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) {
+			continue
+		}
+		sources := []*goyaml.Node{node.Content[i+1]}
+		if node.Content[i+1].Kind == goyaml.SequenceNode {
+			sources = node.Content[i+1].Content
+		}
+		for _, source := range sources {
+			var anchored *goyaml.Node
+			if source.Kind == goyaml.AliasNode {
+				source = source.Alias
+				anchored = source
+			}
+			if value, merged := mappingValue(source, key); value != nil {
+				if anchored == nil {
+					anchored = merged
+				}
+				return value, anchored
+			}
+		}
+	}
+	return nil, nil
+}
+
+// expandPath replaces aliases on the path by a copy of the anchored node and copies keys of merged mappings on the
+// path to the merging mapping, so the node at the path is not shared anymore.
+func expandPath(node *goyaml.Node, path []string) {
+	node = documentContent(node)
+	for _, key := range path {
+		if node.Kind == goyaml.AliasNode {
+			expandAlias(node)
+		}
+		value, merged := mappingValue(node, key)
+		if value == nil {
+			return
+		}
+		if merged != nil {
+			value = copyUnanchored(value)
+			node.Content = append(node.Content, &goyaml.Node{Kind: goyaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		}
+		node = value
+	}
+	if node.Kind == goyaml.AliasNode {
+		expandAlias(node)
+	}
+}
+
+type sharedAnchor struct {
+	node    *goyaml.Node
+	aliases []*goyaml.Node
+}
+
+// sharedAnchors returns the anchored nodes that are referenced by aliases and contain the node (or are the node),
+// starting with the innermost.
+func sharedAnchors(root *goyaml.Node, node *goyaml.Node) []sharedAnchor {
+	parents := make(map[*goyaml.Node]*goyaml.Node)
+	aliases := make(map[*goyaml.Node][]*goyaml.Node)
+	var walk func(n *goyaml.Node)
+	walk = func(n *goyaml.Node) {
+		if n.Kind == goyaml.AliasNode {
+			aliases[n.Alias] = append(aliases[n.Alias], n)
+		}
+		for _, child := range n.Content {
+			parents[child] = n
+			walk(child)
+		}
+	}
+	walk(root)
+
+	var shared []sharedAnchor
+	for n := node; n != nil; n = parents[n] {
+		if len(aliases[n]) > 0 {
+			shared = append(shared, sharedAnchor{node: n, aliases: aliases[n]})
+		}
+	}
+	return shared
+}
+
+// expandAnchor replaces the aliases of an anchored node by a copy of the node and removes the anchor, so the node
+// can be changed without changing the aliases. Merged aliases are replaced by the keys that are not set explicitly.
+func expandAnchor(root *goyaml.Node, anchored *goyaml.Node, aliases []*goyaml.Node) {
+	for _, alias := range aliases {
+		if mapping := mergingMapping(root, alias); mapping != nil && anchored.Kind == goyaml.MappingNode {
+			inlineMerge(mapping, alias)
+			continue
+		}
+		expandAlias(alias)
+	}
+	anchored.Anchor = ""
+}
+
+// mergingMapping returns the mapping with the alias as the value of a merge key, or nil.
+func mergingMapping(root *goyaml.Node, alias *goyaml.Node) *goyaml.Node {
+	var found *goyaml.Node
+	var walk func(n *goyaml.Node)
+	walk = func(n *goyaml.Node) {
+		if n.Kind == goyaml.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if isMergeKey(n.Content[i]) && n.Content[i+1] == alias {
+					found = n
+					return
+				}
+			}
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	walk(root)
+	return found
+}
+
+// inlineMerge replaces the merge key with the alias by copies of the merged keys that are not set in the mapping.
+func inlineMerge(mapping *goyaml.Node, alias *goyaml.Node) {
+	source := alias.Alias
+	var content []*goyaml.Node
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i+1] != alias {
+			content = append(content, mapping.Content[i], mapping.Content[i+1])
+			continue
+		}
+		for j := 0; j+1 < len(source.Content); j += 2 {
+			key := source.Content[j]
+			if !isMergeKey(key) {
+				if value, merged := mappingValue(mapping, key.Value); value != nil && merged == nil {
+					continue
+				}
+			}
+			content = append(content, copyUnanchored(key), copyUnanchored(source.Content[j+1]))
+		}
+	}
+	mapping.Content = content
+}
+
+// expandAlias replaces the alias node by a copy of the anchored node, comments of the alias are kept.
+func expandAlias(alias *goyaml.Node) {
+	expanded := copyUnanchored(alias.Alias)
+	expanded.HeadComment, expanded.LineComment, expanded.FootComment = alias.HeadComment, alias.LineComment, alias.FootComment
+	*alias = *expanded
+}
+
+// copyUnanchored returns a deep copy of the node without anchors, aliases in the copy still reference the anchored nodes.
+func copyUnanchored(node *goyaml.Node) *goyaml.Node {
+	c := *node
+	c.Anchor = ""
+	if node.Content != nil {
+		c.Content = make([]*goyaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			c.Content[i] = copyUnanchored(child)
+		}
+	}
+	return &c
+}
+
+func isMergeKey(node *goyaml.Node) bool {
+	return node.Kind == goyaml.ScalarNode && node.Value == "<<" && node.Tag == mergeTag
+}
+
+func documentContent(node *goyaml.Node) *goyaml.Node {
+	if node.Kind == goyaml.DocumentNode && len(node.Content) == 1 {
+		return node.Content[0]
+	}
+	return node
+}
+
+// untagMergeKeys removes the tag of merge keys, since the encoder writes them with an explicit "!!merge" tag.
+// The returned function restores the tags.
+func untagMergeKeys(nodes ...*goyaml.Node) (restore func()) {
+	var keys []*goyaml.Node
+	var walk func(n *goyaml.Node)
+	walk = func(n *goyaml.Node) {
+		if isMergeKey(n) {
+			keys = append(keys, n)
+		}
+		for _, child := range n.Content {
+			walk(child)
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+	for _, key := range keys {
+		key.Tag = ""
+	}
+	return func() {
+		for _, key := range keys {
+			key.Tag = mergeTag
+		}
+	}
+}
+
+func sharedFieldError(path string, anchored *goyaml.Node) error {
+	return fmt.Errorf("field %q is shared by anchor %q with aliases, it can only be set with expanding aliases", path, anchored.Anchor)
+}
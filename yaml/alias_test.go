@@ -0,0 +1,254 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestPatcher_SetFieldWithAliases(t *testing.T) {
+	input := `defaults: &defaults
+  image: &image
+    tag: 1.0.0
+  replicas: 1
+production:
+  <<: *defaults
+  replicas: 2
+staging:
+  image: *image
+version: &version 1.0.0
+chart:
+  version: *version # the chart version
+`
+
+	tests := []struct {
+		name          string
+		field         string
+		expandAliases bool
+		expectedYAML  string
+		expectedErr   string
+	}{
+		{
+			name:        "merged key",
+			field:       "production.image.tag",
+			expectedErr: `field "production.image.tag" is shared by anchor "defaults" with aliases`,
+		},
+		{
+			name:          "merged key expanded",
+			field:         "production.image.tag",
+			expandAliases: true,
+			expectedYAML: `defaults: &defaults
+  image: &image
+    tag: 1.0.0
+  replicas: 1
+production:
+  <<: *defaults
+  replicas: 2
+  image:
+    tag: 2.0.0
+staging:
+  image: *image
+version: &version 1.0.0
+chart:
+  version: *version # the chart version
+`,
+		},
+		{
+			name:        "key of alias",
+			field:       "staging.image.tag",
+			expectedErr: `field "staging.image.tag" is shared by anchor "image" with aliases`,
+		},
+		{
+			name:          "key of alias expanded",
+			field:         "staging.image.tag",
+			expandAliases: true,
+			expectedYAML: `defaults: &defaults
+  image: &image
+    tag: 1.0.0
+  replicas: 1
+production:
+  <<: *defaults
+  replicas: 2
+staging:
+  image:
+    tag: 2.0.0
+version: &version 1.0.0
+chart:
+  version: *version # the chart version
+`,
+		},
+		{
+			name:        "alias",
+			field:       "chart.version",
+			expectedErr: `field "chart.version" is shared by anchor "version" with aliases`,
+		},
+		{
+			name:          "alias expanded",
+			field:         "chart.version",
+			expandAliases: true,
+			expectedYAML: `defaults: &defaults
+  image: &image
+    tag: 1.0.0
+  replicas: 1
+production:
+  <<: *defaults
+  replicas: 2
+staging:
+  image: *image
+version: &version 1.0.0
+chart:
+  version: 2.0.0 # the chart version
+`,
+		},
+		{
+			name:        "anchored mapping",
+			field:       "defaults.image.tag",
+			expectedErr: `field "defaults.image.tag" is shared by anchor "image" with aliases`,
+		},
+		{
+			name:          "anchored mapping expanded",
+			field:         "defaults.image.tag",
+			expandAliases: true,
+			expectedYAML: `defaults:
+  image:
+    tag: 2.0.0
+  replicas: 1
+production:
+  image:
+    tag: 1.0.0
+  replicas: 2
+staging:
+  image:
+    tag: 1.0.0
+version: &version 1.0.0
+chart:
+  version: *version # the chart version
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+			patcher.SetExpandAliases(tt.expandAliases)
+
+			err = patcher.SetField(tt.field, "2.0.0", false)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_FieldWithAliases(t *testing.T) {
+	patcher, err := yaml.NewPatcher(strings.NewReader(`defaults: &defaults
+  image:
+    tag: 1.0.0
+production:
+  <<: *defaults
+staging: *defaults
+`))
+	require.NoError(t, err)
+
+	for _, field := range []string{"production.image.tag", "staging.image.tag"} {
+		value, err := patcher.Field(field)
+		require.NoError(t, err)
+		assert.Equal(t, "1.0.0", value, field)
+	}
+}
+
+func TestPatcher_EncodeKeepsMergeKeys(t *testing.T) {
+	input := `defaults: &defaults
+  replicas: 1
+production:
+  <<: *defaults
+  replicas: 2
+`
+	patcher, err := yaml.NewPatcher(strings.NewReader(input))
+	require.NoError(t, err)
+	require.NoError(t, patcher.SetField("production.replicas", 3, false))
+
+	var sb strings.Builder
+	require.NoError(t, patcher.Encode(&sb))
+	assert.Equal(t, strings.Replace(input, "replicas: 2", "replicas: 3", 1), sb.String())
+}
+
+func TestPatcher_DeleteFieldWithAliases(t *testing.T) {
+	input := `defaults: &defaults
+  image:
+    tag: 1.0.0
+  replicas: 1
+production:
+  <<: *defaults
+`
+	patcher, err := yaml.NewPatcher(strings.NewReader(input))
+	require.NoError(t, err)
+
+	err = patcher.DeleteField("defaults.replicas")
+	require.ErrorContains(t, err, `field "defaults.replicas" is shared by anchor "defaults" with aliases`)
+
+	patcher.SetExpandAliases(true)
+	require.NoError(t, patcher.DeleteField("defaults.replicas"))
+
+	var sb strings.Builder
+	require.NoError(t, patcher.Encode(&sb))
+	assert.Equal(t, `defaults:
+  image:
+    tag: 1.0.0
+production:
+  image:
+    tag: 1.0.0
+  replicas: 1
+`, sb.String())
+}
+
+func TestPatcher_ChangeThroughMergeKeys(t *testing.T) {
+	input := `defaults: &defaults
+  image:
+    tag: 1.0.0
+  ports:
+    - 80
+production:
+  <<: *defaults
+`
+
+	t.Run("delete field", func(t *testing.T) {
+		patcher, err := yaml.NewPatcher(strings.NewReader(input))
+		require.NoError(t, err)
+
+		err = patcher.DeleteField("production.image.tag")
+		require.ErrorContains(t, err, `field "production.image.tag" is shared by anchor "defaults" with aliases`)
+
+		patcher.SetExpandAliases(true)
+		require.NoError(t, patcher.DeleteField("production.image.tag"))
+
+		var sb strings.Builder
+		require.NoError(t, patcher.Encode(&sb))
+		assert.Equal(t, input+"  image: {}\n", sb.String())
+	})
+
+	t.Run("add to array", func(t *testing.T) {
+		patcher, err := yaml.NewPatcher(strings.NewReader(input))
+		require.NoError(t, err)
+
+		err = patcher.AddToArray("production.ports", 443, nil)
+		require.ErrorContains(t, err, `field "production.ports" is shared by anchor "defaults" with aliases`)
+
+		patcher.SetExpandAliases(true)
+		require.NoError(t, patcher.AddToArray("production.ports", 443, nil))
+
+		var sb strings.Builder
+		require.NoError(t, patcher.Encode(&sb))
+		assert.Equal(t, input+"  ports:\n    - 80\n    - 443\n", sb.String())
+	})
+}
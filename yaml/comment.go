@@ -0,0 +1,86 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// SetComment sets the line comment (at the end of the line of the field) and the head comment (on the lines above
+// the field) of the node matching path. A nil comment is kept and an empty comment is removed. Comments are given
+// without "#" (e.g. `{"$imagepolicy": "flux-system:app"}`), head comments can have multiple lines.
+// It returns true if a comment changed.
+func (p *Patcher) SetComment(path string, lineComment, headComment *string) (bool, error) {
+	matchedNodes, anchored, err := p.findNodes(path)
+	if err != nil {
+		return false, err
+	}
+	if len(matchedNodes) == 0 {
+		return false, errors.New("no nodes matched path")
+	} else if len(matchedNodes) > 1 {
+		return false, errors.New("multiple nodes matched path")
+	} else if anchored != nil {
+		// Comments cannot be set on fields of merged mappings, which are not part of the mapping
+		return false, sharedFieldError(path, anchored)
+	}
+
+	node := matchedNodes[0]
+	parent, index := findParent(p.node, node)
+	if parent == nil || parent.Kind == goyaml.DocumentNode {
+		return false, errors.New("cannot set comments of the root node")
+	}
+	// The head comment of an entry of a mapping is written above its key
+	headNode := node
+	if parent.Kind == goyaml.MappingNode {
+		headNode = parent.Content[index-1]
+	}
+
+	changed := false
+	if lineComment != nil {
+		if strings.Contains(*lineComment, "\n") {
+			return false, errors.New("line comment must be a single line")
+		}
+		lineNode := node
+		isBlock := (node.Kind == goyaml.MappingNode || node.Kind == goyaml.SequenceNode) && node.Style&goyaml.FlowStyle == 0
+		if isBlock {
+			// The line comment of a mapping or sequence is written after its key
+			if parent.Kind != goyaml.MappingNode {
+				return false, fmt.Errorf("line comment is not supported for %s in a sequence (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+			}
+			lineNode = headNode
+		}
+		comment := formatComment(*lineComment)
+		if lineNode.LineComment != comment {
+			lineNode.LineComment = comment
+			changed = true
+		}
+	}
+	if headComment != nil {
+		comment := formatComment(*headComment)
+		if headNode.HeadComment != comment {
+			headNode.HeadComment = comment
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// formatComment prefixes the lines of the comment with "#", lines that already start with "#" are kept.
+func formatComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case line == "":
+			lines[i] = "#"
+		default:
+			lines[i] = "# " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,122 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestPatcher_SetComment(t *testing.T) {
+	input := `image:
+  repository: test.example.com/app
+  tag: 0.1.0 # old marker
+hosts:
+  - a.example.com
+`
+	ptr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name            string
+		path            string
+		lineComment     *string
+		headComment     *string
+		expectedChanged bool
+		expectedYAML    string
+		expectedErr     string
+	}{
+		{
+			name:            "line comment of scalar",
+			path:            "image.tag",
+			lineComment:     ptr(`{"$imagepolicy": "flux-system:app:tag"}`),
+			expectedChanged: true,
+			expectedYAML: `image:
+  repository: test.example.com/app
+  tag: 0.1.0 # {"$imagepolicy": "flux-system:app:tag"}
+hosts:
+  - a.example.com
+`,
+		},
+		{
+			name:            "remove line comment",
+			path:            "image.tag",
+			lineComment:     ptr(""),
+			expectedChanged: true,
+			expectedYAML: `image:
+  repository: test.example.com/app
+  tag: 0.1.0
+hosts:
+  - a.example.com
+`,
+		},
+		{
+			name:            "unchanged line comment",
+			path:            "image.tag",
+			lineComment:     ptr("# old marker"),
+			expectedChanged: false,
+			expectedYAML:    input,
+		},
+		{
+			name:            "head and line comment of mapping",
+			path:            "image",
+			lineComment:     ptr("managed by vignet"),
+			headComment:     ptr("Image of the app\n\nUpdated by CI"),
+			expectedChanged: true,
+			expectedYAML: `# Image of the app
+#
+# Updated by CI
+image: # managed by vignet
+  repository: test.example.com/app
+  tag: 0.1.0 # old marker
+hosts:
+  - a.example.com
+`,
+		},
+		{
+			name:            "head comment of sequence item",
+			path:            "hosts[0]",
+			headComment:     ptr("primary host"),
+			expectedChanged: true,
+			expectedYAML: `image:
+  repository: test.example.com/app
+  tag: 0.1.0 # old marker
+hosts:
+  # primary host
+  - a.example.com
+`,
+		},
+		{
+			name:        "multi-line line comment",
+			path:        "image.tag",
+			lineComment: ptr("a\nb"),
+			expectedErr: "line comment must be a single line",
+		},
+		{
+			name:        "missing field",
+			path:        "image.digest",
+			lineComment: ptr("digest"),
+			expectedErr: "no nodes matched path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+
+			changed, err := patcher.SetComment(tt.path, tt.lineComment, tt.headComment)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedChanged, changed)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
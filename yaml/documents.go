@@ -0,0 +1,119 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// Documents is a stream of YAML documents, e.g. a file with multiple Kubernetes manifests separated by "---".
+type Documents struct {
+	patchers []*Patcher
+	style    style
+}
+
+func NewDocuments(r io.Reader) (*Documents, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := goyaml.NewDecoder(bytes.NewReader(src))
+	var (
+		patchers []*Patcher
+		nodes    []*goyaml.Node
+	)
+	for {
+		var node goyaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &node)
+	}
+
+	// All documents of the stream are encoded in the same style
+	s := detectStyle(nodes...)
+	s.wrapped = wrappedScalars(src, nodes...)
+	for _, node := range nodes {
+		patchers = append(patchers, &Patcher{node: node, style: s})
+	}
+
+	return &Documents{
+		patchers: patchers,
+		style:    s,
+	}, nil
+}
+
+// Patchers returns a patcher for each document in the order of the stream.
+func (d *Documents) Patchers() []*Patcher {
+	return d.patchers
+}
+
+// Encode writes the documents with the indentation and sequence style of the input.
+func (d *Documents) Encode(w io.Writer) error {
+	nodes := make([]*goyaml.Node, len(d.patchers))
+	for i, p := range d.patchers {
+		nodes[i] = p.node
+	}
+	return d.style.encode(w, nodes...)
+}
+
+// SetMapEntry sets the key of the mapping at the path of mapping keys to a string value. Missing or empty mappings
+// of the path are created, keys can contain dots (e.g. "app.kubernetes.io/name"). It returns true if the value changed.
+func (p *Patcher) SetMapEntry(path []string, key string, value string) (bool, error) {
+	node := p.node
+	if node.Kind == goyaml.DocumentNode {
+		if len(node.Content) != 1 {
+			return false, fmt.Errorf("expected exactly one node in document, got %d (at %d:%d)", len(node.Content), node.Line, node.Column)
+		}
+		node = node.Content[0]
+	}
+
+	keys := append(append([]string{}, path...), key)
+	for i, k := range keys {
+		if node.Kind != goyaml.MappingNode {
+			return false, fmt.Errorf("expected mapping node, got %s (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+		}
+		var valueNode *goyaml.Node
+		for j := 0; j < len(node.Content); j += 2 {
+			if node.Content[j].Value == k {
+				valueNode = node.Content[j+1]
+				break
+			}
+		}
+		if valueNode == nil {
+			valueNode = &goyaml.Node{Kind: goyaml.ScalarNode, Tag: "!!null"}
+			node.Content = append(node.Content, &goyaml.Node{Kind: goyaml.ScalarNode, Value: k}, valueNode)
+		}
+		// An empty value (e.g. "labels:") is replaced by a mapping, unless it is the entry to set
+		if i < len(keys)-1 && valueNode.Kind == goyaml.ScalarNode && valueNode.ShortTag() == "!!null" {
+			*valueNode = goyaml.Node{Kind: goyaml.MappingNode}
+		}
+		node = valueNode
+	}
+
+	if node.Kind != goyaml.ScalarNode {
+		return false, fmt.Errorf("expected scalar node, got %s (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+	}
+	if node.ShortTag() == "!!str" && node.Value == value {
+		return false, nil
+	}
+
+	newNode := new(goyaml.Node)
+	if err := newNode.Encode(value); err != nil {
+		return false, fmt.Errorf("encoding value: %w", err)
+	}
+	node.Value = newNode.Value
+	node.Tag = newNode.Tag
+	// Values that need quotes (e.g. "true") are quoted, otherwise the style of the existing value is kept
+	if newNode.Style != 0 {
+		node.Style = newNode.Style
+	}
+	return true, nil
+}
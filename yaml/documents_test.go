@@ -0,0 +1,129 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestDocuments(t *testing.T) {
+	input := `apiVersion: v1
+kind: Service
+metadata:
+  name: my-app # the service
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+`
+	docs, err := yaml.NewDocuments(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, docs.Patchers(), 2)
+
+	kind, err := docs.Patchers()[1].Field("kind")
+	require.NoError(t, err)
+	assert.Equal(t, "Deployment", kind)
+
+	var sb strings.Builder
+	require.NoError(t, docs.Encode(&sb))
+	assert.Equal(t, input, sb.String())
+}
+
+func TestPatcher_SetMapEntry(t *testing.T) {
+	tests := []struct {
+		name          string
+		inputYAML     string
+		key           string
+		value         string
+		expectedYAML  string
+		expectChanged bool
+		expectedErr   string
+	}{
+		{
+			name: "existing key",
+			inputYAML: `metadata:
+  labels:
+    app.kubernetes.io/version: "1.0" # set by CI
+`,
+			key:   "app.kubernetes.io/version",
+			value: "1.1",
+			expectedYAML: `metadata:
+  labels:
+    app.kubernetes.io/version: "1.1" # set by CI
+`,
+			expectChanged: true,
+		},
+		{
+			name: "missing mapping",
+			inputYAML: `metadata:
+  name: my-app
+`,
+			key:   "team",
+			value: "true",
+			expectedYAML: `metadata:
+  name: my-app
+  labels:
+    team: "true"
+`,
+			expectChanged: true,
+		},
+		{
+			name: "empty mapping",
+			inputYAML: `metadata:
+  labels:
+`,
+			key:   "team",
+			value: "a",
+			expectedYAML: `metadata:
+  labels:
+    team: a
+`,
+			expectChanged: true,
+		},
+		{
+			name: "unchanged value",
+			inputYAML: `metadata:
+  labels:
+    team: a
+`,
+			key:   "team",
+			value: "a",
+			expectedYAML: `metadata:
+  labels:
+    team: a
+`,
+		},
+		{
+			name: "path is not a mapping",
+			inputYAML: `metadata:
+  labels: [a]
+`,
+			key:         "team",
+			value:       "a",
+			expectedErr: "expected mapping node, got SequenceNode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(tt.inputYAML))
+			require.NoError(t, err)
+
+			changed, err := patcher.SetMapEntry([]string{"metadata", "labels"}, tt.key, tt.value)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectChanged, changed)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
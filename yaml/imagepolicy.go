@@ -0,0 +1,54 @@
+package yaml
+
+import (
+	"encoding/json"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// SetImagePolicyMarkers sets values marked with a Flux image policy comment (e.g. `# {"$imagepolicy": "flux-system:my-app"}`)
+// for the policy ("namespace:name"). A marker for the policy sets the full image reference, markers with a ":name" or ":tag"
+// suffix set only the image name or tag. It returns the number of markers found and if a value changed.
+func (p *Patcher) SetImagePolicyMarkers(policy string, image string, tag string) (found int, changed bool) {
+	values := map[string]string{
+		policy:           image + ":" + tag,
+		policy + ":name": image,
+		policy + ":tag":  tag,
+	}
+
+	var walk func(node *goyaml.Node)
+	walk = func(node *goyaml.Node) {
+		if node.Kind == goyaml.ScalarNode && node.LineComment != "" {
+			if value, ok := values[imagePolicyMarker(node.LineComment)]; ok {
+				found++
+				if node.Value != value {
+					node.Value = value
+					node.Tag = "!!str"
+					changed = true
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(p.node)
+
+	return found, changed
+}
+
+// imagePolicyMarker returns the image policy of a marker comment or an empty string if the comment is not a marker.
+func imagePolicyMarker(comment string) string {
+	comment = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(comment), "#"))
+	if !strings.HasPrefix(comment, "{") {
+		return ""
+	}
+	var marker struct {
+		ImagePolicy string `json:"$imagepolicy"`
+	}
+	if err := json.Unmarshal([]byte(comment), &marker); err != nil {
+		return ""
+	}
+	return marker.ImagePolicy
+}
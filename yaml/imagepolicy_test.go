@@ -0,0 +1,55 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestPatcher_SetImagePolicyMarkers(t *testing.T) {
+	input := `spec:
+  containers:
+    - name: app
+      image: registry.example.com/app:1.0.0 # {"$imagepolicy": "flux-system:app"}
+    - name: sidecar
+      image: registry.example.com/sidecar:1.0.0 # {"$imagepolicy": "flux-system:sidecar"}
+  values:
+    image:
+      repository: registry.example.com/app # {"$imagepolicy": "flux-system:app:name"}
+      tag: "1.0" # {"$imagepolicy": "flux-system:app:tag"}
+    other: 1.0 # not a marker
+`
+
+	patcher, err := yaml.NewPatcher(strings.NewReader(input))
+	require.NoError(t, err)
+
+	found, changed := patcher.SetImagePolicyMarkers("flux-system:app", "registry.example.com/app", "1.1")
+	assert.Equal(t, 3, found)
+	assert.True(t, changed)
+
+	var sb strings.Builder
+	require.NoError(t, patcher.Encode(&sb))
+	assert.Equal(t, `spec:
+  containers:
+    - name: app
+      image: registry.example.com/app:1.1 # {"$imagepolicy": "flux-system:app"}
+    - name: sidecar
+      image: registry.example.com/sidecar:1.0.0 # {"$imagepolicy": "flux-system:sidecar"}
+  values:
+    image:
+      repository: registry.example.com/app # {"$imagepolicy": "flux-system:app:name"}
+      tag: "1.1" # {"$imagepolicy": "flux-system:app:tag"}
+    other: 1.0 # not a marker
+`, sb.String())
+
+	found, changed = patcher.SetImagePolicyMarkers("flux-system:app", "registry.example.com/app", "1.1")
+	assert.Equal(t, 3, found)
+	assert.False(t, changed)
+
+	found, _ = patcher.SetImagePolicyMarkers("flux-system:unknown", "registry.example.com/app", "1.1")
+	assert.Equal(t, 0, found)
+}
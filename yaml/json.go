@@ -0,0 +1,108 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// EncodeJSON writes the document as JSON indented with two spaces, keys keep the order of the document.
+// Comments cannot be represented in JSON and are dropped.
+func (p *Patcher) EncodeJSON(w io.Writer) error {
+	return p.EncodeJSONIndent(w, "  ")
+}
+
+// EncodeJSONIndent writes the document as JSON with the given indentation, keys keep the order of the document.
+func (p *Patcher) EncodeJSONIndent(w io.Writer, indent string) error {
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, p.node); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", indent); err != nil {
+		return fmt.Errorf("indenting JSON: %w", err)
+	}
+	out.WriteByte('\n')
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func writeJSONNode(buf *bytes.Buffer, node *goyaml.Node) error {
+	switch node.Kind {
+	case goyaml.DocumentNode:
+		if len(node.Content) != 1 {
+			return fmt.Errorf("expected exactly one node in document, got %d", len(node.Content))
+		}
+		return writeJSONNode(buf, node.Content[0])
+	case goyaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case goyaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, node.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSONNode(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case goyaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, child := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONNode(buf, child); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case goyaml.ScalarNode:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return fmt.Errorf("decoding value (at %d:%d): %w", node.Line, node.Column, err)
+		}
+		if err := writeJSONValue(buf, value); err != nil {
+			return fmt.Errorf("encoding value (at %d:%d): %w", node.Line, node.Column, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected node of kind %s", kindToStr(node.Kind))
+	}
+}
+
+// writeJSONValue writes the value without escaping HTML characters.
+func writeJSONValue(buf *bytes.Buffer, value any) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	// Remove the newline written by Encode
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// DetectJSONIndent returns the indentation of the first indented line of a JSON document, so it can be kept when
+// the document is encoded again. It defaults to two spaces.
+func DetectJSONIndent(data []byte) string {
+	for _, line := range bytes.Split(data, []byte("\n"))[1:] {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) > 0 && len(trimmed) < len(line) {
+			return string(line[:len(line)-len(trimmed)])
+		}
+	}
+	return "  "
+}
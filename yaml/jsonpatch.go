@@ -0,0 +1,326 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// JSONPatchOperation is an operation of a JSON Patch (RFC 6902) document.
+type JSONPatchOperation struct {
+	// Op is one of "add", "remove", "replace", "move", "copy" or "test".
+	Op string
+	// Path is a JSON Pointer (RFC 6901) to the target location.
+	Path string
+	// From is a JSON Pointer to the source location for "move" and "copy".
+	From string
+	// Value for "add", "replace" and "test".
+	Value any
+}
+
+// ApplyJSONPatch applies the operations in order. Nodes that are not changed by an operation keep their comments and style.
+// If an operation fails, the document is left partially patched and must not be encoded.
+func (p *Patcher) ApplyJSONPatch(ops []JSONPatchOperation) error {
+	if p.node.Kind != goyaml.DocumentNode || len(p.node.Content) != 1 {
+		return errors.New("expected a single document")
+	}
+
+	for idx, op := range ops {
+		if err := p.applyJSONPatchOperation(op); err != nil {
+			return fmt.Errorf("operation %d (%s %s): %w", idx, op.Op, op.Path, err)
+		}
+	}
+	return nil
+}
+
+func (p *Patcher) applyJSONPatchOperation(op JSONPatchOperation) error {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	switch op.Op {
+	case "add":
+		valueNode, err := encodeValueNode(op.Value)
+		if err != nil {
+			return err
+		}
+		return p.addNode(path, valueNode)
+	case "remove":
+		_, err := p.removeNode(path)
+		return err
+	case "replace":
+		valueNode, err := encodeValueNode(op.Value)
+		if err != nil {
+			return err
+		}
+		if len(path) == 0 {
+			p.node.Content[0] = valueNode
+			return nil
+		}
+		target, err := p.resolveNode(path)
+		if err != nil {
+			return err
+		}
+		replaceNode(target, valueNode)
+		return nil
+	case "move":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+		if isPointerPrefix(from, path) && len(from) < len(path) {
+			return errors.New("cannot move a value into one of its children")
+		}
+		node, err := p.removeNode(from)
+		if err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		return p.addNode(path, node)
+	case "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+		node, err := p.resolveNode(from)
+		if err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		return p.addNode(path, copyNode(node))
+	case "test":
+		node, err := p.resolveNode(path)
+		if err != nil {
+			return err
+		}
+		var actual, expected any
+		if err := node.Decode(&actual); err != nil {
+			return fmt.Errorf("decoding value: %w", err)
+		}
+		expectedNode, err := encodeValueNode(op.Value)
+		if err != nil {
+			return err
+		}
+		if err := expectedNode.Decode(&expected); err != nil {
+			return fmt.Errorf("decoding expected value: %w", err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			return errors.New("test failed, value does not match")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// resolveNode returns the node at the pointer.
+func (p *Patcher) resolveNode(path []string) (*goyaml.Node, error) {
+	node := p.node.Content[0]
+	for i, token := range path {
+		child, err := childNode(node, token)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", formatJSONPointer(path[:i+1]), err)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// addNode adds the node at the pointer, an existing member of a mapping is replaced.
+func (p *Patcher) addNode(path []string, valueNode *goyaml.Node) error {
+	if len(path) == 0 {
+		p.node.Content[0] = valueNode
+		return nil
+	}
+	parent, err := p.resolveNode(path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	token := path[len(path)-1]
+
+	switch parent.Kind {
+	case goyaml.MappingNode:
+		for i := 0; i < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == token {
+				replaceNode(parent.Content[i+1], valueNode)
+				return nil
+			}
+		}
+		keyNode := &goyaml.Node{Kind: goyaml.ScalarNode, Tag: "!!str", Value: token}
+		parent.Content = append(parent.Content, keyNode, valueNode)
+		return nil
+	case goyaml.SequenceNode:
+		index := len(parent.Content)
+		if token != "-" {
+			index, err = parseArrayIndex(token, len(parent.Content))
+			if err != nil {
+				return fmt.Errorf("%s: %w", formatJSONPointer(path), err)
+			}
+		}
+		parent.Content = append(parent.Content[:index], append([]*goyaml.Node{valueNode}, parent.Content[index:]...)...)
+		return nil
+	default:
+		return fmt.Errorf("%s: parent is a %s", formatJSONPointer(path), kindToStr(parent.Kind))
+	}
+}
+
+// removeNode removes the node at the pointer and returns it.
+func (p *Patcher) removeNode(path []string) (*goyaml.Node, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	parent, err := p.resolveNode(path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch parent.Kind {
+	case goyaml.MappingNode:
+		for i := 0; i < len(parent.Content); i += 2 {
+			if parent.Content[i].Value == token {
+				node := parent.Content[i+1]
+				parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+				return node, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: key not found", formatJSONPointer(path))
+	case goyaml.SequenceNode:
+		index, err := parseArrayIndex(token, len(parent.Content)-1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", formatJSONPointer(path), err)
+		}
+		node := parent.Content[index]
+		parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%s: parent is a %s", formatJSONPointer(path), kindToStr(parent.Kind))
+	}
+}
+
+func childNode(node *goyaml.Node, token string) (*goyaml.Node, error) {
+	if node.Kind == goyaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case goyaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == token {
+				return node.Content[i+1], nil
+			}
+		}
+		return nil, errors.New("key not found")
+	case goyaml.SequenceNode:
+		index, err := parseArrayIndex(token, len(node.Content)-1)
+		if err != nil {
+			return nil, err
+		}
+		return node.Content[index], nil
+	default:
+		return nil, fmt.Errorf("cannot descend into %s", kindToStr(node.Kind))
+	}
+}
+
+// replaceNode replaces the value of target in place, so comments of the target are kept.
+func replaceNode(target, valueNode *goyaml.Node) {
+	headComment, lineComment, footComment := target.HeadComment, target.LineComment, target.FootComment
+	*target = *valueNode
+	if target.HeadComment == "" {
+		target.HeadComment = headComment
+	}
+	if target.LineComment == "" {
+		target.LineComment = lineComment
+	}
+	if target.FootComment == "" {
+		target.FootComment = footComment
+	}
+}
+
+func copyNode(node *goyaml.Node) *goyaml.Node {
+	c := *node
+	if node.Content != nil {
+		c.Content = make([]*goyaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			c.Content[i] = copyNode(child)
+		}
+	}
+	return &c
+}
+
+func encodeValueNode(value any) (*goyaml.Node, error) {
+	node := new(goyaml.Node)
+	if err := node.Encode(value); err != nil {
+		return nil, fmt.Errorf("encoding value: %w", err)
+	}
+	useBlockStyle(node)
+	return node, nil
+}
+
+// useBlockStyle removes the flow style of empty collections, so they are written in block style once items are added.
+func useBlockStyle(node *goyaml.Node) {
+	if node.Kind == goyaml.MappingNode || node.Kind == goyaml.SequenceNode {
+		node.Style &^= goyaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		useBlockStyle(child)
+	}
+}
+
+// parseArrayIndex parses an array index of a JSON Pointer that must not be greater than max.
+func parseArrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+// ValidateJSONPointer returns an error if pointer is not a valid JSON Pointer (RFC 6901).
+func ValidateJSONPointer(pointer string) error {
+	_, err := parseJSONPointer(pointer)
+	return err
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("pointer %q must start with '/'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func formatJSONPointer(path []string) string {
+	var sb strings.Builder
+	for _, token := range path {
+		sb.WriteString("/")
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+func isPointerPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package yaml
+
+import (
+	"fmt"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// MergeNode deep merges the map value into the mapping at the path (in JSONPath syntax, the document if empty).
+// Keys of nested maps are merged into existing mappings, other values (scalars and sequences) replace the existing
+// value. Existing keys keep their position and comments, new keys are appended. A missing mapping is created,
+// the path must be a dot separated path of keys then.
+func (p *Patcher) MergeNode(path string, value any) error {
+	valueNode := new(goyaml.Node)
+	if err := valueNode.Encode(value); err != nil {
+		return fmt.Errorf("encoding value: %w", err)
+	}
+	if valueNode.Kind != goyaml.MappingNode {
+		return fmt.Errorf("expected a map value, got %s", kindToStr(valueNode.Kind))
+	}
+
+	var node *goyaml.Node
+	if path == "" {
+		node = documentContent(p.node)
+	} else {
+		matchedNodes, anchored, err := p.findNodes(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(matchedNodes) > 1:
+			return fmt.Errorf("multiple nodes matched path")
+		case len(matchedNodes) == 1 && anchored != nil:
+			if !p.expandAliases {
+				return sharedFieldError(path, anchored)
+			}
+			keys, _ := splitMappingKeys(path)
+			expandPath(p.node, keys)
+			node, _ = resolvePath(p.node, keys)
+		case len(matchedNodes) == 1:
+			node = matchedNodes[0]
+		default:
+			pathKeys, err := splitPath(path)
+			if err != nil {
+				return fmt.Errorf("parsing path: %w", err)
+			}
+			node, err = recurseNodeByPath(p.node, pathKeys, true)
+			if err != nil {
+				return fmt.Errorf("creating path: %w", err)
+			}
+		}
+	}
+
+	// Empty values (e.g. "labels:") are replaced by a mapping
+	if node.Kind == goyaml.ScalarNode && node.ShortTag() == "!!null" {
+		node.Kind, node.Tag, node.Value = goyaml.MappingNode, "", ""
+	}
+	if node.Kind != goyaml.MappingNode {
+		return fmt.Errorf("expected mapping node, got %s (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+	}
+
+	// Merging into an anchored mapping (or a mapping of an anchored node) would also change its aliases
+	shared := sharedAnchors(p.node, node)
+	if len(shared) > 0 && !p.expandAliases {
+		return sharedFieldError(path, shared[0].node)
+	}
+	for _, anchor := range shared {
+		expandAnchor(p.node, anchor.node, anchor.aliases)
+	}
+
+	mergeMapping(node, valueNode)
+	return nil
+}
+
+// mergeMapping merges the keys of the source mapping into the mapping.
+func mergeMapping(node *goyaml.Node, source *goyaml.Node) {
+	// An empty flow mapping ("{}") becomes a block mapping
+	if len(node.Content) == 0 {
+		node.Style &^= goyaml.FlowStyle
+	}
+	for i := 0; i+1 < len(source.Content); i += 2 {
+		key, value := source.Content[i], source.Content[i+1]
+
+		var existing *goyaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if !isMergeKey(node.Content[j]) && node.Content[j].Value == key.Value {
+				existing = node.Content[j+1]
+				break
+			}
+		}
+
+		switch {
+		case existing == nil:
+			node.Content = append(node.Content, key, value)
+		case existing.Kind == goyaml.MappingNode && value.Kind == goyaml.MappingNode:
+			mergeMapping(existing, value)
+		case existing.Kind == goyaml.ScalarNode && value.Kind == goyaml.ScalarNode:
+			// The style of the existing value is kept, like for SetField
+			existing.Value = value.Value
+			existing.Tag = value.Tag
+		default:
+			value.HeadComment, value.LineComment, value.FootComment = existing.HeadComment, existing.LineComment, existing.FootComment
+			*existing = *value
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestPatcher_MergeNode(t *testing.T) {
+	input := `metadata:
+  name: app
+  labels: # set by CI
+    app: app
+    version: 0.1.0 # current version
+spec:
+  replicas: 1
+  hosts: [a.example.com]
+`
+	tests := []struct {
+		name         string
+		path         string
+		value        any
+		expectedYAML string
+		expectedErr  string
+	}{
+		{
+			name: "nested mapping",
+			path: "metadata",
+			value: map[string]any{
+				"labels": map[string]any{"version": "0.2.0", "tier": "web"},
+			},
+			expectedYAML: `metadata:
+  name: app
+  labels: # set by CI
+    app: app
+    version: 0.2.0 # current version
+    tier: web
+spec:
+  replicas: 1
+  hosts: [a.example.com]
+`,
+		},
+		{
+			name: "document",
+			path: "",
+			value: map[string]any{
+				"spec": map[string]any{"replicas": 2, "hosts": []string{"b.example.com"}},
+			},
+			expectedYAML: `metadata:
+  name: app
+  labels: # set by CI
+    app: app
+    version: 0.1.0 # current version
+spec:
+  replicas: 2
+  hosts:
+    - b.example.com
+`,
+		},
+		{
+			name:  "missing mapping",
+			path:  "metadata.annotations",
+			value: map[string]any{"managed-by": "vignet"},
+			expectedYAML: `metadata:
+  name: app
+  labels: # set by CI
+    app: app
+    version: 0.1.0 # current version
+  annotations:
+    managed-by: vignet
+spec:
+  replicas: 1
+  hosts: [a.example.com]
+`,
+		},
+		{
+			name:        "not a map",
+			path:        "metadata",
+			value:       "app",
+			expectedErr: "expected a map value, got ScalarNode",
+		},
+		{
+			name:        "not a mapping",
+			path:        "metadata.name",
+			value:       map[string]any{"first": "app"},
+			expectedErr: "expected mapping node, got ScalarNode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+
+			err = patcher.MergeNode(tt.path, tt.value)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
@@ -1,68 +1,132 @@
 package yaml
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"sort"
 
 	"github.com/vmware-labs/yaml-jsonpath/pkg/yamlpath"
 	goyaml "gopkg.in/yaml.v3"
 )
 
 type Patcher struct {
-	node *goyaml.Node
+	node          *goyaml.Node
+	style         style
+	expandAliases bool
 }
 
 func NewPatcher(r io.Reader) (*Patcher, error) {
-	dec := goyaml.NewDecoder(r)
+	// The source is kept to restore the line breaks of wrapped scalars on encode
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := goyaml.NewDecoder(bytes.NewReader(src))
 	var node goyaml.Node
 	if err := dec.Decode(&node); err != nil {
 		return nil, err
 	}
 
+	s := detectStyle(&node)
+	s.wrapped = wrappedScalars(src, &node)
 	return &Patcher{
-		node: &node,
+		node:  &node,
+		style: s,
 	}, nil
 }
 
+// SetField sets the scalar at the path (in JSONPath syntax) to the value. Missing keys are created if createKeys is set,
+// the path must be a dot separated path of keys then. Keys containing dots can be escaped (e.g. "app\.kubernetes\.io/name")
+// or given in brackets (e.g. "['app.kubernetes.io/name']").
 func (p *Patcher) SetField(path string, value any, createKeys bool) error {
-	parsedPath, err := yamlpath.NewPath(path)
+	matchedNodes, err := p.findSettableNodes(path)
 	if err != nil {
-		return fmt.Errorf("parsing path: %w", err)
+		return err
 	}
 
-	matchedNodes, err := parsedPath.Find(p.node)
-	if err != nil {
-		return fmt.Errorf("finding value node: %w", err)
+	var valueNode *goyaml.Node
+	switch {
+	case len(matchedNodes) > 1:
+		return errors.New("multiple nodes matched path")
+	case len(matchedNodes) == 1:
+		valueNode = matchedNodes[0]
+	case createKeys:
+		// Note: we do not support JSONPath expressions in the path if createKeys is executed!
+		pathKeys, err := splitPath(path)
+		if err != nil {
+			return fmt.Errorf("parsing path: %w", err)
+		}
+		valueNode, err = recurseNodeByPath(p.node, pathKeys, true)
+		if err != nil {
+			return fmt.Errorf("creating path: %w", err)
+		}
+	default:
+		return errors.New("no nodes matched path")
 	}
 
-	var valueNode *goyaml.Node
+	return p.setScalarNode(path, valueNode, value)
+}
 
+// SetFieldAll sets all scalars matching the path (e.g. "spec.containers[*].image") to the value and returns the number
+// of matched nodes. Keys are not created, so at least one node must match. Either all or no nodes are set.
+func (p *Patcher) SetFieldAll(path string, value any) (int, error) {
+	matchedNodes, err := p.findSettableNodes(path)
+	if err != nil {
+		return 0, err
+	}
 	if len(matchedNodes) == 0 {
-		if createKeys {
-			pathParts := strings.Split(path, ".")
-			// Note: we do not support JSONPath expressions in the path if createKeys is executed!
-			valueNode, err = recurseNodeByPath(p.node, pathParts, true)
-			if err != nil {
-				return fmt.Errorf("creating path: %w", err)
-			}
-		} else {
-			return errors.New("no nodes matched path")
+		return 0, errors.New("no nodes matched path")
+	}
+
+	for _, valueNode := range matchedNodes {
+		if err := p.checkScalarNode(path, valueNode); err != nil {
+			return 0, err
+		}
+	}
+	for _, valueNode := range matchedNodes {
+		if err := p.setScalarNode(path, valueNode, value); err != nil {
+			return 0, err
 		}
-	} else if len(matchedNodes) > 1 {
-		return errors.New("multiple nodes matched path")
-	} else {
-		valueNode = matchedNodes[0]
 	}
+	return len(matchedNodes), nil
+}
 
+// checkScalarNode returns an error if the node cannot be set by setScalarNode.
+func (p *Patcher) checkScalarNode(path string, valueNode *goyaml.Node) error {
+	if valueNode.Kind == goyaml.AliasNode && valueNode.Alias.Kind == goyaml.ScalarNode {
+		if !p.expandAliases {
+			return sharedFieldError(path, valueNode.Alias)
+		}
+		return nil
+	}
 	if valueNode.Kind != goyaml.ScalarNode {
 		return fmt.Errorf("expected scalar node, got %s (at %d:%d)", kindToStr(valueNode.Kind), valueNode.Line, valueNode.Column)
 	}
+	if shared := sharedAnchors(p.node, valueNode); len(shared) > 0 && !p.expandAliases {
+		return sharedFieldError(path, shared[0].node)
+	}
+	return nil
+}
+
+// setScalarNode sets the scalar node to the value, aliases and anchors are expanded if it is shared.
+func (p *Patcher) setScalarNode(path string, valueNode *goyaml.Node, value any) error {
+	if err := p.checkScalarNode(path, valueNode); err != nil {
+		return err
+	}
+	if valueNode.Kind == goyaml.AliasNode {
+		expandAlias(valueNode)
+	}
+
+	// Setting an anchored node (or a node of an anchored mapping or sequence) would also change its aliases
+	for _, anchor := range sharedAnchors(p.node, valueNode) {
+		expandAnchor(p.node, anchor.node, anchor.aliases)
+	}
 
 	newNode := new(goyaml.Node)
 	newNode.Kind = goyaml.ScalarNode
-	err = newNode.Encode(value)
+	err := newNode.Encode(value)
 	if err != nil {
 		return fmt.Errorf("encoding value: %w", err)
 	}
@@ -73,7 +137,306 @@ func (p *Patcher) SetField(path string, value any, createKeys bool) error {
 	return nil
 }
 
-func recurseNodeByPath(node *goyaml.Node, path []string, createKeys bool) (valueNode *goyaml.Node, err error) {
+// Field returns the decoded value of the node matching path (see GetField), or nil if no node matched.
+func (p *Patcher) Field(path string) (any, error) {
+	values, err := p.GetField(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	} else if len(values) > 1 {
+		return nil, errors.New("multiple nodes matched path")
+	}
+	return values[0], nil
+}
+
+// GetField returns the decoded values of all nodes matching path (e.g. the images of "spec.containers[*].image")
+// in document order. The result is empty if no node matched.
+func (p *Patcher) GetField(path string) ([]any, error) {
+	matchedNodes, _, err := p.findNodes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, len(matchedNodes))
+	for i, node := range matchedNodes {
+		err = node.Decode(&values[i])
+		if err != nil {
+			return nil, fmt.Errorf("decoding value: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// findNodes returns the nodes matching path. Since JSONPath does not follow aliases and merge keys, a path of mapping
+// keys is resolved following them if nothing matched, the anchored node of the first followed alias is returned then.
+func (p *Patcher) findNodes(path string) (matchedNodes []*goyaml.Node, anchored *goyaml.Node, err error) {
+	parsedPath, err := yamlpath.NewPath(jsonPath(path))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing path: %w", err)
+	}
+
+	matchedNodes, err = parsedPath.Find(p.node)
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding value node: %w", err)
+	}
+	if len(matchedNodes) > 0 {
+		return matchedNodes, nil, nil
+	}
+
+	keys, ok := splitMappingKeys(path)
+	if !ok {
+		return nil, nil, nil
+	}
+	resolved, anchored := resolvePath(p.node, keys)
+	if resolved == nil {
+		return nil, nil, nil
+	}
+	return []*goyaml.Node{resolved}, anchored, nil
+}
+
+// findSettableNodes returns the nodes matching path like findNodes for changing them. A node that was resolved
+// following an alias is shared with the anchored node, so the path is expanded (if aliases may be expanded) and the
+// node of the expanded path is returned instead.
+func (p *Patcher) findSettableNodes(path string) ([]*goyaml.Node, error) {
+	matchedNodes, anchored, err := p.findNodes(path)
+	if err != nil || anchored == nil {
+		return matchedNodes, err
+	}
+	if !p.expandAliases {
+		return nil, sharedFieldError(path, anchored)
+	}
+	// A path resolved following aliases is a path of mapping keys
+	keys, _ := splitMappingKeys(path)
+	expandPath(p.node, keys)
+	resolved, _ := resolvePath(p.node, keys)
+	return []*goyaml.Node{resolved}, nil
+}
+
+// AddToArray adds the value to the sequence matching path. The value is appended if index is nil,
+// otherwise it is inserted at the index (0 inserts at the start).
+func (p *Patcher) AddToArray(path string, value any, index *int) error {
+	matchedNodes, err := p.findSettableNodes(path)
+	if err != nil {
+		return err
+	}
+	if len(matchedNodes) == 0 {
+		return errors.New("no nodes matched path")
+	} else if len(matchedNodes) > 1 {
+		return errors.New("multiple nodes matched path")
+	}
+
+	seqNode := matchedNodes[0]
+	if seqNode.Kind != goyaml.SequenceNode {
+		return fmt.Errorf("expected sequence node, got %s (at %d:%d)", kindToStr(seqNode.Kind), seqNode.Line, seqNode.Column)
+	}
+
+	newNode := new(goyaml.Node)
+	err = newNode.Encode(value)
+	if err != nil {
+		return fmt.Errorf("encoding value: %w", err)
+	}
+
+	if index == nil {
+		seqNode.Content = append(seqNode.Content, newNode)
+		return nil
+	}
+	if *index < 0 || *index > len(seqNode.Content) {
+		return fmt.Errorf("index %d out of range, sequence has %d items (at %d:%d)", *index, len(seqNode.Content), seqNode.Line, seqNode.Column)
+	}
+	seqNode.Content = append(seqNode.Content[:*index], append([]*goyaml.Node{newNode}, seqNode.Content[*index:]...)...)
+	return nil
+}
+
+// DeleteField removes the key and value of the mapping (or the item of the sequence) matching path. Comments above
+// the removed entry and on its line are removed with it, comments below it are kept.
+func (p *Patcher) DeleteField(path string) error {
+	matchedNodes, err := p.findSettableNodes(path)
+	if err != nil {
+		return err
+	}
+	if len(matchedNodes) == 0 {
+		return errors.New("no nodes matched path")
+	} else if len(matchedNodes) > 1 {
+		return errors.New("multiple nodes matched path")
+	}
+
+	node := matchedNodes[0]
+	parent, index := findParent(p.node, node)
+	if parent == nil || parent.Kind == goyaml.DocumentNode {
+		return errors.New("cannot delete the root node")
+	}
+
+	// Deleting from an anchored node (or deleting an anchored node) would also change (or break) its aliases
+	shared := sharedAnchors(p.node, node)
+	if len(shared) > 0 && !p.expandAliases {
+		return sharedFieldError(path, shared[0].node)
+	}
+	for _, anchor := range shared {
+		expandAnchor(p.node, anchor.node, anchor.aliases)
+	}
+
+	start, end := index, index+1
+	footComment := node.FootComment
+	if parent.Kind == goyaml.MappingNode {
+		start = index - 1
+		footComment = joinComments(parent.Content[start].FootComment, footComment)
+	}
+	parent.Content = append(parent.Content[:start], parent.Content[end:]...)
+
+	// Comments below the removed entry are moved to the previous or next entry
+	if footComment == "" {
+		return nil
+	}
+	step := 1
+	if parent.Kind == goyaml.MappingNode {
+		step = 2
+	}
+	switch {
+	case start >= step:
+		prev := parent.Content[start-step]
+		prev.FootComment = joinComments(prev.FootComment, footComment)
+	case start < len(parent.Content):
+		next := parent.Content[start]
+		next.HeadComment = joinComments(footComment, next.HeadComment)
+	default:
+		parent.FootComment = joinComments(parent.FootComment, footComment)
+	}
+	return nil
+}
+
+// findParent returns the node containing the node and its index in the content of the parent.
+func findParent(root *goyaml.Node, node *goyaml.Node) (*goyaml.Node, int) {
+	for i, child := range root.Content {
+		if child == node {
+			return root, i
+		}
+		if parent, index := findParent(child, node); parent != nil {
+			return parent, index
+		}
+	}
+	return nil, 0
+}
+
+func joinComments(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "\n" + b
+}
+
+// EnsureFields converges the document to the values of the given fields (dot separated paths), missing keys are created.
+// If prune is set, keys under the prune path that are neither a field nor a parent of a field are removed.
+// It returns the sorted paths of changed fields and pruned keys.
+func (p *Patcher) EnsureFields(fields map[string]any, prune string) (changed []string, pruned []string, err error) {
+	paths := make([]string, 0, len(fields))
+	keysOfPath := make(map[string][]pathKey, len(fields))
+	for path := range fields {
+		keys, err := splitPath(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %q: %w", path, err)
+		}
+		paths = append(paths, path)
+		keysOfPath[path] = keys
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		newNode := new(goyaml.Node)
+		err = newNode.Encode(fields[path])
+		if err != nil {
+			return nil, nil, fmt.Errorf("encoding value of %q: %w", path, err)
+		}
+		if newNode.Kind != goyaml.ScalarNode {
+			return nil, nil, fmt.Errorf("value of %q must be a scalar", path)
+		}
+
+		valueNode, err := recurseNodeByPath(p.node, keysOfPath[path], true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ensuring %q: %w", path, err)
+		}
+		if valueNode.Value == newNode.Value && valueNode.ShortTag() == newNode.ShortTag() {
+			continue
+		}
+		valueNode.Value = newNode.Value
+		valueNode.Tag = newNode.Tag
+		changed = append(changed, path)
+	}
+
+	if prune != "" {
+		// Paths are compared in the form of joinPath, since keys with dots can be given in different forms
+		keep := make(map[string]bool)
+		isField := make(map[string]bool)
+		for _, path := range paths {
+			keys := keysOfPath[path]
+			for i := range keys {
+				keep[joinPath(keys[:i+1])] = true
+			}
+			isField[joinPath(keys)] = true
+		}
+
+		pruneKeys, ok := splitMappingKeys(prune)
+		if !ok {
+			return nil, nil, fmt.Errorf("prune path must be a dot separated path of keys")
+		}
+		pruneNode := lookupNode(p.node, pruneKeys)
+		if pruneNode != nil {
+			if pruneNode.Kind != goyaml.MappingNode {
+				return nil, nil, fmt.Errorf("prune path must be a mapping, got %s (at %d:%d)", kindToStr(pruneNode.Kind), pruneNode.Line, pruneNode.Column)
+			}
+			pruneMapping(pruneNode, prune, isField, keep, &pruned)
+			sort.Strings(pruned)
+		}
+	}
+
+	return changed, pruned, nil
+}
+
+// lookupNode returns the node at the path of mapping keys, or nil if it does not exist.
+func lookupNode(node *goyaml.Node, path []string) *goyaml.Node {
+	if node.Kind == goyaml.DocumentNode {
+		if len(node.Content) != 1 {
+			return nil
+		}
+		return lookupNode(node.Content[0], path)
+	}
+	if len(path) == 0 {
+		return node
+	}
+	if node.Kind != goyaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == path[0] {
+			return lookupNode(node.Content[i+1], path[1:])
+		}
+	}
+	return nil
+}
+
+// pruneMapping removes keys of the mapping node that are not kept and descends into parents of fields.
+func pruneMapping(node *goyaml.Node, prefix string, isField map[string]bool, keep map[string]bool, pruned *[]string) {
+	content := node.Content[:0]
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		path := prefix + "." + joinPath([]pathKey{{key: keyNode.Value}})
+		if !keep[path] {
+			*pruned = append(*pruned, path)
+			continue
+		}
+		if !isField[path] && valueNode.Kind == goyaml.MappingNode {
+			pruneMapping(valueNode, path, isField, keep, pruned)
+		}
+		content = append(content, keyNode, valueNode)
+	}
+	node.Content = content
+}
+
+func recurseNodeByPath(node *goyaml.Node, path []pathKey, createKeys bool) (valueNode *goyaml.Node, err error) {
 	if node.Kind == goyaml.DocumentNode {
 		return handleDocumentNode(node, path, createKeys)
 	}
@@ -82,24 +445,38 @@ func recurseNodeByPath(node *goyaml.Node, path []string, createKeys bool) (value
 		return handleScalarNode(node)
 	}
 
-	if node.Kind == goyaml.MappingNode {
+	// Empty values (e.g. "containers:") are replaced by a mapping or sequence
+	if createKeys && node.Kind == goyaml.ScalarNode && node.ShortTag() == "!!null" {
+		node.Kind, node.Tag, node.Value = newContainerNode(path[0]).Kind, "", ""
+	}
+
+	if node.Kind == goyaml.MappingNode && !path[0].isIndex {
 		return handleMappingNode(node, path, createKeys)
 	}
+	if node.Kind == goyaml.SequenceNode && path[0].isIndex {
+		return handleSequenceNode(node, path, createKeys)
+	}
 
-	return nil, fmt.Errorf("unexpected node of kind %s (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+	return nil, fmt.Errorf("unexpected node of kind %s for %s (at %d:%d)", kindToStr(node.Kind), path[0], node.Line, node.Column)
 }
 
-func handleDocumentNode(node *goyaml.Node, path []string, createKeys bool) (*goyaml.Node, error) {
+// newContainerNode returns an empty mapping for a key or an empty sequence for an index.
+func newContainerNode(key pathKey) *goyaml.Node {
+	if key.isIndex {
+		return &goyaml.Node{Kind: goyaml.SequenceNode}
+	}
+	return &goyaml.Node{Kind: goyaml.MappingNode}
+}
+
+func handleDocumentNode(node *goyaml.Node, path []pathKey, createKeys bool) (*goyaml.Node, error) {
 	if len(node.Content) != 1 {
 		return nil, fmt.Errorf("expected exactly one node in document, got %d (at %d:%d)", len(node.Content), node.Line, node.Column)
 	}
 
 	// Special case for empty documents
-	if createKeys && node.Content[0].Kind == goyaml.ScalarNode && node.Content[0].Tag == "!!null" {
-		// The document is empty, so we need to create a mapping node
-		node.Content[0] = &goyaml.Node{
-			Kind: goyaml.MappingNode,
-		}
+	if createKeys && len(path) > 0 && node.Content[0].Kind == goyaml.ScalarNode && node.Content[0].Tag == "!!null" {
+		// The document is empty, so we need to create a mapping or sequence node
+		node.Content[0] = newContainerNode(path[0])
 	}
 
 	return recurseNodeByPath(node.Content[0], path, createKeys)
@@ -113,10 +490,10 @@ func handleScalarNode(node *goyaml.Node) (*goyaml.Node, error) {
 	return node, nil
 }
 
-func handleMappingNode(node *goyaml.Node, path []string, createKeys bool) (*goyaml.Node, error) {
+func handleMappingNode(node *goyaml.Node, path []pathKey, createKeys bool) (*goyaml.Node, error) {
 	for i := 0; i < len(node.Content); i += 2 {
 		key := node.Content[i].Value
-		if key == path[0] {
+		if key == path[0].key {
 			return recurseNodeByPath(node.Content[i+1], path[1:], createKeys)
 		}
 	}
@@ -125,15 +502,13 @@ func handleMappingNode(node *goyaml.Node, path []string, createKeys bool) (*goya
 	if createKeys {
 		keyNode := &goyaml.Node{
 			Kind:  goyaml.ScalarNode,
-			Value: path[0],
+			Value: path[0].key,
 		}
-		// Create a mapping node if the path is longer than 1
+		// Create a mapping or sequence node if the path is longer than 1
 		if len(path) > 1 {
-			mappingNode := &goyaml.Node{
-				Kind: goyaml.MappingNode,
-			}
-			node.Content = append(node.Content, keyNode, mappingNode)
-			return recurseNodeByPath(mappingNode, path[1:], createKeys)
+			containerNode := newContainerNode(path[1])
+			node.Content = append(node.Content, keyNode, containerNode)
+			return recurseNodeByPath(containerNode, path[1:], createKeys)
 		}
 
 		// Otherwise, create a scalar node
@@ -144,7 +519,30 @@ func handleMappingNode(node *goyaml.Node, path []string, createKeys bool) (*goya
 		return scalarNode, nil
 	}
 
-	return node, fmt.Errorf("key %q not found (at %d:%d)", path[0], node.Line, node.Column)
+	return node, fmt.Errorf("key %q not found (at %d:%d)", path[0].key, node.Line, node.Column)
+}
+
+func handleSequenceNode(node *goyaml.Node, path []pathKey, createKeys bool) (*goyaml.Node, error) {
+	index := path[0].index
+	if index < len(node.Content) {
+		return recurseNodeByPath(node.Content[index], path[1:], createKeys)
+	}
+
+	// Items are only appended, so the sequence has no gaps
+	if createKeys && index == len(node.Content) {
+		itemNode := &goyaml.Node{Kind: goyaml.ScalarNode}
+		if len(path) > 1 {
+			itemNode = newContainerNode(path[1])
+		}
+		// An empty flow sequence ("[]") becomes a block sequence
+		if len(node.Content) == 0 {
+			node.Style &^= goyaml.FlowStyle
+		}
+		node.Content = append(node.Content, itemNode)
+		return recurseNodeByPath(itemNode, path[1:], createKeys)
+	}
+
+	return nil, fmt.Errorf("index %d out of range, sequence has %d items (at %d:%d)", index, len(node.Content), node.Line, node.Column)
 }
 
 func kindToStr(kind goyaml.Kind) string {
@@ -164,8 +562,12 @@ func kindToStr(kind goyaml.Kind) string {
 	}
 }
 
+// Node returns the document node, e.g. to process it before encoding.
+func (p *Patcher) Node() *goyaml.Node {
+	return p.node
+}
+
+// Encode writes the document with the indentation and sequence style of the input.
 func (p *Patcher) Encode(w io.Writer) error {
-	enc := goyaml.NewEncoder(w)
-	enc.SetIndent(2)
-	return enc.Encode(p.node)
+	return p.style.encode(w, p.node)
 }
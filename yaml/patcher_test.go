@@ -129,6 +129,59 @@ spec:
           image: test.example.com:0.1.0
 `,
 		},
+		{
+			name: "empty sequence and create keys",
+			inputYAML: `spec:
+  containers: []
+`,
+			fieldPath:  "spec.containers[0].image",
+			value:      "test.example.com:0.1.0",
+			createKeys: true,
+			expectedYAML: `spec:
+  containers:
+    - image: test.example.com:0.1.0
+`,
+		},
+		{
+			name: "append to sequence and create keys",
+			inputYAML: `spec:
+  containers:
+    - name: test
+  hosts:
+`,
+			fieldPath:  "spec.containers[1].name",
+			value:      "sidecar",
+			createKeys: true,
+			expectedYAML: `spec:
+  containers:
+    - name: test
+    - name: sidecar
+  hosts:
+`,
+		},
+		{
+			name: "missing sequence and create keys",
+			inputYAML: `spec:
+  hosts:
+`,
+			fieldPath:  "spec.hosts[0]",
+			value:      "example.com",
+			createKeys: true,
+			expectedYAML: `spec:
+  hosts:
+    - example.com
+`,
+		},
+		{
+			name: "index out of range and create keys",
+			inputYAML: `spec:
+  containers: []
+`,
+			fieldPath:  "spec.containers[1].image",
+			value:      "test.example.com:0.1.0",
+			createKeys: true,
+			expectErr:  true,
+		},
 		{
 			name: "yaml with filter by name",
 			inputYAML: `spec:
@@ -197,6 +250,93 @@ foo: bar
 			expectedYAML: `foo: true
 `,
 		},
+		{
+			name: "escaped dots and create keys",
+			inputYAML: `metadata:
+  annotations:
+    app.kubernetes.io/name: my-app
+`,
+			fieldPath:  `metadata.annotations.app\.kubernetes\.io/version`,
+			value:      "1.0.0",
+			createKeys: true,
+			expectedYAML: `metadata:
+  annotations:
+    app.kubernetes.io/name: my-app
+    app.kubernetes.io/version: 1.0.0
+`,
+		},
+		{
+			name: "existing key with escaped dots",
+			inputYAML: `metadata:
+  annotations:
+    app.kubernetes.io/name: my-app
+`,
+			fieldPath: `metadata.annotations.app\.kubernetes\.io/name`,
+			value:     "other-app",
+			expectedYAML: `metadata:
+  annotations:
+    app.kubernetes.io/name: other-app
+`,
+		},
+		{
+			name: "key in brackets and create keys",
+			inputYAML: `metadata:
+  name: my-app
+`,
+			fieldPath:  `metadata.labels['app.kubernetes.io/name']`,
+			value:      "my-app",
+			createKeys: true,
+			expectedYAML: `metadata:
+  name: my-app
+  labels:
+    app.kubernetes.io/name: my-app
+`,
+		},
+		{
+			name:       "unterminated key in brackets and create keys",
+			inputYAML:  `metadata: {}`,
+			fieldPath:  `metadata.labels['app.kubernetes.io/name`,
+			value:      "my-app",
+			createKeys: true,
+			expectErr:  true,
+		},
+		{
+			name: "json pointer",
+			inputYAML: `spec:
+  containers:
+    - name: app
+      image: app:0.1.0
+`,
+			fieldPath: "/spec/containers/0/image",
+			value:     "app:0.2.0",
+			expectedYAML: `spec:
+  containers:
+    - name: app
+      image: app:0.2.0
+`,
+		},
+		{
+			name: "json pointer with escaped slash and create keys",
+			inputYAML: `metadata:
+  name: my-app
+`,
+			fieldPath:  "/metadata/labels/app.kubernetes.io~1name",
+			value:      "my-app",
+			createKeys: true,
+			expectedYAML: `metadata:
+  name: my-app
+  labels:
+    app.kubernetes.io/name: my-app
+`,
+		},
+		{
+			name:       "json pointer with end of array token",
+			inputYAML:  `hosts: []`,
+			fieldPath:  "/hosts/-",
+			value:      "example.com",
+			createKeys: true,
+			expectErr:  true,
+		},
 		{
 			name: "setting string to int",
 			inputYAML: `
@@ -240,3 +380,677 @@ foo: bar
 		})
 	}
 }
+
+func TestPatcher_Field(t *testing.T) {
+	patcher, err := yaml.NewPatcher(strings.NewReader(`
+spec:
+  image:
+    tag: 0.1.0
+  replicas: 3
+`))
+	require.NoError(t, err)
+
+	value, err := patcher.Field("spec.image.tag")
+	require.NoError(t, err)
+	assert.Equal(t, "0.1.0", value)
+
+	value, err = patcher.Field("$.spec.replicas")
+	require.NoError(t, err)
+	assert.Equal(t, 3, value)
+
+	value, err = patcher.Field("spec.missing")
+	require.NoError(t, err)
+	assert.Nil(t, value)
+
+	value, err = patcher.Field("/spec/image/tag")
+	require.NoError(t, err)
+	assert.Equal(t, "0.1.0", value)
+}
+
+func TestPatcher_GetField(t *testing.T) {
+	patcher, err := yaml.NewPatcher(strings.NewReader(`
+defaults: &defaults
+  replicas: 1
+spec:
+  <<: *defaults
+  containers:
+    - name: app
+      image: app:0.1.0
+    - name: sidecar
+      image: sidecar:0.1.0
+`))
+	require.NoError(t, err)
+
+	values, err := patcher.GetField("spec.containers[*].image")
+	require.NoError(t, err)
+	assert.Equal(t, []any{"app:0.1.0", "sidecar:0.1.0"}, values)
+
+	values, err = patcher.GetField("spec.replicas")
+	require.NoError(t, err)
+	assert.Equal(t, []any{1}, values)
+
+	values, err = patcher.GetField("spec.missing")
+	require.NoError(t, err)
+	assert.Empty(t, values)
+}
+
+func TestPatcher_SetFieldAll(t *testing.T) {
+	input := `spec:
+  containers:
+    - name: app
+      image: app:0.1.0
+    - name: sidecar
+      image: sidecar:0.1.0
+      resources: {}
+`
+	tests := []struct {
+		name            string
+		fieldPath       string
+		expectedMatches int
+		expectedYAML    string
+		expectedErr     string
+	}{
+		{
+			name:            "multiple matches",
+			fieldPath:       "spec.containers[*].image",
+			expectedMatches: 2,
+			expectedYAML: `spec:
+  containers:
+    - name: app
+      image: app:0.2.0
+    - name: sidecar
+      image: app:0.2.0
+      resources: {}
+`,
+		},
+		{
+			name:            "single match",
+			fieldPath:       "spec.containers[0].image",
+			expectedMatches: 1,
+			expectedYAML: `spec:
+  containers:
+    - name: app
+      image: app:0.2.0
+    - name: sidecar
+      image: sidecar:0.1.0
+      resources: {}
+`,
+		},
+		{
+			name:        "no match",
+			fieldPath:   "spec.initContainers[*].image",
+			expectedErr: "no nodes matched path",
+		},
+		{
+			name:        "non-scalar match",
+			fieldPath:   "spec.containers[*].resources",
+			expectedErr: "expected scalar node, got MappingNode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+
+			matches, err := patcher.SetFieldAll(tt.fieldPath, "app:0.2.0")
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedMatches, matches)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_DeleteField(t *testing.T) {
+	input := `# head of document
+spec:
+  # about the image
+  image:
+    repository: app
+    tag: 0.1.0 # set by CI
+    # foot of image
+  replicas: 2
+  hosts:
+    - a.example.com
+    - b.example.com # secondary
+`
+	tests := []struct {
+		name         string
+		fieldPath    string
+		expectedYAML string
+		expectedErr  string
+	}{
+		{
+			name:      "scalar",
+			fieldPath: "spec.image.tag",
+			expectedYAML: `# head of document
+spec:
+  # about the image
+  image:
+    repository: app
+    # foot of image
+  replicas: 2
+  hosts:
+    - a.example.com
+    - b.example.com # secondary
+`,
+		},
+		{
+			name:      "mapping",
+			fieldPath: "spec.image",
+			expectedYAML: `# head of document
+spec:
+  replicas: 2
+  hosts:
+    - a.example.com
+    - b.example.com # secondary
+`,
+		},
+		{
+			name:      "sequence item",
+			fieldPath: "spec.hosts[1]",
+			expectedYAML: `# head of document
+spec:
+  # about the image
+  image:
+    repository: app
+    tag: 0.1.0 # set by CI
+    # foot of image
+  replicas: 2
+  hosts:
+    - a.example.com
+`,
+		},
+		{
+			name:      "sequence item by value",
+			fieldPath: "spec.hosts[?(@ == 'a.example.com')]",
+			expectedYAML: `# head of document
+spec:
+  # about the image
+  image:
+    repository: app
+    tag: 0.1.0 # set by CI
+    # foot of image
+  replicas: 2
+  hosts:
+    - b.example.com # secondary
+`,
+		},
+		{
+			name:        "missing field",
+			fieldPath:   "spec.missing",
+			expectedErr: "no nodes matched path",
+		},
+		{
+			name:        "multiple matches",
+			fieldPath:   "spec.hosts[*]",
+			expectedErr: "multiple nodes matched path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(input))
+			require.NoError(t, err)
+
+			err = patcher.DeleteField(tt.fieldPath)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_EnsureFields(t *testing.T) {
+	tests := []struct {
+		name            string
+		inputYAML       string
+		fields          map[string]any
+		prune           string
+		expectedYAML    string
+		expectedChanged []string
+		expectedPruned  []string
+		expectErr       bool
+	}{
+		{
+			name: "update and create fields",
+			inputYAML: `spec:
+  image:
+    tag: 0.1.0 # keep comment
+  replicas: 2
+`,
+			fields: map[string]any{
+				"spec.image.tag":       "0.2.0",
+				"spec.replicas":        float64(2),
+				"spec.resources.limit": "1Gi",
+			},
+			expectedYAML: `spec:
+  image:
+    tag: 0.2.0 # keep comment
+  replicas: 2
+  resources:
+    limit: 1Gi
+`,
+			expectedChanged: []string{"spec.image.tag", "spec.resources.limit"},
+		},
+		{
+			name: "already converged",
+			inputYAML: `spec:
+  enabled: true
+  name: "foo"
+`,
+			fields: map[string]any{
+				"spec.enabled": true,
+				"spec.name":    "foo",
+			},
+			expectedYAML: `spec:
+  enabled: true
+  name: "foo"
+`,
+		},
+		{
+			name: "changed type",
+			inputYAML: `version: 1
+`,
+			fields: map[string]any{
+				"version": "1",
+			},
+			expectedYAML: `version: "1"
+`,
+			expectedChanged: []string{"version"},
+		},
+		{
+			name: "prune extra keys under subtree",
+			inputYAML: `foo: bar
+spec:
+  env:
+    A: "1"
+    B: "2"
+    nested:
+      C: "3"
+      D: "4"
+`,
+			fields: map[string]any{
+				"spec.env.A":        "1",
+				"spec.env.nested.C": "3",
+			},
+			prune: "spec.env",
+			expectedYAML: `foo: bar
+spec:
+  env:
+    A: "1"
+    nested:
+      C: "3"
+`,
+			expectedPruned: []string{"spec.env.B", "spec.env.nested.D"},
+		},
+		{
+			name: "prune missing subtree",
+			inputYAML: `foo: bar
+`,
+			fields: map[string]any{
+				"foo": "bar",
+			},
+			prune: "spec.env",
+			expectedYAML: `foo: bar
+`,
+		},
+		{
+			name: "prune scalar",
+			inputYAML: `foo: bar
+`,
+			fields: map[string]any{
+				"foo": "bar",
+			},
+			prune:     "foo",
+			expectErr: true,
+		},
+		{
+			name: "non-scalar value",
+			inputYAML: `foo: bar
+`,
+			fields: map[string]any{
+				"foo": map[string]any{"bar": "baz"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(tt.inputYAML))
+			require.NoError(t, err)
+
+			changed, pruned, err := patcher.EnsureFields(tt.fields, tt.prune)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedChanged, changed)
+			assert.Equal(t, tt.expectedPruned, pruned)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_AddToArray(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		name         string
+		inputYAML    string
+		path         string
+		value        any
+		index        *int
+		expectedYAML string
+		expectErr    bool
+	}{
+		{
+			name: "append object",
+			inputYAML: `spec:
+  rules:
+    # the main host
+    - host: a.example.com
+`,
+			path:  "spec.rules",
+			value: map[string]any{"host": "b.example.com", "http": map[string]any{"paths": []any{"/"}}},
+			expectedYAML: `spec:
+  rules:
+    # the main host
+    - host: a.example.com
+    - host: b.example.com
+      http:
+        paths:
+          - /
+`,
+		},
+		{
+			name: "insert scalar at index",
+			inputYAML: `args:
+  - --verbose
+  - --port=80
+`,
+			path:  "args",
+			value: "--config=/etc/app.yml",
+			index: intPtr(1),
+			expectedYAML: `args:
+  - --verbose
+  - --config=/etc/app.yml
+  - --port=80
+`,
+		},
+		{
+			name: "insert at start with JSONPath",
+			inputYAML: `containers:
+  - name: app
+    ports:
+      - 80
+`,
+			path:  "$.containers[?(@.name=='app')].ports",
+			value: float64(443),
+			index: intPtr(0),
+			expectedYAML: `containers:
+  - name: app
+    ports:
+      - 443
+      - 80
+`,
+		},
+		{
+			name: "append to empty flow sequence",
+			inputYAML: `hosts: []
+`,
+			path:  "hosts",
+			value: "a.example.com",
+			expectedYAML: `hosts: [a.example.com]
+`,
+		},
+		{
+			name: "index out of range",
+			inputYAML: `args:
+  - --verbose
+`,
+			path:      "args",
+			value:     "--port=80",
+			index:     intPtr(2),
+			expectErr: true,
+		},
+		{
+			name: "not a sequence",
+			inputYAML: `spec:
+  replicas: 1
+`,
+			path:      "spec",
+			value:     "foo",
+			expectErr: true,
+		},
+		{
+			name: "missing path",
+			inputYAML: `spec:
+  replicas: 1
+`,
+			path:      "spec.rules",
+			value:     "foo",
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(tt.inputYAML))
+			require.NoError(t, err)
+
+			err = patcher.AddToArray(tt.path, tt.value, tt.index)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			err = patcher.Encode(&sb)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_ApplyJSONPatch(t *testing.T) {
+	inputYAML := `metadata:
+  # name of the app
+  name: app
+  labels:
+    tier: web
+spec:
+  replicas: 1 # scaled by HPA
+  ports:
+    - 80
+    - 443
+`
+
+	tests := []struct {
+		name         string
+		ops          []yaml.JSONPatchOperation
+		expectedYAML string
+		expectErr    bool
+	}{
+		{
+			name: "add, replace and remove",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "add", Path: "/metadata/labels/app.kubernetes.io~1name", Value: "app"},
+				{Op: "replace", Path: "/spec/replicas", Value: float64(3)},
+				{Op: "add", Path: "/spec/ports/1", Value: float64(8080)},
+				{Op: "add", Path: "/spec/ports/-", Value: float64(9090)},
+				{Op: "remove", Path: "/metadata/labels/tier"},
+			},
+			expectedYAML: `metadata:
+  # name of the app
+  name: app
+  labels:
+    app.kubernetes.io/name: app
+spec:
+  replicas: 3 # scaled by HPA
+  ports:
+    - 80
+    - 8080
+    - 443
+    - 9090
+`,
+		},
+		{
+			name: "move and copy",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "copy", From: "/metadata/name", Path: "/metadata/labels/app"},
+				{Op: "move", From: "/spec/ports", Path: "/ports"},
+			},
+			expectedYAML: `metadata:
+  # name of the app
+  name: app
+  labels:
+    tier: web
+    app: app
+spec:
+  replicas: 1 # scaled by HPA
+ports:
+  - 80
+  - 443
+`,
+		},
+		{
+			name: "successful test",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "test", Path: "/spec/ports", Value: []any{float64(80), float64(443)}},
+				{Op: "replace", Path: "/metadata/name", Value: "other"},
+			},
+			expectedYAML: `metadata:
+  # name of the app
+  name: other
+  labels:
+    tier: web
+spec:
+  replicas: 1 # scaled by HPA
+  ports:
+    - 80
+    - 443
+`,
+		},
+		{
+			name: "failed test",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "test", Path: "/spec/replicas", Value: float64(2)},
+			},
+			expectErr: true,
+		},
+		{
+			name: "replace missing key",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "replace", Path: "/spec/missing", Value: "foo"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "remove index out of range",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "remove", Path: "/spec/ports/2"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "move into child",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "move", From: "/spec", Path: "/spec/nested"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "unknown operation",
+			ops: []yaml.JSONPatchOperation{
+				{Op: "merge", Path: "/spec"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(inputYAML))
+			require.NoError(t, err)
+
+			err = patcher.ApplyJSONPatch(tt.ops)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			err = patcher.Encode(&sb)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestPatcher_EncodeJSON(t *testing.T) {
+	patcher, err := yaml.NewPatcher(strings.NewReader(`{
+  "Logging": {"LogLevel": {"Default": "Information"}},
+  "AllowedHosts": "*",
+  "Ports": [80, 443],
+  "Url": "https://example.com/?a=1&b=<2>",
+  "Enabled": true,
+  "Ratio": 0.5,
+  "Empty": null
+}`))
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	err = patcher.EncodeJSON(&sb)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "Logging": {
+    "LogLevel": {
+      "Default": "Information"
+    }
+  },
+  "AllowedHosts": "*",
+  "Ports": [
+    80,
+    443
+  ],
+  "Url": "https://example.com/?a=1&b=<2>",
+  "Enabled": true,
+  "Ratio": 0.5,
+  "Empty": null
+}
+`, sb.String())
+}
+
+func TestDetectJSONIndent(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{name: "four spaces", data: "{\n    \"a\": {\n        \"b\": 1\n    }\n}\n", expected: "    "},
+		{name: "tab", data: "{\n\t\"a\": 1\n}\n", expected: "\t"},
+		{name: "single line", data: `{"a": 1}`, expected: "  "},
+		{name: "empty lines", data: "{\n\n  \"a\": 1\n}", expected: "  "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, yaml.DetectJSONIndent([]byte(tt.data)))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pathKey is a key of a mapping or the index of a sequence item of a path.
+type pathKey struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (k pathKey) String() string {
+	if k.isIndex {
+		return "[" + strconv.Itoa(k.index) + "]"
+	}
+	return k.key
+}
+
+// splitPath splits a dot separated path into keys. Keys containing dots can be given with escaped dots
+// (e.g. "metadata.labels.app\.kubernetes\.io/name") or in brackets (e.g. "metadata.labels['app.kubernetes.io/name']").
+// Items of sequences are given by their index in brackets (e.g. "spec.containers[0].image").
+// A path starting with "/" is a JSON Pointer (e.g. "/spec/containers/0/image"), see IsJSONPointer.
+func splitPath(path string) ([]pathKey, error) {
+	if IsJSONPointer(path) {
+		return splitJSONPointer(path)
+	}
+
+	var (
+		keys []pathKey
+		key  strings.Builder
+		// closed is set after a key in brackets, which must be followed by a dot, another key in brackets or the end
+		closed bool
+	)
+	flush := func() {
+		keys = append(keys, pathKey{key: key.String()})
+		key.Reset()
+	}
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '.':
+			if !closed {
+				flush()
+			}
+			closed = false
+		case c == '[' && i+1 < len(path) && (path[i+1] == '\'' || path[i+1] == '"'):
+			// A key in brackets can directly follow a key (e.g. "labels['app.kubernetes.io/name']")
+			if key.Len() > 0 {
+				flush()
+			}
+			quote := path[i+1]
+			end := strings.IndexByte(path[i+2:], quote)
+			if end == -1 || i+2+end+1 >= len(path) || path[i+2+end+1] != ']' {
+				return nil, fmt.Errorf("unterminated key in brackets at %d", i)
+			}
+			keys = append(keys, pathKey{key: path[i+2 : i+2+end]})
+			i += 2 + end + 1
+			closed = true
+		case c == '[' && i+1 < len(path) && path[i+1] >= '0' && path[i+1] <= '9':
+			if key.Len() > 0 {
+				flush()
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated index at %d", i)
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index at %d: %w", i, err)
+			}
+			keys = append(keys, pathKey{index: index, isIndex: true})
+			i += end
+			closed = true
+		case closed:
+			return nil, fmt.Errorf("expected '.' after brackets at %d", i)
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		default:
+			key.WriteByte(c)
+		}
+	}
+	if !closed {
+		flush()
+	}
+	for _, k := range keys {
+		if !k.isIndex && k.key == "" {
+			return nil, fmt.Errorf("empty key in path %q", path)
+		}
+	}
+	return keys, nil
+}
+
+// IsJSONPointer returns true if the path is a JSON Pointer (RFC 6901) instead of a dot separated path or JSONPath,
+// which cannot start with "/".
+func IsJSONPointer(path string) bool {
+	return strings.HasPrefix(path, "/")
+}
+
+// splitJSONPointer splits a JSON Pointer into keys, tokens that are array indexes ("0" or digits without leading zero)
+// are items of sequences.
+func splitJSONPointer(pointer string) ([]pathKey, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]pathKey, len(tokens))
+	for i, token := range tokens {
+		if token == "-" {
+			return nil, fmt.Errorf("token '-' of pointer %q is not supported, use the index of the item", pointer)
+		}
+		if token != "" && (token == "0" || token[0] != '0') && strings.Trim(token, "0123456789") == "" {
+			index, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q: %w", token, err)
+			}
+			keys[i] = pathKey{index: index, isIndex: true}
+			continue
+		}
+		keys[i] = pathKey{key: token}
+	}
+	return keys, nil
+}
+
+// splitMappingKeys splits a path that only consists of keys of mappings, it returns false otherwise.
+func splitMappingKeys(path string) ([]string, bool) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return nil, false
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		if k.isIndex {
+			return nil, false
+		}
+		names[i] = k.key
+	}
+	return names, true
+}
+
+// joinPath joins keys to a dot separated path, dots in keys are escaped.
+func joinPath(keys []pathKey) string {
+	var sb strings.Builder
+	for i, k := range keys {
+		if k.isIndex {
+			sb.WriteString(k.String())
+			continue
+		}
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(strings.NewReplacer(`\`, `\\`, ".", `\.`).Replace(k.key))
+	}
+	return sb.String()
+}
+
+// jsonPath returns the path for JSONPath matching, keys with escaped dots and JSON Pointers are converted to keys
+// in brackets, since JSONPath does not support escaping.
+func jsonPath(path string) string {
+	if !strings.Contains(path, `\`) && !IsJSONPointer(path) {
+		return path
+	}
+	keys, err := splitPath(path)
+	if err != nil {
+		return path
+	}
+	var sb strings.Builder
+	for i, k := range keys {
+		if k.isIndex {
+			sb.WriteString(k.String())
+			continue
+		}
+		if !isPlainKey(k.key) {
+			quote := "'"
+			if strings.Contains(k.key, "'") {
+				quote = `"`
+			}
+			sb.WriteString("[" + quote + k.key + quote + "]")
+			continue
+		}
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(k.key)
+	}
+	return sb.String()
+}
+
+// isPlainKey returns true if the key can be given without brackets in JSONPath.
+func isPlainKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '/' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+const defaultIndent = 2
+
+// style is the layout of a YAML document that is reproduced on encode.
+type style struct {
+	indent int
+	// indentlessSequences is set if sequences in mappings are not indented (e.g. "containers:\n- name: app")
+	indentlessSequences bool
+	// lineWidth to fold long scalars at, see Patcher.SetLineWidth
+	lineWidth int
+	// wrapped are the scalars that are wrapped in the source
+	wrapped map[*goyaml.Node]wrappedScalar
+}
+
+// detectStyle detects the indentation of nested mappings and the style of sequences in mappings from the positions
+// of the decoded nodes. The first nested mapping and sequence decide, defaults are used if there are none.
+func detectStyle(nodes ...*goyaml.Node) style {
+	var (
+		indent, seqIndent int
+		seqFound          bool
+		s                 style
+	)
+
+	var walk func(node *goyaml.Node)
+	walk = func(node *goyaml.Node) {
+		if node.Kind == goyaml.MappingNode && node.Style&goyaml.FlowStyle == 0 {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if value.Line <= key.Line || value.Style&goyaml.FlowStyle != 0 || len(value.Content) == 0 {
+					continue
+				}
+				switch value.Kind {
+				case goyaml.MappingNode:
+					if indent == 0 && value.Column > key.Column {
+						indent = value.Column - key.Column
+					}
+				case goyaml.SequenceNode:
+					if !seqFound {
+						seqFound = true
+						s.indentlessSequences = value.Column == key.Column
+						seqIndent = value.Column - key.Column
+					}
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	s.indent = indent
+	if s.indent == 0 {
+		s.indent = seqIndent
+	}
+	// The encoder only supports indentations from 2 to 9 spaces
+	if s.indent < 2 || s.indent > 9 {
+		s.indent = defaultIndent
+	}
+	return s
+}
+
+// encode writes the nodes as a stream of documents in the style.
+func (s style) encode(w io.Writer, nodes ...*goyaml.Node) error {
+	restore := untagMergeKeys(nodes...)
+	defer restore()
+
+	var buf bytes.Buffer
+	enc := goyaml.NewEncoder(&buf)
+	enc.SetIndent(s.indent)
+	for _, node := range nodes {
+		if err := enc.Encode(node); err != nil {
+			return err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	out := buf.Bytes()
+	if s.indentlessSequences {
+		var err error
+		out, err = unindentSequences(out)
+		if err != nil {
+			return fmt.Errorf("unindenting sequences: %w", err)
+		}
+	}
+	out, err := s.wrapScalars(out, nodes...)
+	if err != nil {
+		return fmt.Errorf("wrapping scalars: %w", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// unindentSequences moves block sequences in mappings of encoded YAML to the column of their key, since the encoder
+// always indents them. Lines of a sequence are all following lines indented at least to the column of the sequence.
+func unindentSequences(data []byte) ([]byte, error) {
+	type sequence struct {
+		line, column, offset int
+	}
+	var sequences []sequence
+
+	var walk func(node *goyaml.Node)
+	walk = func(node *goyaml.Node) {
+		if node.Kind == goyaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if value.Kind == goyaml.SequenceNode && value.Style&goyaml.FlowStyle == 0 && len(value.Content) > 0 && value.Column > key.Column {
+					sequences = append(sequences, sequence{line: value.Line, column: value.Column, offset: value.Column - key.Column})
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	dec := goyaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node goyaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		walk(&node)
+	}
+	if len(sequences) == 0 {
+		return data, nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	dedent := make([]int, len(lines))
+	for _, seq := range sequences {
+		for i := seq.line - 1; i < len(lines); i++ {
+			text := strings.TrimLeft(lines[i], " ")
+			if text == "" {
+				continue
+			}
+			// Columns are 1-based
+			if i > seq.line-1 && len(lines[i])-len(text) < seq.column-1 {
+				break
+			}
+			dedent[i] += seq.offset
+		}
+	}
+	for i, n := range dedent {
+		if n > 0 {
+			lines[i] = lines[i][n:]
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// ScalarStyle is the style of a scalar value, e.g. to quote a string that would be written without quotes.
+type ScalarStyle string
+
+const (
+	PlainStyle        ScalarStyle = "plain"
+	SingleQuotedStyle ScalarStyle = "single"
+	DoubleQuotedStyle ScalarStyle = "double"
+	LiteralStyle      ScalarStyle = "literal"
+	FoldedStyle       ScalarStyle = "folded"
+)
+
+// Valid returns an error if the style is unknown.
+func (s ScalarStyle) Valid() error {
+	switch s {
+	case PlainStyle, SingleQuotedStyle, DoubleQuotedStyle, LiteralStyle, FoldedStyle:
+		return nil
+	}
+	return fmt.Errorf("unknown style %q, expected one of plain, single, double, literal or folded", string(s))
+}
+
+func (s ScalarStyle) nodeStyle() goyaml.Style {
+	switch s {
+	case SingleQuotedStyle:
+		return goyaml.SingleQuotedStyle
+	case DoubleQuotedStyle:
+		return goyaml.DoubleQuotedStyle
+	case LiteralStyle:
+		return goyaml.LiteralStyle
+	case FoldedStyle:
+		return goyaml.FoldedStyle
+	default:
+		return 0
+	}
+}
+
+// SetFieldStyle sets the style of the scalar value at the path, e.g. after setting it with SetField.
+// Only strings can be quoted or written as block scalars, since other values would become strings.
+// Plain strings that would be read as another type (e.g. "true") are still quoted by the encoder.
+func (p *Patcher) SetFieldStyle(path string, style ScalarStyle) error {
+	if err := style.Valid(); err != nil {
+		return err
+	}
+
+	matchedNodes, anchored, err := p.findNodes(path)
+	if err != nil {
+		return err
+	}
+	// The style of a field shared by an anchor would also change its aliases
+	if len(matchedNodes) == 0 || anchored != nil {
+		return errors.New("no nodes matched path")
+	} else if len(matchedNodes) > 1 {
+		return errors.New("multiple nodes matched path")
+	}
+
+	node := matchedNodes[0]
+	if node.Kind != goyaml.ScalarNode {
+		return fmt.Errorf("expected scalar node, got %s (at %d:%d)", kindToStr(node.Kind), node.Line, node.Column)
+	}
+	if style != PlainStyle && node.ShortTag() != "!!str" {
+		return fmt.Errorf("style %s is only supported for strings, got %s", style, node.ShortTag())
+	}
+	node.Style = style.nodeStyle()
+	return nil
+}
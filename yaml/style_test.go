@@ -0,0 +1,137 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+func TestPatcher_EncodeKeepsStyle(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputYAML string
+	}{
+		{
+			name: "four spaces",
+			inputYAML: `spec:
+    image:
+        tag: 0.1.0
+    hosts:
+        - example.com
+`,
+		},
+		{
+			name: "indentless sequences",
+			inputYAML: `spec:
+  image:
+    tag: 0.1.0
+  containers:
+  - name: app
+    args:
+    - --verbose
+    - --port=8080
+    env:
+    - name: MODE
+      value: |
+        multi
+          line
+  - name: sidecar
+# comment before key
+other:
+- a
+`,
+		},
+		{
+			name: "four spaces with indentless sequences",
+			inputYAML: `spec:
+    image:
+        tag: 0.1.0
+    hosts:
+    - example.com
+`,
+		},
+		{
+			name: "two spaces with indented sequences",
+			inputYAML: `spec:
+  image:
+    tag: 0.1.0
+  hosts:
+    - example.com
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(tt.inputYAML))
+			require.NoError(t, err)
+
+			require.NoError(t, patcher.SetField("spec.image.tag", "0.2.0", false))
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, strings.Replace(tt.inputYAML, "0.1.0", "0.2.0", 1), sb.String())
+		})
+	}
+}
+
+func TestDocuments_EncodeKeepsStyle(t *testing.T) {
+	input := `kind: Service
+spec:
+    ports:
+    - port: 80
+---
+kind: Deployment
+spec:
+    template:
+        spec:
+            containers:
+            - name: app
+`
+	docs, err := yaml.NewDocuments(strings.NewReader(input))
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	require.NoError(t, docs.Encode(&sb))
+	assert.Equal(t, input, sb.String())
+}
+
+func TestPatcher_SetFieldStyle(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        any
+		style        yaml.ScalarStyle
+		expectedYAML string
+		expectedErr  string
+	}{
+		{name: "plain", value: "1.20", style: yaml.PlainStyle, expectedYAML: "version: \"1.20\"\n"},
+		{name: "single", value: "1.20", style: yaml.SingleQuotedStyle, expectedYAML: "version: '1.20'\n"},
+		{name: "double", value: "true", style: yaml.DoubleQuotedStyle, expectedYAML: "version: \"true\"\n"},
+		{name: "literal", value: "line 1\nline 2\n", style: yaml.LiteralStyle, expectedYAML: "version: |\n  line 1\n  line 2\n"},
+		{name: "folded", value: "text", style: yaml.FoldedStyle, expectedYAML: "version: >-\n  text\n"},
+		{name: "plain number", value: 1, style: yaml.PlainStyle, expectedYAML: "version: 1\n"},
+		{name: "quoted number", value: 1, style: yaml.DoubleQuotedStyle, expectedErr: "style double is only supported for strings, got !!int"},
+		{name: "unknown style", value: "1", style: "fancy", expectedErr: `unknown style "fancy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader("version: 1.0\n"))
+			require.NoError(t, err)
+			require.NoError(t, patcher.SetField("version", tt.value, false))
+
+			err = patcher.SetFieldStyle("version", tt.style)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// PreserveLineWidth keeps the line breaks of unchanged scalars that are wrapped in the source, other scalars
+	// are not folded. This is the default.
+	PreserveLineWidth = 0
+	// NeverFold writes every scalar on a single line, also scalars that are wrapped in the source.
+	NeverFold = -1
+)
+
+// SetLineWidth sets the width at which long scalars are folded on encode. Lines of plain, quoted and folded scalars
+// are broken at the first space after the width, PreserveLineWidth and NeverFold do not fold scalars.
+func (p *Patcher) SetLineWidth(width int) {
+	p.style.lineWidth = width
+}
+
+// SetLineWidth sets the width at which long scalars of all documents are folded on encode, see Patcher.SetLineWidth.
+func (d *Documents) SetLineWidth(width int) {
+	d.style.lineWidth = width
+}
+
+// wrappedScalar is a scalar in block context that spans multiple lines in the source (e.g. a long URL in double
+// quotes wrapped by hand) or is written in folded style, which the encoder writes on a single line.
+type wrappedScalar struct {
+	value       string
+	style       goyaml.Style
+	lineComment string
+	column      int
+	// lines of the scalar in the source, the first line starts at the column of the scalar
+	lines []string
+}
+
+// blockScalar is a scalar in block context with the indentation of its parent, continuation lines of the scalar
+// must be indented more.
+type blockScalar struct {
+	node         *goyaml.Node
+	parentIndent int
+	// source is the node at the same position of the tree the node was encoded from
+	source *goyaml.Node
+}
+
+// wrappedScalars returns the scalars of the nodes that are wrapped in the source.
+func wrappedScalars(src []byte, nodes ...*goyaml.Node) map[*goyaml.Node]wrappedScalar {
+	lines := strings.Split(string(src), "\n")
+	wrapped := make(map[*goyaml.Node]wrappedScalar)
+	for _, node := range nodes {
+		for _, s := range blockScalars(lines, node, nil) {
+			if !foldable(s.node.Style) {
+				continue
+			}
+			start, end := s.node.Line-1, scalarEnd(lines, s.node, s.parentIndent)
+			if end == start && s.node.Style&goyaml.FoldedStyle == 0 {
+				continue
+			}
+			scalarLines := append([]string{lines[start][s.node.Column-1:]}, lines[start+1:end+1]...)
+			wrapped[s.node] = wrappedScalar{
+				value:       s.node.Value,
+				style:       s.node.Style,
+				lineComment: s.node.LineComment,
+				column:      s.node.Column,
+				lines:       scalarLines,
+			}
+		}
+	}
+	return wrapped
+}
+
+// blockScalars returns the scalars that are values of block mappings or items of block sequences. If the node was
+// encoded from a source node, the scalars are returned with the scalars at the same position of the source.
+func blockScalars(lines []string, node *goyaml.Node, source *goyaml.Node) []blockScalar {
+	var scalars []blockScalar
+	var walk func(n, src *goyaml.Node)
+	walk = func(n, src *goyaml.Node) {
+		if src != nil && (src.Kind != n.Kind || len(src.Content) != len(n.Content)) {
+			src = nil
+		}
+		sourceAt := func(i int) *goyaml.Node {
+			if src == nil {
+				return nil
+			}
+			return src.Content[i]
+		}
+		switch {
+		case n.Kind == goyaml.MappingNode && n.Style&goyaml.FlowStyle == 0:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				if value.Kind == goyaml.ScalarNode {
+					scalars = append(scalars, blockScalar{node: value, parentIndent: key.Column - 1, source: sourceAt(i + 1)})
+				}
+			}
+		case n.Kind == goyaml.SequenceNode && n.Style&goyaml.FlowStyle == 0:
+			for i, item := range n.Content {
+				if item.Kind != goyaml.ScalarNode || item.Line > len(lines) {
+					continue
+				}
+				// The indentation of a sequence item is the column of its dash
+				line := lines[item.Line-1]
+				end := item.Column - 1
+				if end > len(line) {
+					end = len(line)
+				}
+				dash := strings.LastIndexFunc(line[:end], func(r rune) bool { return r != ' ' })
+				if dash == -1 || line[dash] != '-' {
+					continue
+				}
+				scalars = append(scalars, blockScalar{node: item, parentIndent: dash, source: sourceAt(i)})
+			}
+		}
+		for i, child := range n.Content {
+			walk(child, sourceAt(i))
+		}
+	}
+	walk(node, source)
+	return scalars
+}
+
+func foldable(style goyaml.Style) bool {
+	return style&(goyaml.TaggedStyle|goyaml.LiteralStyle|goyaml.FlowStyle) == 0
+}
+
+// scalarEnd returns the index of the last line of the scalar. Trailing blank lines of folded scalars are included,
+// since the encoder writes an additional blank line after them.
+func scalarEnd(lines []string, node *goyaml.Node, parentIndent int) int {
+	start := node.Line - 1
+	switch {
+	case node.Style&(goyaml.DoubleQuotedStyle|goyaml.SingleQuotedStyle) != 0:
+		quote := lines[start][node.Column-1]
+		for i := start; i < len(lines); i++ {
+			line := lines[i]
+			j := 0
+			if i == start {
+				j = node.Column
+			}
+			for ; j < len(line); j++ {
+				switch {
+				case quote == '"' && line[j] == '\\':
+					j++
+				case line[j] == quote && quote == '\'' && j+1 < len(line) && line[j+1] == '\'':
+					j++
+				case line[j] == quote:
+					return i
+				}
+			}
+		}
+		return start
+	case node.Style&goyaml.FoldedStyle != 0:
+		end := start
+		// The last line is empty if the source ends with a line break
+		for i := start + 1; i < len(lines) && !(i == len(lines)-1 && lines[i] == ""); i++ {
+			if strings.TrimSpace(lines[i]) != "" && indentOf(lines[i]) <= parentIndent {
+				break
+			}
+			end = i
+		}
+		return end
+	default:
+		end := start
+		// A comment ends a plain scalar
+		if strings.Contains(lines[start][node.Column-1:], " #") {
+			return start
+		}
+		for i := start + 1; i < len(lines); i++ {
+			text := strings.TrimSpace(lines[i])
+			if text == "" {
+				continue
+			}
+			if indentOf(lines[i]) <= parentIndent || text[0] == '#' {
+				break
+			}
+			end = i
+			if strings.Contains(lines[i], " #") {
+				break
+			}
+		}
+		return end
+	}
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// wrapScalars restores the line breaks of unchanged wrapped scalars or folds long scalars of the encoded nodes,
+// depending on the line width. The encoded YAML is returned unchanged if the values would change.
+func (s style) wrapScalars(data []byte, nodes ...*goyaml.Node) ([]byte, error) {
+	if s.lineWidth < 0 || (s.lineWidth == PreserveLineWidth && len(s.wrapped) == 0) {
+		return data, nil
+	}
+
+	encoded, err := decodeAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) != len(nodes) {
+		return data, nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	type replacement struct {
+		start, end int
+		lines      []string
+	}
+	var replacements []replacement
+	for i, node := range nodes {
+		for _, scalar := range blockScalars(lines, encoded[i], node) {
+			n := scalar.node
+			if !foldable(n.Style) {
+				continue
+			}
+			start, end := n.Line-1, scalarEnd(lines, n, scalar.parentIndent)
+			var wrapped []string
+			if s.lineWidth == PreserveLineWidth {
+				source := scalar.source
+				ws, ok := s.wrapped[source]
+				if source == nil || !ok || source.Value != ws.value || source.Style != ws.style || source.LineComment != ws.lineComment ||
+					n.Style != ws.style || n.Column != ws.column {
+					continue
+				}
+				wrapped = append([]string{lines[start][:n.Column-1] + ws.lines[0]}, ws.lines[1:]...)
+			} else {
+				wrapped = foldScalar(lines[start:end+1], n, scalar.parentIndent, s.indent, s.lineWidth)
+			}
+			if wrapped != nil {
+				replacements = append(replacements, replacement{start: start, end: end, lines: wrapped})
+			}
+		}
+	}
+	if len(replacements) == 0 {
+		return data, nil
+	}
+
+	// Replace from the end, so the line numbers of the remaining scalars stay valid
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start > replacements[j].start
+	})
+	for _, r := range replacements {
+		lines = append(lines[:r.start], append(r.lines, lines[r.end+1:]...)...)
+	}
+	wrapped := []byte(strings.Join(lines, "\n"))
+
+	rewrapped, err := decodeAll(wrapped)
+	if err != nil || len(rewrapped) != len(encoded) {
+		return data, nil
+	}
+	for i := range encoded {
+		if !sameValues(encoded[i], rewrapped[i]) {
+			return data, nil
+		}
+	}
+	return wrapped, nil
+}
+
+// foldScalar breaks long lines of an encoded plain, quoted or folded scalar at spaces, it returns nil if no line
+// is too long.
+func foldScalar(lines []string, node *goyaml.Node, parentIndent, indent, width int) []string {
+	var folded []string
+	changed := false
+	if node.Style&goyaml.FoldedStyle != 0 {
+		folded = append(folded, lines[0])
+		contentIndent := -1
+		for _, line := range lines[1:] {
+			if strings.TrimSpace(line) != "" && contentIndent == -1 {
+				contentIndent = indentOf(line)
+			}
+			// More indented lines are not folded
+			if len(line) <= width || strings.TrimSpace(line) == "" || indentOf(line) != contentIndent {
+				folded = append(folded, line)
+				continue
+			}
+			wrapped := foldLine(line, contentIndent+1, len(line), width, strings.Repeat(" ", contentIndent), node.Style)
+			changed = changed || len(wrapped) > 1
+			folded = append(folded, wrapped...)
+		}
+	} else {
+		if len(lines) != 1 || len(lines[0]) <= width {
+			return nil
+		}
+		line := lines[0]
+		to := len(line)
+		if node.LineComment != "" {
+			if i := strings.LastIndex(line, " "+node.LineComment); i != -1 {
+				to = i
+			}
+		}
+		continuationIndent := parentIndent + indent
+		if node.Column-1 <= continuationIndent {
+			continuationIndent = node.Column - 1
+		}
+		folded = foldLine(line, node.Column, to, width, strings.Repeat(" ", continuationIndent), node.Style)
+		changed = len(folded) > 1
+	}
+	if !changed {
+		return nil
+	}
+	return folded
+}
+
+// foldLine breaks the line at single spaces between from and to once the line is longer than the width, like the
+// encoder does. Continuation lines start with the indentation.
+func foldLine(line string, from, to, width int, indentation string, style goyaml.Style) []string {
+	var (
+		folded []string
+		start  int
+		prefix string
+	)
+	if from < 1 {
+		from = 1
+	}
+	for i := from; i < to-1; i++ {
+		if line[i] != ' ' || line[i-1] == ' ' || line[i+1] == ' ' {
+			continue
+		}
+		if style&goyaml.DoubleQuotedStyle != 0 && line[i-1] == '\\' {
+			continue
+		}
+		// Continuation lines of plain scalars must not start with an indicator
+		if style&(goyaml.DoubleQuotedStyle|goyaml.SingleQuotedStyle|goyaml.FoldedStyle) == 0 && strings.ContainsRune("#-?:,[]{}&*!|>'\"%@`", rune(line[i+1])) {
+			continue
+		}
+		if len(prefix)+i-start > width {
+			folded = append(folded, prefix+line[start:i])
+			start = i + 1
+			prefix = indentation
+		}
+	}
+	return append(folded, prefix+line[start:])
+}
+
+func decodeAll(data []byte) ([]*goyaml.Node, error) {
+	var nodes []*goyaml.Node
+	dec := goyaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node goyaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, &node)
+	}
+}
+
+// sameValues returns true if the nodes have the same structure and values.
+func sameValues(a, b *goyaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || a.ShortTag() != b.ShortTag() || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameValues(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
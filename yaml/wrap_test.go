@@ -0,0 +1,118 @@
+package yaml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/networkteam/vignet/yaml"
+)
+
+const wrappedYAML = `image:
+  tag: 0.1.0
+description: a long description that was wrapped by hand
+  to keep lines short # wrapped
+notes: >
+  folded text that is wrapped
+  in the source
+url: "https://example.com/a/long/url?with=query
+  &and=more"
+hosts:
+  - a host that is wrapped
+    by hand
+`
+
+func TestPatcher_EncodeKeepsWrappedScalars(t *testing.T) {
+	patcher, err := yaml.NewPatcher(strings.NewReader(wrappedYAML))
+	require.NoError(t, err)
+
+	require.NoError(t, patcher.SetField("image.tag", "0.2.0", false))
+	require.NoError(t, patcher.SetField("hosts[0]", "example.com", false))
+
+	var sb strings.Builder
+	require.NoError(t, patcher.Encode(&sb))
+	assert.Equal(t, `image:
+  tag: 0.2.0
+description: a long description that was wrapped by hand
+  to keep lines short # wrapped
+notes: >
+  folded text that is wrapped
+  in the source
+url: "https://example.com/a/long/url?with=query
+  &and=more"
+hosts:
+  - example.com
+`, sb.String())
+}
+
+func TestPatcher_SetLineWidth(t *testing.T) {
+	tests := []struct {
+		name         string
+		width        int
+		expectedYAML string
+	}{
+		{
+			name:  "never fold",
+			width: yaml.NeverFold,
+			expectedYAML: `image:
+  tag: 0.2.0
+description: a long description that was wrapped by hand to keep lines short # wrapped
+notes: >
+  folded text that is wrapped in the source
+
+url: "https://example.com/a/long/url?with=query &and=more"
+hosts:
+  - a host that is wrapped by hand
+`,
+		},
+		{
+			name:  "fold at width",
+			width: 30,
+			expectedYAML: `image:
+  tag: 0.2.0
+description: a long description
+  that was wrapped by hand to keep
+  lines short # wrapped
+notes: >
+  folded text that is wrapped in
+  the source
+
+url: "https://example.com/a/long/url?with=query
+  &and=more"
+hosts:
+  - a host that is wrapped by hand
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patcher, err := yaml.NewPatcher(strings.NewReader(wrappedYAML))
+			require.NoError(t, err)
+			patcher.SetLineWidth(tt.width)
+
+			require.NoError(t, patcher.SetField("image.tag", "0.2.0", false))
+
+			var sb strings.Builder
+			require.NoError(t, patcher.Encode(&sb))
+			assert.Equal(t, tt.expectedYAML, sb.String())
+		})
+	}
+}
+
+func TestDocuments_EncodeKeepsWrappedScalars(t *testing.T) {
+	input := `kind: ConfigMap
+data:
+  message: a message that is
+    wrapped by hand
+---
+kind: Secret
+`
+	docs, err := yaml.NewDocuments(strings.NewReader(input))
+	require.NoError(t, err)
+
+	var sb strings.Builder
+	require.NoError(t, docs.Encode(&sb))
+	assert.Equal(t, input, sb.String())
+}